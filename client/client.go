@@ -0,0 +1,612 @@
+// Package client connects Go programs and bots to the chat server over its
+// WebSocket protocol. A client reconnects with backoff when its connection
+// drops, returns to the room it was in, passes on the messages it missed
+// meanwhile and resends the messages the server hadn't acknowledged.
+package client
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// LobbyID is the room every connection starts in
+const LobbyID = "lobby"
+
+// defaultMaxFrameSize is the largest frame a server accepts by default;
+// larger frames end the connection
+const defaultMaxFrameSize = 512
+
+// maxPending is the most sent messages waiting to be acknowledged
+const maxPending = 100
+
+// Errors returned by the client
+var (
+	ErrNotConnected  = errors.New("client: not connected")
+	ErrFrameTooLarge = errors.New("client: frame too large")
+	ErrTooManyQueued = errors.New("client: too many unacknowledged messages")
+
+	// ErrRejected is returned when the server refuses the username or token
+	ErrRejected = errors.New("client: server rejected the credentials")
+)
+
+// Options configures a client
+type Options struct {
+	// Server address, such as http://localhost:8080 or ws://localhost:8080/ws
+	URL string
+
+	// Name to chat as without logging in; the server uses "Anonymous" when empty
+	Username string
+
+	// Session token to chat as a logged-in account instead
+	Token string
+
+	// Reconnect delays start around MinBackoff and double up to MaxBackoff;
+	// 500ms and 30s when zero
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Largest frame the server accepts, its CHAT_MAX_FRAME_SIZE; 512 when zero
+	MaxFrameSize int
+}
+
+// Client is a connection to the chat server. Set its callbacks before
+// calling Connect; they are called one at a time from the client's goroutine.
+type Client struct {
+	OnConnect       func()          // After connecting and after every reconnect
+	OnDisconnect    func(err error) // When the connection drops, before reconnecting
+	OnEvent         func(*Event)    // Every frame from the server
+	OnJoined        func(*RoomJoined)
+	OnMessage       func(*Message) // Room messages, including those missed while reconnecting
+	OnAck           func(*Ack)
+	OnDirectMessage func(*DirectMessage)
+	OnHistory       func(*HistoryPage)
+	OnError         func(*Error)
+
+	opts   Options
+	url    string
+	header http.Header
+
+	writeMutex sync.Mutex // Serializes writes, which the connection requires
+
+	mutex   sync.Mutex
+	conn    *websocket.Conn
+	room    string           // Room the server last confirmed joining
+	lastSeq map[string]int64 // Latest message sequence number accounted for, by room
+	resume  string           // Room to return to after reconnecting
+	catchUp *catchUp
+	pending []pendingMessage
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// catchUp tracks the messages of a room fetched after a reconnect
+type catchUp struct {
+	roomID    string
+	afterSeq  int64
+	delivered map[int64]bool // Messages already passed on, live or fetched
+}
+
+// pendingMessage is a sent message the server hasn't acknowledged yet
+type pendingMessage struct {
+	clientID string
+	roomID   string
+	frame    []byte
+}
+
+// New returns a client that isn't connected yet
+func New(opts Options) *Client {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 500 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(30*time.Second, opts.MinBackoff)
+	}
+	if opts.MaxFrameSize <= 0 {
+		opts.MaxFrameSize = defaultMaxFrameSize
+	}
+	return &Client{
+		opts:    opts,
+		lastSeq: make(map[string]int64),
+		done:    make(chan struct{}),
+	}
+}
+
+// Connect connects to the server and keeps the client connected until ctx
+// is cancelled or Close is called. Only the first attempt's error is
+// returned; later connections are retried with backoff.
+func (c *Client) Connect(ctx context.Context) error {
+	endpoint, err := endpointURL(c.opts)
+	if err != nil {
+		return err
+	}
+	c.url = endpoint
+	c.header = http.Header{}
+	if c.opts.Token != "" {
+		c.header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+
+	conn, _, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.connected(conn)
+	context.AfterFunc(ctx, c.closeConn)
+	go c.run(ctx, conn)
+	return nil
+}
+
+// Close disconnects the client and waits for its callbacks to finish, so it
+// must not be called from a callback
+func (c *Client) Close() error {
+	if c.cancel != nil {
+		c.cancel()
+		<-c.done
+	}
+	return nil
+}
+
+// Wait blocks until the client stops, returning why unless it was closed
+func (c *Client) Wait() error {
+	<-c.done
+	return c.err
+}
+
+// Room returns the room the client is in
+func (c *Client) Room() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.room
+}
+
+// Join moves the client to a room; OnJoined or OnError reports the outcome
+func (c *Client) Join(roomID string) error {
+	return c.write(map[string]interface{}{"type": "join", "roomId": roomID})
+}
+
+// Leave returns the client to the lobby
+func (c *Client) Leave() error {
+	return c.write(map[string]interface{}{"type": "leave"})
+}
+
+// Send posts a message to the client's room and returns the client message
+// ID its Ack will carry. Messages sent while disconnected, or not yet
+// acknowledged when the connection drops, are sent again after reconnecting;
+// the server posts each only once.
+func (c *Client) Send(content string) (string, error) {
+	clientID := newClientMessageID()
+	frame, err := json.Marshal(map[string]interface{}{
+		"type":            "message",
+		"content":         content,
+		"clientMessageId": clientID,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(frame) > c.opts.MaxFrameSize {
+		return "", ErrFrameTooLarge
+	}
+
+	c.mutex.Lock()
+	if c.room == "" {
+		c.mutex.Unlock()
+		return "", ErrNotConnected
+	}
+	if len(c.pending) >= maxPending {
+		c.mutex.Unlock()
+		return "", ErrTooManyQueued
+	}
+	c.pending = append(c.pending, pendingMessage{clientID: clientID, roomID: c.room, frame: frame})
+	live := c.conn != nil && c.resume == "" && c.catchUp == nil
+	c.mutex.Unlock()
+
+	// A failed write is retried after reconnecting
+	if live {
+		c.writeFrame(frame)
+	}
+	return clientID, nil
+}
+
+// SendDirect sends a private message to another user
+func (c *Client) SendDirect(to, content string) error {
+	return c.write(map[string]interface{}{"type": "dm", "to": to, "content": content})
+}
+
+// LoadMore asks for the page of the room's history before a message ID, or
+// the latest page when before is empty; OnHistory receives it. A limit of 0
+// uses the server's default.
+func (c *Client) LoadMore(before string, limit int) error {
+	return c.write(map[string]interface{}{"type": "load_more", "before": before, "limit": limit})
+}
+
+// SendFrame sends any other frame of the protocol, such as
+// {"type": "react", "messageId": "...", "emoji": "👍"}
+func (c *Client) SendFrame(frame interface{}) error {
+	return c.write(frame)
+}
+
+// run reads frames until the connection drops, then reconnects with backoff
+// until the client is closed or its credentials are rejected
+func (c *Client) run(ctx context.Context, conn *websocket.Conn) {
+	defer close(c.done)
+
+	for {
+		err := c.read(conn)
+		c.disconnected()
+		if ctx.Err() != nil {
+			return
+		}
+		if c.OnDisconnect != nil {
+			c.OnDisconnect(err)
+		}
+
+		backoff := c.opts.MinBackoff
+		for {
+			// Jitter keeps clients dropped together from reconnecting together
+			delay := backoff/2 + rand.N(backoff/2+1)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+
+			var retryAfter time.Duration
+			conn, retryAfter, err = c.dial(ctx)
+			if err == nil {
+				break
+			}
+			if errors.Is(err, ErrRejected) {
+				c.err = err
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			backoff = max(min(backoff*2, c.opts.MaxBackoff), retryAfter)
+		}
+
+		c.connected(conn)
+		if ctx.Err() != nil {
+			c.closeConn()
+		}
+	}
+}
+
+// dial opens a connection, returning how long an overloaded server asked
+// clients to wait before trying again
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, time.Duration, error) {
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, c.url, c.header)
+	if err == nil {
+		return conn, 0, nil
+	}
+	if resp == nil {
+		return nil, 0, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return nil, 0, ErrRejected
+	case http.StatusServiceUnavailable:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return nil, time.Duration(seconds) * time.Second, fmt.Errorf("client: server overloaded: %w", err)
+	default:
+		return nil, 0, fmt.Errorf("client: connecting failed with status %d: %w", resp.StatusCode, err)
+	}
+}
+
+// connected starts using a new connection and heads back to the room the
+// previous connection was in
+func (c *Client) connected(conn *websocket.Conn) {
+	c.mutex.Lock()
+	c.conn = conn
+	resume := c.resume
+	c.mutex.Unlock()
+
+	if c.OnConnect != nil {
+		c.OnConnect()
+	}
+
+	// The server puts every connection in the lobby first
+	if resume != "" && resume != LobbyID {
+		c.Join(resume)
+	}
+}
+
+// disconnected forgets a dropped connection, remembering its room
+func (c *Client) disconnected() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	if c.room != "" {
+		c.resume = c.room
+	}
+	c.catchUp = nil
+}
+
+// closeConn closes the current connection, ending its read loop
+func (c *Client) closeConn() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// read handles frames from a connection until it fails. The server may
+// write several frames in one WebSocket message, one per line.
+func (c *Client) read(conn *websocket.Conn) error {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		for _, frame := range bytes.Split(data, []byte{'\n'}) {
+			if len(frame) > 0 {
+				c.handle(frame)
+			}
+		}
+	}
+}
+
+// handle decodes a frame and passes it to the callbacks
+func (c *Client) handle(frame []byte) {
+	var event Event
+	if err := json.Unmarshal(frame, &event); err != nil {
+		return
+	}
+	event.Data = frame
+
+	switch event.Type {
+	case "room_joined":
+		var joined RoomJoined
+		if json.Unmarshal(frame, &joined) == nil {
+			c.joined(&joined)
+		}
+
+	case "message":
+		var msg Message
+		if json.Unmarshal(frame, &msg) == nil {
+			c.received(&msg)
+		}
+
+	case "message_ack":
+		var ack Ack
+		if json.Unmarshal(frame, &ack) == nil {
+			c.acked(&ack)
+		}
+
+	case "dm":
+		var dm DirectMessage
+		if json.Unmarshal(frame, &dm) == nil && c.OnDirectMessage != nil {
+			c.OnDirectMessage(&dm)
+		}
+
+	case "history_page":
+		var page catchUpPage
+		if json.Unmarshal(frame, &page) == nil && page.AfterSeq != nil && c.caughtUp(&page) {
+			break
+		}
+		var history HistoryPage
+		if json.Unmarshal(frame, &history) == nil && c.OnHistory != nil {
+			c.OnHistory(&history)
+		}
+
+	case "room_error", "permission_error", "dm_error":
+		var refused Error
+		if json.Unmarshal(frame, &refused) == nil && c.OnError != nil {
+			c.OnError(&refused)
+		}
+	}
+
+	if c.OnEvent != nil {
+		c.OnEvent(&event)
+	}
+}
+
+// joined records the room the client is in. Returning to a room after a
+// reconnect fetches the messages missed meanwhile before resending the
+// unacknowledged ones.
+func (c *Client) joined(joined *RoomJoined) {
+	c.mutex.Lock()
+	c.room = joined.RoomID
+	resuming := c.resume == joined.RoomID
+	if resuming {
+		c.resume = ""
+	}
+
+	seen, known := c.lastSeq[joined.RoomID]
+	var resend [][]byte
+	if resuming && known && seen < joined.LastSeq {
+		c.catchUp = &catchUp{roomID: joined.RoomID, afterSeq: seen, delivered: make(map[int64]bool)}
+	} else {
+		c.lastSeq[joined.RoomID] = max(seen, joined.LastSeq)
+		if resuming {
+			resend = c.takePending(joined.RoomID)
+		} else if c.resume == "" {
+			// Messages can only be resent to the room they were meant for
+			c.takePending("")
+		}
+	}
+	catchUp := c.catchUp
+	c.mutex.Unlock()
+
+	if c.OnJoined != nil {
+		c.OnJoined(joined)
+	}
+	if catchUp != nil && catchUp.roomID == joined.RoomID {
+		c.write(map[string]interface{}{"type": "load_more", "afterSeq": catchUp.afterSeq})
+	}
+	for _, frame := range resend {
+		c.writeFrame(frame)
+	}
+}
+
+// received passes on a live room message
+func (c *Client) received(msg *Message) {
+	c.mutex.Lock()
+	if msg.Seq > 0 {
+		if c.catchUp != nil && c.catchUp.roomID == msg.RoomID {
+			c.catchUp.delivered[msg.Seq] = true
+		}
+		c.lastSeq[msg.RoomID] = max(c.lastSeq[msg.RoomID], msg.Seq)
+	}
+	c.mutex.Unlock()
+
+	if c.OnMessage != nil {
+		c.OnMessage(msg)
+	}
+}
+
+// caughtUp passes on the missed messages in a page fetched after a
+// reconnect and asks for the next page, reporting whether the page was one
+func (c *Client) caughtUp(page *catchUpPage) bool {
+	c.mutex.Lock()
+	catchUp := c.catchUp
+	if catchUp == nil || catchUp.roomID != page.RoomID {
+		c.mutex.Unlock()
+		return false
+	}
+
+	var missed []Message
+	for _, msg := range page.Messages {
+		catchUp.afterSeq = max(catchUp.afterSeq, msg.Seq)
+		c.lastSeq[msg.RoomID] = max(c.lastSeq[msg.RoomID], msg.Seq)
+		if !catchUp.delivered[msg.Seq] {
+			catchUp.delivered[msg.Seq] = true
+			missed = append(missed, msg)
+		}
+	}
+
+	var resend [][]byte
+	finished := !page.HasMore || len(page.Messages) == 0
+	if finished {
+		c.catchUp = nil
+		resend = c.takePending(page.RoomID)
+	}
+	c.mutex.Unlock()
+
+	if c.OnMessage != nil {
+		for i := range missed {
+			c.OnMessage(&missed[i])
+		}
+	}
+	if !finished {
+		c.write(map[string]interface{}{"type": "load_more", "afterSeq": catchUp.afterSeq})
+	}
+	for _, frame := range resend {
+		c.writeFrame(frame)
+	}
+	return true
+}
+
+// takePending returns the frames of the unacknowledged messages for a room
+// to send again, dropping those for other rooms. The caller must hold the mutex.
+func (c *Client) takePending(roomID string) [][]byte {
+	var frames [][]byte
+	kept := c.pending[:0]
+	for _, msg := range c.pending {
+		if msg.roomID == roomID {
+			frames = append(frames, msg.frame)
+			kept = append(kept, msg)
+		}
+	}
+	c.pending = kept
+	return frames
+}
+
+// acked stops resending an acknowledged message
+func (c *Client) acked(ack *Ack) {
+	c.mutex.Lock()
+	for i, msg := range c.pending {
+		if msg.clientID == ack.ClientMessageID {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			break
+		}
+	}
+	c.mutex.Unlock()
+
+	if c.OnAck != nil {
+		c.OnAck(ack)
+	}
+}
+
+// write encodes and sends a frame
+func (c *Client) write(frame interface{}) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	if len(data) > c.opts.MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	return c.writeFrame(data)
+}
+
+// writeFrame sends an encoded frame on the current connection
+func (c *Client) writeFrame(frame []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.mutex.Lock()
+	conn := c.conn
+	c.mutex.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return conn.WriteMessage(websocket.TextMessage, frame)
+}
+
+// endpointURL returns the WebSocket URL to connect to
+func endpointURL(opts Options) (string, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return "", fmt.Errorf("client: invalid URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("client: URL must be http, https, ws or wss")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/ws"
+	}
+	if opts.Username != "" {
+		query := u.Query()
+		query.Set("username", opts.Username)
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), nil
+}
+
+// newClientMessageID returns a random ID for a sent message
+func newClientMessageID() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
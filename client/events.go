@@ -0,0 +1,112 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is a frame received from the server. Every frame is passed to
+// OnEvent, including those also passed to a typed callback.
+type Event struct {
+	Type   string          `json:"type"`
+	RoomID string          `json:"roomId,omitempty"`
+	Data   json.RawMessage `json:"-"` // The whole frame
+}
+
+// Message is a chat message, received live or from a room's history
+type Message struct {
+	ID        string              `json:"id"`
+	RoomID    string              `json:"roomId"`
+	Seq       int64               `json:"seq"` // Increases by one with every message posted in the room
+	Username  string              `json:"username"`
+	Content   string              `json:"content"`
+	Timestamp time.Time           `json:"timestamp"`
+	Bot       bool                `json:"bot,omitempty"`
+	EditedAt  *time.Time          `json:"editedAt,omitempty"`
+	Deleted   bool                `json:"deleted,omitempty"`
+	ExpiresAt *time.Time          `json:"expiresAt,omitempty"`
+	Reactions map[string][]string `json:"reactions,omitempty"` // Emoji to usernames, in reaction order
+	Emoji     map[string]string   `json:"emoji,omitempty"`     // Image URLs of the custom emoji used, by shortcode
+
+	// ID the sender's client gave the message, if any
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+}
+
+// Status is a user's presence status
+type Status struct {
+	State string `json:"state"` // "available", "away" or "busy"
+	Text  string `json:"text,omitempty"`
+	Emoji string `json:"emoji,omitempty"`
+}
+
+// Member is a user in a room with their presence status
+type Member struct {
+	Username string `json:"username"`
+	Status   Status `json:"status"`
+}
+
+// RoomJoined reports that the client joined a room; every connection starts
+// in the lobby
+type RoomJoined struct {
+	RoomID      string   `json:"roomId"`
+	RoomName    string   `json:"roomName"`
+	Mode        string   `json:"mode"`
+	Topic       string   `json:"topic"`
+	Description string   `json:"description"`
+	MessageTTL  int      `json:"messageTtl"` // Seconds before messages disappear; 0 keeps them
+	CanPost     bool     `json:"canPost"`
+	Role        string   `json:"role"`
+	Members     []Member `json:"members"`
+	LastSeq     int64    `json:"lastSeq"` // Sequence number of the room's latest message
+	Muted       bool     `json:"muted,omitempty"`
+}
+
+// Ack acknowledges a message sent with Send. Duplicate is set when the
+// server had already posted a message with its client message ID, such as
+// one resent after a reconnect.
+type Ack struct {
+	ClientMessageID string    `json:"clientMessageId"`
+	ID              string    `json:"id"`
+	Seq             int64     `json:"seq"`
+	RoomID          string    `json:"roomId"`
+	Duplicate       bool      `json:"duplicate"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// DirectMessage is a private message to or from another user
+type DirectMessage struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// HistoryPage is a page of a room's history requested with LoadMore
+type HistoryPage struct {
+	RoomID   string    `json:"roomId"`
+	Messages []Message `json:"messages"`
+	HasMore  bool      `json:"hasMore"`
+	Before   string    `json:"before,omitempty"`
+	After    string    `json:"after,omitempty"`
+}
+
+// Error is a request the server refused: a "room_error",
+// "permission_error" or "dm_error" frame
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	To      string `json:"to,omitempty"` // Recipient of a direct message that failed
+}
+
+// Error returns the server's message
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// catchUpPage is the reply to a load_more frame with afterSeq
+type catchUpPage struct {
+	RoomID   string    `json:"roomId"`
+	Messages []Message `json:"messages"`
+	HasMore  bool      `json:"hasMore"`
+	AfterSeq *int64    `json:"afterSeq"`
+}
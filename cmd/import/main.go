@@ -0,0 +1,111 @@
+// Command import brings the history of a community moving from Slack or
+// Discord into the server's storage: each channel of a Slack export zip or of
+// DiscordChatExporter JSON files becomes a room with its messages, authors
+// and times. It opens the storage CHAT_* variables configure, as the server
+// does, and must run while the server is stopped.
+//
+//	go run ./cmd/import [-workspace id] [-users users.json] slack-export.zip
+//	go run ./cmd/import [-workspace id] [-users users.json] channel.json...
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/importer"
+	"realtime-chat/internal/ledger"
+	"realtime-chat/internal/store"
+	"strings"
+)
+
+func main() {
+	workspace := flag.String("workspace", "", "workspace to create the rooms in; the server's own rooms when unset")
+	usersFile := flag.String("users", "", "JSON object mapping the export's user names to local usernames")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: import [-workspace id] [-users users.json] slack-export.zip | channel.json...")
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+
+	var usernames map[string]string
+	if *usersFile != "" {
+		data, err := os.ReadFile(*usersFile)
+		if err != nil {
+			log.Fatalf("Error reading users: %v", err)
+		}
+		if err := json.Unmarshal(data, &usernames); err != nil {
+			log.Fatalf("Error reading users: %v", err)
+		}
+	}
+
+	var export *importer.Export
+	if strings.EqualFold(filepath.Ext(flag.Arg(0)), ".zip") {
+		if flag.NArg() != 1 {
+			log.Fatalf("Error reading export: import one Slack export at a time")
+		}
+		export, err = importer.ReadSlack(flag.Arg(0))
+	} else {
+		export, err = importer.ReadDiscord(flag.Args()...)
+	}
+	if err != nil {
+		log.Fatalf("Error reading export: %v", err)
+	}
+
+	// Open the storage the server uses, encrypting content as it would
+	var (
+		st       store.Store
+		snapshot *store.MemoryStore
+		database *store.BoltStore
+	)
+	switch cfg.Storage.Backend {
+	case config.StorageMemory:
+		snapshot, err = store.NewMemoryStore(filepath.Join(cfg.DataDir, "snapshot.json"), cfg.Storage.SnapshotHistory)
+		st = snapshot
+	case config.StorageBolt:
+		database, err = store.NewBoltStore(filepath.Join(cfg.DataDir, "chat.db"))
+		st = database
+	default:
+		st, err = store.NewFileStore(cfg.DataDir)
+	}
+	if err != nil {
+		log.Fatalf("Error opening storage: %v", err)
+	}
+	if st, err = store.NewEncrypted(st, cfg.Storage.EncryptionKey, cfg.Storage.PreviousKeys); err != nil {
+		log.Fatalf("Error opening storage: %v", err)
+	}
+
+	report, err := importer.Import(st, ledger.New(cfg.HistoryKey), export, importer.Options{
+		Workspace: *workspace,
+		Users:     usernames,
+	})
+
+	// Keep what was written before any failure
+	if snapshot != nil {
+		if err := snapshot.Snapshot(); err != nil {
+			log.Fatalf("Error writing snapshot: %v", err)
+		}
+	}
+	if database != nil {
+		if err := database.Close(); err != nil {
+			log.Fatalf("Error closing database: %v", err)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Error importing %s export: %v", export.Source, err)
+	}
+
+	fmt.Printf("Imported %d rooms with %d messages and %d new users from %s\n",
+		report.Rooms, report.Messages, report.Profiles, export.Source)
+	if report.Skipped > 0 {
+		fmt.Printf("Skipped %d channels imported before\n", report.Skipped)
+	}
+}
@@ -0,0 +1,382 @@
+// Command loadgen load-tests a running chat server: it connects simulated
+// WebSocket clients spread across rooms, has each post messages at a steady
+// rate, and reports delivery latency percentiles and the share of expected
+// deliveries that never arrived.
+//
+//	go run ./cmd/loadgen [-url ws://localhost:8080/ws] [-clients 100] [-rooms 10] [-rate 1] [-duration 30s]
+//
+// The hub's and rooms' broadcast paths are benchmarked in-process by their
+// packages' benchmarks instead.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// marker starts the content of every message loadgen posts, followed by the
+// time it was sent in Unix nanoseconds
+const marker = "loadgen "
+
+// setupTimeout bounds how long creating or joining a room may take
+const setupTimeout = 10 * time.Second
+
+// connectConcurrency is the most clients connecting at once
+const connectConcurrency = 32
+
+// options are the parameters of a load test
+type options struct {
+	url      string
+	clients  int
+	rooms    int
+	rate     float64
+	duration time.Duration
+	settle   time.Duration
+	size     int
+	prefix   string
+}
+
+// client is one simulated connection
+type client struct {
+	id   int
+	name string
+	conn *websocket.Conn
+	room int
+
+	// Replies to creating and joining rooms
+	frames chan frame
+
+	// Written by the client's reader only, read once the test has ended
+	latencies []time.Duration
+	received  int
+
+	sent atomic.Int64
+
+	// Closed once the client's reader has returned
+	done chan struct{}
+}
+
+// frame is the part of a server frame loadgen looks at
+type frame struct {
+	Type    string `json:"type"`
+	RoomID  string `json:"roomId"`
+	Content string `json:"content"`
+	Message string `json:"message"`
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.url, "url", "ws://localhost:8080/ws", "WebSocket endpoint of the server")
+	flag.IntVar(&opts.clients, "clients", 100, "simulated clients")
+	flag.IntVar(&opts.rooms, "rooms", 10, "rooms the clients are spread across")
+	flag.Float64Var(&opts.rate, "rate", 1, "messages per second each client posts")
+	flag.DurationVar(&opts.duration, "duration", 30*time.Second, "how long clients post messages")
+	flag.DurationVar(&opts.settle, "settle", 2*time.Second, "how long to wait for deliveries after posting stops")
+	flag.IntVar(&opts.size, "size", 0, "bytes of padding added to each message; frames must stay within the server's CHAT_MAX_FRAME_SIZE")
+	flag.StringVar(&opts.prefix, "prefix", "loadgen", "prefix of the simulated usernames and room names")
+	flag.Parse()
+
+	if opts.clients <= 0 || opts.rooms <= 0 || opts.rooms > opts.clients || opts.rate <= 0 {
+		fmt.Fprintln(os.Stderr, "-clients must be a positive number of at least -rooms, and -rooms and -rate positive")
+		os.Exit(2)
+	}
+
+	if err := run(opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run connects the clients, has them post for the test's duration and
+// prints the report
+func run(opts options) error {
+	clients := make([]*client, opts.clients)
+	for i := range clients {
+		clients[i] = &client{
+			id:     i,
+			name:   fmt.Sprintf("%s-%d", opts.prefix, i),
+			room:   i % opts.rooms,
+			frames: make(chan frame, 64),
+			done:   make(chan struct{}),
+		}
+	}
+	defer func() {
+		for _, c := range clients {
+			if c.conn != nil {
+				c.conn.Close()
+			}
+		}
+	}()
+
+	// The first client of each room creates it, then the rest join
+	start := time.Now()
+	roomIDs := make([]string, opts.rooms)
+	if err := setup(clients[:opts.rooms], func(c *client) error {
+		id, err := c.createRoom(fmt.Sprintf("%s-%d", opts.prefix, c.room))
+		roomIDs[c.room] = id
+		return err
+	}, opts.url); err != nil {
+		return err
+	}
+	if err := setup(clients[opts.rooms:], func(c *client) error {
+		return c.joinRoom(roomIDs[c.room])
+	}, opts.url); err != nil {
+		return err
+	}
+	fmt.Printf("Connected %d clients to %d rooms in %v\n", opts.clients, opts.rooms, time.Since(start).Round(time.Millisecond))
+
+	// Post until the duration is up, then give the last deliveries time to arrive
+	fmt.Printf("Posting %g messages/s per client for %v\n", opts.rate, opts.duration)
+	padding := strings.Repeat("x", opts.size)
+	interval := time.Duration(float64(time.Second) / opts.rate)
+	stop := make(chan struct{})
+	var posting sync.WaitGroup
+	for _, c := range clients {
+		// Clients start at offsets spread over the first interval so their
+		// messages don't arrive in bursts
+		offset := interval * time.Duration(c.id) / time.Duration(len(clients))
+		posting.Add(1)
+		go func() {
+			defer posting.Done()
+			c.post(interval, offset, padding, stop)
+		}()
+	}
+	time.Sleep(opts.duration)
+	close(stop)
+	posting.Wait()
+	time.Sleep(opts.settle)
+
+	disconnected := 0
+	for _, c := range clients {
+		select {
+		case <-c.done:
+			disconnected++
+		default:
+		}
+		c.conn.Close()
+	}
+	for _, c := range clients {
+		<-c.done
+	}
+	report(clients, opts)
+	if disconnected > 0 {
+		fmt.Printf("\n%d clients were disconnected during the test\n", disconnected)
+	}
+	return nil
+}
+
+// setup connects clients with limited concurrency and runs prepare for each,
+// stopping at the first failure
+func setup(clients []*client, prepare func(*client) error, url string) error {
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, connectConcurrency)
+	for _, c := range clients {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			err := c.connect(url)
+			if err == nil {
+				err = prepare(c)
+			}
+			if err != nil {
+				mutex.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("client %s: %w", c.name, err)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// connect opens the client's connection and starts reading from it
+func (c *client) connect(url string) error {
+	conn, resp, err := websocket.DefaultDialer.Dial(url+"?username="+c.name, nil)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("connecting: %w (HTTP %d)", err, resp.StatusCode)
+		}
+		return fmt.Errorf("connecting: %w", err)
+	}
+	c.conn = conn
+	go c.read()
+	return nil
+}
+
+// read records the latency of every loadgen message the client receives
+// and passes replies to setup, until the connection closes
+func (c *client) read() {
+	defer close(c.done)
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		received := time.Now()
+
+		// Frames queued together arrive in one message, a line each
+		for _, line := range bytes.Split(data, []byte{'\n'}) {
+			var f frame
+			if json.Unmarshal(line, &f) == nil {
+				c.handle(f, received)
+			}
+		}
+	}
+}
+
+// handle records the latency of a loadgen message received at received, or
+// passes a reply to setup
+func (c *client) handle(f frame, received time.Time) {
+	if f.Type == "message" && strings.HasPrefix(f.Content, marker) {
+		sent, err := strconv.ParseInt(strings.Fields(f.Content[len(marker):])[0], 10, 64)
+		if err == nil {
+			c.latencies = append(c.latencies, received.Sub(time.Unix(0, sent)))
+			c.received++
+		}
+		return
+	}
+
+	// Only replies to setup are kept, not the notices of the lobby
+	if f.Type != "room_created" && f.Type != "room_joined" && !strings.HasSuffix(f.Type, "error") {
+		return
+	}
+	select {
+	case c.frames <- f:
+	default:
+	}
+}
+
+// createRoom creates a room, which the server joins the client to, and
+// returns its ID
+func (c *client) createRoom(name string) (string, error) {
+	if err := c.conn.WriteJSON(map[string]string{"type": "create", "roomName": name}); err != nil {
+		return "", err
+	}
+	created, err := c.await("room_created", "")
+	if err != nil {
+		return "", err
+	}
+	_, err = c.await("room_joined", created.RoomID)
+	return created.RoomID, err
+}
+
+// joinRoom joins the client to a room
+func (c *client) joinRoom(roomID string) error {
+	if err := c.conn.WriteJSON(map[string]string{"type": "join", "roomId": roomID}); err != nil {
+		return err
+	}
+	_, err := c.await("room_joined", roomID)
+	return err
+}
+
+// await waits for a frame of the given type, and room unless roomID is
+// empty, failing on an error frame
+func (c *client) await(frameType, roomID string) (frame, error) {
+	timeout := time.After(setupTimeout)
+	for {
+		select {
+		case f := <-c.frames:
+			if f.Type == frameType && (roomID == "" || f.RoomID == roomID) {
+				return f, nil
+			}
+			if strings.HasSuffix(f.Type, "error") {
+				return f, fmt.Errorf("%s: %s", f.Type, f.Message)
+			}
+		case <-timeout:
+			return frame{}, fmt.Errorf("no %s frame within %v", frameType, setupTimeout)
+		}
+	}
+}
+
+// post sends a message every interval, starting after offset, until stop
+// is closed or the connection fails
+func (c *client) post(interval, offset time.Duration, padding string, stop chan struct{}) {
+	select {
+	case <-time.After(offset):
+	case <-stop:
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		content := marker + strconv.FormatInt(time.Now().UnixNano(), 10) + " " + padding
+		if err := c.conn.WriteJSON(map[string]string{"type": "message", "content": content}); err != nil {
+			return
+		}
+		c.sent.Add(1)
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// report prints delivery counts, the drop rate and latency percentiles
+func report(clients []*client, opts options) {
+	sentPerRoom := make([]int64, opts.rooms)
+	members := make([]int64, opts.rooms)
+	var (
+		sent, received int
+		latencies      []time.Duration
+	)
+	for _, c := range clients {
+		sentPerRoom[c.room] += c.sent.Load()
+		members[c.room]++
+		sent += int(c.sent.Load())
+		received += c.received
+		latencies = append(latencies, c.latencies...)
+	}
+
+	// Every member of a room, the sender included, receives each of its messages
+	var expected int64
+	for room := range sentPerRoom {
+		expected += sentPerRoom[room] * members[room]
+	}
+
+	fmt.Printf("\nSent %d messages, %.1f/s\n", sent, float64(sent)/opts.duration.Seconds())
+	fmt.Printf("Received %d of %d expected deliveries, %.1f/s\n", received, expected, float64(received)/opts.duration.Seconds())
+	if expected > 0 {
+		fmt.Printf("Dropped %.3f%%\n", 100*(1-float64(received)/float64(expected)))
+	}
+	if len(latencies) == 0 {
+		return
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Println("\nLatency")
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		fmt.Printf("  p%-5g %v\n", p, percentile(latencies, p))
+	}
+	fmt.Printf("  max    %v\n", latencies[len(latencies)-1])
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
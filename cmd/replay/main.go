@@ -0,0 +1,71 @@
+// Command replay re-executes a recording made with CHAT_RECORD_FILE against a
+// fresh hub and reports where the frames delivered to clients differ from the
+// recorded ones.
+//
+//	go run ./cmd/replay [-settle 20ms] [-v] recording.jsonl
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/websocket"
+	"time"
+)
+
+func main() {
+	settle := flag.Duration("settle", 20*time.Millisecond, "how long each step waits for deliveries to stop")
+	verbose := flag.Bool("v", false, "print the full report as JSON")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: replay [-settle duration] [-v] recording.jsonl")
+		os.Exit(2)
+	}
+
+	events, err := replay.Load(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Error loading recording: %v", err)
+	}
+
+	// Replay against an empty store so earlier runs can't affect the result
+	dir, err := os.MkdirTemp("", "chat-replay")
+	if err != nil {
+		log.Fatalf("Error creating data directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	st, err := store.NewMemoryStore(filepath.Join(dir, "snapshot.json"), 0)
+	if err != nil {
+		log.Fatalf("Error opening storage: %v", err)
+	}
+
+	replay.ReplayIDs(events)
+	cfg := config.Default()
+	cfg.DataDir = dir
+	h := hub.NewHub(context.Background(), cfg, st)
+	go h.Run()
+
+	report := websocket.Replay(h, events, *settle)
+	h.Stop()
+
+	if *verbose {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+	}
+	fmt.Printf("Replayed %d inputs: %d frames recorded, %d delivered, %d mismatches\n",
+		report.Inputs, report.Recorded, report.Replayed, len(report.Mismatches))
+	for _, m := range report.Mismatches {
+		fmt.Printf("\nclient %s (%s) frame %d\n  recorded: %s\n  replayed: %s\n",
+			m.Client, m.Channel, m.Index, m.Recorded, m.Replayed)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
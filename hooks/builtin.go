@@ -0,0 +1,82 @@
+package hooks
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// ErrBlocked is returned by Block for a message containing a blocked word
+var ErrBlocked = errors.New("Your message contains a blocked word")
+
+// Log registers hooks that log every connection, message, room join and
+// disconnection to logger, or to the standard logger when it is nil
+func Log(r *Registry, logger *log.Logger) {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	r.OnConnect(func(e *Connect) error {
+		logger.Printf("hook: %s connected as %s over %s from %s", e.ClientID, e.Username, e.Transport, e.RemoteAddr)
+		return nil
+	})
+	r.OnMessage(func(e *Message) error {
+		switch e.Kind {
+		case KindDirect:
+			logger.Printf("hook: %s sent a direct message to %s (%d bytes)", e.Username, e.To, len(e.Content))
+		case KindEdit:
+			logger.Printf("hook: %s edited %s in %s (%d bytes)", e.Username, e.MessageID, e.RoomID, len(e.Content))
+		default:
+			logger.Printf("hook: %s posted in %s (%d bytes)", e.Username, e.RoomID, len(e.Content))
+		}
+		return nil
+	})
+	r.OnJoinRoom(func(e *JoinRoom) error {
+		logger.Printf("hook: %s joining %s", e.Username, e.RoomID)
+		return nil
+	})
+	r.OnDisconnect(func(e *Disconnect) {
+		logger.Printf("hook: %s (%s) disconnected", e.ClientID, e.Username)
+	})
+}
+
+// Block returns a message hook rejecting messages that contain any of words
+// as a whole word, ignoring case
+func Block(words ...string) func(*Message) error {
+	pattern := wordPattern(words)
+	return func(e *Message) error {
+		if pattern != nil && pattern.MatchString(e.Content) {
+			return ErrBlocked
+		}
+		return nil
+	}
+}
+
+// Censor returns a message hook replacing each of words, as a whole word and
+// ignoring case, with asterisks
+func Censor(words ...string) func(*Message) error {
+	pattern := wordPattern(words)
+	return func(e *Message) error {
+		if pattern != nil {
+			e.Content = pattern.ReplaceAllStringFunc(e.Content, func(word string) string {
+				return strings.Repeat("*", len([]rune(word)))
+			})
+		}
+		return nil
+	}
+}
+
+// wordPattern matches any of words as a whole word, ignoring case, or is nil without words
+func wordPattern(words []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
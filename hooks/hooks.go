@@ -0,0 +1,155 @@
+// Package hooks lets operators attach Go middleware to chat events. Hooks
+// run in the order they were registered; each can inspect and change the
+// event, and a hook returning an error rejects it, skipping the hooks after
+// it. Hooks run on the goroutine of the client that caused the event, so
+// they must be safe for concurrent use and should return quickly.
+package hooks
+
+import "sync"
+
+// Transports clients connect over
+const (
+	TransportWebSocket  = "websocket"
+	TransportGRPC       = "grpc"
+	TransportMQTT       = "mqtt"
+	TransportFederation = "federation"
+)
+
+// Kinds of messages passed to message hooks
+const (
+	KindMessage = "message" // A message posted in a room
+	KindEdit    = "edit"    // A new version of a message's content
+	KindDirect  = "dm"      // A direct message to another user
+)
+
+// Connect is a client connecting. Changing Username makes the client chat
+// under that name; rejecting refuses the connection.
+type Connect struct {
+	ClientID   string
+	Username   string
+	RemoteAddr string
+	Transport  string // TransportWebSocket, TransportGRPC, TransportMQTT or TransportFederation
+}
+
+// Message is a message being sent. Changing Content changes what is posted;
+// rejecting drops the message and tells the sender why.
+type Message struct {
+	Kind      string // KindMessage, KindEdit or KindDirect
+	ClientID  string
+	Username  string
+	RoomID    string // Room of a message or edit
+	MessageID string // Message being edited
+	To        string // Recipient of a direct message
+	Content   string
+}
+
+// JoinRoom is a client joining a room; every connection joins the lobby
+// first. Changing RoomID sends the client to another room; rejecting keeps
+// it where it is.
+type JoinRoom struct {
+	ClientID string
+	Username string
+	RoomID   string
+}
+
+// Disconnect is a client going away
+type Disconnect struct {
+	ClientID string
+	Username string
+	RoomID   string // Room the client was in, if any
+}
+
+// Registry holds the hooks of each event
+type Registry struct {
+	mutex      sync.RWMutex
+	connect    []func(*Connect) error
+	message    []func(*Message) error
+	joinRoom   []func(*JoinRoom) error
+	disconnect []func(*Disconnect)
+}
+
+// New creates an empty registry
+func New() *Registry {
+	return &Registry{}
+}
+
+// OnConnect adds a hook run before a client is admitted
+func (r *Registry) OnConnect(hook func(*Connect) error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.connect = append(r.connect, hook)
+}
+
+// OnMessage adds a hook run before a room message, edit or direct message is sent
+func (r *Registry) OnMessage(hook func(*Message) error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.message = append(r.message, hook)
+}
+
+// OnJoinRoom adds a hook run before a client joins a room
+func (r *Registry) OnJoinRoom(hook func(*JoinRoom) error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.joinRoom = append(r.joinRoom, hook)
+}
+
+// OnDisconnect adds a hook run after a client goes away
+func (r *Registry) OnDisconnect(hook func(*Disconnect)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.disconnect = append(r.disconnect, hook)
+}
+
+// Connect runs the connect hooks, returning the error of the one that rejected the connection
+func (r *Registry) Connect(event *Connect) error {
+	r.mutex.RLock()
+	hooks := r.connect
+	r.mutex.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Message runs the message hooks, returning the error of the one that rejected the message
+func (r *Registry) Message(event *Message) error {
+	r.mutex.RLock()
+	hooks := r.message
+	r.mutex.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JoinRoom runs the join hooks, returning the error of the one that rejected the join
+func (r *Registry) JoinRoom(event *JoinRoom) error {
+	r.mutex.RLock()
+	hooks := r.joinRoom
+	r.mutex.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Disconnect runs the disconnect hooks
+func (r *Registry) Disconnect(event *Disconnect) {
+	r.mutex.RLock()
+	hooks := r.disconnect
+	r.mutex.RUnlock()
+
+	for _, hook := range hooks {
+		hook(event)
+	}
+}
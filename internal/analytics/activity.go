@@ -0,0 +1,53 @@
+package analytics
+
+import (
+	"realtime-chat/internal/store"
+	"time"
+)
+
+// MaxActivityRange is the longest range an activity histogram covers
+const MaxActivityRange = 31 * 24 * time.Hour
+
+// Activity is a histogram of the activity in a room, or in a workspace as a
+// whole, hour by hour
+type Activity struct {
+	RoomID string    `json:"roomId,omitempty"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+
+	// Every hour of the range, oldest first, including those without activity
+	Hours []HourStats `json:"hours"`
+
+	// Messages posted in the range by day of the week (Sunday first) and
+	// hour of the day, in UTC
+	Heatmap [7][24]int `json:"heatmap"`
+}
+
+// HourStats is an hour's activity
+type HourStats struct {
+	Hour            time.Time `json:"hour"`
+	Messages        int       `json:"messages"`
+	ActiveUsers     int       `json:"activeUsers"`
+	PeakConnections int       `json:"peakConnections"`
+}
+
+// Activity returns the hour by hour activity f selects
+func (a *Analytics) Activity(f Filter) *Activity {
+	since, until := f.bounds()
+	activity := &Activity{RoomID: f.RoomID, Since: since.UTC(), Until: until.UTC(), Hours: []HourStats{}}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	for t := since.UTC().Truncate(time.Hour); t.Before(until); t = t.Add(time.Hour) {
+		stats := HourStats{Hour: t}
+		key := (&store.StatsRecord{Workspace: f.Workspace, RoomID: f.RoomID, Hour: t}).Key()
+		if h, ok := a.hours[key]; ok {
+			stats.Messages = h.record.Messages
+			stats.ActiveUsers = len(h.users)
+			stats.PeakConnections = h.record.PeakConnections
+		}
+		activity.Hours = append(activity.Hours, stats)
+		activity.Heatmap[t.Weekday()][t.Hour()] += stats.Messages
+	}
+	return activity
+}
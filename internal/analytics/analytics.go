@@ -0,0 +1,235 @@
+// Package analytics keeps statistics of the chat's activity: the messages
+// posted, the users active, the most connections at once and the busiest
+// hours, in each room and in each workspace as a whole, and what each user
+// did in each workspace. Activity is counted an hour at a time and kept for
+// a year; users' totals are kept until they are erased.
+package analytics
+
+import (
+	"log"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/store"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Retention is how long hours of activity are kept
+const Retention = 366 * 24 * time.Hour
+
+// Connection is a client connected to a workspace, or to a room in it, when
+// activity is sampled
+type Connection struct {
+	Workspace string
+	RoomID    string // "" for the workspace as a whole
+	Username  string
+}
+
+// Analytics counts activity by the hour
+type Analytics struct {
+	store store.Store // may be nil to keep statistics in memory only
+
+	// Workspace returns the workspace of a room, reporting false for
+	// anything that isn't a room, such as direct message conversations. It
+	// must be set before messages are posted.
+	Workspace func(roomID string) (string, bool)
+
+	// Guards the fields below
+	mutex sync.RWMutex
+
+	// Hours of activity by their record's key
+	hours map[string]*hour
+
+	// Keys of the hours changed since they were last saved
+	dirty map[string]bool
+
+	// Activity of each user by their record's key
+	users map[string]*store.UserStatsRecord
+
+	// Keys of the users whose activity changed since it was last saved
+	changedUsers map[string]bool
+
+	// Start of the hour activity was last sampled in, to prune once an hour
+	sampled time.Time
+
+	// Held while changed hours are saved, so they are saved in order
+	saving sync.Mutex
+}
+
+// hour is an hour of activity in a room or workspace
+type hour struct {
+	record store.StatsRecord // Users is only filled in when saved
+	users  map[string]bool
+}
+
+// New creates empty statistics
+func New(st store.Store) *Analytics {
+	return &Analytics{
+		store:        st,
+		hours:        make(map[string]*hour),
+		dirty:        make(map[string]bool),
+		users:        make(map[string]*store.UserStatsRecord),
+		changedUsers: make(map[string]bool),
+	}
+}
+
+// Load reads the stored hours of activity and users' activity
+func (a *Analytics) Load() error {
+	if a.store == nil {
+		return nil
+	}
+	records, err := a.store.LoadStats()
+	if err != nil {
+		return err
+	}
+	users, err := a.store.LoadUserStats()
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, record := range records {
+		h := &hour{record: *record, users: make(map[string]bool, len(record.Users))}
+		for _, username := range record.Users {
+			h.users[username] = true
+		}
+		h.record.Users = nil
+		a.hours[record.Key()] = h
+	}
+	for _, record := range users {
+		a.users[record.Key()] = record
+	}
+	return nil
+}
+
+// Observe counts the messages posted in rooms and their posters. It is a
+// history observer.
+func (a *Analytics) Observe(event *store.MessageEvent, _ *history.Message) {
+	if event.Type != history.EventMessage || a.Workspace == nil {
+		return
+	}
+	workspace, ok := a.Workspace(event.RoomID)
+	if !ok {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, roomID := range []string{event.RoomID, ""} {
+		h := a.hourLocked(workspace, roomID, event.Timestamp)
+		h.record.Messages++
+		h.users[event.Username] = true
+	}
+	a.seenLocked(workspace, event.Username, event.Timestamp).Messages++
+}
+
+// Sample records the users connected at now and the number of connections
+// in each workspace and room, then saves the hours that changed. A client in
+// a room is passed once for the room and once for its workspace. It is
+// called every minute.
+func (a *Analytics) Sample(now time.Time, connections []Connection) {
+	counts := make(map[string]int)
+	a.mutex.Lock()
+	for _, c := range connections {
+		a.seenLocked(c.Workspace, c.Username, now)
+		h := a.hourLocked(c.Workspace, c.RoomID, now)
+		h.users[c.Username] = true
+		key := h.record.Key()
+		counts[key]++
+		if counts[key] > h.record.PeakConnections {
+			h.record.PeakConnections = counts[key]
+		}
+	}
+
+	// Hours too old to keep are dropped once an hour
+	var cutoff time.Time
+	if current := now.UTC().Truncate(time.Hour); !current.Equal(a.sampled) {
+		a.sampled = current
+		cutoff = now.Add(-Retention)
+		for key, h := range a.hours {
+			if h.record.Hour.Before(cutoff) {
+				delete(a.hours, key)
+				delete(a.dirty, key)
+			}
+		}
+	}
+	a.mutex.Unlock()
+
+	if err := a.Flush(); err != nil {
+		log.Printf("Error saving statistics: %v", err)
+	}
+	if a.store != nil && !cutoff.IsZero() {
+		if _, err := a.store.DeleteStats(cutoff); err != nil {
+			log.Printf("Error pruning statistics: %v", err)
+		}
+	}
+}
+
+// Flush saves the hours and users' activity that changed since they were
+// last saved
+func (a *Analytics) Flush() error {
+	if a.store == nil {
+		return nil
+	}
+	a.saving.Lock()
+	defer a.saving.Unlock()
+	return a.flushLocked()
+}
+
+// flushLocked saves what changed; the caller holds a.saving
+func (a *Analytics) flushLocked() error {
+	a.mutex.Lock()
+	hours := a.changedLocked()
+	users := a.changedUsersLocked()
+	a.mutex.Unlock()
+
+	if len(hours) > 0 {
+		if err := a.store.SaveStats(hours); err != nil {
+			return err
+		}
+	}
+	if len(users) > 0 {
+		return a.store.SaveUserStats(users)
+	}
+	return nil
+}
+
+// hourLocked returns the hour of activity of a room, or of a workspace as a
+// whole when roomID is "", that t falls in, creating it if needed. The
+// caller holds a.mutex.
+func (a *Analytics) hourLocked(workspace, roomID string, t time.Time) *hour {
+	record := store.StatsRecord{Workspace: workspace, RoomID: roomID, Hour: t.UTC().Truncate(time.Hour)}
+	key := record.Key()
+	h, ok := a.hours[key]
+	if !ok {
+		h = &hour{record: record, users: make(map[string]bool)}
+		a.hours[key] = h
+	}
+	a.dirty[key] = true
+	return h
+}
+
+// changedLocked returns copies of the hours changed since they were last
+// saved, with their users, and marks them saved. The caller holds a.mutex.
+func (a *Analytics) changedLocked() []*store.StatsRecord {
+	changed := make([]*store.StatsRecord, 0, len(a.dirty))
+	for key := range a.dirty {
+		h := a.hours[key]
+		record := h.record
+		record.Users = sortedUsers(h.users)
+		changed = append(changed, &record)
+	}
+	clear(a.dirty)
+	return changed
+}
+
+// sortedUsers returns the usernames of a set, sorted
+func sortedUsers(users map[string]bool) []string {
+	sorted := make([]string, 0, len(users))
+	for username := range users {
+		sorted = append(sorted, username)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,186 @@
+package analytics
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultRange is how far back a query looks when it names no start
+const DefaultRange = 7 * 24 * time.Hour
+
+// Filter selects the activity a report covers
+type Filter struct {
+	Workspace string
+	RoomID    string    // "" for the workspace as a whole
+	Since     time.Time // zero for DefaultRange before Until
+	Until     time.Time // zero for now
+}
+
+// Report sums up the activity in a workspace or room over a time range
+type Report struct {
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+
+	// Messages posted in the range
+	Messages int `json:"messages"`
+
+	// Distinct users who posted or were connected in the range
+	ActiveUsers int `json:"activeUsers"`
+
+	// Distinct users active in the day and week before Until
+	DAU int `json:"dau"`
+	WAU int `json:"wau"`
+
+	// Most connections at once in the range, and the hour it was reached in
+	PeakConnections int        `json:"peakConnections"`
+	PeakAt          *time.Time `json:"peakAt,omitempty"`
+
+	// Messages posted in each hour of the day (UTC), and the busiest of them
+	BusiestHours [24]int `json:"busiestHours"`
+	BusiestHour  int     `json:"busiestHour"`
+
+	// Activity on each day (UTC) of the range with any
+	Daily []DayStats `json:"daily"`
+
+	// Activity in each room with any, busiest first, when the report covers
+	// a whole workspace
+	Rooms []RoomStats `json:"rooms,omitempty"`
+}
+
+// DayStats is a day's activity
+type DayStats struct {
+	Date        string `json:"date"` // YYYY-MM-DD
+	Messages    int    `json:"messages"`
+	ActiveUsers int    `json:"activeUsers"`
+}
+
+// RoomStats is a room's activity over a report's range
+type RoomStats struct {
+	RoomID      string `json:"roomId"`
+	Messages    int    `json:"messages"`
+	ActiveUsers int    `json:"activeUsers"`
+}
+
+// bounds returns the range f selects, with its defaults filled in
+func (f Filter) bounds() (since, until time.Time) {
+	until = f.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	since = f.Since
+	if since.IsZero() {
+		since = until.Add(-DefaultRange)
+	}
+	return since, until
+}
+
+// Query reports the activity f selects
+func (a *Analytics) Query(f Filter) *Report {
+	since, until := f.bounds()
+	report := &Report{Since: since.UTC(), Until: until.UTC(), Daily: []DayStats{}}
+	first := since.UTC().Truncate(time.Hour)
+	day, week := until.Add(-24*time.Hour), until.Add(-7*24*time.Hour)
+
+	active := make(map[string]bool)
+	dau := make(map[string]bool)
+	wau := make(map[string]bool)
+	days := make(map[string]*DayStats)
+	dayUsers := make(map[string]map[string]bool)
+	rooms := make(map[string]*RoomStats)
+	roomUsers := make(map[string]map[string]bool)
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	for _, h := range a.hours {
+		record := &h.record
+		if record.Workspace != f.Workspace {
+			continue
+		}
+
+		// Rooms are summed up from their own hours
+		if f.RoomID == "" && record.RoomID != "" {
+			if record.Hour.Before(first) || !record.Hour.Before(until) {
+				continue
+			}
+			rs, ok := rooms[record.RoomID]
+			if !ok {
+				rs = &RoomStats{RoomID: record.RoomID}
+				rooms[record.RoomID] = rs
+				roomUsers[record.RoomID] = make(map[string]bool)
+			}
+			rs.Messages += record.Messages
+			for username := range h.users {
+				roomUsers[record.RoomID][username] = true
+			}
+			continue
+		}
+		if record.RoomID != f.RoomID {
+			continue
+		}
+
+		// An hour counts toward DAU and WAU when it overlaps their window
+		end := record.Hour.Add(time.Hour)
+		if end.After(week) && record.Hour.Before(until) {
+			for username := range h.users {
+				wau[username] = true
+				if end.After(day) {
+					dau[username] = true
+				}
+			}
+		}
+
+		if record.Hour.Before(first) || !record.Hour.Before(until) {
+			continue
+		}
+		report.Messages += record.Messages
+		report.BusiestHours[record.Hour.Hour()] += record.Messages
+		if record.PeakConnections > report.PeakConnections {
+			report.PeakConnections = record.PeakConnections
+			peakAt := record.Hour
+			report.PeakAt = &peakAt
+		}
+
+		date := record.Hour.Format("2006-01-02")
+		ds, ok := days[date]
+		if !ok {
+			ds = &DayStats{Date: date}
+			days[date] = ds
+			dayUsers[date] = make(map[string]bool)
+		}
+		ds.Messages += record.Messages
+		for username := range h.users {
+			active[username] = true
+			dayUsers[date][username] = true
+		}
+	}
+
+	report.ActiveUsers = len(active)
+	report.DAU = len(dau)
+	report.WAU = len(wau)
+	for hour, messages := range report.BusiestHours {
+		if messages > report.BusiestHours[report.BusiestHour] {
+			report.BusiestHour = hour
+		}
+	}
+	for date, ds := range days {
+		ds.ActiveUsers = len(dayUsers[date])
+		report.Daily = append(report.Daily, *ds)
+	}
+	sort.Slice(report.Daily, func(i, j int) bool {
+		return report.Daily[i].Date < report.Daily[j].Date
+	})
+	if f.RoomID == "" {
+		report.Rooms = make([]RoomStats, 0, len(rooms))
+		for roomID, rs := range rooms {
+			rs.ActiveUsers = len(roomUsers[roomID])
+			report.Rooms = append(report.Rooms, *rs)
+		}
+		sort.Slice(report.Rooms, func(i, j int) bool {
+			if report.Rooms[i].Messages != report.Rooms[j].Messages {
+				return report.Rooms[i].Messages > report.Rooms[j].Messages
+			}
+			return report.Rooms[i].RoomID < report.Rooms[j].RoomID
+		})
+	}
+	return report
+}
@@ -0,0 +1,172 @@
+package analytics
+
+import (
+	"realtime-chat/internal/store"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Orders of a leaderboard
+const (
+	SortMessages = "messages" // Most messages posted first
+	SortRooms    = "rooms"    // Most rooms joined first
+	SortRecent   = "recent"   // Most recently seen first
+)
+
+// MaxLeaderboard is the most users a leaderboard lists
+const MaxLeaderboard = 100
+
+// ValidSort reports whether sort orders a leaderboard; "" means SortMessages
+func ValidSort(sort string) bool {
+	return sort == "" || sort == SortMessages || sort == SortRooms || sort == SortRecent
+}
+
+// UserStats is a user's activity in a workspace
+type UserStats struct {
+	Username    string    `json:"username"`
+	Messages    int       `json:"messages"`
+	RoomsJoined int       `json:"roomsJoined"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+}
+
+// Joined records that a user joined a room, which counts as being seen
+func (a *Analytics) Joined(roomID, username string, t time.Time) {
+	if a.Workspace == nil {
+		return
+	}
+	workspace, ok := a.Workspace(roomID)
+	if !ok {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	record := a.seenLocked(workspace, username, t)
+	if !slices.Contains(record.Rooms, roomID) {
+		record.Rooms = append(record.Rooms, roomID)
+	}
+}
+
+// User returns a user's activity in a workspace, reporting false when they
+// were never seen there
+func (a *Analytics) User(workspace, username string) (*UserStats, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	record, ok := a.users[workspace+"/"+username]
+	if !ok {
+		return nil, false
+	}
+	return &UserStats{
+		Username:    record.Username,
+		Messages:    record.Messages,
+		RoomsJoined: len(record.Rooms),
+		FirstSeen:   record.FirstSeen,
+		LastSeen:    record.LastSeen,
+	}, true
+}
+
+// Leaderboard returns the activity of up to limit users of a workspace in
+// the given order, ties going to the username first alphabetically
+func (a *Analytics) Leaderboard(workspace, order string, limit int) []UserStats {
+	a.mutex.RLock()
+	users := make([]UserStats, 0)
+	for _, record := range a.users {
+		if record.Workspace != workspace {
+			continue
+		}
+		users = append(users, UserStats{
+			Username:    record.Username,
+			Messages:    record.Messages,
+			RoomsJoined: len(record.Rooms),
+			FirstSeen:   record.FirstSeen,
+			LastSeen:    record.LastSeen,
+		})
+	}
+	a.mutex.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		switch order {
+		case SortRooms:
+			if users[i].RoomsJoined != users[j].RoomsJoined {
+				return users[i].RoomsJoined > users[j].RoomsJoined
+			}
+		case SortRecent:
+			if !users[i].LastSeen.Equal(users[j].LastSeen) {
+				return users[i].LastSeen.After(users[j].LastSeen)
+			}
+		default:
+			if users[i].Messages != users[j].Messages {
+				return users[i].Messages > users[j].Messages
+			}
+		}
+		return users[i].Username < users[j].Username
+	})
+	if limit > 0 && len(users) > limit {
+		users = users[:limit]
+	}
+	return users
+}
+
+// EraseUser forgets a user: their activity in every workspace is deleted and
+// they are no longer counted among any hour's active users
+func (a *Analytics) EraseUser(username string) error {
+	a.saving.Lock()
+	defer a.saving.Unlock()
+
+	a.mutex.Lock()
+	for key, record := range a.users {
+		if record.Username == username {
+			delete(a.users, key)
+			delete(a.changedUsers, key)
+		}
+	}
+	for key, h := range a.hours {
+		if h.users[username] {
+			delete(h.users, username)
+			a.dirty[key] = true
+		}
+	}
+	a.mutex.Unlock()
+
+	if a.store == nil {
+		return nil
+	}
+	if err := a.store.DeleteUserStats(username); err != nil {
+		return err
+	}
+	return a.flushLocked()
+}
+
+// seenLocked returns a user's activity in a workspace after marking them
+// seen at t, creating it if needed. The caller holds a.mutex.
+func (a *Analytics) seenLocked(workspace, username string, t time.Time) *store.UserStatsRecord {
+	key := workspace + "/" + username
+	record, ok := a.users[key]
+	if !ok {
+		record = &store.UserStatsRecord{Workspace: workspace, Username: username, FirstSeen: t.UTC()}
+		a.users[key] = record
+	}
+	if t.Before(record.FirstSeen) {
+		record.FirstSeen = t.UTC()
+	}
+	if t.After(record.LastSeen) {
+		record.LastSeen = t.UTC()
+	}
+	a.changedUsers[key] = true
+	return record
+}
+
+// changedUsersLocked returns copies of the users' activity changed since it
+// was last saved, and marks it saved. The caller holds a.mutex.
+func (a *Analytics) changedUsersLocked() []*store.UserStatsRecord {
+	changed := make([]*store.UserStatsRecord, 0, len(a.changedUsers))
+	for key := range a.changedUsers {
+		record := *a.users[key]
+		record.Rooms = slices.Clone(record.Rooms)
+		changed = append(changed, &record)
+	}
+	clear(a.changedUsers)
+	return changed
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"realtime-chat/internal/hub"
+	"time"
+)
+
+// listAnnouncements handles GET /api/admin/announcements and returns the
+// announcements that haven't expired
+func (h *Handler) listAnnouncements(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"announcements": h.hub.Announcements(),
+	})
+}
+
+// announce handles POST /api/admin/announcements with a JSON body holding
+// the "message" and an optional "ttl" in seconds, and sends the announcement
+// to every connected client in every room
+func (h *Handler) announce(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Message string `json:"message"`
+		TTL     int64  `json:"ttl"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "expected a JSON object with a message")
+		return
+	}
+
+	actor, _ := h.adminActor(r)
+	announcement, err := h.hub.Announce(body.Message, time.Duration(body.TTL)*time.Second, actor)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, announcement)
+}
+
+// withdrawAnnouncement handles DELETE /api/admin/announcements/{id} and ends
+// an announcement before it expires
+func (h *Handler) withdrawAnnouncement(w http.ResponseWriter, r *http.Request) {
+	actor, _ := h.adminActor(r)
+	if err := h.hub.WithdrawAnnouncement(r.PathValue("id"), actor); errors.Is(err, hub.ErrNoAnnouncement) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
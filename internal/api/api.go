@@ -0,0 +1,130 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/auth"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/store"
+	"strconv"
+	"strings"
+)
+
+// Handler serves the REST API
+type Handler struct {
+	hub        *hub.Hub
+	adminToken string
+	spec       []byte // OpenAPI document of the routes
+}
+
+// Register adds the REST API routes to mux, along with the OpenAPI document
+// describing them and Swagger UI. Admin routes require adminToken as a bearer
+// token, the session of a global admin or an API key with the admin scope.
+func Register(mux *http.ServeMux, h *hub.Hub, adminToken string) {
+	handler := &Handler{hub: h, adminToken: adminToken}
+	routes := handler.routes()
+
+	spec, err := json.Marshal(openAPI(routes))
+	if err != nil {
+		log.Printf("Error encoding OpenAPI document: %v", err)
+	}
+	handler.spec = spec
+
+	for _, rt := range routes {
+		next := rt.handler
+		switch rt.access {
+		case accessUser:
+			next = handler.requireUser(next)
+		case accessSelf:
+			next = handler.requireSelf(next)
+		case accessAdmin:
+			next = handler.requireAdmin(next)
+		}
+		if rt.workspace {
+			next = handler.requireWorkspace(next)
+		}
+		mux.HandleFunc(rt.pattern, handler.checkAPIKey(rt, next))
+	}
+}
+
+// requireAdmin rejects requests that carry neither the admin bearer token,
+// the session of a user with a global admin role nor an API key with the
+// admin scope, and records every accepted call in the audit log
+func (h *Handler) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := h.adminActor(r)
+		if !ok {
+			if h.adminToken == "" {
+				writeError(w, http.StatusNotFound, "admin API is disabled")
+				return
+			}
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next(sw, r)
+
+		path := r.URL.Path
+		if r.URL.RawQuery != "" {
+			path += "?" + r.URL.RawQuery
+		}
+		h.hub.Audit.Record(store.AuditRecord{
+			Actor:  actor,
+			Action: audit.ActionAdminAPI,
+			Target: r.PathValue("username"),
+			Details: map[string]string{
+				"method": r.Method,
+				"path":   path,
+				"status": strconv.Itoa(sw.status),
+			},
+		})
+	}
+}
+
+// adminActor returns who is making an admin request: "admin" for the admin
+// token, the username of a global admin's session or the service account
+// of an API key with the admin scope
+func (h *Handler) adminActor(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok && h.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1 {
+		return "admin", true
+	}
+	if key, ok := h.hub.Auth.APIKey(auth.Token(r)); ok {
+		return key.Username, key.Allows(auth.ScopeAdmin)
+	}
+	if username, ok := h.hub.Auth.Session(auth.Token(r)); ok && h.hub.Roles.Can(username, "", rbac.PermAdminAPI) {
+		return username, true
+	}
+	return "", false
+}
+
+// statusWriter remembers the status code written to a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before writing it
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"realtime-chat/internal/auth"
+	"strconv"
+	"strings"
+)
+
+// listAPIKeys handles GET /api/admin/keys and returns every API key,
+// without the keys themselves
+func (h *Handler) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys := h.hub.Auth.APIKeys()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys":  keys,
+		"count": len(keys),
+	})
+}
+
+// createAPIKey handles POST /api/admin/keys and issues an API key to a
+// service account, creating the account on its first key. The key is only
+// ever shown in this response.
+func (h *Handler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username  string   `json:"username"`
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		RateLimit int      `json:"rateLimit"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "expected a JSON object with a username and scopes")
+		return
+	}
+	if body.RateLimit < 0 {
+		writeError(w, http.StatusBadRequest, "rateLimit must not be negative")
+		return
+	}
+
+	actor, _ := h.adminActor(r)
+	token, key, err := h.hub.Auth.CreateAPIKey(body.Username, strings.TrimSpace(body.Name), body.Scopes, body.RateLimit, actor)
+	switch {
+	case errors.Is(err, auth.ErrInvalidScope), errors.Is(err, auth.ErrServiceUsername):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, auth.ErrNotServiceAccount):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error creating API key for %s: %v", body.Username, err)
+		writeError(w, http.StatusInternalServerError, "could not create API key")
+		return
+	}
+
+	log.Printf("Created API key %s for %s with scopes %s", key.ID, key.Username, strings.Join(key.Scopes, ","))
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"key":    token,
+		"apiKey": key,
+	})
+}
+
+// revokeAPIKey handles DELETE /api/admin/keys/{id}, revoking the key and
+// closing the connections made with it
+func (h *Handler) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, closed, err := h.hub.RevokeAPIKey(r.PathValue("id"))
+	switch {
+	case errors.Is(err, auth.ErrNoAPIKey):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error revoking API key %s: %v", r.PathValue("id"), err)
+		writeError(w, http.StatusInternalServerError, "could not revoke API key")
+		return
+	}
+
+	log.Printf("Revoked API key %s of %s, closing %d connections", key.ID, key.Username, closed)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkAPIKey rejects requests made with an API key that is unknown, lacks
+// the scope the route needs or is over its rate limit. Admin routes need
+// the admin scope, other reads the read scope and other writes the post
+// scope. Requests without an API key pass through.
+func (h *Handler) checkAPIKey(rt route, next http.HandlerFunc) http.HandlerFunc {
+	scope := auth.ScopePost
+	if method, _, _ := strings.Cut(rt.pattern, " "); method == http.MethodGet || method == http.MethodHead {
+		scope = auth.ScopeRead
+	}
+	if rt.access == accessAdmin {
+		scope = auth.ScopeAdmin
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := auth.Token(r)
+		if !auth.IsAPIKey(token) {
+			next(w, r)
+			return
+		}
+		key, ok := h.hub.Auth.APIKey(token)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+		if !key.Allows(scope) {
+			writeError(w, http.StatusForbidden, "this API key lacks the "+scope+" scope")
+			return
+		}
+		if !h.hub.AllowKeyRequest(key) {
+			w.Header().Set("Retry-After", "60")
+			writeError(w, http.StatusTooManyRequests, "this API key is limited to "+strconv.Itoa(h.hub.KeyRateLimit(key))+" requests a minute")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// account returns the account a request is made as: the user of its
+// session or the service account of its API key
+func (h *Handler) account(r *http.Request) (string, bool) {
+	token := auth.Token(r)
+	if key, ok := h.hub.Auth.APIKey(token); ok {
+		return key.Username, true
+	}
+	return h.hub.Auth.Session(token)
+}
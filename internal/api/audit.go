@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"realtime-chat/internal/audit"
+	"strconv"
+	"time"
+)
+
+// queryAudit handles GET /api/admin/audit and returns audit log entries,
+// newest first, filtered by ?actor=, ?action=, ?target=, ?room=, ?since=
+// and ?until= (RFC 3339 times) and limited by ?limit=
+func (h *Handler) queryAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+		RoomID: query.Get("room"),
+	}
+
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, name+" must be an RFC 3339 time")
+			return
+		}
+		*t = parsed
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		filter.Limit = limit
+	}
+
+	entries := h.hub.Audit.Query(filter)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/auth"
+	"realtime-chat/internal/profile"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/workspace"
+	"time"
+)
+
+// stateCookie holds the OAuth2 state between the redirect to a provider and its callback
+const stateCookie = "chat_oauth_state"
+
+// login handles GET /api/auth/{provider}/login and redirects to the provider's consent page
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.hub.Auth.Provider(r.PathValue("provider"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "login provider not configured")
+		return
+	}
+
+	// Single sign-on finds its endpoints when first used
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	if err := provider.Ready(ctx); err != nil {
+		log.Printf("Error preparing %s login: %v", provider.Name, err)
+		writeError(w, http.StatusBadGateway, "could not reach "+provider.Label)
+		return
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		log.Printf("Error generating OAuth2 state: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not start login")
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(raw)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/api/auth/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   h.hub.Auth.Secure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state, h.hub.Auth.RedirectURL(provider.Name)), http.StatusFound)
+}
+
+// callback handles GET /api/auth/{provider}/callback. It logs into the
+// account linked to the provider's user, creating one on first login, or
+// links the provider to the account already logged in, then sets the
+// session cookie and returns to the chat.
+func (h *Handler) callback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.hub.Auth.Provider(r.PathValue("provider"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "login provider not configured")
+		return
+	}
+
+	cookie, err := r.Cookie(stateCookie)
+	state := r.URL.Query().Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		writeError(w, http.StatusBadRequest, "login expired or was not started here")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/api/auth/", MaxAge: -1})
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		writeError(w, http.StatusBadRequest, "login was cancelled")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	user, err := provider.User(ctx, code, h.hub.Auth.RedirectURL(provider.Name))
+	if err != nil {
+		log.Printf("Error logging in with %s: %v", provider.Name, err)
+		writeError(w, http.StatusBadGateway, "could not verify login with "+provider.Name)
+		return
+	}
+
+	// Logged-in users add the provider to their account instead of getting a
+	// new one, and guests sign up keeping their username, connections and rooms
+	token := auth.Token(r)
+	current, _ := h.hub.Auth.Session(token)
+	guest := h.hub.Auth.Guest(current)
+	var username string
+	var created bool
+	if guest {
+		username, created, err = h.hub.Auth.Convert(user, token)
+	} else {
+		username, created, err = h.hub.Auth.Login(user, current)
+	}
+	if errors.Is(err, auth.ErrIdentityLinked) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving account: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not save account")
+		return
+	}
+	if created {
+		log.Printf("Created account %s from %s login", username, provider.Name)
+		h.populateProfile(ctx, username, user)
+	}
+	if role, ok := h.hub.Auth.SSORole(user); ok {
+		h.syncRole(username, role)
+	}
+
+	switch {
+	case current != username:
+		token, err := h.hub.Auth.NewSession(username, r.UserAgent(), remoteIP(r))
+		if err != nil {
+			log.Printf("Error starting session: %v", err)
+			writeError(w, http.StatusInternalServerError, "could not start session")
+			return
+		}
+		h.setSessionCookie(w, token, time.Now().Add(h.hub.Auth.SessionTTL()))
+	case guest:
+		// The guest's session now lasts as long as an account's
+		h.setSessionCookie(w, token, time.Now().Add(h.hub.Auth.SessionTTL()))
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// guest handles POST /api/auth/guest and issues a guest identity, setting
+// its session cookie and returning its username and token
+func (h *Handler) guest(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.hub.Auth.Session(auth.Token(r)); ok {
+		writeError(w, http.StatusConflict, "already logged in; log out first")
+		return
+	}
+
+	username, token, expiresAt, err := h.hub.Auth.NewGuest(r.UserAgent(), remoteIP(r))
+	if err != nil {
+		log.Printf("Error issuing guest identity: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not issue guest identity")
+		return
+	}
+	h.setSessionCookie(w, token, expiresAt)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"username":  username,
+		"token":     token,
+		"expiresAt": expiresAt,
+	})
+}
+
+// setSessionCookie sends a session token as the session cookie
+func (h *Handler) setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   h.hub.Auth.Secure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// syncRole gives a single sign-on user the global role their claims map
+// to, removing it when they map to none, and audits changes
+func (h *Handler) syncRole(username string, role rbac.Role) {
+	if h.hub.Roles.Assigned("", username) == role {
+		return
+	}
+	if err := h.hub.Roles.Assign("", username, role); err != nil {
+		log.Printf("Error assigning %s the role from single sign-on: %v", username, err)
+		return
+	}
+	log.Printf("Role of %s set to %q from single sign-on", username, role)
+	h.hub.Audit.Record(store.AuditRecord{
+		Actor:   auth.ProviderOIDC,
+		Action:  audit.ActionSetRole,
+		Target:  username,
+		Details: map[string]string{"role": string(role)},
+	})
+}
+
+// populateProfile fills a new account's profile with the provider's name,
+// email and avatar
+func (h *Handler) populateProfile(ctx context.Context, username string, user *auth.ProviderUser) {
+	update := profile.Update{}
+	if user.Name != "" {
+		update.DisplayName = &user.Name
+	}
+	if user.Email != "" {
+		update.Email = &user.Email
+	}
+	if _, err := h.hub.Profiles.Update(username, update); err != nil {
+		log.Printf("Error setting profile of %s: %v", username, err)
+	}
+
+	if user.AvatarURL == "" {
+		return
+	}
+	image, err := auth.FetchAvatar(ctx, user.AvatarURL, profile.MaxAvatarUpload+1)
+	if err == nil {
+		_, err = h.hub.Profiles.SetAvatar(username, image)
+	}
+	if err != nil {
+		log.Printf("Error setting avatar of %s: %v", username, err)
+	}
+}
+
+// session handles GET /api/auth/session and returns who is logged in, or
+// what a session logging in still needs
+func (h *Handler) session(w http.ResponseWriter, r *http.Request) {
+	username, state, ok := h.hub.Auth.Pending(auth.Token(r))
+	response := map[string]interface{}{
+		"loggedIn":  ok && state == auth.SecondFactorNone,
+		"providers": h.hub.Auth.Providers(),
+		"labels":    h.hub.Auth.ProviderLabels(),
+	}
+	if ok {
+		response["username"] = username
+		response["guest"] = h.hub.Auth.Guest(username)
+	}
+	if ok && state != auth.SecondFactorNone {
+		// The session can't be used until it passes two-factor login
+		response["secondFactor"] = state
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// logout handles POST /api/auth/logout and ends the current session,
+// closing the connections made with it
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	if token := auth.Token(r); token != "" {
+		username, _ := h.hub.Auth.Session(token)
+		if err := h.hub.Auth.Logout(token); err != nil {
+			log.Printf("Error ending session: %v", err)
+			writeError(w, http.StatusInternalServerError, "could not log out")
+			return
+		}
+		h.hub.CloseSessions(username, auth.TokenSessionID(token))
+	}
+	http.SetCookie(w, &http.Cookie{Name: auth.SessionCookie, Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireUser rejects changes to a user's settings unless the username is
+// unclaimed or the request carries that account's or guest's session
+func (h *Handler) requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.PathValue("username")
+		if !h.hub.Auth.Reserved(username) {
+			next(w, r)
+			return
+		}
+
+		current, ok := h.account(r)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "log in to change this user's settings")
+			return
+		}
+		if current != username {
+			writeError(w, http.StatusForbidden, "you can only change your own settings")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireWorkspace rejects reads of a workspace's rooms and messages by
+// anyone the workspace doesn't admit, as connecting to it does
+func (h *Handler) requireWorkspace(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, ok := h.account(r)
+		if h.hub.Workspaces.Admits(workspace.FromContext(r.Context()), current, ok && !h.hub.Auth.Guest(current)) {
+			next(w, r)
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "log in to read this workspace")
+			return
+		}
+		writeError(w, http.StatusForbidden, "this workspace is open only to its members")
+	}
+}
+
+// requireSelf rejects reads of a user's private messages unless the
+// request carries that user's session or API key. Unlike requireUser it
+// trusts no username on its own, claimed or not, since anyone could give it.
+func (h *Handler) requireSelf(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, ok := h.account(r)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "log in, or connect as a guest, to read your messages")
+			return
+		}
+		if current != r.PathValue("username") {
+			writeError(w, http.StatusForbidden, "you can only read your own messages")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+)
+
+// clusterStatus handles GET /api/admin/cluster and returns this node's name,
+// the other nodes still announcing themselves and the clients connected
+// across the cluster
+func (h *Handler) clusterStatus(w http.ResponseWriter, r *http.Request) {
+	c := h.hub.Cluster
+	if c == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"enabled": false,
+			"clients": h.hub.ClusterClients(),
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":      true,
+		"node":         c.NodeID(),
+		"localClients": h.hub.GetClientCount(),
+		"peers":        c.Peers(),
+		"clients":      h.hub.ClusterClients(),
+	})
+}
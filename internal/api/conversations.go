@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/workspace"
+	"strconv"
+)
+
+// listConversations handles GET /api/users/{username}/conversations and
+// returns the user's direct message conversations in the workspace with
+// their last message and unread count
+func (h *Handler) listConversations(w http.ResponseWriter, r *http.Request) {
+	conversations := h.hub.Conversations.List(workspace.FromContext(r.Context()), r.PathValue("username"))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"conversations": conversations,
+		"count":         len(conversations),
+	})
+}
+
+// listGroups handles GET /api/users/{username}/groups and returns the
+// user's group conversations in the workspace with their participants and
+// unread count
+func (h *Handler) listGroups(w http.ResponseWriter, r *http.Request) {
+	groups := h.hub.Groups(workspace.FromContext(r.Context()), r.PathValue("username"))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"groups": groups,
+		"count":  len(groups),
+	})
+}
+
+// conversationMessages handles GET /api/users/{username}/conversations/{with}/messages
+// and returns a page of the direct messages between the two users, before
+// or after a message ID with ?before= or ?after=
+func (h *Handler) conversationMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	before, after := query.Get("before"), query.Get("after")
+	if before != "" && after != "" {
+		writeError(w, http.StatusBadRequest, "give either before or after, not both")
+		return
+	}
+	limit := history.DefaultPageSize
+	if limitParam := query.Get("limit"); limitParam != "" {
+		n, err := strconv.Atoi(limitParam)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = min(n, history.MaxPageSize)
+	}
+
+	ws, username, with := workspace.FromContext(r.Context()), r.PathValue("username"), r.PathValue("with")
+	messages, more, err := h.hub.Conversations.Page(ws, username, with, before, after, limit)
+	if errors.Is(err, history.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "cursor message not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading direct messages of %s with %s: %v", username, with, err)
+		writeError(w, http.StatusInternalServerError, "could not load messages")
+		return
+	}
+	if messages == nil {
+		messages = []*history.Message{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"conversationId": conversation.ID(ws, username, with),
+		"with":           with,
+		"messages":       messages,
+		"hasMore":        more,
+		"limit":          limit,
+	})
+}
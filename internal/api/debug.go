@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/room"
+	"runtime"
+)
+
+// slowClientLimit is the most slow clients /debug/stats lists
+const slowClientLimit = 50
+
+// debugStats handles GET /debug/stats and returns the goroutine count, how
+// full the hub's and room manager's channels and the clients' queues are and
+// the state of every room, so goroutine leaks, stuck queues and slow clients
+// can be diagnosed on a live server
+func (h *Handler) debugStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"goroutines":   runtime.NumGoroutine(),
+		"clients":      h.hub.GetClientCount(),
+		"overloaded":   h.hub.IsOverloaded(),
+		"hub":          h.hub.ChannelDepths(),
+		"roomManager":  h.hub.RoomManager.ChannelDepths(),
+		"runningRooms": room.RunningRooms(),
+		"rooms":        h.hub.RoomManager.Stats(),
+		"queues":       metrics.Queues(),
+		"slowClients":  h.hub.SlowClients(slowClientLimit),
+	})
+}
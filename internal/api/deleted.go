@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+)
+
+// listDeletedMessages handles GET /api/admin/rooms/{id}/deleted and lists
+// the room's deleted messages that can still be restored, with their content
+func (h *Handler) listDeletedMessages(w http.ResponseWriter, r *http.Request) {
+	rm, exists := h.hub.RoomManager.GetRoom(r.PathValue("id"))
+	if !exists {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+
+	deleted, err := h.hub.DeletedMessages(rm.ID)
+	if err != nil {
+		log.Printf("Error loading deleted messages of room %s: %v", rm.ID, err)
+		writeError(w, http.StatusInternalServerError, "could not load deleted messages")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"roomId":   rm.ID,
+		"messages": deleted,
+		"count":    len(deleted),
+	})
+}
+
+// restoreMessage handles POST /api/admin/rooms/{id}/messages/{messageId}/restore
+// and undoes a message's deletion
+func (h *Handler) restoreMessage(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "expected a JSON object with an optional reason")
+			return
+		}
+	}
+
+	rm, exists := h.hub.RoomManager.GetRoom(r.PathValue("id"))
+	if !exists {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	actor, _ := h.adminActor(r)
+	msg, err := h.hub.RestoreMessage(rm.ID, r.PathValue("messageId"), actor, body.Reason)
+	switch {
+	case errors.Is(err, history.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, history.ErrNotDeleted):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, hub.ErrRestoreExpired):
+		writeError(w, http.StatusGone, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error restoring message %s: %v", r.PathValue("messageId"), err)
+		writeError(w, http.StatusInternalServerError, "could not restore message")
+		return
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
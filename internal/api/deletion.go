@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"realtime-chat/internal/auth"
+	"realtime-chat/internal/hub"
+)
+
+// deletionStatus handles GET /api/users/{username}/deletion and returns the
+// account's pending deletion request
+func (h *Handler) deletionStatus(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	account, ok := h.hub.Auth.Account(username)
+	if !ok || account.Deletion == nil {
+		writeError(w, http.StatusNotFound, auth.ErrNoDeletion.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"username": username,
+		"deletion": account.Deletion,
+	})
+}
+
+// requestDeletion handles POST /api/users/{username}/deletion with a JSON
+// body holding the "mode" for the user's messages, anonymize (the default)
+// or purge. The account and its data are erased once the grace period is
+// over unless the request is cancelled first. Only accounts can ask for
+// deletion; unclaimed usernames are erased by an admin.
+func (h *Handler) requestDeletion(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Mode string `json:"mode"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "expected a JSON object with a mode")
+			return
+		}
+	}
+	if body.Mode == "" {
+		body.Mode = hub.ErasureAnonymize
+	}
+	if !hub.ValidErasureMode(body.Mode) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("mode must be %q or %q", hub.ErasureAnonymize, hub.ErasurePurge))
+		return
+	}
+
+	username := r.PathValue("username")
+	deletion, err := h.hub.Auth.RequestDeletion(username, body.Mode)
+	switch {
+	case errors.Is(err, auth.ErrNoAccount):
+		writeError(w, http.StatusNotFound, "only accounts can ask to be deleted; ask an admin to erase an unclaimed username")
+		return
+	case err != nil:
+		log.Printf("Error scheduling deletion of %s: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not schedule deletion")
+		return
+	}
+
+	log.Printf("Deletion of %s scheduled for %s", username, deletion.DueAt.Format("2006-01-02 15:04:05"))
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"username": username,
+		"deletion": deletion,
+	})
+}
+
+// cancelDeletion handles DELETE /api/users/{username}/deletion and withdraws
+// a pending deletion during its grace period
+func (h *Handler) cancelDeletion(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	err := h.hub.Auth.CancelDeletion(username)
+	switch {
+	case errors.Is(err, auth.ErrNoDeletion):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error cancelling deletion of %s: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not cancel deletion")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listDeletions handles GET /api/admin/deletions and returns every pending
+// deletion request by username
+func (h *Handler) listDeletions(w http.ResponseWriter, r *http.Request) {
+	deletions := h.hub.Auth.Deletions()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"deletions": deletions,
+		"count":     len(deletions),
+	})
+}
+
+// eraseUser handles POST /api/admin/users/{username}/erase with a JSON body
+// holding the "mode" for the user's messages, and erases the user at once,
+// skipping any grace period. It works for unclaimed usernames too.
+func (h *Handler) eraseUser(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Mode string `json:"mode"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "expected a JSON object with a mode")
+		return
+	}
+	if !hub.ValidErasureMode(body.Mode) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("mode must be %q or %q", hub.ErasureAnonymize, hub.ErasurePurge))
+		return
+	}
+
+	username := r.PathValue("username")
+	actor, _ := h.adminActor(r)
+	erasure, err := h.hub.EraseUser(username, body.Mode, actor)
+	if err != nil {
+		log.Printf("Error erasing %s: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not erase user")
+		return
+	}
+	writeJSON(w, http.StatusOK, erasure)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"realtime-chat/internal/emoji"
+	"strconv"
+)
+
+// listEmoji handles GET /api/emoji and returns every custom emoji and sticker
+func (h *Handler) listEmoji(w http.ResponseWriter, r *http.Request) {
+	list := h.hub.Emoji.List()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"emoji": list,
+		"count": len(list),
+	})
+}
+
+// emojiImage handles GET /api/emoji/{shortcode} and serves the emoji's image
+func (h *Handler) emojiImage(w http.ResponseWriter, r *http.Request) {
+	image, contentType, err := h.hub.Emoji.Image(r.PathValue("shortcode"))
+	if errors.Is(err, emoji.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading emoji %s: %v", r.PathValue("shortcode"), err)
+		writeError(w, http.StatusInternalServerError, "could not read emoji")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(image)
+}
+
+// registerEmoji handles POST /api/admin/emoji, a multipart form with a
+// "shortcode", an optional "kind" ("emoji" or "sticker") and an "image" file
+func (h *Handler) registerEmoji(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, emoji.MaxImageSize+64<<10)
+	if err := r.ParseMultipartForm(emoji.MaxImageSize); err != nil {
+		writeError(w, http.StatusBadRequest, "expected a multipart form with an image of at most 256 KiB")
+		return
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "an image file is required")
+		return
+	}
+	defer file.Close()
+
+	image, err := io.ReadAll(io.LimitReader(file, emoji.MaxImageSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "could not read image")
+		return
+	}
+
+	registered, err := h.hub.Emoji.Register(r.FormValue("shortcode"), r.FormValue("kind"), image)
+	switch {
+	case errors.Is(err, emoji.ErrInvalidShortcode), errors.Is(err, emoji.ErrInvalidKind), errors.Is(err, emoji.ErrInvalidImage):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error registering emoji: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not save emoji")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, registered)
+}
+
+// removeEmoji handles DELETE /api/admin/emoji/{shortcode}
+func (h *Handler) removeEmoji(w http.ResponseWriter, r *http.Request) {
+	err := h.hub.Emoji.Remove(r.PathValue("shortcode"))
+	if errors.Is(err, emoji.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error removing emoji: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not remove emoji")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
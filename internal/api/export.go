@@ -0,0 +1,401 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/coldstorage"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/ledger"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/store"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats selectable with the "format" query parameter
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatText = "txt"
+)
+
+// contentTypes maps each export format to its Content-Type
+var contentTypes = map[string]string{
+	formatJSON: "application/json",
+	formatCSV:  "text/csv; charset=utf-8",
+	formatText: "text/plain; charset=utf-8",
+}
+
+// csvHeader names the columns of messages exported as CSV
+var csvHeader = []string{"id", "roomId", "timestamp", "username", "content", "editedAt", "deleted", "reactions"}
+
+// exportRoom handles GET /api/rooms/{id}/export?format=json|csv|txt&view=state|events
+// and streams the room's full history as a downloadable file. Only the
+// room's owner and admins, and the admin API, may export it. The events view
+// is only available as JSON.
+func (h *Handler) exportRoom(w http.ResponseWriter, r *http.Request) {
+	rm, exists := h.room(r, r.PathValue("id"))
+	if !exists {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	roomID := rm.ID
+
+	actor, ok := h.roomExporter(w, r, roomID)
+	if !ok {
+		return
+	}
+
+	format, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+	view := r.URL.Query().Get("view")
+	if view == "" {
+		view = viewState
+	}
+	if view != viewState && view != viewEvents {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("view must be %q or %q", viewState, viewEvents))
+		return
+	}
+	if view == viewEvents && format != formatJSON {
+		writeError(w, http.StatusBadRequest, "the events view can only be exported as json")
+		return
+	}
+
+	var (
+		messages []*history.Message
+		events   []*store.MessageEvent
+		err      error
+	)
+	if view == viewEvents {
+		events, err = h.hub.History.Events(roomID)
+	} else {
+		messages, err = h.hub.History.Messages(roomID)
+	}
+	if err != nil {
+		log.Printf("Error loading history for %s: %v", roomID, err)
+		writeError(w, http.StatusInternalServerError, "could not load history")
+		return
+	}
+
+	h.hub.Audit.Record(store.AuditRecord{
+		Actor:   actor,
+		Action:  audit.ActionExport,
+		RoomID:  roomID,
+		Details: map[string]string{"format": format, "view": view},
+	})
+
+	startDownload(w, fmt.Sprintf("%s-%s.%s", roomID, view, format), format)
+	out := bufio.NewWriter(w)
+	exportedAt := time.Now().UTC()
+
+	switch format {
+	case formatJSON:
+		fields := map[string]interface{}{
+			"roomId":     roomID,
+			"roomName":   rm.Name,
+			"view":       view,
+			"exportedAt": exportedAt.Format(time.RFC3339),
+		}
+		if view == viewEvents {
+			// Events carry their hash chain, so the file can be verified later
+			fields["algorithm"] = ledger.Algorithm
+			if publicKey := h.hub.History.PublicKey(); publicKey != "" {
+				fields["publicKey"] = publicKey
+			}
+			if len(events) > 0 {
+				fields["head"] = events[len(events)-1].Hash
+			}
+			err = streamJSON(out, fields, "events", len(events), func(i int) interface{} { return events[i] })
+		} else {
+			err = streamJSON(out, fields, "messages", len(messages), func(i int) interface{} { return messages[i] })
+		}
+
+	case formatCSV:
+		err = writeMessagesCSV(out, messages)
+
+	case formatText:
+		fmt.Fprintf(out, "Room: %s (%s)\nExported: %s\n\n", rm.Name, roomID, exportedAt.Format(time.RFC3339))
+		err = writeMessagesText(out, messages, false)
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		log.Printf("Error exporting room %s: %v", roomID, err)
+	}
+}
+
+// roomExporter returns who is exporting a room: the admin API, or a user
+// who may manage the room. It answers the request itself when neither is.
+func (h *Handler) roomExporter(w http.ResponseWriter, r *http.Request, roomID string) (string, bool) {
+	if actor, ok := h.adminActor(r); ok {
+		return actor, true
+	}
+
+	username, ok := h.requester(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "log in, or give your username, to export this room")
+		return "", false
+	}
+	if !h.hub.Roles.Can(username, roomID, rbac.PermManageRoom) {
+		writeError(w, http.StatusForbidden, "only the room's owner and admins can export it")
+		return "", false
+	}
+	return username, true
+}
+
+// exportUser handles GET /api/users/{username}/export?format=json|csv|txt and
+// streams everything stored about a user: their profile and private
+// settings, account, roles, the messages they posted, direct messages and
+// those in cold storage included, and the reports they filed. CSV exports
+// hold just the messages.
+func (h *Handler) exportUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	format, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	messages, _, err := h.hub.UserMessages(r.Context(), hub.UserMessagesQuery{Username: username, AllWorkspaces: true})
+	if errors.Is(err, coldstorage.ErrStorage) {
+		log.Printf("Error loading messages of %s: %v", username, err)
+		writeError(w, http.StatusBadGateway, "cold storage unavailable")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading messages of %s: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not load history")
+		return
+	}
+	slices.Reverse(messages) // Oldest first, like room exports
+
+	actor, ok := h.requester(r)
+	if !ok {
+		actor = username
+	}
+	h.hub.Audit.Record(store.AuditRecord{
+		Actor:   actor,
+		Action:  audit.ActionExport,
+		Target:  username,
+		Details: map[string]string{"format": format},
+	})
+
+	startDownload(w, fmt.Sprintf("%s-data.%s", username, format), format)
+	out := bufio.NewWriter(w)
+	exportedAt := time.Now().UTC()
+	switch format {
+	case formatJSON:
+		fields := map[string]interface{}{
+			"username":   username,
+			"exportedAt": exportedAt.Format(time.RFC3339),
+			"profile":    h.hub.Profiles.Record(username),
+			"roles":      h.userRoles(username),
+			"reports":    h.userReports(username),
+		}
+		if account, ok := h.hub.Auth.Account(username); ok {
+			fields["account"] = account
+		}
+		err = streamJSON(out, fields, "messages", len(messages), func(i int) interface{} { return messages[i] })
+
+	case formatCSV:
+		err = writeMessagesCSV(out, messages)
+
+	case formatText:
+		fmt.Fprintf(out, "User: %s\nExported: %s\n", username, exportedAt.Format(time.RFC3339))
+		if rec := h.hub.Profiles.Record(username); rec != nil {
+			fmt.Fprintf(out, "Display name: %s\nBio: %s\nStatus: %s\nEmail: %s\n", rec.DisplayName, rec.Bio, rec.Status, rec.Email)
+		}
+		if account, ok := h.hub.Auth.Account(username); ok {
+			for _, id := range account.Identities {
+				fmt.Fprintf(out, "Linked %s account: %s\n", id.Provider, id.Email)
+			}
+		}
+		roles := h.userRoles(username)
+		roomIDs := make([]string, 0, len(roles))
+		for roomID := range roles {
+			roomIDs = append(roomIDs, roomID)
+		}
+		sort.Strings(roomIDs)
+		for _, roomID := range roomIDs {
+			where := roomID
+			if where == "" {
+				where = "every room"
+			}
+			fmt.Fprintf(out, "Role in %s: %s\n", where, roles[roomID])
+		}
+		fmt.Fprintf(out, "Reports filed: %d\n\n", len(h.userReports(username)))
+		err = writeMessagesText(out, messages, true)
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		log.Printf("Error exporting data of %s: %v", username, err)
+	}
+}
+
+// requester returns who is making a request: the user of its session or
+// the service account of its API key, or the unclaimed username given as
+// ?username=
+func (h *Handler) requester(r *http.Request) (string, bool) {
+	if username, ok := h.account(r); ok {
+		return username, true
+	}
+	username := r.URL.Query().Get("username")
+	if username == "" || h.hub.Auth.Reserved(username) {
+		return "", false
+	}
+	return username, true
+}
+
+// userRoles returns the roles assigned to a user, by room ID; the global
+// role has an empty room ID
+func (h *Handler) userRoles(username string) map[string]rbac.Role {
+	global, rooms := h.hub.Roles.Assignments()
+	roles := make(map[string]rbac.Role)
+	if role, ok := global[username]; ok {
+		roles[""] = role
+	}
+	for roomID, assigned := range rooms {
+		if role, ok := assigned[username]; ok {
+			roles[roomID] = role
+		}
+	}
+	return roles
+}
+
+// userReports returns the reports a user filed
+func (h *Handler) userReports(username string) []*store.ReportRecord {
+	reports := make([]*store.ReportRecord, 0)
+	for _, rep := range h.hub.Reports.List("", "") {
+		if rep.Reporter == username {
+			reports = append(reports, rep)
+		}
+	}
+	return reports
+}
+
+// exportFormat reads the "format" query parameter, json by default, writing
+// an error response and returning false when it is unknown
+func exportFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatJSON
+	}
+	if _, ok := contentTypes[format]; !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("format must be %q, %q or %q", formatJSON, formatCSV, formatText))
+		return "", false
+	}
+	return format, true
+}
+
+// startDownload writes the headers of a file download
+func startDownload(w http.ResponseWriter, filename, format string) {
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+}
+
+// streamJSON writes a JSON object holding fields and, under key, an array of
+// n items encoded one at a time so large histories are never held encoded
+// in memory
+func streamJSON(w io.Writer, fields map[string]interface{}, key string, n int, item func(i int) interface{}) error {
+	head, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	keyJSON, _ := json.Marshal(key)
+
+	// Reopen the object to append the array
+	if _, err := fmt.Fprintf(w, "%s,%s:[", head[:len(head)-1], keyJSON); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		data, err := json.Marshal(item(i))
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			data = append([]byte{','}, data...)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// writeMessagesCSV writes messages as CSV rows under csvHeader
+func writeMessagesCSV(w io.Writer, messages []*history.Message) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		editedAt := ""
+		if msg.EditedAt != nil {
+			editedAt = msg.EditedAt.UTC().Format(time.RFC3339)
+		}
+		reactions := ""
+		if len(msg.Reactions) > 0 {
+			data, _ := json.Marshal(msg.Reactions)
+			reactions = string(data)
+		}
+		row := []string{
+			msg.ID,
+			msg.RoomID,
+			msg.Timestamp.UTC().Format(time.RFC3339),
+			msg.Username,
+			msg.Content,
+			editedAt,
+			strconv.FormatBool(msg.Deleted),
+			reactions,
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeMessagesText writes messages as a readable transcript, one message
+// per line with continuation lines indented, naming each message's room
+// when withRoom is set
+func writeMessagesText(w io.Writer, messages []*history.Message, withRoom bool) error {
+	for _, msg := range messages {
+		content := msg.Content
+		switch {
+		case msg.Deleted:
+			content = "[deleted]"
+		case msg.Encryption != nil:
+			content = "[encrypted]"
+		case msg.EditedAt != nil:
+			content += " (edited)"
+		}
+		content = strings.ReplaceAll(content, "\n", "\n    ")
+
+		line := fmt.Sprintf("[%s] %s: %s\n", msg.Timestamp.UTC().Format("2006-01-02 15:04:05"), msg.Username, content)
+		if withRoom {
+			line = fmt.Sprintf("[%s] %s in %s: %s\n", msg.Timestamp.UTC().Format("2006-01-02 15:04:05"), msg.Username, msg.RoomID, content)
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
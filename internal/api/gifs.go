@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"realtime-chat/internal/gif"
+	"strconv"
+)
+
+// searchGIFs handles GET /api/gifs?q=&limit= and returns the provider's
+// GIFs matching q, or its trending GIFs when q is empty. The provider is
+// asked by the server, so its API key never reaches clients.
+func (h *Handler) searchGIFs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if len(query) > gif.MaxQuery {
+		writeError(w, http.StatusBadRequest, "q must be at most "+strconv.Itoa(gif.MaxQuery)+" bytes")
+		return
+	}
+	limit := gif.DefaultLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > gif.MaxLimit {
+			writeError(w, http.StatusBadRequest, "limit must be a number from 1 to "+strconv.Itoa(gif.MaxLimit))
+			return
+		}
+		limit = n
+	}
+
+	gifs, err := h.hub.GIFs.Search(r.Context(), query, limit)
+	if errors.Is(err, gif.ErrDisabled) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error searching GIFs: %v", err)
+		writeError(w, http.StatusBadGateway, "could not search GIFs")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"provider": h.hub.GIFs.Provider(),
+		"gifs":     gifs,
+		"count":    len(gifs),
+	})
+}
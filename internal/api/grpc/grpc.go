@@ -0,0 +1,161 @@
+package grpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative chatpb/chat.proto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/api/grpc/chatpb"
+	"realtime-chat/internal/auth"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"strings"
+	"time"
+
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements the Chat service on top of the hub, so gRPC clients
+// share rooms, history and sessions with WebSocket clients
+type Server struct {
+	chatpb.UnimplementedChatServer
+	hub *hub.Hub
+}
+
+// Register adds the Chat service for h to a gRPC server
+func Register(s grpclib.ServiceRegistrar, h *hub.Hub) {
+	chatpb.RegisterChatServer(s, &Server{hub: h})
+}
+
+// ListRooms returns every room outside workspaces, with the unread count of
+// the requested user
+func (s *Server) ListRooms(ctx context.Context, req *chatpb.ListRoomsRequest) (*chatpb.ListRoomsResponse, error) {
+	// Room entries are shared with the REST API, whose JSON field names the
+	// protobuf JSON mapping accepts
+	data, err := json.Marshal(map[string]interface{}{"rooms": s.hub.RoomList("", req.GetUsername(), false)})
+	if err != nil {
+		log.Printf("Error encoding room list: %v", err)
+		return nil, status.Error(codes.Internal, "could not list rooms")
+	}
+
+	resp := &chatpb.ListRoomsResponse{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, resp); err != nil {
+		log.Printf("Error converting room list: %v", err)
+		return nil, status.Error(codes.Internal, "could not list rooms")
+	}
+	return resp, nil
+}
+
+// GetHistory returns one page of a room's messages, paged by message ID or
+// by sequence number like the REST history endpoint
+func (s *Server) GetHistory(ctx context.Context, req *chatpb.GetHistoryRequest) (*chatpb.GetHistoryResponse, error) {
+	r, exists := s.hub.Room("", req.GetRoomId())
+	if !exists {
+		return nil, status.Error(codes.NotFound, "room not found")
+	}
+	roomID := r.ID
+
+	before, after := req.GetBefore(), req.GetAfter()
+	if before != "" && after != "" {
+		return nil, status.Error(codes.InvalidArgument, "give either before or after, not both")
+	}
+	if req.AfterSeq != nil && (before != "" || after != "") {
+		return nil, status.Error(codes.InvalidArgument, "after_seq can't be combined with before or after")
+	}
+	if req.GetLimit() < 0 || req.GetAfterSeq() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit and after_seq must not be negative")
+	}
+	limit := history.DefaultPageSize
+	if req.GetLimit() > 0 {
+		limit = min(int(req.GetLimit()), history.MaxPageSize)
+	}
+
+	resp := &chatpb.GetHistoryResponse{}
+	var messages []*history.Message
+	var err error
+	if req.AfterSeq != nil {
+		messages, resp.LastSeq, resp.HasMore, err = s.hub.HistorySince(ctx, roomID, req.GetAfterSeq(), limit)
+	} else {
+		messages, resp.HasMore, err = s.hub.HistoryPage(ctx, roomID, before, after, limit)
+	}
+	if errors.Is(err, history.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "cursor message not found")
+	}
+	if err != nil {
+		log.Printf("Error loading history for %s: %v", roomID, err)
+		return nil, status.Error(codes.Internal, "could not load history")
+	}
+
+	resp.Messages = make([]*chatpb.ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		resp.Messages = append(resp.Messages, chatMessage(msg))
+	}
+	return resp, nil
+}
+
+// session returns who a call chats as: the account of its session token,
+// or else the unclaimed name in its "username" header, like a WebSocket
+// connection. The ID of the login session is "" when the call didn't log in.
+func (s *Server) session(ctx context.Context) (string, string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(value, "Bearer "); ok {
+			if username, loggedIn := s.hub.Auth.Session(token); loggedIn {
+				return username, auth.TokenSessionID(token), nil
+			}
+		}
+	}
+
+	if s.hub.Auth.GuestsOnly() {
+		return "", "", status.Error(codes.Unauthenticated, "log in or get a guest identity from /api/auth/guest")
+	}
+	username := "Anonymous"
+	if names := md.Get("username"); len(names) > 0 && names[0] != "" {
+		username = names[0]
+	}
+	if !hub.ValidUsername(username) {
+		return "", "", status.Error(codes.InvalidArgument, hub.ErrUsernameInvalid.Error())
+	}
+	if s.hub.Auth.Reserved(username) {
+		return "", "", status.Error(codes.Unauthenticated, "log in to use this username")
+	}
+	return username, "", nil
+}
+
+// chatMessage converts a message from the history
+func chatMessage(msg *history.Message) *chatpb.ChatMessage {
+	converted := &chatpb.ChatMessage{
+		Id:              msg.ID,
+		RoomId:          msg.RoomID,
+		Seq:             msg.Seq,
+		Username:        msg.Username,
+		Content:         msg.Content,
+		Timestamp:       timestamppb.New(msg.Timestamp),
+		EditedAt:        timestamp(msg.EditedAt),
+		Deleted:         msg.Deleted,
+		ExpiresAt:       timestamp(msg.ExpiresAt),
+		ClientMessageId: msg.ClientMessageID,
+	}
+	if len(msg.Reactions) > 0 {
+		converted.Reactions = make(map[string]*chatpb.Usernames, len(msg.Reactions))
+		for emoji, usernames := range msg.Reactions {
+			converted.Reactions[emoji] = &chatpb.Usernames{Usernames: usernames}
+		}
+	}
+	return converted
+}
+
+// timestamp converts an optional time
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
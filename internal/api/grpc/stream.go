@@ -0,0 +1,245 @@
+package grpc
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"realtime-chat/hooks"
+	"realtime-chat/internal/api/grpc/chatpb"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/websocket"
+	"time"
+
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Connect runs a chat session for as long as the stream lasts. Frames from
+// the client are translated to WebSocket frames and handled by the same code
+// as WebSocket clients, and every frame the hub sends is passed back.
+func (s *Server) Connect(stream grpclib.BidiStreamingServer[chatpb.ClientFrame, chatpb.ServerFrame]) error {
+	// Shed new sessions while the server is overloaded
+	if s.hub.IsOverloaded() {
+		metrics.ConnectionsShed.Add(1)
+		return status.Error(codes.Unavailable, "server overloaded, try again later")
+	}
+
+	username, sessionID, err := s.session(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	// Refuse sessions beyond the connection limit
+	client := websocket.NewClient(s.hub, username)
+	if !s.hub.Admit(client) {
+		return status.Error(codes.Unavailable, "too many connections, try again later")
+	}
+
+	// Operator hooks may rename or turn away the client
+	connect := &hooks.Connect{ClientID: client.ID, Username: username, Transport: hooks.TransportGRPC}
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		connect.RemoteAddr = p.Addr.String()
+	}
+	if err := s.hub.Hooks.Connect(connect); err != nil {
+		s.hub.Release(client)
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if sessionID == "" {
+		connect.Username = s.hub.TakeUsername(client, connect.Username)
+	}
+	client.SetUsername(connect.Username)
+	client.Transport, client.RemoteAddr, client.Session = connect.Transport, connect.RemoteAddr, sessionID
+	client.ConnectedAt = time.Now()
+
+	if !websocket.Register(client) {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+
+	// The session ends when the client closes its side of the stream or
+	// sends something invalid; the hub then closes the client's channels
+	failed := make(chan error, 1)
+	go func() {
+		defer websocket.Disconnect(client)
+		if err := receive(stream, client); err != nil {
+			failed <- err
+		}
+	}()
+
+	if err := send(stream, client); err != nil {
+		return err
+	}
+	select {
+	case err := <-failed:
+		return err
+	default:
+		return nil
+	}
+}
+
+// receive handles the client's frames until its side of the stream closes,
+// returning the status to end the stream with if the client misbehaved
+func receive(stream grpclib.BidiStreamingServer[chatpb.ClientFrame, chatpb.ServerFrame], c *hub.Client) error {
+	for {
+		received := time.Now()
+		frame, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if code := status.Code(err); code != codes.Canceled && code != codes.DeadlineExceeded {
+				log.Printf("gRPC stream error: %v", err)
+			}
+			return nil
+		}
+
+		data, err := frameJSON(frame)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if limit := c.Hub.Connection().MaxFrameSize; len(data) > limit {
+			return status.Errorf(codes.ResourceExhausted, "frames are limited to %d bytes", limit)
+		}
+
+		metrics.ObserveFrame(metrics.StageReceive, metrics.FrameType(data), len(data), time.Since(received))
+		websocket.HandleFrame(c, data)
+	}
+}
+
+// send passes frames from the hub to the client, high-priority ones first,
+// until the hub closes the client's channels or disconnects it
+func send(stream grpclib.BidiStreamingServer[chatpb.ClientFrame, chatpb.ServerFrame], c *hub.Client) error {
+	for {
+		select {
+		case frame := <-c.Priority:
+			if err := sendFrame(stream, c, replay.ChannelPriority, frame); err != nil {
+				return err
+			}
+			continue
+		default:
+		}
+
+		select {
+		case frame := <-c.Priority:
+			if err := sendFrame(stream, c, replay.ChannelPriority, frame); err != nil {
+				return err
+			}
+
+		case <-c.Closing():
+			// The hub disconnected the client; ending the stream ends its session
+			return sendFinal(stream, c)
+
+		case frame, ok := <-c.Send:
+			if !ok {
+				return sendFinal(stream, c)
+			}
+			if err := sendFrame(stream, c, replay.ChannelSend, frame); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendFinal delivers any final priority frames, such as a shutdown notice
+func sendFinal(stream grpclib.BidiStreamingServer[chatpb.ClientFrame, chatpb.ServerFrame], c *hub.Client) error {
+	for len(c.Priority) > 0 {
+		if err := sendFrame(stream, c, replay.ChannelPriority, <-c.Priority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendFrame converts a frame from one of the client's channels and sends it
+func sendFrame(stream grpclib.BidiStreamingServer[chatpb.ClientFrame, chatpb.ServerFrame], c *hub.Client, channel string, frame []byte) error {
+	converted, err := serverFrame(frame)
+	if err != nil {
+		log.Printf("Error converting frame for gRPC client %s: %v", c.ID, err)
+		return nil
+	}
+
+	writing := time.Now()
+	if err := stream.Send(converted); err != nil {
+		return err
+	}
+	c.Hub.Recorder.Record(&replay.Event{Kind: replay.KindDeliver, Client: c.ID, Source: channel, Data: string(frame)})
+	metrics.ObserveFrame(metrics.StageDeliver, metrics.FrameType(frame), len(frame), time.Since(writing))
+	return nil
+}
+
+// frameJSON translates a client frame to the WebSocket frame it stands for
+func frameJSON(frame *chatpb.ClientFrame) ([]byte, error) {
+	switch f := frame.GetFrame().(type) {
+	case *chatpb.ClientFrame_Join:
+		return json.Marshal(websocket.RoomAction{Type: "join", RoomID: f.Join.GetRoomId()})
+
+	case *chatpb.ClientFrame_Leave:
+		return json.Marshal(websocket.RoomAction{Type: "leave", RoomID: f.Leave.GetRoomId()})
+
+	case *chatpb.ClientFrame_Send:
+		return json.Marshal(websocket.Message{
+			Type:            "message",
+			Content:         f.Send.GetContent(),
+			TTL:             int(f.Send.GetTtl()),
+			ClientMessageID: f.Send.GetClientMessageId(),
+		})
+
+	case *chatpb.ClientFrame_Raw:
+		if _, ok := f.Raw.GetFields()["type"].GetKind().(*structpb.Value_StringValue); !ok {
+			return nil, errors.New("raw frames need a type")
+		}
+		return json.Marshal(f.Raw.AsMap())
+
+	default:
+		return nil, errors.New("empty frame")
+	}
+}
+
+// serverFrame converts a frame the hub sent to a client. Chat messages are
+// also decoded into their typed form.
+func serverFrame(frame []byte) (*chatpb.ServerFrame, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(frame, &fields); err != nil {
+		return nil, err
+	}
+	payload, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	converted := &chatpb.ServerFrame{Payload: payload}
+	converted.Type, _ = fields["type"].(string)
+	converted.RoomId, _ = fields["roomId"].(string)
+
+	if converted.Type == "message" {
+		var msg websocket.RoomMessage
+		if err := json.Unmarshal(frame, &msg); err != nil {
+			return nil, err
+		}
+		converted.Message = &chatpb.ChatMessage{
+			Id:        msg.ID,
+			RoomId:    msg.RoomID,
+			Seq:       msg.Seq,
+			Username:  msg.Username,
+			Content:   msg.Content,
+			Timestamp: parseTimestamp(msg.Timestamp),
+			ExpiresAt: parseTimestamp(msg.ExpiresAt),
+		}
+	}
+	return converted, nil
+}
+
+// parseTimestamp converts an RFC 3339 time from a frame, which may be empty
+func parseTimestamp(value string) *timestamppb.Timestamp {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"realtime-chat/internal/coldstorage"
+	"realtime-chat/internal/history"
+	"strconv"
+	"time"
+)
+
+// History views selectable with the "view" query parameter
+const (
+	// viewState returns each message's final state after edits, deletions and reactions
+	viewState = "state"
+
+	// viewEvents returns the raw event stream: messages, edits, deletions and reactions in order
+	viewEvents = "events"
+)
+
+// roomHistory handles GET /api/rooms/{id}/history?view=state|events. With
+// ?before= or ?after= a message ID, ?afterSeq= a sequence number, or
+// ?limit=, it returns one page of the state view instead of the whole history.
+func (h *Handler) roomHistory(w http.ResponseWriter, r *http.Request) {
+	body, status, err := h.historyBody(r)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// historyBody builds the response for a room's history in the requested view
+func (h *Handler) historyBody(r *http.Request) (map[string]interface{}, int, error) {
+	rm, exists := h.room(r, r.PathValue("id"))
+	if !exists {
+		return nil, http.StatusNotFound, fmt.Errorf("room not found")
+	}
+	roomID := rm.ID
+
+	view := r.URL.Query().Get("view")
+	if view == "" {
+		view = viewState
+	}
+
+	body := map[string]interface{}{
+		"roomId":     roomID,
+		"view":       view,
+		"exportedAt": time.Now().Format(time.RFC3339),
+	}
+
+	// Only the room's devices can decrypt its messages, so the content is ciphertext
+	if rm.IsEncrypted() {
+		body["encrypted"] = true
+	}
+
+	query := r.URL.Query()
+	before, after, afterSeq, limitParam := query.Get("before"), query.Get("after"), query.Get("afterSeq"), query.Get("limit")
+	if before != "" || after != "" || afterSeq != "" || limitParam != "" {
+		if view != viewState {
+			return nil, http.StatusBadRequest, fmt.Errorf("only the %q view can be paged", viewState)
+		}
+		if before != "" && after != "" {
+			return nil, http.StatusBadRequest, fmt.Errorf("give either before or after, not both")
+		}
+		if afterSeq != "" && (before != "" || after != "") {
+			return nil, http.StatusBadRequest, fmt.Errorf("afterSeq can't be combined with before or after")
+		}
+		limit := history.DefaultPageSize
+		if limitParam != "" {
+			n, err := strconv.Atoi(limitParam)
+			if err != nil || n <= 0 {
+				return nil, http.StatusBadRequest, fmt.Errorf("limit must be a positive number")
+			}
+			limit = min(n, history.MaxPageSize)
+		}
+
+		if afterSeq != "" {
+			seq, err := strconv.ParseInt(afterSeq, 10, 64)
+			if err != nil || seq < 0 {
+				return nil, http.StatusBadRequest, fmt.Errorf("afterSeq must be a sequence number")
+			}
+			messages, lastSeq, more, err := h.hub.HistorySince(r.Context(), roomID, seq, limit)
+			if errors.Is(err, coldstorage.ErrStorage) {
+				log.Printf("Error loading history for %s: %v", roomID, err)
+				return nil, http.StatusBadGateway, fmt.Errorf("cold storage unavailable")
+			}
+			if err != nil {
+				log.Printf("Error loading history for %s: %v", roomID, err)
+				return nil, http.StatusInternalServerError, fmt.Errorf("could not load history")
+			}
+			body["messages"] = messages
+			body["hasMore"] = more
+			body["limit"] = limit
+			body["afterSeq"] = seq
+			body["lastSeq"] = lastSeq
+			return body, http.StatusOK, nil
+		}
+
+		messages, more, err := h.hub.HistoryPage(r.Context(), roomID, before, after, limit)
+		if errors.Is(err, history.ErrNotFound) {
+			return nil, http.StatusNotFound, fmt.Errorf("cursor message not found")
+		}
+		if errors.Is(err, coldstorage.ErrStorage) {
+			log.Printf("Error loading history for %s: %v", roomID, err)
+			return nil, http.StatusBadGateway, fmt.Errorf("cold storage unavailable")
+		}
+		if err != nil {
+			log.Printf("Error loading history for %s: %v", roomID, err)
+			return nil, http.StatusInternalServerError, fmt.Errorf("could not load history")
+		}
+		body["messages"] = messages
+		body["hasMore"] = more
+		body["limit"] = limit
+		return body, http.StatusOK, nil
+	}
+
+	switch view {
+	case viewState:
+		messages, err := h.hub.History.Messages(roomID)
+		if err != nil {
+			log.Printf("Error loading history for %s: %v", roomID, err)
+			return nil, http.StatusInternalServerError, fmt.Errorf("could not load history")
+		}
+		body["messages"] = messages
+
+	case viewEvents:
+		events, err := h.hub.History.Events(roomID)
+		if err != nil {
+			log.Printf("Error loading history for %s: %v", roomID, err)
+			return nil, http.StatusInternalServerError, fmt.Errorf("could not load history")
+		}
+		body["events"] = events
+
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("view must be %q or %q", viewState, viewEvents)
+	}
+
+	return body, http.StatusOK, nil
+}
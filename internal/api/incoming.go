@@ -0,0 +1,123 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"realtime-chat/internal/auth"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/webhook"
+	"realtime-chat/internal/websocket"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxIncomingBody is the largest payload an incoming webhook accepts
+	maxIncomingBody = 64 << 10
+
+	// maxIncomingLength is the longest message an incoming webhook posts, in characters
+	maxIncomingLength = 4000
+
+	// maxIncomingUsername is the longest name a payload can post under, in characters
+	maxIncomingUsername = 32
+
+	// incomingUsername is who webhook messages are posted as unless the payload names someone
+	incomingUsername = "webhook"
+)
+
+// postIncoming handles POST /api/hooks/{id}/{token} and posts a Slack
+// incoming webhook payload, sent as JSON or as a form's payload field, to
+// the room. The token is the room's incoming webhook token, or an API key
+// with the post scope whose service account then posts the message.
+// Responses use Slack's plain-text codes so Slack tooling can be pointed at
+// the room unchanged.
+func (h *Handler) postIncoming(w http.ResponseWriter, r *http.Request) {
+	room, exists := h.room(r, r.PathValue("id"))
+	if !exists {
+		writeSlack(w, http.StatusNotFound, "no_service")
+		return
+	}
+	var key *auth.APIKey
+	if token := r.PathValue("token"); auth.IsAPIKey(token) {
+		var ok bool
+		if key, ok = h.hub.Auth.APIKey(token); !ok || !key.Allows(auth.ScopePost) {
+			writeSlack(w, http.StatusNotFound, "no_service")
+			return
+		}
+		if !h.hub.AllowKeyRequest(key) {
+			w.Header().Set("Retry-After", "60")
+			writeSlack(w, http.StatusTooManyRequests, "rate_limited")
+			return
+		}
+		if room.IsBanned(key.Username) || !h.hub.Roles.Can(key.Username, room.ID, rbac.PermPost) || !room.CanPost(key.Username) {
+			writeSlack(w, http.StatusForbidden, "action_prohibited")
+			return
+		}
+	} else if incoming := room.GetIncomingToken(); incoming == "" || subtle.ConstantTimeCompare([]byte(token), []byte(incoming)) != 1 {
+		writeSlack(w, http.StatusNotFound, "no_service")
+		return
+	}
+
+	// Tools often send JSON labelled as a form, so a form without a payload
+	// field is read as JSON too
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIncomingBody))
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		if form, formErr := url.ParseQuery(string(payload)); formErr == nil && form.Has("payload") {
+			payload = []byte(form.Get("payload"))
+		}
+	}
+	var msg webhook.SlackMessage
+	if err != nil || json.Unmarshal(payload, &msg) != nil {
+		writeSlack(w, http.StatusBadRequest, "invalid_payload")
+		return
+	}
+
+	content := msg.Render()
+	switch {
+	case content == "":
+		writeSlack(w, http.StatusBadRequest, "no_text")
+		return
+	case utf8.RuneCountInString(content) > maxIncomingLength:
+		writeSlack(w, http.StatusBadRequest, "msg_too_long")
+		return
+	}
+
+	// Payloads may pick a name, but not one an account or guest has
+	// claimed; API keys post as their service account
+	username := strings.TrimSpace(msg.Username)
+	switch {
+	case key != nil:
+		username = key.Username
+	case username == "" || utf8.RuneCountInString(username) > maxIncomingUsername || h.hub.Auth.Reserved(username):
+		username = incomingUsername
+	}
+
+	err = websocket.PostBotMessage(h.hub, room.ID, username, content)
+	if errors.Is(err, websocket.ErrEncryptedRoom) {
+		writeSlack(w, http.StatusForbidden, "action_prohibited")
+		return
+	}
+	if errors.Is(err, websocket.ErrArchivedRoom) {
+		writeSlack(w, http.StatusForbidden, "channel_is_archived")
+		return
+	}
+	if err != nil {
+		log.Printf("Error posting incoming webhook message to room %s: %v", room.ID, err)
+		writeSlack(w, http.StatusInternalServerError, "posting_failed")
+		return
+	}
+	writeSlack(w, http.StatusOK, "ok")
+}
+
+// writeSlack writes one of Slack's plain-text webhook responses
+func writeSlack(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, code)
+}
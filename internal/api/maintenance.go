@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"realtime-chat/internal/maintenance"
+)
+
+// maintenanceReport handles GET /api/admin/maintenance and returns the report
+// of the most recent cleanup; report is null if none has run yet
+func (h *Handler) maintenanceReport(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"report": h.hub.Maintenance.LastReport(),
+	})
+}
+
+// runMaintenance handles POST /api/admin/maintenance/run, running a cleanup
+// immediately and returning its report
+func (h *Handler) runMaintenance(w http.ResponseWriter, r *http.Request) {
+	report, err := h.hub.Maintenance.Run(h.hub.HistoryIDs)
+	if errors.Is(err, maintenance.ErrRunning) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"report": report,
+	})
+}
+
+// compactColdStorage handles POST /api/admin/cold-storage/compact, moving
+// messages past the threshold to cold storage immediately and returning the
+// compaction's report
+func (h *Handler) compactColdStorage(w http.ResponseWriter, r *http.Request) {
+	if h.hub.ColdStorage == nil {
+		writeError(w, http.StatusNotFound, "cold storage isn't configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"report": h.hub.ColdStorage.Compact(r.Context(), h.hub.HistoryIDs()),
+	})
+}
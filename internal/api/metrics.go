@@ -0,0 +1,14 @@
+package api
+
+import (
+	"net/http"
+	"realtime-chat/internal/metrics"
+)
+
+// frameMetrics handles GET /api/admin/metrics/frames and returns frame counts
+// and latency percentiles per stage and frame type, for SLO dashboards
+func (h *Handler) frameMetrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"stages": metrics.Frames(),
+	})
+}
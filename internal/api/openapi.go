@@ -0,0 +1,444 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"realtime-chat/internal/auth"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPIVersion is the version of the OpenAPI specification the document follows
+const openAPIVersion = "3.0.3"
+
+// pathParam matches the wildcards of a route pattern
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// pathParams describes the path wildcards routes share; a route's own
+// params take precedence
+var pathParams = map[string]string{
+	"id":        "Room ID",
+	"roomId":    "Room ID",
+	"username":  "Username",
+	"with":      "Username of the other user in the conversation",
+	"shortcode": "Emoji shortcode, without colons",
+	"provider":  "Login provider, such as github or google",
+	"token":     "Secret of the room's incoming webhook",
+}
+
+// schema is an OpenAPI schema object
+type schema map[string]interface{}
+
+// fields describes a JSON object that handlers build as a map. Each value
+// is a schema, or a Go value whose type gives the field's schema.
+type fields map[string]interface{}
+
+// arrayItems describes a JSON array whose items are a schema, fields or a Go value
+type arrayItems struct {
+	of interface{}
+}
+
+// arrayOf returns the description of an array of v
+func arrayOf(v interface{}) arrayItems {
+	return arrayItems{of: v}
+}
+
+// enum returns the schema of a string limited to values
+func enum(values ...string) schema {
+	return schema{"type": "string", "enum": values}
+}
+
+// param is a query or form parameter of a route
+type param struct {
+	name        string
+	description string
+	schema      schema // A plain string when nil
+	required    bool
+}
+
+// response is a successful response of a route
+type response struct {
+	status      int
+	description string
+
+	// A schema, fields or Go value describing the JSON body; nil for no JSON body
+	body interface{}
+
+	// Content types the body is also, or otherwise, served as
+	contentTypes []string
+}
+
+// openAPI builds the OpenAPI document describing routes
+func openAPI(routes []route) map[string]interface{} {
+	s := &schemas{
+		named: map[string]schema{
+			"Error": {
+				"type":       "object",
+				"properties": map[string]interface{}{"error": schema{"type": "string"}},
+				"required":   []string{"error"},
+			},
+		},
+		types: make(map[reflect.Type]string),
+	}
+
+	paths := make(map[string]map[string]interface{})
+	for _, rt := range routes {
+		method, path, _ := strings.Cut(rt.pattern, " ")
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(method)] = s.operation(path, rt)
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "Realtime Chat API",
+			"version":     "1.0.0",
+			"description": "REST API of the chat server. Messages are sent and received over the WebSocket at /ws.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": s.named,
+			"securitySchemes": map[string]interface{}{
+				"adminToken": schema{"type": "http", "scheme": "bearer", "description": "The server's admin token"},
+				"session":    schema{"type": "http", "scheme": "bearer", "description": "Session token of a logged-in account"},
+				"apiKey":     schema{"type": "http", "scheme": "bearer", "description": "API key of a service account"},
+				"sessionCookie": schema{
+					"type": "apiKey", "in": "cookie", "name": auth.SessionCookie,
+					"description": "Session cookie set by logging in",
+				},
+			},
+		},
+	}
+}
+
+// operation builds the OpenAPI operation of a route
+func (s *schemas) operation(path string, rt route) map[string]interface{} {
+	name := rt.name
+	if name == "" {
+		name = handlerName(rt.handler)
+	}
+	op := map[string]interface{}{
+		"operationId": name,
+		"summary":     rt.summary,
+		"tags":        []string{rt.tag},
+	}
+	if rt.description != "" {
+		op["description"] = rt.description
+	}
+
+	var parameters []interface{}
+	for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+		description := rt.params[match[1]]
+		if description == "" {
+			description = pathParams[match[1]]
+		}
+		parameters = append(parameters, map[string]interface{}{
+			"name": match[1], "in": "path", "required": true,
+			"description": description, "schema": schema{"type": "string"},
+		})
+	}
+	for _, p := range rt.query {
+		parameters = append(parameters, map[string]interface{}{
+			"name": p.name, "in": "query", "required": p.required,
+			"description": p.description, "schema": p.typ(),
+		})
+	}
+	if parameters != nil {
+		op["parameters"] = parameters
+	}
+
+	switch {
+	case rt.body != nil:
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": s.of(rt.body)}},
+		}
+	case rt.form != nil:
+		properties := make(map[string]interface{})
+		var required []string
+		for _, p := range rt.form {
+			properties[p.name] = merge(p.typ(), schema{"description": p.description})
+			if p.required {
+				required = append(required, p.name)
+			}
+		}
+		form := schema{"type": "object", "properties": properties}
+		if required != nil {
+			form["required"] = required
+		}
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"multipart/form-data": map[string]interface{}{"schema": form}},
+		}
+	}
+
+	responses := make(map[string]interface{})
+	for _, res := range rt.responses {
+		out := map[string]interface{}{"description": res.description}
+		content := make(map[string]interface{})
+		if res.body != nil {
+			content["application/json"] = map[string]interface{}{"schema": s.of(res.body)}
+		}
+		for _, contentType := range res.contentTypes {
+			content[contentType] = map[string]interface{}{"schema": schema{"type": "string", "format": "binary"}}
+		}
+		if len(content) > 0 {
+			out["content"] = content
+		}
+		responses[strconv.Itoa(res.status)] = out
+	}
+
+	errors := make(map[int]string)
+	switch rt.access {
+	case accessUser:
+		errors[http.StatusUnauthorized] = "The username is claimed and the request has no session"
+		errors[http.StatusForbidden] = "The session or API key belongs to another user, or the API key lacks the post scope"
+		errors[http.StatusTooManyRequests] = "The API key is over its rate limit"
+		op["security"] = []interface{}{
+			map[string]interface{}{"session": []string{}},
+			map[string]interface{}{"sessionCookie": []string{}},
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{},
+		}
+	case accessSelf:
+		errors[http.StatusUnauthorized] = "The request has no session or API key"
+		errors[http.StatusForbidden] = "The session or API key belongs to another user, or the API key lacks the read scope"
+		errors[http.StatusTooManyRequests] = "The API key is over its rate limit"
+		op["security"] = []interface{}{
+			map[string]interface{}{"session": []string{}},
+			map[string]interface{}{"sessionCookie": []string{}},
+			map[string]interface{}{"apiKey": []string{}},
+		}
+	case accessAdmin:
+		errors[http.StatusUnauthorized] = "Neither the admin token, a global admin's session nor an API key was given"
+		errors[http.StatusForbidden] = "The API key lacks the admin scope"
+		errors[http.StatusNotFound] = "The admin API is disabled"
+		errors[http.StatusTooManyRequests] = "The API key is over its rate limit"
+		op["security"] = []interface{}{
+			map[string]interface{}{"adminToken": []string{}},
+			map[string]interface{}{"session": []string{}},
+			map[string]interface{}{"sessionCookie": []string{}},
+			map[string]interface{}{"apiKey": []string{}},
+		}
+	}
+	if rt.workspace {
+		if _, ok := errors[http.StatusUnauthorized]; !ok {
+			errors[http.StatusUnauthorized] = "The workspace doesn't let guests in and the request has no session or API key"
+		}
+		if forbidden, ok := errors[http.StatusForbidden]; ok {
+			errors[http.StatusForbidden] = forbidden + ", or the workspace is open only to its members"
+		} else {
+			errors[http.StatusForbidden] = "The workspace is open only to its members"
+		}
+	}
+	for status, description := range rt.errors {
+		errors[status] = description
+	}
+	for status, description := range errors {
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": description,
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": ref("Error")}},
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// typ returns the schema of a parameter
+func (p param) typ() schema {
+	if p.schema == nil {
+		return schema{"type": "string"}
+	}
+	return p.schema
+}
+
+// schemas builds the OpenAPI schemas of Go types, naming each struct type
+// under components so it is described once and shared by reference
+type schemas struct {
+	named map[string]schema
+	types map[reflect.Type]string // Component name of each named struct type
+}
+
+// of returns the schema described by v: a schema, fields, arrayItems or a Go value
+func (s *schemas) of(v interface{}) schema {
+	switch v := v.(type) {
+	case schema:
+		return v
+	case fields:
+		properties := make(map[string]interface{}, len(v))
+		for name, field := range v {
+			properties[name] = s.of(field)
+		}
+		return schema{"type": "object", "properties": properties}
+	case arrayItems:
+		return schema{"type": "array", "items": s.of(v.of)}
+	default:
+		return s.reflect(reflect.TypeOf(v))
+	}
+}
+
+// reflect returns the schema of the JSON encoding of t
+func (s *schemas) reflect(t reflect.Type) schema {
+	if t == nil {
+		return schema{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return schema{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return schema{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return schema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return schema{"type": "string", "format": "byte"}
+		}
+		return schema{"type": "array", "items": s.reflect(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": s.reflect(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return s.component(t)
+	default:
+		return schema{}
+	}
+}
+
+// component returns a reference to the schema of a named struct type,
+// describing the type on first use. Components are named after the type,
+// qualified by its package unless the name already starts with it, such as
+// ProfileUpdate and MaintenanceReport.
+func (s *schemas) component(t reflect.Type) schema {
+	if name, ok := s.types[t]; ok {
+		return ref(name)
+	}
+
+	pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+	name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	if !strings.HasPrefix(strings.ToLower(name), pkg) {
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	s.types[t] = name
+	s.named[name] = schema{} // Placeholder so recursive types end
+	s.named[name] = s.object(t)
+	return ref(name)
+}
+
+// object returns the schema of a struct's JSON encoding
+func (s *schemas) object(t reflect.Type) schema {
+	properties := make(map[string]interface{})
+	s.collect(t, properties)
+	return schema{"type": "object", "properties": properties}
+}
+
+// collect adds the JSON fields of a struct, including those of its embedded structs
+func (s *schemas) collect(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				s.collect(embedded, properties)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.reflect(field.Type)
+	}
+}
+
+// ref returns a reference to a component schema
+func ref(name string) schema {
+	return schema{"$ref": "#/components/schemas/" + name}
+}
+
+// merge returns a copy of a with the entries of b added
+func merge(a, b schema) schema {
+	out := make(schema, len(a)+len(b))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		out[k] = v
+	}
+	return out
+}
+
+// swaggerUIVersion is the release of swagger-ui-dist the docs page loads
+const swaggerUIVersion = "5.17.14"
+
+// swaggerUI is the page browsing the OpenAPI document with Swagger UI
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Realtime Chat API</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui-bundle.js" crossorigin></script>
+    <script>
+        window.onload = () => {
+            window.ui = SwaggerUIBundle({ url: "openapi.json", dom_id: "#swagger-ui" });
+        };
+    </script>
+</body>
+</html>
+`
+
+// openAPIDocument handles GET /api/openapi.json and returns the OpenAPI
+// document describing the REST API
+func (h *Handler) openAPIDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(h.spec)
+}
+
+// apiDocs handles GET /api/docs and serves Swagger UI for the OpenAPI
+// document. Swagger UI comes from a CDN, so the page is sandboxed in an
+// origin of its own: its scripts can't read the chat's cookies or make
+// requests with them.
+func (h *Handler) apiDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox allow-scripts allow-popups")
+	fmt.Fprint(w, swaggerUI)
+}
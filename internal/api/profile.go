@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"realtime-chat/internal/profile"
+	"strconv"
+)
+
+// getProfile handles GET /api/users/{username}/profile
+func (h *Handler) getProfile(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.hub.Profiles.Get(r.PathValue("username")))
+}
+
+// updateProfile handles PUT /api/users/{username}/profile with a JSON body
+// holding any of "displayName", "bio", "status", "email" and "emailDigests"
+func (h *Handler) updateProfile(w http.ResponseWriter, r *http.Request) {
+	var update profile.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, "expected a JSON object with displayName, bio, status, email or emailDigests")
+		return
+	}
+
+	updated, err := h.hub.Profiles.Update(r.PathValue("username"), update)
+	if errors.Is(err, profile.ErrInvalidProfile) || errors.Is(err, profile.ErrInvalidEmail) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating profile: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not save profile")
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// uploadAvatar handles POST /api/users/{username}/avatar, a multipart form
+// with an "image" file
+func (h *Handler) uploadAvatar(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, profile.MaxAvatarUpload+64<<10)
+	if err := r.ParseMultipartForm(profile.MaxAvatarUpload); err != nil {
+		writeError(w, http.StatusBadRequest, "expected a multipart form with an image of at most 5 MiB")
+		return
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "an image file is required")
+		return
+	}
+	defer file.Close()
+
+	image, err := io.ReadAll(io.LimitReader(file, profile.MaxAvatarUpload+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "could not read image")
+		return
+	}
+
+	updated, err := h.hub.Profiles.SetAvatar(r.PathValue("username"), image)
+	if errors.Is(err, profile.ErrInvalidAvatar) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving avatar: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not save avatar")
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// avatarImage handles GET /api/users/{username}/avatar and serves the avatar
+// as PNG, in the smallest stored size at least ?size= pixels wide
+func (h *Handler) avatarImage(w http.ResponseWriter, r *http.Request) {
+	size := 0
+	if value := r.URL.Query().Get("size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "size must be a positive integer")
+			return
+		}
+		size = n
+	}
+
+	image, err := h.hub.Profiles.Avatar(r.PathValue("username"), size)
+	if errors.Is(err, profile.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading avatar of %s: %v", r.PathValue("username"), err)
+		writeError(w, http.StatusInternalServerError, "could not read avatar")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(image)
+}
+
+// notificationLevels handles GET /api/users/{username}/notifications and
+// returns the user's notification level of every room that isn't the default
+func (h *Handler) notificationLevels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"default": profile.LevelMentions,
+		"rooms":   h.hub.Profiles.NotificationLevels(r.PathValue("username")),
+	})
+}
+
+// setNotificationLevel handles PUT /api/users/{username}/notifications/{roomId}
+// with a JSON body holding the room's "level": "all", "mentions" or "muted"
+func (h *Handler) setNotificationLevel(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "expected a JSON object with a level")
+		return
+	}
+
+	rm, exists := h.room(r, r.PathValue("roomId"))
+	if !exists {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	roomID := rm.ID
+
+	username := r.PathValue("username")
+	err := h.hub.Profiles.SetNotificationLevel(username, roomID, body.Level)
+	if errors.Is(err, profile.ErrInvalidLevel) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving notification level: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not save notification level")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"roomId": roomID,
+		"level":  h.hub.Profiles.NotificationLevel(username, roomID),
+	})
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/report"
+	"realtime-chat/internal/store"
+)
+
+// listReports handles GET /api/admin/reports and returns the moderation
+// queue oldest first, optionally filtered by ?status= and ?room=
+func (h *Handler) listReports(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", report.StatusOpen, report.StatusResolved, report.StatusDismissed:
+	default:
+		writeError(w, http.StatusBadRequest, "status must be open, resolved or dismissed")
+		return
+	}
+
+	reports := h.hub.Reports.List(status, r.URL.Query().Get("room"))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"reports": reports,
+		"count":   len(reports),
+	})
+}
+
+// resolveReport handles POST /api/admin/reports/{id}/resolve with a JSON body
+// holding the new "status" (resolved or dismissed) and an optional "note",
+// and tells the room's online moderators
+func (h *Handler) resolveReport(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "expected a JSON object with a status")
+		return
+	}
+
+	by, _ := h.adminActor(r)
+	rep, err := h.hub.Reports.Resolve(r.PathValue("id"), by, body.Status, body.Note)
+	switch {
+	case errors.Is(err, report.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, report.ErrInvalidStatus):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, report.ErrClosed):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error resolving report: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not resolve report")
+		return
+	}
+
+	h.hub.Audit.Record(store.AuditRecord{
+		Actor:   by,
+		Action:  audit.ActionResolveReport,
+		Target:  rep.Author,
+		RoomID:  rep.RoomID,
+		Reason:  rep.Note,
+		Details: map[string]string{"reportId": rep.ID, "messageId": rep.MessageID, "status": rep.Status},
+	})
+
+	h.hub.NotifyModerators(rep.RoomID, map[string]interface{}{
+		"type":   "report_resolved",
+		"roomId": rep.RoomID,
+		"report": rep,
+	})
+	writeJSON(w, http.StatusOK, rep)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/store"
+)
+
+// listRoles handles GET /api/admin/roles and returns the default role, the
+// global roles and every room's roles
+func (h *Handler) listRoles(w http.ResponseWriter, r *http.Request) {
+	global, rooms := h.hub.Roles.Assignments()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"default": h.hub.Roles.Default(),
+		"global":  global,
+		"rooms":   rooms,
+	})
+}
+
+// assignRole handles PUT /api/admin/roles/{username} and
+// PUT /api/admin/rooms/{id}/roles/{username} with a JSON body holding the
+// "role"; an empty role removes the assignment
+func (h *Handler) assignRole(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Role rbac.Role `json:"role"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "expected a JSON object with a role")
+		return
+	}
+
+	roomID := r.PathValue("id")
+	if roomID != "" {
+		if _, exists := h.hub.RoomManager.GetRoom(roomID); !exists {
+			writeError(w, http.StatusNotFound, "room not found")
+			return
+		}
+	}
+
+	username := r.PathValue("username")
+	err := h.hub.Roles.Assign(roomID, username, body.Role)
+	if errors.Is(err, rbac.ErrInvalidRole) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error assigning role: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not assign role")
+		return
+	}
+
+	actor, _ := h.adminActor(r)
+	h.hub.Audit.Record(store.AuditRecord{
+		Actor:   actor,
+		Action:  audit.ActionSetRole,
+		Target:  username,
+		RoomID:  roomID,
+		Details: map[string]string{"role": string(body.Role)},
+	})
+
+	// Report the role that now applies, which may differ from the one assigned
+	role := h.hub.Roles.Role(username, roomID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"username":    username,
+		"roomId":      roomID,
+		"role":        role,
+		"permissions": role.Permissions(),
+	})
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/workspace"
+	"strconv"
+)
+
+// room returns a room of the workspace a request addresses
+func (h *Handler) room(r *http.Request, roomID string) (*room.Room, bool) {
+	rm, exists := h.hub.Room(workspace.FromContext(r.Context()), roomID)
+	if !exists || rm.IsGroup() {
+		return nil, false
+	}
+	return rm, true
+}
+
+// listRooms handles GET /api/rooms?username=name&archived=true and returns
+// every room of the workspace with its member count, message count and last
+// message. When username is given, each room also includes that user's
+// unread count. Archived rooms are listed only when archived is true.
+func (h *Handler) listRooms(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	rooms := h.hub.RoomList(workspace.FromContext(r.Context()), query.Get("username"), query.Get("archived") == "true")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"rooms": rooms,
+		"count": len(rooms),
+	})
+}
+
+// getRoom handles GET /api/rooms/{id}?username=name and returns one room of
+// the workspace, found by its ID or slug, as listRooms lists it
+func (h *Handler) getRoom(w http.ResponseWriter, r *http.Request) {
+	rm, exists := h.room(r, r.PathValue("id"))
+	if !exists {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.hub.RoomSummary(rm, r.URL.Query().Get("username")))
+}
+
+// discoverRooms handles GET /api/rooms/discover?q=&tag=&sort=&limit=&offset=
+// and returns a page of the workspace's rooms matching the search, with the
+// featured rooms and the tags in use
+func (h *Handler) discoverRooms(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := hub.Discovery{Query: query.Get("q"), Tag: query.Get("tag"), Sort: query.Get("sort")}
+	if !hub.ValidSort(q.Sort) {
+		writeError(w, http.StatusBadRequest, "sort must be active, members, new or name")
+		return
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > hub.MaxDiscoverLimit {
+			writeError(w, http.StatusBadRequest, "limit must be a number from 1 to "+strconv.Itoa(hub.MaxDiscoverLimit))
+			return
+		}
+		q.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must not be negative")
+			return
+		}
+		q.Offset = offset
+	}
+
+	writeJSON(w, http.StatusOK, h.hub.Discover(workspace.FromContext(r.Context()), query.Get("username"), q))
+}
+
+// featureRoom handles PUT /api/admin/rooms/{id}/featured with a JSON body
+// holding "featured", and shows the room first in room discovery or stops
+func (h *Handler) featureRoom(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Featured *bool `json:"featured"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil || body.Featured == nil {
+		writeError(w, http.StatusBadRequest, "expected a JSON object with featured")
+		return
+	}
+
+	rm, exists := h.hub.RoomManager.GetRoom(r.PathValue("id"))
+	if !exists {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	rm.SetFeatured(*body.Featured)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"roomId":   rm.ID,
+		"featured": *body.Featured,
+	})
+}
+
+// archiveRoom handles PUT /api/admin/rooms/{id}/archived with a JSON body
+// holding "archived", and archives the room read-only or restores it
+func (h *Handler) archiveRoom(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Archived *bool `json:"archived"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil || body.Archived == nil {
+		writeError(w, http.StatusBadRequest, "expected a JSON object with archived")
+		return
+	}
+
+	rm, exists := h.hub.RoomManager.GetRoom(r.PathValue("id"))
+	if !exists {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	if room.IsLobby(rm.ID) {
+		writeError(w, http.StatusBadRequest, "the lobby can't be archived")
+		return
+	}
+	actor, _ := h.adminActor(r)
+	h.hub.ArchiveRoom(rm, *body.Archived, actor, "")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"roomId":   rm.ID,
+		"archived": *body.Archived,
+	})
+}
+
+// verifyProjections handles GET /api/admin/projections/verify and reports
+// rooms whose projections no longer match their history
+func (h *Handler) verifyProjections(w http.ResponseWriter, r *http.Request) {
+	drifted := h.hub.Projections.Verify(h.hub.RoomIDs())
+	if drifted == nil {
+		drifted = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"drifted": drifted,
+		"ok":      len(drifted) == 0,
+	})
+}
+
+// rebuildProjections handles POST /api/admin/projections/rebuild?room=id and
+// recomputes the projections of one room, or of every room when room is unset
+func (h *Handler) rebuildProjections(w http.ResponseWriter, r *http.Request) {
+	roomIDs := h.hub.RoomIDs()
+	if roomID := r.URL.Query().Get("room"); roomID != "" {
+		if _, exists := h.hub.RoomManager.GetRoom(roomID); !exists {
+			writeError(w, http.StatusNotFound, "room not found")
+			return
+		}
+		roomIDs = []string{roomID}
+	}
+
+	h.hub.Projections.Rebuild(roomIDs)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"rebuilt": roomIDs,
+	})
+}
@@ -0,0 +1,1120 @@
+package api
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"realtime-chat/internal/analytics"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/auth"
+	"realtime-chat/internal/coldstorage"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/emoji"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/ledger"
+	"realtime-chat/internal/maintenance"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/profile"
+	"realtime-chat/internal/projection"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/report"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/search"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/webhook"
+	"realtime-chat/internal/workspace"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Who may call a route
+const (
+	accessPublic = iota
+
+	// accessUser routes change a user's settings and pass through requireUser
+	accessUser
+
+	// accessSelf routes read a user's private messages and pass through
+	// requireSelf
+	accessSelf
+
+	// accessAdmin routes pass through requireAdmin
+	accessAdmin
+)
+
+// route is one REST endpoint. Register serves it and the OpenAPI document
+// describes it, so the two can't drift apart.
+type route struct {
+	pattern string // Method and path, as given to http.ServeMux
+	handler http.HandlerFunc
+	access  int
+
+	// Reads the workspace's rooms or messages, so passes through
+	// requireWorkspace
+	workspace bool
+
+	// Operation ID; the handler's name when empty
+	name string
+
+	tag         string
+	summary     string
+	description string
+
+	// Descriptions of path wildcards that differ from pathParams
+	params map[string]string
+
+	query []param
+
+	// JSON request body as a schema, fields or Go value, or a multipart form
+	body interface{}
+	form []param
+
+	responses []response
+	errors    map[int]string // Error responses by status code
+}
+
+// Error descriptions shared by routes
+const (
+	errBadRequest  = "The request is invalid"
+	errRoomMissing = "The room doesn't exist"
+	errInternal    = "The server couldn't complete the request"
+	errLoggedOut   = "The request isn't logged in or its session needs a second factor first"
+)
+
+// exportFormats are the content types exports are served as besides JSON
+var exportFormats = []string{contentTypes[formatCSV], contentTypes[formatText]}
+
+// routes returns every REST endpoint with the documentation of its operation
+func (h *Handler) routes() []route {
+	formatParam := param{name: "format", description: "File format; json by default", schema: enum(formatJSON, formatCSV, formatText)}
+	viewParam := param{name: "view", description: "History view; state by default", schema: enum(viewState, viewEvents)}
+	secondsParam := param{name: "seconds", description: "How long to record for", schema: schema{"type": "integer", "minimum": 1}}
+	limitParam := param{name: "limit", description: "Most entries to return", schema: schema{"type": "integer", "minimum": 1}}
+	roomSummary := fields{
+		"id":           "",
+		"name":         "",
+		"slug":         schema{"type": "string", "description": "Readable name the room is joined and linked by; empty when it has none"},
+		"mode":         "",
+		"topic":        "",
+		"description":  "",
+		"tags":         []string{},
+		"featured":     false,
+		"archived":     schema{"type": "boolean", "description": "Whether the room is archived and read-only"},
+		"joinRule":     enum(string(room.JoinOpen), string(room.JoinKnock)),
+		"messageTtl":   schema{"type": "integer", "description": "Seconds messages last before disappearing; 0 keeps them"},
+		"clientCount":  0,
+		"messageCount": 0,
+		"lastMessage":  &projection.Preview{},
+		"createdBy":    "",
+		"createdAt":    time.Time{},
+		"unread":       schema{"type": "integer", "description": "Unread messages of the user given as ?username="},
+	}
+	groupSummary := fields{"participants": []string{}}
+	for name, value := range roomSummary {
+		groupSummary[name] = value
+	}
+	deletion := fields{"username": "", "deletion": &store.DeletionRequest{}}
+
+	return []route{
+		{
+			pattern: "GET /api/rooms", handler: h.listRooms, workspace: true, tag: "rooms",
+			summary: "List rooms with their member count, message count and last message",
+			query: []param{
+				{name: "username", description: "Include this user's unread count in each room"},
+				{name: "archived", description: "Include archived rooms when true", schema: schema{"type": "boolean"}},
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The rooms", body: fields{"rooms": arrayOf(roomSummary), "count": 0}},
+			},
+		},
+		{
+			pattern: "GET /api/rooms/discover", handler: h.discoverRooms, workspace: true, tag: "rooms",
+			summary: "Search and browse rooms a page at a time",
+			description: "Returns the matching rooms, how many match in all, the featured rooms and how many rooms have " +
+				"each tag, most used first. Support conversations aren't listed.",
+			query: []param{
+				{name: "q", description: "Words the room's name, topic, description or tags must all contain"},
+				{name: "tag", description: "Only rooms with this tag"},
+				{name: "sort", description: "Order of the rooms; active by default", schema: enum(hub.SortActive, hub.SortMembers, hub.SortNew, hub.SortName)},
+				{name: "limit", description: "Most rooms to return; 20 by default", schema: schema{"type": "integer", "minimum": 1, "maximum": hub.MaxDiscoverLimit}},
+				{name: "offset", description: "Matching rooms to skip", schema: schema{"type": "integer", "minimum": 0}},
+				{name: "username", description: "Include this user's unread count in each room"},
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The rooms", body: fields{
+					"rooms":    arrayOf(roomSummary),
+					"total":    0,
+					"offset":   0,
+					"limit":    0,
+					"featured": arrayOf(roomSummary),
+					"tags":     arrayOf(fields{"tag": "", "count": 0}),
+				}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest},
+		},
+		{
+			pattern: "GET /api/rooms/{id}", handler: h.getRoom, workspace: true, tag: "rooms",
+			summary: "Get a room by its ID or slug",
+			query:   []param{{name: "username", description: "Include this user's unread count"}},
+			responses: []response{
+				{status: http.StatusOK, description: "The room", body: roomSummary},
+			},
+			errors: map[int]string{http.StatusNotFound: errRoomMissing},
+		},
+		{
+			pattern: "GET /api/rooms/{id}/history", handler: h.roomHistory, workspace: true, tag: "rooms",
+			summary: "Get a room's history",
+			description: "Returns the whole history in the requested view, or one page of the state view when " +
+				"before, after, afterSeq or limit is given.",
+			query: []param{
+				viewParam,
+				{name: "before", description: "Page of messages before this message ID"},
+				{name: "after", description: "Page of messages after this message ID"},
+				{name: "afterSeq", description: "Page of messages after this sequence number", schema: schema{"type": "integer", "format": "int64"}},
+				describe(limitParam, "Messages per page, up to the server's maximum"),
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The history", body: fields{
+					"roomId":     "",
+					"view":       "",
+					"exportedAt": time.Time{},
+					"messages":   []*history.Message{},
+					"events":     []*store.MessageEvent{},
+					"hasMore":    false,
+					"limit":      0,
+					"afterSeq":   int64(0),
+					"lastSeq":    int64(0),
+				}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusNotFound: "The room or cursor message doesn't exist", http.StatusInternalServerError: errInternal, http.StatusBadGateway: "Cold storage couldn't be reached"},
+		},
+		{
+			pattern: "GET /api/rooms/{id}/activity", handler: h.roomActivity, workspace: true, tag: "rooms",
+			summary:     "Get a room's activity hour by hour",
+			description: "Lists every hour of the range, up to 31 days, with a heatmap of the messages posted by weekday (Sunday first) and hour of the day in UTC.",
+			query: []param{
+				{name: "since", description: "The week before until by default", schema: schema{"type": "string", "format": "date-time"}},
+				{name: "until", description: "Now by default", schema: schema{"type": "string", "format": "date-time"}},
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The activity", body: &analytics.Activity{}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusNotFound: errRoomMissing},
+		},
+		{
+			pattern: "GET /api/rooms/{id}/export", handler: h.exportRoom, workspace: true, tag: "rooms",
+			summary:     "Download a room's full history",
+			description: "Only the room's owner and admins, and the admin API, may export a room. The events view is only available as JSON.",
+			query: []param{
+				formatParam,
+				viewParam,
+				{name: "username", description: "Unclaimed username making the request when there's no session"},
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The history as a file", body: fields{
+					"roomId":     "",
+					"roomName":   "",
+					"view":       "",
+					"exportedAt": time.Time{},
+					"messages":   []*history.Message{},
+					"events":     []*store.MessageEvent{},
+					"algorithm":  "",
+					"publicKey":  "",
+					"head":       "",
+				}, contentTypes: exportFormats},
+			},
+			errors: map[int]string{
+				http.StatusBadRequest:   errBadRequest,
+				http.StatusUnauthorized: "Neither a session nor an unclaimed username was given",
+				http.StatusForbidden:    "The requester may not manage the room",
+				http.StatusNotFound:     errRoomMissing,
+			},
+		},
+		{
+			pattern: "POST /api/rooms/{id}/verify", handler: h.verifyTranscript, workspace: true, tag: "rooms",
+			summary: "Check that an events export of a room is complete and unmodified",
+			description: "Checks the export's hash chain and, when history signing is on, the server's signature of every " +
+				"event, and whether the events are still part of the room's history. Only those who may export the room " +
+				"may verify it.",
+			query: []param{{name: "username", description: "Unclaimed username making the request when there's no session"}},
+			body:  fields{"roomId": "", "events": []*store.MessageEvent{}},
+			responses: []response{
+				{status: http.StatusOK, description: "The verification", body: fields{
+					"roomId":        "",
+					"algorithm":     "",
+					"publicKey":     "",
+					"result":        &ledger.Result{},
+					"matchesServer": false,
+					"serverHead":    "",
+					"upToDate":      false,
+				}},
+			},
+			errors: map[int]string{
+				http.StatusBadRequest:   "The body isn't an events export of the room",
+				http.StatusUnauthorized: "Neither a session nor an unclaimed username was given",
+				http.StatusForbidden:    "The requester may not manage the room",
+				http.StatusNotFound:     errRoomMissing,
+			},
+		},
+		{
+			pattern: "GET /api/search", handler: h.searchMessages, workspace: true, tag: "rooms",
+			summary: "Search the workspace's messages, newest first",
+			description: "Finds messages containing every word of q, in Elasticsearch or OpenSearch when the server indexes " +
+				"messages there and in each room's history otherwise. Groups and direct messages are never searched, " +
+				"and encrypted, deleted and GIF messages are never found.",
+			query: []param{
+				{name: "q", description: "Words the message must all contain; required unless author is given"},
+				{name: "room", description: "Room ID or slug; every room of the workspace by default"},
+				{name: "author", description: "Only messages posted by this user"},
+				{name: "since", schema: schema{"type": "string", "format": "date-time"}},
+				{name: "until", schema: schema{"type": "string", "format": "date-time"}},
+				{name: "limit", description: "Most messages to return; 20 by default", schema: schema{"type": "integer", "minimum": 1, "maximum": search.MaxLimit}},
+				{name: "offset", description: "Matching messages to skip", schema: schema{"type": "integer", "minimum": 0}},
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The messages found", body: fields{"results": []search.Hit{}, "total": 0, "offset": 0, "limit": 0}},
+			},
+			errors: map[int]string{
+				http.StatusBadRequest: errBadRequest,
+				http.StatusNotFound:   errRoomMissing,
+				http.StatusBadGateway: "The search index couldn't be reached",
+			},
+		},
+		{
+			pattern: "POST /api/hooks/{id}/{token}", handler: h.postIncoming, tag: "rooms",
+			summary: "Post a Slack incoming webhook payload to a room",
+			description: "Accepts Slack's text, blocks and attachments as JSON or as a form's payload field, and answers " +
+				"with Slack's plain-text codes: ok, invalid_payload, no_text, msg_too_long, no_service, action_prohibited, " +
+				"channel_is_archived, rate_limited or posting_failed. The token is the room's incoming webhook token or an API key with the post " +
+				"scope, which posts as its service account.",
+			body: &webhook.SlackMessage{},
+			responses: []response{
+				{status: http.StatusOK, description: "The message was posted", contentTypes: []string{"text/plain"}},
+				{status: http.StatusBadRequest, description: "The payload is invalid, empty or too long", contentTypes: []string{"text/plain"}},
+				{status: http.StatusNotFound, description: "The room or token is unknown", contentTypes: []string{"text/plain"}},
+				{status: http.StatusTooManyRequests, description: "The API key is over its rate limit", contentTypes: []string{"text/plain"}},
+			},
+		},
+		{
+			pattern: "GET /api/emoji", handler: h.listEmoji, tag: "emoji",
+			summary: "List custom emoji and stickers",
+			responses: []response{
+				{status: http.StatusOK, description: "The emoji", body: fields{"emoji": []*emoji.Emoji{}, "count": 0}},
+			},
+		},
+		{
+			pattern: "GET /api/emoji/{shortcode}", handler: h.emojiImage, tag: "emoji",
+			summary: "Get an emoji's image",
+			responses: []response{
+				{status: http.StatusOK, description: "The image", contentTypes: []string{"image/png", "image/gif", "image/jpeg", "image/webp"}},
+			},
+			errors: map[int]string{http.StatusNotFound: "The emoji doesn't exist"},
+		},
+		{
+			pattern: "GET /api/gifs", handler: h.searchGIFs, tag: "gifs",
+			summary: "Search GIFs",
+			description: "Searches the configured GIF provider, Giphy or Tenor, so clients can pick GIFs without its API key. " +
+				"Results are cached for a while. Post one with a `gif` frame giving its `id` as `gifId`.",
+			query: []param{
+				{name: "q", description: "Search terms; trending GIFs are returned when empty"},
+				limitParam,
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The GIFs", body: fields{"provider": enum(config.GIFGiphy, config.GIFTenor), "gifs": []*store.GIF{}, "count": 0}},
+			},
+			errors: map[int]string{
+				http.StatusBadRequest: errBadRequest,
+				http.StatusNotFound:   "GIF search isn't configured",
+				http.StatusBadGateway: "The provider couldn't be reached",
+			},
+		},
+		{
+			pattern: "GET /api/users/{username}/profile", handler: h.getProfile, tag: "users",
+			summary: "Get a user's profile",
+			responses: []response{
+				{status: http.StatusOK, description: "The profile", body: &profile.Profile{}},
+			},
+		},
+		{
+			pattern: "GET /api/users/{username}/stats", handler: h.userStats, workspace: true, tag: "users",
+			summary: "Get a user's messages, rooms joined, and when they were first and last seen in the workspace",
+			responses: []response{
+				{status: http.StatusOK, description: "The user's activity", body: &analytics.UserStats{}},
+			},
+			errors: map[int]string{http.StatusNotFound: "The user has no recorded activity"},
+		},
+		{
+			pattern: "GET /api/leaderboard", handler: h.leaderboard, workspace: true, tag: "users",
+			summary: "List the workspace's most active users",
+			query: []param{
+				{name: "sort", description: "Order of the users; messages by default", schema: enum(analytics.SortMessages, analytics.SortRooms, analytics.SortRecent)},
+				{name: "limit", description: "Most users to return; 20 by default", schema: schema{"type": "integer", "minimum": 1, "maximum": analytics.MaxLeaderboard}},
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The users", body: fields{"users": []analytics.UserStats{}, "count": 0}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest},
+		},
+		{
+			pattern: "PUT /api/users/{username}/profile", handler: h.updateProfile, access: accessUser, tag: "users",
+			summary: "Change a user's profile; fields left out are unchanged",
+			body:    &profile.Update{},
+			responses: []response{
+				{status: http.StatusOK, description: "The updated profile", body: &profile.Profile{}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest},
+		},
+		{
+			pattern: "GET /api/users/{username}/avatar", handler: h.avatarImage, tag: "users",
+			summary: "Get a user's avatar",
+			query: []param{
+				{name: "size", description: "Width in pixels the avatar is shown at; the smallest stored size at least this wide is served, the largest by default", schema: schema{"type": "integer", "minimum": 1}},
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The avatar", contentTypes: []string{"image/png"}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusNotFound: "The user has no avatar"},
+		},
+		{
+			pattern: "POST /api/users/{username}/avatar", handler: h.uploadAvatar, access: accessUser, tag: "users",
+			summary:     "Upload a user's avatar",
+			description: "The image is identified by its content, whatever its name or type, and stored without its metadata, cropped to a square at 32, 64, 128 and 256 pixels.",
+			form:        []param{{name: "image", description: "PNG, GIF or JPEG image of at most 5 MiB", schema: schema{"type": "string", "format": "binary"}, required: true}},
+			responses: []response{
+				{status: http.StatusOK, description: "The updated profile", body: &profile.Profile{}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest},
+		},
+		{
+			pattern: "GET /api/users/{username}/notifications", handler: h.notificationLevels, access: accessUser, tag: "users",
+			summary: "Get a user's notification level of every room that isn't the default",
+			responses: []response{
+				{status: http.StatusOK, description: "The notification levels", body: fields{"default": "", "rooms": map[string]string{}}},
+			},
+		},
+		{
+			pattern: "PUT /api/users/{username}/notifications/{roomId}", handler: h.setNotificationLevel, access: accessUser, tag: "users",
+			summary: "Set a user's notification level of a room",
+			body:    fields{"level": enum(profile.LevelAll, profile.LevelMentions, profile.LevelMuted)},
+			responses: []response{
+				{status: http.StatusOK, description: "The level now applying", body: fields{"roomId": "", "level": ""}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusNotFound: errRoomMissing},
+		},
+		{
+			pattern: "GET /api/users/{username}/export", handler: h.exportUser, access: accessSelf, tag: "users",
+			summary:     "Download everything stored about a user",
+			description: "Holds the user's profile, account, roles, messages and filed reports. CSV exports hold just the messages.",
+			query:       []param{formatParam},
+			responses: []response{
+				{status: http.StatusOK, description: "The data as a file", body: fields{
+					"username":   "",
+					"exportedAt": time.Time{},
+					"profile":    &store.ProfileRecord{},
+					"account":    &store.AccountRecord{},
+					"roles":      map[string]rbac.Role{},
+					"reports":    []*store.ReportRecord{},
+					"messages":   []*history.Message{},
+				}, contentTypes: exportFormats},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest},
+		},
+		{
+			pattern: "GET /api/users/{username}/messages", handler: h.userMessages, access: accessSelf, workspace: true, tag: "users",
+			summary:     "Get a page of the messages a user posted, newest first",
+			description: "Covers the workspace's rooms and the user's direct messages in it, including messages moved to cold storage. Deleted messages are listed as tombstones.",
+			query: []param{
+				{name: "room", description: "Room ID or slug; every room by default"},
+				{name: "with", description: "Only direct messages with this user"},
+				{name: "since", schema: schema{"type": "string", "format": "date-time"}},
+				{name: "until", schema: schema{"type": "string", "format": "date-time"}},
+				describe(limitParam, "Messages per page, up to the server's maximum"),
+				{name: "offset", description: "Messages to skip", schema: schema{"type": "integer", "minimum": 0}},
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The messages", body: fields{
+					"username": "",
+					"messages": []*history.Message{},
+					"total":    0,
+					"offset":   0,
+					"limit":    0,
+				}},
+			},
+			errors: map[int]string{
+				http.StatusBadRequest: errBadRequest,
+				http.StatusNotFound:   errRoomMissing,
+				http.StatusBadGateway: "Cold storage couldn't be reached",
+			},
+		},
+		{
+			pattern: "GET /api/users/{username}/deletion", handler: h.deletionStatus, access: accessUser, tag: "users",
+			summary: "Get an account's pending deletion request",
+			responses: []response{
+				{status: http.StatusOK, description: "The deletion request", body: deletion},
+			},
+			errors: map[int]string{http.StatusNotFound: "No deletion is pending"},
+		},
+		{
+			pattern: "POST /api/users/{username}/deletion", handler: h.requestDeletion, access: accessUser, tag: "users",
+			summary:     "Ask for an account to be deleted",
+			description: "The account and its data are erased once the grace period is over unless the request is cancelled first.",
+			body:        fields{"mode": merge(enum(hub.ErasureAnonymize, hub.ErasurePurge), schema{"default": hub.ErasureAnonymize})},
+			responses: []response{
+				{status: http.StatusAccepted, description: "The deletion is scheduled", body: deletion},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusNotFound: "The username isn't an account"},
+		},
+		{
+			pattern: "DELETE /api/users/{username}/deletion", handler: h.cancelDeletion, access: accessUser, tag: "users",
+			summary: "Cancel a pending deletion during its grace period",
+			responses: []response{
+				{status: http.StatusNoContent, description: "The deletion is cancelled"},
+			},
+			errors: map[int]string{http.StatusNotFound: "No deletion is pending"},
+		},
+		{
+			pattern: "GET /api/users/{username}/sessions", handler: h.listSessions, access: accessUser, tag: "users",
+			summary:     "List a user's login sessions and their connections",
+			description: "Each session is a device the user logged in on, with the browser or app that logged in, its address and the connections it has open to this server.",
+			responses: []response{
+				{status: http.StatusOK, description: "The sessions, oldest first", body: fields{"sessions": []*hub.Session{}, "count": 0}},
+			},
+		},
+		{
+			pattern: "DELETE /api/users/{username}/sessions/{id}", handler: h.revokeSession, access: accessUser, tag: "users",
+			summary:     "Log out one of a user's sessions",
+			description: "Connections made with the session are sent a session_revoked frame and closed.",
+			responses: []response{
+				{status: http.StatusNoContent, description: "The session is logged out"},
+			},
+			errors: map[int]string{http.StatusNotFound: "No such session"},
+		},
+		{
+			pattern: "DELETE /api/users/{username}/sessions", handler: h.revokeOtherSessions, access: accessUser, tag: "users",
+			summary:     "Log a user out of every other device",
+			description: "Ends every session but the one making the request and closes their connections.",
+			responses: []response{
+				{status: http.StatusOK, description: "How many sessions were ended and connections closed", body: fields{"revoked": 0, "closed": 0}},
+			},
+		},
+		{
+			pattern: "GET /api/users/{username}/conversations", handler: h.listConversations, access: accessSelf, workspace: true, tag: "users",
+			summary: "List a user's direct message conversations",
+			responses: []response{
+				{status: http.StatusOK, description: "The conversations, the most recently active first", body: fields{"conversations": []*conversation.Conversation{}, "count": 0}},
+			},
+		},
+		{
+			pattern: "GET /api/users/{username}/groups", handler: h.listGroups, access: accessSelf, workspace: true, tag: "users",
+			summary: "List a user's group conversations",
+			responses: []response{
+				{status: http.StatusOK, description: "The groups, the most recently active first", body: fields{"groups": arrayOf(groupSummary), "count": 0}},
+			},
+		},
+		{
+			pattern: "GET /api/users/{username}/conversations/{with}/messages", handler: h.conversationMessages, access: accessSelf, workspace: true, tag: "users",
+			summary: "Get a page of a user's direct messages with another user",
+			query: []param{
+				{name: "before", description: "Page of messages before this message ID"},
+				{name: "after", description: "Page of messages after this message ID"},
+				describe(limitParam, "Messages per page, up to the server's maximum"),
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The messages, oldest first", body: fields{
+					"conversationId": "",
+					"with":           "",
+					"messages":       []*history.Message{},
+					"hasMore":        false,
+					"limit":          0,
+				}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusNotFound: "The cursor message doesn't exist"},
+		},
+
+		{
+			pattern: "GET /api/auth/session", handler: h.session, tag: "auth",
+			summary: "Get who is logged in and the login providers",
+			responses: []response{
+				{status: http.StatusOK, description: "The session", body: fields{
+					"loggedIn":     false,
+					"username":     "",
+					"guest":        false,
+					"secondFactor": enum(auth.SecondFactorVerify, auth.SecondFactorEnroll),
+					"providers":    []string{},
+					"labels":       map[string]string{},
+				}},
+			},
+		},
+		{
+			pattern: "POST /api/auth/guest", handler: h.guest, tag: "auth",
+			summary:     "Get a guest identity",
+			description: "Guests chat under a generated `guest-` username but can't send direct messages or create rooms, and may be rate-limited. Logging in with a provider while holding a guest session turns it into an account with the same username. Also sets the `chat_session` cookie.",
+			responses: []response{
+				{status: http.StatusCreated, description: "The guest identity and its session token", body: fields{"username": "", "token": "", "expiresAt": time.Time{}}},
+			},
+			errors: map[int]string{http.StatusConflict: "The request is already logged in"},
+		},
+		{
+			pattern: "POST /api/auth/logout", handler: h.logout, tag: "auth",
+			summary: "End the current session and close its connections",
+			responses: []response{
+				{status: http.StatusNoContent, description: "Logged out"},
+			},
+		},
+		{
+			pattern: "GET /api/auth/2fa", handler: h.twoFactorStatus, tag: "auth",
+			summary:     "Get the logged-in account's two-factor login",
+			description: "Works for sessions still waiting for their second factor, whose `secondFactor` is `verify` or `enroll`.",
+			responses: []response{
+				{status: http.StatusOK, description: "The two-factor login", body: fields{
+					"enabled":      false,
+					"enabledAt":    time.Time{},
+					"required":     false,
+					"backupCodes":  0,
+					"secondFactor": enum(auth.SecondFactorNone, auth.SecondFactorVerify, auth.SecondFactorEnroll),
+				}},
+			},
+			errors: map[int]string{http.StatusUnauthorized: errLoggedOut},
+		},
+		{
+			pattern: "POST /api/auth/2fa/enroll", handler: h.enrollTwoFactor, tag: "auth",
+			summary:     "Start setting up an authenticator app",
+			description: "Returns the secret to add to the app and its otpauth URI to show as a QR code. Two-factor login stays off until a code confirms it.",
+			responses: []response{
+				{status: http.StatusOK, description: "The secret", body: fields{"secret": "", "uri": ""}},
+			},
+			errors: map[int]string{http.StatusUnauthorized: errLoggedOut, http.StatusConflict: "Two-factor login is already on"},
+		},
+		{
+			pattern: "POST /api/auth/2fa/enable", handler: h.enableTwoFactor, tag: "auth",
+			summary:     "Turn two-factor login on with a code from the newly enrolled app",
+			description: "Returns backup codes that are only shown this once. The account's other sessions must enter a code before they can be used again, and their connections are closed.",
+			body:        fields{"code": ""},
+			responses: []response{
+				{status: http.StatusOK, description: "The backup codes", body: fields{"backupCodes": []string{}}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusUnauthorized: errLoggedOut, http.StatusConflict: "Enrollment hasn't started or two-factor login is already on"},
+		},
+		{
+			pattern: "POST /api/auth/2fa/verify", handler: h.verifyTwoFactor, tag: "auth",
+			summary:     "Complete a login with a code from the authenticator app or a backup code",
+			description: "After five wrong codes the session is ended and the user must log in again.",
+			body:        fields{"code": ""},
+			responses: []response{
+				{status: http.StatusNoContent, description: "The session can be used"},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusUnauthorized: errLoggedOut},
+		},
+		{
+			pattern: "POST /api/auth/2fa/backup-codes", handler: h.regenerateBackupCodes, tag: "auth",
+			summary: "Replace the account's backup codes, given a code from the authenticator app",
+			body:    fields{"code": ""},
+			responses: []response{
+				{status: http.StatusOK, description: "The new backup codes", body: fields{"backupCodes": []string{}}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusUnauthorized: errLoggedOut, http.StatusConflict: "Two-factor login is off"},
+		},
+		{
+			pattern: "POST /api/auth/2fa/disable", handler: h.disableTwoFactor, tag: "auth",
+			summary: "Turn two-factor login off with a code from the authenticator app or a backup code",
+			body:    fields{"code": ""},
+			responses: []response{
+				{status: http.StatusNoContent, description: "Two-factor login is off"},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusUnauthorized: errLoggedOut, http.StatusForbidden: "The server requires two-factor login of the account", http.StatusConflict: "Two-factor login is off"},
+		},
+		{
+			pattern: "GET /api/auth/{provider}/login", handler: h.login, tag: "auth",
+			summary: "Start logging in with a provider",
+			responses: []response{
+				{status: http.StatusFound, description: "Redirect to the provider's consent page"},
+			},
+			errors: map[int]string{http.StatusNotFound: "The provider isn't configured", http.StatusBadGateway: "The single sign-on provider couldn't be reached"},
+		},
+		{
+			pattern: "GET /api/auth/{provider}/callback", handler: h.callback, tag: "auth",
+			summary: "Finish logging in with a provider",
+			query: []param{
+				{name: "code", description: "Authorization code from the provider", required: true},
+				{name: "state", description: "State given to the provider", required: true},
+			},
+			responses: []response{
+				{status: http.StatusFound, description: "Logged in; redirect to the chat"},
+			},
+			errors: map[int]string{
+				http.StatusBadRequest: "The login expired or was cancelled",
+				http.StatusNotFound:   "The provider isn't configured",
+				http.StatusConflict:   "The provider's account is linked to another user",
+				http.StatusBadGateway: "The provider couldn't verify the login",
+			},
+		},
+
+		{
+			pattern: "GET /api/admin/projections/verify", handler: h.verifyProjections, access: accessAdmin, tag: "admin",
+			summary: "Report rooms whose projections no longer match their history",
+			responses: []response{
+				{status: http.StatusOK, description: "The drifted rooms", body: fields{"drifted": []string{}, "ok": false}},
+			},
+		},
+		{
+			pattern: "POST /api/admin/projections/rebuild", handler: h.rebuildProjections, access: accessAdmin, tag: "admin",
+			summary: "Recompute the projections of one room, or of every room",
+			query:   []param{{name: "room", description: "Room to rebuild; every room when unset"}},
+			responses: []response{
+				{status: http.StatusOK, description: "The rebuilt rooms", body: fields{"rebuilt": []string{}}},
+			},
+			errors: map[int]string{http.StatusNotFound: errRoomMissing},
+		},
+		{
+			pattern: "GET /api/admin/metrics/frames", handler: h.frameMetrics, access: accessAdmin, tag: "admin",
+			summary: "Get frame counts and latency percentiles per stage and frame type",
+			responses: []response{
+				{status: http.StatusOK, description: "The frame metrics", body: fields{"stages": map[string]map[string]*metrics.FrameStats{}}},
+			},
+		},
+		{
+			pattern: "GET /api/admin/cluster", handler: h.clusterStatus, access: accessAdmin, tag: "admin",
+			summary: "Get this node, its peers and the clients connected across the cluster",
+			responses: []response{
+				{status: http.StatusOK, description: "The cluster status", body: fields{
+					"enabled":      false,
+					"node":         "",
+					"localClients": 0,
+					"peers":        arrayOf(schema{"type": "object"}),
+					"clients":      0,
+				}},
+			},
+		},
+		{
+			pattern: "GET /api/admin/maintenance", handler: h.maintenanceReport, access: accessAdmin, tag: "admin",
+			summary: "Get the report of the most recent cleanup; null if none has run",
+			responses: []response{
+				{status: http.StatusOK, description: "The report", body: fields{"report": &maintenance.Report{}}},
+			},
+		},
+		{
+			pattern: "POST /api/admin/maintenance/run", handler: h.runMaintenance, access: accessAdmin, tag: "admin",
+			summary: "Run a cleanup now",
+			responses: []response{
+				{status: http.StatusOK, description: "The cleanup's report", body: fields{"report": &maintenance.Report{}}},
+			},
+			errors: map[int]string{http.StatusConflict: "A cleanup is already running", http.StatusInternalServerError: errInternal},
+		},
+		{
+			pattern: "POST /api/admin/cold-storage/compact", handler: h.compactColdStorage, access: accessAdmin, tag: "admin",
+			summary:     "Move messages past the threshold to cold storage now",
+			description: "Compaction otherwise runs after every scheduled cleanup. Problems with single rooms are listed in the report's errors.",
+			responses: []response{
+				{status: http.StatusOK, description: "The compaction's report", body: fields{"report": &coldstorage.Report{}}},
+			},
+			errors: map[int]string{http.StatusNotFound: "No cold storage is configured"},
+		},
+		{
+			pattern: "POST /api/admin/emoji", handler: h.registerEmoji, access: accessAdmin, tag: "admin",
+			summary: "Register a custom emoji or sticker",
+			form: []param{
+				{name: "shortcode", description: "Name used as :shortcode:", required: true},
+				{name: "kind", description: "emoji by default", schema: enum(emoji.KindEmoji, emoji.KindSticker)},
+				{name: "image", description: "Image of at most 256 KiB", schema: schema{"type": "string", "format": "binary"}, required: true},
+			},
+			responses: []response{
+				{status: http.StatusCreated, description: "The registered emoji", body: &emoji.Emoji{}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest},
+		},
+		{
+			pattern: "DELETE /api/admin/emoji/{shortcode}", handler: h.removeEmoji, access: accessAdmin, tag: "admin",
+			summary: "Remove a custom emoji or sticker",
+			responses: []response{
+				{status: http.StatusNoContent, description: "The emoji is removed"},
+			},
+			errors: map[int]string{http.StatusNotFound: "The emoji doesn't exist"},
+		},
+		{
+			pattern: "GET /api/admin/roles", handler: h.listRoles, access: accessAdmin, tag: "admin",
+			summary: "List the default role, the global roles and every room's roles",
+			responses: []response{
+				{status: http.StatusOK, description: "The roles", body: fields{
+					"default": rbac.Role(""),
+					"global":  map[string]rbac.Role{},
+					"rooms":   map[string]map[string]rbac.Role{},
+				}},
+			},
+		},
+		{
+			pattern: "PUT /api/admin/roles/{username}", handler: h.assignRole, access: accessAdmin, tag: "admin",
+			summary: "Assign a user's global role; an empty role removes it",
+			body:    fields{"role": roleSchema()},
+			responses: []response{
+				{status: http.StatusOK, description: "The role now applying", body: assignedRole()},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest},
+		},
+		{
+			pattern: "PUT /api/admin/rooms/{id}/roles/{username}", handler: h.assignRole, access: accessAdmin, tag: "admin",
+			name:    "assignRoomRole",
+			summary: "Assign a user's role in a room; an empty role removes it",
+			body:    fields{"role": roleSchema()},
+			responses: []response{
+				{status: http.StatusOK, description: "The role now applying", body: assignedRole()},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusNotFound: errRoomMissing},
+		},
+		{
+			pattern: "PUT /api/admin/rooms/{id}/featured", handler: h.featureRoom, access: accessAdmin, tag: "admin",
+			summary: "Feature a room in room discovery, or stop featuring it",
+			body:    fields{"featured": false},
+			responses: []response{
+				{status: http.StatusOK, description: "Whether the room is featured", body: fields{"roomId": "", "featured": false}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusNotFound: errRoomMissing},
+		},
+		{
+			pattern: "PUT /api/admin/rooms/{id}/archived", handler: h.archiveRoom, access: accessAdmin, tag: "admin",
+			summary: "Archive a room read-only, or restore it",
+			description: "Archived rooms keep their history and can still be joined, but take no new messages and are " +
+				"left out of the room list and discovery. Members are sent room_archived or room_unarchived.",
+			body: fields{"archived": false},
+			responses: []response{
+				{status: http.StatusOK, description: "Whether the room is archived", body: fields{"roomId": "", "archived": false}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusNotFound: errRoomMissing},
+		},
+		{
+			pattern: "GET /api/admin/rooms/{id}/deleted", handler: h.listDeletedMessages, access: accessAdmin, tag: "admin",
+			summary: "List a room's deleted messages that can be restored, most recently deleted first",
+			description: "Deleted messages keep their content, which clients no longer see, until the tombstone retention " +
+				"period has passed since they were deleted.",
+			responses: []response{
+				{status: http.StatusOK, description: "The deleted messages", body: fields{"roomId": "", "messages": []*hub.RestorableMessage{}, "count": 0}},
+			},
+			errors: map[int]string{http.StatusNotFound: errRoomMissing, http.StatusInternalServerError: errInternal},
+		},
+		{
+			pattern: "POST /api/admin/rooms/{id}/messages/{messageId}/restore", handler: h.restoreMessage, access: accessAdmin, tag: "admin",
+			summary: "Restore a deleted message",
+			description: "Brings back the message's content and reactions, and sends the room message_restored with " +
+				"the message. The reason is kept in the audit log.",
+			params: map[string]string{"messageId": "ID of the deleted message"},
+			body:   fields{"reason": ""},
+			responses: []response{
+				{status: http.StatusOK, description: "The restored message", body: &history.Message{}},
+			},
+			errors: map[int]string{
+				http.StatusBadRequest: errBadRequest,
+				http.StatusNotFound:   "The room or message doesn't exist",
+				http.StatusConflict:   "The message hasn't been deleted",
+				http.StatusGone:       "The message was deleted too long ago to restore",
+			},
+		},
+		{
+			pattern: "GET /api/admin/reports", handler: h.listReports, access: accessAdmin, tag: "admin",
+			summary: "List the moderation queue, oldest first",
+			query: []param{
+				{name: "status", schema: enum(report.StatusOpen, report.StatusResolved, report.StatusDismissed)},
+				{name: "room", description: "Only reports of this room"},
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The reports", body: fields{"reports": []*store.ReportRecord{}, "count": 0}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest},
+		},
+		{
+			pattern: "POST /api/admin/reports/{id}/resolve", handler: h.resolveReport, access: accessAdmin, tag: "admin",
+			summary: "Resolve or dismiss a report",
+			params:  map[string]string{"id": "Report ID"},
+			body:    fields{"status": enum(report.StatusResolved, report.StatusDismissed), "note": ""},
+			responses: []response{
+				{status: http.StatusOK, description: "The report", body: &store.ReportRecord{}},
+			},
+			errors: map[int]string{
+				http.StatusBadRequest: errBadRequest,
+				http.StatusNotFound:   "The report doesn't exist",
+				http.StatusConflict:   "The report is already closed",
+			},
+		},
+		{
+			pattern: "GET /api/admin/audit", handler: h.queryAudit, access: accessAdmin, tag: "admin",
+			summary: "Query the audit log, newest first",
+			query: []param{
+				{name: "actor"},
+				{name: "action", description: "Such as " + audit.ActionExport + " or " + audit.ActionAdminAPI},
+				{name: "target"},
+				{name: "room"},
+				{name: "since", schema: schema{"type": "string", "format": "date-time"}},
+				{name: "until", schema: schema{"type": "string", "format": "date-time"}},
+				limitParam,
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The entries", body: fields{"entries": []*store.AuditRecord{}, "count": 0}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest},
+		},
+		{
+			pattern: "GET /api/stats", handler: h.getStats, access: accessAdmin, tag: "admin",
+			summary: "Report message counts, active users, peak connections and busiest hours",
+			query: []param{
+				{name: "room", description: "Room ID or slug; the whole workspace by default"},
+				{name: "since", description: "The week before until by default", schema: schema{"type": "string", "format": "date-time"}},
+				{name: "until", description: "Now by default", schema: schema{"type": "string", "format": "date-time"}},
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The statistics", body: &analytics.Report{}},
+			},
+			errors: map[int]string{
+				http.StatusBadRequest: errBadRequest,
+				http.StatusNotFound:   errRoomMissing,
+			},
+		},
+		{
+			pattern: "POST /api/admin/search/reindex", handler: h.reindexSearch, access: accessAdmin, tag: "admin",
+			summary: "Index every room's history in Elasticsearch or OpenSearch anew",
+			responses: []response{
+				{status: http.StatusOK, description: "What was indexed", body: fields{"rooms": 0, "messages": 0}},
+			},
+			errors: map[int]string{
+				http.StatusNotFound:            "No search index is configured",
+				http.StatusInternalServerError: errInternal,
+				http.StatusBadGateway:          "The search index couldn't be reached",
+			},
+		},
+		{
+			pattern: "GET /api/admin/deletions", handler: h.listDeletions, access: accessAdmin, tag: "admin",
+			summary: "List pending deletion requests by username",
+			responses: []response{
+				{status: http.StatusOK, description: "The deletion requests", body: fields{"deletions": map[string]*store.DeletionRequest{}, "count": 0}},
+			},
+		},
+		{
+			pattern: "POST /api/admin/users/{username}/erase", handler: h.eraseUser, access: accessAdmin, tag: "admin",
+			summary: "Erase a user at once, skipping any grace period",
+			body:    fields{"mode": enum(hub.ErasureAnonymize, hub.ErasurePurge)},
+			responses: []response{
+				{status: http.StatusOK, description: "What was erased", body: &hub.Erasure{}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusInternalServerError: errInternal},
+		},
+		{
+			pattern: "DELETE /api/admin/users/{username}/2fa", handler: h.resetTwoFactor, access: accessAdmin, tag: "admin",
+			summary: "Turn a user's two-factor login off without a code, when they lost their app and backup codes",
+			responses: []response{
+				{status: http.StatusNoContent, description: "Two-factor login is off"},
+			},
+			errors: map[int]string{http.StatusNotFound: "The username isn't an account or has two-factor login off"},
+		},
+		{
+			pattern: "GET /api/admin/keys", handler: h.listAPIKeys, access: accessAdmin, tag: "admin",
+			summary: "List the API keys of service accounts, oldest first, without the keys themselves",
+			responses: []response{
+				{status: http.StatusOK, description: "The API keys", body: fields{"keys": []*auth.APIKey{}, "count": 0}},
+			},
+		},
+		{
+			pattern: "POST /api/admin/keys", handler: h.createAPIKey, access: accessAdmin, tag: "admin",
+			summary: "Issue an API key to a service account, creating the account on its first key",
+			description: "Scopes are read, post and admin, which includes the others. The key is only shown in this " +
+				"response; send it as a bearer token to the REST API and /ws, or as the token of an incoming webhook URL.",
+			body: fields{
+				"username":  "",
+				"name":      schema{"type": "string", "description": "What the key is for"},
+				"scopes":    schema{"type": "array", "items": enum(auth.ScopeRead, auth.ScopePost, auth.ScopeAdmin)},
+				"rateLimit": schema{"type": "integer", "minimum": 0, "description": "Requests a minute; 0 for the server's default"},
+			},
+			responses: []response{
+				{status: http.StatusCreated, description: "The key", body: fields{"key": "", "apiKey": &auth.APIKey{}}},
+			},
+			errors: map[int]string{
+				http.StatusBadRequest:          errBadRequest,
+				http.StatusConflict:            "The username belongs to a user's account",
+				http.StatusInternalServerError: errInternal,
+			},
+		},
+		{
+			pattern: "DELETE /api/admin/keys/{id}", handler: h.revokeAPIKey, access: accessAdmin, tag: "admin",
+			summary: "Revoke an API key, closing the connections made with it",
+			params:  map[string]string{"id": "API key ID"},
+			responses: []response{
+				{status: http.StatusNoContent, description: "The key is revoked"},
+			},
+			errors: map[int]string{http.StatusNotFound: "The key doesn't exist"},
+		},
+		{
+			pattern: "GET /api/admin/workspaces", handler: h.listWorkspaces, access: accessAdmin, tag: "admin",
+			summary: "List the workspaces hosted by the server",
+			responses: []response{
+				{status: http.StatusOK, description: "The workspaces", body: fields{"workspaces": []*workspace.Workspace{}, "count": 0}},
+			},
+		},
+		{
+			pattern: "PUT /api/admin/workspaces/{id}", handler: h.saveWorkspace, access: accessAdmin, tag: "admin",
+			summary: "Create a workspace or change its settings",
+			description: "A workspace is reached under /w/{id}/, or at {id}.CHAT_WORKSPACE_DOMAIN when that is set, and has " +
+				"its own rooms and lobby. Listing members limits it to their accounts; guests are welcome unless turned " +
+				"away. Clients the new settings no longer admit are disconnected.",
+			params: map[string]string{"id": "Workspace ID"},
+			body: fields{
+				"name":        "",
+				"description": "",
+				"members":     schema{"type": "array", "items": schema{"type": "string"}, "description": "Only these accounts may connect; anyone when empty"},
+				"guests":      schema{"type": "boolean", "description": "Whether guests and users who didn't log in may connect; true when left out"},
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The updated workspace", body: &workspace.Workspace{}},
+				{status: http.StatusCreated, description: "The created workspace", body: &workspace.Workspace{}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest, http.StatusInternalServerError: errInternal},
+		},
+		{
+			pattern: "DELETE /api/admin/workspaces/{id}", handler: h.deleteWorkspace, access: accessAdmin, tag: "admin",
+			summary: "Delete a workspace and its rooms, disconnecting its clients",
+			params:  map[string]string{"id": "Workspace ID"},
+			responses: []response{
+				{status: http.StatusNoContent, description: "The workspace is deleted"},
+			},
+			errors: map[int]string{http.StatusNotFound: "The workspace doesn't exist"},
+		},
+
+		{
+			pattern: "GET /api/admin/announcements", handler: h.listAnnouncements, access: accessAdmin, tag: "admin",
+			summary: "List the announcements that haven't expired",
+			responses: []response{
+				{status: http.StatusOK, description: "The announcements, oldest first", body: fields{"announcements": []*hub.Announcement{}}},
+			},
+		},
+		{
+			pattern: "POST /api/admin/announcements", handler: h.announce, access: accessAdmin, tag: "admin",
+			summary: "Send an announcement to every connected client in every room",
+			description: "Clients receive an announcement frame, and clients connecting later receive it too until it " +
+				"expires after ttl seconds, or until it is withdrawn when ttl is 0 or omitted.",
+			body: fields{"message": "", "ttl": 0},
+			responses: []response{
+				{status: http.StatusCreated, description: "The announcement", body: &hub.Announcement{}},
+			},
+			errors: map[int]string{http.StatusBadRequest: errBadRequest},
+		},
+		{
+			pattern: "DELETE /api/admin/announcements/{id}", handler: h.withdrawAnnouncement, access: accessAdmin, tag: "admin",
+			summary: "Withdraw an announcement before it expires",
+			params:  map[string]string{"id": "Announcement ID"},
+			responses: []response{
+				{status: http.StatusNoContent, description: "The announcement was withdrawn"},
+			},
+			errors: map[int]string{http.StatusNotFound: "The announcement doesn't exist or has expired"},
+		},
+
+		{
+			pattern: "GET /debug/stats", handler: h.debugStats, access: accessAdmin, tag: "debug",
+			summary: "Get the goroutine count, channel depths and the state of every room",
+			description: "runningRooms counts room goroutines that haven't returned; more than there are rooms means " +
+				"rooms were dropped without being stopped.",
+			responses: []response{
+				{status: http.StatusOK, description: "The stats", body: fields{
+					"goroutines":   0,
+					"clients":      0,
+					"overloaded":   false,
+					"hub":          map[string]room.Depth{},
+					"roomManager":  map[string]room.Depth{},
+					"runningRooms": 0,
+					"rooms":        []room.Stats{},
+				}},
+			},
+		},
+		{
+			pattern: "GET /debug/pprof/", handler: pprof.Index, access: accessAdmin, tag: "debug", name: "pprofIndex",
+			summary: "List the runtime profiles",
+			responses: []response{
+				{status: http.StatusOK, description: "The profiles", contentTypes: []string{"text/html"}},
+			},
+		},
+		{
+			pattern: "GET /debug/pprof/{profile}", handler: pprof.Index, access: accessAdmin, tag: "debug", name: "pprofProfile",
+			summary: "Get a runtime profile, such as goroutine, heap, allocs, block, mutex or threadcreate",
+			params:  map[string]string{"profile": "Profile name"},
+			query: []param{
+				{name: "debug", description: "1 or 2 for a text profile instead of a pprof file", schema: schema{"type": "integer"}},
+				secondsParam,
+			},
+			responses: []response{
+				{status: http.StatusOK, description: "The profile", contentTypes: []string{"application/octet-stream", "text/plain"}},
+			},
+		},
+		{
+			pattern: "GET /debug/pprof/cmdline", handler: pprof.Cmdline, access: accessAdmin, tag: "debug", name: "pprofCmdline",
+			summary: "Get the server's command line",
+			responses: []response{
+				{status: http.StatusOK, description: "The arguments, separated by NUL bytes", contentTypes: []string{"text/plain"}},
+			},
+		},
+		{
+			pattern: "GET /debug/pprof/profile", handler: pprof.Profile, access: accessAdmin, tag: "debug", name: "pprofCPU",
+			summary: "Record a CPU profile",
+			query:   []param{secondsParam},
+			responses: []response{
+				{status: http.StatusOK, description: "The profile", contentTypes: []string{"application/octet-stream"}},
+			},
+		},
+		{
+			pattern: "GET /debug/pprof/symbol", handler: pprof.Symbol, access: accessAdmin, tag: "debug", name: "pprofSymbol",
+			summary: "Look up the function names of program counters",
+			responses: []response{
+				{status: http.StatusOK, description: "The symbols", contentTypes: []string{"text/plain"}},
+			},
+		},
+		{
+			pattern: "POST /debug/pprof/symbol", handler: pprof.Symbol, access: accessAdmin, tag: "debug", name: "pprofSymbols",
+			summary: "Look up the function names of the program counters in the body",
+			responses: []response{
+				{status: http.StatusOK, description: "The symbols", contentTypes: []string{"text/plain"}},
+			},
+		},
+		{
+			pattern: "GET /debug/pprof/trace", handler: pprof.Trace, access: accessAdmin, tag: "debug", name: "pprofTrace",
+			summary: "Record an execution trace",
+			query:   []param{secondsParam},
+			responses: []response{
+				{status: http.StatusOK, description: "The trace", contentTypes: []string{"application/octet-stream"}},
+			},
+		},
+
+		{
+			pattern: "GET /api/openapi.json", handler: h.openAPIDocument, tag: "meta",
+			summary: "Get this OpenAPI document",
+			responses: []response{
+				{status: http.StatusOK, description: "The document", body: schema{"type": "object"}},
+			},
+		},
+		{
+			pattern: "GET /api/docs", handler: h.apiDocs, tag: "meta",
+			summary: "Browse this document with Swagger UI",
+			responses: []response{
+				{status: http.StatusOK, description: "The Swagger UI page", contentTypes: []string{"text/html"}},
+			},
+		},
+	}
+}
+
+// roleSchema returns the schema of a role in a request
+func roleSchema() schema {
+	return enum(string(rbac.Guest), string(rbac.Member), string(rbac.Moderator), string(rbac.Admin), "")
+}
+
+// assignedRole describes the response to a role assignment
+func assignedRole() fields {
+	return fields{"username": "", "roomId": "", "role": rbac.Role(""), "permissions": []rbac.Permission{}}
+}
+
+// describe returns a copy of p with another description
+func describe(p param, description string) param {
+	p.description = description
+	return p
+}
+
+// handlerName returns the name of a handler method, used as its operation ID
+func handlerName(handler http.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
+}
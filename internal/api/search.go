@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"realtime-chat/internal/search"
+	"realtime-chat/internal/workspace"
+	"strconv"
+	"time"
+)
+
+// searchMessages handles GET /api/search?q=&room=&author=&since=&until=&limit=&offset=
+// and returns a page of the workspace's messages containing every word of
+// q, newest first, searched in the index when one is configured
+func (h *Handler) searchMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := search.Query{Text: query.Get("q"), Author: query.Get("author"), Limit: search.DefaultLimit}
+	if q.Text == "" && q.Author == "" {
+		writeError(w, http.StatusBadRequest, "q or author is required")
+		return
+	}
+
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, name+" must be an RFC 3339 time")
+			return
+		}
+		*t = parsed
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > search.MaxLimit {
+			writeError(w, http.StatusBadRequest, "limit must be a number from 1 to "+strconv.Itoa(search.MaxLimit))
+			return
+		}
+		q.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must not be negative")
+			return
+		}
+		q.Offset = offset
+	}
+
+	if ref := query.Get("room"); ref != "" {
+		rm, exists := h.room(r, ref)
+		if !exists {
+			writeError(w, http.StatusNotFound, "room not found")
+			return
+		}
+		q.RoomIDs = []string{rm.ID}
+	}
+
+	results, err := h.hub.SearchMessages(r.Context(), workspace.FromContext(r.Context()), q)
+	if errors.Is(err, search.ErrIndex) {
+		log.Printf("Error searching messages: %v", err)
+		writeError(w, http.StatusBadGateway, "search index unavailable")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results.Hits,
+		"total":   results.Total,
+		"offset":  q.Offset,
+		"limit":   q.Limit,
+	})
+}
+
+// reindexSearch handles POST /api/admin/search/reindex and replaces the
+// search index's messages with every room's history
+func (h *Handler) reindexSearch(w http.ResponseWriter, r *http.Request) {
+	if h.hub.Index == nil {
+		writeError(w, http.StatusNotFound, "search index isn't configured")
+		return
+	}
+	rooms, messages, err := h.hub.Reindex(r.Context())
+	if errors.Is(err, search.ErrIndex) {
+		log.Printf("Error reindexing messages: %v", err)
+		writeError(w, http.StatusBadGateway, "search index unavailable")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"rooms":    rooms,
+		"messages": messages,
+	})
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"realtime-chat/internal/auth"
+)
+
+// listSessions handles GET /api/users/{username}/sessions and returns the
+// user's login sessions with the connections each has open
+func (h *Handler) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := h.hub.Sessions(r.PathValue("username"), auth.TokenSessionID(auth.Token(r)))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions": sessions,
+		"count":    len(sessions),
+	})
+}
+
+// revokeSession handles DELETE /api/users/{username}/sessions/{id}, logging
+// out one of the user's sessions and closing its connections
+func (h *Handler) revokeSession(w http.ResponseWriter, r *http.Request) {
+	username, id := r.PathValue("username"), r.PathValue("id")
+	closed, err := h.hub.RevokeSession(username, id)
+	switch {
+	case errors.Is(err, auth.ErrNoSession):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error revoking session of %s: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not revoke session")
+		return
+	}
+
+	log.Printf("Revoked session %s of %s, closing %d connections", id, username, closed)
+	if id == auth.TokenSessionID(auth.Token(r)) {
+		http.SetCookie(w, &http.Cookie{Name: auth.SessionCookie, Path: "/", MaxAge: -1})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeOtherSessions handles DELETE /api/users/{username}/sessions and logs
+// the user out of every session but the one making the request, closing
+// their connections
+func (h *Handler) revokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	revoked, closed, err := h.hub.RevokeOtherSessions(username, auth.TokenSessionID(auth.Token(r)))
+	if err != nil {
+		log.Printf("Error revoking sessions of %s: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not revoke sessions")
+		return
+	}
+
+	log.Printf("Revoked %d other sessions of %s, closing %d connections", revoked, username, closed)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"revoked": revoked,
+		"closed":  closed,
+	})
+}
+
+// remoteIP returns the address a request came from, without its port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"realtime-chat/internal/analytics"
+	"realtime-chat/internal/workspace"
+	"strconv"
+	"time"
+)
+
+// getStats handles GET /api/stats and reports the activity in the request's
+// workspace, or in one of its rooms with ?room=, between ?since= and ?until=
+// (RFC 3339 times; the week before now by default)
+func (h *Handler) getStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := analytics.Filter{Workspace: workspace.FromContext(r.Context())}
+	if message := parseRange(query, &filter); message != "" {
+		writeError(w, http.StatusBadRequest, message)
+		return
+	}
+
+	if ref := query.Get("room"); ref != "" {
+		rm, exists := h.hub.Room(filter.Workspace, ref)
+		if !exists {
+			writeError(w, http.StatusNotFound, "room not found")
+			return
+		}
+		filter.RoomID = rm.ID
+	}
+
+	writeJSON(w, http.StatusOK, h.hub.Analytics.Query(filter))
+}
+
+// roomActivity handles GET /api/rooms/{id}/activity?since=&until= and returns
+// the room's activity hour by hour, with a heatmap by weekday and hour
+func (h *Handler) roomActivity(w http.ResponseWriter, r *http.Request) {
+	rm, exists := h.room(r, r.PathValue("id"))
+	if !exists {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+
+	filter := analytics.Filter{Workspace: rm.Workspace, RoomID: rm.ID}
+	if message := parseRange(r.URL.Query(), &filter); message != "" {
+		writeError(w, http.StatusBadRequest, message)
+		return
+	}
+
+	// Every hour of the range is listed, so it is bounded
+	if filter.Until.IsZero() {
+		filter.Until = time.Now()
+	}
+	if filter.Since.IsZero() {
+		filter.Since = filter.Until.Add(-analytics.DefaultRange)
+	}
+	if filter.Until.Sub(filter.Since) > analytics.MaxActivityRange {
+		writeError(w, http.StatusBadRequest, "the range must not be longer than 31 days")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.hub.Analytics.Activity(filter))
+}
+
+// userStats handles GET /api/users/{username}/stats and returns the user's
+// activity in the request's workspace
+func (h *Handler) userStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := h.hub.Analytics.User(workspace.FromContext(r.Context()), r.PathValue("username"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "user has no recorded activity")
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// leaderboard handles GET /api/leaderboard?sort=&limit= and returns the
+// activity of the workspace's users, those who posted the most first unless
+// sort is rooms or recent
+func (h *Handler) leaderboard(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	order := query.Get("sort")
+	if !analytics.ValidSort(order) {
+		writeError(w, http.StatusBadRequest, "sort must be messages, rooms or recent")
+		return
+	}
+	limit := 20
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > analytics.MaxLeaderboard {
+			writeError(w, http.StatusBadRequest, "limit must be a number from 1 to "+strconv.Itoa(analytics.MaxLeaderboard))
+			return
+		}
+		limit = parsed
+	}
+
+	users := h.hub.Analytics.Leaderboard(workspace.FromContext(r.Context()), order, limit)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"users": users,
+		"count": len(users),
+	})
+}
+
+// parseRange reads ?since= and ?until= (RFC 3339 times) into a filter,
+// returning what is wrong with them if anything
+func parseRange(query url.Values, filter *analytics.Filter) string {
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return name + " must be an RFC 3339 time"
+		}
+		*t = parsed
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		return "since must be before until"
+	}
+	return ""
+}
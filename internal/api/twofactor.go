@@ -0,0 +1,239 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"realtime-chat/internal/auth"
+	"slices"
+)
+
+// twoFactorStatus handles GET /api/auth/2fa and returns the state of the
+// logged-in account's two-factor login and of the current session
+func (h *Handler) twoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	username, state, ok := h.pendingUser(w, r, auth.SecondFactorNone, auth.SecondFactorVerify, auth.SecondFactorEnroll)
+	if !ok {
+		return
+	}
+	status, err := h.hub.Auth.TwoFactor(username)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	response := map[string]interface{}{
+		"enabled":      status.Enabled,
+		"required":     status.Required,
+		"backupCodes":  status.BackupCodes,
+		"secondFactor": state,
+	}
+	if status.Enabled {
+		response["enabledAt"] = status.EnabledAt
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// enrollTwoFactor handles POST /api/auth/2fa/enroll and returns a new
+// authenticator app secret for the logged-in account, which a code must
+// confirm before two-factor login is on
+func (h *Handler) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username, _, ok := h.pendingUser(w, r, auth.SecondFactorNone, auth.SecondFactorEnroll)
+	if !ok {
+		return
+	}
+	secret, uri, err := h.hub.Auth.Enroll(username)
+	switch {
+	case errors.Is(err, auth.ErrTwoFactorEnabled):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error enrolling %s in two-factor login: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not start enrollment")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"secret": secret,
+		"uri":    uri,
+	})
+}
+
+// enableTwoFactor handles POST /api/auth/2fa/enable with a JSON body holding
+// a "code" from the newly enrolled app, turns two-factor login on and
+// returns the account's backup codes. Connections of the account's other
+// sessions are closed until they verify.
+func (h *Handler) enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username, _, ok := h.pendingUser(w, r, auth.SecondFactorNone, auth.SecondFactorEnroll)
+	if !ok {
+		return
+	}
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, unverified, err := h.hub.Auth.Enable(auth.Token(r), code)
+	switch {
+	case errors.Is(err, auth.ErrInvalidCode):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, auth.ErrNotEnrolled), errors.Is(err, auth.ErrTwoFactorEnabled):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error enabling two-factor login of %s: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not enable two-factor login")
+		return
+	}
+
+	log.Printf("Two-factor login enabled for %s", username)
+	h.hub.CloseSessions(username, unverified...)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"backupCodes": codes})
+}
+
+// verifyTwoFactor handles POST /api/auth/2fa/verify with a JSON body holding
+// a "code" from the authenticator app or a backup code, and completes the
+// login of a session waiting for its second factor
+func (h *Handler) verifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username, _, ok := h.pendingUser(w, r, auth.SecondFactorVerify)
+	if !ok {
+		return
+	}
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.hub.Auth.Verify(auth.Token(r), code)
+	switch {
+	case errors.Is(err, auth.ErrInvalidCode):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, auth.ErrTooManyFailures):
+		log.Printf("Ended a session of %s after too many wrong two-factor codes", username)
+		http.SetCookie(w, &http.Cookie{Name: auth.SessionCookie, Path: "/", MaxAge: -1})
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error verifying two-factor login of %s: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not verify code")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// regenerateBackupCodes handles POST /api/auth/2fa/backup-codes with a JSON
+// body holding a "code" from the authenticator app, and replaces the
+// account's backup codes
+func (h *Handler) regenerateBackupCodes(w http.ResponseWriter, r *http.Request) {
+	username, _, ok := h.pendingUser(w, r, auth.SecondFactorNone)
+	if !ok {
+		return
+	}
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.hub.Auth.RegenerateBackupCodes(username, code)
+	switch {
+	case errors.Is(err, auth.ErrInvalidCode):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, auth.ErrTwoFactorOff):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error replacing backup codes of %s: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not replace backup codes")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"backupCodes": codes})
+}
+
+// disableTwoFactor handles POST /api/auth/2fa/disable with a JSON body
+// holding a "code" from the authenticator app or a backup code, and turns
+// the account's two-factor login off unless the server requires it
+func (h *Handler) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username, _, ok := h.pendingUser(w, r, auth.SecondFactorNone)
+	if !ok {
+		return
+	}
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.hub.Auth.Disable(username, code)
+	switch {
+	case errors.Is(err, auth.ErrInvalidCode):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, auth.ErrTwoFactorRequired):
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, auth.ErrTwoFactorOff):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error disabling two-factor login of %s: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not disable two-factor login")
+		return
+	}
+
+	log.Printf("Two-factor login disabled for %s", username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resetTwoFactor handles DELETE /api/admin/users/{username}/2fa and turns a
+// user's two-factor login off without a code, for users who lost their
+// authenticator app and backup codes
+func (h *Handler) resetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	err := h.hub.Auth.Reset(username)
+	switch {
+	case errors.Is(err, auth.ErrNoAccount), errors.Is(err, auth.ErrTwoFactorOff):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error resetting two-factor login of %s: %v", username, err)
+		writeError(w, http.StatusInternalServerError, "could not reset two-factor login")
+		return
+	}
+
+	log.Printf("Two-factor login of %s reset", username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pendingUser returns the username of the request's session and what it
+// still needs before it can be used, rejecting the request unless that is
+// one of states
+func (h *Handler) pendingUser(w http.ResponseWriter, r *http.Request, states ...string) (string, string, bool) {
+	username, state, ok := h.hub.Auth.Pending(auth.Token(r))
+	switch {
+	case !ok:
+		writeError(w, http.StatusUnauthorized, "log in first")
+		return "", "", false
+	case state == auth.SecondFactorVerify && !slices.Contains(states, state):
+		writeError(w, http.StatusUnauthorized, "enter a code from your authenticator app first")
+		return "", "", false
+	case state == auth.SecondFactorEnroll && !slices.Contains(states, state):
+		writeError(w, http.StatusForbidden, "set up two-factor login first")
+		return "", "", false
+	case !slices.Contains(states, state):
+		writeError(w, http.StatusConflict, "this session has already passed two-factor login")
+		return "", "", false
+	}
+	return username, state, true
+}
+
+// readCode reads the "code" of a JSON request body
+func readCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil || body.Code == "" {
+		writeError(w, http.StatusBadRequest, "expected a JSON object with a code")
+		return "", false
+	}
+	return body.Code, true
+}
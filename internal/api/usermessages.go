@@ -0,0 +1,87 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"realtime-chat/internal/coldstorage"
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/workspace"
+	"strconv"
+	"time"
+)
+
+// userMessages handles GET /api/users/{username}/messages?room=&with=&since=&until=&limit=&offset=
+// and returns a page of the messages a user posted across the workspace's
+// rooms and their direct message conversations, newest first, including
+// those in cold storage
+func (h *Handler) userMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ws := workspace.FromContext(r.Context())
+	q := hub.UserMessagesQuery{Username: r.PathValue("username"), Workspace: ws, Limit: history.DefaultPageSize}
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, name+" must be an RFC 3339 time")
+			return
+		}
+		*t = parsed
+	}
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		q.Limit = min(n, history.MaxPageSize)
+	}
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "offset must not be negative")
+			return
+		}
+		q.Offset = n
+	}
+
+	ref, with := query.Get("room"), query.Get("with")
+	switch {
+	case ref != "" && with != "":
+		writeError(w, http.StatusBadRequest, "give either room or with, not both")
+		return
+	case ref != "":
+		rm, exists := h.room(r, ref)
+		if !exists {
+			writeError(w, http.StatusNotFound, "room not found")
+			return
+		}
+		q.RoomIDs = []string{rm.ID}
+	case with != "":
+		q.RoomIDs = []string{conversation.ID(ws, q.Username, with)}
+	}
+
+	messages, total, err := h.hub.UserMessages(r.Context(), q)
+	if errors.Is(err, coldstorage.ErrStorage) {
+		log.Printf("Error loading messages of %s: %v", q.Username, err)
+		writeError(w, http.StatusBadGateway, "cold storage unavailable")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading messages of %s: %v", q.Username, err)
+		writeError(w, http.StatusInternalServerError, "could not load messages")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"username": q.Username,
+		"messages": messages,
+		"total":    total,
+		"offset":   q.Offset,
+		"limit":    q.Limit,
+	})
+}
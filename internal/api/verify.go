@@ -0,0 +1,84 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"log"
+	"net/http"
+	"realtime-chat/internal/ledger"
+	"realtime-chat/internal/store"
+)
+
+// maxTranscriptSize is the largest events export accepted for verification
+const maxTranscriptSize = 64 << 20
+
+// verifyTranscript handles POST /api/rooms/{id}/verify with an events
+// export of the room. It reports whether any event was changed, removed,
+// added or reordered, whether the server signed every event when history
+// signing is on, and whether the events are still part of the room's
+// history. Only those who may export the room may verify it.
+func (h *Handler) verifyTranscript(w http.ResponseWriter, r *http.Request) {
+	rm, exists := h.room(r, r.PathValue("id"))
+	if !exists {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	roomID := rm.ID
+	if _, ok := h.roomExporter(w, r, roomID); !ok {
+		return
+	}
+
+	var transcript struct {
+		RoomID string                `json:"roomId"`
+		Events []*store.MessageEvent `json:"events"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTranscriptSize)).Decode(&transcript); err != nil {
+		writeError(w, http.StatusBadRequest, "body must be an events export of the room")
+		return
+	}
+	if transcript.RoomID != roomID {
+		writeError(w, http.StatusBadRequest, "the export is of another room")
+		return
+	}
+
+	publicKey := h.hub.History.PublicKey()
+	var key ed25519.PublicKey
+	if publicKey != "" {
+		key, _ = ledger.ParsePublicKey(publicKey)
+	}
+	result := ledger.Verify(transcript.Events, key)
+
+	events, err := h.hub.History.Events(roomID)
+	if err != nil {
+		log.Printf("Error loading history for %s: %v", roomID, err)
+		writeError(w, http.StatusInternalServerError, "could not load history")
+		return
+	}
+
+	// Retention and erasure remove events, and reseal the events after any
+	// they removed, so older exports can stop matching
+	stored := make(map[string]bool, len(events))
+	for _, event := range events {
+		stored[event.Hash] = true
+	}
+	matches := result.Valid
+	for _, event := range transcript.Events {
+		matches = matches && stored[event.Hash]
+	}
+
+	body := map[string]interface{}{
+		"roomId":        roomID,
+		"algorithm":     ledger.Algorithm,
+		"result":        result,
+		"matchesServer": matches,
+	}
+	if publicKey != "" {
+		body["publicKey"] = publicKey
+	}
+	if len(events) > 0 {
+		// Events cut from the end of an export only show against the room's newest event
+		body["serverHead"] = events[len(events)-1].Hash
+		body["upToDate"] = matches && result.Head == events[len(events)-1].Hash
+	}
+	writeJSON(w, http.StatusOK, body)
+}
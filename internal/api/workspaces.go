@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"realtime-chat/internal/workspace"
+)
+
+// listWorkspaces handles GET /api/admin/workspaces and returns every
+// workspace with its settings
+func (h *Handler) listWorkspaces(w http.ResponseWriter, r *http.Request) {
+	workspaces := h.hub.Workspaces.List()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"workspaces": workspaces,
+		"count":      len(workspaces),
+	})
+}
+
+// saveWorkspace handles PUT /api/admin/workspaces/{id}, creating the
+// workspace with its lobby or replacing its settings. Clients the new
+// settings no longer admit are disconnected.
+func (h *Handler) saveWorkspace(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Members     []string `json:"members"`
+		Guests      *bool    `json:"guests"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "expected a JSON object with a name")
+		return
+	}
+
+	// Guests are welcome unless turned away
+	guests := body.Guests == nil || *body.Guests
+	actor, _ := h.adminActor(r)
+	ws, created, closed, err := h.hub.SaveWorkspace(&workspace.Workspace{
+		ID:          r.PathValue("id"),
+		Name:        body.Name,
+		Description: body.Description,
+		Members:     body.Members,
+		Guests:      guests,
+	}, actor)
+	switch {
+	case errors.Is(err, workspace.ErrInvalidID), errors.Is(err, workspace.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error saving workspace %s: %v", r.PathValue("id"), err)
+		writeError(w, http.StatusInternalServerError, "could not save workspace")
+		return
+	}
+
+	if created {
+		log.Printf("Created workspace %s", ws.ID)
+		writeJSON(w, http.StatusCreated, ws)
+		return
+	}
+	log.Printf("Updated workspace %s, disconnecting %d clients it no longer admits", ws.ID, closed)
+	writeJSON(w, http.StatusOK, ws)
+}
+
+// deleteWorkspace handles DELETE /api/admin/workspaces/{id}, removing the
+// workspace and its rooms and disconnecting its clients
+func (h *Handler) deleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	rooms, closed, err := h.hub.DeleteWorkspace(r.PathValue("id"))
+	switch {
+	case errors.Is(err, workspace.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error deleting workspace %s: %v", r.PathValue("id"), err)
+		writeError(w, http.StatusInternalServerError, "could not delete workspace")
+		return
+	}
+
+	log.Printf("Deleted workspace %s with %d rooms, closing %d connections", r.PathValue("id"), rooms, closed)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/store"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionKick                 = "kick"
+	ActionBan                  = "ban"
+	ActionUnban                = "unban"
+	ActionMute                 = "mute"
+	ActionTimeout              = "timeout"
+	ActionUnmute               = "unmute"
+	ActionDelete               = "delete"
+	ActionRestore              = "restore"
+	ActionPin                  = "pin"
+	ActionUnpin                = "unpin"
+	ActionSetRole              = "set_role"
+	ActionResolveReport        = "resolve_report"
+	ActionAdminAPI             = "admin_api"
+	ActionSpam                 = "spam_detected"
+	ActionSetRetention         = "set_retention"
+	ActionExport               = "export"
+	ActionEraseUser            = "erase_user"
+	ActionAnnounce             = "announce"
+	ActionWithdrawAnnouncement = "withdraw_announcement"
+	ActionArchive              = "archive"
+	ActionUnarchive            = "unarchive"
+	ActionSetJoinRule          = "set_join_rule"
+	ActionApproveJoin          = "approve_join"
+	ActionDenyJoin             = "deny_join"
+	ActionRoomSettings         = "room_settings"
+)
+
+// Limits on the number of entries a query returns
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Filter selects audit entries; empty fields match everything
+type Filter struct {
+	Actor  string
+	Action string
+	Target string
+	RoomID string
+	Since  time.Time
+	Until  time.Time
+	Limit  int // DefaultLimit when zero, at most MaxLimit
+}
+
+// Log is the append-only record of moderation and admin actions
+type Log struct {
+	store   store.Store // may be nil for an in-memory only log
+	mutex   sync.RWMutex
+	entries []*store.AuditRecord // oldest first
+
+	// Functions called after every recorded entry, outside the lock
+	observers []func(entry *store.AuditRecord)
+}
+
+// New creates an empty audit log
+func New(st store.Store) *Log {
+	return &Log{store: st}
+}
+
+// Observe registers a function called after every entry is recorded. It
+// must be called before the log is used.
+func (l *Log) Observe(fn func(entry *store.AuditRecord)) {
+	l.observers = append(l.observers, fn)
+}
+
+// Load reads the persisted audit log into memory
+func (l *Log) Load() error {
+	if l.store == nil {
+		return nil
+	}
+
+	entries, err := l.store.LoadAudit()
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries = entries
+	return nil
+}
+
+// Record appends an action to the log, stamping it with an ID and the
+// current time. Failures to persist it are logged rather than returned so
+// that auditing never blocks the action itself.
+func (l *Log) Record(entry store.AuditRecord) {
+	entry.ID = newID()
+	entry.Time = time.Now()
+
+	l.mutex.Lock()
+	if l.store != nil {
+		if err := l.store.AppendAudit(&entry); err != nil {
+			log.Printf("Error recording %s by %s in audit log: %v", entry.Action, entry.Actor, err)
+		}
+	}
+	l.entries = append(l.entries, &entry)
+	l.mutex.Unlock()
+
+	for _, fn := range l.observers {
+		fn(&entry)
+	}
+}
+
+// Query returns the entries matching a filter, newest first
+func (l *Log) Query(f Filter) []*store.AuditRecord {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	entries := make([]*store.AuditRecord, 0)
+	for i := len(l.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := l.entries[i]
+		if f.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// matches reports whether an entry passes the filter
+func (f Filter) matches(entry *store.AuditRecord) bool {
+	switch {
+	case f.Actor != "" && entry.Actor != f.Actor:
+		return false
+	case f.Action != "" && entry.Action != f.Action:
+		return false
+	case f.Target != "" && entry.Target != f.Target:
+		return false
+	case f.RoomID != "" && entry.RoomID != f.RoomID:
+		return false
+	case !f.Since.IsZero() && entry.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !entry.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// newID returns a random audit entry ID
+func newID() string {
+	return replay.ID("audit", func() string {
+		b := make([]byte, 8)
+		rand.Read(b)
+		return "audit_" + hex.EncodeToString(b)
+	})
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"realtime-chat/internal/store"
+	"sort"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix starts every API key, telling them apart from session tokens
+const APIKeyPrefix = "ck_"
+
+// Scopes an API key may hold
+const (
+	ScopeRead  = "read"  // Read rooms and history and connect to receive messages
+	ScopePost  = "post"  // Post messages and change settings
+	ScopeAdmin = "admin" // Call the admin API; includes read and post
+)
+
+// Errors returned when managing API keys
+var (
+	ErrNoAPIKey          = errors.New("no such API key")
+	ErrInvalidScope      = errors.New("scopes must be one or more of read, post and admin")
+	ErrServiceUsername   = errors.New("a service account needs a username of letters, digits, '-', '_' and '.' without the guest prefix")
+	ErrNotServiceAccount = errors.New("the username belongs to a user's account, not a service account")
+)
+
+// APIKey is an API key of a service account, without its secret
+type APIKey struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Name      string    `json:"name,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	RateLimit int       `json:"rateLimit,omitempty"` // Requests a minute; 0 for the server's default
+	CreatedBy string    `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Allows reports whether the key holds a scope. The admin scope includes
+// the others.
+func (k *APIKey) Allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAPIKey reports whether a token sent with a request is an API key rather
+// than a session token
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// CreateAPIKey issues an API key to the service account username, creating
+// the account when it doesn't exist, and returns the key. The key itself
+// can't be read back later.
+func (a *Auth) CreateAPIKey(username, name string, scopes []string, rateLimit int, createdBy string) (string, *APIKey, error) {
+	scopes, ok := normalizeScopes(scopes)
+	if !ok {
+		return "", nil, ErrInvalidScope
+	}
+	if username == "" || sanitizeUsername(username) != username || strings.HasPrefix(username, GuestPrefix) {
+		return "", nil, ErrServiceUsername
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	hash := hashToken(token)
+	record := &store.APIKeyRecord{
+		ID:        SessionID(hash),
+		TokenHash: hash,
+		Name:      name,
+		Scopes:    scopes,
+		RateLimit: rateLimit,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	account := &store.AccountRecord{Username: username, Identities: []*store.Identity{}, CreatedAt: record.CreatedAt, Service: true}
+	if current, ok := a.accounts[username]; ok {
+		if !current.Service {
+			return "", nil, ErrNotServiceAccount
+		}
+		c := *current
+		account = &c
+	}
+	account.APIKeys = append(append([]*store.APIKeyRecord(nil), account.APIKeys...), record)
+	if err := a.save(account); err != nil {
+		return "", nil, err
+	}
+	a.keys[hash] = username
+	return token, apiKey(username, record), nil
+}
+
+// APIKey returns the key of a token, if it is a valid API key
+func (a *Auth) APIKey(token string) (*APIKey, bool) {
+	if !IsAPIKey(token) {
+		return nil, false
+	}
+	hash := hashToken(token)
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	account, ok := a.accounts[a.keys[hash]]
+	if !ok {
+		return nil, false
+	}
+	for _, record := range account.APIKeys {
+		if record.TokenHash == hash {
+			return apiKey(account.Username, record), true
+		}
+	}
+	return nil, false
+}
+
+// APIKeys returns every API key, oldest first
+func (a *Auth) APIKeys() []*APIKey {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	keys := make([]*APIKey, 0)
+	for _, account := range a.accounts {
+		for _, record := range account.APIKeys {
+			keys = append(keys, apiKey(account.Username, record))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// RevokeAPIKey removes an API key by its ID and returns it. The service
+// account stays, keeping its username, roles and messages.
+func (a *Auth) RevokeAPIKey(id string) (*APIKey, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, current := range a.accounts {
+		for i, record := range current.APIKeys {
+			if record.ID != id {
+				continue
+			}
+			account := *current
+			account.APIKeys = append(append([]*store.APIKeyRecord(nil), current.APIKeys[:i]...), current.APIKeys[i+1:]...)
+			if err := a.save(&account); err != nil {
+				return nil, err
+			}
+			delete(a.keys, record.TokenHash)
+			return apiKey(account.Username, record), nil
+		}
+	}
+	return nil, ErrNoAPIKey
+}
+
+// apiKey returns the public view of a service account's key
+func apiKey(username string, record *store.APIKeyRecord) *APIKey {
+	return &APIKey{
+		ID:        record.ID,
+		Username:  username,
+		Name:      record.Name,
+		Scopes:    append([]string(nil), record.Scopes...),
+		RateLimit: record.RateLimit,
+		CreatedBy: record.CreatedBy,
+		CreatedAt: record.CreatedAt,
+	}
+}
+
+// normalizeScopes checks that scopes holds only known scopes, at least one,
+// and returns them without duplicates
+func normalizeScopes(scopes []string) ([]string, bool) {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeRead, ScopePost, ScopeAdmin:
+		default:
+			return nil, false
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, len(normalized) > 0
+}
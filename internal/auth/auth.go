@@ -0,0 +1,606 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/store"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// SessionCookie is the name of the cookie holding a browser's session token
+const SessionCookie = "chat_session"
+
+// maxUsername is the longest username created from a provider's profile
+const maxUsername = 32
+
+// Errors returned when a login can't be completed
+var (
+	ErrIdentityLinked = errors.New("this account is already linked to another user")
+	ErrNoAccount      = errors.New("no account to link to")
+	ErrNoDeletion     = errors.New("no deletion is pending for this account")
+	ErrNoSession      = errors.New("no such session")
+)
+
+// ProviderUser is a user as reported by an OAuth2 provider
+type ProviderUser struct {
+	Provider  string
+	Subject   string // The provider's stable user ID
+	Login     string // Preferred username, if the provider has one
+	Name      string
+	Email     string
+	AvatarURL string
+
+	// Values of the single sign-on role claim; nil unless roles follow it
+	Groups []string
+}
+
+// Auth holds the local accounts created through OAuth2 logins and their sessions
+type Auth struct {
+	store      store.Store // may be nil for in-memory only accounts
+	ttl        time.Duration
+	grace      time.Duration
+	publicURL  string
+	providers  map[string]*Provider
+	twoFactor  string // Which accounts must use two-factor login
+	guestTTL   time.Duration
+	guestsOnly bool
+	roleMap    map[string]string // Single sign-on claim value -> role
+
+	// Privileged reports whether a user has moderation powers, so
+	// config.TwoFactorModerators requires a second factor of them
+	Privileged func(username string) bool
+
+	mutex      sync.RWMutex
+	accounts   map[string]*store.AccountRecord // by username
+	identities map[string]string               // provider:subject -> username
+	sessions   map[string]*store.SessionRecord // by token hash
+	guests     map[string]string               // guest username -> token hash
+	keys       map[string]string               // API key hash -> service account username
+}
+
+// New creates an empty account set backed by st with the providers
+// configured in cfg
+func New(st store.Store, cfg config.AuthConfig) *Auth {
+	a := &Auth{
+		store:      st,
+		ttl:        cfg.SessionTTL,
+		grace:      cfg.DeletionGrace,
+		publicURL:  strings.TrimSuffix(cfg.PublicURL, "/"),
+		providers:  make(map[string]*Provider),
+		twoFactor:  cfg.TwoFactor,
+		guestTTL:   cfg.GuestTTL,
+		guestsOnly: cfg.GuestsOnly,
+		roleMap:    cfg.OIDC.RoleMap,
+		accounts:   make(map[string]*store.AccountRecord),
+		identities: make(map[string]string),
+		sessions:   make(map[string]*store.SessionRecord),
+		guests:     make(map[string]string),
+		keys:       make(map[string]string),
+	}
+	if cfg.Google.ClientID != "" {
+		a.providers[ProviderGoogle] = Google(cfg.Google.ClientID, cfg.Google.ClientSecret)
+	}
+	if cfg.GitHub.ClientID != "" {
+		a.providers[ProviderGitHub] = GitHub(cfg.GitHub.ClientID, cfg.GitHub.ClientSecret)
+	}
+	if cfg.OIDC.Issuer != "" {
+		a.providers[ProviderOIDC] = OIDC(cfg.OIDC)
+	}
+	return a
+}
+
+// Load reads every persisted account and session
+func (a *Auth) Load() error {
+	if a.store == nil {
+		return nil
+	}
+
+	accounts, err := a.store.LoadAccounts()
+	if err != nil {
+		return err
+	}
+	sessions, err := a.store.LoadSessions()
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, account := range accounts {
+		a.accounts[account.Username] = account
+		for _, id := range account.Identities {
+			a.identities[identityKey(id.Provider, id.Subject)] = account.Username
+		}
+		for _, key := range account.APIKeys {
+			a.keys[key.TokenHash] = account.Username
+		}
+	}
+	for _, session := range sessions {
+		a.sessions[session.TokenHash] = session
+		if session.Guest {
+			a.guests[session.Username] = session.TokenHash
+		}
+	}
+	return nil
+}
+
+// Provider returns a configured provider by name
+func (a *Auth) Provider(name string) (*Provider, bool) {
+	p, ok := a.providers[name]
+	return p, ok
+}
+
+// Providers returns the names of the configured providers
+func (a *Auth) Providers() []string {
+	names := make([]string, 0, len(a.providers))
+	for _, name := range []string{ProviderOIDC, ProviderGoogle, ProviderGitHub} {
+		if _, ok := a.providers[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ProviderLabels returns the sign-in label of each configured provider
+func (a *Auth) ProviderLabels() map[string]string {
+	labels := make(map[string]string, len(a.providers))
+	for name, p := range a.providers {
+		labels[name] = p.Label
+	}
+	return labels
+}
+
+// RedirectURL returns the callback URL registered with a provider
+func (a *Auth) RedirectURL(provider string) string {
+	return a.publicURL + "/api/auth/" + provider + "/callback"
+}
+
+// SessionTTL returns how long a login session lasts
+func (a *Auth) SessionTTL() time.Duration {
+	return a.ttl
+}
+
+// Secure reports whether the server is reached over HTTPS, so cookies can be
+// marked secure
+func (a *Auth) Secure() bool {
+	return strings.HasPrefix(a.publicURL, "https://")
+}
+
+// Claimed reports whether username belongs to an account, so only its
+// sessions may use it
+func (a *Auth) Claimed(username string) bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	_, ok := a.accounts[username]
+	return ok
+}
+
+// Account returns a copy of the account a username belongs to
+func (a *Auth) Account(username string) (*store.AccountRecord, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	account, ok := a.accounts[username]
+	if !ok {
+		return nil, false
+	}
+	c := *account
+	c.Identities = append([]*store.Identity(nil), account.Identities...)
+	if account.TOTP != nil {
+		// Only whether two-factor login is on leaves the package, never its secrets
+		c.TOTP = &store.TOTPRecord{Enabled: account.TOTP.Enabled, EnabledAt: account.TOTP.EnabledAt}
+	}
+	c.APIKeys = nil
+	for _, key := range account.APIKeys {
+		// API keys leave without their hashes
+		k := *key
+		k.TokenHash = ""
+		c.APIKeys = append(c.APIKeys, &k)
+	}
+	return &c, true
+}
+
+// Login returns the account linked to a provider's user. When linkTo names
+// an account the identity is added to it; otherwise an account is created
+// with a username derived from the provider's profile. created reports
+// whether a new account was made.
+func (a *Auth) Login(user *ProviderUser, linkTo string) (username string, created bool, err error) {
+	key := identityKey(user.Provider, user.Subject)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if existing, ok := a.identities[key]; ok {
+		if linkTo != "" && linkTo != existing {
+			return "", false, ErrIdentityLinked
+		}
+		return existing, false, nil
+	}
+
+	identity := &store.Identity{Provider: user.Provider, Subject: user.Subject, Email: user.Email}
+	if linkTo != "" {
+		current, ok := a.accounts[linkTo]
+		if !ok {
+			return "", false, ErrNoAccount
+		}
+		// Copy the account so readers never see a partial update
+		account := *current
+		account.Identities = append(append([]*store.Identity(nil), current.Identities...), identity)
+		if err := a.save(&account); err != nil {
+			return "", false, err
+		}
+		a.identities[key] = linkTo
+		return linkTo, false, nil
+	}
+
+	account := &store.AccountRecord{
+		Username:   a.uniqueUsername(user),
+		Identities: []*store.Identity{identity},
+		CreatedAt:  time.Now(),
+	}
+	if err := a.save(account); err != nil {
+		return "", false, err
+	}
+	a.identities[key] = account.Username
+	return account.Username, true, nil
+}
+
+// NewSession starts a session for username on a device, such as a browser's
+// user agent, logging in from ip and returns its token
+func (a *Auth) NewSession(username, device, ip string) (string, error) {
+	return a.startSession(&store.SessionRecord{Username: username, Device: device, IP: ip}, a.ttl)
+}
+
+// startSession saves session, lasting ttl from now, and returns its token
+func (a *Auth) startSession(session *store.SessionRecord, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	session.TokenHash = hashToken(token)
+	session.CreatedAt = now
+	session.ExpiresAt = now.Add(ttl)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.store != nil {
+		if err := a.store.SaveSession(session); err != nil {
+			return "", err
+		}
+	}
+	a.sessions[session.TokenHash] = session
+	if session.Guest {
+		a.guests[session.Username] = session.TokenHash
+	}
+	return token, nil
+}
+
+// Session returns the username of a valid session token. Sessions still
+// waiting for a second factor aren't valid.
+func (a *Auth) Session(token string) (string, bool) {
+	username, state, ok := a.Pending(token)
+	if !ok || state != SecondFactorNone {
+		return "", false
+	}
+	return username, true
+}
+
+// Logout ends the session of a token
+func (a *Auth) Logout(token string) error {
+	hash := hashToken(token)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, ok := a.sessions[hash]; !ok {
+		return nil
+	}
+	delete(a.sessions, hash)
+	if a.store != nil {
+		return a.store.DeleteSession(hash)
+	}
+	return nil
+}
+
+// Sessions returns copies of a user's unexpired sessions, oldest first
+func (a *Auth) Sessions(username string) []*store.SessionRecord {
+	now := time.Now()
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var sessions []*store.SessionRecord
+	for _, session := range a.sessions {
+		if session.Username == username && now.Before(session.ExpiresAt) {
+			c := *session
+			sessions = append(sessions, &c)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions
+}
+
+// Revoke ends one of a user's sessions by its ID
+func (a *Auth) Revoke(username, id string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for hash, session := range a.sessions {
+		if session.Username != username || SessionID(hash) != id {
+			continue
+		}
+		delete(a.sessions, hash)
+		if a.store != nil {
+			return a.store.DeleteSession(hash)
+		}
+		return nil
+	}
+	return ErrNoSession
+}
+
+// RevokeOthers ends every session of a user except the one with ID keep,
+// and returns the IDs of the sessions it ended
+func (a *Auth) RevokeOthers(username, keep string) ([]string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var revoked []string
+	for hash, session := range a.sessions {
+		id := SessionID(hash)
+		if session.Username != username || id == keep {
+			continue
+		}
+		if a.store != nil {
+			if err := a.store.DeleteSession(hash); err != nil {
+				return revoked, err
+			}
+		}
+		delete(a.sessions, hash)
+		revoked = append(revoked, id)
+	}
+	return revoked, nil
+}
+
+// ExpireSessions removes sessions past their expiry and returns how many were removed
+func (a *Auth) ExpireSessions() (int, error) {
+	now := time.Now()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	expired := 0
+	for hash, session := range a.sessions {
+		if now.Before(session.ExpiresAt) {
+			continue
+		}
+		if a.store != nil {
+			if err := a.store.DeleteSession(hash); err != nil {
+				return expired, err
+			}
+		}
+		delete(a.sessions, hash)
+		expired++
+	}
+	for username, hash := range a.guests {
+		if _, ok := a.sessions[hash]; !ok {
+			delete(a.guests, username)
+		}
+	}
+	return expired, nil
+}
+
+// RequestDeletion schedules the erasure of an account once the deletion
+// grace period is over, replacing any pending request. mode says what
+// happens to the user's messages and is checked by the caller.
+func (a *Auth) RequestDeletion(username, mode string) (*store.DeletionRequest, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	current, ok := a.accounts[username]
+	if !ok {
+		return nil, ErrNoAccount
+	}
+
+	now := time.Now()
+	request := &store.DeletionRequest{Mode: mode, RequestedAt: now, DueAt: now.Add(a.grace)}
+	account := *current
+	account.Deletion = request
+	if err := a.save(&account); err != nil {
+		return nil, err
+	}
+	c := *request
+	return &c, nil
+}
+
+// CancelDeletion withdraws an account's pending deletion
+func (a *Auth) CancelDeletion(username string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	current, ok := a.accounts[username]
+	if !ok || current.Deletion == nil {
+		return ErrNoDeletion
+	}
+
+	account := *current
+	account.Deletion = nil
+	return a.save(&account)
+}
+
+// Deletions returns every pending deletion request, by username
+func (a *Auth) Deletions() map[string]*store.DeletionRequest {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	deletions := make(map[string]*store.DeletionRequest)
+	for username, account := range a.accounts {
+		if account.Deletion != nil {
+			c := *account.Deletion
+			deletions[username] = &c
+		}
+	}
+	return deletions
+}
+
+// DueDeletions returns the usernames of accounts whose deletion grace period
+// is over, with the requested mode of each
+func (a *Auth) DueDeletions(now time.Time) map[string]string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	due := make(map[string]string)
+	for username, account := range a.accounts {
+		if account.Deletion != nil && !now.Before(account.Deletion.DueAt) {
+			due[username] = account.Deletion.Mode
+		}
+	}
+	return due
+}
+
+// Delete removes an account with its linked identities and every session,
+// leaving the username free to be used again. Deleting an unknown account
+// is not an error.
+func (a *Auth) Delete(username string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for hash, session := range a.sessions {
+		if session.Username != username {
+			continue
+		}
+		if a.store != nil {
+			if err := a.store.DeleteSession(hash); err != nil {
+				return err
+			}
+		}
+		delete(a.sessions, hash)
+	}
+
+	account, ok := a.accounts[username]
+	if !ok {
+		return nil
+	}
+	if a.store != nil {
+		if err := a.store.DeleteAccount(username); err != nil {
+			return err
+		}
+	}
+	for _, id := range account.Identities {
+		delete(a.identities, identityKey(id.Provider, id.Subject))
+	}
+	for _, key := range account.APIKeys {
+		delete(a.keys, key.TokenHash)
+	}
+	delete(a.accounts, username)
+	return nil
+}
+
+// Token returns the session token sent with a request, from the session
+// cookie, a bearer token or a "token" query parameter for WebSocket clients
+// that can't set headers
+func Token(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		return cookie.Value
+	}
+	return r.URL.Query().Get("token")
+}
+
+// save persists an account and makes it current. The caller must hold the mutex.
+func (a *Auth) save(account *store.AccountRecord) error {
+	if a.store != nil {
+		if err := a.store.SaveAccount(account); err != nil {
+			return err
+		}
+	}
+	a.accounts[account.Username] = account
+	return nil
+}
+
+// uniqueUsername derives a username from a provider's profile that no
+// account has yet, adding a number when it's taken. The caller must hold the mutex.
+func (a *Auth) uniqueUsername(user *ProviderUser) string {
+	base := ""
+	for _, candidate := range []string{user.Login, user.Name, strings.Split(user.Email, "@")[0]} {
+		if base = sanitizeUsername(candidate); base != "" {
+			break
+		}
+	}
+	if base == "" {
+		base = user.Provider + "-user"
+	}
+
+	username := base
+	for n := 2; ; n++ {
+		if _, taken := a.accounts[username]; !taken {
+			return username
+		}
+		suffix := "-" + strconv.Itoa(n)
+		username = truncate(base, maxUsername-len(suffix)) + suffix
+	}
+}
+
+// sanitizeUsername keeps letters, digits, '-', '_' and '.' of s, turning
+// spaces into '-'
+func sanitizeUsername(s string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune('-')
+		}
+	}
+	return truncate(b.String(), maxUsername)
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
+
+// identityKey indexes a provider's user
+func identityKey(provider, subject string) string {
+	return provider + ":" + subject
+}
+
+// hashToken returns the stored form of a session token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SessionID returns the public ID of the session with a token hash, short
+// enough to show in a device list and useless for logging in
+func SessionID(tokenHash string) string {
+	return tokenHash[:16]
+}
+
+// TokenSessionID returns the public ID of a token's session, or "" for no token
+func TokenSessionID(token string) string {
+	if token == "" {
+		return ""
+	}
+	return SessionID(hashToken(token))
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"realtime-chat/internal/store"
+	"time"
+)
+
+// NewEmbedGuest issues a guest identity to the chat widget of a room
+// embedded in the site of origin, and returns its username and session
+// token. The session only lets the guest chat in that room.
+func (a *Auth) NewEmbedGuest(device, ip, origin, roomID string) (username, token string, expiresAt time.Time, err error) {
+	return a.newGuest(&store.SessionRecord{Device: device, IP: ip, Origin: origin, RoomID: roomID})
+}
+
+// EmbedSession returns the username of a valid embedded chat widget's
+// session token, with the site and room it is limited to
+func (a *Auth) EmbedSession(token string) (username, origin, roomID string, ok bool) {
+	if token == "" {
+		return "", "", "", false
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	session, ok := a.sessions[hashToken(token)]
+	if !ok || session.RoomID == "" || time.Now().After(session.ExpiresAt) {
+		return "", "", "", false
+	}
+	return session.Username, session.Origin, session.RoomID, true
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"realtime-chat/internal/store"
+	"strings"
+	"time"
+)
+
+// GuestPrefix starts the username of every guest identity. Other users
+// can't pick such a name, so guests keep theirs while their session lasts.
+const GuestPrefix = "guest-"
+
+// ErrNotGuest is returned when converting a session that isn't a guest's
+var ErrNotGuest = errors.New("the session is not a guest's")
+
+// NewGuest issues a guest identity to a device, such as a browser's user
+// agent, connecting from ip and returns its username and session token
+func (a *Auth) NewGuest(device, ip string) (username, token string, expiresAt time.Time, err error) {
+	return a.newGuest(&store.SessionRecord{Device: device, IP: ip})
+}
+
+// newGuest picks an unused guest username for session, a guest's session
+// without a username yet, and starts the session
+func (a *Auth) newGuest(session *store.SessionRecord) (username, token string, expiresAt time.Time, err error) {
+	raw := make([]byte, 4)
+	for {
+		if _, err := rand.Read(raw); err != nil {
+			return "", "", time.Time{}, err
+		}
+		username = GuestPrefix + hex.EncodeToString(raw)
+		if !a.Claimed(username) && !a.Guest(username) {
+			break
+		}
+	}
+
+	session.Username = username
+	session.Guest = true
+	token, err = a.startSession(session, a.guestTTL)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return username, token, session.ExpiresAt, nil
+}
+
+// Guest reports whether username is a guest identity whose session is
+// still valid
+func (a *Auth) Guest(username string) bool {
+	if !strings.HasPrefix(username, GuestPrefix) {
+		return false
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	session, ok := a.sessions[a.guests[username]]
+	return ok && session.Guest && time.Now().Before(session.ExpiresAt)
+}
+
+// Reserved reports whether username belongs to an account or has the guest
+// prefix, so only its own session may use it
+func (a *Auth) Reserved(username string) bool {
+	return strings.HasPrefix(username, GuestPrefix) || a.Claimed(username)
+}
+
+// GuestsOnly reports whether users without an account must connect with a
+// guest identity rather than any unclaimed username
+func (a *Auth) GuestsOnly() bool {
+	return a.guestsOnly
+}
+
+// Convert turns the guest session of token into an account linked to a
+// provider's user. The account keeps the guest's username and the session
+// becomes its login, so the guest's connections and rooms carry on as
+// they are. When the provider's user already has an account it is
+// returned instead and the guest is left as it was.
+func (a *Auth) Convert(user *ProviderUser, token string) (username string, created bool, err error) {
+	key := identityKey(user.Provider, user.Subject)
+	hash := hashToken(token)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if existing, ok := a.identities[key]; ok {
+		return existing, false, nil
+	}
+	session, ok := a.sessions[hash]
+	if !ok || !session.Guest || session.RoomID != "" {
+		return "", false, ErrNotGuest
+	}
+
+	now := time.Now()
+	account := &store.AccountRecord{
+		Username:   session.Username,
+		Identities: []*store.Identity{{Provider: user.Provider, Subject: user.Subject, Email: user.Email}},
+		CreatedAt:  now,
+	}
+	if err := a.save(account); err != nil {
+		return "", false, err
+	}
+	a.identities[key] = account.Username
+
+	updated := *session
+	updated.Guest = false
+	updated.ExpiresAt = now.Add(a.ttl)
+	if a.store != nil {
+		if err := a.store.SaveSession(&updated); err != nil {
+			return "", false, err
+		}
+	}
+	a.sessions[hash] = &updated
+	delete(a.guests, account.Username)
+	return account.Username, true, nil
+}
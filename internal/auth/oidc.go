@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/rbac"
+	"strings"
+	"time"
+)
+
+// ProviderOIDC is the name of the OpenID Connect single sign-on provider
+const ProviderOIDC = "oidc"
+
+// clockSkew is how far the issuer's clock may be ahead of or behind ours
+const clockSkew = time.Minute
+
+// oidc holds the claims an OpenID Connect provider reads and the userinfo
+// endpoint found by discovery
+type oidc struct {
+	issuer        string
+	usernameClaim string
+	roleClaim     string
+	userinfoURL   string
+}
+
+// OIDC returns the single sign-on provider of an OpenID Connect issuer.
+// Its endpoints are discovered when it is first used.
+func OIDC(cfg config.OIDCConfig) *Provider {
+	o := &oidc{
+		issuer:        cfg.Issuer,
+		usernameClaim: cfg.UsernameClaim,
+		roleClaim:     cfg.RoleClaim,
+	}
+	return &Provider{
+		Name:         ProviderOIDC,
+		Label:        cfg.Name,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		Scopes:       append([]string{"openid"}, cfg.Scopes...),
+		user:         o.user,
+		discover:     o.discover,
+	}
+}
+
+// discover reads the issuer's discovery document
+func (o *oidc) discover(ctx context.Context, p *Provider) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	if err := do(req, &doc); err != nil {
+		return fmt.Errorf("discovering %s: %w", o.issuer, err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != o.issuer {
+		return fmt.Errorf("discovering %s: document is for issuer %q", o.issuer, doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" {
+		return fmt.Errorf("discovering %s: no authorization or token endpoint", o.issuer)
+	}
+
+	p.AuthURL, p.TokenURL = doc.AuthorizationEndpoint, doc.TokenEndpoint
+	o.userinfoURL = doc.UserinfoEndpoint
+	return nil
+}
+
+// user reads the user from the ID token, adding any claims only the
+// userinfo endpoint has. The ID token came straight from the token endpoint
+// over TLS, so its signature needn't be checked (OpenID Connect Core
+// 3.1.3.7), but it must be meant for this client and still be valid.
+func (o *oidc) user(ctx context.Context, p *Provider, tokens *tokens) (*ProviderUser, error) {
+	claims, err := idTokenClaims(tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != o.issuer {
+		return nil, fmt.Errorf("ID token is from issuer %q", iss)
+	}
+	if !audienceIncludes(claims["aud"], p.ClientID) {
+		return nil, fmt.Errorf("ID token is for another client")
+	}
+	if exp, ok := claims["exp"].(float64); !ok || time.Unix(int64(exp), 0).Add(clockSkew).Before(time.Now()) {
+		return nil, fmt.Errorf("ID token has expired")
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("no user ID in ID token")
+	}
+
+	if o.userinfoURL != "" {
+		var info map[string]interface{}
+		if err := get(ctx, o.userinfoURL, tokens.AccessToken, &info); err != nil {
+			return nil, err
+		}
+		if sub, _ := info["sub"].(string); sub != subject {
+			return nil, fmt.Errorf("userinfo is for another user")
+		}
+		for claim, value := range info {
+			if _, ok := claims[claim]; !ok {
+				claims[claim] = value
+			}
+		}
+	}
+
+	user := &ProviderUser{Subject: subject}
+	user.Login, _ = claims[o.usernameClaim].(string)
+	user.Name, _ = claims["name"].(string)
+	user.AvatarURL, _ = claims["picture"].(string)
+	// Enterprise providers often leave email_verified out; only an explicit
+	// false is distrusted
+	if verified, ok := claims["email_verified"].(bool); !ok || verified {
+		user.Email, _ = claims["email"].(string)
+	}
+	if o.roleClaim != "" {
+		user.Groups = claimValues(lookupClaim(claims, o.roleClaim))
+		if user.Groups == nil {
+			user.Groups = []string{}
+		}
+	}
+	return user, nil
+}
+
+// SSORole returns the global role the claims of a single sign-on user map
+// to: the most privileged role mapped from their groups, or "" for none.
+// ok is false unless roles of single sign-on users follow their claims.
+func (a *Auth) SSORole(user *ProviderUser) (role rbac.Role, ok bool) {
+	if user.Provider != ProviderOIDC || user.Groups == nil {
+		return "", false
+	}
+	for _, group := range user.Groups {
+		if mapped := rbac.Role(a.roleMap[group]); mapped != "" && (role == "" || mapped.Outranks(role)) {
+			role = mapped
+		}
+	}
+	return role, true
+}
+
+// idTokenClaims decodes the claims of a JWT without checking its signature
+func idTokenClaims(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("no ID token in response")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("decoding ID token: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("decoding ID token: %w", err)
+	}
+	return claims, nil
+}
+
+// audienceIncludes reports whether an aud claim, a string or a list of
+// them, includes clientID
+func audienceIncludes(aud interface{}, clientID string) bool {
+	for _, audience := range claimValues(aud) {
+		if audience == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupClaim returns a claim by its name or by a dotted path such as
+// "realm_access.roles"
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	if value, ok := claims[path]; ok {
+		return value
+	}
+	var value interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// claimValues returns the strings of a claim holding one or a list of them
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"realtime-chat/internal/webhook"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported providers
+const (
+	ProviderGoogle = "google"
+	ProviderGitHub = "github"
+)
+
+// client is used for every request to a provider
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// avatarClient downloads avatars, whose URLs come from the provider's
+// profile and so may be chosen by the user: only over https, and only
+// from public addresses
+var avatarClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: webhook.PublicTransport(),
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return errAvatarURL
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	},
+}
+
+// errAvatarURL is returned for avatar URLs that aren't https
+var errAvatarURL = errors.New("avatar URL must use https")
+
+// Provider is an OAuth2 provider users can log in with
+type Provider struct {
+	Name         string
+	Label        string // Shown on sign-in links
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	Scopes       []string
+
+	// user fetches the logged-in user with the tokens a code was exchanged for
+	user func(ctx context.Context, p *Provider, tokens *tokens) (*ProviderUser, error)
+
+	// discover fills in the endpoints the first time the provider is used;
+	// nil when they are fixed
+	discover   func(ctx context.Context, p *Provider) error
+	ready      sync.Mutex
+	discovered bool
+}
+
+// tokens is a provider's response to exchanging an authorization code
+type tokens struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"` // Only from OpenID Connect providers
+	Error       string `json:"error"`
+}
+
+// Google returns the Google provider for a client
+func Google(clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         ProviderGoogle,
+		Label:        "Google",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		Scopes:       []string{"openid", "email", "profile"},
+		user:         googleUser,
+	}
+}
+
+// GitHub returns the GitHub provider for a client
+func GitHub(clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         ProviderGitHub,
+		Label:        "GitHub",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		Scopes:       []string{"read:user", "user:email"},
+		user:         githubUser,
+	}
+}
+
+// Ready fetches what the provider needs before a login can start, such as
+// the endpoints of an OpenID Connect issuer. It is retried on the next
+// login when it fails.
+func (p *Provider) Ready(ctx context.Context) error {
+	if p.discover == nil {
+		return nil
+	}
+
+	p.ready.Lock()
+	defer p.ready.Unlock()
+	if p.discovered {
+		return nil
+	}
+	if err := p.discover(ctx, p); err != nil {
+		return err
+	}
+	p.discovered = true
+	return nil
+}
+
+// AuthCodeURL returns the provider's consent page that redirects back to
+// redirectURL with a code and state. The provider must be ready.
+func (p *Provider) AuthCodeURL(state, redirectURL string) string {
+	query := url.Values{
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {redirectURL},
+		"response_type": {"code"},
+		"scope":         {strings.Join(p.Scopes, " ")},
+		"state":         {state},
+	}
+	return p.AuthURL + "?" + query.Encode()
+}
+
+// User exchanges an authorization code for an access token and returns the
+// user it belongs to
+func (p *Provider) User(ctx context.Context, code, redirectURL string) (*ProviderUser, error) {
+	if err := p.Ready(ctx); err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code":          {code},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {redirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token tokens
+	if err := do(req, &token); err != nil {
+		return nil, fmt.Errorf("exchanging code: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("exchanging code: %s", token.Error)
+	}
+
+	user, err := p.user(ctx, p, &token)
+	if err != nil {
+		return nil, fmt.Errorf("fetching user: %w", err)
+	}
+	user.Provider = p.Name
+	return user, nil
+}
+
+// FetchAvatar downloads a provider's avatar image of at most limit bytes.
+// The image must be served over https from a public address.
+func FetchAvatar(ctx context.Context, avatarURL string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, avatarURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme != "https" {
+		return nil, errAvatarURL
+	}
+	resp, err := avatarClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+// googleUser fetches a Google user from the OpenID Connect userinfo endpoint
+func googleUser(ctx context.Context, p *Provider, tokens *tokens) (*ProviderUser, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Picture       string `json:"picture"`
+	}
+	if err := get(ctx, "https://openidconnect.googleapis.com/v1/userinfo", tokens.AccessToken, &info); err != nil {
+		return nil, err
+	}
+	if info.Sub == "" {
+		return nil, fmt.Errorf("no user ID in response")
+	}
+
+	user := &ProviderUser{Subject: info.Sub, Name: info.Name, AvatarURL: info.Picture}
+	if info.EmailVerified {
+		user.Email = info.Email
+	}
+	return user, nil
+}
+
+// githubUser fetches a GitHub user and their primary verified email
+func githubUser(ctx context.Context, p *Provider, tokens *tokens) (*ProviderUser, error) {
+	token := tokens.AccessToken
+	var info struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := get(ctx, "https://api.github.com/user", token, &info); err != nil {
+		return nil, err
+	}
+	if info.ID == 0 {
+		return nil, fmt.Errorf("no user ID in response")
+	}
+
+	user := &ProviderUser{
+		Subject:   strconv.FormatInt(info.ID, 10),
+		Login:     info.Login,
+		Name:      info.Name,
+		AvatarURL: info.AvatarURL,
+	}
+
+	// The profile's email may be hidden, so ask for the primary one
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := get(ctx, "https://api.github.com/user/emails", token, &emails); err == nil {
+		for _, e := range emails {
+			if e.Primary && e.Verified {
+				user.Email = e.Email
+			}
+		}
+	}
+	return user, nil
+}
+
+// get fetches a JSON resource with an access token
+func get(ctx context.Context, resource, token string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resource, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return do(req, v)
+}
+
+// do sends a request and decodes its JSON response into v
+func do(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"realtime-chat/internal/webhook"
+	"testing"
+)
+
+func TestFetchAvatarRefusesInternalURLs(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image"))
+	}))
+	defer server.Close()
+
+	// The test server listens on a loopback address
+	if _, err := FetchAvatar(context.Background(), server.URL+"/avatar.png", 1024); !errors.Is(err, webhook.ErrPrivateAddress) {
+		t.Errorf("avatar on a loopback address: %v, want %v", err, webhook.ErrPrivateAddress)
+	}
+	for _, avatarURL := range []string{"http://example.com/avatar.png", "file:///etc/passwd", "ftp://example.com/avatar.png"} {
+		if _, err := FetchAvatar(context.Background(), avatarURL, 1024); !errors.Is(err, errAvatarURL) {
+			t.Errorf("avatar at %s: %v, want %v", avatarURL, err, errAvatarURL)
+		}
+	}
+}
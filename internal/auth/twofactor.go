@@ -0,0 +1,395 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/totp"
+	"strings"
+	"time"
+)
+
+// What a session still needs before it can be used
+const (
+	// SecondFactorNone means the session can be used
+	SecondFactorNone = ""
+
+	// SecondFactorVerify means a code from the account's authenticator app,
+	// or a backup code, must be entered
+	SecondFactorVerify = "verify"
+
+	// SecondFactorEnroll means the account must set up an authenticator app
+	// because the server requires two-factor login of it
+	SecondFactorEnroll = "enroll"
+)
+
+// backupCodeCount is how many backup codes are issued at a time
+const backupCodeCount = 10
+
+// maxFailures is how many wrong codes a session may enter before it is ended
+const maxFailures = 5
+
+// Errors returned by two-factor enrollment and verification
+var (
+	ErrInvalidCode       = errors.New("invalid code")
+	ErrTooManyFailures   = errors.New("too many wrong codes; log in again")
+	ErrTwoFactorEnabled  = errors.New("two-factor login is already on")
+	ErrTwoFactorOff      = errors.New("two-factor login is off")
+	ErrNotEnrolled       = errors.New("start enrolling an authenticator app first")
+	ErrTwoFactorRequired = errors.New("this server requires two-factor login of this account")
+)
+
+// TwoFactorStatus describes an account's two-factor login
+type TwoFactorStatus struct {
+	Enabled     bool      `json:"enabled"`
+	EnabledAt   time.Time `json:"enabledAt,omitempty"`
+	Required    bool      `json:"required"`    // The server requires it of the account
+	BackupCodes int       `json:"backupCodes"` // Unused backup codes left
+}
+
+// Pending returns the username of a session token and what the session
+// still needs before it can be used, such as SecondFactorVerify
+func (a *Auth) Pending(token string) (username, state string, ok bool) {
+	if token == "" {
+		return "", "", false
+	}
+
+	a.mutex.RLock()
+	session, ok := a.sessions[hashToken(token)]
+	if !ok || time.Now().After(session.ExpiresAt) || session.RoomID != "" {
+		// Embedded chat widgets' guests are only let into their room
+		a.mutex.RUnlock()
+		return "", "", false
+	}
+	username, verified := session.Username, session.Verified || session.Guest
+	enabled := a.totpEnabled(username)
+	a.mutex.RUnlock()
+
+	// Checked without the mutex since Privileged looks up roles elsewhere.
+	// Guests have no account to set up a second factor for.
+	if verified {
+		return username, SecondFactorNone, true
+	}
+	return username, a.secondFactor(username, enabled), true
+}
+
+// Required reports whether the server requires two-factor login of a user
+func (a *Auth) Required(username string) bool {
+	switch a.twoFactor {
+	case config.TwoFactorAll:
+		return true
+	case config.TwoFactorModerators:
+		return a.Privileged != nil && a.Privileged(username)
+	}
+	return false
+}
+
+// TwoFactor returns the state of an account's two-factor login
+func (a *Auth) TwoFactor(username string) (*TwoFactorStatus, error) {
+	a.mutex.RLock()
+	account, ok := a.accounts[username]
+	if !ok {
+		a.mutex.RUnlock()
+		return nil, ErrNoAccount
+	}
+	status := &TwoFactorStatus{}
+	if account.TOTP != nil && account.TOTP.Enabled {
+		status.Enabled = true
+		status.EnabledAt = account.TOTP.EnabledAt
+		status.BackupCodes = len(account.TOTP.BackupCodes)
+	}
+	a.mutex.RUnlock()
+
+	status.Required = a.Required(username)
+	return status, nil
+}
+
+// Enroll starts setting up an authenticator app for an account, replacing
+// any enrollment that was never confirmed, and returns its secret and the
+// otpauth URI to show as a QR code
+func (a *Auth) Enroll(username string) (secret, uri string, err error) {
+	if secret, err = totp.NewSecret(); err != nil {
+		return "", "", err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	current, ok := a.accounts[username]
+	if !ok {
+		return "", "", ErrNoAccount
+	}
+	if current.TOTP != nil && current.TOTP.Enabled {
+		return "", "", ErrTwoFactorEnabled
+	}
+	account := *current
+	account.TOTP = &store.TOTPRecord{Secret: secret}
+	if err := a.save(&account); err != nil {
+		return "", "", err
+	}
+	return secret, totp.URI(a.issuer(), username, secret), nil
+}
+
+// Enable turns two-factor login on once a code from the newly enrolled app
+// checks out. The session of token counts as verified; the account's other
+// sessions must enter a code before they can be used again, and their IDs
+// are returned. It also returns the account's backup codes, which are
+// only shown this once.
+func (a *Auth) Enable(token, code string) (codes, unverified []string, err error) {
+	if codes, err = totp.BackupCodes(backupCodeCount); err != nil {
+		return nil, nil, err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	session, ok := a.sessions[hashToken(token)]
+	if !ok {
+		return nil, nil, ErrNoSession
+	}
+	current, ok := a.accounts[session.Username]
+	switch {
+	case !ok:
+		return nil, nil, ErrNoAccount
+	case current.TOTP == nil:
+		return nil, nil, ErrNotEnrolled
+	case current.TOTP.Enabled:
+		return nil, nil, ErrTwoFactorEnabled
+	}
+
+	step, ok := totp.Validate(current.TOTP.Secret, code, time.Now(), 0)
+	if !ok {
+		return nil, nil, ErrInvalidCode
+	}
+	account := *current
+	account.TOTP = &store.TOTPRecord{
+		Secret:      current.TOTP.Secret,
+		Enabled:     true,
+		EnabledAt:   time.Now(),
+		LastStep:    step,
+		BackupCodes: hashBackupCodes(codes),
+	}
+	if err := a.save(&account); err != nil {
+		return nil, nil, err
+	}
+
+	for hash, other := range a.sessions {
+		switch {
+		case other.Username != session.Username:
+			continue
+		case hash == session.TokenHash:
+			err = a.updateSession(other, true, 0)
+		default:
+			unverified = append(unverified, SessionID(hash))
+			if other.Verified {
+				err = a.updateSession(other, false, 0)
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return codes, unverified, nil
+}
+
+// Verify completes the login of a session waiting for a second factor with
+// a code from the account's authenticator app or one of its backup codes,
+// which can then not be used again. After too many wrong codes the session
+// is ended.
+func (a *Auth) Verify(token, code string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	session, ok := a.sessions[hashToken(token)]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return ErrNoSession
+	}
+	current, ok := a.accounts[session.Username]
+	if !ok || current.TOTP == nil || !current.TOTP.Enabled {
+		return ErrNotEnrolled
+	}
+
+	updated, ok := checkCode(current.TOTP, code)
+	if !ok {
+		if session.Failures+1 >= maxFailures {
+			delete(a.sessions, session.TokenHash)
+			if a.store != nil {
+				if err := a.store.DeleteSession(session.TokenHash); err != nil {
+					return err
+				}
+			}
+			return ErrTooManyFailures
+		}
+		if err := a.updateSession(session, false, session.Failures+1); err != nil {
+			return err
+		}
+		return ErrInvalidCode
+	}
+
+	account := *current
+	account.TOTP = updated
+	if err := a.save(&account); err != nil {
+		return err
+	}
+	return a.updateSession(session, true, 0)
+}
+
+// RegenerateBackupCodes replaces an account's backup codes once a code
+// from its authenticator app checks out, and returns the new ones
+func (a *Auth) RegenerateBackupCodes(username, code string) ([]string, error) {
+	codes, err := totp.BackupCodes(backupCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	current, ok := a.accounts[username]
+	if !ok || current.TOTP == nil || !current.TOTP.Enabled {
+		return nil, ErrTwoFactorOff
+	}
+	step, ok := totp.Validate(current.TOTP.Secret, code, time.Now(), current.TOTP.LastStep)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	account := *current
+	updated := *current.TOTP
+	updated.LastStep = step
+	updated.BackupCodes = hashBackupCodes(codes)
+	account.TOTP = &updated
+	if err := a.save(&account); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns an account's two-factor login off with a code from its
+// authenticator app or a backup code, unless the server requires it
+func (a *Auth) Disable(username, code string) error {
+	if a.Required(username) {
+		return ErrTwoFactorRequired
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	current, ok := a.accounts[username]
+	if !ok || current.TOTP == nil || !current.TOTP.Enabled {
+		return ErrTwoFactorOff
+	}
+	if _, ok := checkCode(current.TOTP, code); !ok {
+		return ErrInvalidCode
+	}
+	account := *current
+	account.TOTP = nil
+	return a.save(&account)
+}
+
+// Reset turns an account's two-factor login off without a code, for users
+// who lost their authenticator app and backup codes. Its sessions must
+// verify again once it is turned back on.
+func (a *Auth) Reset(username string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	current, ok := a.accounts[username]
+	if !ok {
+		return ErrNoAccount
+	}
+	if current.TOTP == nil {
+		return ErrTwoFactorOff
+	}
+	account := *current
+	account.TOTP = nil
+	if err := a.save(&account); err != nil {
+		return err
+	}
+
+	for _, session := range a.sessions {
+		if session.Username == username && session.Verified {
+			if err := a.updateSession(session, false, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// secondFactor returns what a user's unverified session still needs
+func (a *Auth) secondFactor(username string, enabled bool) string {
+	switch {
+	case enabled:
+		return SecondFactorVerify
+	case a.Required(username):
+		return SecondFactorEnroll
+	}
+	return SecondFactorNone
+}
+
+// totpEnabled reports whether an account has two-factor login on. The
+// caller holds the mutex.
+func (a *Auth) totpEnabled(username string) bool {
+	account, ok := a.accounts[username]
+	return ok && account.TOTP != nil && account.TOTP.Enabled
+}
+
+// updateSession saves a copy of a session with its two-factor state
+// changed, so readers never see a partial update. The caller holds the
+// mutex for writing.
+func (a *Auth) updateSession(session *store.SessionRecord, verified bool, failures int) error {
+	updated := *session
+	updated.Verified = verified
+	updated.Failures = failures
+	if a.store != nil {
+		if err := a.store.SaveSession(&updated); err != nil {
+			return err
+		}
+	}
+	a.sessions[updated.TokenHash] = &updated
+	return nil
+}
+
+// issuer names the server in authenticator apps
+func (a *Auth) issuer() string {
+	if u, err := url.Parse(a.publicURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "Realtime Chat"
+}
+
+// checkCode checks a code from an authenticator app, or else a backup
+// code, and returns a copy of the enrollment with the code used up
+func checkCode(record *store.TOTPRecord, code string) (*store.TOTPRecord, bool) {
+	updated := *record
+	if step, ok := totp.Validate(record.Secret, code, time.Now(), record.LastStep); ok {
+		updated.LastStep = step
+		return &updated, true
+	}
+
+	hash := hashToken(normalizeBackupCode(code))
+	for i, stored := range record.BackupCodes {
+		if stored == hash {
+			updated.BackupCodes = append(append([]string(nil), record.BackupCodes[:i]...), record.BackupCodes[i+1:]...)
+			return &updated, true
+		}
+	}
+	return nil, false
+}
+
+// hashBackupCodes returns the stored form of backup codes
+func hashBackupCodes(codes []string) []string {
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = hashToken(normalizeBackupCode(code))
+	}
+	return hashes
+}
+
+// normalizeBackupCode accepts backup codes typed in either case, with or
+// without their dash
+func normalizeBackupCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
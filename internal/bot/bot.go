@@ -0,0 +1,271 @@
+package bot
+
+import (
+	"fmt"
+	"realtime-chat/internal/rbac"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ArgType is the type of a command argument
+type ArgType string
+
+const (
+	// ArgString is a single word
+	ArgString ArgType = "string"
+
+	// ArgInt is a whole number
+	ArgInt ArgType = "int"
+
+	// ArgText consumes the rest of the command line; it must be the last argument
+	ArgText ArgType = "text"
+)
+
+// Arg describes one positional argument of a command
+type Arg struct {
+	Name        string  `json:"name"`
+	Type        ArgType `json:"type"`
+	Required    bool    `json:"required"`
+	Description string  `json:"description,omitempty"`
+	Min         *int    `json:"min,omitempty"` // Lower bound for ArgInt
+	Max         *int    `json:"max,omitempty"` // Upper bound for ArgInt
+}
+
+// Invocation is a validated call of a command
+type Invocation struct {
+	RoomID   string
+	Username string
+	Args     map[string]interface{}
+}
+
+// String returns a string or text argument, or "" if it was not given
+func (inv *Invocation) String(name string) string {
+	s, _ := inv.Args[name].(string)
+	return s
+}
+
+// Int returns an int argument and whether it was given
+func (inv *Invocation) Int(name string) (int, bool) {
+	n, ok := inv.Args[name].(int)
+	return n, ok
+}
+
+// Handler runs a command and returns the reply to post in the room
+type Handler func(inv *Invocation) (string, error)
+
+// Command is a slash command provided by a bot
+type Command struct {
+	Name        string   // Invoked as /Name
+	Bot         string   // Name of the bot that owns the command; replies are posted as this user
+	Description string   // One-line summary shown by /help
+	Args        []Arg    // Positional arguments, validated before Handler runs
+	Rooms       []string // Rooms where the command is available; empty means every room
+	Handler     Handler
+
+	// Permission needed to run the command, such as rbac.PermModerate for
+	// moderation commands; empty means anyone who can post
+	Permission rbac.Permission
+}
+
+// Usage returns the command's usage line, e.g. "/roll <dice> [sides]"
+func (c *Command) Usage() string {
+	parts := []string{"/" + c.Name}
+	for _, arg := range c.Args {
+		name := arg.Name
+		if arg.Type == ArgText {
+			name += "..."
+		}
+		if arg.Required {
+			parts = append(parts, "<"+name+">")
+		} else {
+			parts = append(parts, "["+name+"]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// availableIn reports whether the command can be used in a room
+func (c *Command) availableIn(roomID string) bool {
+	if len(c.Rooms) == 0 {
+		return true
+	}
+	for _, id := range c.Rooms {
+		if id == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// Validation error codes returned to users
+const (
+	ErrUnknownCommand   = "unknown_command"
+	ErrMissingArgument  = "missing_argument"
+	ErrInvalidArgument  = "invalid_argument"
+	ErrTooManyArguments = "too_many_arguments"
+	ErrForbidden        = "forbidden"
+)
+
+// ValidationError reports a command line that doesn't match the command's schema
+type ValidationError struct {
+	Code     string `json:"code"`
+	Command  string `json:"command"`
+	Argument string `json:"argument,omitempty"`
+	Message  string `json:"message"`
+	Usage    string `json:"usage,omitempty"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Registry holds the commands registered by bots
+type Registry struct {
+	mutex    sync.RWMutex
+	commands map[string]*Command
+}
+
+// NewRegistry creates an empty command registry
+func NewRegistry() *Registry {
+	return &Registry{commands: make(map[string]*Command)}
+}
+
+// Register adds a command, rejecting duplicate names and malformed schemas
+func (r *Registry) Register(cmd *Command) error {
+	if cmd.Name == "" || strings.ContainsAny(cmd.Name, " /") {
+		return fmt.Errorf("invalid command name %q", cmd.Name)
+	}
+	if cmd.Name == "help" {
+		return fmt.Errorf("command name %q is reserved", cmd.Name)
+	}
+	if cmd.Handler == nil {
+		return fmt.Errorf("command /%s has no handler", cmd.Name)
+	}
+
+	optional := false
+	for i, arg := range cmd.Args {
+		switch arg.Type {
+		case ArgString, ArgInt:
+		case ArgText:
+			if i != len(cmd.Args)-1 {
+				return fmt.Errorf("command /%s: text argument %q must be last", cmd.Name, arg.Name)
+			}
+		default:
+			return fmt.Errorf("command /%s: argument %q has unknown type %q", cmd.Name, arg.Name, arg.Type)
+		}
+		if arg.Required && optional {
+			return fmt.Errorf("command /%s: required argument %q follows an optional one", cmd.Name, arg.Name)
+		}
+		optional = optional || !arg.Required
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.commands[cmd.Name]; exists {
+		return fmt.Errorf("command /%s is already registered", cmd.Name)
+	}
+	r.commands[cmd.Name] = cmd
+	return nil
+}
+
+// Available returns the commands usable in a room, sorted by name
+func (r *Registry) Available(roomID string) []*Command {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	commands := make([]*Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		if cmd.availableIn(roomID) {
+			commands = append(commands, cmd)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// Parse looks up the command for a "/name args..." line and validates its arguments
+func (r *Registry) Parse(roomID, username, line string) (*Command, *Invocation, error) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(line), "/"), " ")
+
+	r.mutex.RLock()
+	cmd, exists := r.commands[name]
+	r.mutex.RUnlock()
+
+	if !exists || !cmd.availableIn(roomID) {
+		return nil, nil, &ValidationError{
+			Code:    ErrUnknownCommand,
+			Command: name,
+			Message: fmt.Sprintf("Unknown command /%s, type /help for a list of commands", name),
+		}
+	}
+
+	args, err := cmd.parseArgs(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cmd, &Invocation{RoomID: roomID, Username: username, Args: args}, nil
+}
+
+// parseArgs validates a command line against the command's argument schema
+func (c *Command) parseArgs(line string) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	fields := strings.Fields(line)
+
+	for i, arg := range c.Args {
+		if i >= len(fields) {
+			if arg.Required {
+				return nil, c.validationError(ErrMissingArgument, arg.Name, fmt.Sprintf("Missing required argument %q", arg.Name))
+			}
+			break
+		}
+
+		switch arg.Type {
+		case ArgString:
+			args[arg.Name] = fields[i]
+
+		case ArgText:
+			args[arg.Name] = strings.Join(fields[i:], " ")
+			return args, nil
+
+		case ArgInt:
+			n, err := strconv.Atoi(fields[i])
+			if err != nil {
+				return nil, c.validationError(ErrInvalidArgument, arg.Name, fmt.Sprintf("Argument %q must be a whole number", arg.Name))
+			}
+			if arg.Min != nil && n < *arg.Min {
+				return nil, c.validationError(ErrInvalidArgument, arg.Name, fmt.Sprintf("Argument %q must be at least %d", arg.Name, *arg.Min))
+			}
+			if arg.Max != nil && n > *arg.Max {
+				return nil, c.validationError(ErrInvalidArgument, arg.Name, fmt.Sprintf("Argument %q must be at most %d", arg.Name, *arg.Max))
+			}
+			args[arg.Name] = n
+		}
+	}
+
+	if len(fields) > len(c.Args) {
+		return nil, c.validationError(ErrTooManyArguments, "", fmt.Sprintf("/%s takes at most %d arguments", c.Name, len(c.Args)))
+	}
+	return args, nil
+}
+
+// validationError builds a ValidationError for this command
+func (c *Command) validationError(code, argument, message string) *ValidationError {
+	return &ValidationError{
+		Code:     code,
+		Command:  c.Name,
+		Argument: argument,
+		Message:  message,
+		Usage:    c.Usage(),
+	}
+}
+
+// IntPtr returns a pointer to n, for use as an argument bound
+func IntPtr(n int) *int {
+	return &n
+}
@@ -0,0 +1,418 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/presence"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// missedHeartbeats is how many announcements a node may miss before it is
+// dropped from the cluster
+const missedHeartbeats = 3
+
+// forwardTimeout is how long a node waits for another to confirm a frame
+// forwarded to one of its users was delivered
+const forwardTimeout = 2 * time.Second
+
+// changeDelay is the shortest time between announcements made because this
+// node's presence changed, so a burst of joins is announced once
+const changeDelay = 250 * time.Millisecond
+
+// Envelope carries a frame from the node it was sent on to the others
+type Envelope struct {
+	Node     string          `json:"node"`
+	RoomID   string          `json:"roomId,omitempty"`   // Empty for frames sent to every client
+	To       string          `json:"to,omitempty"`       // User a forwarded frame is for
+	Priority bool            `json:"priority,omitempty"` // Admin, moderation or system frames delivered ahead of chat traffic
+	Author   string          `json:"author,omitempty"`   // User whose message a room frame carries, hidden from users who block them
+	Frame    json.RawMessage `json:"frame"`
+}
+
+// Node is a member of the cluster as last announced in its heartbeat
+type Node struct {
+	ID      string         `json:"id"`
+	Clients int            `json:"clients"`         // Connected clients
+	Rooms   map[string]int `json:"rooms,omitempty"` // Connected clients by room
+	SeenAt  time.Time      `json:"seenAt"`
+	Leaving bool           `json:"leaving,omitempty"` // Set in the last heartbeat of a node shutting down
+
+	// Usernames of the connected clients by room
+	Members map[string][]string `json:"members,omitempty"`
+
+	// Every user connected to the node with their presence status
+	Users map[string]presence.Status `json:"users,omitempty"`
+}
+
+// Cluster shares broadcasts and presence with the other nodes through a
+// NATS server. Room frames are published to <subject>.room.<id>, frames for
+// every client to <subject>.global and heartbeats to <subject>.nodes. Every
+// node subscribes to the frame subjects in a queue group of its own, so
+// each node receives each frame exactly once even if it subscribes again
+// after reconnecting. Frames for one user, such as direct messages, are
+// forwarded to <subject>.node.<id> of the nodes the user is connected to.
+//
+// Heartbeats carry the node's users and room members, so together they form
+// a presence registry of the whole cluster. A node's entries expire with it
+// when it stops announcing itself.
+type Cluster struct {
+	conn      *nats.Conn
+	nodeID    string
+	subject   string
+	heartbeat time.Duration
+
+	// Deliver is called with every frame published by another node. It must
+	// be set before Start.
+	Deliver func(env *Envelope)
+
+	// Local returns this node's counts for its heartbeats. It must be set
+	// before Start.
+	Local func() *Node
+
+	// DeliverUser is called with every frame forwarded to one of this
+	// node's users and reports whether any of their clients got it. It must
+	// be set before Start.
+	DeliverUser func(env *Envelope) bool
+
+	// Signalled when this node's presence changes
+	changed chan struct{}
+
+	mutex sync.RWMutex
+	peers map[string]*Node
+}
+
+// Connect joins the NATS server in cfg. Frames are only received once Start is called.
+func Connect(cfg config.ClusterConfig) (*Cluster, error) {
+	nodeID := cfg.NodeID
+	if nodeID == "" {
+		b := make([]byte, 4)
+		rand.Read(b)
+		nodeID = "node-" + hex.EncodeToString(b)
+	}
+
+	conn, err := nats.Connect(cfg.NATSURL,
+		nats.Name("realtime-chat "+nodeID),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("Disconnected from NATS: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Printf("Reconnected to NATS at %s", nc.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("connect to NATS: %w", err)
+	}
+
+	return &Cluster{
+		conn:      conn,
+		nodeID:    nodeID,
+		subject:   cfg.Subject,
+		heartbeat: cfg.Heartbeat,
+		changed:   make(chan struct{}, 1),
+		peers:     make(map[string]*Node),
+	}, nil
+}
+
+// NodeID returns the name of this node
+func (c *Cluster) NodeID() string {
+	return c.nodeID
+}
+
+// Start subscribes to the frames and heartbeats of the other nodes and
+// announces this node until ctx is cancelled
+func (c *Cluster) Start(ctx context.Context) error {
+	queue := "node-" + c.nodeID
+	if _, err := c.conn.QueueSubscribe(c.subject+".room.>", queue, c.receive); err != nil {
+		return fmt.Errorf("subscribe to room frames: %w", err)
+	}
+	if _, err := c.conn.QueueSubscribe(c.subject+".global", queue, c.receive); err != nil {
+		return fmt.Errorf("subscribe to global frames: %w", err)
+	}
+	if _, err := c.conn.QueueSubscribe(c.subject+".node."+c.nodeID, queue, c.receiveForward); err != nil {
+		return fmt.Errorf("subscribe to forwarded frames: %w", err)
+	}
+	if _, err := c.conn.Subscribe(c.subject+".nodes", c.receiveHeartbeat); err != nil {
+		return fmt.Errorf("subscribe to heartbeats: %w", err)
+	}
+
+	go c.announce(ctx)
+	log.Printf("Joined cluster as %s", c.nodeID)
+	return nil
+}
+
+// Publish sends a frame for a room's clients, or every client when roomID
+// is empty, to the other nodes. Failures are logged, since the frame has
+// already been delivered on this node.
+func (c *Cluster) Publish(roomID string, frame []byte, priority bool) {
+	c.PublishFrom(roomID, "", frame, priority)
+}
+
+// PublishFrom is Publish for a frame carrying a message of author, which
+// the other nodes don't deliver to users who block them
+func (c *Cluster) PublishFrom(roomID, author string, frame []byte, priority bool) {
+	data, err := json.Marshal(&Envelope{Node: c.nodeID, RoomID: roomID, Priority: priority, Author: author, Frame: frame})
+	if err != nil {
+		log.Printf("Error encoding cluster frame: %v", err)
+		return
+	}
+
+	subject := c.subject + ".global"
+	if roomID != "" {
+		subject = c.subject + ".room." + roomID
+	}
+	if err := c.conn.Publish(subject, data); err != nil {
+		log.Printf("Error publishing to %s: %v", subject, err)
+	}
+}
+
+// Forward sends a frame for a user to another node and reports whether the
+// node delivered it to any of the user's clients
+func (c *Cluster) Forward(nodeID, username string, frame []byte) (bool, error) {
+	data, err := json.Marshal(&Envelope{Node: c.nodeID, To: username, Frame: frame})
+	if err != nil {
+		return false, err
+	}
+
+	reply, err := c.conn.Request(c.subject+".node."+nodeID, data, forwardTimeout)
+	if err != nil {
+		return false, fmt.Errorf("forward to %s: %w", nodeID, err)
+	}
+
+	var result struct {
+		Delivered bool `json:"delivered"`
+	}
+	if err := json.Unmarshal(reply.Data, &result); err != nil {
+		return false, fmt.Errorf("decode reply from %s: %w", nodeID, err)
+	}
+	return result.Delivered, nil
+}
+
+// Peers returns every other node that is still announcing itself, by ID
+func (c *Cluster) Peers() []Node {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	peers := make([]Node, 0, len(c.peers))
+	for _, node := range c.peers {
+		if c.live(node) {
+			peers = append(peers, *node)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
+
+// RemoteClients returns how many clients are connected to the other nodes
+func (c *Cluster) RemoteClients() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	total := 0
+	for _, node := range c.peers {
+		if c.live(node) {
+			total += node.Clients
+		}
+	}
+	return total
+}
+
+// RemoteRoomClients returns how many clients in a room are connected to the other nodes
+func (c *Cluster) RemoteRoomClients(roomID string) int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	total := 0
+	for _, node := range c.peers {
+		if c.live(node) {
+			total += node.Rooms[roomID]
+		}
+	}
+	return total
+}
+
+// Locate returns the IDs of the other nodes the user is connected to
+func (c *Cluster) Locate(username string) []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var nodes []string
+	for id, node := range c.peers {
+		if _, ok := node.Users[username]; ok && c.live(node) {
+			nodes = append(nodes, id)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// RemoteStatus returns the presence status of a user connected to another
+// node, and whether they are
+func (c *Cluster) RemoteStatus(username string) (presence.Status, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, node := range c.peers {
+		if status, ok := node.Users[username]; ok && c.live(node) {
+			return status, true
+		}
+	}
+	return presence.Status{}, false
+}
+
+// RemoteMembers returns the usernames in a room connected to the other
+// nodes; a user connected to several nodes is listed once per connection
+func (c *Cluster) RemoteMembers(roomID string) []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var members []string
+	for _, node := range c.peers {
+		if c.live(node) {
+			members = append(members, node.Members[roomID]...)
+		}
+	}
+	return members
+}
+
+// Changed tells the other nodes soon that this node's users, rooms or
+// statuses changed, instead of waiting for the next heartbeat
+func (c *Cluster) Changed() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Close tells the other nodes this one is leaving and disconnects from NATS
+func (c *Cluster) Close() {
+	node := c.Local()
+	node.Leaving = true
+	c.publishHeartbeat(node)
+	if err := c.conn.FlushTimeout(time.Second); err != nil {
+		log.Printf("Error flushing NATS connection: %v", err)
+	}
+	c.conn.Close()
+}
+
+// receive passes frames published by other nodes to Deliver
+func (c *Cluster) receive(msg *nats.Msg) {
+	var env Envelope
+	if err := json.Unmarshal(msg.Data, &env); err != nil {
+		log.Printf("Error decoding cluster frame on %s: %v", msg.Subject, err)
+		return
+	}
+	if env.Node == c.nodeID {
+		return
+	}
+	c.Deliver(&env)
+}
+
+// receiveForward passes a frame forwarded to one of this node's users to
+// DeliverUser and tells the sending node whether it was delivered
+func (c *Cluster) receiveForward(msg *nats.Msg) {
+	var env Envelope
+	if err := json.Unmarshal(msg.Data, &env); err != nil {
+		log.Printf("Error decoding forwarded frame: %v", err)
+		return
+	}
+
+	reply, _ := json.Marshal(map[string]bool{"delivered": c.DeliverUser(&env)})
+	if err := msg.Respond(reply); err != nil {
+		log.Printf("Error answering forwarded frame from %s: %v", env.Node, err)
+	}
+}
+
+// receiveHeartbeat records another node's announcement
+func (c *Cluster) receiveHeartbeat(msg *nats.Msg) {
+	var node Node
+	if err := json.Unmarshal(msg.Data, &node); err != nil {
+		log.Printf("Error decoding heartbeat: %v", err)
+		return
+	}
+	if node.ID == c.nodeID {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, known := c.peers[node.ID]
+	if node.Leaving {
+		if known {
+			delete(c.peers, node.ID)
+			log.Printf("Node %s left the cluster", node.ID)
+		}
+		return
+	}
+	if !known {
+		log.Printf("Node %s joined the cluster", node.ID)
+	}
+	// Judge liveness by our clock, not the other node's
+	node.SeenAt = time.Now()
+	c.peers[node.ID] = &node
+}
+
+// announce publishes this node's heartbeat and drops silent nodes until ctx is cancelled
+func (c *Cluster) announce(ctx context.Context) {
+	ticker := time.NewTicker(c.heartbeat)
+	defer ticker.Stop()
+
+	c.publishHeartbeat(c.Local())
+	for {
+		select {
+		case <-ticker.C:
+			c.publishHeartbeat(c.Local())
+			c.dropSilent()
+		case <-c.changed:
+			c.publishHeartbeat(c.Local())
+			// Let further changes collect before announcing them
+			select {
+			case <-time.After(changeDelay):
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publishHeartbeat announces a node's counts
+func (c *Cluster) publishHeartbeat(node *Node) {
+	node.ID = c.nodeID
+	node.SeenAt = time.Now()
+	data, _ := json.Marshal(node)
+	if err := c.conn.Publish(c.subject+".nodes", data); err != nil {
+		log.Printf("Error publishing heartbeat: %v", err)
+	}
+}
+
+// dropSilent forgets nodes that have stopped announcing themselves
+func (c *Cluster) dropSilent() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for id, node := range c.peers {
+		if !c.live(node) {
+			delete(c.peers, id)
+			log.Printf("Node %s stopped responding and was dropped from the cluster", id)
+		}
+	}
+}
+
+// live reports whether a node has announced itself recently; the caller must hold the lock
+func (c *Cluster) live(node *Node) bool {
+	return time.Since(node.SeenAt) < missedHeartbeats*c.heartbeat
+}
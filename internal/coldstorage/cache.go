@@ -0,0 +1,67 @@
+package coldstorage
+
+import (
+	"container/list"
+	"realtime-chat/internal/history"
+	"sync"
+)
+
+// cache keeps the messages of the segments read most recently
+type cache struct {
+	size int
+
+	mutex   sync.Mutex
+	order   *list.List // Most recently used first
+	entries map[string]*list.Element
+}
+
+// entry is a cached segment
+type entry struct {
+	object   string
+	messages []*history.Message
+}
+
+// newCache returns a cache of up to size segments
+func newCache(size int) *cache {
+	return &cache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns a cached segment's messages
+func (c *cache) get(object string) ([]*history.Message, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[object]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*entry).messages, true
+}
+
+// add caches a segment's messages, evicting the least recently used
+// segment when the cache is full
+func (c *cache) add(object string, messages []*history.Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[object]; ok {
+		element.Value.(*entry).messages = messages
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[object] = c.order.PushFront(&entry{object: object, messages: messages})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).object)
+	}
+}
+
+// remove forgets a segment
+func (c *cache) remove(object string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[object]; ok {
+		c.order.Remove(element)
+		delete(c.entries, object)
+	}
+}
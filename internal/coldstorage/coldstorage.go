@@ -0,0 +1,674 @@
+// Package coldstorage moves messages older than a threshold out of rooms'
+// history into cold storage: gzip-compressed JSON Lines segments in an
+// S3-compatible bucket, encrypted with the store's keys when message content
+// is encrypted at rest. The segments are listed in the store, so history
+// can be paged back into them long after they left memory.
+package coldstorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/store"
+	"sort"
+	"sync"
+	"time"
+)
+
+// partialAge is how far past the threshold a room's oldest message must be
+// before fewer messages than fill a segment are compacted, so quiet rooms
+// are compacted a day at a time rather than a few messages at a time
+const partialAge = 24 * time.Hour
+
+// ErrStorage is returned when the bucket can't be reached or refuses a request
+var ErrStorage = errors.New("cold storage unavailable")
+
+// Report describes what a compaction moved to cold storage
+type Report struct {
+	Segments       int   `json:"segments"` // Segments written
+	Messages       int   `json:"messages"` // Messages moved
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+
+	// Segments removed by retention policies or along with their room
+	Dropped int `json:"dropped"`
+
+	// Problems that didn't stop the rest of the compaction
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Tier compacts rooms' old messages into segments in a bucket and reads
+// them back
+type Tier struct {
+	bucket      *bucket
+	prefix      string
+	after       time.Duration
+	segmentSize int
+	history     *history.History
+	store       store.Store // may be nil, keeping the list of segments in memory only
+
+	// Policy returns the days and number of messages of history a room
+	// keeps, 0 keeping everything; messages in cold storage count toward both.
+	// May be nil, keeping every segment.
+	Policy func(roomID string) (days, messages int)
+
+	mutex    sync.RWMutex
+	segments map[string][]*store.ColdSegmentRecord // Room to its segments, oldest first
+
+	// Segments read back recently
+	cache *cache
+
+	// Compactions and erasures rewrite segments, so run one at a time
+	working sync.Mutex
+}
+
+// New returns a cold storage tier for h's messages in the bucket cfg names, or nil
+// when no bucket is configured
+func New(cfg config.ColdStorageConfig, h *history.History, st store.Store) *Tier {
+	if cfg.Bucket == "" {
+		return nil
+	}
+	return &Tier{
+		bucket:      newBucket(cfg),
+		prefix:      cfg.Prefix,
+		after:       cfg.After,
+		segmentSize: cfg.SegmentSize,
+		history:     h,
+		store:       st,
+		segments:    make(map[string][]*store.ColdSegmentRecord),
+		cache:       newCache(cfg.CacheSize),
+	}
+}
+
+// Load reads the list of segments from the store
+func (t *Tier) Load() error {
+	if t.store == nil {
+		return nil
+	}
+	segments, err := t.store.LoadColdSegments()
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, segment := range segments {
+		t.segments[segment.RoomID] = append(t.segments[segment.RoomID], segment)
+	}
+	for _, room := range t.segments {
+		sort.Slice(room, func(i, j int) bool {
+			return room[i].FirstSeq < room[j].FirstSeq
+		})
+	}
+	return nil
+}
+
+// Segments returns a room's segments, oldest first
+func (t *Tier) Segments(roomID string) []*store.ColdSegmentRecord {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	segments := make([]*store.ColdSegmentRecord, 0, len(t.segments[roomID]))
+	for _, segment := range t.segments[roomID] {
+		s := *segment
+		segments = append(segments, &s)
+	}
+	return segments
+}
+
+// Count returns how many of a room's messages are in cold storage
+func (t *Tier) Count(roomID string) int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	count := 0
+	for _, segment := range t.segments[roomID] {
+		count += segment.Messages
+	}
+	return count
+}
+
+// LastSeq returns the sequence number of a room's newest message in cold storage,
+// or 0 when none is
+func (t *Tier) LastSeq(roomID string) int64 {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	room := t.segments[roomID]
+	if len(room) == 0 {
+		return 0
+	}
+	return room[len(room)-1].LastSeq
+}
+
+// Compact moves the messages of the given rooms that are older than the
+// threshold into new segments, removing them from history, drops segments
+// past their room's retention policy, and drops every segment of rooms not
+// listed, which no longer exist
+func (t *Tier) Compact(ctx context.Context, roomIDs []string) *Report {
+	t.working.Lock()
+	defer t.working.Unlock()
+
+	report := &Report{}
+	failed := func(format string, args ...interface{}) {
+		report.Errors = append(report.Errors, fmt.Sprintf(format, args...))
+	}
+
+	cutoff := time.Now().Add(-t.after)
+	live := make(map[string]bool, len(roomIDs))
+	for _, roomID := range roomIDs {
+		live[roomID] = true
+		if err := t.compactRoom(ctx, roomID, cutoff, report); err != nil {
+			failed("compact room %s: %v", roomID, err)
+		}
+		if err := t.expireRoom(ctx, roomID, report); err != nil {
+			failed("expire cold storage of room %s: %v", roomID, err)
+		}
+	}
+
+	var gone []string
+	t.mutex.RLock()
+	for roomID := range t.segments {
+		if !live[roomID] {
+			gone = append(gone, roomID)
+		}
+	}
+	t.mutex.RUnlock()
+	for _, roomID := range gone {
+		segments := t.Segments(roomID)
+		if err := t.drop(ctx, segments); err != nil {
+			failed("drop cold storage of room %s: %v", roomID, err)
+			continue
+		}
+		report.Dropped += len(segments)
+	}
+
+	metrics.MessagesCompacted.Add(int64(report.Messages))
+	log.Printf("Cold storage wrote %d segments of %d messages, reclaiming %d bytes of history, and dropped %d segments",
+		report.Segments, report.Messages, report.ReclaimedBytes, report.Dropped)
+	return report
+}
+
+// compactRoom moves a room's messages posted before the cutoff, a
+// segment at a time
+func (t *Tier) compactRoom(ctx context.Context, roomID string, cutoff time.Time, report *Report) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		messages, err := t.history.Older(roomID, cutoff, t.segmentSize)
+		if err != nil {
+			return err
+		}
+		full := len(messages) == t.segmentSize
+		if len(messages) == 0 || (!full && !messages[0].Timestamp.Before(cutoff.Add(-partialAge))) {
+			return nil
+		}
+
+		// Tombstones are left out; they only leave history
+		kept := make([]*history.Message, 0, len(messages))
+		for _, msg := range messages {
+			if !msg.Deleted {
+				kept = append(kept, msg)
+			}
+		}
+		if len(kept) > 0 {
+			segment := &store.ColdSegmentRecord{
+				RoomID:   roomID,
+				FirstSeq: messages[0].Seq,
+				LastSeq:  messages[len(messages)-1].Seq,
+			}
+			segment.Object = fmt.Sprintf("%s%s/%020d-%020d.jsonl.gz", t.prefix, roomID, segment.FirstSeq, segment.LastSeq)
+			if err := t.write(ctx, segment, kept); err != nil {
+				return err
+			}
+			report.Segments++
+		}
+
+		// A segment whose messages changed meanwhile is written again next time
+		reclaimed, err := t.history.Compacted(roomID, messages)
+		if errors.Is(err, history.ErrChanged) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		report.Messages += len(kept)
+		report.ReclaimedBytes += reclaimed
+		if !full {
+			return nil
+		}
+	}
+}
+
+// expireRoom drops a room's segments that its retention policy no longer
+// keeps: those whose newest message is older than its days, and those whose
+// every message is beyond its newest messages
+func (t *Tier) expireRoom(ctx context.Context, roomID string, report *Report) error {
+	if t.Policy == nil {
+		return nil
+	}
+	days, keep := t.Policy(roomID)
+	if days == 0 && keep == 0 {
+		return nil
+	}
+
+	newer := 0
+	if keep > 0 {
+		messages, err := t.history.Messages(roomID)
+		if err != nil {
+			return err
+		}
+		newer = len(messages)
+	}
+	segments := t.Segments(roomID)
+	var expired []*store.ColdSegmentRecord
+	for i := len(segments) - 1; i >= 0; i-- {
+		segment := segments[i]
+		tooOld := days > 0 && segment.To.Before(time.Now().AddDate(0, 0, -days))
+		tooMany := keep > 0 && newer >= keep
+		if tooOld || tooMany {
+			expired = append(expired, segment)
+		}
+		newer += segment.Messages
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	if err := t.drop(ctx, expired); err != nil {
+		return err
+	}
+	report.Dropped += len(expired)
+	return nil
+}
+
+// Before returns up to limit of a room's messages in cold storage numbered
+// below seq, oldest first. more reports whether older ones are there.
+func (t *Tier) Before(ctx context.Context, roomID string, seq int64, limit int) (page []*history.Message, more bool, err error) {
+	page = []*history.Message{}
+	segments := t.Segments(roomID)
+	for i := len(segments) - 1; i >= 0; i-- {
+		segment := segments[i]
+		if segment.FirstSeq >= seq {
+			continue
+		}
+		if len(page) == limit {
+			return page, true, nil
+		}
+		messages, err := t.read(ctx, segment)
+		if err != nil {
+			return nil, false, err
+		}
+
+		var older []*history.Message
+		for _, msg := range messages {
+			if msg.Seq < seq {
+				older = append(older, clone(msg))
+			}
+		}
+		if n := limit - len(page); len(older) > n {
+			return append(older[len(older)-n:], page...), true, nil
+		}
+		if len(older) > 0 {
+			page = append(older, page...)
+		}
+	}
+	return page, false, nil
+}
+
+// After returns up to limit of a room's messages in cold storage numbered
+// above seq, oldest first. more reports whether newer ones are there.
+func (t *Tier) After(ctx context.Context, roomID string, seq int64, limit int) (page []*history.Message, more bool, err error) {
+	page = []*history.Message{}
+	for _, segment := range t.Segments(roomID) {
+		if segment.LastSeq <= seq {
+			continue
+		}
+		if len(page) == limit {
+			return page, true, nil
+		}
+		messages, err := t.read(ctx, segment)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, msg := range messages {
+			if msg.Seq <= seq {
+				continue
+			}
+			if len(page) == limit {
+				return page, true, nil
+			}
+			page = append(page, clone(msg))
+		}
+	}
+	return page, false, nil
+}
+
+// Find returns a room's message from cold storage, or history.ErrNotFound. Segments
+// are searched newest first, since recent messages are looked up most.
+func (t *Tier) Find(ctx context.Context, roomID, messageID string) (*history.Message, error) {
+	segments := t.Segments(roomID)
+	for i := len(segments) - 1; i >= 0; i-- {
+		messages, err := t.read(ctx, segments[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			if msg.ID == messageID {
+				return clone(msg), nil
+			}
+		}
+	}
+	return nil, history.ErrNotFound
+}
+
+// Messages returns every message of a room in cold storage, oldest first
+func (t *Tier) Messages(ctx context.Context, roomID string) ([]*history.Message, error) {
+	messages, _, err := t.After(ctx, roomID, 0, math.MaxInt)
+	return messages, err
+}
+
+// Authored returns a room's messages in cold storage by their author,
+// oldest first. Only segments listed before their authors were kept are read.
+func (t *Tier) Authored(ctx context.Context, roomID string) (map[string][]store.ColdMessage, error) {
+	authored := make(map[string][]store.ColdMessage)
+	for _, segment := range t.Segments(roomID) {
+		if segment.Authors != nil {
+			for username, messages := range segment.Authors {
+				authored[username] = append(authored[username], messages...)
+			}
+			continue
+		}
+		messages, err := t.read(ctx, segment)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			authored[msg.Username] = append(authored[msg.Username], store.ColdMessage{ID: msg.ID, Seq: msg.Seq, Timestamp: msg.Timestamp})
+		}
+	}
+	return authored, nil
+}
+
+// Holds reports whether a room's message with a sequence number is in cold
+// storage, without reading it
+func (t *Tier) Holds(roomID string, seq int64) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	for _, segment := range t.segments[roomID] {
+		if segment.FirstSeq <= seq && seq <= segment.LastSeq {
+			return true
+		}
+	}
+	return false
+}
+
+// Message returns a room's message in cold storage with a sequence number,
+// reading only the segment that holds it
+func (t *Tier) Message(ctx context.Context, roomID string, seq int64) (*history.Message, error) {
+	for _, segment := range t.Segments(roomID) {
+		if seq < segment.FirstSeq || seq > segment.LastSeq {
+			continue
+		}
+		messages, err := t.read(ctx, segment)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			if msg.Seq == seq {
+				return clone(msg), nil
+			}
+		}
+	}
+	return nil, history.ErrNotFound
+}
+
+// Forget drops every segment of a room, for rooms whose history was removed
+func (t *Tier) Forget(ctx context.Context, roomID string) error {
+	t.working.Lock()
+	defer t.working.Unlock()
+	return t.drop(ctx, t.Segments(roomID))
+}
+
+// EraseUser removes a user from every segment like history.EraseUser: with
+// purge their messages and reactions are removed, otherwise they are kept
+// under pseudonym. Segments are rewritten in place. Returns how many of
+// their messages were purged or anonymized, and the rooms whose segments
+// changed.
+func (t *Tier) EraseUser(ctx context.Context, username, pseudonym string, purge bool) (int, []string, error) {
+	t.working.Lock()
+	defer t.working.Unlock()
+
+	t.mutex.RLock()
+	roomIDs := make([]string, 0, len(t.segments))
+	for roomID := range t.segments {
+		roomIDs = append(roomIDs, roomID)
+	}
+	t.mutex.RUnlock()
+	sort.Strings(roomIDs)
+
+	erased := 0
+	var changedRooms []string
+	for _, roomID := range roomIDs {
+		roomChanged := false
+		for _, segment := range t.Segments(roomID) {
+			messages, err := t.read(ctx, segment)
+			if err != nil {
+				return erased, changedRooms, err
+			}
+
+			changed := false
+			kept := make([]*history.Message, 0, len(messages))
+			for _, msg := range messages {
+				msg = clone(msg)
+				if msg.Username == username {
+					erased++
+					changed = true
+					if purge {
+						continue
+					}
+					msg.Username = pseudonym
+				}
+				for emoji, users := range msg.Reactions {
+					for i := 0; i < len(users); i++ {
+						if users[i] != username {
+							continue
+						}
+						changed = true
+						if purge {
+							users = append(users[:i], users[i+1:]...)
+							i--
+						} else {
+							users[i] = pseudonym
+						}
+					}
+					if len(users) == 0 {
+						delete(msg.Reactions, emoji)
+					} else {
+						msg.Reactions[emoji] = users
+					}
+				}
+				kept = append(kept, msg)
+			}
+			if !changed {
+				continue
+			}
+			roomChanged = true
+
+			if len(kept) == 0 {
+				err = t.drop(ctx, []*store.ColdSegmentRecord{segment})
+			} else {
+				err = t.write(ctx, segment, kept)
+			}
+			if err != nil {
+				return erased, changedRooms, err
+			}
+		}
+		if roomChanged {
+			changedRooms = append(changedRooms, roomID)
+		}
+	}
+	return erased, changedRooms, nil
+}
+
+// write uploads messages as a segment and lists it, in place of any listed
+// segment whose messages it overlaps: those were written before but never
+// left history, and are stale
+func (t *Tier) write(ctx context.Context, segment *store.ColdSegmentRecord, messages []*history.Message) error {
+	var data bytes.Buffer
+	compressor := gzip.NewWriter(&data)
+	encoder := json.NewEncoder(compressor)
+	for _, msg := range messages {
+		if err := encoder.Encode(msg); err != nil {
+			return err
+		}
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	segment.From = messages[0].Timestamp.UTC()
+	segment.To = messages[len(messages)-1].Timestamp.UTC()
+	segment.Messages = len(messages)
+	segment.Authors = make(map[string][]store.ColdMessage)
+	for _, msg := range messages {
+		segment.Authors[msg.Username] = append(segment.Authors[msg.Username], store.ColdMessage{ID: msg.ID, Seq: msg.Seq, Timestamp: msg.Timestamp})
+	}
+
+	// Segments are encrypted with the keys of the store's message content
+	object, contentType := data.Bytes(), "application/gzip"
+	if sealer, ok := t.store.(store.BlobSealer); ok {
+		sealed, err := sealer.SealBlob(object, segment.Object)
+		if err != nil {
+			return err
+		}
+		if store.Sealed(sealed) {
+			object, contentType = sealed, "application/octet-stream"
+		}
+	}
+	segment.Bytes = int64(len(object))
+	if err := t.bucket.put(ctx, segment.Object, contentType, object); err != nil {
+		return err
+	}
+	if t.store != nil {
+		if err := t.store.SaveColdSegment(segment); err != nil {
+			return err
+		}
+	}
+	t.cache.add(segment.Object, messages)
+
+	t.mutex.Lock()
+	var stale []*store.ColdSegmentRecord
+	room := []*store.ColdSegmentRecord{segment}
+	for _, listed := range t.segments[segment.RoomID] {
+		switch {
+		case listed.Key() == segment.Key():
+			if listed.Object != segment.Object {
+				stale = append(stale, listed)
+			}
+		case listed.FirstSeq <= segment.LastSeq && segment.FirstSeq <= listed.LastSeq:
+			stale = append(stale, listed)
+		default:
+			room = append(room, listed)
+		}
+	}
+	sort.Slice(room, func(i, j int) bool {
+		return room[i].FirstSeq < room[j].FirstSeq
+	})
+	t.segments[segment.RoomID] = room
+	t.mutex.Unlock()
+
+	for _, listed := range stale {
+		if listed.Key() != segment.Key() && t.store != nil {
+			if err := t.store.DeleteColdSegment(listed.Key()); err != nil {
+				log.Printf("Error unlisting stale cold segment %s: %v", listed.Object, err)
+			}
+		}
+		if err := t.bucket.remove(ctx, listed.Object); err != nil {
+			log.Printf("Error removing stale cold segment %s: %v", listed.Object, err)
+		}
+		t.cache.remove(listed.Object)
+	}
+	return nil
+}
+
+// drop removes segments from the bucket and the list
+func (t *Tier) drop(ctx context.Context, segments []*store.ColdSegmentRecord) error {
+	for _, segment := range segments {
+		if err := t.bucket.remove(ctx, segment.Object); err != nil {
+			return err
+		}
+		if t.store != nil {
+			if err := t.store.DeleteColdSegment(segment.Key()); err != nil {
+				return err
+			}
+		}
+		t.cache.remove(segment.Object)
+
+		t.mutex.Lock()
+		room := t.segments[segment.RoomID]
+		for i, listed := range room {
+			if listed.Key() == segment.Key() {
+				room = append(room[:i:i], room[i+1:]...)
+				break
+			}
+		}
+		if len(room) == 0 {
+			delete(t.segments, segment.RoomID)
+		} else {
+			t.segments[segment.RoomID] = room
+		}
+		t.mutex.Unlock()
+	}
+	return nil
+}
+
+// read returns a segment's messages, downloading it unless it is cached.
+// The messages are shared and must not be changed.
+func (t *Tier) read(ctx context.Context, segment *store.ColdSegmentRecord) ([]*history.Message, error) {
+	if messages, ok := t.cache.get(segment.Object); ok {
+		return messages, nil
+	}
+
+	data, err := t.bucket.get(ctx, segment.Object)
+	if err != nil {
+		return nil, err
+	}
+	metrics.ColdReads.Add(1)
+	if sealer, ok := t.store.(store.BlobSealer); ok {
+		if data, err = sealer.OpenBlob(data, segment.Object); err != nil {
+			return nil, fmt.Errorf("read segment %s: %w", segment.Object, err)
+		}
+	}
+	decompressor, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("read segment %s: %w", segment.Object, err)
+	}
+	messages := make([]*history.Message, 0, segment.Messages)
+	decoder := json.NewDecoder(decompressor)
+	for decoder.More() {
+		var msg history.Message
+		if err := decoder.Decode(&msg); err != nil {
+			return nil, fmt.Errorf("read segment %s: %w", segment.Object, err)
+		}
+		messages = append(messages, &msg)
+	}
+	t.cache.add(segment.Object, messages)
+	return messages, nil
+}
+
+// clone returns a deep copy of a message read back so callers can't change
+// the cached one
+func clone(msg *history.Message) *history.Message {
+	c := *msg
+	if msg.Reactions != nil {
+		c.Reactions = make(map[string][]string, len(msg.Reactions))
+		for emoji, users := range msg.Reactions {
+			c.Reactions[emoji] = append([]string(nil), users...)
+		}
+	}
+	return &c
+}
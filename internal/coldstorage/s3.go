@@ -0,0 +1,165 @@
+package coldstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"realtime-chat/internal/config"
+	"strings"
+	"time"
+)
+
+// client is shared by every request to the bucket
+var client = &http.Client{Timeout: time.Minute}
+
+// bucket reads and writes objects in an S3-compatible bucket, addressed by
+// path and signed with AWS Signature Version 4
+type bucket struct {
+	endpoint  string
+	name      string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+// newBucket returns the bucket cfg names
+func newBucket(cfg config.ColdStorageConfig) *bucket {
+	return &bucket{
+		endpoint:  cfg.Endpoint,
+		name:      cfg.Bucket,
+		region:    cfg.Region,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+	}
+}
+
+// put stores an object under key
+func (b *bucket) put(ctx context.Context, key, contentType string, body []byte) error {
+	res, err := b.do(ctx, http.MethodPut, key, contentType, body)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// get returns the object stored under key
+func (b *bucket) get(ctx context.Context, key string) ([]byte, error) {
+	res, err := b.do(ctx, http.MethodGet, key, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: read %s: %v", ErrStorage, key, err)
+	}
+	return data, nil
+}
+
+// remove deletes the object stored under key; removing a missing object succeeds
+func (b *bucket) remove(ctx context.Context, key string) error {
+	res, err := b.do(ctx, http.MethodDelete, key, "", nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// do sends a signed request for an object, returning ErrStorage unless it succeeds
+func (b *bucket) do(ctx context.Context, method, key, contentType string, body []byte) (*http.Response, error) {
+	path := "/" + escapePath(b.name+"/"+key)
+	req, err := http.NewRequestWithContext(ctx, method, b.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	b.sign(req, path, body, time.Now())
+
+	res, err := client.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+	if res.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		res.Body.Close()
+		return nil, fmt.Errorf("%w: %s %s: %s %s", ErrStorage, method, key, res.Status, bytes.TrimSpace(detail))
+	}
+	return res, nil
+}
+
+// sign adds the headers of AWS Signature Version 4 to a request, unless the
+// bucket has no credentials
+func (b *bucket) sign(req *http.Request, path string, body []byte, now time.Time) {
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if b.accessKey == "" {
+		return
+	}
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		"", // No query string
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath percent-encodes every byte of an object path except unreserved
+// characters and slashes, as signatures require
+func escapePath(path string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
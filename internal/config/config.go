@@ -0,0 +1,1406 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server's runtime settings
+type Config struct {
+	// Directory where persistent data is stored
+	DataDir string
+
+	// Directory the web client is served from; no static files are served when empty
+	WebDir string
+
+	// URL devices on the local network open the chat at, encoded in the QR
+	// code served at /qr; no QR code is served when empty
+	NetworkURL string
+
+	// Storage backend and its settings
+	Storage StorageConfig
+
+	// Client connection limits and keepalives
+	Connection ConnectionConfig
+
+	// Overload protection thresholds
+	Overload OverloadConfig
+
+	// Parallel delivery of broadcasts to large rooms
+	Fanout FanoutConfig
+
+	// Direct message settings
+	DM DMConfig
+
+	// Outgoing email settings
+	Email EmailConfig
+
+	// Customer-support settings
+	Support SupportConfig
+
+	// Scheduled cleanup of data that is no longer needed
+	Maintenance MaintenanceConfig
+
+	// How much message history rooms keep unless they set their own policy
+	Retention RetentionConfig
+
+	// Email digests of messages missed while offline
+	Digest DigestConfig
+
+	// OAuth2 login and sessions
+	Auth AuthConfig
+
+	// Detection of repeated messages, shouting, link spam and room hopping
+	Spam SpamConfig
+
+	// Words masked or refused in rooms whose profanity level asks for it
+	ProfanityWords []string
+
+	// Sharing broadcasts and presence with the other nodes of a cluster
+	Cluster ClusterConfig
+
+	// Streaming chat events to Kafka for analytics and archiving
+	Kafka KafkaConfig
+
+	// Indexing messages in Elasticsearch or OpenSearch for search
+	Search SearchConfig
+
+	// Moving old messages out of history into S3-compatible object storage
+	ColdStorage ColdStorageConfig
+
+	// Out-of-process plugins hooked into connections, messages and room joins
+	Plugins PluginConfig
+
+	// Exporting OpenTelemetry traces of the message path
+	Tracing TracingConfig
+
+	// GIF search through Giphy or Tenor, proxied so clients never see the API key
+	GIF GIFConfig
+
+	// Translating messages through LibreTranslate or DeepL on request
+	Translate TranslateConfig
+
+	// The /roll and /trivia games
+	Games GameConfig
+
+	// Role of users without an assigned role: guest, member, moderator or admin
+	DefaultRole string
+
+	// Base domain whose subdomains address workspaces, such as chat.example.com;
+	// workspaces are only reached under /w/{id}/ when empty
+	WorkspaceDomain string
+
+	// Address the gRPC API listens on, such as :9090; the gRPC API is off when empty
+	GRPCAddr string
+
+	// Address the embedded MQTT broker listens on, such as :1883; the MQTT bridge is off when empty
+	MQTTAddr string
+
+	// Whether the network URL is advertised on the local network over mDNS as
+	// a _chat._tcp service, and the instance name it's advertised under, the
+	// host name when empty
+	MDNS     bool
+	MDNSName string
+
+	// Sharing rooms with independent servers
+	Federation FederationConfig
+
+	// Bearer token required by the admin API; admin endpoints are disabled when empty
+	AdminToken string
+
+	// File that hub and room events are recorded to for replay; recording is off when empty
+	RecordFile string
+
+	// Key that signs every history event; events are hash-chained but unsigned when nil
+	HistoryKey ed25519.PrivateKey
+}
+
+// Storage backends
+const (
+	// StorageFile keeps each kind of data in its own file, written on every change
+	StorageFile = "file"
+
+	// StorageMemory keeps everything in memory and periodically snapshots it to one file
+	StorageMemory = "memory"
+
+	// StorageBolt keeps everything in one embedded bbolt database file
+	StorageBolt = "bolt"
+)
+
+// Durability levels for history events
+const (
+	// DurabilitySync writes every event before it is broadcast
+	DurabilitySync = "sync"
+
+	// DurabilityBatched broadcasts events at once and writes them in batches
+	// in the background; queued events are lost if the process crashes
+	DurabilityBatched = "batched"
+)
+
+// StorageConfig selects where data is kept
+type StorageConfig struct {
+	// Backend is StorageFile, StorageMemory or StorageBolt
+	Backend string
+
+	// How often the memory backend writes a snapshot
+	SnapshotInterval time.Duration
+
+	// Events of each room's history kept in snapshots; 0 keeps all
+	SnapshotHistory int
+
+	// Durability is DurabilitySync or DurabilityBatched
+	Durability string
+
+	// Batched writes happen once BatchSize events are waiting or after FlushInterval
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// Most events waiting to be written before senders have to wait
+	QueueSize int
+
+	// AES-256 key that message content is encrypted with at rest; content is
+	// stored in plaintext when nil
+	EncryptionKey []byte
+
+	// Earlier encryption keys, kept to read content stored before a rotation
+	PreviousKeys [][]byte
+}
+
+// EncryptionKeySize is the size in bytes of a storage encryption key
+const EncryptionKeySize = 32
+
+// EmailConfig controls outgoing email; email is disabled when SMTPAddr is empty
+type EmailConfig struct {
+	// SMTP server address as host:port
+	SMTPAddr string
+
+	// Sender address
+	From string
+
+	// Credentials for PLAIN authentication; authentication is skipped when Username is empty
+	Username string
+	Password string
+}
+
+// SupportConfig controls support-mode conversations
+type SupportConfig struct {
+	// URL that receives a summary record when a conversation ends; disabled when empty
+	CRMWebhook string
+}
+
+// MaintenanceConfig controls the scheduled cleanup job
+type MaintenanceConfig struct {
+	// How often the cleanup runs
+	Interval time.Duration
+
+	// How long deleted messages are kept as tombstones before they are
+	// purged, which moderators can restore them within
+	TombstoneRetention time.Duration
+
+	// Whether deleted messages are kept as tombstones; otherwise they are
+	// purged as soon as they are deleted
+	SoftDelete bool
+}
+
+// RetentionConfig limits the history kept by rooms without their own
+// policy; 0 keeps everything. Old messages are pruned by the cleanup job.
+type RetentionConfig struct {
+	// Days a message is kept after it is posted
+	Days int
+
+	// Most recent messages kept in each room
+	Messages int
+}
+
+// DigestConfig controls email digests of missed direct messages and mentions
+type DigestConfig struct {
+	// How long a message must have waited for an offline user before it is emailed
+	After time.Duration
+
+	// How often users are checked for missed messages
+	Interval time.Duration
+}
+
+// AuthConfig controls OAuth2 login; a provider is disabled when its client ID is empty
+type AuthConfig struct {
+	// Address users reach the server at, used to build OAuth2 callback URLs
+	PublicURL string
+
+	// How long a login session lasts
+	SessionTTL time.Duration
+
+	// How long an account's deletion waits after the user asks for it, so
+	// they can change their mind
+	DeletionGrace time.Duration
+
+	// Which accounts must log in with a second factor: TwoFactorOff,
+	// TwoFactorModerators or TwoFactorAll. Users can always turn it on for
+	// their own account.
+	TwoFactor string
+
+	// How long a guest identity lasts, how many messages a minute guests
+	// may send (0 for no limit) and whether connecting without an account
+	// needs a guest identity instead of any unclaimed username
+	GuestTTL       time.Duration
+	GuestRateLimit int
+	GuestsOnly     bool
+
+	// Requests a minute an API key may make unless it has its own limit;
+	// 0 for no limit
+	APIKeyRateLimit int
+
+	Google OAuthClient
+	GitHub OAuthClient
+
+	// OpenID Connect single sign-on; off when its issuer is empty
+	OIDC OIDCConfig
+}
+
+// OIDCConfig configures single sign-on with an OpenID Connect identity
+// provider. Users are given an account the first time they sign in.
+type OIDCConfig struct {
+	OAuthClient
+
+	// Issuer URL, whose discovery document lists the provider's endpoints
+	Issuer string
+
+	// Name of the provider shown on the sign-in link
+	Name string
+
+	// Scopes requested besides openid
+	Scopes []string
+
+	// Claim holding the preferred username of new accounts
+	UsernameClaim string
+
+	// Claim listing the user's groups or roles, such as "groups" or
+	// "realm_access.roles", and the role given for each value. When
+	// RoleClaim is set the global role of single sign-on users follows it
+	// each time they sign in.
+	RoleClaim string
+	RoleMap   map[string]string
+}
+
+// Accounts that must use two-factor login
+const (
+	// TwoFactorOff leaves two-factor login up to each user
+	TwoFactorOff = "off"
+
+	// TwoFactorModerators requires it of accounts with a moderator or admin
+	// role, globally or in any room
+	TwoFactorModerators = "moderators"
+
+	// TwoFactorAll requires it of every account
+	TwoFactorAll = "all"
+)
+
+// OAuthClient holds the credentials of an app registered with an OAuth2 provider
+type OAuthClient struct {
+	ClientID     string
+	ClientSecret string
+}
+
+// Responses to detected spam
+const (
+	// SpamFlag reports the user to the room's moderators and the audit log
+	SpamFlag = "flag"
+
+	// SpamMute also mutes the user in the room and drops the message
+	SpamMute = "mute"
+)
+
+// SpamConfig controls the spam detector; a threshold of 0 turns its check off
+type SpamConfig struct {
+	// SpamFlag or SpamMute
+	Action string
+
+	// How long SpamMute mutes a user for
+	MuteDuration time.Duration
+
+	// Identical messages from a user within RepeatWindow that count as spam
+	RepeatLimit  int
+	RepeatWindow time.Duration
+
+	// Percentage of capital letters that counts as shouting, in messages with
+	// at least CapsMinLetters letters
+	CapsPercent    int
+	CapsMinLetters int
+
+	// Links in one message that count as spam
+	MaxLinks int
+
+	// Rooms joined within HopWindow that count as room hopping
+	HopLimit  int
+	HopWindow time.Duration
+}
+
+// ClusterConfig controls cluster mode, which is off when NATSURL is empty
+type ClusterConfig struct {
+	// Address of the NATS server the nodes share, such as nats://localhost:4222
+	NATSURL string
+
+	// Name of this node, unique in the cluster; a random name is used when empty
+	NodeID string
+
+	// Prefix of every NATS subject used, so several clusters can share a server
+	Subject string
+
+	// How often this node announces itself; a node is dropped after missing three announcements
+	Heartbeat time.Duration
+}
+
+// KafkaConfig controls the export of chat events to Kafka, which is off
+// when Brokers is empty
+type KafkaConfig struct {
+	// Addresses of the Kafka brokers as host:port
+	Brokers []string
+
+	// Topics for message changes, room joins and leaves, and moderation and admin actions
+	MessageTopic    string
+	MembershipTopic string
+	ModerationTopic string
+
+	// Most events waiting to be exported; further events are dropped
+	QueueSize int
+}
+
+// SearchConfig controls indexing messages in Elasticsearch or OpenSearch,
+// which is off when URL is empty and messages are searched in memory
+type SearchConfig struct {
+	// Base URL of the cluster, such as http://localhost:9200
+	URL string
+
+	// Index messages are written to; created with its mappings when missing
+	Index string
+
+	// Basic authentication, or an Elasticsearch API key used instead
+	Username string
+	Password string
+	APIKey   string
+
+	// Most changes waiting to be indexed; further changes are dropped
+	QueueSize int
+}
+
+// ColdStorageConfig controls moving messages older than a threshold out of
+// rooms' history into compressed segments in an S3-compatible bucket, which
+// is off when Bucket is empty
+type ColdStorageConfig struct {
+	// Base URL of the service, such as http://localhost:9000 for MinIO; AWS
+	// S3 in Region when empty. Buckets are addressed by path.
+	Endpoint string
+	Bucket   string
+	Region   string
+
+	// Credentials requests are signed with; requests are unsigned when empty
+	AccessKey string
+	SecretKey string
+
+	// Prefix of the segments' keys in the bucket
+	Prefix string
+
+	// Age past which messages are moved to cold storage
+	After time.Duration
+
+	// Most messages in a segment
+	SegmentSize int
+
+	// Segments kept in memory after being read back from the bucket
+	CacheSize int
+}
+
+// DMConfig controls store-and-forward of direct messages
+type DMConfig struct {
+	// Maximum number of messages queued for a single offline user
+	OfflineQueueLimit int
+
+	// How long undelivered messages are kept before they expire
+	OfflineTTL time.Duration
+}
+
+// PluginConfig controls the out-of-process plugins the server starts
+type PluginConfig struct {
+	// Directory whose executables are started as plugins; plugins are off when empty
+	Dir string
+
+	// How long a plugin may take to answer a hook before the event is let through
+	Timeout time.Duration
+}
+
+// TracingConfig controls exporting OpenTelemetry traces over OTLP
+type TracingConfig struct {
+	// OTLP/gRPC collector address, such as localhost:4317; tracing is off when empty
+	Endpoint string
+
+	// Send spans without TLS, as to a collector on the same host
+	Insecure bool
+
+	// Fraction of new traces recorded, from 0 to 1; traces continued from a
+	// client follow the client's sampling decision
+	SampleRatio float64
+
+	// Name the server reports in its spans
+	ServiceName string
+}
+
+// GIF providers
+const (
+	GIFGiphy = "giphy"
+	GIFTenor = "tenor"
+)
+
+// GIF content ratings, from the most to the least restrictive
+var GIFRatings = []string{"g", "pg", "pg-13", "r"}
+
+// GIFConfig controls GIF search, which is off when APIKey is empty
+type GIFConfig struct {
+	// GIFGiphy or GIFTenor
+	Provider string
+
+	// The provider's API key, only ever sent to the provider
+	APIKey string
+
+	// Most restrictive rating of GIFs returned: "g", "pg", "pg-13" or "r"
+	Rating string
+
+	// How long search results are reused before the provider is asked again
+	CacheTTL time.Duration
+}
+
+// Translation providers
+const (
+	TranslateLibre = "libretranslate"
+	TranslateDeepL = "deepl"
+)
+
+// TranslateConfig controls message translation, which is off when neither
+// URL nor APIKey is set
+type TranslateConfig struct {
+	// TranslateLibre or TranslateDeepL
+	Provider string
+
+	// Base URL of the provider's API. Defaults to libretranslate.com for
+	// LibreTranslate, and to DeepL's free or pro API as the key suits.
+	URL string
+
+	// The provider's API key; optional for a self-hosted LibreTranslate
+	APIKey string
+
+	// How long a translation is reused before the provider is asked again
+	CacheTTL time.Duration
+}
+
+// FederationConfig names this server to the servers it shares rooms with
+// and lists them
+type FederationConfig struct {
+	// Name other servers know this one by, such as chat.example.com;
+	// federation is off when empty
+	Name string
+
+	// Key signing this server's requests to the others
+	Key ed25519.PrivateKey
+
+	// Servers whose users may join rooms shared with them
+	Peers []FederationPeer
+}
+
+// FederationPeer is a server rooms can be shared with
+type FederationPeer struct {
+	Name      string
+	URL       string // Base URL, such as https://chat.example.org
+	PublicKey ed25519.PublicKey
+}
+
+// serverNamePattern matches the name of a federated server, which looks like
+// a lower-case host name
+var serverNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]{0,61}[a-z0-9])?$`)
+
+// indexPattern matches the name of an Elasticsearch index
+var indexPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,254}$`)
+
+// bucketPattern matches the name of an S3 bucket
+var bucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// ValidServerName reports whether name can name a federated server
+func ValidServerName(name string) bool {
+	return serverNamePattern.MatchString(name)
+}
+
+// GameConfig controls the chat games
+type GameConfig struct {
+	// Whether /roll and /trivia are available
+	Enabled bool
+
+	// JSON file of trivia questions used instead of the built-in ones
+	TriviaQuestions string
+
+	// How long players have to answer a trivia question
+	TriviaAnswerTime time.Duration
+
+	// Questions in a trivia game unless the player starting it asks for another number
+	TriviaRounds int
+}
+
+// Bounds of MaxFrameSize: the smallest limit still fits every protocol frame,
+// and the largest keeps one client from buffering unbounded memory
+const (
+	MinFrameSize = 512
+	MaxFrameSize = 16 << 20
+)
+
+// MaxQueueSize bounds the send and priority queue sizes, since every client
+// allocates its queues up front
+const MaxQueueSize = 65536
+
+// ConnectionConfig controls the frames clients may send and how their
+// connections are kept alive
+type ConnectionConfig struct {
+	// Largest frame in bytes a client may send; larger frames end the connection
+	MaxFrameSize int
+
+	// How long a connection may stay silent, pongs included, before it is closed
+	ReadTimeout time.Duration
+
+	// How often connections are pinged; shorter than ReadTimeout so pongs arrive in time
+	PingInterval time.Duration
+
+	// How long a write to a client may take
+	WriteTimeout time.Duration
+
+	// Frames each client's send and priority queues hold; frames for a
+	// client whose queue is full are dropped
+	SendQueueSize     int
+	PriorityQueueSize int
+}
+
+// FanoutConfig controls the workers that deliver broadcasts to the clients
+// of large rooms in parallel
+type FanoutConfig struct {
+	// Workers delivering broadcasts alongside each room's goroutine, by
+	// default one per CPU besides the room's own; 0 has every room deliver
+	// its broadcasts alone
+	Workers int
+
+	// Clients a room needs before its broadcasts are split among the workers
+	Threshold int
+}
+
+// OverloadConfig controls when the server starts shedding load
+type OverloadConfig struct {
+	// Aggregate number of queued outgoing messages across all clients
+	MaxQueueDepth int
+
+	// Number of running goroutines
+	MaxGoroutines int
+
+	// Connections across all transports; further connections are refused
+	MaxConnections int
+
+	// How often the thresholds are checked
+	CheckInterval time.Duration
+
+	// Value of the Retry-After header sent to rejected connections
+	RetryAfter time.Duration
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	return &Config{
+		DataDir:     "data",
+		WebDir:      "web",
+		DefaultRole: "member",
+		MDNS:        true,
+		Storage: StorageConfig{
+			Backend:          StorageFile,
+			SnapshotInterval: 5 * time.Minute,
+			SnapshotHistory:  1000,
+			Durability:       DurabilitySync,
+			BatchSize:        100,
+			FlushInterval:    100 * time.Millisecond,
+			QueueSize:        10000,
+		},
+		Cluster: ClusterConfig{
+			Subject:   "chat",
+			Heartbeat: 5 * time.Second,
+		},
+		Kafka: KafkaConfig{
+			MessageTopic:    "chat.messages",
+			MembershipTopic: "chat.membership",
+			ModerationTopic: "chat.moderation",
+			QueueSize:       10000,
+		},
+		Search: SearchConfig{
+			Index:     "chat-messages",
+			QueueSize: 10000,
+		},
+		ColdStorage: ColdStorageConfig{
+			Region:      "us-east-1",
+			Prefix:      "cold/",
+			After:       30 * 24 * time.Hour,
+			SegmentSize: 10000,
+			CacheSize:   16,
+		},
+		Plugins: PluginConfig{
+			Timeout: 2 * time.Second,
+		},
+		Tracing: TracingConfig{
+			SampleRatio: 1,
+			ServiceName: "realtime-chat",
+		},
+		GIF: GIFConfig{
+			Provider: GIFGiphy,
+			Rating:   "pg",
+			CacheTTL: 10 * time.Minute,
+		},
+		Translate: TranslateConfig{
+			Provider: TranslateLibre,
+			CacheTTL: 24 * time.Hour,
+		},
+		Games: GameConfig{
+			Enabled:          true,
+			TriviaAnswerTime: 30 * time.Second,
+			TriviaRounds:     10,
+		},
+		Connection: ConnectionConfig{
+			MaxFrameSize: MinFrameSize,
+			ReadTimeout:  60 * time.Second,
+			PingInterval: 54 * time.Second,
+			WriteTimeout: 10 * time.Second,
+
+			SendQueueSize:     256,
+			PriorityQueueSize: 64,
+		},
+		Overload: OverloadConfig{
+			MaxQueueDepth:  100000,
+			MaxGoroutines:  50000,
+			MaxConnections: 20000,
+			CheckInterval:  time.Second,
+			RetryAfter:     30 * time.Second,
+		},
+		Fanout: FanoutConfig{
+			Workers:   runtime.NumCPU() - 1,
+			Threshold: 1000,
+		},
+		DM: DMConfig{
+			OfflineQueueLimit: 100,
+			OfflineTTL:        7 * 24 * time.Hour,
+		},
+		Maintenance: MaintenanceConfig{
+			Interval:           24 * time.Hour,
+			TombstoneRetention: 30 * 24 * time.Hour,
+			SoftDelete:         true,
+		},
+		Digest: DigestConfig{
+			After:    time.Hour,
+			Interval: 5 * time.Minute,
+		},
+		Auth: AuthConfig{
+			PublicURL:       "http://localhost:8080",
+			SessionTTL:      30 * 24 * time.Hour,
+			DeletionGrace:   7 * 24 * time.Hour,
+			TwoFactor:       TwoFactorOff,
+			GuestTTL:        24 * time.Hour,
+			GuestRateLimit:  10,
+			APIKeyRateLimit: 120,
+			OIDC: OIDCConfig{
+				Name:          "SSO",
+				Scopes:        []string{"email", "profile"},
+				UsernameClaim: "preferred_username",
+			},
+		},
+		Spam: SpamConfig{
+			Action:         SpamFlag,
+			MuteDuration:   5 * time.Minute,
+			RepeatLimit:    3,
+			RepeatWindow:   time.Minute,
+			CapsPercent:    80,
+			CapsMinLetters: 12,
+			MaxLinks:       3,
+			HopLimit:       8,
+			HopWindow:      time.Minute,
+		},
+		ProfanityWords: []string{"fuck", "fucking", "shit", "bitch", "asshole", "bastard", "cunt", "dick", "motherfucker"},
+	}
+}
+
+// Load returns the default configuration overridden by CHAT_* environment variables
+func Load() (*Config, error) {
+	cfg := Default()
+
+	if dir := os.Getenv("CHAT_DATA_DIR"); dir != "" {
+		cfg.DataDir = dir
+	}
+	if dir, ok := os.LookupEnv("CHAT_WEB_DIR"); ok {
+		cfg.WebDir = dir
+	}
+	if networkURL := os.Getenv("CHAT_NETWORK_URL"); networkURL != "" {
+		u, err := url.Parse(networkURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("CHAT_NETWORK_URL must be an http or https URL")
+		}
+		cfg.NetworkURL = strings.TrimSuffix(networkURL, "/")
+	}
+	if backend := os.Getenv("CHAT_STORAGE"); backend != "" {
+		cfg.Storage.Backend = backend
+	}
+	cfg.AdminToken = os.Getenv("CHAT_ADMIN_TOKEN")
+	if role := os.Getenv("CHAT_DEFAULT_ROLE"); role != "" {
+		cfg.DefaultRole = role
+	}
+	cfg.RecordFile = os.Getenv("CHAT_RECORD_FILE")
+	if seed := os.Getenv("CHAT_HISTORY_SIGNING_KEY"); seed != "" {
+		decoded, err := decodeKey("CHAT_HISTORY_SIGNING_KEY", seed, ed25519.SeedSize)
+		if err != nil {
+			return nil, err
+		}
+		cfg.HistoryKey = ed25519.NewKeyFromSeed(decoded)
+	}
+	if err := loadStorageKeys(&cfg.Storage); err != nil {
+		return nil, err
+	}
+	cfg.WorkspaceDomain = strings.ToLower(strings.TrimSuffix(os.Getenv("CHAT_WORKSPACE_DOMAIN"), "."))
+	cfg.GRPCAddr = os.Getenv("CHAT_GRPC_ADDR")
+	cfg.MQTTAddr = os.Getenv("CHAT_MQTT_ADDR")
+	cfg.MDNSName = os.Getenv("CHAT_MDNS_NAME")
+	cfg.Plugins.Dir = os.Getenv("CHAT_PLUGIN_DIR")
+	cfg.Tracing.Endpoint = os.Getenv("CHAT_OTLP_ENDPOINT")
+	if name := os.Getenv("CHAT_SERVICE_NAME"); name != "" {
+		cfg.Tracing.ServiceName = name
+	}
+	if provider := os.Getenv("CHAT_GIF_PROVIDER"); provider != "" {
+		cfg.GIF.Provider = provider
+	}
+	cfg.GIF.APIKey = os.Getenv("CHAT_GIF_API_KEY")
+	if rating := os.Getenv("CHAT_GIF_RATING"); rating != "" {
+		cfg.GIF.Rating = strings.ToLower(rating)
+	}
+	if provider := os.Getenv("CHAT_TRANSLATE_PROVIDER"); provider != "" {
+		cfg.Translate.Provider = strings.ToLower(provider)
+	}
+	cfg.Translate.URL = strings.TrimSuffix(os.Getenv("CHAT_TRANSLATE_URL"), "/")
+	cfg.Translate.APIKey = os.Getenv("CHAT_TRANSLATE_API_KEY")
+	cfg.Games.TriviaQuestions = os.Getenv("CHAT_TRIVIA_QUESTIONS")
+	cfg.Email.SMTPAddr = os.Getenv("CHAT_SMTP_ADDR")
+	cfg.Email.From = os.Getenv("CHAT_SMTP_FROM")
+	cfg.Email.Username = os.Getenv("CHAT_SMTP_USERNAME")
+	cfg.Email.Password = os.Getenv("CHAT_SMTP_PASSWORD")
+	cfg.Support.CRMWebhook = os.Getenv("CHAT_SUPPORT_CRM_WEBHOOK")
+	cfg.Cluster.NATSURL = os.Getenv("CHAT_NATS_URL")
+	cfg.Cluster.NodeID = os.Getenv("CHAT_NODE_ID")
+	if subject := os.Getenv("CHAT_NATS_SUBJECT"); subject != "" {
+		cfg.Cluster.Subject = subject
+	}
+	if words := os.Getenv("CHAT_PROFANITY_WORDS"); words != "" {
+		cfg.ProfanityWords = strings.Split(words, ",")
+	}
+	if brokers := os.Getenv("CHAT_KAFKA_BROKERS"); brokers != "" {
+		cfg.Kafka.Brokers = strings.Split(brokers, ",")
+	}
+	if topic := os.Getenv("CHAT_KAFKA_MESSAGE_TOPIC"); topic != "" {
+		cfg.Kafka.MessageTopic = topic
+	}
+	if topic := os.Getenv("CHAT_KAFKA_MEMBERSHIP_TOPIC"); topic != "" {
+		cfg.Kafka.MembershipTopic = topic
+	}
+	if topic := os.Getenv("CHAT_KAFKA_MODERATION_TOPIC"); topic != "" {
+		cfg.Kafka.ModerationTopic = topic
+	}
+	cfg.Search.URL = strings.TrimRight(os.Getenv("CHAT_ELASTICSEARCH_URL"), "/")
+	if index := os.Getenv("CHAT_ELASTICSEARCH_INDEX"); index != "" {
+		cfg.Search.Index = index
+	}
+	cfg.Search.Username = os.Getenv("CHAT_ELASTICSEARCH_USERNAME")
+	cfg.Search.Password = os.Getenv("CHAT_ELASTICSEARCH_PASSWORD")
+	cfg.Search.APIKey = os.Getenv("CHAT_ELASTICSEARCH_API_KEY")
+	cfg.ColdStorage.Endpoint = strings.TrimRight(os.Getenv("CHAT_COLD_ENDPOINT"), "/")
+	cfg.ColdStorage.Bucket = os.Getenv("CHAT_COLD_BUCKET")
+	if region := os.Getenv("CHAT_COLD_REGION"); region != "" {
+		cfg.ColdStorage.Region = region
+	}
+	cfg.ColdStorage.AccessKey = os.Getenv("CHAT_COLD_ACCESS_KEY")
+	cfg.ColdStorage.SecretKey = os.Getenv("CHAT_COLD_SECRET_KEY")
+	if prefix, ok := os.LookupEnv("CHAT_COLD_PREFIX"); ok {
+		cfg.ColdStorage.Prefix = prefix
+	}
+	if cfg.ColdStorage.Endpoint == "" {
+		cfg.ColdStorage.Endpoint = "https://s3." + cfg.ColdStorage.Region + ".amazonaws.com"
+	}
+	if publicURL := os.Getenv("CHAT_PUBLIC_URL"); publicURL != "" {
+		cfg.Auth.PublicURL = publicURL
+	}
+	cfg.Auth.Google.ClientID = os.Getenv("CHAT_GOOGLE_CLIENT_ID")
+	cfg.Auth.Google.ClientSecret = os.Getenv("CHAT_GOOGLE_CLIENT_SECRET")
+	cfg.Auth.GitHub.ClientID = os.Getenv("CHAT_GITHUB_CLIENT_ID")
+	cfg.Auth.GitHub.ClientSecret = os.Getenv("CHAT_GITHUB_CLIENT_SECRET")
+	if err := loadOIDC(&cfg.Auth.OIDC); err != nil {
+		return nil, err
+	}
+	if err := loadFederation(&cfg.Federation); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if cfg.Connection.MaxFrameSize, err = envInt("CHAT_MAX_FRAME_SIZE", cfg.Connection.MaxFrameSize); err != nil {
+		return nil, err
+	}
+	if cfg.Connection.ReadTimeout, err = envDuration("CHAT_READ_TIMEOUT", cfg.Connection.ReadTimeout); err != nil {
+		return nil, err
+	}
+	if cfg.Connection.PingInterval, err = envDuration("CHAT_PING_INTERVAL", cfg.Connection.PingInterval); err != nil {
+		return nil, err
+	}
+	if cfg.Connection.WriteTimeout, err = envDuration("CHAT_WRITE_TIMEOUT", cfg.Connection.WriteTimeout); err != nil {
+		return nil, err
+	}
+	if cfg.Connection.SendQueueSize, err = envInt("CHAT_SEND_QUEUE_SIZE", cfg.Connection.SendQueueSize); err != nil {
+		return nil, err
+	}
+	if cfg.Connection.PriorityQueueSize, err = envInt("CHAT_PRIORITY_QUEUE_SIZE", cfg.Connection.PriorityQueueSize); err != nil {
+		return nil, err
+	}
+	if cfg.Overload.MaxQueueDepth, err = envInt("CHAT_OVERLOAD_MAX_QUEUE_DEPTH", cfg.Overload.MaxQueueDepth); err != nil {
+		return nil, err
+	}
+	if cfg.Overload.MaxGoroutines, err = envInt("CHAT_OVERLOAD_MAX_GOROUTINES", cfg.Overload.MaxGoroutines); err != nil {
+		return nil, err
+	}
+	if cfg.Overload.MaxConnections, err = envInt("CHAT_MAX_CONNECTIONS", cfg.Overload.MaxConnections); err != nil {
+		return nil, err
+	}
+	if cfg.Overload.CheckInterval, err = envDuration("CHAT_OVERLOAD_CHECK_INTERVAL", cfg.Overload.CheckInterval); err != nil {
+		return nil, err
+	}
+	if cfg.Overload.RetryAfter, err = envDuration("CHAT_OVERLOAD_RETRY_AFTER", cfg.Overload.RetryAfter); err != nil {
+		return nil, err
+	}
+	if cfg.Fanout.Workers, err = envInt("CHAT_FANOUT_WORKERS", cfg.Fanout.Workers); err != nil {
+		return nil, err
+	}
+	if cfg.Fanout.Threshold, err = envInt("CHAT_FANOUT_THRESHOLD", cfg.Fanout.Threshold); err != nil {
+		return nil, err
+	}
+	if cfg.DM.OfflineQueueLimit, err = envInt("CHAT_DM_OFFLINE_QUEUE_LIMIT", cfg.DM.OfflineQueueLimit); err != nil {
+		return nil, err
+	}
+	if cfg.DM.OfflineTTL, err = envDuration("CHAT_DM_OFFLINE_TTL", cfg.DM.OfflineTTL); err != nil {
+		return nil, err
+	}
+
+	if cfg.Storage.SnapshotInterval, err = envDuration("CHAT_SNAPSHOT_INTERVAL", cfg.Storage.SnapshotInterval); err != nil {
+		return nil, err
+	}
+	if cfg.Storage.SnapshotHistory, err = envInt("CHAT_SNAPSHOT_HISTORY", cfg.Storage.SnapshotHistory); err != nil {
+		return nil, err
+	}
+	if durability := os.Getenv("CHAT_DURABILITY"); durability != "" {
+		cfg.Storage.Durability = durability
+	}
+	if cfg.Storage.BatchSize, err = envInt("CHAT_PERSIST_BATCH_SIZE", cfg.Storage.BatchSize); err != nil {
+		return nil, err
+	}
+	if cfg.Storage.FlushInterval, err = envDuration("CHAT_PERSIST_FLUSH_INTERVAL", cfg.Storage.FlushInterval); err != nil {
+		return nil, err
+	}
+	if cfg.Storage.QueueSize, err = envInt("CHAT_PERSIST_QUEUE_SIZE", cfg.Storage.QueueSize); err != nil {
+		return nil, err
+	}
+	if cfg.Maintenance.Interval, err = envDuration("CHAT_MAINTENANCE_INTERVAL", cfg.Maintenance.Interval); err != nil {
+		return nil, err
+	}
+	if cfg.Maintenance.TombstoneRetention, err = envDuration("CHAT_TOMBSTONE_RETENTION", cfg.Maintenance.TombstoneRetention); err != nil {
+		return nil, err
+	}
+	if cfg.Maintenance.SoftDelete, err = envBool("CHAT_SOFT_DELETE", cfg.Maintenance.SoftDelete); err != nil {
+		return nil, err
+	}
+
+	if cfg.Retention.Days, err = envInt("CHAT_RETENTION_DAYS", cfg.Retention.Days); err != nil {
+		return nil, err
+	}
+	if cfg.Retention.Messages, err = envInt("CHAT_RETENTION_MESSAGES", cfg.Retention.Messages); err != nil {
+		return nil, err
+	}
+
+	if cfg.Digest.After, err = envDuration("CHAT_DIGEST_AFTER", cfg.Digest.After); err != nil {
+		return nil, err
+	}
+	if cfg.Digest.Interval, err = envDuration("CHAT_DIGEST_INTERVAL", cfg.Digest.Interval); err != nil {
+		return nil, err
+	}
+
+	if cfg.Auth.SessionTTL, err = envDuration("CHAT_SESSION_TTL", cfg.Auth.SessionTTL); err != nil {
+		return nil, err
+	}
+	if cfg.Auth.DeletionGrace, err = envDuration("CHAT_DELETION_GRACE", cfg.Auth.DeletionGrace); err != nil {
+		return nil, err
+	}
+	if twoFactor := os.Getenv("CHAT_REQUIRE_2FA"); twoFactor != "" {
+		cfg.Auth.TwoFactor = twoFactor
+	}
+	if cfg.Auth.GuestTTL, err = envDuration("CHAT_GUEST_TTL", cfg.Auth.GuestTTL); err != nil {
+		return nil, err
+	}
+	if cfg.Auth.GuestRateLimit, err = envInt("CHAT_GUEST_RATE_LIMIT", cfg.Auth.GuestRateLimit); err != nil {
+		return nil, err
+	}
+	if cfg.Auth.APIKeyRateLimit, err = envInt("CHAT_API_KEY_RATE_LIMIT", cfg.Auth.APIKeyRateLimit); err != nil {
+		return nil, err
+	}
+	if cfg.Auth.GuestsOnly, err = envBool("CHAT_GUESTS_ONLY", cfg.Auth.GuestsOnly); err != nil {
+		return nil, err
+	}
+
+	if action := os.Getenv("CHAT_SPAM_ACTION"); action != "" {
+		cfg.Spam.Action = action
+	}
+	if cfg.Spam.MuteDuration, err = envDuration("CHAT_SPAM_MUTE_DURATION", cfg.Spam.MuteDuration); err != nil {
+		return nil, err
+	}
+	if cfg.Spam.RepeatLimit, err = envInt("CHAT_SPAM_REPEAT_LIMIT", cfg.Spam.RepeatLimit); err != nil {
+		return nil, err
+	}
+	if cfg.Spam.RepeatWindow, err = envDuration("CHAT_SPAM_REPEAT_WINDOW", cfg.Spam.RepeatWindow); err != nil {
+		return nil, err
+	}
+	if cfg.Spam.CapsPercent, err = envInt("CHAT_SPAM_CAPS_PERCENT", cfg.Spam.CapsPercent); err != nil {
+		return nil, err
+	}
+	if cfg.Spam.CapsMinLetters, err = envInt("CHAT_SPAM_CAPS_MIN_LETTERS", cfg.Spam.CapsMinLetters); err != nil {
+		return nil, err
+	}
+	if cfg.Spam.MaxLinks, err = envInt("CHAT_SPAM_MAX_LINKS", cfg.Spam.MaxLinks); err != nil {
+		return nil, err
+	}
+	if cfg.Spam.HopLimit, err = envInt("CHAT_SPAM_HOP_LIMIT", cfg.Spam.HopLimit); err != nil {
+		return nil, err
+	}
+	if cfg.Spam.HopWindow, err = envDuration("CHAT_SPAM_HOP_WINDOW", cfg.Spam.HopWindow); err != nil {
+		return nil, err
+	}
+	if cfg.Cluster.Heartbeat, err = envDuration("CHAT_CLUSTER_HEARTBEAT", cfg.Cluster.Heartbeat); err != nil {
+		return nil, err
+	}
+	if cfg.Search.QueueSize, err = envInt("CHAT_ELASTICSEARCH_QUEUE_SIZE", cfg.Search.QueueSize); err != nil {
+		return nil, err
+	}
+	if cfg.ColdStorage.After, err = envDuration("CHAT_COLD_AFTER", cfg.ColdStorage.After); err != nil {
+		return nil, err
+	}
+	if cfg.ColdStorage.SegmentSize, err = envInt("CHAT_COLD_SEGMENT_SIZE", cfg.ColdStorage.SegmentSize); err != nil {
+		return nil, err
+	}
+	if cfg.ColdStorage.CacheSize, err = envInt("CHAT_COLD_CACHE_SIZE", cfg.ColdStorage.CacheSize); err != nil {
+		return nil, err
+	}
+	if cfg.Kafka.QueueSize, err = envInt("CHAT_KAFKA_QUEUE_SIZE", cfg.Kafka.QueueSize); err != nil {
+		return nil, err
+	}
+	if cfg.Plugins.Timeout, err = envDuration("CHAT_PLUGIN_TIMEOUT", cfg.Plugins.Timeout); err != nil {
+		return nil, err
+	}
+	if cfg.GIF.CacheTTL, err = envDuration("CHAT_GIF_CACHE_TTL", cfg.GIF.CacheTTL); err != nil {
+		return nil, err
+	}
+	if cfg.Translate.CacheTTL, err = envDuration("CHAT_TRANSLATE_CACHE_TTL", cfg.Translate.CacheTTL); err != nil {
+		return nil, err
+	}
+	if cfg.MDNS, err = envBool("CHAT_MDNS", cfg.MDNS); err != nil {
+		return nil, err
+	}
+	if cfg.Games.Enabled, err = envBool("CHAT_GAMES", cfg.Games.Enabled); err != nil {
+		return nil, err
+	}
+	if cfg.Games.TriviaAnswerTime, err = envDuration("CHAT_TRIVIA_ANSWER_TIME", cfg.Games.TriviaAnswerTime); err != nil {
+		return nil, err
+	}
+	if cfg.Games.TriviaRounds, err = envInt("CHAT_TRIVIA_ROUNDS", cfg.Games.TriviaRounds); err != nil {
+		return nil, err
+	}
+
+	if cfg.Tracing.Insecure, err = envBool("CHAT_OTLP_INSECURE", cfg.Tracing.Insecure); err != nil {
+		return nil, err
+	}
+	if cfg.Tracing.SampleRatio, err = envFloat("CHAT_TRACE_SAMPLE_RATIO", cfg.Tracing.SampleRatio); err != nil {
+		return nil, err
+	}
+
+	if cfg.Connection.MaxFrameSize < MinFrameSize || cfg.Connection.MaxFrameSize > MaxFrameSize {
+		return nil, fmt.Errorf("CHAT_MAX_FRAME_SIZE must be between %d and %d bytes", MinFrameSize, MaxFrameSize)
+	}
+	if cfg.Connection.PingInterval <= 0 || cfg.Connection.WriteTimeout <= 0 {
+		return nil, fmt.Errorf("CHAT_PING_INTERVAL and CHAT_WRITE_TIMEOUT must be positive")
+	}
+	if cfg.Connection.PingInterval >= cfg.Connection.ReadTimeout {
+		return nil, fmt.Errorf("CHAT_PING_INTERVAL must be shorter than CHAT_READ_TIMEOUT")
+	}
+	if cfg.Connection.SendQueueSize < 1 || cfg.Connection.SendQueueSize > MaxQueueSize ||
+		cfg.Connection.PriorityQueueSize < 1 || cfg.Connection.PriorityQueueSize > MaxQueueSize {
+		return nil, fmt.Errorf("CHAT_SEND_QUEUE_SIZE and CHAT_PRIORITY_QUEUE_SIZE must be between 1 and %d frames", MaxQueueSize)
+	}
+	if cfg.Overload.CheckInterval <= 0 {
+		return nil, fmt.Errorf("CHAT_OVERLOAD_CHECK_INTERVAL must be positive")
+	}
+	if cfg.Overload.MaxConnections <= 0 {
+		return nil, fmt.Errorf("CHAT_MAX_CONNECTIONS must be positive")
+	}
+	if cfg.Fanout.Workers < 0 {
+		return nil, fmt.Errorf("CHAT_FANOUT_WORKERS must not be negative")
+	}
+	if cfg.Fanout.Threshold <= 0 {
+		return nil, fmt.Errorf("CHAT_FANOUT_THRESHOLD must be positive")
+	}
+	switch cfg.Storage.Backend {
+	case StorageFile, StorageMemory, StorageBolt:
+	default:
+		return nil, fmt.Errorf("CHAT_STORAGE must be %q, %q or %q", StorageFile, StorageMemory, StorageBolt)
+	}
+	if cfg.Storage.SnapshotInterval <= 0 {
+		return nil, fmt.Errorf("CHAT_SNAPSHOT_INTERVAL must be positive")
+	}
+	if cfg.Storage.Durability != DurabilitySync && cfg.Storage.Durability != DurabilityBatched {
+		return nil, fmt.Errorf("CHAT_DURABILITY must be %q or %q", DurabilitySync, DurabilityBatched)
+	}
+	if cfg.Storage.BatchSize <= 0 || cfg.Storage.FlushInterval <= 0 || cfg.Storage.QueueSize <= 0 {
+		return nil, fmt.Errorf("CHAT_PERSIST_BATCH_SIZE, CHAT_PERSIST_FLUSH_INTERVAL and CHAT_PERSIST_QUEUE_SIZE must be positive")
+	}
+	if cfg.Maintenance.Interval <= 0 {
+		return nil, fmt.Errorf("CHAT_MAINTENANCE_INTERVAL must be positive")
+	}
+	if cfg.Plugins.Timeout <= 0 {
+		return nil, fmt.Errorf("CHAT_PLUGIN_TIMEOUT must be positive")
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		return nil, fmt.Errorf("CHAT_TRACE_SAMPLE_RATIO must be between 0 and 1")
+	}
+	if cfg.Retention.Days < 0 || cfg.Retention.Messages < 0 {
+		return nil, fmt.Errorf("CHAT_RETENTION_DAYS and CHAT_RETENTION_MESSAGES must not be negative")
+	}
+	if cfg.Digest.After <= 0 || cfg.Digest.Interval <= 0 {
+		return nil, fmt.Errorf("CHAT_DIGEST_AFTER and CHAT_DIGEST_INTERVAL must be positive")
+	}
+	switch cfg.DefaultRole {
+	case "guest", "member", "moderator", "admin":
+	default:
+		return nil, fmt.Errorf("CHAT_DEFAULT_ROLE must be guest, member, moderator or admin")
+	}
+	if cfg.Auth.SessionTTL <= 0 {
+		return nil, fmt.Errorf("CHAT_SESSION_TTL must be positive")
+	}
+	if cfg.Auth.DeletionGrace < 0 {
+		return nil, fmt.Errorf("CHAT_DELETION_GRACE must not be negative")
+	}
+	if cfg.Auth.GuestTTL <= 0 {
+		return nil, fmt.Errorf("CHAT_GUEST_TTL must be positive")
+	}
+	if cfg.Auth.GuestRateLimit < 0 {
+		return nil, fmt.Errorf("CHAT_GUEST_RATE_LIMIT must not be negative")
+	}
+	if cfg.Auth.APIKeyRateLimit < 0 {
+		return nil, fmt.Errorf("CHAT_API_KEY_RATE_LIMIT must not be negative")
+	}
+	switch cfg.Auth.TwoFactor {
+	case TwoFactorOff, TwoFactorModerators, TwoFactorAll:
+	default:
+		return nil, fmt.Errorf("CHAT_REQUIRE_2FA must be %q, %q or %q", TwoFactorOff, TwoFactorModerators, TwoFactorAll)
+	}
+	if (cfg.Auth.Google.ClientID != "" && cfg.Auth.Google.ClientSecret == "") ||
+		(cfg.Auth.GitHub.ClientID != "" && cfg.Auth.GitHub.ClientSecret == "") {
+		return nil, fmt.Errorf("an OAuth2 client secret must be set with its client ID")
+	}
+	if oidc := cfg.Auth.OIDC; oidc.Issuer != "" && (oidc.ClientID == "" || oidc.ClientSecret == "") {
+		return nil, fmt.Errorf("CHAT_OIDC_CLIENT_ID and CHAT_OIDC_CLIENT_SECRET must be set with CHAT_OIDC_ISSUER")
+	}
+	if d := cfg.WorkspaceDomain; d != "" && (strings.ContainsAny(d, ":/@ ") || strings.HasPrefix(d, ".")) {
+		return nil, fmt.Errorf("CHAT_WORKSPACE_DOMAIN must be a bare hostname such as chat.example.com")
+	}
+	if cfg.Spam.Action != SpamFlag && cfg.Spam.Action != SpamMute {
+		return nil, fmt.Errorf("CHAT_SPAM_ACTION must be %q or %q", SpamFlag, SpamMute)
+	}
+	if cfg.Spam.MuteDuration <= 0 || cfg.Spam.RepeatWindow <= 0 || cfg.Spam.HopWindow <= 0 {
+		return nil, fmt.Errorf("CHAT_SPAM_MUTE_DURATION, CHAT_SPAM_REPEAT_WINDOW and CHAT_SPAM_HOP_WINDOW must be positive")
+	}
+	if cfg.Spam.RepeatLimit < 0 || cfg.Spam.CapsPercent < 0 || cfg.Spam.CapsPercent > 100 ||
+		cfg.Spam.CapsMinLetters < 0 || cfg.Spam.MaxLinks < 0 || cfg.Spam.HopLimit < 0 {
+		return nil, fmt.Errorf("spam thresholds must not be negative, and CHAT_SPAM_CAPS_PERCENT must be at most 100")
+	}
+	if cfg.Cluster.Heartbeat <= 0 {
+		return nil, fmt.Errorf("CHAT_CLUSTER_HEARTBEAT must be positive")
+	}
+	if strings.ContainsAny(cfg.Cluster.NodeID+cfg.Cluster.Subject, " *>") {
+		return nil, fmt.Errorf("CHAT_NODE_ID and CHAT_NATS_SUBJECT must not contain spaces, * or >")
+	}
+	if cfg.Kafka.QueueSize <= 0 {
+		return nil, fmt.Errorf("CHAT_KAFKA_QUEUE_SIZE must be positive")
+	}
+	if cfg.Search.QueueSize <= 0 {
+		return nil, fmt.Errorf("CHAT_ELASTICSEARCH_QUEUE_SIZE must be positive")
+	}
+	if cfg.Search.URL != "" {
+		if u, err := url.Parse(cfg.Search.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("CHAT_ELASTICSEARCH_URL must be an http or https URL")
+		}
+	}
+	if !indexPattern.MatchString(cfg.Search.Index) {
+		return nil, fmt.Errorf("CHAT_ELASTICSEARCH_INDEX must be a lower-case index name")
+	}
+	if u, err := url.Parse(cfg.ColdStorage.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("CHAT_COLD_ENDPOINT must be an http or https URL")
+	}
+	if cfg.ColdStorage.Bucket != "" && !bucketPattern.MatchString(cfg.ColdStorage.Bucket) {
+		return nil, fmt.Errorf("CHAT_COLD_BUCKET must be a bucket name")
+	}
+	if (cfg.ColdStorage.AccessKey == "") != (cfg.ColdStorage.SecretKey == "") {
+		return nil, fmt.Errorf("CHAT_COLD_ACCESS_KEY and CHAT_COLD_SECRET_KEY must be set together")
+	}
+	if cfg.ColdStorage.After <= 0 {
+		return nil, fmt.Errorf("CHAT_COLD_AFTER must be positive")
+	}
+	if cfg.ColdStorage.SegmentSize <= 0 {
+		return nil, fmt.Errorf("CHAT_COLD_SEGMENT_SIZE must be positive")
+	}
+	if cfg.ColdStorage.CacheSize <= 0 {
+		return nil, fmt.Errorf("CHAT_COLD_CACHE_SIZE must be positive")
+	}
+	if cfg.GIF.Provider != GIFGiphy && cfg.GIF.Provider != GIFTenor {
+		return nil, fmt.Errorf("CHAT_GIF_PROVIDER must be %q or %q", GIFGiphy, GIFTenor)
+	}
+	if !slices.Contains(GIFRatings, cfg.GIF.Rating) {
+		return nil, fmt.Errorf("CHAT_GIF_RATING must be g, pg, pg-13 or r")
+	}
+	if cfg.GIF.CacheTTL <= 0 {
+		return nil, fmt.Errorf("CHAT_GIF_CACHE_TTL must be positive")
+	}
+	if cfg.Translate.Provider != TranslateLibre && cfg.Translate.Provider != TranslateDeepL {
+		return nil, fmt.Errorf("CHAT_TRANSLATE_PROVIDER must be %q or %q", TranslateLibre, TranslateDeepL)
+	}
+	if cfg.Translate.Provider == TranslateDeepL && cfg.Translate.URL != "" && cfg.Translate.APIKey == "" {
+		return nil, fmt.Errorf("CHAT_TRANSLATE_API_KEY must be set to translate with DeepL")
+	}
+	if cfg.Translate.CacheTTL <= 0 {
+		return nil, fmt.Errorf("CHAT_TRANSLATE_CACHE_TTL must be positive")
+	}
+	if cfg.Games.TriviaAnswerTime < 5*time.Second || cfg.Games.TriviaAnswerTime > 10*time.Minute {
+		return nil, fmt.Errorf("CHAT_TRIVIA_ANSWER_TIME must be between 5s and 10m")
+	}
+	if cfg.Games.TriviaRounds < 1 || cfg.Games.TriviaRounds > 50 {
+		return nil, fmt.Errorf("CHAT_TRIVIA_ROUNDS must be between 1 and 50")
+	}
+	if cfg.Email.SMTPAddr != "" && cfg.Email.From == "" {
+		return nil, fmt.Errorf("CHAT_SMTP_FROM must be set when CHAT_SMTP_ADDR is set")
+	}
+
+	return cfg, nil
+}
+
+// loadStorageKeys reads the storage encryption key from CHAT_STORAGE_KEY or
+// from the output of CHAT_STORAGE_KEY_COMMAND, which can fetch it from a KMS
+// or secret manager, and the earlier keys from CHAT_STORAGE_PREVIOUS_KEYS
+func loadStorageKeys(storage *StorageConfig) error {
+	key := os.Getenv("CHAT_STORAGE_KEY")
+	if command := os.Getenv("CHAT_STORAGE_KEY_COMMAND"); command != "" {
+		if key != "" {
+			return fmt.Errorf("set CHAT_STORAGE_KEY or CHAT_STORAGE_KEY_COMMAND, not both")
+		}
+		out, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			return fmt.Errorf("CHAT_STORAGE_KEY_COMMAND: %w", err)
+		}
+		key = strings.TrimSpace(string(out))
+	}
+	previous := os.Getenv("CHAT_STORAGE_PREVIOUS_KEYS")
+	if key == "" {
+		if previous != "" {
+			return fmt.Errorf("CHAT_STORAGE_PREVIOUS_KEYS needs a current key")
+		}
+		return nil
+	}
+
+	var err error
+	if storage.EncryptionKey, err = decodeKey("the storage encryption key", key, EncryptionKeySize); err != nil {
+		return err
+	}
+	for _, encoded := range strings.Split(previous, ",") {
+		if encoded = strings.TrimSpace(encoded); encoded == "" {
+			continue
+		}
+		decoded, err := decodeKey("CHAT_STORAGE_PREVIOUS_KEYS", encoded, EncryptionKeySize)
+		if err != nil {
+			return err
+		}
+		storage.PreviousKeys = append(storage.PreviousKeys, decoded)
+	}
+	return nil
+}
+
+// loadFederation reads the federation settings. CHAT_FEDERATION_PEERS lists
+// peers separated by semicolons, each as its name, base URL and base64
+// public key separated by spaces.
+func loadFederation(federation *FederationConfig) error {
+	federation.Name = strings.ToLower(os.Getenv("CHAT_FEDERATION_NAME"))
+	if federation.Name == "" {
+		return nil
+	}
+	if !ValidServerName(federation.Name) {
+		return fmt.Errorf("CHAT_FEDERATION_NAME must look like a host name, such as chat.example.com")
+	}
+	seed := os.Getenv("CHAT_FEDERATION_KEY")
+	if seed == "" {
+		return fmt.Errorf("CHAT_FEDERATION_NAME needs CHAT_FEDERATION_KEY")
+	}
+	decoded, err := decodeKey("CHAT_FEDERATION_KEY", seed, ed25519.SeedSize)
+	if err != nil {
+		return err
+	}
+	federation.Key = ed25519.NewKeyFromSeed(decoded)
+
+	peers := os.Getenv("CHAT_FEDERATION_PEERS")
+	if strings.TrimSpace(peers) == "" {
+		return nil
+	}
+	names := map[string]bool{federation.Name: true}
+	for _, entry := range strings.Split(peers, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) != 3 {
+			return fmt.Errorf("CHAT_FEDERATION_PEERS must hold \"name url key\" entries separated by semicolons")
+		}
+		name := strings.ToLower(fields[0])
+		if !ValidServerName(name) || names[name] {
+			return fmt.Errorf("CHAT_FEDERATION_PEERS: %q is not a host name, or is listed twice or as this server", fields[0])
+		}
+		names[name] = true
+		if u, err := url.Parse(fields[1]); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("CHAT_FEDERATION_PEERS: the URL of %s must be an http or https URL", name)
+		}
+		key, err := decodeKey("CHAT_FEDERATION_PEERS: the key of "+name, fields[2], ed25519.PublicKeySize)
+		if err != nil {
+			return err
+		}
+		federation.Peers = append(federation.Peers, FederationPeer{
+			Name:      name,
+			URL:       strings.TrimSuffix(fields[1], "/"),
+			PublicKey: key,
+		})
+	}
+	return nil
+}
+
+// loadOIDC reads the single sign-on settings. CHAT_OIDC_ROLE_MAP maps claim
+// values to roles as "value=role" pairs separated by commas.
+func loadOIDC(oidc *OIDCConfig) error {
+	oidc.Issuer = strings.TrimSuffix(os.Getenv("CHAT_OIDC_ISSUER"), "/")
+	oidc.ClientID = os.Getenv("CHAT_OIDC_CLIENT_ID")
+	oidc.ClientSecret = os.Getenv("CHAT_OIDC_CLIENT_SECRET")
+	if name := os.Getenv("CHAT_OIDC_NAME"); name != "" {
+		oidc.Name = name
+	}
+	if scopes := os.Getenv("CHAT_OIDC_SCOPES"); scopes != "" {
+		oidc.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+	}
+	if claim := os.Getenv("CHAT_OIDC_USERNAME_CLAIM"); claim != "" {
+		oidc.UsernameClaim = claim
+	}
+	oidc.RoleClaim = os.Getenv("CHAT_OIDC_ROLE_CLAIM")
+
+	if oidc.Issuer != "" {
+		if u, err := url.Parse(oidc.Issuer); err != nil || (u.Scheme != "https" && u.Hostname() != "localhost") {
+			return fmt.Errorf("CHAT_OIDC_ISSUER must be an https URL, or http on localhost")
+		}
+	}
+
+	roleMap := os.Getenv("CHAT_OIDC_ROLE_MAP")
+	if roleMap == "" {
+		return nil
+	}
+	if oidc.RoleClaim == "" {
+		return fmt.Errorf("CHAT_OIDC_ROLE_MAP needs CHAT_OIDC_ROLE_CLAIM")
+	}
+	oidc.RoleMap = make(map[string]string)
+	for _, pair := range strings.Split(roleMap, ",") {
+		value, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		switch role {
+		case "guest", "member", "moderator", "admin":
+		default:
+			ok = false
+		}
+		if !ok || value == "" {
+			return fmt.Errorf("CHAT_OIDC_ROLE_MAP must hold value=role pairs with a guest, member, moderator or admin role")
+		}
+		oidc.RoleMap[value] = role
+	}
+	return nil
+}
+
+// decodeKey decodes a base64 key of the given size in bytes
+func decodeKey(name, encoded string, size int) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(decoded) != size {
+		return nil, fmt.Errorf("%s must be %d base64 encoded bytes", name, size)
+	}
+	return decoded, nil
+}
+
+// envInt reads an integer environment variable, falling back to def when unset
+func envInt(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, nil
+}
+
+// envDuration reads a duration environment variable such as "30s", falling back to def when unset
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
+
+// envFloat reads a decimal environment variable such as "0.25", falling back to def when unset
+func envFloat(key string, def float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return f, nil
+}
+
+// envBool reads a boolean environment variable such as "true", falling back to def when unset
+func envBool(key string, def bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", key, err)
+	}
+	return b, nil
+}
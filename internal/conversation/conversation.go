@@ -0,0 +1,240 @@
+package conversation
+
+import (
+	"encoding/hex"
+	"log"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/projection"
+	"realtime-chat/internal/store"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefix starts the ID of every direct message conversation, so their
+// histories are never mistaken for a room's
+const prefix = "dm_"
+
+// Conversation is a user's view of their direct messages with another user
+type Conversation struct {
+	ID           string              `json:"id"`
+	With         string              `json:"with"`
+	MessageCount int                 `json:"messageCount"`
+	LastMessage  *projection.Preview `json:"lastMessage,omitempty"`
+	Unread       int                 `json:"unread"`
+}
+
+// Conversations keeps the history of direct messages between each pair of
+// users, stored like a room's history under the conversation's ID
+type Conversations struct {
+	history     *history.History
+	projections *projection.Projections
+
+	mutex  sync.RWMutex
+	byUser map[string]map[string]bool // Username to the IDs of their conversations
+}
+
+// New creates an empty set of conversations kept in h, with unread counts
+// and last messages from p
+func New(h *history.History, p *projection.Projections) *Conversations {
+	return &Conversations{
+		history:     h,
+		projections: p,
+		byUser:      make(map[string]map[string]bool),
+	}
+}
+
+// ID returns the ID of the conversation between two users in a workspace,
+// the same whichever of them is given first. The IDs hex-encode their
+// participants, since usernames may hold any character a file name can't.
+func ID(workspace, a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return prefix + hex.EncodeToString([]byte(workspace+"\x00"+a+"\x00"+b))
+}
+
+// IsID reports whether a history ID is that of a conversation rather than a room
+func IsID(id string) bool {
+	return strings.HasPrefix(id, prefix)
+}
+
+// Participants returns the workspace and the two users of a conversation
+func Participants(id string) (workspace, a, b string, ok bool) {
+	if !IsID(id) {
+		return "", "", "", false
+	}
+	decoded, err := hex.DecodeString(strings.TrimPrefix(id, prefix))
+	if err != nil {
+		return "", "", "", false
+	}
+	parts := strings.Split(string(decoded), "\x00")
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// Load indexes the conversations among the IDs of every stored history
+func (c *Conversations) Load(st store.Store) error {
+	if st == nil {
+		return nil
+	}
+	ids, err := st.HistoryRooms()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, id := range ids {
+		if IsID(id) {
+			c.indexLocked(id)
+		}
+	}
+	return nil
+}
+
+// IDs returns the ID of every conversation
+func (c *Conversations) IDs() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, ids := range c.byUser {
+		for id := range ids {
+			seen[id] = true
+		}
+	}
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Post adds a direct message to the conversation between its sender and
+// recipient, starting the conversation if it is their first. The sender has
+// read the conversation up to their message; the recipient hasn't.
+func (c *Conversations) Post(workspace, from, to, content string, encryption *store.Encryption) (*history.Message, error) {
+	id := ID(workspace, from, to)
+
+	c.mutex.Lock()
+	c.indexLocked(id)
+	c.mutex.Unlock()
+
+	c.projections.Visit(id, to)
+	msg, _, err := c.history.PostEncrypted(id, from, "", content, encryption, 0)
+	if err != nil {
+		return nil, err
+	}
+	c.projections.Read(id, from)
+	return msg, nil
+}
+
+// List returns a user's conversations in a workspace, the most recently
+// active first
+func (c *Conversations) List(workspace, username string) []*Conversation {
+	c.mutex.RLock()
+	ids := make([]string, 0, len(c.byUser[username]))
+	for id := range c.byUser[username] {
+		ids = append(ids, id)
+	}
+	c.mutex.RUnlock()
+
+	conversations := make([]*Conversation, 0, len(ids))
+	for _, id := range ids {
+		ws, a, b, _ := Participants(id)
+		if ws != workspace {
+			continue
+		}
+		with := a
+		if a == username {
+			with = b
+		}
+		summary := c.projections.Summary(id)
+		conversations = append(conversations, &Conversation{
+			ID:           id,
+			With:         with,
+			MessageCount: summary.MessageCount,
+			LastMessage:  summary.LastMessage,
+			Unread:       summary.Unread[username],
+		})
+	}
+
+	sort.Slice(conversations, func(i, j int) bool {
+		return lastActive(conversations[i]).After(lastActive(conversations[j]))
+	})
+	return conversations
+}
+
+// Page returns a page of the conversation between two users, as
+// history.Page does for a room; users who never wrote each other get none
+func (c *Conversations) Page(workspace, username, with, before, after string, limit int) ([]*history.Message, bool, error) {
+	id := ID(workspace, username, with)
+	if !c.Has(username, id) {
+		return nil, false, nil
+	}
+	return c.history.Page(id, before, after, limit)
+}
+
+// Read marks a user's conversation with another as read
+func (c *Conversations) Read(workspace, username, with string) {
+	if id := ID(workspace, username, with); c.Has(username, id) {
+		c.projections.Read(id, username)
+	}
+}
+
+// Remove forgets every conversation of a user, returning their IDs so their
+// histories can be deleted
+func (c *Conversations) Remove(username string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var ids []string
+	for id := range c.byUser[username] {
+		ids = append(ids, id)
+		_, a, b, _ := Participants(id)
+		other := a
+		if a == username {
+			other = b
+		}
+		delete(c.byUser[other], id)
+	}
+	delete(c.byUser, username)
+	sort.Strings(ids)
+	return ids
+}
+
+// Has reports whether a user has the conversation with an ID
+func (c *Conversations) Has(username, id string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.byUser[username][id]
+}
+
+// indexLocked adds a conversation to the lists of both its users; the
+// caller holds the lock
+func (c *Conversations) indexLocked(id string) {
+	_, a, b, ok := Participants(id)
+	if !ok {
+		log.Printf("Ignoring history %s: not a valid conversation ID", id)
+		return
+	}
+	for _, username := range []string{a, b} {
+		if c.byUser[username] == nil {
+			c.byUser[username] = make(map[string]bool)
+		}
+		c.byUser[username][id] = true
+	}
+}
+
+// lastActive returns when a conversation's latest message was sent
+func lastActive(conversation *Conversation) time.Time {
+	if conversation.LastMessage == nil {
+		return time.Time{}
+	}
+	return conversation.LastMessage.Timestamp
+}
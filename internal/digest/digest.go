@@ -0,0 +1,173 @@
+package digest
+
+import (
+	"fmt"
+	"log"
+	"realtime-chat/internal/email"
+	"realtime-chat/internal/profile"
+	"realtime-chat/internal/store"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Digester emails users a digest of the direct messages, mentions and room
+// notifications queued for them while they were offline
+type Digester struct {
+	store    store.Store       // may be nil, which disables digests
+	profiles *profile.Profiles // holds each user's address and opt-out
+	email    email.Sender      // nil disables digests
+	after    time.Duration     // How long a message must wait before it is emailed
+
+	// RoomName returns the name of a room for mentions; may be nil
+	RoomName func(roomID string) string
+
+	// Set while Run is sending digests
+	running atomic.Bool
+}
+
+// New creates a digester; sender and st may be nil, which disables it
+func New(st store.Store, profiles *profile.Profiles, sender email.Sender, after time.Duration) *Digester {
+	return &Digester{
+		store:    st,
+		profiles: profiles,
+		email:    sender,
+		after:    after,
+	}
+}
+
+// Enabled reports whether digests can be sent
+func (d *Digester) Enabled() bool {
+	return d.store != nil && d.email != nil
+}
+
+// Run emails a digest to every user who isn't online, has an address and
+// hasn't opted out, once their oldest message not yet emailed has waited
+// long enough. Queued messages stay queued and are still delivered when the
+// user connects. Run blocks on network I/O and returns how many digests
+// were sent; it does nothing if a previous run hasn't finished.
+func (d *Digester) Run(online func(username string) bool) (int, error) {
+	if !d.Enabled() || !d.running.CompareAndSwap(false, true) {
+		return 0, nil
+	}
+	defer d.running.Store(false)
+
+	pending, err := d.store.PendingMessages()
+	if err != nil {
+		return 0, err
+	}
+
+	// Send in a stable order so logs are easy to follow
+	usernames := make([]string, 0, len(pending))
+	for username := range pending {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	sent := 0
+	now := time.Now()
+	for _, username := range usernames {
+		address, lastDigest, ok := d.profiles.DigestAddress(username)
+		if !ok || online(username) {
+			continue
+		}
+
+		var missed []*store.PendingMessage
+		for _, msg := range pending[username] {
+			// Rooms muted since the message was queued are left out
+			if msg.RoomID != "" && d.profiles.NotificationLevel(username, msg.RoomID) == profile.LevelMuted {
+				continue
+			}
+			if msg.Timestamp.After(lastDigest) {
+				missed = append(missed, msg)
+			}
+		}
+		// Queues are oldest first; a message this old means the user has been away that long
+		if len(missed) == 0 || now.Sub(missed[0].Timestamp) < d.after {
+			continue
+		}
+
+		subject := fmt.Sprintf("You missed %d message%s", len(missed), plural(len(missed)))
+		if err := d.email.Send(address, subject, d.body(username, missed)); err != nil {
+			log.Printf("Error emailing digest to %s: %v", username, err)
+			continue
+		}
+		if err := d.profiles.MarkDigested(username, missed[len(missed)-1].Timestamp); err != nil {
+			log.Printf("Error recording digest for %s: %v", username, err)
+		}
+		sent++
+	}
+
+	if sent > 0 {
+		log.Printf("Emailed %d digests of missed messages", sent)
+	}
+	return sent, nil
+}
+
+// body renders the plain-text digest of a user's missed messages
+func (d *Digester) body(username string, missed []*store.PendingMessage) string {
+	var direct, mentions, others []*store.PendingMessage
+	for _, msg := range missed {
+		switch msg.Kind {
+		case store.PendingMention:
+			mentions = append(mentions, msg)
+		case store.PendingRoom:
+			others = append(others, msg)
+		default:
+			direct = append(direct, msg)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\n", username)
+	fmt.Fprintf(&b, "You missed %d message%s while you were away.\n", len(missed), plural(len(missed)))
+
+	if len(direct) > 0 {
+		b.WriteString("\nDirect messages\n")
+		for _, msg := range direct {
+			fmt.Fprintf(&b, "  [%s] %s: %s\n", msg.Timestamp.UTC().Format("2006-01-02 15:04 MST"), msg.From, content(msg))
+		}
+	}
+
+	d.writeRoomMessages(&b, "Mentions", mentions)
+	d.writeRoomMessages(&b, "Other messages in your rooms", others)
+
+	b.WriteString("\nConnect to the chat to read and reply. To stop these emails, turn off email digests in your profile.\n")
+	return b.String()
+}
+
+// writeRoomMessages adds a titled section of room messages to a digest
+func (d *Digester) writeRoomMessages(b *strings.Builder, title string, messages []*store.PendingMessage) {
+	if len(messages) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "\n%s\n", title)
+	for _, msg := range messages {
+		room := msg.RoomID
+		if d.RoomName != nil {
+			if name := d.RoomName(msg.RoomID); name != "" {
+				room = name
+			}
+		}
+		fmt.Fprintf(b, "  [%s] %s in %s: %s\n", msg.Timestamp.UTC().Format("2006-01-02 15:04 MST"), msg.From, room, content(msg))
+	}
+}
+
+// content returns a missed message's text as a digest shows it; only the
+// recipient's devices can decrypt end-to-end encrypted messages
+func content(msg *store.PendingMessage) string {
+	if msg.Encryption != nil {
+		return "[encrypted message]"
+	}
+	return msg.Content
+}
+
+// plural returns "s" unless n is 1
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,89 @@
+// Package discovery advertises the server on the local network over mDNS
+// (zeroconf), so other devices and native clients find nearby chat servers
+// without typing addresses. The server is a _chat._tcp service whose TXT
+// records tell clients how to connect:
+//
+//	url=http://192.168.1.20:8080   Address of the web client
+//	ws=/ws                         Path of the WebSocket endpoint
+//	protocol=1                     Newest wire protocol version spoken
+package discovery
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"realtime-chat/internal/websocket"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/mdns"
+)
+
+// Service is the DNS-SD service type chat servers are advertised as
+const Service = "_chat._tcp"
+
+// Advertiser answers mDNS queries for the server until it is closed
+type Advertiser struct {
+	server *mdns.Server
+}
+
+// Advertise starts answering mDNS queries with the host and port of
+// networkURL, under instance or the host name when instance is empty
+func Advertise(networkURL, instance string) (*Advertiser, error) {
+	u, err := url.Parse(networkURL)
+	if err != nil {
+		return nil, err
+	}
+	port := 80
+	if u.Scheme == "https" {
+		port = 443
+	}
+	if u.Port() != "" {
+		if port, err = strconv.Atoi(u.Port()); err != nil {
+			return nil, fmt.Errorf("invalid port in %s", networkURL)
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	host, _, _ = strings.Cut(host, ".")
+	if instance == "" {
+		instance = host
+	}
+
+	// Clients are sent the network URL's address, which a host name is resolved to
+	var ips []net.IP
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		ips = []net.IP{ip}
+	} else if ips, err = net.LookupIP(u.Hostname()); err != nil {
+		return nil, err
+	}
+
+	txt := []string{
+		"url=" + networkURL,
+		"ws=/ws",
+		"protocol=" + strconv.Itoa(websocket.ProtocolVersion),
+	}
+	service, err := mdns.NewMDNSService(instance, Service, "local.", host+".local.", port, ips, txt)
+	if err != nil {
+		return nil, err
+	}
+	server, err := mdns.NewServer(&mdns.Config{
+		Zone:   service,
+		Logger: log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Advertiser{server: server}, nil
+}
+
+// Close stops answering queries
+func (a *Advertiser) Close() {
+	a.server.Shutdown()
+}
@@ -0,0 +1,268 @@
+package e2ee
+
+import (
+	"errors"
+	"realtime-chat/internal/store"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limits on the key bundles a device can publish
+const (
+	MaxDevices     = 16  // Devices per user
+	MaxOneTimeKeys = 100 // One-time prekeys waiting to be claimed per device
+	MaxKeyLength   = 512 // Length of a key, signature or ID
+)
+
+// Errors returned when a key operation is rejected
+var (
+	ErrInvalidKeys    = errors.New("a device needs an ID, identity and signing keys, and a signed prekey")
+	ErrTooManyDevices = errors.New("too many devices")
+	ErrTooManyKeys    = errors.New("too many one-time keys")
+	ErrUnknownDevice  = errors.New("device not found")
+)
+
+// Device is the public view of a device's keys, used to decide which
+// devices to encrypt for and to verify them
+type Device struct {
+	Username     string       `json:"username"`
+	DeviceID     string       `json:"deviceId"`
+	IdentityKey  string       `json:"identityKey"`
+	SigningKey   string       `json:"signingKey"`
+	SignedPreKey store.PreKey `json:"signedPreKey"`
+	OneTimeKeys  int          `json:"oneTimeKeys"` // How many are left to claim
+	UpdatedAt    time.Time    `json:"updatedAt"`
+}
+
+// Bundle is what another device needs to start an X3DH session with a
+// device: its identity and signed prekeys and, while it has any left, one
+// of its one-time prekeys
+type Bundle struct {
+	Username     string        `json:"username"`
+	DeviceID     string        `json:"deviceId"`
+	IdentityKey  string        `json:"identityKey"`
+	SigningKey   string        `json:"signingKey"`
+	SignedPreKey store.PreKey  `json:"signedPreKey"`
+	OneTimeKey   *store.PreKey `json:"oneTimeKey,omitempty"`
+}
+
+// Directory holds the public key bundles of every user's devices and
+// persists them. It only ever sees public keys; clients keep their private
+// keys and do all encryption themselves.
+type Directory struct {
+	store   store.Store // may be nil for an in-memory only directory
+	mutex   sync.Mutex
+	devices map[string]map[string]*store.DeviceKeysRecord // Username to device ID
+}
+
+// New creates an empty directory
+func New(st store.Store) *Directory {
+	return &Directory{store: st, devices: make(map[string]map[string]*store.DeviceKeysRecord)}
+}
+
+// Load reads every persisted key bundle into memory
+func (d *Directory) Load() error {
+	if d.store == nil {
+		return nil
+	}
+
+	records, err := d.store.LoadDeviceKeys()
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, rec := range records {
+		d.userDevices(rec.Username)[rec.DeviceID] = rec
+	}
+	return nil
+}
+
+// Upload publishes a device's keys. A device uploading the identity key it
+// already has refreshes its signed prekey and adds one-time prekeys to
+// those left; one with a new identity key was reinstalled and replaces its
+// old bundle entirely.
+func (d *Directory) Upload(username string, keys *store.DeviceKeysRecord) (*Device, error) {
+	if !validKeys(keys) {
+		return nil, ErrInvalidKeys
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	previous, exists := d.devices[username][keys.DeviceID]
+	if !exists && len(d.devices[username]) >= MaxDevices {
+		return nil, ErrTooManyDevices
+	}
+
+	rec := &store.DeviceKeysRecord{
+		Username:     username,
+		DeviceID:     keys.DeviceID,
+		IdentityKey:  keys.IdentityKey,
+		SigningKey:   keys.SigningKey,
+		SignedPreKey: keys.SignedPreKey,
+		UpdatedAt:    time.Now(),
+	}
+	seen := make(map[string]bool)
+	if exists && previous.IdentityKey == keys.IdentityKey {
+		for _, key := range previous.OneTimeKeys {
+			rec.OneTimeKeys = append(rec.OneTimeKeys, key)
+			seen[key.ID] = true
+		}
+	}
+	for _, key := range keys.OneTimeKeys {
+		if !seen[key.ID] {
+			rec.OneTimeKeys = append(rec.OneTimeKeys, key)
+			seen[key.ID] = true
+		}
+	}
+	if len(rec.OneTimeKeys) > MaxOneTimeKeys {
+		return nil, ErrTooManyKeys
+	}
+
+	if err := d.save(rec); err != nil {
+		return nil, err
+	}
+	d.userDevices(username)[rec.DeviceID] = rec
+	return device(rec), nil
+}
+
+// Devices returns the public keys of every device of a user, ordered by device ID
+func (d *Directory) Devices(username string) []*Device {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	devices := make([]*Device, 0, len(d.devices[username]))
+	for _, rec := range d.devices[username] {
+		devices = append(devices, device(rec))
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].DeviceID < devices[j].DeviceID
+	})
+	return devices
+}
+
+// Claim returns a bundle for every device of a user, ordered by device ID,
+// handing out and removing one one-time prekey of each device that has any
+// left. Devices that ran out are returned with their signed prekey only.
+func (d *Directory) Claim(username string) ([]*Bundle, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	bundles := make([]*Bundle, 0, len(d.devices[username]))
+	for _, rec := range d.devices[username] {
+		bundle := &Bundle{
+			Username:     rec.Username,
+			DeviceID:     rec.DeviceID,
+			IdentityKey:  rec.IdentityKey,
+			SigningKey:   rec.SigningKey,
+			SignedPreKey: rec.SignedPreKey,
+		}
+		if len(rec.OneTimeKeys) > 0 {
+			key := rec.OneTimeKeys[0]
+			claimed := *rec
+			claimed.OneTimeKeys = rec.OneTimeKeys[1:]
+			if err := d.save(&claimed); err != nil {
+				return nil, err
+			}
+			*rec = claimed
+			bundle.OneTimeKey = &key
+		}
+		bundles = append(bundles, bundle)
+	}
+	sort.Slice(bundles, func(i, j int) bool {
+		return bundles[i].DeviceID < bundles[j].DeviceID
+	})
+	return bundles, nil
+}
+
+// Remove deletes a device's keys, for example when it signs out for good
+func (d *Directory) Remove(username, deviceID string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, exists := d.devices[username][deviceID]; !exists {
+		return ErrUnknownDevice
+	}
+	if d.store != nil {
+		if err := d.store.DeleteDeviceKeys(username, deviceID); err != nil {
+			return err
+		}
+	}
+	delete(d.devices[username], deviceID)
+	if len(d.devices[username]) == 0 {
+		delete(d.devices, username)
+	}
+	return nil
+}
+
+// Forget deletes the keys of every device of a user and returns how many
+// devices they had
+func (d *Directory) Forget(username string) (int, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	removed := 0
+	for deviceID := range d.devices[username] {
+		if d.store != nil {
+			if err := d.store.DeleteDeviceKeys(username, deviceID); err != nil {
+				return removed, err
+			}
+		}
+		delete(d.devices[username], deviceID)
+		removed++
+	}
+	delete(d.devices, username)
+	return removed, nil
+}
+
+// userDevices returns a user's devices, creating the map if needed; the
+// caller must hold the lock
+func (d *Directory) userDevices(username string) map[string]*store.DeviceKeysRecord {
+	devices, ok := d.devices[username]
+	if !ok {
+		devices = make(map[string]*store.DeviceKeysRecord)
+		d.devices[username] = devices
+	}
+	return devices
+}
+
+// save persists a device's keys; the caller must hold the lock
+func (d *Directory) save(rec *store.DeviceKeysRecord) error {
+	if d.store == nil {
+		return nil
+	}
+	return d.store.SaveDeviceKeys(rec)
+}
+
+// device returns the public view of a device's keys
+func device(rec *store.DeviceKeysRecord) *Device {
+	return &Device{
+		Username:     rec.Username,
+		DeviceID:     rec.DeviceID,
+		IdentityKey:  rec.IdentityKey,
+		SigningKey:   rec.SigningKey,
+		SignedPreKey: rec.SignedPreKey,
+		OneTimeKeys:  len(rec.OneTimeKeys),
+		UpdatedAt:    rec.UpdatedAt,
+	}
+}
+
+// validKeys reports whether an uploaded bundle has every required key and
+// nothing longer than MaxKeyLength
+func validKeys(keys *store.DeviceKeysRecord) bool {
+	required := []string{keys.DeviceID, keys.IdentityKey, keys.SigningKey, keys.SignedPreKey.ID, keys.SignedPreKey.Key, keys.SignedPreKey.Signature}
+	for _, value := range required {
+		if value == "" || len(value) > MaxKeyLength {
+			return false
+		}
+	}
+	for _, key := range keys.OneTimeKeys {
+		if key.ID == "" || key.Key == "" || len(key.ID) > MaxKeyLength || len(key.Key) > MaxKeyLength || len(key.Signature) > MaxKeyLength {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package email
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"realtime-chat/internal/config"
+	"strings"
+	"time"
+)
+
+// Sender delivers plain-text email
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPSender sends email through an SMTP server
+type SMTPSender struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// New returns an SMTP sender for cfg, or nil when email is not configured
+func New(cfg config.EmailConfig) Sender {
+	if cfg.SMTPAddr == "" {
+		return nil
+	}
+
+	sender := &SMTPSender{addr: cfg.SMTPAddr, from: cfg.From}
+	if cfg.Username != "" {
+		host, _, err := net.SplitHostPort(cfg.SMTPAddr)
+		if err != nil {
+			host = cfg.SMTPAddr
+		}
+		sender.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return sender
+}
+
+// Send sends a plain-text message to a single recipient
+func (s *SMTPSender) Send(to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid header value")
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, []byte(msg.String()))
+}
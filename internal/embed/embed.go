@@ -0,0 +1,166 @@
+// Package embed lets site owners drop a room's chat into any webpage. A
+// room whose admins listed a site in its embedOrigins setting is shown at
+// /embed/{room}?origin=site, a page that only that site may frame, and
+// /embed.js mounts the page into elements with a data-chat-room attribute.
+//
+// The chat in the page is a guest limited to the room and the site: its
+// session token, from POST /embed/{room}/token, can't join other rooms,
+// send direct messages or call the REST API, and only connects from the
+// embed page or the site it was issued to.
+//
+// The page and the site talk over postMessage. The page sends the site
+// objects with a type of
+//
+//	ready     The widget joined the room, with its username and roomId
+//	message   A message posted in the room, in message
+//	error     Something went wrong, described in message
+//
+// and the site sends the page {type: "send", content} to post a message.
+package embed
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/workspace"
+)
+
+// maxTokenRequest is the largest body of a token request
+const maxTokenRequest = 4096
+
+// Handler serves the embed page, its script and its session tokens
+type Handler struct {
+	hub *hub.Hub
+}
+
+// Register adds the embed routes to mux
+func Register(mux *http.ServeMux, h *hub.Hub) {
+	handler := &Handler{hub: h}
+	mux.HandleFunc("GET /embed.js", handler.script)
+	mux.HandleFunc("GET /embed/{room}", handler.page)
+	mux.HandleFunc("POST /embed/{room}/token", handler.token)
+	mux.HandleFunc("OPTIONS /embed/{room}/token", handler.preflight)
+}
+
+// room returns the room a request names by its ID or slug, when it is
+// embedded in any site and its workspace lets guests in
+func (h *Handler) room(r *http.Request) (*room.Room, bool) {
+	rm, exists := h.hub.Room(workspace.FromContext(r.Context()), r.PathValue("room"))
+	if !exists || len(rm.EmbedOrigins()) == 0 || rm.IsEncrypted() || rm.IsGroup() {
+		return nil, false
+	}
+	if !h.hub.Workspaces.Admits(rm.Workspace, "", false) {
+		return nil, false
+	}
+	return rm, true
+}
+
+// script sends the script that mounts embed pages into a site
+func (h *Handler) script(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(widgetScript))
+}
+
+// tokenRequest asks for a session token of an embedded chat widget
+type tokenRequest struct {
+	// Site the embed page is framed in; only read from the page itself,
+	// since a site's own requests carry their Origin header
+	Origin string `json:"origin"`
+
+	// Token issued before, kept while it is still valid for the room and site
+	Token string `json:"token"`
+}
+
+// token handles POST /embed/{room}/token and issues a guest limited to the
+// room and the site asking. Sites ask across origins, and are answered
+// only when the room is embedded in them; the embed page asks for the site
+// it is framed in, which the browser holds to the room's sites.
+func (h *Handler) token(w http.ResponseWriter, r *http.Request) {
+	rm, exists := h.room(r)
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req tokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTokenRequest)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(r, origin) {
+		origin = req.Origin
+	} else if rm.Embeddable(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	if !rm.Embeddable(origin) {
+		http.Error(w, "This room isn't embedded in that site", http.StatusForbidden)
+		return
+	}
+
+	// A widget coming back keeps its guest while the session lasts
+	if username, issuedTo, roomID, ok := h.hub.Auth.EmbedSession(req.Token); ok && issuedTo == origin && roomID == rm.ID {
+		writeToken(w, http.StatusOK, username, req.Token, rm.ID)
+		return
+	}
+
+	username, token, _, err := h.hub.Auth.NewEmbedGuest(r.UserAgent(), remoteIP(r), origin, rm.ID)
+	if err != nil {
+		log.Printf("Error issuing embedded guest for %s: %v", rm.ID, err)
+		http.Error(w, "Could not issue guest identity", http.StatusInternalServerError)
+		return
+	}
+	writeToken(w, http.StatusCreated, username, token, rm.ID)
+}
+
+// preflight answers the CORS preflight of a site's token request
+func (h *Handler) preflight(w http.ResponseWriter, r *http.Request) {
+	rm, exists := h.room(r)
+	origin := r.Header.Get("Origin")
+	if !exists || !rm.Embeddable(origin) {
+		http.Error(w, "This room isn't embedded in that site", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Max-Age", "3600")
+	w.Header().Add("Vary", "Origin")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeToken sends an embedded chat widget's guest and session token
+func writeToken(w http.ResponseWriter, status int, username, token, roomID string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"username": username,
+		"token":    token,
+		"roomId":   roomID,
+	})
+}
+
+// sameOrigin reports whether origin is the server's own, as for requests
+// from the embed page
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// remoteIP returns the address a request came from, without its port
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,237 @@
+package embed
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// pageTemplate renders a room's chat for framing in a site. The script
+// gets a guest for the site, chats over the WebSocket endpoint and passes
+// the room's messages to the site.
+var pageTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}}</title>
+<style>
+  html, body { height: 100%; }
+  body { margin: 0; display: flex; flex-direction: column; font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #f5f6fa; color: #222; font-size: 14px; }
+  header { background: #4a5fc1; color: #fff; padding: 8px 12px; display: flex; justify-content: space-between; align-items: center; }
+  header h1 { margin: 0; font-size: 1em; }
+  #status { font-size: 0.85em; opacity: 0.85; }
+  #messages { flex: 1; overflow-y: auto; list-style: none; margin: 0; padding: 8px 12px; }
+  .message { background: #fff; border-radius: 6px; padding: 6px 10px; margin: 6px 0; box-shadow: 0 1px 2px rgba(0,0,0,0.08); }
+  .meta { font-size: 0.8em; color: #666; margin-bottom: 2px; }
+  .meta strong { color: #4a5fc1; }
+  .content { overflow-wrap: anywhere; }
+  .content p { margin: 0; }
+  .content img { max-width: 100%; border-radius: 4px; }
+  .notice { font-size: 0.85em; color: #a33; text-align: center; padding: 4px; }
+  form { display: flex; gap: 6px; padding: 8px 12px; background: #fff; border-top: 1px solid #e3e5ee; }
+  input { flex: 1; padding: 6px 8px; border: 1px solid #ccd; border-radius: 4px; font: inherit; }
+  button { padding: 6px 12px; border: 0; border-radius: 4px; background: #4a5fc1; color: #fff; font: inherit; cursor: pointer; }
+  button:disabled { opacity: 0.5; cursor: default; }
+</style>
+</head>
+<body>
+<header><h1>{{.Name}}</h1><span id="status">Connecting…</span></header>
+<ol id="messages"></ol>
+<div class="notice" id="notice" hidden></div>
+<form id="form"><input id="input" autocomplete="off" placeholder="Message" maxlength="4000" disabled><button disabled>Send</button></form>
+<script>
+(function () {
+  var ROOM = {{.Room}};
+  var ORIGIN = {{.Origin}};
+  var list = document.getElementById("messages");
+  var status = document.getElementById("status");
+  var notice = document.getElementById("notice");
+  var form = document.getElementById("form");
+  var input = document.getElementById("input");
+  var button = form.querySelector("button");
+  var storageKey = "chat-embed:" + ROOM + ":" + ORIGIN;
+  var socket = null;
+  var retry = 1000;
+  var lastSeq = -1;
+
+  // Only the site framing the page hears from it
+  function post(data) {
+    if (window.parent !== window) window.parent.postMessage(data, ORIGIN);
+  }
+  function showError(message) {
+    notice.textContent = message;
+    notice.hidden = false;
+    post({ type: "error", message: message });
+  }
+  function stored() {
+    try { return localStorage.getItem(storageKey) || ""; } catch (e) { return ""; }
+  }
+  function store(token) {
+    try { localStorage.setItem(storageKey, token); } catch (e) {}
+  }
+  function setReady(ready) {
+    input.disabled = button.disabled = !ready;
+  }
+
+  function fill(content, msg) {
+    content.textContent = "";
+    if (msg.gif) {
+      var img = document.createElement("img");
+      img.src = msg.gif.url;
+      img.alt = msg.gif.title || "";
+      content.appendChild(img);
+    } else if (msg.html) {
+      content.innerHTML = msg.html; // Sanitized by the server
+    } else {
+      content.textContent = msg.content;
+    }
+  }
+  function add(msg) {
+    if (msg.seq > lastSeq) lastSeq = msg.seq;
+    if (!msg.id || msg.deleted || document.getElementById("m-" + msg.id)) return;
+    var follow = list.scrollTop + list.clientHeight >= list.scrollHeight - 40;
+    var item = document.createElement("li");
+    item.className = "message";
+    item.id = "m-" + msg.id;
+    var meta = document.createElement("div");
+    meta.className = "meta";
+    var name = document.createElement("strong");
+    name.textContent = msg.username;
+    meta.appendChild(name);
+    var content = document.createElement("div");
+    content.className = "content";
+    fill(content, msg);
+    item.appendChild(meta);
+    item.appendChild(content);
+    list.appendChild(item);
+    if (follow) list.scrollTop = list.scrollHeight;
+  }
+
+  function handle(data) {
+    switch (data.type) {
+    case "room_joined":
+      // Users logged in to the server start in the lobby as usual
+      if (data.roomId !== ROOM) {
+        socket.send(JSON.stringify({ type: "join", roomId: ROOM }));
+        break;
+      }
+      status.textContent = data.username;
+      notice.hidden = true;
+      setReady(data.canPost);
+      retry = 1000;
+      // Recent messages at first, and those missed after reconnecting
+      socket.send(JSON.stringify(lastSeq < 0 ? { type: "load_more" } : { type: "load_more", afterSeq: lastSeq }));
+      post({ type: "ready", username: data.username, roomId: data.roomId });
+      break;
+    case "history_page":
+      (data.messages || []).forEach(add);
+      break;
+    case "message":
+      if (data.roomId && data.roomId !== ROOM) return;
+      add(data);
+      post({ type: "message", message: { id: data.id, username: data.username, content: data.content, html: data.html, timestamp: data.timestamp } });
+      break;
+    case "message_edited":
+      var edited = document.getElementById("m-" + data.messageId);
+      if (edited) fill(edited.querySelector(".content"), data);
+      break;
+    case "message_deleted":
+      var deleted = document.getElementById("m-" + data.messageId);
+      if (deleted) deleted.remove();
+      break;
+    case "room_error":
+    case "permission_error":
+    case "muted":
+      showError(data.message);
+      break;
+    }
+  }
+
+  function connect() {
+    setReady(false);
+    fetch(location.pathname + "/token", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ origin: ORIGIN, token: stored() })
+    }).then(function (res) {
+      if (!res.ok) throw new Error("This room can't be embedded here");
+      return res.json();
+    }).then(function (guest) {
+      store(guest.token);
+      var url = new URL("../ws", location.href);
+      url.protocol = url.protocol === "https:" ? "wss:" : "ws:";
+      url.searchParams.set("token", guest.token);
+      socket = new WebSocket(url);
+      socket.onmessage = function (e) {
+        if (typeof e.data === "string") handle(JSON.parse(e.data));
+      };
+      socket.onclose = reconnect;
+    }).catch(function (err) {
+      showError(err.message);
+      reconnect();
+    });
+  }
+  function reconnect() {
+    socket = null;
+    setReady(false);
+    status.textContent = "Reconnecting…";
+    setTimeout(connect, retry);
+    retry = Math.min(retry * 2, 30000);
+  }
+
+  function send(content) {
+    content = String(content || "").trim();
+    if (!content || !socket || socket.readyState !== WebSocket.OPEN) return false;
+    socket.send(JSON.stringify({ type: "message", content: content }));
+    return true;
+  }
+  form.addEventListener("submit", function (e) {
+    e.preventDefault();
+    if (send(input.value)) input.value = "";
+  });
+  window.addEventListener("message", function (e) {
+    if (e.source !== window.parent || e.origin !== ORIGIN || !e.data) return;
+    if (e.data.type === "send" && !send(e.data.content)) {
+      post({ type: "error", message: "Not connected" });
+    }
+  });
+
+  connect();
+})();
+</script>
+</body>
+</html>
+`))
+
+// page handles GET /embed/{room}?origin=site and sends the room's chat,
+// which only that site may frame
+func (h *Handler) page(w http.ResponseWriter, r *http.Request) {
+	rm, exists := h.room(r)
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	origin := r.URL.Query().Get("origin")
+	if !rm.Embeddable(origin) {
+		http.Error(w, "This room isn't embedded in that site", http.StatusForbidden)
+		return
+	}
+
+	name := rm.Name
+	if name == "" {
+		name = rm.GetSlug()
+	}
+	// Embedded origins are validated, so they are safe in the policy
+	w.Header().Set("Content-Security-Policy", "frame-ancestors "+origin)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	err := pageTemplate.Execute(w, map[string]interface{}{
+		"Name":   name,
+		"Room":   rm.ID,
+		"Origin": origin,
+	})
+	if err != nil {
+		log.Printf("Error rendering embed page of %s: %v", rm.ID, err)
+	}
+}
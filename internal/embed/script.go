@@ -0,0 +1,71 @@
+package embed
+
+// widgetScript mounts a room's embed page into every element of a site
+// with a data-chat-room attribute, and into those passed to
+// ChatEmbed.mount. The widgets it returns send messages to their page and
+// pass on what the page tells them.
+const widgetScript = `(function () {
+  if (window.ChatEmbed) return;
+  var script = document.currentScript;
+  var base = new URL(".", script ? script.src : location.href);
+  var widgets = [];
+
+  function Widget(el, options) {
+    var room = options.room || el.getAttribute("data-chat-room");
+    var height = options.height || el.getAttribute("data-chat-height") || "480px";
+    this.el = el;
+    this.handlers = {};
+    this.frame = document.createElement("iframe");
+    this.frame.src = new URL("embed/" + encodeURIComponent(room) + "?origin=" + encodeURIComponent(location.origin), base).href;
+    this.frame.title = options.title || "Chat";
+    this.frame.style.cssText = "border: 0; width: 100%; height: " + height + ";";
+    el.appendChild(this.frame);
+  }
+  Widget.prototype.on = function (type, fn) {
+    (this.handlers[type] = this.handlers[type] || []).push(fn);
+    return this;
+  };
+  Widget.prototype.send = function (content) {
+    this.frame.contentWindow.postMessage({ type: "send", content: content }, base.origin);
+  };
+  Widget.prototype.destroy = function () {
+    widgets.splice(widgets.indexOf(this), 1);
+    this.frame.remove();
+    delete this.el.chatEmbed;
+  };
+  Widget.prototype.emit = function (data) {
+    (this.handlers[data.type] || []).forEach(function (fn) { fn(data); });
+  };
+
+  // Only the server's pages are listened to, each by its own widget
+  window.addEventListener("message", function (e) {
+    if (e.origin !== base.origin || !e.data || !e.data.type) return;
+    widgets.forEach(function (widget) {
+      if (widget.frame.contentWindow === e.source) widget.emit(e.data);
+    });
+  });
+
+  function mount(el, options) {
+    if (typeof el === "string") el = document.querySelector(el);
+    if (!el) throw new Error("ChatEmbed: no element to mount in");
+    if (el.chatEmbed) return el.chatEmbed;
+    options = options || {};
+    if (!options.room && !el.getAttribute("data-chat-room")) throw new Error("ChatEmbed: a room is required");
+    var widget = new Widget(el, options);
+    el.chatEmbed = widget;
+    widgets.push(widget);
+    return widget;
+  }
+  function mountAll() {
+    var elements = document.querySelectorAll("[data-chat-room]");
+    for (var i = 0; i < elements.length; i++) mount(elements[i]);
+  }
+
+  window.ChatEmbed = { mount: mount };
+  if (document.readyState === "loading") {
+    document.addEventListener("DOMContentLoaded", mountAll);
+  } else {
+    mountAll();
+  }
+})();
+`
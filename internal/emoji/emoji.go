@@ -0,0 +1,227 @@
+package emoji
+
+import (
+	"errors"
+	"realtime-chat/internal/media"
+	"realtime-chat/internal/store"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of custom images
+const (
+	KindEmoji   = "emoji"
+	KindSticker = "sticker"
+)
+
+// MaxImageSize is the largest image accepted for an emoji or sticker, in bytes
+const MaxImageSize = 256 << 10
+
+// Errors returned when registering an emoji is rejected
+var (
+	ErrNotFound         = errors.New("emoji not found")
+	ErrInvalidShortcode = errors.New("a shortcode is 2 to 32 lowercase letters, digits, '_', '+' or '-', starting with a letter")
+	ErrInvalidKind      = errors.New(`kind must be "emoji" or "sticker"`)
+	ErrInvalidImage     = errors.New("image must be a PNG, GIF, JPEG or WebP of at most 256 KiB")
+)
+
+// shortcodePattern matches a valid shortcode
+var shortcodePattern = regexp.MustCompile(`^[a-z][a-z0-9_+-]{1,31}$`)
+
+// usagePattern matches :shortcode: in text
+var usagePattern = regexp.MustCompile(`:([a-z][a-z0-9_+-]{1,31}):`)
+
+// Emoji is a registered custom emoji or sticker as listed to clients
+type Emoji struct {
+	Shortcode string    `json:"shortcode"`
+	Kind      string    `json:"kind"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Registry holds the server's custom emoji and stickers
+type Registry struct {
+	store store.Store // may be nil for in-memory only emoji
+	mutex sync.RWMutex
+	emoji map[string]*store.EmojiRecord
+
+	// Images of emoji registered without a store
+	images map[string][]byte
+}
+
+// NewRegistry creates an empty registry backed by st
+func NewRegistry(st store.Store) *Registry {
+	return &Registry{
+		store:  st,
+		emoji:  make(map[string]*store.EmojiRecord),
+		images: make(map[string][]byte),
+	}
+}
+
+// Load reads every persisted emoji into the registry
+func (r *Registry) Load() error {
+	if r.store == nil {
+		return nil
+	}
+
+	records, err := r.store.LoadEmoji()
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, rec := range records {
+		r.emoji[rec.Shortcode] = rec
+	}
+	return nil
+}
+
+// Register adds or replaces a custom emoji or sticker
+func (r *Registry) Register(shortcode, kind string, image []byte) (*Emoji, error) {
+	if !shortcodePattern.MatchString(shortcode) {
+		return nil, ErrInvalidShortcode
+	}
+	if kind == "" {
+		kind = KindEmoji
+	}
+	if kind != KindEmoji && kind != KindSticker {
+		return nil, ErrInvalidKind
+	}
+
+	if len(image) == 0 || len(image) > MaxImageSize {
+		return nil, ErrInvalidImage
+	}
+	// Identified by content and kept without metadata such as EXIF location
+	image, contentType, err := media.Clean(image)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	rec := &store.EmojiRecord{
+		Shortcode:   shortcode,
+		Kind:        kind,
+		ContentType: contentType,
+		CreatedAt:   time.Now(),
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.store != nil {
+		if err := r.store.SaveEmoji(rec, image); err != nil {
+			return nil, err
+		}
+	} else {
+		r.images[shortcode] = image
+	}
+	r.emoji[shortcode] = rec
+	return view(rec), nil
+}
+
+// Remove deletes a custom emoji or sticker
+func (r *Registry) Remove(shortcode string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.emoji[shortcode]; !ok {
+		return ErrNotFound
+	}
+	if r.store != nil {
+		if err := r.store.DeleteEmoji(shortcode); err != nil {
+			return err
+		}
+	}
+	delete(r.emoji, shortcode)
+	delete(r.images, shortcode)
+	return nil
+}
+
+// List returns every registered emoji and sticker, sorted by shortcode
+func (r *Registry) List() []*Emoji {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	list := make([]*Emoji, 0, len(r.emoji))
+	for _, rec := range r.emoji {
+		list = append(list, view(rec))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Shortcode < list[j].Shortcode
+	})
+	return list
+}
+
+// Image returns an emoji's image and its content type
+func (r *Registry) Image(shortcode string) ([]byte, string, error) {
+	r.mutex.RLock()
+	rec, ok := r.emoji[shortcode]
+	image := r.images[shortcode]
+	r.mutex.RUnlock()
+
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+	if r.store == nil {
+		return image, rec.ContentType, nil
+	}
+
+	image, err := r.store.LoadEmojiImage(shortcode)
+	if err != nil {
+		return nil, "", err
+	}
+	return image, rec.ContentType, nil
+}
+
+// Resolve finds every :shortcode: in text. It returns the URLs of the
+// registered ones and the shortcodes that aren't registered.
+func (r *Registry) Resolve(text string) (map[string]string, []string) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var (
+		used    map[string]string
+		unknown []string
+	)
+	for _, match := range usagePattern.FindAllStringSubmatch(text, -1) {
+		shortcode := match[1]
+		if rec, ok := r.emoji[shortcode]; ok {
+			if used == nil {
+				used = make(map[string]string)
+			}
+			used[shortcode] = view(rec).URL
+		} else {
+			unknown = append(unknown, shortcode)
+		}
+	}
+	return used, unknown
+}
+
+// IsShortcode reports whether s is written as :shortcode: and so refers to a custom emoji
+func IsShortcode(s string) bool {
+	return len(s) > 2 && s[0] == ':' && s[len(s)-1] == ':'
+}
+
+// Known reports whether a :shortcode: refers to a registered emoji
+func (r *Registry) Known(s string) bool {
+	if !IsShortcode(s) {
+		return false
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	_, ok := r.emoji[s[1:len(s)-1]]
+	return ok
+}
+
+// view converts a record to its client representation
+func view(rec *store.EmojiRecord) *Emoji {
+	return &Emoji{
+		Shortcode: rec.Shortcode,
+		Kind:      rec.Kind,
+		URL:       "/api/emoji/" + rec.Shortcode,
+		CreatedAt: rec.CreatedAt,
+	}
+}
@@ -0,0 +1,346 @@
+// Package federation shares rooms between independent chat servers. Each
+// server opens a signed WebSocket stream to every peer it is configured
+// with and sends it the messages and members of the rooms shared with it.
+// A room is shared with a peer when its settings list the peer, and it is
+// the peer's room of the same slug. The users of a peer appear in the room
+// as username@peer, posting through a client of their own, so the room's
+// bans, mutes and other rules apply to them as to local users.
+//
+// Servers only send events about their own users and only accept events
+// from the server they started on, and messages posted for another
+// server's users are never sent on, so no event can loop between servers.
+package federation
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/presence"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/store"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Event types
+const (
+	EventMessage = "message" // A message posted by a user of the origin
+	EventMembers = "members" // Every user of the origin in a room, replacing those sent before
+)
+
+// Event is something that happened in a shared room on the server it
+// started on, its origin
+type Event struct {
+	ID        string    `json:"id"`
+	Origin    string    `json:"origin"`
+	Type      string    `json:"type"`
+	Room      string    `json:"room"` // Slug of the shared room
+	Username  string    `json:"username,omitempty"`
+	Content   string    `json:"content,omitempty"`
+	Members   []Member  `json:"members,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Member is a user in a shared room with their presence status
+type Member struct {
+	Username string          `json:"username"`
+	Status   presence.Status `json:"status"`
+}
+
+// queueSize is the most events waiting to be sent to a peer
+const queueSize = 1024
+
+// changeDelay is the shortest time between member lists sent because
+// presence changed, so a burst of joins is sent once
+const changeDelay = 250 * time.Millisecond
+
+// syncInterval is how often every member list is sent even when presence
+// didn't change, reaching peers that only started sharing a room since
+const syncInterval = 15 * time.Second
+
+// Federation shares rooms with the configured peers
+type Federation struct {
+	hub  *hub.Hub
+	name string
+	key  ed25519.PrivateKey
+
+	// Servers rooms can be shared with, by name; fixed once started
+	peers map[string]*peer
+
+	// Signalled when presence changes
+	changed chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// peer is a federated server and this server's state for it
+type peer struct {
+	config.FederationPeer
+
+	// Events waiting to be sent over the outbound stream
+	queue chan *Event
+
+	// Changes to the peer's stand-ins, run one at a time on the goroutine
+	// handling their frames
+	work chan func()
+
+	// Guards the fields below
+	mutex sync.Mutex
+
+	// Stream the peer sends its events over
+	inbound *websocket.Conn
+
+	// Members last sent for each shared room, by slug
+	sent map[string][]Member
+
+	// Clients standing in for the peer's users, by room ID and username
+	standIns map[string]map[string]*standIn
+
+	// Users of the peer turned away from or removed from a room, by room ID
+	// and username, who aren't let back in until they leave it on their server
+	refused map[string]map[string]bool
+}
+
+// Start shares rooms with the peers in cfg. It must be called before the
+// hub runs, since it observes the hub's message history.
+func Start(h *hub.Hub, cfg config.FederationConfig) *Federation {
+	f := &Federation{
+		hub:     h,
+		name:    cfg.Name,
+		key:     cfg.Key,
+		peers:   make(map[string]*peer),
+		changed: make(chan struct{}, 1),
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	for _, pc := range cfg.Peers {
+		f.peers[pc.Name] = &peer{
+			FederationPeer: pc,
+			queue:          make(chan *Event, queueSize),
+			work:           make(chan func()),
+			sent:           make(map[string][]Member),
+			standIns:       make(map[string]map[string]*standIn),
+			refused:        make(map[string]map[string]bool),
+		}
+	}
+
+	h.History.Observe(f.observe)
+	h.PresenceChanged = f.presenceChanged
+
+	log.Printf("Federating as %s with public key %s", f.name, base64.StdEncoding.EncodeToString(f.key.Public().(ed25519.PublicKey)))
+	for _, p := range f.peers {
+		go f.run(p)
+		go f.dial(p)
+	}
+	go f.sync()
+	return f
+}
+
+// Close stops sending to the peers and closes their streams. Their
+// stand-ins are disconnected when the hub stops.
+func (f *Federation) Close() {
+	f.cancel()
+	for _, p := range f.peers {
+		p.mutex.Lock()
+		if p.inbound != nil {
+			p.inbound.Close()
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// observe queues messages posted by local users in shared rooms for the
+// peers they are shared with. End-to-end encrypted and GIF messages aren't
+// shared.
+func (f *Federation) observe(event *store.MessageEvent, _ *history.Message) {
+	if event.Type != history.EventMessage || event.Encryption != nil || event.GIF != nil {
+		return
+	}
+	r, slug, shared := f.sharedRoom(event.RoomID)
+	if !shared || f.standIn(r.ID, event.Username) {
+		return
+	}
+
+	for _, p := range f.peers {
+		if r.FederatedWith(p.Name) {
+			f.send(p, &Event{
+				ID:        event.MessageID,
+				Origin:    f.name,
+				Type:      EventMessage,
+				Room:      slug,
+				Username:  event.Username,
+				Content:   event.Content,
+				Timestamp: event.Timestamp,
+			})
+		}
+	}
+}
+
+// send queues an event for a peer, dropping it when the queue is full so a
+// slow or unreachable peer can't stall the chat
+func (f *Federation) send(p *peer, event *Event) {
+	select {
+	case p.queue <- event:
+	default:
+		metrics.FederationDropped.Add(1)
+	}
+}
+
+// presenceChanged asks for the member lists to be checked soon
+func (f *Federation) presenceChanged() {
+	select {
+	case f.changed <- struct{}{}:
+	default:
+	}
+}
+
+// sync sends the peers the member lists of their shared rooms that
+// changed, and has the peers' stand-ins leave rooms no longer shared with
+// them, until federation stops
+func (f *Federation) sync() {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.changed:
+			// Let a burst of changes settle
+			select {
+			case <-time.After(changeDelay):
+			case <-f.ctx.Done():
+				return
+			}
+			f.sendMembers(false)
+
+		case <-ticker.C:
+			f.sendMembers(true)
+			for _, p := range f.peers {
+				f.do(p, func() { f.prune(p) })
+			}
+
+		case <-f.ctx.Done():
+			return
+		}
+	}
+}
+
+// sendMembers sends each peer the local members of every room shared with
+// it whose members changed since they were last sent, or of all of them
+// when full, and an empty list for rooms no longer shared
+func (f *Federation) sendMembers(full bool) {
+	for _, p := range f.peers {
+		shared := make(map[string][]Member)
+		for _, r := range f.hub.RoomManager.GetRooms() {
+			if _, slug, ok := f.sharedRoom(r.ID); ok && r.FederatedWith(p.Name) {
+				shared[slug] = f.localMembers(r)
+			}
+		}
+
+		p.mutex.Lock()
+		var events []*Event
+		for slug, members := range shared {
+			if sent, ok := p.sent[slug]; full || !ok || !slices.Equal(sent, members) {
+				p.sent[slug] = members
+				events = append(events, f.membersEvent(slug, members))
+			}
+		}
+		for slug := range p.sent {
+			if _, ok := shared[slug]; !ok {
+				delete(p.sent, slug)
+				events = append(events, f.membersEvent(slug, nil))
+			}
+		}
+		p.mutex.Unlock()
+
+		for _, event := range events {
+			f.send(p, event)
+		}
+	}
+}
+
+// membersEvent returns the event listing the local members of a room
+func (f *Federation) membersEvent(slug string, members []Member) *Event {
+	return &Event{
+		ID:        newID(),
+		Origin:    f.name,
+		Type:      EventMembers,
+		Room:      slug,
+		Members:   members,
+		Timestamp: time.Now(),
+	}
+}
+
+// localMembers returns the users of this server in a room, sorted, leaving
+// out those standing in for other servers' users
+func (f *Federation) localMembers(r *room.Room) []Member {
+	var members []Member
+	seen := make(map[string]bool)
+	for _, username := range r.GetClients() {
+		if seen[username] || f.standIn(r.ID, username) {
+			continue
+		}
+		seen[username] = true
+		members = append(members, Member{Username: username, Status: f.hub.Presence.Get(username)})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Username < members[j].Username })
+	return members
+}
+
+// sharedRoom returns a room and its slug when it can be shared: it is one
+// of the server's own rooms, has a slug and isn't end-to-end encrypted
+func (f *Federation) sharedRoom(roomID string) (*room.Room, string, bool) {
+	r, exists := f.hub.RoomManager.GetRoom(roomID)
+	if !exists || r.Workspace != "" || r.IsEncrypted() {
+		return nil, "", false
+	}
+	slug := r.GetSlug()
+	return r, slug, slug != ""
+}
+
+// findRoom returns the shared room with a slug, when it is shared with a peer
+func (f *Federation) findRoom(p *peer, slug string) (*room.Room, bool) {
+	r, exists := f.hub.RoomManager.FindSlug("", slug)
+	if !exists {
+		return nil, false
+	}
+	if _, _, ok := f.sharedRoom(r.ID); !ok || !r.FederatedWith(p.Name) {
+		return nil, false
+	}
+	return r, true
+}
+
+// standIn reports whether a user in a room stands in for another server's user
+func (f *Federation) standIn(roomID, username string) bool {
+	for _, p := range f.peers {
+		p.mutex.Lock()
+		for _, s := range p.standIns[roomID] {
+			if s.client.Name() == username {
+				p.mutex.Unlock()
+				return true
+			}
+		}
+		p.mutex.Unlock()
+	}
+	return false
+}
+
+// newID generates a random event ID
+func newID() string {
+	return replay.ID("federation", func() string {
+		b := make([]byte, 8)
+		rand.Read(b)
+		return "fed_" + hex.EncodeToString(b)
+	})
+}
@@ -0,0 +1,67 @@
+package federation
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Headers of signed requests
+const (
+	headerOrigin      = "Federation-Origin"
+	headerDestination = "Federation-Destination"
+	headerDate        = "Federation-Date"
+	headerSignature   = "Federation-Signature"
+)
+
+// maxClockSkew is how far a signed request's date may be from this
+// server's clock, which bounds how long a captured request can be replayed
+const maxClockSkew = 5 * time.Minute
+
+// ErrSignature is returned for requests that aren't signed by a peer
+var ErrSignature = errors.New("request not signed by a federated server")
+
+// signedString is what a request's signature covers: its method, the path of
+// the federation endpoint, who sent it to whom and when, and its body
+func signedString(method, path, origin, destination, date string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	return []byte(strings.Join([]string{
+		method, path, origin, destination, date,
+		base64.StdEncoding.EncodeToString(digest[:]),
+	}, "\n"))
+}
+
+// sign adds the headers signing a request to a peer
+func (f *Federation) sign(header http.Header, method, path string, to *peer, body []byte) {
+	date := time.Now().UTC().Format(http.TimeFormat)
+	signature := ed25519.Sign(f.key, signedString(method, path, f.name, to.Name, date, body))
+
+	header.Set(headerOrigin, f.name)
+	header.Set(headerDestination, to.Name)
+	header.Set(headerDate, date)
+	header.Set(headerSignature, base64.StdEncoding.EncodeToString(signature))
+}
+
+// verify returns the peer that signed a request to this server
+func (f *Federation) verify(r *http.Request, body []byte) (*peer, error) {
+	p, known := f.peers[r.Header.Get(headerOrigin)]
+	if !known || r.Header.Get(headerDestination) != f.name {
+		return nil, ErrSignature
+	}
+
+	date := r.Header.Get(headerDate)
+	sent, err := http.ParseTime(date)
+	if err != nil || time.Since(sent).Abs() > maxClockSkew {
+		return nil, ErrSignature
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(headerSignature))
+	if err != nil || !ed25519.Verify(p.PublicKey, signedString(r.Method, r.URL.Path, p.Name, f.name, date, body), signature) {
+		return nil, ErrSignature
+	}
+	return p, nil
+}
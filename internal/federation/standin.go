@@ -0,0 +1,318 @@
+package federation
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"realtime-chat/hooks"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/presence"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/websocket"
+	"time"
+	"unicode/utf8"
+)
+
+// standIn is the client a peer's user chats through in a shared room
+type standIn struct {
+	client   *hub.Client
+	username string // Username on the peer
+	roomID   string
+
+	// Status last set for the user; only used on the peer's work goroutine
+	status presence.Status
+}
+
+// run runs the changes to a peer's stand-ins until federation stops. The
+// stand-ins' frames are handled here, one at a time, as a connection's
+// frames are on its read goroutine.
+func (f *Federation) run(p *peer) {
+	for {
+		select {
+		case fn := <-p.work:
+			fn()
+		case <-f.ctx.Done():
+			return
+		}
+	}
+}
+
+// do runs fn on a peer's work goroutine, or drops it when federation stops
+func (f *Federation) do(p *peer, fn func()) {
+	select {
+	case p.work <- fn:
+	case <-f.ctx.Done():
+	}
+}
+
+// apply acts on an event from a peer in the room it names
+func (f *Federation) apply(p *peer, event *Event) {
+	r, shared := f.findRoom(p, event.Room)
+	if !shared {
+		// The room may have stopped being shared since the event was sent
+		if r, exists := f.hub.RoomManager.FindSlug("", event.Room); exists {
+			f.leaveRoom(p, r.ID)
+		}
+		return
+	}
+
+	switch event.Type {
+	case EventMembers:
+		f.reconcile(p, r, event.Members)
+	case EventMessage:
+		f.post(p, r, event)
+	}
+}
+
+// reconcile brings the stand-ins of a peer in a room in line with the
+// peer's members there: new members join, members gone leave, and changed
+// statuses are set
+func (f *Federation) reconcile(p *peer, r *room.Room, members []Member) {
+	present := make(map[string]presence.Status)
+	for _, member := range members {
+		if validStandIn(p, member.Username) {
+			present[member.Username] = member.Status
+		}
+	}
+
+	p.mutex.Lock()
+	current := maps.Clone(p.standIns[r.ID])
+	refused := p.refused[r.ID]
+	for username := range refused {
+		// Users turned away may come back once they have left
+		if _, ok := present[username]; !ok {
+			delete(refused, username)
+		}
+	}
+	p.mutex.Unlock()
+
+	for username, s := range current {
+		if _, ok := present[username]; !ok {
+			f.leave(p, s)
+		}
+	}
+	for username, status := range present {
+		s, ok := current[username]
+		if !ok {
+			if s = f.join(p, r, username); s == nil {
+				continue
+			}
+		}
+		if s.status != status {
+			s.status = status
+			frame, _ := json.Marshal(websocket.StatusAction{Type: "set_status", State: status.State, Text: status.Text, Emoji: status.Emoji})
+			websocket.HandleFrame(s.client, frame)
+		}
+	}
+}
+
+// post posts a message from a peer's user to a room, through the user's
+// stand-in. Messages resent after a stream fails aren't posted twice.
+func (f *Federation) post(p *peer, r *room.Room, event *Event) {
+	if event.Content == "" || !validStandIn(p, event.Username) {
+		return
+	}
+
+	p.mutex.Lock()
+	s := p.standIns[r.ID][event.Username]
+	refused := p.refused[r.ID][event.Username]
+	p.mutex.Unlock()
+	if refused {
+		return
+	}
+	if s == nil {
+		if s = f.join(p, r, event.Username); s == nil {
+			return
+		}
+	}
+
+	frame, _ := json.Marshal(websocket.Message{Type: "message", Content: event.Content, ClientMessageID: event.ID})
+	if len(frame) > f.hub.Connection().MaxFrameSize {
+		return
+	}
+	websocket.HandleFrame(s.client, frame)
+}
+
+// join connects a stand-in for a peer's user and joins it to a room,
+// returning nil when the user is turned away, such as by a ban
+func (f *Federation) join(p *peer, r *room.Room, username string) *standIn {
+	client := websocket.NewClient(f.hub, username+"@"+p.Name)
+	if !f.hub.Admit(client) {
+		return nil
+	}
+
+	// Operator hooks may rename or turn away the user
+	connect := &hooks.Connect{ClientID: client.ID, Username: client.Username, RemoteAddr: p.Name, Transport: hooks.TransportFederation}
+	if err := f.hub.Hooks.Connect(connect); err != nil {
+		f.hub.Release(client)
+		log.Printf("Federated user %s refused: %v", client.Username, err)
+		f.refuse(p, r.ID, username)
+		return nil
+	}
+
+	// The user's stand-ins in other rooms share its name, as an account's
+	// connections do; no other client may use it
+	p.mutex.Lock()
+	name := ""
+	for _, standIns := range p.standIns {
+		if other, ok := standIns[username]; ok {
+			name = other.client.Name()
+			break
+		}
+	}
+	p.mutex.Unlock()
+	if name == "" {
+		name = f.hub.TakeUsername(client, connect.Username)
+	}
+	client.SetUsername(name)
+	client.Transport, client.RemoteAddr, client.ConnectedAt = connect.Transport, connect.RemoteAddr, time.Now()
+
+	// The stand-in is known before it joins, so its arrival isn't sent back
+	s := &standIn{client: client, username: username, roomID: r.ID}
+	p.mutex.Lock()
+	if p.standIns[r.ID] == nil {
+		p.standIns[r.ID] = make(map[string]*standIn)
+	}
+	p.standIns[r.ID][username] = s
+	p.mutex.Unlock()
+
+	if !websocket.RegisterIn(client, r.ID) {
+		f.forget(p, s)
+		return nil
+	}
+	if client.RoomID != r.ID {
+		// Banned, or the room is locked, full or asks to knock
+		f.refuse(p, r.ID, username)
+		websocket.Disconnect(client)
+		return nil
+	}
+	go f.drain(p, s)
+	return s
+}
+
+// validStandIn reports whether a peer's user can have a stand-in, named
+// username@peer
+func validStandIn(p *peer, username string) bool {
+	return hub.ValidUsername(username) && utf8.RuneCountInString(username+"@"+p.Name) <= hub.MaxUsernameLength
+}
+
+// drain reads the frames sent to a stand-in, which the peer's users see
+// in their own copy of the room, until the hub closes it. A stand-in kicked
+// or banned from its room is turned away until its user leaves the room on
+// the peer.
+func (f *Federation) drain(p *peer, s *standIn) {
+	for {
+		select {
+		case frame := <-s.client.Priority:
+			var notice struct {
+				Type   string `json:"type"`
+				RoomID string `json:"roomId"`
+			}
+			json.Unmarshal(frame, &notice)
+			if (notice.Type == "room_kicked" || notice.Type == "room_banned") && notice.RoomID == s.roomID {
+				go f.do(p, func() {
+					f.refuse(p, s.roomID, s.username)
+					websocket.Disconnect(s.client)
+				})
+			}
+
+		case _, ok := <-s.client.Send:
+			if !ok {
+				go f.do(p, func() { f.forget(p, s) })
+				return
+			}
+		}
+	}
+}
+
+// leave disconnects a stand-in
+func (f *Federation) leave(p *peer, s *standIn) {
+	f.forget(p, s)
+	websocket.Disconnect(s.client)
+}
+
+// leaveRoom disconnects every stand-in of a peer in a room
+func (f *Federation) leaveRoom(p *peer, roomID string) {
+	p.mutex.Lock()
+	standIns := p.standIns[roomID]
+	delete(p.standIns, roomID)
+	delete(p.refused, roomID)
+	p.mutex.Unlock()
+
+	for _, s := range standIns {
+		websocket.Disconnect(s.client)
+	}
+}
+
+// leaveAll disconnects every stand-in of a peer
+func (f *Federation) leaveAll(p *peer) {
+	p.mutex.Lock()
+	rooms := make([]string, 0, len(p.standIns))
+	for roomID := range p.standIns {
+		rooms = append(rooms, roomID)
+	}
+	p.mutex.Unlock()
+
+	for _, roomID := range rooms {
+		f.leaveRoom(p, roomID)
+	}
+}
+
+// prune disconnects a peer's stand-ins in rooms that are gone or no longer
+// shared with it, and those no longer in their room
+func (f *Federation) prune(p *peer) {
+	p.mutex.Lock()
+	var stale []string
+	var moved []*standIn
+	for roomID, standIns := range p.standIns {
+		r, exists := f.hub.RoomManager.GetRoom(roomID)
+		if !exists {
+			stale = append(stale, roomID)
+			continue
+		}
+		if _, shared := f.findRoom(p, r.GetSlug()); !shared {
+			stale = append(stale, roomID)
+			continue
+		}
+		for _, s := range standIns {
+			if s.client.RoomID != roomID {
+				moved = append(moved, s)
+			}
+		}
+	}
+	p.mutex.Unlock()
+
+	for _, roomID := range stale {
+		f.leaveRoom(p, roomID)
+	}
+	for _, s := range moved {
+		f.leave(p, s)
+	}
+}
+
+// refuse turns a peer's user away from a room until they leave it on the peer
+func (f *Federation) refuse(p *peer, roomID, username string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if standIns := p.standIns[roomID]; standIns != nil {
+		delete(standIns, username)
+	}
+	if p.refused[roomID] == nil {
+		p.refused[roomID] = make(map[string]bool)
+	}
+	p.refused[roomID][username] = true
+}
+
+// forget stops tracking a stand-in
+func (f *Federation) forget(p *peer, s *standIn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if standIns := p.standIns[s.roomID]; standIns[s.username] == s {
+		delete(standIns, s.username)
+		if len(standIns) == 0 {
+			delete(p.standIns, s.roomID)
+		}
+	}
+}
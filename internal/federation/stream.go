@@ -0,0 +1,225 @@
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/workspace"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Federation endpoints, outside the paths of workspaces
+const (
+	streamPath = "/federation/v1/stream"
+	roomsPath  = "/federation/v1/rooms"
+)
+
+// Stream timing
+const (
+	writeTimeout = 10 * time.Second
+	pingInterval = 30 * time.Second
+	pongWait     = 2 * pingInterval
+	minBackoff   = time.Second
+	maxBackoff   = 30 * time.Second
+)
+
+// maxEventSize is the largest event frame read from a peer
+const maxEventSize = 1 << 20
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// Register adds the endpoints peers connect to to mux
+func (f *Federation) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+streamPath, f.serveStream)
+	mux.HandleFunc("GET "+roomsPath, f.serveRooms)
+}
+
+// serveRooms lists the slugs of the rooms shared with the peer asking
+func (f *Federation) serveRooms(w http.ResponseWriter, r *http.Request) {
+	if workspace.FromContext(r.Context()) != "" {
+		http.NotFound(w, r)
+		return
+	}
+	p, err := f.verify(r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	slugs := []string{}
+	for _, room := range f.hub.RoomManager.GetRooms() {
+		if _, slug, ok := f.sharedRoom(room.ID); ok && room.FederatedWith(p.Name) {
+			slugs = append(slugs, slug)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"server": f.name,
+		"rooms":  slugs,
+	})
+}
+
+// serveStream accepts the stream a peer sends its events over, replacing
+// any stream it opened before
+func (f *Federation) serveStream(w http.ResponseWriter, r *http.Request) {
+	if workspace.FromContext(r.Context()) != "" {
+		http.NotFound(w, r)
+		return
+	}
+	p, err := f.verify(r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Federation stream upgrade error from %s: %v", p.Name, err)
+		return
+	}
+
+	p.mutex.Lock()
+	previous := p.inbound
+	p.inbound = conn
+	p.mutex.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	log.Printf("Federation stream from %s connected", p.Name)
+
+	conn.SetReadLimit(maxEventSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeTimeout))
+	})
+	for {
+		event := &Event{}
+		if err := conn.ReadJSON(event); err != nil {
+			break
+		}
+		// A peer only speaks for its own users
+		if event.Origin != p.Name {
+			continue
+		}
+		metrics.FederationReceived.Add(1)
+		f.do(p, func() { f.apply(p, event) })
+	}
+	conn.Close()
+
+	// The peer's users are gone until it connects again
+	p.mutex.Lock()
+	current := p.inbound == conn
+	if current {
+		p.inbound = nil
+	}
+	p.mutex.Unlock()
+	if current {
+		log.Printf("Federation stream from %s disconnected", p.Name)
+		f.do(p, func() { f.leaveAll(p) })
+	}
+}
+
+// dial keeps a stream open to a peer, sending it the queued events, until
+// federation stops
+func (f *Federation) dial(p *peer) {
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		if err := f.stream(p); err != nil {
+			log.Printf("Federation stream to %s: %v", p.Name, err)
+		}
+
+		// Streams that stayed up for a while retry quickly
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-f.ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// stream opens a stream to a peer and sends it events until the stream
+// fails or federation stops
+func (f *Federation) stream(p *peer) error {
+	u, err := url.Parse(p.URL + streamPath)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	f.sign(header, http.MethodGet, u.Path, p, nil)
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: writeTimeout,
+	}
+	conn, resp, err := dialer.DialContext(f.ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			return errors.New(resp.Status)
+		}
+		return err
+	}
+	defer conn.Close()
+	log.Printf("Federation stream to %s connected", p.Name)
+
+	// The peer forgot the members sent over any earlier stream
+	p.mutex.Lock()
+	clear(p.sent)
+	p.mutex.Unlock()
+	f.presenceChanged()
+
+	// Nothing but control frames is read, which keeps pongs flowing
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case event := <-p.queue:
+			data, _ := json.Marshal(event)
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return err
+			}
+			metrics.FederationSent.Add(1)
+
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return err
+			}
+
+		case <-closed:
+			return errors.New("closed by peer")
+
+		case <-f.ctx.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(writeTimeout))
+			return nil
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package game
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"realtime-chat/internal/bot"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DiceBot is the user /roll replies are posted as
+const DiceBot = "dice"
+
+// Bounds of a roll
+const (
+	MaxDice     = 100
+	MaxSides    = 1000
+	MaxModifier = 1000
+)
+
+// dicePattern matches dice notation such as "d20", "2d6" or "3d8-2"
+var dicePattern = regexp.MustCompile(`^(\d{0,3})d(\d{1,4})([+-]\d{1,4})?$`)
+
+// ErrDice is returned for dice notation /roll can't roll
+var ErrDice = fmt.Errorf("dice must look like 2d6, d20 or 3d8+2, with up to %d dice of up to %d sides", MaxDice, MaxSides)
+
+// Roll is the result of rolling dice
+type Roll struct {
+	Dice     int
+	Sides    int
+	Modifier int
+	Results  []int // Each die, in the order rolled
+	Total    int
+}
+
+// RollDice rolls dice given in dice notation: the number of dice (one when
+// left out), d, the number of sides and an optional modifier added to the total
+func RollDice(notation string) (*Roll, error) {
+	match := dicePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(notation)))
+	if match == nil {
+		return nil, ErrDice
+	}
+	roll := &Roll{Dice: 1}
+	if match[1] != "" {
+		roll.Dice, _ = strconv.Atoi(match[1])
+	}
+	roll.Sides, _ = strconv.Atoi(match[2])
+	if match[3] != "" {
+		roll.Modifier, _ = strconv.Atoi(match[3])
+	}
+	if roll.Dice < 1 || roll.Dice > MaxDice || roll.Sides < 2 || roll.Sides > MaxSides || max(roll.Modifier, -roll.Modifier) > MaxModifier {
+		return nil, ErrDice
+	}
+
+	roll.Results = make([]int, roll.Dice)
+	roll.Total = roll.Modifier
+	for i := range roll.Results {
+		roll.Results[i] = rand.IntN(roll.Sides) + 1
+		roll.Total += roll.Results[i]
+	}
+	return roll, nil
+}
+
+// Notation returns the roll's dice in dice notation
+func (r *Roll) Notation() string {
+	notation := fmt.Sprintf("%dd%d", r.Dice, r.Sides)
+	if r.Modifier != 0 {
+		notation += fmt.Sprintf("%+d", r.Modifier)
+	}
+	return notation
+}
+
+// String describes the roll, such as "2d6+1: 4 + 3 + 1 = 8"
+func (r *Roll) String() string {
+	if r.Dice == 1 && r.Modifier == 0 {
+		return fmt.Sprintf("%s: %d", r.Notation(), r.Total)
+	}
+	parts := make([]string, len(r.Results))
+	for i, result := range r.Results {
+		parts[i] = strconv.Itoa(result)
+	}
+	sum := strings.Join(parts, " + ")
+	if r.Modifier > 0 {
+		sum += fmt.Sprintf(" + %d", r.Modifier)
+	} else if r.Modifier < 0 {
+		sum += fmt.Sprintf(" - %d", -r.Modifier)
+	}
+	return fmt.Sprintf("%s: %s = %d", r.Notation(), sum, r.Total)
+}
+
+// rollCommand is /roll [dice], which rolls a six-sided die by default
+func rollCommand() *bot.Command {
+	return &bot.Command{
+		Name:        "roll",
+		Bot:         DiceBot,
+		Description: "Roll dice, such as /roll 2d6 or /roll d20+3",
+		Args: []bot.Arg{
+			{Name: "dice", Type: bot.ArgString, Description: "Dice to roll in dice notation; 1d6 when left out"},
+		},
+		Handler: func(inv *bot.Invocation) (string, error) {
+			notation := inv.String("dice")
+			if notation == "" {
+				notation = "1d6"
+			}
+			roll, err := RollDice(notation)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("🎲 @%s rolled %s", inv.Username, roll), nil
+		},
+	}
+}
@@ -0,0 +1,38 @@
+// Package game provides chat games played through bot commands, showing off
+// the command framework: /roll rolls dice, and /trivia runs timed rounds of
+// trivia questions in a room and keeps every player's score.
+package game
+
+import (
+	"context"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/store"
+)
+
+// Start registers the game commands with the hub and loads the trivia
+// scores, unless games are turned off. It must be called before the hub
+// runs, since trivia watches room history for answers. Games in progress
+// stop when ctx is cancelled.
+func Start(ctx context.Context, h *hub.Hub, st store.Store, cfg config.GameConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	trivia, err := newTrivia(h, st, cfg)
+	if err != nil {
+		return err
+	}
+	if err := h.Commands.Register(rollCommand()); err != nil {
+		return err
+	}
+	if err := h.Commands.Register(trivia.command()); err != nil {
+		return err
+	}
+	h.History.Observe(trivia.observe)
+
+	go func() {
+		<-ctx.Done()
+		trivia.stopAll()
+	}()
+	return nil
+}
@@ -0,0 +1,100 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Question is a trivia question and the answers accepted for it
+type Question struct {
+	Question string   `json:"question"`
+	Answers  []string `json:"answers"` // The first is the one revealed
+	Category string   `json:"category,omitempty"`
+}
+
+// LoadQuestions reads trivia questions from a JSON file holding a list of
+// questions
+func LoadQuestions(path string) ([]Question, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var questions []Question
+	if err := json.Unmarshal(data, &questions); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	for i, q := range questions {
+		if strings.TrimSpace(q.Question) == "" || len(q.Answers) == 0 {
+			return nil, fmt.Errorf("%s: question %d needs a question and at least one answer", path, i+1)
+		}
+	}
+	if len(questions) == 0 {
+		return nil, fmt.Errorf("%s holds no questions", path)
+	}
+	return questions, nil
+}
+
+// normalizeAnswer reduces an answer to lower-case words without punctuation
+// or a leading article, so "The Pacific!" matches "pacific"
+func normalizeAnswer(answer string) string {
+	words := strings.FieldsFunc(strings.ToLower(answer), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > 1 && (words[0] == "the" || words[0] == "a" || words[0] == "an") {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// correct reports whether a message answers a question
+func (q *Question) correct(content string) bool {
+	given := normalizeAnswer(content)
+	if given == "" {
+		return false
+	}
+	for _, answer := range q.Answers {
+		if normalizeAnswer(answer) == given {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinQuestions are asked when no question file is configured
+var builtinQuestions = []Question{
+	{Question: "What is the largest ocean on Earth?", Answers: []string{"Pacific", "Pacific Ocean"}, Category: "Geography"},
+	{Question: "What is the capital of Australia?", Answers: []string{"Canberra"}, Category: "Geography"},
+	{Question: "Which river flows through Cairo?", Answers: []string{"Nile", "The Nile"}, Category: "Geography"},
+	{Question: "What is the smallest country in the world by area?", Answers: []string{"Vatican City", "Vatican"}, Category: "Geography"},
+	{Question: "On which continent is the Atacama Desert?", Answers: []string{"South America"}, Category: "Geography"},
+	{Question: "What is the chemical symbol for gold?", Answers: []string{"Au"}, Category: "Science"},
+	{Question: "How many bones are in the adult human body?", Answers: []string{"206"}, Category: "Science"},
+	{Question: "Which planet is known as the Red Planet?", Answers: []string{"Mars"}, Category: "Science"},
+	{Question: "What gas do plants absorb from the air for photosynthesis?", Answers: []string{"Carbon dioxide", "CO2"}, Category: "Science"},
+	{Question: "What is the hardest natural substance?", Answers: []string{"Diamond"}, Category: "Science"},
+	{Question: "How many minutes does light from the Sun take to reach Earth, rounded?", Answers: []string{"8", "Eight"}, Category: "Science"},
+	{Question: "What is H2O more commonly called?", Answers: []string{"Water"}, Category: "Science"},
+	{Question: "Who painted the Mona Lisa?", Answers: []string{"Leonardo da Vinci", "da Vinci", "Leonardo"}, Category: "Art"},
+	{Question: "Who wrote Romeo and Juliet?", Answers: []string{"William Shakespeare", "Shakespeare"}, Category: "Literature"},
+	{Question: "Who wrote Nineteen Eighty-Four?", Answers: []string{"George Orwell", "Orwell"}, Category: "Literature"},
+	{Question: "In which year did the first person walk on the Moon?", Answers: []string{"1969"}, Category: "History"},
+	{Question: "Which ancient wonder stood in the harbour of Rhodes?", Answers: []string{"Colossus of Rhodes", "Colossus"}, Category: "History"},
+	{Question: "Who was the first President of the United States?", Answers: []string{"George Washington", "Washington"}, Category: "History"},
+	{Question: "In which year did the Berlin Wall fall?", Answers: []string{"1989"}, Category: "History"},
+	{Question: "How many players does a football (soccer) team have on the pitch?", Answers: []string{"11", "Eleven"}, Category: "Sport"},
+	{Question: "In which sport is the term \"love\" used for a score of zero?", Answers: []string{"Tennis"}, Category: "Sport"},
+	{Question: "How many rings are on the Olympic flag?", Answers: []string{"5", "Five"}, Category: "Sport"},
+	{Question: "What is the largest planet in the Solar System?", Answers: []string{"Jupiter"}, Category: "Science"},
+	{Question: "What is 12 multiplied by 12?", Answers: []string{"144"}, Category: "Maths"},
+	{Question: "What is the square root of 81?", Answers: []string{"9", "Nine"}, Category: "Maths"},
+	{Question: "How many sides does a hexagon have?", Answers: []string{"6", "Six"}, Category: "Maths"},
+	{Question: "Which company created the Go programming language?", Answers: []string{"Google"}, Category: "Computing"},
+	{Question: "What does the \"HTTP\" in web addresses stand for?", Answers: []string{"HyperText Transfer Protocol", "Hypertext Transfer Protocol"}, Category: "Computing"},
+	{Question: "What is the keyword that starts a goroutine in Go?", Answers: []string{"go"}, Category: "Computing"},
+	{Question: "How many bits are in a byte?", Answers: []string{"8", "Eight"}, Category: "Computing"},
+	{Question: "What is the main language spoken in Brazil?", Answers: []string{"Portuguese"}, Category: "Geography"},
+	{Question: "Which instrument has 88 keys?", Answers: []string{"Piano"}, Category: "Music"},
+}
@@ -0,0 +1,402 @@
+package game
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"realtime-chat/internal/bot"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/websocket"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TriviaBot is the user trivia questions and results are posted as
+const TriviaBot = "trivia"
+
+// GameTrivia names trivia scores in the store
+const GameTrivia = "trivia"
+
+// MaxTriviaRounds is the most questions a trivia game can ask
+const MaxTriviaRounds = 50
+
+// leaderboardSize is how many players /trivia scores lists
+const leaderboardSize = 10
+
+// Pauses between the steps of a trivia game
+const (
+	startDelay  = 3 * time.Second        // After the game is announced
+	nextDelay   = 5 * time.Second        // After a question is answered or times out
+	revealDelay = 500 * time.Millisecond // So the winning answer reaches the room before the bot's reply
+)
+
+// Trivia runs trivia games, one at a time per room. The bot asks a question,
+// the first player to post a correct answer in the room scores a point, and
+// the answer is revealed when time runs out. Each player's points, games
+// played and games won are kept across games.
+type Trivia struct {
+	hub        *hub.Hub
+	store      store.Store // may be nil to keep scores in memory only
+	questions  []Question
+	answerTime time.Duration
+	rounds     int
+
+	mutex  sync.Mutex
+	games  map[string]*triviaGame            // By room ID
+	scores map[string]*store.GameScoreRecord // By username
+}
+
+// triviaGame is a trivia game running in a room
+type triviaGame struct {
+	roomID    string
+	startedBy string
+	questions []int // Indexes of the questions to ask, in order
+	asked     int   // Questions asked so far; the current one is questions[asked-1]
+	open      bool  // Whether the current question still takes answers
+	timer     *time.Timer
+	points    map[string]int // This game's points by username
+}
+
+// newTrivia loads the questions and the players' scores
+func newTrivia(h *hub.Hub, st store.Store, cfg config.GameConfig) (*Trivia, error) {
+	t := &Trivia{
+		hub:        h,
+		store:      st,
+		questions:  builtinQuestions,
+		answerTime: cfg.TriviaAnswerTime,
+		rounds:     cfg.TriviaRounds,
+		games:      make(map[string]*triviaGame),
+		scores:     make(map[string]*store.GameScoreRecord),
+	}
+	if cfg.TriviaQuestions != "" {
+		questions, err := LoadQuestions(cfg.TriviaQuestions)
+		if err != nil {
+			return nil, fmt.Errorf("loading trivia questions: %w", err)
+		}
+		t.questions = questions
+	}
+
+	if st != nil {
+		scores, err := st.LoadGameScores()
+		if err != nil {
+			return nil, err
+		}
+		for _, score := range scores {
+			if score.Game == GameTrivia {
+				t.scores[score.Username] = score
+			}
+		}
+	}
+	return t, nil
+}
+
+// command is /trivia <start|stop|scores> [rounds]
+func (t *Trivia) command() *bot.Command {
+	return &bot.Command{
+		Name:        "trivia",
+		Bot:         TriviaBot,
+		Description: "Play trivia: /trivia start [rounds], /trivia stop or /trivia scores",
+		Args: []bot.Arg{
+			{Name: "action", Type: bot.ArgString, Required: true, Description: "start, stop or scores"},
+			{Name: "rounds", Type: bot.ArgInt, Description: "Questions to ask in a new game", Min: bot.IntPtr(1), Max: bot.IntPtr(MaxTriviaRounds)},
+		},
+		Handler: func(inv *bot.Invocation) (string, error) {
+			switch inv.String("action") {
+			case "start":
+				return t.start(inv)
+			case "stop":
+				return t.stop(inv)
+			case "scores":
+				return t.leaderboard(inv.Username), nil
+			}
+			return "", fmt.Errorf("Unknown trivia action %q; use start, stop or scores", inv.String("action"))
+		},
+	}
+}
+
+// start begins a game in the invoking user's room and announces it. The
+// first question follows shortly.
+func (t *Trivia) start(inv *bot.Invocation) (string, error) {
+	if r, exists := t.hub.RoomManager.GetRoom(inv.RoomID); exists && (r.IsEncrypted() || r.IsArchived()) {
+		return "", fmt.Errorf("Trivia can't be played in this room")
+	}
+	rounds, ok := inv.Int("rounds")
+	if !ok {
+		rounds = t.rounds
+	}
+	rounds = min(rounds, len(t.questions))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, running := t.games[inv.RoomID]; running {
+		return "", fmt.Errorf("A trivia game is already running in this room")
+	}
+	g := &triviaGame{
+		roomID:    inv.RoomID,
+		startedBy: inv.Username,
+		questions: rand.Perm(len(t.questions))[:rounds],
+		points:    make(map[string]int),
+	}
+	t.games[g.roomID] = g
+	g.timer = time.AfterFunc(startDelay, func() { t.ask(g) })
+
+	questions := fmt.Sprintf("%d questions", rounds)
+	if rounds == 1 {
+		questions = "1 question"
+	}
+	return fmt.Sprintf("🧠 @%s started trivia: %s, %s each. Post your answers in the chat; the first correct answer scores a point. /trivia stop ends the game.",
+		inv.Username, questions, t.answerTime), nil
+}
+
+// ask posts a game's next question and starts its clock
+func (t *Trivia) ask(g *triviaGame) {
+	t.mutex.Lock()
+	if t.games[g.roomID] != g {
+		t.mutex.Unlock()
+		return
+	}
+	if _, exists := t.hub.RoomManager.GetRoom(g.roomID); !exists {
+		delete(t.games, g.roomID)
+		t.mutex.Unlock()
+		return
+	}
+	q := t.questions[g.questions[g.asked]]
+	g.asked++
+	g.open = true
+	asked := g.asked
+	g.timer = time.AfterFunc(t.answerTime, func() { t.timeout(g, asked) })
+	t.mutex.Unlock()
+
+	text := fmt.Sprintf("❓ Question %d/%d", asked, len(g.questions))
+	if q.Category != "" {
+		text += " (" + q.Category + ")"
+	}
+	t.post(g, text+": "+q.Question)
+}
+
+// timeout closes a question nobody answered and reveals its answer
+func (t *Trivia) timeout(g *triviaGame, asked int) {
+	t.mutex.Lock()
+	if t.games[g.roomID] != g || g.asked != asked || !g.open {
+		t.mutex.Unlock()
+		return
+	}
+	g.open = false
+	answer := t.questions[g.questions[g.asked-1]].Answers[0]
+	t.mutex.Unlock()
+
+	t.post(g, "⏰ Time's up! The answer was: "+answer)
+	t.next(g)
+}
+
+// observe checks every chat message posted in a room with an open question
+// against its answers. The first correct one scores a point.
+func (t *Trivia) observe(event *store.MessageEvent, _ *history.Message) {
+	if event.Type != history.EventMessage || event.Encryption != nil || event.GIF != nil || event.Username == TriviaBot {
+		return
+	}
+
+	t.mutex.Lock()
+	g := t.games[event.RoomID]
+	if g == nil || !g.open || !t.questions[g.questions[g.asked-1]].correct(event.Content) {
+		t.mutex.Unlock()
+		return
+	}
+	g.open = false
+	g.timer.Stop()
+	g.points[event.Username]++
+	points := g.points[event.Username]
+	answer := t.questions[g.questions[g.asked-1]].Answers[0]
+	score := t.scoreLocked(event.Username)
+	score.Points++
+	saved := *score
+	t.mutex.Unlock()
+
+	t.save(&saved)
+	time.AfterFunc(revealDelay, func() {
+		t.mutex.Lock()
+		running := t.games[g.roomID] == g
+		t.mutex.Unlock()
+		if !running {
+			return
+		}
+		t.post(g, fmt.Sprintf("✅ @%s got it: %s! %s this game.", event.Username, answer, pointsText(points)))
+		t.next(g)
+	})
+}
+
+// next schedules a game's next question, or ends the game after its last
+func (t *Trivia) next(g *triviaGame) {
+	t.mutex.Lock()
+	if t.games[g.roomID] != g {
+		t.mutex.Unlock()
+		return
+	}
+	if g.asked < len(g.questions) {
+		g.timer = time.AfterFunc(nextDelay, func() { t.ask(g) })
+		t.mutex.Unlock()
+		return
+	}
+
+	// Every player who scored played the game, and the top scorers won it
+	delete(t.games, g.roomID)
+	standings := g.standings()
+	var changed []store.GameScoreRecord
+	for _, username := range standings {
+		score := t.scoreLocked(username)
+		score.Played++
+		if g.points[username] == g.points[standings[0]] {
+			score.Won++
+		}
+		changed = append(changed, *score)
+	}
+	results := g.results()
+	t.mutex.Unlock()
+
+	for i := range changed {
+		t.save(&changed[i])
+	}
+	t.post(g, "🏁 Trivia over! "+results)
+}
+
+// stop ends the game in the invoking user's room; only the player who
+// started it or a moderator may. Stopped games don't count as played.
+func (t *Trivia) stop(inv *bot.Invocation) (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	g := t.games[inv.RoomID]
+	if g == nil {
+		return "", fmt.Errorf("No trivia game is running in this room")
+	}
+	if inv.Username != g.startedBy && !t.hub.Roles.Can(inv.Username, inv.RoomID, rbac.PermModerate) {
+		return "", fmt.Errorf("Only @%s or a moderator can stop this game", g.startedBy)
+	}
+	g.timer.Stop()
+	delete(t.games, inv.RoomID)
+	return fmt.Sprintf("🛑 @%s stopped trivia. %s", inv.Username, g.results()), nil
+}
+
+// stopAll ends every game without announcing it, as the server shuts down
+func (t *Trivia) stopAll() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for roomID, g := range t.games {
+		g.timer.Stop()
+		delete(t.games, roomID)
+	}
+}
+
+// leaderboard describes the players with the most points overall, and
+// where username stands if they aren't among them
+func (t *Trivia) leaderboard(username string) string {
+	t.mutex.Lock()
+	scores := make([]store.GameScoreRecord, 0, len(t.scores))
+	for _, score := range t.scores {
+		scores = append(scores, *score)
+	}
+	t.mutex.Unlock()
+
+	if len(scores) == 0 {
+		return "🏆 Nobody has scored at trivia yet. /trivia start to play!"
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Points != scores[j].Points {
+			return scores[i].Points > scores[j].Points
+		}
+		if scores[i].Won != scores[j].Won {
+			return scores[i].Won > scores[j].Won
+		}
+		return scores[i].Username < scores[j].Username
+	})
+
+	lines := []string{"🏆 Trivia leaderboard:"}
+	for i, score := range scores {
+		if i < leaderboardSize || score.Username == username {
+			lines = append(lines, fmt.Sprintf("%d. @%s: %s, %d won of %d played",
+				i+1, score.Username, pointsText(score.Points), score.Won, score.Played))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// scoreLocked returns a player's overall score, creating it on their first
+// point; the caller must hold the lock
+func (t *Trivia) scoreLocked(username string) *store.GameScoreRecord {
+	score, ok := t.scores[username]
+	if !ok {
+		score = &store.GameScoreRecord{Game: GameTrivia, Username: username}
+		t.scores[username] = score
+	}
+	score.UpdatedAt = time.Now()
+	return score
+}
+
+// save persists a copy of a player's score
+func (t *Trivia) save(score *store.GameScoreRecord) {
+	if t.store == nil {
+		return
+	}
+	if err := t.store.SaveGameScore(score); err != nil {
+		log.Printf("Error saving trivia score of %s: %v", score.Username, err)
+	}
+}
+
+// post posts a message of a game to its room. A game whose room no longer
+// takes messages ends.
+func (t *Trivia) post(g *triviaGame, content string) {
+	if err := websocket.PostBotMessage(t.hub, g.roomID, TriviaBot, content); err != nil {
+		log.Printf("Error posting trivia to room %s: %v", g.roomID, err)
+		t.mutex.Lock()
+		if t.games[g.roomID] == g {
+			g.timer.Stop()
+			delete(t.games, g.roomID)
+		}
+		t.mutex.Unlock()
+	}
+}
+
+// standings returns the players who scored in a game, the most points
+// first; the caller must hold the lock
+func (g *triviaGame) standings() []string {
+	players := make([]string, 0, len(g.points))
+	for username := range g.points {
+		players = append(players, username)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		if g.points[players[i]] != g.points[players[j]] {
+			return g.points[players[i]] > g.points[players[j]]
+		}
+		return players[i] < players[j]
+	})
+	return players
+}
+
+// results describes a game's final standings; the caller must hold the lock
+func (g *triviaGame) results() string {
+	standings := g.standings()
+	if len(standings) == 0 {
+		return "Nobody scored this time."
+	}
+	parts := make([]string, len(standings))
+	for i, username := range standings {
+		parts[i] = fmt.Sprintf("@%s %s", username, pointsText(g.points[username]))
+	}
+	return "Results: " + strings.Join(parts, ", ")
+}
+
+// pointsText returns "1 point" or "n points"
+func pointsText(n int) string {
+	if n == 1 {
+		return "1 point"
+	}
+	return fmt.Sprintf("%d points", n)
+}
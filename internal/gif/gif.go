@@ -0,0 +1,216 @@
+// Package gif searches a GIF provider, Giphy or Tenor, on behalf of clients
+// so the provider's API key stays on the server. Results are cached, which
+// also spares the provider's rate limit when many users search alike.
+package gif
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/store"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of results of a search
+const (
+	DefaultLimit = 24
+	MaxLimit     = 50
+)
+
+// MaxQuery is the longest search query accepted, in bytes
+const MaxQuery = 100
+
+// maxCached bounds how many searches and GIFs are cached
+const maxCached = 1000
+
+// Errors returned by searches and lookups
+var (
+	ErrDisabled = errors.New("GIF search is not configured")
+	ErrNotFound = errors.New("GIF not found")
+)
+
+// client is shared by every request to the provider
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// provider builds the requests of one GIF provider and reads its responses
+type provider interface {
+	// searchURL returns the URL of a search, or of trending GIFs when query is empty
+	searchURL(query string, limit int) string
+
+	// lookupURL returns the URL fetching one GIF by ID
+	lookupURL(id string) string
+
+	// parse reads the GIFs of a response
+	parse(body io.Reader) ([]*store.GIF, error)
+}
+
+// cached is a cached result and when it stops being used
+type cached[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// Client searches the configured provider and caches its results
+type Client struct {
+	name     string
+	provider provider
+	ttl      time.Duration
+
+	mutex    sync.Mutex
+	searches map[string]cached[[]*store.GIF]
+	gifs     map[string]cached[*store.GIF]
+}
+
+// New returns a client of the provider in cfg, or nil when GIF search is
+// off. A nil client reports every search as ErrDisabled.
+func New(cfg config.GIFConfig) *Client {
+	if cfg.APIKey == "" {
+		return nil
+	}
+	var p provider
+	switch cfg.Provider {
+	case config.GIFTenor:
+		p = &tenor{key: cfg.APIKey, contentFilter: tenorFilters[cfg.Rating]}
+	default:
+		p = &giphy{key: cfg.APIKey, rating: cfg.Rating}
+	}
+	return &Client{
+		name:     cfg.Provider,
+		provider: p,
+		ttl:      cfg.CacheTTL,
+		searches: make(map[string]cached[[]*store.GIF]),
+		gifs:     make(map[string]cached[*store.GIF]),
+	}
+}
+
+// Provider returns the name of the provider searched, or "" when GIF search is off
+func (c *Client) Provider() string {
+	if c == nil {
+		return ""
+	}
+	return c.name
+}
+
+// Search returns up to limit GIFs matching query, or trending GIFs when
+// query is empty. Results are served from the cache while they are fresh.
+func (c *Client) Search(ctx context.Context, query string, limit int) ([]*store.GIF, error) {
+	if c == nil {
+		return nil, ErrDisabled
+	}
+	if limit <= 0 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+	query = strings.ToLower(strings.Join(strings.Fields(query), " "))
+	key := fmt.Sprintf("%d:%s", limit, query)
+
+	c.mutex.Lock()
+	entry, ok := c.searches[key]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	gifs, err := c.fetch(ctx, c.provider.searchURL(query, limit))
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	expires := time.Now().Add(c.ttl)
+	evict(c.searches)
+	c.searches[key] = cached[[]*store.GIF]{gifs, expires}
+	for _, gif := range gifs {
+		evict(c.gifs)
+		c.gifs[gif.ID] = cached[*store.GIF]{gif, expires}
+	}
+	c.mutex.Unlock()
+	return gifs, nil
+}
+
+// GIF returns a GIF by its ID at the provider, so a GIF message can only
+// post the provider's own media rather than any URL a client names
+func (c *Client) GIF(ctx context.Context, id string) (*store.GIF, error) {
+	if c == nil {
+		return nil, ErrDisabled
+	}
+	if id == "" || len(id) > 64 || strings.ContainsAny(id, "/?#&%,") {
+		return nil, ErrNotFound
+	}
+
+	c.mutex.Lock()
+	entry, ok := c.gifs[id]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	gifs, err := c.fetch(ctx, c.provider.lookupURL(id))
+	if err != nil {
+		return nil, err
+	}
+	if len(gifs) == 0 || gifs[0].ID != id {
+		return nil, ErrNotFound
+	}
+
+	c.mutex.Lock()
+	evict(c.gifs)
+	c.gifs[id] = cached[*store.GIF]{gifs[0], time.Now().Add(c.ttl)}
+	c.mutex.Unlock()
+	return gifs[0], nil
+}
+
+// fetch requests a URL of the provider and reads the GIFs it returns
+func (c *Client) fetch(ctx context.Context, url string) ([]*store.GIF, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error includes the URL, and with it the API key
+		return nil, fmt.Errorf("requesting %s failed", c.name)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", c.name, resp.Status)
+	}
+	gifs, err := c.provider.parse(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s response: %w", c.name, err)
+	}
+	for _, gif := range gifs {
+		gif.Provider = c.name
+	}
+	return gifs, nil
+}
+
+// evict makes room for one more entry in a full cache, dropping expired
+// entries or, when none have expired, an arbitrary one. The caller must
+// hold the lock.
+func evict[T any](cache map[string]cached[T]) {
+	if len(cache) < maxCached {
+		return
+	}
+	now := time.Now()
+	for key, entry := range cache {
+		if now.After(entry.expires) {
+			delete(cache, key)
+		}
+	}
+	for key := range cache {
+		if len(cache) < maxCached {
+			break
+		}
+		delete(cache, key)
+	}
+}
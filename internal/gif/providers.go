@@ -0,0 +1,169 @@
+package gif
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/url"
+	"realtime-chat/internal/store"
+	"strconv"
+)
+
+// giphy searches Giphy through its v1 API
+type giphy struct {
+	key    string
+	rating string
+}
+
+// giphyImage is one rendition of a Giphy GIF; its sizes are strings
+type giphyImage struct {
+	URL    string `json:"url"`
+	Width  string `json:"width"`
+	Height string `json:"height"`
+}
+
+// giphyGIF is a GIF as Giphy returns it
+type giphyGIF struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Images struct {
+		Original   giphyImage `json:"original"`
+		FixedWidth giphyImage `json:"fixed_width"`
+	} `json:"images"`
+}
+
+func (g *giphy) searchURL(query string, limit int) string {
+	params := url.Values{
+		"api_key": {g.key},
+		"limit":   {strconv.Itoa(limit)},
+		"rating":  {g.rating},
+	}
+	if query == "" {
+		return "https://api.giphy.com/v1/gifs/trending?" + params.Encode()
+	}
+	params.Set("q", query)
+	return "https://api.giphy.com/v1/gifs/search?" + params.Encode()
+}
+
+func (g *giphy) lookupURL(id string) string {
+	return "https://api.giphy.com/v1/gifs/" + url.PathEscape(id) + "?" + url.Values{"api_key": {g.key}}.Encode()
+}
+
+// parse reads a search response, whose data is a list, or a lookup
+// response, whose data is a single GIF
+func (g *giphy) parse(body io.Reader) ([]*store.GIF, error) {
+	var response struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, err
+	}
+	var results []giphyGIF
+	if bytes.HasPrefix(bytes.TrimSpace(response.Data), []byte("[")) {
+		if err := json.Unmarshal(response.Data, &results); err != nil {
+			return nil, err
+		}
+	} else {
+		var result giphyGIF
+		if err := json.Unmarshal(response.Data, &result); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	gifs := make([]*store.GIF, 0, len(results))
+	for _, result := range results {
+		original := result.Images.Original
+		if result.ID == "" || original.URL == "" {
+			continue
+		}
+		width, _ := strconv.Atoi(original.Width)
+		height, _ := strconv.Atoi(original.Height)
+		gifs = append(gifs, &store.GIF{
+			ID:         result.ID,
+			Title:      result.Title,
+			URL:        original.URL,
+			PreviewURL: result.Images.FixedWidth.URL,
+			Width:      width,
+			Height:     height,
+		})
+	}
+	return gifs, nil
+}
+
+// tenorFilters maps ratings to Tenor's content filters
+var tenorFilters = map[string]string{
+	"g":     "high",
+	"pg":    "medium",
+	"pg-13": "low",
+	"r":     "off",
+}
+
+// tenor searches Tenor through its v2 API
+type tenor struct {
+	key           string
+	contentFilter string
+}
+
+// tenorMedia is one rendition of a Tenor GIF
+type tenorMedia struct {
+	URL  string `json:"url"`
+	Dims []int  `json:"dims"`
+}
+
+func (t *tenor) params() url.Values {
+	return url.Values{
+		"key":           {t.key},
+		"client_key":    {"realtime-chat"},
+		"contentfilter": {t.contentFilter},
+		"media_filter":  {"gif,tinygif"},
+	}
+}
+
+func (t *tenor) searchURL(query string, limit int) string {
+	params := t.params()
+	params.Set("limit", strconv.Itoa(limit))
+	if query == "" {
+		return "https://tenor.googleapis.com/v2/featured?" + params.Encode()
+	}
+	params.Set("q", query)
+	return "https://tenor.googleapis.com/v2/search?" + params.Encode()
+}
+
+func (t *tenor) lookupURL(id string) string {
+	params := t.params()
+	params.Set("ids", id)
+	return "https://tenor.googleapis.com/v2/posts?" + params.Encode()
+}
+
+func (t *tenor) parse(body io.Reader) ([]*store.GIF, error) {
+	var response struct {
+		Results []struct {
+			ID           string                `json:"id"`
+			Description  string                `json:"content_description"`
+			MediaFormats map[string]tenorMedia `json:"media_formats"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	gifs := make([]*store.GIF, 0, len(response.Results))
+	for _, result := range response.Results {
+		full, ok := result.MediaFormats["gif"]
+		if result.ID == "" || !ok || full.URL == "" {
+			continue
+		}
+		gif := &store.GIF{
+			ID:         result.ID,
+			Title:      result.Description,
+			URL:        full.URL,
+			PreviewURL: result.MediaFormats["tinygif"].URL,
+		}
+		if len(full.Dims) == 2 {
+			gif.Width, gif.Height = full.Dims[0], full.Dims[1]
+		}
+		gifs = append(gifs, gif)
+	}
+	return gifs, nil
+}
@@ -0,0 +1,1190 @@
+package history
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/ledger"
+	"realtime-chat/internal/markdown"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/store"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event types recorded in a room's history
+const (
+	EventMessage        = "message"
+	EventEdit           = "edit"
+	EventDelete         = "delete"
+	EventRestore        = "restore" // A moderator undid a deletion
+	EventReactionAdd    = "reaction_add"
+	EventReactionRemove = "reaction_remove"
+
+	// EventExpire is reported to observers when a disappearing message is
+	// purged; it is never stored since the message's events are removed
+	EventExpire = "expire"
+
+	// EventPrune is reported to observers when a message is removed by a
+	// retention policy; like EventExpire it is never stored
+	EventPrune = "prune"
+
+	// EventErase is reported to observers when a message is removed because
+	// its author's data was erased, and once with no message ID for each
+	// room whose remaining events were anonymized; it is never stored
+	EventErase = "erase"
+
+	// EventCompact is reported to observers when a message is moved out of
+	// history into cold storage; it is never stored
+	EventCompact = "compact"
+)
+
+// Sizes of a page of history
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// Errors returned when a change to a message is rejected
+var (
+	ErrNotFound   = errors.New("message not found")
+	ErrNotAuthor  = errors.New("only the author can change this message")
+	ErrDeleted    = errors.New("message has been deleted")
+	ErrNotDeleted = errors.New("message hasn't been deleted")
+	ErrGIF        = errors.New("GIF messages can't be edited")
+)
+
+// ErrChanged is returned when messages read to be moved to cold storage
+// changed before they could be removed from history
+var ErrChanged = errors.New("messages changed while being compacted")
+
+// Message is the resolved state of a message after applying all of its events
+type Message struct {
+	ID        string              `json:"id"`
+	RoomID    string              `json:"roomId"`
+	Seq       int64               `json:"seq"` // Increases by one with every message posted in the room
+	Username  string              `json:"username"`
+	Content   string              `json:"content"`
+	Timestamp time.Time           `json:"timestamp"`
+	EditedAt  *time.Time          `json:"editedAt,omitempty"`
+	Deleted   bool                `json:"deleted,omitempty"`
+	DeletedAt *time.Time          `json:"deletedAt,omitempty"`
+	ExpiresAt *time.Time          `json:"expiresAt,omitempty"` // Set on disappearing messages
+	Reactions map[string][]string `json:"reactions,omitempty"` // Emoji to usernames, in reaction order
+
+	// Set on end-to-end encrypted messages, whose Content is ciphertext the
+	// server can't read, filter or search
+	Encryption *store.Encryption `json:"encryption,omitempty"`
+
+	// Set on GIF messages, whose Content is the GIF's URL
+	GIF *store.GIF `json:"gif,omitempty"`
+
+	// Content rendered from markdown to sanitized HTML, and the links,
+	// mentions and code found in it. Unset on encrypted and GIF messages.
+	HTML     string         `json:"html,omitempty"`
+	Entities []store.Entity `json:"entities,omitempty"`
+
+	// ID the sender's client gave the message, if any
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+}
+
+// DeletedMessage is a deleted message as it was before it was deleted, which
+// a moderator can still restore
+type DeletedMessage struct {
+	Message   *Message  `json:"message"`
+	DeletedBy string    `json:"deletedBy"` // The author or a moderator
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// Expired identifies a disappearing message that has been purged
+type Expired struct {
+	RoomID    string
+	MessageID string
+}
+
+// History records room message events and keeps their resolved state in memory
+type History struct {
+	store store.Store // may be nil for in-memory only history
+	mutex sync.Mutex
+	rooms map[string]*roomHistory
+
+	// Expiry time of every loaded disappearing message
+	expiries map[Expired]time.Time
+
+	// Functions called after every recorded event, outside the lock
+	observers []Observer
+
+	// Chains every recorded event to the one before it and signs it
+	sealer *ledger.Sealer
+
+	// Whether deleted messages are purged at once instead of kept restorable
+	purgeDeleted bool
+}
+
+// Observer is called with every recorded event. before is the message's
+// state before the event, or nil for new and purged messages.
+type Observer func(event *store.MessageEvent, before *Message)
+
+// roomHistory is the cached history of a single room
+type roomHistory struct {
+	events   []*store.MessageEvent
+	messages []*Message
+	byID     map[string]*Message
+
+	// Messages by their author and client message ID
+	byClientID map[clientKey]*Message
+
+	// Sequence number of the newest message ever posted, including removed ones
+	lastSeq int64
+
+	// Deleted messages as they were before they were deleted, until their
+	// events are purged
+	deleted map[string]*DeletedMessage
+}
+
+// clientKey identifies a message by the ID its author's client gave it
+type clientKey struct {
+	Username string
+	ClientID string
+}
+
+// New creates a history backed by st
+func New(st store.Store) *History {
+	return &History{
+		store:    st,
+		rooms:    make(map[string]*roomHistory),
+		expiries: make(map[Expired]time.Time),
+		sealer:   ledger.New(nil),
+	}
+}
+
+// SignWith makes the history sign every event it records with key, not
+// only chain it. It must be called before the history is used.
+func (h *History) SignWith(key ed25519.PrivateKey) {
+	h.sealer = ledger.New(key)
+}
+
+// PurgeDeleted makes the history remove every event of a message as soon as
+// it is deleted, instead of keeping it for moderators to restore until the
+// cleanup job purges it. It must be called before the history is used.
+func (h *History) PurgeDeleted() {
+	h.purgeDeleted = true
+}
+
+// PublicKey returns the base64 key that verifies event signatures, or ""
+// when events aren't signed
+func (h *History) PublicKey() string {
+	return h.sealer.PublicKey()
+}
+
+// Head returns the hash of a room's newest event, or "" if it has none
+func (h *History) Head(roomID string) (string, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, err := h.room(roomID)
+	if err != nil {
+		return "", err
+	}
+	return room.head(), nil
+}
+
+// Post records a new message and returns it. A positive ttl makes the
+// message disappear from history once it has elapsed. The message is given
+// the room's next sequence number.
+func (h *History) Post(roomID, username, content string, ttl time.Duration) (*Message, error) {
+	msg, _, err := h.PostOnce(roomID, username, "", content, ttl)
+	return msg, err
+}
+
+// PostOnce records a new message like Post, unless the user already posted
+// a message with the same client message ID in the room, in which case that
+// message is returned with duplicate set. Clients give each message an ID
+// such as a UUID so a message resent after a reconnect is only posted once.
+// An empty clientID always posts.
+func (h *History) PostOnce(roomID, username, clientID, content string, ttl time.Duration) (msg *Message, duplicate bool, err error) {
+	return h.PostEncrypted(roomID, username, clientID, content, nil, ttl)
+}
+
+// PostEncrypted records a message like PostOnce. With encryption set,
+// content is the ciphertext of an end-to-end encrypted message, stored as
+// it is. Otherwise its markdown is rendered and stored alongside it.
+func (h *History) PostEncrypted(roomID, username, clientID, content string, encryption *store.Encryption, ttl time.Duration) (msg *Message, duplicate bool, err error) {
+	event := &store.MessageEvent{
+		Type:       EventMessage,
+		MessageID:  newID(),
+		RoomID:     roomID,
+		Username:   username,
+		Content:    content,
+		Timestamp:  time.Now(),
+		Encryption: encryption,
+
+		ClientMessageID: clientID,
+	}
+	if encryption == nil {
+		event.HTML, event.Entities = markdown.Render(content)
+	}
+	return h.post(event, ttl)
+}
+
+// PostGIF records a message posting a GIF like PostOnce, with the GIF's URL
+// as its content
+func (h *History) PostGIF(roomID, username, clientID string, gif *store.GIF, ttl time.Duration) (msg *Message, duplicate bool, err error) {
+	return h.post(&store.MessageEvent{
+		Type:      EventMessage,
+		MessageID: newID(),
+		RoomID:    roomID,
+		Username:  username,
+		Content:   gif.URL,
+		Timestamp: time.Now(),
+		GIF:       gif,
+
+		ClientMessageID: clientID,
+	}, ttl)
+}
+
+// post records a new message event, numbering it and setting its expiry
+func (h *History) post(event *store.MessageEvent, ttl time.Duration) (msg *Message, duplicate bool, err error) {
+	roomID, username, clientID := event.RoomID, event.Username, event.ClientMessageID
+	if ttl > 0 {
+		expiresAt := event.Timestamp.Add(ttl)
+		event.ExpiresAt = &expiresAt
+	}
+
+	h.mutex.Lock()
+	room, err := h.room(roomID)
+	if err == nil && clientID != "" {
+		if original, ok := room.byClientID[clientKey{username, clientID}]; ok {
+			msg := copyMessage(original)
+			h.mutex.Unlock()
+			return msg, true, nil
+		}
+	}
+	if err == nil {
+		event.Seq = room.lastSeq + 1
+		err = h.record(room, event)
+	}
+	if err == nil {
+		msg = copyMessage(room.byID[event.MessageID])
+	}
+	h.mutex.Unlock()
+
+	if err != nil {
+		return nil, false, err
+	}
+	h.notify(event, nil)
+	return msg, false, nil
+}
+
+// ClientMessage returns the message a user posted in a room with a client
+// message ID, or ErrNotFound
+func (h *History) ClientMessage(roomID, username, clientID string) (*Message, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, err := h.room(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, ok := room.byClientID[clientKey{username, clientID}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyMessage(msg), nil
+}
+
+// Observe registers a function called after every event is recorded and
+// every disappearing message is purged. It must be called before the history is used.
+func (h *History) Observe(fn Observer) {
+	h.observers = append(h.observers, fn)
+}
+
+// notify passes an event to every observer
+func (h *History) notify(event *store.MessageEvent, before *Message) {
+	for _, fn := range h.observers {
+		fn(event, before)
+	}
+}
+
+// Edit replaces the content of a message; only its author may edit it
+func (h *History) Edit(roomID, messageID, username, content string) (*Message, error) {
+	return h.EditEncrypted(roomID, messageID, username, content, nil)
+}
+
+// EditEncrypted replaces the content of a message like Edit. With
+// encryption set, content is the ciphertext of the new end-to-end
+// encrypted content.
+func (h *History) EditEncrypted(roomID, messageID, username, content string, encryption *store.Encryption) (*Message, error) {
+	event := &store.MessageEvent{
+		Type:       EventEdit,
+		MessageID:  messageID,
+		RoomID:     roomID,
+		Username:   username,
+		Content:    content,
+		Timestamp:  time.Now(),
+		Encryption: encryption,
+	}
+	if encryption == nil {
+		event.HTML, event.Entities = markdown.Render(content)
+	}
+	return h.change(event, true)
+}
+
+// Delete turns a message into a tombstone; only its author may delete it
+func (h *History) Delete(roomID, messageID, username string) (*Message, error) {
+	return h.change(&store.MessageEvent{
+		Type:      EventDelete,
+		MessageID: messageID,
+		RoomID:    roomID,
+		Username:  username,
+		Timestamp: time.Now(),
+	}, true)
+}
+
+// Remove deletes any user's message on behalf of a moderator
+func (h *History) Remove(roomID, messageID, moderator string) (*Message, error) {
+	return h.change(&store.MessageEvent{
+		Type:      EventDelete,
+		MessageID: messageID,
+		RoomID:    roomID,
+		Username:  moderator,
+		Timestamp: time.Now(),
+	}, false)
+}
+
+// Restore undoes a message's deletion on behalf of a moderator, bringing its
+// content and reactions back
+func (h *History) Restore(roomID, messageID, moderator string) (*Message, error) {
+	event := &store.MessageEvent{
+		Type:      EventRestore,
+		MessageID: messageID,
+		RoomID:    roomID,
+		Username:  moderator,
+		Timestamp: time.Now(),
+	}
+
+	h.mutex.Lock()
+	room, err := h.room(roomID)
+	if err != nil {
+		h.mutex.Unlock()
+		return nil, err
+	}
+	msg, ok := room.byID[messageID]
+	if !ok || (msg.Deleted && room.deleted[messageID] == nil) {
+		h.mutex.Unlock()
+		return nil, ErrNotFound
+	}
+	if !msg.Deleted {
+		h.mutex.Unlock()
+		return nil, ErrNotDeleted
+	}
+	before := copyMessage(msg)
+	if err := h.record(room, event); err != nil {
+		h.mutex.Unlock()
+		return nil, err
+	}
+	restored := copyMessage(msg)
+	h.mutex.Unlock()
+
+	h.notify(event, before)
+	return restored, nil
+}
+
+// Deleted returns the messages of a room deleted at or after since, as they
+// were before they were deleted, most recently deleted first
+func (h *History) Deleted(roomID string, since time.Time) ([]*DeletedMessage, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, err := h.room(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := make([]*DeletedMessage, 0)
+	for _, d := range room.deleted {
+		if d.DeletedAt.Before(since) {
+			continue
+		}
+		deleted = append(deleted, &DeletedMessage{Message: copyMessage(d.Message), DeletedBy: d.DeletedBy, DeletedAt: d.DeletedAt})
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].DeletedAt.After(deleted[j].DeletedAt)
+	})
+	return deleted, nil
+}
+
+// React adds or removes a user's emoji reaction on a message
+func (h *History) React(roomID, messageID, username, emoji string, add bool) (*Message, error) {
+	eventType := EventReactionAdd
+	if !add {
+		eventType = EventReactionRemove
+	}
+
+	return h.change(&store.MessageEvent{
+		Type:      eventType,
+		MessageID: messageID,
+		RoomID:    roomID,
+		Username:  username,
+		Emoji:     emoji,
+		Timestamp: time.Now(),
+	}, false)
+}
+
+// Events returns a room's raw event stream in order. The content of
+// deleted messages is blanked from their "message" and "edit" events, which
+// are marked redacted, so only Deleted returns it until it is purged.
+func (h *History) Events(roomID string) ([]*store.MessageEvent, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, err := h.room(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*store.MessageEvent, len(room.events))
+	for i, event := range room.events {
+		e := *event
+		if msg, ok := room.byID[e.MessageID]; ok && msg.Deleted && (e.Type == EventMessage || e.Type == EventEdit) {
+			e.Content = ""
+			e.Encryption = nil
+			e.GIF = nil
+			e.HTML = ""
+			e.Entities = nil
+			e.Redacted = true
+		}
+		events[i] = &e
+	}
+	return events, nil
+}
+
+// Messages returns the resolved final state of every message in a room, oldest first.
+// Deleted messages are included as tombstones.
+func (h *History) Messages(roomID string) ([]*Message, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, err := h.room(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*Message, len(room.messages))
+	for i, msg := range room.messages {
+		messages[i] = copyMessage(msg)
+	}
+	return messages, nil
+}
+
+// Page returns up to limit messages of a room, oldest first, either just
+// before or just after the cursor message, or the newest ones when both
+// cursors are empty. more reports whether further messages lie beyond the
+// page in the direction it was read. limit defaults to DefaultPageSize and
+// is capped at MaxPageSize.
+func (h *History) Page(roomID, before, after string, limit int) (page []*Message, more bool, err error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, err := h.room(roomID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The page is room.messages[start:end]
+	start, end := len(room.messages)-limit, len(room.messages)
+	switch {
+	case after != "":
+		i := room.index(after)
+		if i < 0 {
+			return nil, false, ErrNotFound
+		}
+		start, end = i+1, i+1+limit
+		if end > len(room.messages) {
+			end = len(room.messages)
+		}
+		more = end < len(room.messages)
+
+	default:
+		if before != "" {
+			if end = room.index(before); end < 0 {
+				return nil, false, ErrNotFound
+			}
+			start = end - limit
+		}
+		if start < 0 {
+			start = 0
+		}
+		more = start > 0
+	}
+
+	page = make([]*Message, 0, end-start)
+	for _, msg := range room.messages[start:end] {
+		page = append(page, copyMessage(msg))
+	}
+	return page, more, nil
+}
+
+// Since returns up to limit messages of a room posted after the message
+// numbered afterSeq, oldest first, so a client that noticed a gap in the
+// sequence numbers it received can fetch what it missed. lastSeq is the
+// number of the room's newest message; numbers missing between afterSeq and
+// lastSeq belong to messages that were removed. more reports whether further
+// messages follow the page. limit defaults to DefaultPageSize and is capped
+// at MaxPageSize.
+func (h *History) Since(roomID string, afterSeq int64, limit int) (page []*Message, lastSeq int64, more bool, err error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, err := h.room(roomID)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	// Messages are kept in sequence order
+	start := sort.Search(len(room.messages), func(i int) bool {
+		return room.messages[i].Seq > afterSeq
+	})
+	end := min(start+limit, len(room.messages))
+
+	page = make([]*Message, 0, end-start)
+	for _, msg := range room.messages[start:end] {
+		page = append(page, copyMessage(msg))
+	}
+	return page, room.lastSeq, end < len(room.messages), nil
+}
+
+// LastSeq returns the sequence number of the newest message posted in a
+// room, or 0 when none has been
+func (h *History) LastSeq(roomID string) (int64, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, err := h.room(roomID)
+	if err != nil {
+		return 0, err
+	}
+	return room.lastSeq, nil
+}
+
+// Message returns the resolved state of a single message
+func (h *History) Message(roomID, messageID string) (*Message, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, err := h.room(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, ok := room.byID[messageID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyMessage(msg), nil
+}
+
+// Load reads a room's history into memory so its disappearing messages are tracked
+func (h *History) Load(roomID string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	_, err := h.room(roomID)
+	return err
+}
+
+// Expire purges every disappearing message whose expiry has passed, removing
+// all of its events from history, and returns the messages that were purged
+func (h *History) Expire(now time.Time) ([]Expired, error) {
+	h.mutex.Lock()
+	expired, err := h.expire(now)
+	h.mutex.Unlock()
+
+	for _, e := range expired {
+		h.notify(&store.MessageEvent{
+			Type:      EventExpire,
+			MessageID: e.MessageID,
+			RoomID:    e.RoomID,
+			Timestamp: now,
+		}, nil)
+	}
+	return expired, err
+}
+
+// expire purges due disappearing messages; the caller must hold the lock
+func (h *History) expire(now time.Time) ([]Expired, error) {
+	// Group due messages by room
+	due := make(map[string]map[string]bool)
+	for key, expiresAt := range h.expiries {
+		if expiresAt.After(now) {
+			continue
+		}
+		if due[key.RoomID] == nil {
+			due[key.RoomID] = make(map[string]bool)
+		}
+		due[key.RoomID][key.MessageID] = true
+	}
+
+	var expired []Expired
+	for roomID, messageIDs := range due {
+		if _, err := h.purge(roomID, messageIDs); err != nil {
+			return expired, err
+		}
+
+		for messageID := range messageIDs {
+			key := Expired{RoomID: roomID, MessageID: messageID}
+			delete(h.expiries, key)
+			expired = append(expired, key)
+		}
+	}
+
+	return expired, nil
+}
+
+// PurgeTombstones permanently removes every event of the room's messages that
+// were deleted before the cutoff, and returns how many messages were removed
+// and the approximate number of bytes of history they used
+func (h *History) PurgeTombstones(roomID string, before time.Time) (int, int64, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, err := h.room(roomID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tombstones := make(map[string]bool)
+	for _, msg := range room.messages {
+		if msg.Deleted && msg.DeletedAt != nil && msg.DeletedAt.Before(before) {
+			tombstones[msg.ID] = true
+		}
+	}
+	if len(tombstones) == 0 {
+		return 0, 0, nil
+	}
+
+	reclaimed, err := h.purge(roomID, tombstones)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(tombstones), reclaimed, nil
+}
+
+// Prune permanently removes every event of the room's messages posted before
+// the cutoff, and then of its oldest messages beyond the newest keep. A zero
+// cutoff or keep skips that limit. Returns how many messages were removed and
+// the approximate number of bytes of history they used.
+func (h *History) Prune(roomID string, before time.Time, keep int) (int, int64, error) {
+	h.mutex.Lock()
+	room, err := h.room(roomID)
+	if err != nil {
+		h.mutex.Unlock()
+		return 0, 0, err
+	}
+
+	pruned := make(map[string]bool)
+	for i, msg := range room.messages {
+		tooOld := !before.IsZero() && msg.Timestamp.Before(before)
+		tooMany := keep > 0 && i < len(room.messages)-keep
+		if tooOld || tooMany {
+			pruned[msg.ID] = true
+		}
+	}
+	if len(pruned) == 0 {
+		h.mutex.Unlock()
+		return 0, 0, nil
+	}
+
+	reclaimed, err := h.purge(roomID, pruned)
+	if err == nil {
+		for messageID := range pruned {
+			delete(h.expiries, Expired{RoomID: roomID, MessageID: messageID})
+		}
+	}
+	h.mutex.Unlock()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now()
+	for messageID := range pruned {
+		h.notify(&store.MessageEvent{
+			Type:      EventPrune,
+			MessageID: messageID,
+			RoomID:    roomID,
+			Timestamp: now,
+		}, nil)
+	}
+	return len(pruned), reclaimed, nil
+}
+
+// Older returns up to limit of a room's oldest messages posted before the
+// cutoff, oldest first, including tombstones. It stops at the first
+// disappearing message, which leaves history on its own once it expires.
+func (h *History) Older(roomID string, before time.Time, limit int) ([]*Message, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	room, err := h.room(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	var older []*Message
+	for _, msg := range room.messages {
+		if len(older) == limit || !msg.Timestamp.Before(before) || msg.ExpiresAt != nil {
+			break
+		}
+		older = append(older, copyMessage(msg))
+	}
+	return older, nil
+}
+
+// Compacted permanently removes every event of messages Older returned, once
+// they have been copied to cold storage, and returns the approximate number
+// of bytes of history they used. Nothing is removed and ErrChanged is
+// returned if any of them changed since, so the copy is never stale.
+func (h *History) Compacted(roomID string, messages []*Message) (int64, error) {
+	h.mutex.Lock()
+	room, err := h.room(roomID)
+	if err != nil {
+		h.mutex.Unlock()
+		return 0, err
+	}
+
+	compacted := make(map[string]bool, len(messages))
+	for _, msg := range messages {
+		current, ok := room.byID[msg.ID]
+		if !ok || !reflect.DeepEqual(copyMessage(current), msg) {
+			h.mutex.Unlock()
+			return 0, ErrChanged
+		}
+		compacted[msg.ID] = true
+	}
+	if len(compacted) == 0 {
+		h.mutex.Unlock()
+		return 0, nil
+	}
+
+	reclaimed, err := h.purge(roomID, compacted)
+	h.mutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, msg := range messages {
+		h.notify(&store.MessageEvent{
+			Type:      EventCompact,
+			MessageID: msg.ID,
+			RoomID:    roomID,
+			Timestamp: now,
+		}, nil)
+	}
+	return reclaimed, nil
+}
+
+// EraseUser removes a user from a room's history. With purge every event of
+// their messages and their reactions is removed; otherwise their messages
+// and reactions are kept under pseudonym. Any other event they recorded,
+// such as deleting someone else's message as a moderator, is always kept
+// under pseudonym so the room's state doesn't change. Returns how many of
+// their messages were purged or anonymized.
+func (h *History) EraseUser(roomID, username, pseudonym string, purge bool) (int, error) {
+	h.mutex.Lock()
+	room, err := h.room(roomID)
+	if err != nil {
+		h.mutex.Unlock()
+		return 0, err
+	}
+
+	authored := make(map[string]bool)
+	for _, msg := range room.messages {
+		if msg.Username == username {
+			authored[msg.ID] = true
+		}
+	}
+
+	changed := false
+	kept := make([]*store.MessageEvent, 0, len(room.events))
+	for _, event := range room.events {
+		reaction := event.Type == EventReactionAdd || event.Type == EventReactionRemove
+		if purge && (authored[event.MessageID] || (reaction && event.Username == username)) {
+			changed = true
+			continue
+		}
+		if event.Username == username {
+			// Copy the event so readers holding the old one never see a partial update
+			renamed := *event
+			renamed.Username = pseudonym
+			event = &renamed
+			changed = true
+		}
+		kept = append(kept, event)
+	}
+	if !changed {
+		h.mutex.Unlock()
+		return 0, nil
+	}
+	h.reseal(kept)
+
+	if h.store != nil {
+		if err := h.store.ReplaceEvents(roomID, kept); err != nil {
+			h.mutex.Unlock()
+			return 0, err
+		}
+	}
+	rebuilt := &roomHistory{byID: make(map[string]*Message), lastSeq: room.lastSeq}
+	for _, event := range kept {
+		rebuilt.apply(event)
+	}
+	h.rooms[roomID] = rebuilt
+	if purge {
+		for messageID := range authored {
+			delete(h.expiries, Expired{RoomID: roomID, MessageID: messageID})
+		}
+	}
+	h.mutex.Unlock()
+
+	now := time.Now()
+	if purge {
+		for messageID := range authored {
+			h.notify(&store.MessageEvent{Type: EventErase, MessageID: messageID, RoomID: roomID, Timestamp: now}, nil)
+		}
+	}
+	h.notify(&store.MessageEvent{Type: EventErase, RoomID: roomID, Timestamp: now}, nil)
+	return len(authored), nil
+}
+
+// Forget drops a room's history from memory, for rooms whose stored history was removed
+func (h *History) Forget(roomID string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(h.rooms, roomID)
+	for key := range h.expiries {
+		if key.RoomID == roomID {
+			delete(h.expiries, key)
+		}
+	}
+}
+
+// purge removes every event of the given messages from a loaded room's
+// history and returns the encoded size of the removed events; the caller must hold the lock
+func (h *History) purge(roomID string, messageIDs map[string]bool) (int64, error) {
+	room := h.rooms[roomID]
+
+	var reclaimed int64
+	kept := make([]*store.MessageEvent, 0, len(room.events))
+	for _, event := range room.events {
+		if !messageIDs[event.MessageID] {
+			kept = append(kept, event)
+			continue
+		}
+		if data, err := json.Marshal(event); err == nil {
+			reclaimed += int64(len(data)) + 1
+		}
+	}
+	h.reseal(kept)
+
+	if h.store != nil {
+		if err := h.store.ReplaceEvents(roomID, kept); err != nil {
+			return 0, err
+		}
+	}
+
+	// Rebuild the room's state from the remaining events
+	rebuilt := &roomHistory{byID: make(map[string]*Message), lastSeq: room.lastSeq}
+	for _, event := range kept {
+		rebuilt.apply(event)
+	}
+	h.rooms[roomID] = rebuilt
+	return reclaimed, nil
+}
+
+// change validates and records an event that modifies an existing message
+func (h *History) change(event *store.MessageEvent, authorOnly bool) (*Message, error) {
+	h.mutex.Lock()
+	before, msg, err := h.changeLocked(event, authorOnly)
+	h.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	h.notify(event, before)
+	return msg, nil
+}
+
+// changeLocked validates and records a change, returning the message before
+// and after it; the caller must hold the lock
+func (h *History) changeLocked(event *store.MessageEvent, authorOnly bool) (*Message, *Message, error) {
+	room, err := h.room(event.RoomID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg, ok := room.byID[event.MessageID]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	if msg.Deleted {
+		return nil, nil, ErrDeleted
+	}
+	if authorOnly && msg.Username != event.Username {
+		return nil, nil, ErrNotAuthor
+	}
+	if event.Type == EventEdit && msg.GIF != nil {
+		return nil, nil, ErrGIF
+	}
+
+	before := copyMessage(msg)
+	if err := h.record(room, event); err != nil {
+		return nil, nil, err
+	}
+	after := copyMessage(msg)
+	if event.Type == EventDelete && h.purgeDeleted {
+		if _, err := h.purge(event.RoomID, map[string]bool{msg.ID: true}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return before, after, nil
+}
+
+// record seals and persists an event and applies it to the cached state
+func (h *History) record(room *roomHistory, event *store.MessageEvent) error {
+	h.sealer.Seal(room.head(), event)
+	if h.store != nil {
+		if err := h.store.AppendEvent(event); err != nil {
+			return err
+		}
+	}
+	room.apply(event)
+	h.track(event)
+	return nil
+}
+
+// track remembers when a disappearing message expires
+func (h *History) track(event *store.MessageEvent) {
+	if event.Type == EventMessage && event.ExpiresAt != nil {
+		h.expiries[Expired{RoomID: event.RoomID, MessageID: event.MessageID}] = *event.ExpiresAt
+	}
+}
+
+// room returns a room's cached history, loading it from the store on first use
+func (h *History) room(roomID string) (*roomHistory, error) {
+	if room, ok := h.rooms[roomID]; ok {
+		return room, nil
+	}
+
+	room := &roomHistory{byID: make(map[string]*Message)}
+	if h.store != nil {
+		events, err := h.store.LoadEvents(roomID)
+		if err != nil {
+			return nil, err
+		}
+		broken := -1
+		for i, event := range events {
+			prev := room.head()
+			if i == 0 {
+				// The oldest events may have been dropped, so the first can follow any hash
+				prev = event.PrevHash
+			}
+			room.apply(event)
+			h.track(event)
+
+			// Events recorded before history was chained are sealed as they load
+			if event.Hash == "" {
+				h.sealer.Seal(prev, event)
+			} else if broken < 0 && !ledger.Linked(prev, event) {
+				broken = i
+			}
+		}
+		if broken >= 0 {
+			log.Printf("History of room %s was modified outside the server: its hash chain breaks at event %d", roomID, broken)
+		}
+	}
+
+	h.rooms[roomID] = room
+	return room, nil
+}
+
+// reseal seals again every event that was changed or whose predecessor was
+// removed, and every event after it, replacing each with a sealed copy so
+// readers holding the old one never see a partial update. The first event
+// keeps its link since the events before it may have been dropped on
+// purpose. The caller must hold the lock.
+func (h *History) reseal(events []*store.MessageEvent) {
+	stale := false
+	for i, event := range events {
+		prev := event.PrevHash
+		if i > 0 {
+			prev = events[i-1].Hash
+		}
+		if !stale && ledger.Linked(prev, event) {
+			continue
+		}
+		stale = true
+		sealed := *event
+		h.sealer.Seal(prev, &sealed)
+		events[i] = &sealed
+	}
+}
+
+// head returns the hash of the room's newest event, or ""
+func (r *roomHistory) head() string {
+	if len(r.events) == 0 {
+		return ""
+	}
+	return r.events[len(r.events)-1].Hash
+}
+
+// index returns the position of a message in the room, or -1
+func (r *roomHistory) index(messageID string) int {
+	for i, msg := range r.messages {
+		if msg.ID == messageID {
+			return i
+		}
+	}
+	return -1
+}
+
+// apply folds a single event into the room's resolved state
+func (r *roomHistory) apply(event *store.MessageEvent) {
+	r.events = append(r.events, event)
+
+	if event.Type == EventMessage {
+		// Messages stored before sequence numbers existed are numbered as they load
+		if event.Seq == 0 {
+			event.Seq = r.lastSeq + 1
+		}
+		r.lastSeq = max(r.lastSeq, event.Seq)
+
+		msg := &Message{
+			ID:         event.MessageID,
+			RoomID:     event.RoomID,
+			Seq:        event.Seq,
+			Username:   event.Username,
+			Content:    event.Content,
+			Timestamp:  event.Timestamp,
+			ExpiresAt:  event.ExpiresAt,
+			Encryption: event.Encryption,
+			GIF:        event.GIF,
+			HTML:       event.HTML,
+			Entities:   event.Entities,
+
+			ClientMessageID: event.ClientMessageID,
+		}
+		r.messages = append(r.messages, msg)
+		r.byID[msg.ID] = msg
+		if msg.ClientMessageID != "" {
+			if r.byClientID == nil {
+				r.byClientID = make(map[clientKey]*Message)
+			}
+			r.byClientID[clientKey{msg.Username, msg.ClientMessageID}] = msg
+		}
+		return
+	}
+
+	msg, ok := r.byID[event.MessageID]
+	if !ok {
+		return
+	}
+
+	switch event.Type {
+	case EventEdit:
+		at := event.Timestamp
+		msg.Content = event.Content
+		msg.Encryption = event.Encryption
+		msg.HTML = event.HTML
+		msg.Entities = event.Entities
+		msg.EditedAt = &at
+
+	case EventDelete:
+		if r.deleted == nil {
+			r.deleted = make(map[string]*DeletedMessage)
+		}
+		r.deleted[msg.ID] = &DeletedMessage{Message: copyMessage(msg), DeletedBy: event.Username, DeletedAt: event.Timestamp}
+
+		at := event.Timestamp
+		msg.Content = ""
+		msg.Encryption = nil
+		msg.GIF = nil
+		msg.HTML = ""
+		msg.Entities = nil
+		msg.Deleted = true
+		msg.DeletedAt = &at
+		msg.Reactions = nil
+
+	case EventRestore:
+		if d, ok := r.deleted[msg.ID]; ok {
+			*msg = *copyMessage(d.Message)
+			delete(r.deleted, msg.ID)
+		}
+
+	case EventReactionAdd:
+		if msg.Reactions == nil {
+			msg.Reactions = make(map[string][]string)
+		}
+		for _, username := range msg.Reactions[event.Emoji] {
+			if username == event.Username {
+				return
+			}
+		}
+		msg.Reactions[event.Emoji] = append(msg.Reactions[event.Emoji], event.Username)
+
+	case EventReactionRemove:
+		users := msg.Reactions[event.Emoji]
+		for i, username := range users {
+			if username == event.Username {
+				users = append(users[:i:i], users[i+1:]...)
+				break
+			}
+		}
+		if len(users) == 0 {
+			delete(msg.Reactions, event.Emoji)
+		} else {
+			msg.Reactions[event.Emoji] = users
+		}
+	}
+}
+
+// copyMessage returns a deep copy of a message so callers can't race with later events
+func copyMessage(msg *Message) *Message {
+	c := *msg
+	if msg.Reactions != nil {
+		c.Reactions = make(map[string][]string, len(msg.Reactions))
+		for emoji, users := range msg.Reactions {
+			c.Reactions[emoji] = append([]string(nil), users...)
+		}
+	}
+	return &c
+}
+
+// newID generates a random message ID
+func newID() string {
+	return replay.ID("message", func() string {
+		b := make([]byte, 8)
+		rand.Read(b)
+		return "msg_" + hex.EncodeToString(b)
+	})
+}
@@ -0,0 +1,190 @@
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/store"
+	"strings"
+	"time"
+)
+
+// MaxAnnouncementLength is the longest announcement in bytes
+const MaxAnnouncementLength = 2000
+
+// Frame types of announcements
+const (
+	announcementType = "announcement"
+	withdrawnType    = "announcement_withdrawn"
+)
+
+// Errors returned by Announce and WithdrawAnnouncement
+var (
+	ErrAnnouncementInvalid = fmt.Errorf("an announcement needs a message of at most %d bytes and a ttl that isn't negative", MaxAnnouncementLength)
+	ErrNoAnnouncement      = errors.New("no such announcement")
+)
+
+// Announcement is a server-wide notice from an admin, shown to every
+// connected client regardless of its room until it expires or is withdrawn
+type Announcement struct {
+	ID        string     `json:"id"`
+	Message   string     `json:"message"`
+	Author    string     `json:"author"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // Never expires when nil
+}
+
+// expired reports whether the announcement has expired at now
+func (a *Announcement) expired(now time.Time) bool {
+	return a.ExpiresAt != nil && !now.Before(*a.ExpiresAt)
+}
+
+// frame returns the announcement as an "announcement" frame
+func (a *Announcement) frame() []byte {
+	frame, _ := json.Marshal(struct {
+		Type string `json:"type"`
+		*Announcement
+	}{announcementType, a})
+	return frame
+}
+
+// Announce sends an announcement to every connected client on every node,
+// and to clients connecting later until it expires after ttl, or never when
+// ttl is 0. actor is recorded in the audit log as its author.
+func (h *Hub) Announce(message string, ttl time.Duration, actor string) (*Announcement, error) {
+	message = strings.TrimSpace(message)
+	if message == "" || len(message) > MaxAnnouncementLength || ttl < 0 {
+		return nil, ErrAnnouncementInvalid
+	}
+
+	a := &Announcement{
+		ID:        newAnnouncementID(),
+		Message:   message,
+		Author:    actor,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := a.CreatedAt.Add(ttl)
+		a.ExpiresAt = &expiresAt
+	}
+	h.keepAnnouncement(a)
+
+	h.Audit.Record(store.AuditRecord{
+		Actor:   actor,
+		Action:  audit.ActionAnnounce,
+		Target:  a.ID,
+		Details: map[string]string{"message": message},
+	})
+
+	h.SendPriority(&PriorityMessage{Message: a.frame()})
+	log.Printf("Announcement %s by %s: %q", a.ID, actor, message)
+	return a, nil
+}
+
+// WithdrawAnnouncement ends an announcement before it expires and tells
+// every connected client to remove its banner
+func (h *Hub) WithdrawAnnouncement(id, actor string) error {
+	if !h.dropAnnouncement(id) {
+		return ErrNoAnnouncement
+	}
+
+	h.Audit.Record(store.AuditRecord{
+		Actor:  actor,
+		Action: audit.ActionWithdrawAnnouncement,
+		Target: id,
+	})
+
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":      withdrawnType,
+		"id":        id,
+		"timestamp": getCurrentTime(),
+	})
+	h.SendPriority(&PriorityMessage{Message: frame})
+	log.Printf("Announcement %s withdrawn by %s", id, actor)
+	return nil
+}
+
+// Announcements returns the announcements that haven't expired, oldest first
+func (h *Hub) Announcements() []*Announcement {
+	h.announcing.Lock()
+	defer h.announcing.Unlock()
+
+	now := time.Now()
+	active := h.announcements[:0]
+	for _, a := range h.announcements {
+		if !a.expired(now) {
+			active = append(active, a)
+		}
+	}
+	h.announcements = active
+
+	list := make([]*Announcement, len(active))
+	copy(list, active)
+	return list
+}
+
+// keepAnnouncement remembers an announcement for clients connecting later
+func (h *Hub) keepAnnouncement(a *Announcement) {
+	h.announcing.Lock()
+	defer h.announcing.Unlock()
+	for _, kept := range h.announcements {
+		if kept.ID == a.ID {
+			return
+		}
+	}
+	h.announcements = append(h.announcements, a)
+}
+
+// dropAnnouncement forgets an announcement, reporting whether it was active
+func (h *Hub) dropAnnouncement(id string) bool {
+	h.announcing.Lock()
+	defer h.announcing.Unlock()
+	for i, a := range h.announcements {
+		if a.ID == id {
+			h.announcements = append(h.announcements[:i], h.announcements[i+1:]...)
+			return !a.expired(time.Now())
+		}
+	}
+	return false
+}
+
+// sendAnnouncements shows a newly connected client the active announcements
+func (h *Hub) sendAnnouncements(client *Client) {
+	for _, a := range h.Announcements() {
+		deliverTo(client.Priority, client.PriorityQueue, a.frame(), client.ID)
+	}
+}
+
+// trackAnnouncement follows the announcements made and withdrawn on other
+// nodes of a cluster, so clients connecting to this node see them too
+func (h *Hub) trackAnnouncement(frame []byte) {
+	switch metrics.FrameType(frame) {
+	case announcementType:
+		var a Announcement
+		if err := json.Unmarshal(frame, &a); err == nil && a.ID != "" {
+			h.keepAnnouncement(&a)
+		}
+	case withdrawnType:
+		var withdrawn struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(frame, &withdrawn); err == nil {
+			h.dropAnnouncement(withdrawn.ID)
+		}
+	}
+}
+
+// newAnnouncementID returns a random announcement ID
+func newAnnouncementID() string {
+	return replay.ID("announcement", func() string {
+		b := make([]byte, 8)
+		rand.Read(b)
+		return "announcement_" + hex.EncodeToString(b)
+	})
+}
@@ -0,0 +1,41 @@
+package hub
+
+import (
+	"encoding/json"
+	"realtime-chat/internal/auth"
+)
+
+// AllowKeyRequest records a request made with an API key and reports
+// whether it is within the key's rate limit
+func (h *Hub) AllowKeyRequest(key *auth.APIKey) bool {
+	limit := h.KeyRateLimit(key)
+	return limit == 0 || h.keyLimits.allow(key.ID, limit)
+}
+
+// KeyRateLimit returns how many requests a minute an API key may make: its
+// own limit, or CHAT_API_KEY_RATE_LIMIT. 0 is no limit.
+func (h *Hub) KeyRateLimit(key *auth.APIKey) int {
+	if key.RateLimit > 0 {
+		return key.RateLimit
+	}
+	return h.config.Auth.APIKeyRateLimit
+}
+
+// RevokeAPIKey removes an API key and closes the connections made with it,
+// returning the key and how many connections were closed
+func (h *Hub) RevokeAPIKey(id string) (*auth.APIKey, int, error) {
+	key, err := h.Auth.RevokeAPIKey(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":      "api_key_revoked",
+		"message":   "The API key this connection used was revoked",
+		"timestamp": getCurrentTime(),
+	})
+	closed := h.closeClients(key.Username, frame, func(c *Client) bool {
+		return c.APIKey != nil && c.APIKey.ID == id
+	})
+	return key, closed, nil
+}
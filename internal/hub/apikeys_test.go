@@ -0,0 +1,41 @@
+package hub
+
+import (
+	"context"
+	"realtime-chat/internal/auth"
+	"testing"
+)
+
+func TestRevokeAPIKey(t *testing.T) {
+	h := newTestHub(t, context.Background())
+	go h.Run()
+	defer h.Stop()
+
+	_, key, err := h.Auth.CreateAPIKey("bot", "deploys", []string{auth.ScopeRead}, 0, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := h.Auth.CreateAPIKey("bot", "alerts", []string{auth.ScopeRead}, 0, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, roomID := newRoomClient(t, h, "", "bot")
+	client.APIKey = key
+	remaining, _ := newRoomClient(t, h, "", "bot")
+	remaining.APIKey = other
+
+	revoked, closed, err := h.RevokeAPIKey(key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked.ID != key.ID || closed != 1 {
+		t.Fatalf("revoked %s and closed %d clients, want %s and 1", revoked.ID, closed, key.ID)
+	}
+	checkDisconnected(t, h, client, roomID, "api_key_revoked")
+
+	select {
+	case <-remaining.Closing():
+		t.Fatal("client of another key was disconnected")
+	default:
+	}
+}
@@ -0,0 +1,34 @@
+package hub
+
+import (
+	"encoding/json"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/store"
+)
+
+// ArchiveRoom archives a room on behalf of actor, making it read-only and
+// leaving it out of the room list and discovery, or restores it when archived
+// is false. The change is audited and announced to the room's members.
+func (h *Hub) ArchiveRoom(r *room.Room, archived bool, actor, reason string) {
+	r.SetArchived(archived, actor)
+
+	action, eventType := audit.ActionArchive, "room_archived"
+	if !archived {
+		action, eventType = audit.ActionUnarchive, "room_unarchived"
+	}
+	h.Audit.Record(store.AuditRecord{
+		Actor:  actor,
+		Action: action,
+		RoomID: r.ID,
+		Reason: reason,
+	})
+
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":      eventType,
+		"roomId":    r.ID,
+		"username":  actor,
+		"timestamp": getCurrentTime(),
+	})
+	h.RoomManager.BroadcastToRoom(r.ID, frame, nil)
+}
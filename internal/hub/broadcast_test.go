@@ -0,0 +1,134 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/store"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// benchQueueSize is the send and priority queue size of benchmarked
+// clients; the queues are emptied after every benchQueueSize broadcasts so
+// no frame is dropped
+const benchQueueSize = 256
+
+// benchFrame is the frame broadcast by the benchmarks
+var benchFrame = []byte(`{"type":"message","username":"loadgen","content":"hello","roomId":"lobby"}`)
+
+// newBenchHub starts a hub with n clients in the lobby and no connections
+// draining their queues
+func newBenchHub(b *testing.B, n int) (*Hub, []*Client) {
+	b.Helper()
+	st, err := store.NewMemoryStore(filepath.Join(b.TempDir(), "snapshot.json"), 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.Connection.SendQueueSize = benchQueueSize
+	cfg.Connection.PriorityQueueSize = benchQueueSize
+	cfg.Overload.MaxConnections = n + 1
+	cfg.Overload.MaxQueueDepth = math.MaxInt
+
+	h := NewHub(context.Background(), cfg, st)
+	go h.Run()
+	b.Cleanup(h.Stop)
+
+	clients := make([]*Client, n)
+	for i := range clients {
+		c := newTestClient(h, "bench-"+strconv.Itoa(i), "bench-"+strconv.Itoa(i))
+		c.Send = make(chan []byte, benchQueueSize)
+		c.Priority = make(chan []byte, benchQueueSize)
+		if !h.Admit(c) {
+			b.Fatalf("client %d not admitted", i)
+		}
+		h.Register <- c
+		if resp := h.RoomManager.JoinRoomAsync(c, room.LobbyID); !resp.Success {
+			b.Fatalf("client %d: %s", i, resp.Message)
+		}
+		clients[i] = c
+	}
+
+	// Drop the join notices
+	drainClients(clients)
+	return h, clients
+}
+
+// drainClients empties the clients' queues
+func drainClients(clients []*Client) {
+	for _, c := range clients {
+		for len(c.Send) > 0 {
+			<-c.Send
+		}
+		for len(c.Priority) > 0 {
+			<-c.Priority
+		}
+	}
+}
+
+// benchmarkBroadcast runs broadcast b.N times. Every benchQueueSize
+// broadcasts, and at the end, it waits until queued reports them all queued
+// for every client, then empties the queues with the timer stopped.
+func benchmarkBroadcast(b *testing.B, clients []*Client, broadcast func(), queued func(*Client) int) {
+	b.SetBytes(int64(len(benchFrame) * len(clients)))
+	b.ResetTimer()
+
+	pending := 0
+	for i := 0; i < b.N; i++ {
+		broadcast()
+		pending++
+
+		if pending == benchQueueSize || i == b.N-1 {
+			for _, c := range clients {
+				for queued(c) < pending {
+					runtime.Gosched()
+				}
+			}
+			b.StopTimer()
+			drainClients(clients)
+			pending = 0
+			b.StartTimer()
+		}
+	}
+}
+
+// BenchmarkRoomBroadcast broadcasts to the lobby as chat messages are,
+// through the room manager and the lobby's goroutine
+func BenchmarkRoomBroadcast(b *testing.B) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+			h, clients := newBenchHub(b, n)
+			benchmarkBroadcast(b, clients, func() {
+				h.RoomManager.BroadcastToRoom(room.LobbyID, benchFrame, nil)
+			}, func(c *Client) int { return len(c.Send) })
+		})
+	}
+}
+
+// BenchmarkPriorityBroadcast broadcasts to every client as announcements
+// are, through the hub's priority path
+func BenchmarkPriorityBroadcast(b *testing.B) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+			h, clients := newBenchHub(b, n)
+			benchmarkBroadcast(b, clients, func() {
+				h.SendPriority(&PriorityMessage{Message: benchFrame})
+			}, func(c *Client) int { return len(c.Priority) })
+		})
+	}
+}
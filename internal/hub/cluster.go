@@ -0,0 +1,107 @@
+package hub
+
+import (
+	"realtime-chat/internal/cluster"
+	"realtime-chat/internal/presence"
+)
+
+// JoinCluster shares this node's room broadcasts and priority frames with
+// the other nodes of c, delivers theirs to this node's clients, and
+// announces this node's client counts. It must be called before Run.
+func (h *Hub) JoinCluster(c *cluster.Cluster) error {
+	h.Cluster = c
+	c.Deliver = h.deliverRemote
+	c.Local = h.localNode
+	c.DeliverUser = h.deliverUser
+	h.RoomManager.Relay = func(roomID, author string, message []byte) {
+		c.PublishFrom(roomID, author, message, false)
+	}
+	return c.Start(h.ctx)
+}
+
+// deliverRemote delivers a frame published by another node to the clients
+// connected to this one
+func (h *Hub) deliverRemote(env *cluster.Envelope) {
+	if env.Priority {
+		h.trackAnnouncement(env.Frame)
+		select {
+		case h.Priority <- &PriorityMessage{RoomID: env.RoomID, Message: env.Frame}:
+		case <-h.ctx.Done():
+		}
+		return
+	}
+	if env.RoomID != "" {
+		h.RoomManager.DeliverToRoom(env.RoomID, env.Author, env.Frame)
+	}
+}
+
+// deliverUser delivers a frame forwarded by another node to every client of
+// its user on this node outside workspaces, which only forward the server's
+// own direct messages, and reports whether the user has any
+func (h *Hub) deliverUser(env *cluster.Envelope) bool {
+	// Hold the lock while sending so no client can be unregistered in between
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	delivered := false
+	for client := range h.clients {
+		if client.Name() == env.To && client.Workspace == "" {
+			h.sendTo(client, env.Frame)
+			delivered = true
+		}
+	}
+	return delivered
+}
+
+// localNode returns the clients, room members and user statuses of this
+// node for its heartbeats
+func (h *Hub) localNode() *cluster.Node {
+	node := &cluster.Node{
+		Rooms:   make(map[string]int),
+		Members: make(map[string][]string),
+		Users:   make(map[string]presence.Status),
+	}
+	for _, r := range h.RoomManager.GetRooms() {
+		if members := r.GetClients(); len(members) > 0 {
+			node.Rooms[r.ID] = len(members)
+			node.Members[r.ID] = members
+		}
+	}
+
+	clients := h.connected()
+	node.Clients = len(clients)
+	for _, client := range clients {
+		node.Users[client.Name()] = h.Presence.Get(client.Name())
+	}
+	return node
+}
+
+// presenceChanged tells the other nodes of a cluster, and any other
+// listener, that this node's users, room members or statuses changed
+func (h *Hub) presenceChanged() {
+	if h.Cluster != nil {
+		h.Cluster.Changed()
+	}
+	if h.PresenceChanged != nil {
+		h.PresenceChanged()
+	}
+}
+
+// Online reports whether a user is connected to this node or, in a
+// cluster, to any other node
+func (h *Hub) Online(username string) bool {
+	if len(h.findClients(username)) > 0 {
+		return true
+	}
+	return h.Cluster != nil && len(h.Cluster.Locate(username)) > 0
+}
+
+// ClusterClients returns how many clients are connected across the cluster,
+// or to this node when clustering is off
+func (h *Hub) ClusterClients() int {
+	clients := h.GetClientCount()
+	if h.Cluster != nil {
+		clients += h.Cluster.RemoteClients()
+	}
+	return clients
+}
@@ -0,0 +1,105 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"math"
+	"realtime-chat/internal/history"
+)
+
+// HistoryPage returns a page of a room's history like History.Page, reading
+// on into cold storage when the page reaches past the oldest message left in
+// history or its cursor message was moved there
+func (h *Hub) HistoryPage(ctx context.Context, roomID, before, after string, limit int) ([]*history.Message, bool, error) {
+	limit = pageSize(limit)
+	page, more, err := h.History.Page(roomID, before, after, limit)
+	if h.ColdStorage == nil {
+		return page, more, err
+	}
+
+	if errors.Is(err, history.ErrNotFound) {
+		cursorID := before
+		if after != "" {
+			cursorID = after
+		}
+		cursor, err := h.ColdStorage.Find(ctx, roomID, cursorID)
+		if err != nil {
+			return nil, false, err
+		}
+		if after == "" {
+			return h.ColdStorage.Before(ctx, roomID, cursor.Seq, limit)
+		}
+
+		page, more, err := h.ColdStorage.After(ctx, roomID, cursor.Seq, limit)
+		if err != nil || more {
+			return page, more, err
+		}
+		// Every message left in history is newer than those in cold storage
+		newer, _, newerMore, err := h.History.Since(roomID, cursor.Seq, max(limit-len(page), 1))
+		if err != nil {
+			return nil, false, err
+		}
+		if len(page) == limit {
+			return page, len(newer) > 0, nil
+		}
+		return append(page, newer...), newerMore, nil
+	}
+	if err != nil || after != "" || more {
+		return page, more, err
+	}
+
+	// The page reached the oldest message in history, so older ones are in cold storage
+	seq := int64(math.MaxInt64)
+	switch {
+	case len(page) > 0:
+		seq = page[0].Seq
+	case before != "":
+		cursor, err := h.History.Message(roomID, before)
+		if err != nil {
+			return nil, false, err
+		}
+		seq = cursor.Seq
+	}
+	if len(page) == limit {
+		return page, h.ColdStorage.LastSeq(roomID) > 0, nil
+	}
+	older, more, err := h.ColdStorage.Before(ctx, roomID, seq, limit-len(page))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(older) > 0 {
+		page = append(older, page...)
+	}
+	return page, more, nil
+}
+
+// HistorySince returns the messages of a room posted after a sequence number
+// like History.Since, starting in cold storage when some of them were moved there
+func (h *Hub) HistorySince(ctx context.Context, roomID string, afterSeq int64, limit int) ([]*history.Message, int64, bool, error) {
+	limit = pageSize(limit)
+	if h.ColdStorage == nil || afterSeq >= h.ColdStorage.LastSeq(roomID) {
+		return h.History.Since(roomID, afterSeq, limit)
+	}
+
+	page, more, err := h.ColdStorage.After(ctx, roomID, afterSeq, limit)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	newer, lastSeq, newerMore, err := h.History.Since(roomID, afterSeq, max(limit-len(page), 1))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if more || len(page) == limit {
+		return page, lastSeq, more || len(newer) > 0, nil
+	}
+	return append(page, newer...), lastSeq, newerMore, nil
+}
+
+// pageSize returns the size of a page of history limit asks for, with
+// History's default and cap applied
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return history.DefaultPageSize
+	}
+	return min(limit, history.MaxPageSize)
+}
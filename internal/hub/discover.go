@@ -0,0 +1,175 @@
+package hub
+
+import (
+	"realtime-chat/internal/projection"
+	"realtime-chat/internal/room"
+	"sort"
+	"strings"
+)
+
+// Orders of discovered rooms
+const (
+	SortActive  = "active"  // Most recent message first
+	SortMembers = "members" // Most connected clients first
+	SortNew     = "new"     // Newest room first
+	SortName    = "name"    // Alphabetically by name
+)
+
+// Discovery page sizes
+const (
+	DefaultDiscoverLimit = 20
+	MaxDiscoverLimit     = 100
+)
+
+// Discovery is a room discovery query
+type Discovery struct {
+	Query  string // Words the name, topic, description or tags must all contain
+	Tag    string // Tag the rooms must have
+	Sort   string // SortActive when empty
+	Limit  int    // DefaultDiscoverLimit when 0, at most MaxDiscoverLimit
+	Offset int
+}
+
+// ValidSort reports whether sort names an order of discovered rooms
+func ValidSort(sort string) bool {
+	switch sort {
+	case "", SortActive, SortMembers, SortNew, SortName:
+		return true
+	}
+	return false
+}
+
+// discovered is a room matching a discovery query with what it is sorted by
+type discovered struct {
+	room    *room.Room
+	summary *projection.Summary
+	clients int
+}
+
+// Discover returns a page of a workspace's rooms matching a query, how many
+// match in all, the featured rooms and how many rooms have each tag. Support
+// conversations and archived rooms aren't discoverable. Rooms are entries
+// like RoomList's, with username's unread counts when username is set.
+func (h *Hub) Discover(workspace, username string, q Discovery) map[string]interface{} {
+	words := strings.Fields(strings.ToLower(q.Query))
+	tag := strings.ToLower(strings.TrimPrefix(q.Tag, "#"))
+
+	var matches []discovered
+	featured := make([]map[string]interface{}, 0)
+	tagCounts := make(map[string]int)
+	for _, r := range h.RoomManager.GetRooms() {
+		if r.Workspace != workspace || r.Mode == room.ModeSupport || r.IsGroup() || r.IsArchived() {
+			continue
+		}
+		tags := r.GetTags()
+		for _, t := range tags {
+			tagCounts[t]++
+		}
+
+		summary := h.Projections.Summary(r.ID)
+		if r.IsFeatured() {
+			featured = append(featured, h.roomEntry(r, summary, username))
+		}
+		if !matchesDiscovery(r, tags, words, tag) {
+			continue
+		}
+		clients := summary.MemberCount
+		if h.Cluster != nil {
+			clients += h.Cluster.RemoteRoomClients(r.ID)
+		}
+		matches = append(matches, discovered{room: r, summary: summary, clients: clients})
+	}
+
+	sortDiscovered(matches, q.Sort)
+	sort.Slice(featured, func(i, j int) bool { return featured[i]["name"].(string) < featured[j]["name"].(string) })
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultDiscoverLimit
+	}
+	limit = min(limit, MaxDiscoverLimit)
+	start := min(max(q.Offset, 0), len(matches))
+	end := min(start+limit, len(matches))
+
+	rooms := make([]map[string]interface{}, 0, end-start)
+	for _, d := range matches[start:end] {
+		rooms = append(rooms, h.roomEntry(d.room, d.summary, username))
+	}
+
+	tags := make([]map[string]interface{}, 0, len(tagCounts))
+	for t, count := range tagCounts {
+		tags = append(tags, map[string]interface{}{"tag": t, "count": count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		ci, cj := tags[i]["count"].(int), tags[j]["count"].(int)
+		if ci != cj {
+			return ci > cj
+		}
+		return tags[i]["tag"].(string) < tags[j]["tag"].(string)
+	})
+
+	return map[string]interface{}{
+		"rooms":    rooms,
+		"total":    len(matches),
+		"offset":   start,
+		"limit":    limit,
+		"featured": featured,
+		"tags":     tags,
+	}
+}
+
+// matchesDiscovery reports whether a room has tag, when set, and contains
+// every word in its name, topic, description or tags
+func matchesDiscovery(r *room.Room, tags, words []string, tag string) bool {
+	if tag != "" {
+		found := false
+		for _, t := range tags {
+			found = found || t == tag
+		}
+		if !found {
+			return false
+		}
+	}
+
+	topic, description := r.GetTopic()
+	text := strings.ToLower(strings.Join(append([]string{r.Name, topic, description}, tags...), " "))
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortDiscovered orders discovered rooms, breaking ties by name then ID
+func sortDiscovered(rooms []discovered, order string) {
+	lastActive := func(d discovered) int64 {
+		if d.summary.LastMessage == nil {
+			return d.room.CreatedAt.UnixNano()
+		}
+		return d.summary.LastMessage.Timestamp.UnixNano()
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		a, b := rooms[i], rooms[j]
+		switch order {
+		case SortMembers:
+			if a.clients != b.clients {
+				return a.clients > b.clients
+			}
+		case SortNew:
+			if !a.room.CreatedAt.Equal(b.room.CreatedAt) {
+				return a.room.CreatedAt.After(b.room.CreatedAt)
+			}
+		case SortName:
+		default:
+			if la, lb := lastActive(a), lastActive(b); la != lb {
+				return la > lb
+			}
+		}
+		if na, nb := strings.ToLower(a.room.Name), strings.ToLower(b.room.Name); na != nb {
+			return na < nb
+		}
+		return a.room.ID < b.room.ID
+	})
+}
@@ -0,0 +1,312 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/store"
+	"time"
+)
+
+// DirectMessage is a private message from one user to another
+type DirectMessage struct {
+	Sender  *Client
+	To      string
+	Content string
+
+	// Set on end-to-end encrypted messages, whose Content is ciphertext
+	Encryption *store.Encryption
+
+	// ID of the message in its conversation, once saved
+	messageID string
+}
+
+// dmFrame is the wire format of a delivered direct message
+type dmFrame struct {
+	Type            string `json:"type"`
+	ID              string `json:"id,omitempty"`
+	ConversationID  string `json:"conversationId"`
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Content         string `json:"content"`
+	Timestamp       string `json:"timestamp"`
+	OfflineDelivery bool   `json:"offline_delivery,omitempty"`
+
+	Encryption *store.Encryption `json:"encryption,omitempty"`
+
+	// Content rendered from markdown, unset on encrypted messages
+	HTML     string         `json:"html,omitempty"`
+	Entities []store.Entity `json:"entities,omitempty"`
+}
+
+// roomFrame is the wire format of a mention or other room message
+// delivered after the user reconnects
+type roomFrame struct {
+	Type            string `json:"type"`
+	From            string `json:"from"`
+	To              string `json:"to"`
+	RoomID          string `json:"roomId"`
+	RoomName        string `json:"roomName,omitempty"`
+	MessageID       string `json:"messageId"`
+	Content         string `json:"content"`
+	Timestamp       string `json:"timestamp"`
+	OfflineDelivery bool   `json:"offline_delivery"`
+
+	Encryption *store.Encryption `json:"encryption,omitempty"`
+}
+
+// SendDirect queues a direct message for routing by the hub
+func (h *Hub) SendDirect(dm *DirectMessage) {
+	select {
+	case h.Direct <- dm:
+	case <-h.ctx.Done():
+	}
+}
+
+// routeDirect adds a direct message to the conversation of its sender and
+// recipient and delivers it to every connection of the recipient in the
+// sender's workspace, forwards it to the other nodes of a
+// cluster they are connected to, or stores it for later delivery if the
+// recipient is offline. Direct messages sent in a workspace aren't
+// forwarded, and wait for the recipient to connect to it on this node.
+func (h *Hub) routeDirect(dm *DirectMessage) {
+	// Users who block the sender never get their direct messages, and the
+	// sender isn't told
+	if h.Profiles.Blocks(dm.To, dm.Sender.Username) {
+		h.ackDirect(dm, map[string]interface{}{"type": "dm_sent", "to": dm.To, "delivered": false})
+		return
+	}
+
+	// The message is delivered even if its conversation can't be saved
+	now := time.Now()
+	saved := &history.Message{}
+	if msg, err := h.Conversations.Post(dm.Sender.Workspace, dm.Sender.Username, dm.To, dm.Content, dm.Encryption); err != nil {
+		log.Printf("Error saving direct message to %s: %v", dm.To, err)
+	} else {
+		now, saved = msg.Timestamp, msg
+	}
+	dm.messageID = saved.ID
+
+	frame, _ := json.Marshal(dmFrame{
+		Type:           "dm",
+		ID:             saved.ID,
+		ConversationID: conversation.ID(dm.Sender.Workspace, dm.Sender.Username, dm.To),
+		From:           dm.Sender.Username,
+		To:             dm.To,
+		Content:        dm.Content,
+		Timestamp:      now.Format(time.RFC3339),
+		Encryption:     dm.Encryption,
+		HTML:           saved.HTML,
+		Entities:       saved.Entities,
+	})
+
+	if recipients := h.workspaceClients(dm.To, dm.Sender.Workspace); len(recipients) > 0 {
+		for _, client := range recipients {
+			h.sendTo(client, frame)
+		}
+		h.ackDirect(dm, map[string]interface{}{"type": "dm_sent", "to": dm.To, "delivered": true})
+		return
+	}
+
+	// Forwarding waits for the other nodes, so keep it off the hub's goroutine
+	if h.Cluster != nil && dm.Sender.Workspace == "" {
+		if nodes := h.Cluster.Locate(dm.To); len(nodes) > 0 {
+			go h.forwardDirect(dm, frame, nodes, now)
+			return
+		}
+	}
+
+	h.ackDirect(dm, h.queueDirect(dm, now))
+}
+
+// forwardDirect forwards a direct message to the other nodes the recipient
+// is connected to, and queues it if none of them delivered it, for example
+// because the recipient disconnected in the meantime
+func (h *Hub) forwardDirect(dm *DirectMessage, frame []byte, nodes []string, now time.Time) {
+	delivered := false
+	for _, node := range nodes {
+		ok, err := h.Cluster.Forward(node, dm.To, frame)
+		if err != nil {
+			log.Printf("Error forwarding direct message for %s: %v", dm.To, err)
+		}
+		delivered = delivered || ok
+	}
+
+	if delivered {
+		h.ackDirect(dm, map[string]interface{}{"type": "dm_sent", "to": dm.To, "delivered": true})
+		return
+	}
+	h.ackDirect(dm, h.queueDirect(dm, now))
+}
+
+// queueDirect stores a direct message for an offline recipient and returns
+// the status to acknowledge to the sender with
+func (h *Hub) queueDirect(dm *DirectMessage, now time.Time) map[string]interface{} {
+	if h.store == nil {
+		return map[string]interface{}{"type": "dm_error", "to": dm.To, "message": "User is offline"}
+	}
+
+	err := h.store.QueueMessage(&store.PendingMessage{
+		From:       dm.Sender.Username,
+		To:         dm.To,
+		Content:    dm.Content,
+		Timestamp:  now,
+		MessageID:  dm.messageID,
+		Encryption: dm.Encryption,
+		Workspace:  dm.Sender.Workspace,
+	}, h.config.DM.OfflineQueueLimit)
+
+	switch {
+	case errors.Is(err, store.ErrQueueFull):
+		return map[string]interface{}{"type": "dm_error", "to": dm.To, "message": "User is offline and their message queue is full"}
+	case err != nil:
+		log.Printf("Error queueing direct message for %s: %v", dm.To, err)
+		return map[string]interface{}{"type": "dm_error", "to": dm.To, "message": "Could not queue message"}
+	default:
+		return map[string]interface{}{"type": "dm_sent", "to": dm.To, "queued": true}
+	}
+}
+
+// ackDirect sends the status of a direct message to its sender if they are still connected
+func (h *Hub) ackDirect(dm *DirectMessage, status map[string]interface{}) {
+	statusJSON, _ := json.Marshal(status)
+
+	// Hold the lock while sending so the client can't be unregistered in between
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if _, connected := h.clients[dm.Sender]; connected {
+		h.sendTo(dm.Sender, statusJSON)
+	}
+}
+
+// deliverPending sends a newly connected client the messages queued while
+// they were offline. Messages of other workspaces stay queued.
+func (h *Hub) deliverPending(client *Client) {
+	if h.store == nil {
+		return
+	}
+
+	username := client.Name()
+	messages, err := h.store.TakeMessages(username)
+	if err != nil {
+		log.Printf("Error loading queued messages for %s: %v", username, err)
+		return
+	}
+
+	delivered := 0
+	for _, msg := range messages {
+		if h.pendingWorkspace(msg) != client.Workspace {
+			if err := h.store.QueueMessage(msg, h.config.DM.OfflineQueueLimit); err != nil {
+				log.Printf("Error requeueing message for %s: %v", username, err)
+			}
+			continue
+		}
+		// Messages from users blocked since they were queued are dropped
+		if h.Profiles.Blocks(username, msg.From) {
+			continue
+		}
+		delivered++
+
+		var frame []byte
+		if msg.Kind == store.PendingMention || msg.Kind == store.PendingRoom {
+			var roomName string
+			if r, exists := h.RoomManager.GetRoom(msg.RoomID); exists {
+				roomName = r.Name
+			}
+			frameType := "mention"
+			if msg.Kind == store.PendingRoom {
+				frameType = "missed_message"
+			}
+			frame, _ = json.Marshal(roomFrame{
+				Type:            frameType,
+				From:            msg.From,
+				To:              msg.To,
+				RoomID:          msg.RoomID,
+				RoomName:        roomName,
+				MessageID:       msg.MessageID,
+				Content:         msg.Content,
+				Timestamp:       msg.Timestamp.Format(time.RFC3339),
+				OfflineDelivery: true,
+				Encryption:      msg.Encryption,
+			})
+		} else {
+			frame, _ = json.Marshal(dmFrame{
+				Type:            "dm",
+				ID:              msg.MessageID,
+				ConversationID:  conversation.ID(msg.Workspace, msg.From, msg.To),
+				From:            msg.From,
+				To:              msg.To,
+				Content:         msg.Content,
+				Timestamp:       msg.Timestamp.Format(time.RFC3339),
+				OfflineDelivery: true,
+				Encryption:      msg.Encryption,
+			})
+		}
+		h.sendTo(client, frame)
+	}
+
+	if delivered > 0 {
+		log.Printf("Delivered %d queued messages to %s", delivered, username)
+	}
+}
+
+// pendingWorkspace returns the workspace a queued message is delivered in:
+// that of its room for mentions and room notifications
+func (h *Hub) pendingWorkspace(msg *store.PendingMessage) string {
+	if msg.RoomID == "" {
+		return msg.Workspace
+	}
+	if r, exists := h.RoomManager.GetRoom(msg.RoomID); exists {
+		return r.Workspace
+	}
+	return ""
+}
+
+// expirePending discards queued messages older than the configured TTL
+func (h *Hub) expirePending() {
+	if h.store == nil {
+		return
+	}
+
+	removed, err := h.store.ExpireMessages(time.Now().Add(-h.config.DM.OfflineTTL))
+	if err != nil {
+		log.Printf("Error expiring queued messages: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("Expired %d undelivered direct messages and mentions", removed)
+	}
+}
+
+// findClients returns every connected client with the given username
+func (h *Hub) findClients(username string) []*Client {
+	var clients []*Client
+	for _, client := range h.connected() {
+		if client.Name() == username {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// workspaceClients returns every client connected to a workspace with the
+// given username
+func (h *Hub) workspaceClients(username, workspace string) []*Client {
+	var clients []*Client
+	for _, client := range h.findClients(username) {
+		if client.Workspace == workspace {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// sendTo performs a non-blocking send to a single client, logging the first
+// frame the client drops
+func (h *Hub) sendTo(client *Client, message []byte) {
+	if !client.SendQueue.Offer(client.Send, message) && client.SendQueue.Dropped() == 1 {
+		log.Printf("Dropping messages for client %s: send queue full", client.ID)
+	}
+}
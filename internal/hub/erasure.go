@@ -0,0 +1,210 @@
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/store"
+	"strconv"
+	"time"
+)
+
+// What erasing a user does with their messages
+const (
+	// ErasureAnonymize keeps the messages and reactions under a pseudonym
+	ErasureAnonymize = "anonymize"
+
+	// ErasurePurge removes the messages and reactions entirely
+	ErasurePurge = "purge"
+)
+
+// Erasure reports what was removed when a user's data was erased
+type Erasure struct {
+	Username  string `json:"username"`
+	Pseudonym string `json:"pseudonym"` // Name left on anything kept
+	Mode      string `json:"mode"`
+	Messages  int    `json:"messages"` // Messages purged or anonymized
+
+	// Direct message conversations deleted along with the user
+	Conversations int       `json:"conversations"`
+	Reports       int       `json:"reports"` // Reports that mentioned the user
+	Roles         int       `json:"roles"`   // Role assignments removed
+	Devices       int       `json:"devices"` // Device key bundles removed
+	Queued        int       `json:"queued"`  // Undelivered messages dropped
+	ErasedAt      time.Time `json:"erasedAt"`
+}
+
+// ValidErasureMode reports whether mode is ErasureAnonymize or ErasurePurge
+func ValidErasureMode(mode string) bool {
+	return mode == ErasureAnonymize || mode == ErasurePurge
+}
+
+// EraseUser removes a user from every store: their messages and reactions
+// in every room are anonymized or purged, and their profile, avatar,
+// account, sessions, roles, device keys, statistics and undelivered messages
+// are deleted. Reports and history events that must stay for the record keep
+// a pseudonym instead of the username. The audit log is never rewritten. actor is
+// recorded in the audit log as who erased the user.
+func (h *Hub) EraseUser(username, mode, actor string) (*Erasure, error) {
+	// Erasures rewrite whole histories, so run one at a time
+	h.erasing.Lock()
+	defer h.erasing.Unlock()
+	return h.eraseUser(username, mode, actor)
+}
+
+// eraseUser erases a user; the caller must hold the erasing lock
+func (h *Hub) eraseUser(username, mode, actor string) (*Erasure, error) {
+	erasure := &Erasure{Username: username, Pseudonym: pseudonym(), Mode: mode}
+	purge := mode == ErasurePurge
+
+	// Cold storage goes first so history's reindexing finds it erased
+	var coldRooms []string
+	if h.ColdStorage != nil {
+		erased, rooms, err := h.ColdStorage.EraseUser(h.ctx, username, erasure.Pseudonym, purge)
+		if err != nil {
+			return nil, err
+		}
+		erasure.Messages += erased
+		coldRooms = rooms
+	}
+
+	for _, roomID := range h.RoomIDs() {
+		erased, err := h.History.EraseUser(roomID, username, erasure.Pseudonym, purge)
+		if err != nil {
+			return nil, err
+		}
+		erasure.Messages += erased
+	}
+	if h.Index != nil {
+		for _, roomID := range coldRooms {
+			if conversation.IsID(roomID) {
+				continue
+			}
+			go func() {
+				if _, err := h.reindexRoom(h.ctx, roomID); err != nil {
+					log.Printf("Error reindexing room %s: %v", roomID, err)
+				}
+			}()
+		}
+	}
+
+	h.authored.drop(username)
+
+	// Conversations are named after their users, so they go entirely
+	for _, id := range h.Conversations.Remove(username) {
+		h.History.Forget(id)
+		if h.ColdStorage != nil {
+			if err := h.ColdStorage.Forget(h.ctx, id); err != nil {
+				return nil, err
+			}
+		}
+		if h.store != nil {
+			if _, err := h.store.DeleteEvents(id); err != nil {
+				return nil, err
+			}
+			if err := h.store.DeleteReadMarkers(id); err != nil {
+				return nil, err
+			}
+		}
+		erasure.Conversations++
+	}
+
+	reports, err := h.Reports.EraseUser(username, erasure.Pseudonym, purge)
+	if err != nil {
+		return nil, err
+	}
+	erasure.Reports = reports
+
+	if err := h.Profiles.Delete(username); err != nil {
+		return nil, err
+	}
+
+	// The global role is listed under an empty room ID
+	global, rooms := h.Roles.Assignments()
+	rooms[""] = global
+	for roomID, roles := range rooms {
+		if _, ok := roles[username]; !ok {
+			continue
+		}
+		if err := h.Roles.Assign(roomID, username, ""); err != nil {
+			return nil, err
+		}
+		erasure.Roles++
+	}
+
+	if err := h.Analytics.EraseUser(username); err != nil {
+		return nil, err
+	}
+
+	devices, err := h.Keys.Forget(username)
+	if err != nil {
+		return nil, err
+	}
+	erasure.Devices = devices
+
+	if h.store != nil {
+		queued, err := h.store.TakeMessages(username)
+		if err != nil {
+			return nil, err
+		}
+		erasure.Queued = len(queued)
+	}
+
+	// The account goes last so a failed erasure can be retried from its pending request
+	if err := h.Auth.Delete(username); err != nil {
+		return nil, err
+	}
+	erasure.ErasedAt = time.Now()
+
+	h.Audit.Record(store.AuditRecord{
+		Actor:  actor,
+		Action: audit.ActionEraseUser,
+		Target: username,
+		Details: map[string]string{
+			"mode":          mode,
+			"pseudonym":     erasure.Pseudonym,
+			"messages":      strconv.Itoa(erasure.Messages),
+			"conversations": strconv.Itoa(erasure.Conversations),
+		},
+	})
+
+	// Let clients drop or rename what they have cached
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":      "user_erased",
+		"username":  username,
+		"pseudonym": erasure.Pseudonym,
+		"purged":    purge,
+		"timestamp": getCurrentTime(),
+	})
+	h.SendPriority(&PriorityMessage{Message: frame})
+
+	log.Printf("Erased %s (%s): %d messages, %d reports, %d roles, %d queued messages",
+		username, mode, erasure.Messages, erasure.Reports, erasure.Roles, erasure.Queued)
+	return erasure, nil
+}
+
+// runDeletions erases every account whose deletion grace period is over.
+// Failed erasures stay pending and are retried on the next run, which is
+// skipped while another erasure is still running.
+func (h *Hub) runDeletions() {
+	if !h.erasing.TryLock() {
+		return
+	}
+	defer h.erasing.Unlock()
+
+	for username, mode := range h.Auth.DueDeletions(time.Now()) {
+		if _, err := h.eraseUser(username, mode, "system"); err != nil {
+			log.Printf("Error erasing %s: %v", username, err)
+		}
+	}
+}
+
+// pseudonym returns a random name to leave in place of an erased username
+func pseudonym() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return "deleted-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,66 @@
+package hub
+
+import (
+	"realtime-chat/internal/projection"
+	"realtime-chat/internal/room"
+	"sort"
+	"time"
+)
+
+// Groups returns the room list entries of a user's group conversations in
+// a workspace, each with its participants and the user's unread count, the
+// most recently active first
+func (h *Hub) Groups(workspace, username string) []map[string]interface{} {
+	var groups []*room.Room
+	summaries := make(map[string]*projection.Summary)
+	for _, r := range h.RoomManager.GetRooms() {
+		if r.Workspace == workspace && r.IsGroup() && r.Visible(username) {
+			groups = append(groups, r)
+			summaries[r.ID] = h.Projections.Summary(r.ID)
+		}
+	}
+
+	// Groups nobody has written in yet were active when they were created
+	lastActive := func(r *room.Room) time.Time {
+		if last := summaries[r.ID].LastMessage; last != nil {
+			return last.Timestamp
+		}
+		return r.CreatedAt
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return lastActive(groups[i]).After(lastActive(groups[j]))
+	})
+
+	entries := make([]map[string]interface{}, 0, len(groups))
+	for _, r := range groups {
+		entry := h.roomEntry(r, summaries[r.ID], username)
+		entry["participants"] = r.GetParticipants()
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// AddToGroup makes users participants of a group conversation, counting
+// their unread messages from now on and telling those online, and returns
+// the users who weren't participants already
+func (h *Hub) AddToGroup(r *room.Room, by string, usernames []string) ([]string, error) {
+	added, err := r.AddParticipants(usernames)
+	if err != nil {
+		return nil, err
+	}
+	for _, username := range added {
+		h.Projections.Visit(r.ID, username)
+		if username == by {
+			continue
+		}
+		h.NotifyUser(username, map[string]interface{}{
+			"type":         "group_added",
+			"roomId":       r.ID,
+			"roomName":     r.Name,
+			"participants": r.GetParticipants(),
+			"addedBy":      by,
+			"timestamp":    getCurrentTime(),
+		})
+	}
+	return added, nil
+}
@@ -0,0 +1,18 @@
+package hub
+
+// AllowGuestMessage records a chat message from a user and reports whether
+// it may be sent. Only guests are limited, to GuestRateLimit messages a
+// minute; messages over the limit don't count towards it.
+func (h *Hub) AllowGuestMessage(username string) bool {
+	limit := h.GuestRateLimit()
+	if limit == 0 || !h.Auth.Guest(username) {
+		return true
+	}
+	return h.guestLimits.allow(username, limit)
+}
+
+// GuestRateLimit returns how many messages a minute guests may send, or 0
+// for no limit
+func (h *Hub) GuestRateLimit() int {
+	return h.config.Auth.GuestRateLimit
+}
@@ -1,19 +1,110 @@
 package hub
 
 import (
+	"context"
+	"encoding/json"
 	"log"
+	"net/url"
+	"realtime-chat/hooks"
+	"realtime-chat/internal/analytics"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/auth"
+	"realtime-chat/internal/bot"
+	"realtime-chat/internal/cluster"
+	"realtime-chat/internal/coldstorage"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/digest"
+	"realtime-chat/internal/e2ee"
+	"realtime-chat/internal/email"
+	"realtime-chat/internal/emoji"
+	"realtime-chat/internal/gif"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/maintenance"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/poll"
+	"realtime-chat/internal/presence"
+	"realtime-chat/internal/profile"
+	"realtime-chat/internal/projection"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/report"
 	"realtime-chat/internal/room"
+	"realtime-chat/internal/search"
+	"realtime-chat/internal/spam"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/stream"
+	"realtime-chat/internal/support"
+	"realtime-chat/internal/translate"
+	"realtime-chat/internal/webhook"
+	"realtime-chat/internal/workspace"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Client represents a connected WebSocket client
 type Client struct {
-	ID       string
+	ID string
+
+	// Username is only read on the goroutine handling the client's frames,
+	// since Rename may change it; other goroutines call Name
 	Username string
+
 	Send     chan []byte
+	Priority chan []byte // High-priority admin/system frames, written before Send
 	Hub      *Hub
 	RoomID   string // Current room the client is in
+
+	// How and when the client connected, and the ID of the login session it
+	// connected with, or "" when it didn't log in
+	Transport   string
+	RemoteAddr  string
+	ConnectedAt time.Time
+	Session     string
+
+	// API key the client connected with, or nil
+	APIKey *auth.APIKey
+
+	// Workspace the client connected to; "" for the server's own rooms
+	Workspace string
+
+	// Room the client's embedded chat widget is limited to, or ""
+	EmbedRoom string
+
+	// High-water marks and drops of Send and Priority
+	SendQueue     *metrics.Queue
+	PriorityQueue *metrics.Queue
+
+	// Optional features the client's transport can provide
+	Supported Feature
+
+	// Protocol version and features agreed in the client's hello
+	version  atomic.Int32
+	features atomic.Uint32
+
+	// Set once the client's session has ended
+	ended atomic.Bool
+
+	// Closed when the hub disconnects the client; see Closing
+	closing     chan struct{}
+	closingInit sync.Once
+	closeOnce   sync.Once
+
+	// Set while the client holds a connection slot
+	admitted atomic.Bool
+
+	// Username as other goroutines see it
+	name atomic.Pointer[string]
+
+	// Trace of the connection, to which the spans of the client's frames are
+	// linked, and context of the frame being handled, which is only used on
+	// the goroutine handling the client's frames
+	Connection trace.SpanContext
+	Frame      context.Context
 }
 
 // GetID returns the client ID
@@ -23,21 +114,56 @@ func (c *Client) GetID() string {
 
 // GetUsername returns the client username
 func (c *Client) GetUsername() string {
+	return c.Name()
+}
+
+// Name returns the client's username; unlike Username it may be read on
+// any goroutine
+func (c *Client) Name() string {
+	if name := c.name.Load(); name != nil {
+		return *name
+	}
 	return c.Username
 }
 
+// SetUsername sets the client's username, before it registers or on the
+// goroutine handling its frames
+func (c *Client) SetUsername(username string) {
+	c.name.Store(&username)
+	c.Username = username
+}
+
 // GetSendChannel returns the client's send channel
 func (c *Client) GetSendChannel() chan []byte {
 	return c.Send
 }
 
-// Hub maintains the set of active clients and manages room operations
+// GetPriorityChannel returns the client's high-priority send channel
+func (c *Client) GetPriorityChannel() chan []byte {
+	return c.Priority
+}
+
+// GetQueues returns the records of the client's send and priority queues
+func (c *Client) GetQueues() (send, priority *metrics.Queue) {
+	return c.SendQueue, c.PriorityQueue
+}
+
+// PriorityMessage is an admin, moderation or system frame that is delivered
+// ahead of regular user traffic
+type PriorityMessage struct {
+	RoomID  string // Target room, or empty for every connected client
+	Message []byte
+}
+
+// Hub maintains the set of active clients and manages room operations.
+// All chat traffic flows through rooms; new clients start in the lobby.
 type Hub struct {
-	// Registered clients
+	// Registered clients, guarded by mutex
 	clients map[*Client]bool
 
-	// Channel for broadcasting messages to all clients
-	broadcast chan []byte
+	// Copy-on-write snapshot of clients, replaced whenever one registers or
+	// unregisters, so broadcasts read it without holding mutex
+	snapshot atomic.Pointer[[]*Client]
 
 	// Channel for registering new clients
 	Register chan *Client
@@ -45,88 +171,633 @@ type Hub struct {
 	// Channel for unregistering clients
 	Unregister chan *Client
 
-	// Channel for broadcasting messages
-	Broadcast chan []byte
+	// Channel for high-priority messages, always served before other channels
+	Priority chan *PriorityMessage
+
+	// Channel for routing direct messages between users
+	Direct chan *DirectMessage
 
 	// Room manager for handling multiple rooms
 	RoomManager *room.Manager
 
+	// Message history of every room, including edits, deletions and reactions
+	History *history.History
+
+	// Direct message histories between each pair of users
+	Conversations *conversation.Conversations
+
+	// Delivers message changes to rooms' webhooks
+	Webhooks *webhook.Dispatcher
+
+	// Custom emoji and stickers usable in messages and reactions
+	Emoji *emoji.Registry
+
+	// GIF search of the configured provider; nil when GIF search is off
+	GIFs *gif.Client
+
+	// Translates messages on request; nil when translation is off
+	Translator *translate.Client
+
+	// Isolated communities hosted alongside the server's own rooms
+	Workspaces *workspace.Registry
+
+	// Polls of every room with their votes
+	Polls *poll.Polls
+
+	// Public keys of the devices of users who encrypt end to end
+	Keys *e2ee.Directory
+
+	// Users' display names, bios, statuses and avatars
+	Profiles *profile.Profiles
+
+	// Accounts created by OAuth2 logins and their sessions
+	Auth *auth.Auth
+
+	// Global and per-room roles deciding what each user may do
+	Roles *rbac.Roles
+
+	// Messages reported by users, queued for moderators
+	Reports *report.Reports
+
+	// Append-only record of moderation and admin actions
+	Audit *audit.Log
+
+	// Counts messages, active users and connections by the hour
+	Analytics *analytics.Analytics
+
+	// Spots repeated messages, shouting, link spam and room hopping
+	Spam *spam.Detector
+
+	// Messages recently sent by guests and requests made with API keys, to
+	// rate-limit them
+	guestLimits *minuteLimiter
+	keyLimits   *minuteLimiter
+
+	// Masks or refuses profanity in rooms whose settings ask for it
+	profanity *profanityFilter
+
+	// Usernames of connected clients that didn't log in
+	nicknames *nicknames
+
+	// Presence status of every user, shown with presence events and member lists
+	Presence *presence.Statuses
+
+	// Read models of every room, kept current for fast room lists
+	Projections *projection.Projections
+
+	// Sends transcripts and CRM summaries when support conversations end
+	Support *support.Closer
+
+	// Emails offline users digests of missed direct messages and mentions
+	Digests *digest.Digester
+
+	// Scheduled cleanup of tombstones and data left by deleted rooms
+	Maintenance *maintenance.Cleaner
+
+	// Slash commands registered by bots
+	Commands *bot.Registry
+
+	// Operator middleware run on connections, messages, room joins and disconnections
+	Hooks *hooks.Registry
+
+	// Records client input and deliveries for replay; nil when not recording
+	Recorder *replay.Recorder
+
+	// Shares broadcasts and presence counts with other nodes; nil when clustering is off
+	Cluster *cluster.Cluster
+
+	// Told whenever users connect, disconnect, change rooms or set their
+	// status, such as to share presence with federated servers; it must not
+	// block, and is nil when nothing listens
+	PresenceChanged func()
+
+	// Exports chat events to Kafka; nil when no brokers are configured
+	Stream *stream.Exporter
+
+	// Finds messages in rooms' history, through Index when it is configured
+	Search search.Searcher
+
+	// Indexes rooms' messages in Elasticsearch or OpenSearch; nil when no
+	// cluster is configured
+	Index *search.Elastic
+
+	// Moves rooms' old messages into an S3-compatible bucket and reads them
+	// back; nil when no bucket is configured
+	ColdStorage *coldstorage.Tier
+
 	// Mutex for thread-safe operations
 	mutex sync.RWMutex
-}
 
-// NewHub creates a new hub instance
-func NewHub() *Hub {
-	roomManager := room.NewManager()
+	// Server configuration
+	config *config.Config
 
-	// Start the room manager in a goroutine
-	go roomManager.Run()
+	// Persistent storage; may be nil
+	store store.Store
+
+	// Held while a user's data is erased
+	erasing sync.Mutex
+
+	// Messages of rooms and conversations by their author
+	authored *authored
+
+	// Active announcements, shown to clients as they connect
+	announcing    sync.Mutex
+	announcements []*Announcement
+
+	// Set while the server is shedding load
+	overloaded atomic.Bool
+
+	// Connection slots taken, at most Overload.MaxConnections, and whether
+	// the last client asking for one was refused
+	connections atomic.Int64
+	atLimit     atomic.Bool
 
-	return &Hub{
+	// Context cancelled when the hub is stopped
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Closed once Run has returned
+	stopped chan struct{}
+}
+
+// NewHub creates a new hub instance whose lifetime is bound to ctx.
+// Rooms saved in st are restored before the hub starts.
+func NewHub(ctx context.Context, cfg *config.Config, st store.Store) *Hub {
+	ctx, cancel := context.WithCancel(ctx)
+	roomManager := room.NewManager(ctx)
+	roomManager.Store = st
+
+	h := &Hub{
 		clients:     make(map[*Client]bool),
-		broadcast:   make(chan []byte),
 		Register:    make(chan *Client),
 		Unregister:  make(chan *Client),
-		Broadcast:   make(chan []byte),
+		Priority:    make(chan *PriorityMessage, 64),
+		Direct:      make(chan *DirectMessage),
 		RoomManager: roomManager,
+		History:     history.New(st),
+		Polls:       poll.New(st),
+		Keys:        e2ee.New(st),
+		Profiles:    profile.New(st),
+		Presence:    presence.New(),
+		Auth:        auth.New(st, cfg.Auth),
+		Roles:       rbac.New(st, rbac.Role(cfg.DefaultRole)),
+		Reports:     report.New(st),
+		Audit:       audit.New(st),
+		Analytics:   analytics.New(st),
+		Spam:        spam.New(cfg.Spam),
+		guestLimits: newMinuteLimiter(),
+		profanity:   newProfanityFilter(cfg.ProfanityWords),
+		keyLimits:   newMinuteLimiter(),
+		nicknames:   &nicknames{taken: make(map[string]string)},
+		authored:    newAuthored(),
+		Emoji:       emoji.NewRegistry(st),
+		GIFs:        gif.New(cfg.GIF),
+		Translator:  translate.New(cfg.Translate),
+		Workspaces:  workspace.NewRegistry(st, cfg.WorkspaceDomain),
+		Webhooks:    webhook.NewDispatcher(ctx),
+		Stream:      stream.New(ctx, cfg.Kafka),
+		Index:       search.NewElastic(ctx, cfg.Search),
+		Commands:    bot.NewRegistry(),
+		Hooks:       hooks.New(),
+		config:      cfg,
+		store:       st,
+		ctx:         ctx,
+		cancel:      cancel,
+		stopped:     make(chan struct{}),
+	}
+
+	// Rooms pause non-essential notices while the hub is overloaded
+	roomManager.Overloaded = h.IsOverloaded
+	roomManager.Presence = h.Presence.Get
+	roomManager.Blocked = h.Profiles.Blocks
+
+	// History events are always hash-chained, and signed when there's a key
+	if cfg.HistoryKey != nil {
+		h.History.SignWith(cfg.HistoryKey)
+	}
+	if !cfg.Maintenance.SoftDelete {
+		h.History.PurgeDeleted()
+	}
+
+	// Large rooms share workers to deliver their broadcasts
+	if cfg.Fanout.Workers > 0 {
+		roomManager.Fanout = room.NewFanout(ctx, cfg.Fanout.Workers, cfg.Fanout.Threshold)
+	}
+
+	// Elevated roles need an account, which may have to log in with a second
+	// factor, guests are restricted, and room creators administer their rooms
+	h.Roles.Claimed = h.Auth.Claimed
+	h.Roles.Owner = func(roomID, username string) bool {
+		r, exists := roomManager.GetRoom(roomID)
+		return exists && r.CreatedBy == username && username != room.SystemUser
+	}
+	roomManager.Moderator = func(roomID, username string) bool {
+		return h.Roles.Can(username, roomID, rbac.PermModerate)
 	}
+	h.Auth.Privileged = h.Roles.Elevated
+	h.Roles.Guest = h.Auth.Guest
+
+	// Keep room projections in step with history and membership
+	h.Projections = projection.New(h.History, st)
+	h.Conversations = conversation.New(h.History, h.Projections)
+	h.History.Observe(h.notifyWebhook)
+	h.Profiles.Observe(h.broadcastProfile)
+	h.History.Observe(h.queueNotifications)
+	h.History.Observe(h.unpinRemoved)
+	h.History.Observe(h.authored.observe)
+
+	// Count messages in rooms, not direct message conversations
+	h.Analytics.Workspace = func(roomID string) (string, bool) {
+		if r, exists := roomManager.GetRoom(roomID); exists {
+			return r.Workspace, true
+		}
+		return "", false
+	}
+	h.History.Observe(h.Analytics.Observe)
+	h.Maintenance = maintenance.New(h.History, st, cfg.Maintenance.TombstoneRetention)
+	h.Maintenance.Policy = h.RetentionPolicy
+	h.ColdStorage = coldstorage.New(cfg.ColdStorage, h.History, st)
+	if h.ColdStorage != nil {
+		h.ColdStorage.Policy = h.RetentionPolicy
+		h.Projections.Cold = h.ColdStorage.Count
+	}
+	sender := email.New(cfg.Email)
+	h.Support = support.NewCloser(h.History, sender, cfg.Support.CRMWebhook)
+	h.Digests = digest.New(st, h.Profiles, sender, cfg.Digest.After)
+	h.Digests.RoomName = func(roomID string) string {
+		if r, exists := roomManager.GetRoom(roomID); exists {
+			return r.Name
+		}
+		return ""
+	}
+	roomManager.OnMembership = func(roomID, username string, joined bool) {
+		if joined {
+			h.Projections.Joined(roomID, username)
+			h.Analytics.Joined(roomID, username, time.Now())
+		} else {
+			h.Projections.Left(roomID, username)
+			h.supportLeft(roomID, username)
+		}
+		if h.Stream != nil {
+			h.streamMembership(roomID, username, joined)
+		}
+		h.presenceChanged()
+	}
+
+	// Stream messages, membership and moderation to Kafka when it is configured
+	if h.Stream != nil {
+		h.History.Observe(h.streamMessage)
+		h.Audit.Observe(h.streamModeration)
+	}
+
+	// Search the index when there is one, and the history in memory otherwise
+	h.Search = search.NewScan(h.History)
+	if h.Index != nil {
+		h.Search = h.Index
+		h.History.Observe(h.indexMessage)
+	}
+
+	// Recreate rooms that existed before the last restart
+	if err := roomManager.RestoreRooms(); err != nil {
+		log.Printf("Error restoring rooms: %v", err)
+	}
+
+	if err := h.Workspaces.Load(); err != nil {
+		log.Printf("Error loading workspaces: %v", err)
+	}
+
+	// Every client joins the lobby of its workspace when it connects
+	roomManager.EnsureLobby("")
+	for _, ws := range h.Workspaces.List() {
+		roomManager.EnsureLobby(ws.ID)
+	}
+
+	// Load room histories so disappearing messages are purged on time
+	for _, r := range roomManager.GetRooms() {
+		if err := h.History.Load(r.ID); err != nil {
+			log.Printf("Error loading history for room %s: %v", r.ID, err)
+		}
+	}
+
+	if err := h.Emoji.Load(); err != nil {
+		log.Printf("Error loading custom emoji: %v", err)
+	}
+
+	// Restore polls so votes survive restarts
+	if err := h.Polls.Load(); err != nil {
+		log.Printf("Error loading polls: %v", err)
+	}
+
+	if err := h.Profiles.Load(); err != nil {
+		log.Printf("Error loading profiles: %v", err)
+	}
+
+	if err := h.Keys.Load(); err != nil {
+		log.Printf("Error loading device keys: %v", err)
+	}
+
+	if err := h.Auth.Load(); err != nil {
+		log.Printf("Error loading accounts: %v", err)
+	}
+
+	if err := h.Roles.Load(); err != nil {
+		log.Printf("Error loading roles: %v", err)
+	}
+
+	if err := h.Reports.Load(); err != nil {
+		log.Printf("Error loading reports: %v", err)
+	}
+
+	if err := h.Audit.Load(); err != nil {
+		log.Printf("Error loading audit log: %v", err)
+	}
+
+	if err := h.Analytics.Load(); err != nil {
+		log.Printf("Error loading statistics: %v", err)
+	}
+
+	if h.ColdStorage != nil {
+		if err := h.ColdStorage.Load(); err != nil {
+			log.Printf("Error loading cold storage segments: %v", err)
+		}
+	}
+
+	if err := h.Conversations.Load(st); err != nil {
+		log.Printf("Error loading conversations: %v", err)
+	}
+
+	// Build the projections from the loaded histories
+	h.Projections.Rebuild(h.HistoryIDs())
+
+	// Start the room manager in a goroutine
+	go roomManager.Run()
+
+	return h
 }
 
-// Run starts the hub and handles client registration/unregistration and message broadcasting
+// RoomIDs returns the IDs of every room
+func (h *Hub) RoomIDs() []string {
+	rooms := h.RoomManager.GetRooms()
+	ids := make([]string, 0, len(rooms))
+	for _, r := range rooms {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+// HistoryIDs returns the IDs of every room and direct message conversation,
+// whose histories are kept alike
+func (h *Hub) HistoryIDs() []string {
+	return append(h.RoomIDs(), h.Conversations.IDs()...)
+}
+
+// Run starts the hub and handles client registration/unregistration and message broadcasting.
+// It returns once the hub's context is cancelled.
 func (h *Hub) Run() {
+	defer close(h.stopped)
+
+	ticker := time.NewTicker(h.config.Overload.CheckInterval)
+	defer ticker.Stop()
+
+	expiry := time.NewTicker(time.Minute)
+	defer expiry.Stop()
+
+	disappear := time.NewTicker(time.Second)
+	defer disappear.Stop()
+
+	cleanup := time.NewTicker(h.config.Maintenance.Interval)
+	defer cleanup.Stop()
+
+	digests := time.NewTicker(h.config.Digest.Interval)
+	defer digests.Stop()
+
 	for {
+		// Serve pending high-priority messages before anything else
+		select {
+		case msg := <-h.Priority:
+			h.deliverPriority(msg)
+			continue
+		default:
+		}
+
 		select {
+		case msg := <-h.Priority:
+			h.deliverPriority(msg)
+
+		case <-ticker.C:
+			h.checkOverload()
+
+		case <-expiry.C:
+			h.expirePending()
+			if _, err := h.Auth.ExpireSessions(); err != nil {
+				log.Printf("Error expiring sessions: %v", err)
+			}
+			h.Spam.Prune()
+			h.guestLimits.prune()
+			h.keyLimits.prune()
+			// Erasures rewrite histories, so keep them off the hub's goroutine
+			go h.runDeletions()
+			go h.Analytics.Sample(time.Now(), h.sampleConnections())
+
+		case <-disappear.C:
+			h.expireMessages()
+
+		case <-cleanup.C:
+			// Cleanup touches every room's history, so keep it off the hub's goroutine
+			go h.runMaintenance()
+
+		case <-digests.C:
+			// Digests wait on the mail server, so send them in the background
+			if h.Digests.Enabled() {
+				go h.sendDigests()
+			}
+
+		case dm := <-h.Direct:
+			h.routeDirect(dm)
+
+		case <-h.ctx.Done():
+			// Tell everyone the server is going away before closing connections
+			shutdownMsg := []byte(`{"type":"shutdown","message":"Server is shutting down","timestamp":"` + getCurrentTime() + `"}`)
+			h.deliverPriority(&PriorityMessage{Message: shutdownMsg})
+
+			// Stop the rooms first so nothing writes to a closed send channel
+			h.RoomManager.Stop()
+			h.closeAllClients()
+
+			// Keep the messages counted since the last sample
+			if err := h.Analytics.Flush(); err != nil {
+				log.Printf("Error saving statistics: %v", err)
+			}
+			log.Println("Hub stopped")
+			return
+
 		case client := <-h.Register:
 			h.mutex.Lock()
 			h.clients[client] = true
+			h.resubscribe()
 			h.mutex.Unlock()
 
 			log.Printf("Client %s (%s) connected. Total clients: %d",
-				client.ID, client.Username, len(h.clients))
+				client.ID, client.Name(), len(h.clients))
+			h.presenceChanged()
 
-			// Send welcome message
-			welcomeMsg := []byte(`{"type":"system","message":"` + client.Username + ` joined the chat","timestamp":"` + getCurrentTime() + `"}`)
-			h.broadcastMessage(welcomeMsg, client)
+			// Deliver direct messages that arrived while the user was offline
+			h.deliverPending(client)
+			h.sendAnnouncements(client)
 
 		case client := <-h.Unregister:
 			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
+			_, ok := h.clients[client]
+			if ok {
 				delete(h.clients, client)
+				h.resubscribe()
 				close(client.Send)
 			}
 			h.mutex.Unlock()
+			h.Release(client)
 
-			log.Printf("Client %s (%s) disconnected. Total clients: %d",
-				client.ID, client.Username, len(h.clients))
+			// Only clients that were still registered have left
+			if ok {
+				log.Printf("Client %s (%s) disconnected. Total clients: %d",
+					client.ID, client.Name(), len(h.clients))
+				h.presenceChanged()
+			}
+		}
+	}
+}
 
-			// Send goodbye message
-			goodbyeMsg := []byte(`{"type":"system","message":"` + client.Username + ` left the chat","timestamp":"` + getCurrentTime() + `"}`)
-			h.broadcastMessage(goodbyeMsg, nil)
+// expireMessages purges disappearing messages that have expired and tells
+// their rooms so clients remove them too
+func (h *Hub) expireMessages() {
+	expired, err := h.History.Expire(time.Now())
+	if err != nil {
+		log.Printf("Error purging expired messages: %v", err)
+	}
 
-		case message := <-h.Broadcast:
-			h.broadcastMessage(message, nil)
-		}
+	for _, e := range expired {
+		event, _ := json.Marshal(map[string]interface{}{
+			"type":      "message_expired",
+			"roomId":    e.RoomID,
+			"messageId": e.MessageID,
+		})
+		h.RoomManager.BroadcastToRoom(e.RoomID, event, nil)
 	}
 }
 
-// broadcastMessage sends a message to all connected clients
-func (h *Hub) broadcastMessage(message []byte, sender *Client) {
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
+// runMaintenance runs the scheduled cleanup
+func (h *Hub) runMaintenance() {
+	if _, err := h.Maintenance.Run(h.HistoryIDs); err != nil {
+		log.Printf("Error running maintenance: %v", err)
+	}
+	if h.ColdStorage != nil {
+		h.ColdStorage.Compact(h.ctx, h.HistoryIDs())
+	}
+}
+
+// sendDigests emails digests of missed messages to users who are offline
+func (h *Hub) sendDigests() {
+	if _, err := h.Digests.Run(h.Online); err != nil {
+		log.Printf("Error sending digests: %v", err)
+	}
+}
+
+// closeAllClients closes every client's send channel so their connections shut down
+func (h *Hub) closeAllClients() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	clients := h.clients
+	h.clients = make(map[*Client]bool)
+	h.resubscribe()
+	for client := range clients {
+		close(client.Send)
+	}
+}
+
+// connected returns the clients registered when the hub last registered or
+// unregistered one. The slice is shared and must not be modified.
+func (h *Hub) connected() []*Client {
+	if snapshot := h.snapshot.Load(); snapshot != nil {
+		return *snapshot
+	}
+	return nil
+}
+
+// sampleConnections returns every connected client once for its workspace
+// and once for the room it is in, for sampling statistics
+func (h *Hub) sampleConnections() []analytics.Connection {
+	var conns []analytics.Connection
+	for _, client := range h.connected() {
+		conns = append(conns, analytics.Connection{Workspace: client.Workspace, Username: client.Name()})
+	}
+	for _, r := range h.RoomManager.GetRooms() {
+		for _, client := range r.Subscribers() {
+			conns = append(conns, analytics.Connection{Workspace: r.Workspace, RoomID: r.ID, Username: client.Username})
+		}
+	}
+	return conns
+}
 
+// resubscribe replaces the snapshot of registered clients; the caller holds
+// mutex for writing
+func (h *Hub) resubscribe() {
+	clients := make([]*Client, 0, len(h.clients))
 	for client := range h.clients {
-		// Don't send the message back to the sender
-		if sender != nil && client == sender {
-			continue
+		clients = append(clients, client)
+	}
+	h.snapshot.Store(&clients)
+}
+
+// Stop cancels the hub's context and waits for the hub and its rooms to stop
+func (h *Hub) Stop() {
+	h.cancel()
+	<-h.stopped
+}
+
+// Done returns a channel that is closed when the hub is stopped
+func (h *Hub) Done() <-chan struct{} {
+	return h.ctx.Done()
+}
+
+// SendPriority queues a high-priority message for delivery by the hub, on
+// every node of a cluster
+func (h *Hub) SendPriority(msg *PriorityMessage) {
+	if h.Cluster != nil {
+		h.Cluster.Publish(msg.RoomID, msg.Message, true)
+	}
+	select {
+	case h.Priority <- msg:
+	case <-h.ctx.Done():
+	}
+}
+
+// deliverPriority writes a high-priority message to the priority channel of
+// every client in the target room, or of every client when no room is set.
+// It reads snapshots of the clients, so no lock is held while writing;
+// priority channels are never closed.
+func (h *Hub) deliverPriority(msg *PriorityMessage) {
+	if msg.RoomID != "" {
+		r, exists := h.RoomManager.GetRoom(msg.RoomID)
+		if !exists {
+			return
 		}
 
-		select {
-		case client.Send <- message:
-		default:
-			// If client's send channel is full, close the connection
-			close(client.Send)
-			delete(h.clients, client)
+		for _, client := range r.Subscribers() {
+			deliverTo(client.Priority, client.PriorityQueue, msg.Message, client.ID)
 		}
+		return
+	}
+
+	for _, client := range h.connected() {
+		deliverTo(client.Priority, client.PriorityQueue, msg.Message, client.ID)
+	}
+}
+
+// deliverTo performs a non-blocking send on a client's priority channel,
+// logging the first frame the client drops
+func deliverTo(priority chan []byte, queue *metrics.Queue, message []byte, clientID string) {
+	if !queue.Offer(priority, message) && queue.Dropped() == 1 {
+		log.Printf("Dropping priority messages for client %s: priority queue full", clientID)
 	}
 }
 
@@ -137,6 +808,29 @@ func (h *Hub) GetClientCount() int {
 	return len(h.clients)
 }
 
+// Connection returns the limits and keepalive settings of client connections
+func (h *Hub) Connection() config.ConnectionConfig {
+	return h.config.Connection
+}
+
+// SiteOrigin reports whether a browser origin is one the chat is served
+// at: that of the public URL or the local network URL, or a workspace's
+// subdomain
+func (h *Hub) SiteOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, site := range []string{h.config.Auth.PublicURL, h.config.NetworkURL} {
+		if s, err := url.Parse(site); err == nil && s.Host != "" &&
+			strings.EqualFold(s.Scheme, u.Scheme) && strings.EqualFold(s.Host, u.Host) {
+			return true
+		}
+	}
+	id, ok := h.Workspaces.FromHost(u.Host)
+	return id != "" && ok
+}
+
 // getCurrentTime returns the current timestamp
 func getCurrentTime() string {
 	return time.Now().Format(time.RFC3339)
@@ -0,0 +1,102 @@
+package hub
+
+import (
+	"context"
+	"path/filepath"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/store"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// newTestHub returns a hub bound to ctx over an empty memory store
+func newTestHub(t *testing.T, ctx context.Context) *Hub {
+	t.Helper()
+	st, err := store.NewMemoryStore(filepath.Join(t.TempDir(), "snapshot.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHub(ctx, config.Default(), st)
+}
+
+// checkNoLeaks fails the test unless the goroutines started after baseline
+// have returned
+func checkNoLeaks(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines left running, %d before the hub started:\n%s",
+				runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := room.RunningRooms(); n != 0 {
+		t.Fatalf("%d room goroutines still running", n)
+	}
+}
+
+// newTestClient returns a client as the WebSocket handler creates them
+func newTestClient(h *Hub, id, username string) *Client {
+	c := &Client{
+		ID:            id,
+		Send:          make(chan []byte, 64),
+		Priority:      make(chan []byte, 64),
+		Hub:           h,
+		SendQueue:     metrics.NewSendQueue(),
+		PriorityQueue: metrics.NewPriorityQueue(),
+	}
+	c.SetUsername(username)
+	return c
+}
+
+func TestStopLeaksNoGoroutines(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	h := newTestHub(t, context.Background())
+	go h.Run()
+
+	var ids []string
+	for range 5 {
+		ids = append(ids, h.RoomManager.CreateRoomAsync("", "room", room.SystemUser, room.ModeNormal, false))
+	}
+	client := newTestClient(h, "c1", "alice")
+	h.Register <- client
+	if resp := h.RoomManager.JoinRoomAsync(client, ids[0]); !resp.Success {
+		t.Fatalf("join failed: %s", resp.Message)
+	}
+
+	h.Stop()
+	if n := h.RoomManager.GetRoomCount(); n != 0 {
+		t.Fatalf("%d rooms left after the hub stopped", n)
+	}
+
+	// Clients are told the server is going away and their send channels closed
+	select {
+	case <-client.Priority:
+	default:
+		t.Fatal("client wasn't sent the shutdown notice")
+	}
+	for range client.Send {
+	}
+	checkNoLeaks(t, baseline)
+}
+
+func TestCancelledContextStopsHub(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	h := newTestHub(t, ctx)
+	go h.Run()
+	h.RoomManager.CreateRoomAsync("", "room", room.SystemUser, room.ModeNormal, false)
+
+	cancel()
+	select {
+	case <-h.stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("hub still running after its context was cancelled")
+	}
+	checkNoLeaks(t, baseline)
+}
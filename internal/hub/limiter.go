@@ -0,0 +1,58 @@
+package hub
+
+import (
+	"sync"
+	"time"
+)
+
+// limitWindow is the period messages and requests are counted over
+const limitWindow = time.Minute
+
+// minuteLimiter counts what each guest or API key sent recently
+type minuteLimiter struct {
+	mutex sync.Mutex
+	sent  map[string][]time.Time // username or key ID -> times of recent sends, oldest first
+}
+
+// newMinuteLimiter creates an empty limiter
+func newMinuteLimiter() *minuteLimiter {
+	return &minuteLimiter{sent: make(map[string][]time.Time)}
+}
+
+// allow records a send by id and reports whether it is within limit sends
+// a minute. Sends over the limit don't count towards it.
+func (l *minuteLimiter) allow(id string, limit int) bool {
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	sent := trimBefore(l.sent[id], now.Add(-limitWindow))
+	if len(sent) >= limit {
+		l.sent[id] = sent
+		return false
+	}
+	l.sent[id] = append(sent, now)
+	return true
+}
+
+// prune forgets those that sent nothing within the window
+func (l *minuteLimiter) prune() {
+	cutoff := time.Now().Add(-limitWindow)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for id, sent := range l.sent {
+		if len(trimBefore(sent, cutoff)) == 0 {
+			delete(l.sent, id)
+		}
+	}
+}
+
+// trimBefore drops times before cutoff
+func trimBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
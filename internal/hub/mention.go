@@ -0,0 +1,85 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/profile"
+	"realtime-chat/internal/store"
+	"regexp"
+)
+
+// mentionPattern matches @username in message content
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]+)`)
+
+// Mentions returns the distinct usernames mentioned in content, in order
+func Mentions(content string) []string {
+	var mentions []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		if username := match[1]; !seen[username] {
+			seen[username] = true
+			mentions = append(mentions, username)
+		}
+	}
+	return mentions
+}
+
+// queueNotifications stores a new room message for every user with no
+// active connection who should be notified of it, so it is delivered when
+// they next connect. Users are notified according to their level for the
+// room: of mentions by default, of every message, or not at all when muted.
+// Only users who have visited the room are queued, so stray @words don't
+// fill the queue. Mentions in end-to-end encrypted messages can't be read,
+// so those only notify users who get every message.
+func (h *Hub) queueNotifications(event *store.MessageEvent, _ *history.Message) {
+	// Direct messages are queued as they are sent
+	if event.Type != history.EventMessage || h.store == nil || conversation.IsID(event.RoomID) {
+		return
+	}
+
+	mentioned := make(map[string]bool)
+	if event.Encryption == nil {
+		for _, username := range Mentions(event.Content) {
+			mentioned[username] = true
+		}
+	}
+
+	for username := range h.Projections.Summary(event.RoomID).Unread {
+		if username == event.Username || h.Profiles.Blocks(username, event.Username) {
+			continue
+		}
+
+		var kind string
+		switch level := h.Profiles.NotificationLevel(username, event.RoomID); {
+		case level == profile.LevelMuted:
+			continue
+		case mentioned[username]:
+			kind = store.PendingMention
+		case level == profile.LevelAll:
+			kind = store.PendingRoom
+		default:
+			continue
+		}
+		if h.Online(username) {
+			continue
+		}
+
+		err := h.store.QueueMessage(&store.PendingMessage{
+			Kind:       kind,
+			From:       event.Username,
+			To:         username,
+			Content:    event.Content,
+			Timestamp:  event.Timestamp,
+			RoomID:     event.RoomID,
+			MessageID:  event.MessageID,
+			Encryption: event.Encryption,
+		}, h.config.DM.OfflineQueueLimit)
+		if errors.Is(err, store.ErrQueueFull) {
+			log.Printf("Dropping notification for %s: their message queue is full", username)
+		} else if err != nil {
+			log.Printf("Error queueing notification for %s: %v", username, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxUsernameLength is the longest username a client may rename itself to
+const MaxUsernameLength = 32
+
+// Errors returned by Rename
+var (
+	ErrUsernameInvalid  = fmt.Errorf("a username needs 1 to %d characters and no spaces or '@'", MaxUsernameLength)
+	ErrUsernameTaken    = errors.New("that username is in use")
+	ErrUsernameReserved = errors.New("that username is reserved; log in to use it")
+	ErrUsernameFixed    = errors.New("your username is your account's; change your display name in your profile instead")
+	ErrUsernameMuted    = errors.New("you can't change your username while muted")
+)
+
+// nicknames holds the usernames of connected clients that didn't log in,
+// so that no two of them share one
+type nicknames struct {
+	mutex sync.Mutex
+	taken map[string]string // Lower-cased username -> ID of the client using it
+}
+
+// TakeUsername picks the username of a client that didn't log in: username
+// itself, or with -2, -3, ... added when another connected client uses it
+// in any letter case. The username is held for the client until it is
+// released.
+func (h *Hub) TakeUsername(c *Client, username string) string {
+	n := h.nicknames
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	candidate := username
+	for i := 2; h.usernameTaken(c, candidate) || (candidate != username && h.Auth.Reserved(candidate)); i++ {
+		candidate = username + "-" + strconv.Itoa(i)
+	}
+	n.taken[strings.ToLower(candidate)] = c.ID
+	return candidate
+}
+
+// Rename changes the username of a client that didn't log in and tells the
+// room it is in. Logged-in users, guests and service accounts keep their
+// account's or guest identity's name. It is called on the goroutine
+// handling the client's frames.
+func (h *Hub) Rename(c *Client, username string) error {
+	old := c.Username
+	switch {
+	case c.Session != "" || c.APIKey != nil:
+		return ErrUsernameFixed
+	case !ValidUsername(username):
+		return ErrUsernameInvalid
+	case username == old:
+		return nil
+	case h.Auth.Reserved(username):
+		return ErrUsernameReserved
+	}
+
+	// Muted users would otherwise get their voice back under a new name
+	r, inRoom := h.RoomManager.GetRoom(c.RoomID)
+	if inRoom {
+		if _, muted := r.MutedUntil(old); muted {
+			return ErrUsernameMuted
+		}
+	}
+
+	n := h.nicknames
+	n.mutex.Lock()
+	if h.usernameTaken(c, username) {
+		n.mutex.Unlock()
+		return ErrUsernameTaken
+	}
+	if n.taken[strings.ToLower(old)] == c.ID {
+		delete(n.taken, strings.ToLower(old))
+	}
+	n.taken[strings.ToLower(username)] = c.ID
+	c.SetUsername(username)
+	n.mutex.Unlock()
+
+	if inRoom && r.Rename(c.ID, username) {
+		h.Projections.Left(r.ID, old)
+		h.Projections.Joined(r.ID, username)
+	}
+	h.presenceChanged()
+	log.Printf("Client %s renamed from %s to %s", c.ID, old, username)
+
+	renamed, _ := json.Marshal(map[string]interface{}{
+		"type":        "renamed",
+		"oldUsername": old,
+		"username":    username,
+		"timestamp":   getCurrentTime(),
+	})
+	if inRoom {
+		h.RoomManager.BroadcastToRoom(r.ID, renamed, nil)
+	} else {
+		h.sendTo(c, renamed)
+	}
+	return nil
+}
+
+// ValidUsername reports whether a client may rename itself to username.
+// Names with '@' are left to federated users' stand-ins, named user@peer.
+func ValidUsername(username string) bool {
+	if username == "" || utf8.RuneCountInString(username) > MaxUsernameLength {
+		return false
+	}
+	for _, r := range username {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || r == '@' {
+			return false
+		}
+	}
+	return true
+}
+
+// releaseUsername frees the username a client that didn't log in held
+func (h *Hub) releaseUsername(c *Client) {
+	n := h.nicknames
+	key := strings.ToLower(c.Name())
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.taken[key] == c.ID {
+		delete(n.taken, key)
+	}
+}
+
+// usernameTaken reports whether a client other than c uses username in any
+// letter case, on this node or, in a cluster, another one. The caller holds
+// the nicknames mutex.
+func (h *Hub) usernameTaken(c *Client, username string) bool {
+	key := strings.ToLower(username)
+	if id, ok := h.nicknames.taken[key]; ok && id != c.ID {
+		return true
+	}
+	for _, client := range h.connected() {
+		if client != c && strings.ToLower(client.Name()) == key {
+			return true
+		}
+	}
+	return h.Cluster != nil && len(h.Cluster.Locate(username)) > 0
+}
@@ -0,0 +1,89 @@
+package hub
+
+import (
+	"log"
+	"realtime-chat/internal/metrics"
+	"runtime"
+)
+
+// checkOverload measures aggregate queue depth and goroutine count and
+// toggles the hub's overloaded state when they cross the configured thresholds
+func (h *Hub) checkOverload() {
+	depth := 0
+	for _, client := range h.connected() {
+		depth += len(client.Send)
+	}
+
+	goroutines := runtime.NumGoroutine()
+
+	metrics.QueueDepth.Set(int64(depth))
+	metrics.Goroutines.Set(int64(goroutines))
+
+	limits := h.config.Overload
+	overloaded := depth > limits.MaxQueueDepth || goroutines > limits.MaxGoroutines
+
+	if overloaded == h.overloaded.Load() {
+		return
+	}
+	h.overloaded.Store(overloaded)
+
+	if overloaded {
+		metrics.Overloaded.Set(1)
+		metrics.OverloadEvents.Add(1)
+		log.Printf("ALERT: server overloaded (queue depth %d/%d, goroutines %d/%d), shedding load",
+			depth, limits.MaxQueueDepth, goroutines, limits.MaxGoroutines)
+	} else {
+		metrics.Overloaded.Set(0)
+		log.Printf("Server load recovered (queue depth %d, goroutines %d)", depth, goroutines)
+	}
+}
+
+// IsOverloaded reports whether the hub is currently shedding load
+func (h *Hub) IsOverloaded() bool {
+	return h.overloaded.Load()
+}
+
+// RetryAfterSeconds returns how long rejected clients should wait before reconnecting
+func (h *Hub) RetryAfterSeconds() int {
+	return int(h.config.Overload.RetryAfter.Seconds())
+}
+
+// Admit reserves one of the server's connection slots for a new client,
+// reporting false when all CHAT_MAX_CONNECTIONS are taken. The slot is
+// freed when the hub unregisters the client, or by Release when the client
+// never registers.
+func (h *Hub) Admit(c *Client) bool {
+	limit := int64(h.config.Overload.MaxConnections)
+	for {
+		n := h.connections.Load()
+		if n >= limit {
+			metrics.ConnectionsLimited.Add(1)
+			if h.atLimit.CompareAndSwap(false, true) {
+				metrics.AtConnectionLimit.Set(1)
+				log.Printf("ALERT: connection limit of %d reached, refusing new connections", limit)
+			}
+			return false
+		}
+		if h.connections.CompareAndSwap(n, n+1) {
+			break
+		}
+	}
+	c.admitted.Store(true)
+	metrics.Connections.Set(h.connections.Load())
+	return true
+}
+
+// Release frees the connection slot of a client, if it holds one, and the
+// username it took
+func (h *Hub) Release(c *Client) {
+	h.releaseUsername(c)
+	if !c.admitted.CompareAndSwap(true, false) {
+		return
+	}
+	n := h.connections.Add(-1)
+	metrics.Connections.Set(n)
+	if n < int64(h.config.Overload.MaxConnections) && h.atLimit.CompareAndSwap(true, false) {
+		metrics.AtConnectionLimit.Set(0)
+		log.Printf("Connections below the limit again (%d)", n)
+	}
+}
@@ -0,0 +1,45 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/store"
+)
+
+// Pinned returns a room's pinned messages, oldest pin first
+func (h *Hub) Pinned(roomID string) []*history.Message {
+	r, exists := h.RoomManager.GetRoom(roomID)
+	if !exists {
+		return nil
+	}
+
+	pins := r.GetPins()
+	pinned := make([]*history.Message, 0, len(pins))
+	for _, id := range pins {
+		msg, err := h.History.Message(roomID, id)
+		if errors.Is(err, history.ErrNotFound) && h.ColdStorage != nil {
+			msg, err = h.ColdStorage.Find(h.ctx, roomID, id)
+		}
+		if err != nil {
+			log.Printf("Error reading pinned message %s: %v", id, err)
+			continue
+		}
+		pinned = append(pinned, msg)
+	}
+	return pinned
+}
+
+// unpinRemoved is a History observer that unpins messages once they are
+// deleted, disappear, are pruned or are erased
+func (h *Hub) unpinRemoved(event *store.MessageEvent, before *history.Message) {
+	if event.Type != history.EventDelete && event.Type != history.EventExpire && event.Type != history.EventPrune && event.Type != history.EventErase {
+		return
+	}
+	if event.MessageID == "" {
+		return
+	}
+	if r, exists := h.RoomManager.GetRoom(event.RoomID); exists {
+		r.Unpin(event.MessageID)
+	}
+}
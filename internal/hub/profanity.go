@@ -0,0 +1,39 @@
+package hub
+
+import (
+	"realtime-chat/hooks"
+	"realtime-chat/internal/room"
+	"strings"
+)
+
+// profanityFilter masks or refuses the configured profane words
+type profanityFilter struct {
+	mask  func(*hooks.Message) error
+	block func(*hooks.Message) error
+}
+
+// newProfanityFilter returns a filter for words, ignoring blank ones
+func newProfanityFilter(words []string) *profanityFilter {
+	trimmed := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			trimmed = append(trimmed, word)
+		}
+	}
+	return &profanityFilter{mask: hooks.Censor(trimmed...), block: hooks.Block(trimmed...)}
+}
+
+// FilterProfanity applies a room's profanity level to a message, returning
+// the content to post, or false when the room refuses it
+func (h *Hub) FilterProfanity(r *room.Room, content string) (string, bool) {
+	msg := &hooks.Message{Kind: hooks.KindMessage, RoomID: r.ID, Content: content}
+	switch r.GetSettings().ProfanityLevel {
+	case room.ProfanityMask:
+		h.profanity.mask(msg)
+	case room.ProfanityBlock:
+		if h.profanity.block(msg) != nil {
+			return content, false
+		}
+	}
+	return msg.Content, true
+}
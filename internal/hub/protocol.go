@@ -0,0 +1,40 @@
+package hub
+
+// Feature is an optional protocol feature a client can negotiate in its hello
+type Feature uint32
+
+const (
+	// FeatureCompression compresses the frames sent to the client with permessage-deflate
+	FeatureCompression Feature = 1 << iota
+
+	// FeatureBinary sends the client's frames as binary WebSocket messages
+	FeatureBinary
+
+	// FeatureAck acknowledges every message the client posts, not only
+	// those carrying a client message ID
+	FeatureAck
+)
+
+// Negotiate records the protocol version and features agreed with the
+// client. It is called on the goroutine handling the client's frames; the
+// transport writing to the client reads them with Has.
+func (c *Client) Negotiate(version int, features Feature) {
+	c.version.Store(int32(version))
+	c.features.Store(uint32(features))
+}
+
+// ProtocolVersion returns the protocol version agreed with the client, or
+// 0 if it never sent a hello
+func (c *Client) ProtocolVersion() int {
+	return int(c.version.Load())
+}
+
+// Has reports whether the client negotiated a feature
+func (c *Client) Has(f Feature) bool {
+	return Feature(c.features.Load())&f != 0
+}
+
+// End marks the client's session as ended, reporting false if it already was
+func (c *Client) End() bool {
+	return c.ended.CompareAndSwap(false, true)
+}
@@ -0,0 +1,27 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+	"realtime-chat/internal/rbac"
+)
+
+// NotifyModerators sends a frame to every connected user who can moderate a
+// room, wherever they are, and returns how many connections it reached
+func (h *Hub) NotifyModerators(roomID string, frame map[string]interface{}) int {
+	message, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("Error encoding %v frame: %v", frame["type"], err)
+		return 0
+	}
+
+	notified := 0
+	for _, client := range h.connected() {
+		if !h.Roles.Can(client.Name(), roomID, rbac.PermModerate) {
+			continue
+		}
+		deliverTo(client.Priority, client.PriorityQueue, message, client.ID)
+		notified++
+	}
+	return notified
+}
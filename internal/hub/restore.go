@@ -0,0 +1,74 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/store"
+	"time"
+)
+
+// ErrRestoreExpired is returned when a deleted message is past the window it
+// can be restored in
+var ErrRestoreExpired = errors.New("the message was deleted too long ago to restore")
+
+// RestorableMessage is a deleted message and the time until which it can be
+// restored
+type RestorableMessage struct {
+	*history.DeletedMessage
+	RestorableUntil time.Time `json:"restorableUntil"`
+}
+
+// DeletedMessages returns the messages of a room that moderators can still
+// restore, most recently deleted first. There are none when deleted messages
+// are purged at once.
+func (h *Hub) DeletedMessages(roomID string) ([]*RestorableMessage, error) {
+	retention := h.config.Maintenance.TombstoneRetention
+	deleted, err := h.History.Deleted(roomID, time.Now().Add(-retention))
+	if err != nil {
+		return nil, err
+	}
+	restorable := make([]*RestorableMessage, len(deleted))
+	for i, d := range deleted {
+		restorable[i] = &RestorableMessage{DeletedMessage: d, RestorableUntil: d.DeletedAt.Add(retention)}
+	}
+	return restorable, nil
+}
+
+// RestoreMessage undoes a message's deletion on behalf of a moderator, as
+// long as it was deleted within the tombstone retention period. The restore
+// is audited and the message is sent to the room again so clients can show
+// it in its place.
+func (h *Hub) RestoreMessage(roomID, messageID, actor, reason string) (*history.Message, error) {
+	msg, err := h.History.Message(roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.DeletedAt != nil && time.Since(*msg.DeletedAt) > h.config.Maintenance.TombstoneRetention {
+		return nil, ErrRestoreExpired
+	}
+	if msg, err = h.History.Restore(roomID, messageID, actor); err != nil {
+		return nil, err
+	}
+
+	h.Audit.Record(store.AuditRecord{
+		Actor:   actor,
+		Action:  audit.ActionRestore,
+		Target:  msg.Username,
+		RoomID:  roomID,
+		Reason:  reason,
+		Details: map[string]string{"messageId": messageID},
+	})
+
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":      "message_restored",
+		"roomId":    roomID,
+		"messageId": messageID,
+		"message":   msg,
+		"username":  actor,
+		"timestamp": getCurrentTime(),
+	})
+	h.RoomManager.BroadcastFrom(h.ctx, roomID, msg.Username, frame, nil)
+	return msg, nil
+}
@@ -0,0 +1,94 @@
+package hub
+
+import (
+	"realtime-chat/internal/projection"
+	"realtime-chat/internal/room"
+	"time"
+)
+
+// Room returns a room of a workspace by ID or slug. Rooms of other
+// workspaces are reported as not existing.
+func (h *Hub) Room(workspace, ref string) (*room.Room, bool) {
+	r, exists := h.RoomManager.GetRoom(ref)
+	if !exists {
+		return h.RoomManager.FindSlug(workspace, ref)
+	}
+	if r.Workspace != workspace {
+		return nil, false
+	}
+	return r, true
+}
+
+// RoomList returns a summary of every room of a workspace for room list
+// rendering. It is served from the projections, so no room's history or
+// client set is scanned. When username is set, each entry includes that
+// user's unread count. In a cluster the client count includes the clients
+// of the other nodes. Archived rooms are left out unless includeArchived is
+// set, and group conversations always are.
+func (h *Hub) RoomList(workspace, username string, includeArchived bool) []map[string]interface{} {
+	rooms := h.RoomManager.GetRooms()
+
+	roomList := make([]map[string]interface{}, 0, len(rooms))
+	for _, r := range rooms {
+		if r.Workspace != workspace || r.IsGroup() || (r.IsArchived() && !includeArchived) {
+			continue
+		}
+		roomList = append(roomList, h.roomEntry(r, h.Projections.Summary(r.ID), username))
+	}
+	return roomList
+}
+
+// RoomSummary returns the room list entry of one room, with username's
+// unread count when username is set
+func (h *Hub) RoomSummary(r *room.Room, username string) map[string]interface{} {
+	return h.roomEntry(r, h.Projections.Summary(r.ID), username)
+}
+
+// roomEntry returns the room list entry of a room, with username's unread
+// count when username is set
+func (h *Hub) roomEntry(r *room.Room, summary *projection.Summary, username string) map[string]interface{} {
+	topic, description := r.GetTopic()
+	clients := summary.MemberCount
+	if h.Cluster != nil {
+		clients += h.Cluster.RemoteRoomClients(r.ID)
+	}
+
+	entry := map[string]interface{}{
+		"id":           r.ID,
+		"name":         r.Name,
+		"slug":         r.GetSlug(),
+		"mode":         r.Mode,
+		"encrypted":    r.IsEncrypted(),
+		"topic":        topic,
+		"description":  description,
+		"tags":         r.GetTags(),
+		"featured":     r.IsFeatured(),
+		"archived":     r.IsArchived(),
+		"joinRule":     r.GetJoinRule(),
+		"messageTtl":   int(r.GetMessageTTL().Seconds()),
+		"clientCount":  clients,
+		"messageCount": summary.MessageCount,
+		"lastMessage":  summary.LastMessage,
+		"createdBy":    r.CreatedBy,
+		"createdAt":    r.CreatedAt.Format(time.RFC3339),
+	}
+	if username != "" {
+		entry["unread"] = summary.Unread[username]
+	}
+	return entry
+}
+
+// RetentionPolicy returns the days and number of messages of history a room
+// keeps: its own settings, falling back to the server's for those left at 0
+func (h *Hub) RetentionPolicy(roomID string) (days, messages int) {
+	if r, exists := h.RoomManager.GetRoom(roomID); exists {
+		days, messages = r.GetRetention()
+	}
+	if days == 0 {
+		days = h.config.Retention.Days
+	}
+	if messages == 0 {
+		messages = h.config.Retention.Messages
+	}
+	return days, messages
+}
@@ -0,0 +1,87 @@
+package hub
+
+import (
+	"context"
+	"log"
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/search"
+	"realtime-chat/internal/store"
+)
+
+// SearchMessages finds messages in the rooms of a workspace, or in the rooms
+// q names. Groups are private to their participants and encrypted rooms'
+// content is ciphertext, so they are left out unless named; direct messages
+// are never indexed.
+func (h *Hub) SearchMessages(ctx context.Context, workspace string, q search.Query) (*search.Results, error) {
+	if q.RoomIDs == nil {
+		for _, r := range h.RoomManager.GetRooms() {
+			if r.Workspace == workspace && !r.IsGroup() && !r.IsEncrypted() {
+				q.RoomIDs = append(q.RoomIDs, r.ID)
+			}
+		}
+	}
+	return h.Search.Search(ctx, q)
+}
+
+// Reindex replaces the search index's messages with every room's history,
+// as when the index is new or was lost, and returns how many rooms and
+// messages were indexed
+func (h *Hub) Reindex(ctx context.Context) (rooms, messages int, err error) {
+	for _, roomID := range h.RoomIDs() {
+		indexed, err := h.reindexRoom(ctx, roomID)
+		if err != nil {
+			return rooms, messages, err
+		}
+		rooms++
+		messages += indexed
+	}
+	return rooms, messages, nil
+}
+
+// reindexRoom replaces a room's messages in the search index
+func (h *Hub) reindexRoom(ctx context.Context, roomID string) (int, error) {
+	msgs, err := h.History.Messages(roomID)
+	if err != nil {
+		return 0, err
+	}
+	// Messages moved to cold storage stay searchable
+	if h.ColdStorage != nil {
+		cold, err := h.ColdStorage.Messages(ctx, roomID)
+		if err != nil {
+			return 0, err
+		}
+		msgs = append(cold, msgs...)
+	}
+	return h.Index.Replace(ctx, roomID, msgs)
+}
+
+// indexMessage keeps the search index in step with rooms' messages,
+// including messages removed by expiry, retention and erasure. Direct
+// messages stay private.
+func (h *Hub) indexMessage(event *store.MessageEvent, _ *history.Message) {
+	if conversation.IsID(event.RoomID) {
+		return
+	}
+	switch event.Type {
+	case history.EventMessage, history.EventEdit, history.EventRestore:
+		if msg, err := h.History.Message(event.RoomID, event.MessageID); err == nil {
+			h.Index.Index(msg)
+		}
+
+	case history.EventDelete, history.EventExpire, history.EventPrune:
+		h.Index.Delete(event.MessageID)
+
+	case history.EventErase:
+		if event.MessageID != "" {
+			h.Index.Delete(event.MessageID)
+			return
+		}
+		// The room's remaining messages were anonymized, so index them anew
+		go func() {
+			if _, err := h.reindexRoom(h.ctx, event.RoomID); err != nil {
+				log.Printf("Error reindexing room %s: %v", event.RoomID, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,141 @@
+package hub
+
+import (
+	"encoding/json"
+	"realtime-chat/internal/auth"
+	"time"
+)
+
+// Connection is a live connection made with a login session
+type Connection struct {
+	ClientID    string    `json:"clientId"`
+	Transport   string    `json:"transport"`
+	RemoteAddr  string    `json:"remoteAddr,omitempty"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// Session is one of a user's login sessions, such as a browser or phone,
+// with the connections it has open to this server
+type Session struct {
+	ID          string       `json:"id"`
+	Device      string       `json:"device,omitempty"`
+	IP          string       `json:"ip,omitempty"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	ExpiresAt   time.Time    `json:"expiresAt"`
+	Current     bool         `json:"current"` // The session the request was made with
+	Connections []Connection `json:"connections"`
+}
+
+// Sessions returns a user's login sessions, oldest first, marking the one
+// with ID current
+func (h *Hub) Sessions(username, current string) []*Session {
+	connections := make(map[string][]Connection)
+	for _, client := range h.findClients(username) {
+		if client.Session == "" {
+			continue
+		}
+		connections[client.Session] = append(connections[client.Session], Connection{
+			ClientID:    client.ID,
+			Transport:   client.Transport,
+			RemoteAddr:  client.RemoteAddr,
+			ConnectedAt: client.ConnectedAt,
+		})
+	}
+
+	records := h.Auth.Sessions(username)
+	sessions := make([]*Session, 0, len(records))
+	for _, record := range records {
+		id := auth.SessionID(record.TokenHash)
+		conns := connections[id]
+		if conns == nil {
+			conns = []Connection{}
+		}
+		sessions = append(sessions, &Session{
+			ID:          id,
+			Device:      record.Device,
+			IP:          record.IP,
+			CreatedAt:   record.CreatedAt,
+			ExpiresAt:   record.ExpiresAt,
+			Current:     id == current,
+			Connections: conns,
+		})
+	}
+	return sessions
+}
+
+// RevokeSession ends one of a user's login sessions and closes the
+// connections made with it, returning how many were closed
+func (h *Hub) RevokeSession(username, id string) (int, error) {
+	if err := h.Auth.Revoke(username, id); err != nil {
+		return 0, err
+	}
+	return h.CloseSessions(username, id), nil
+}
+
+// RevokeOtherSessions ends every login session of a user except the one
+// with ID keep, logging the user out of their other devices, and closes
+// the connections made with them. It returns how many sessions were ended
+// and connections closed.
+func (h *Hub) RevokeOtherSessions(username, keep string) (int, int, error) {
+	revoked, err := h.Auth.RevokeOthers(username, keep)
+	closed := h.CloseSessions(username, revoked...)
+	return len(revoked), closed, err
+}
+
+// CloseSessions disconnects a user's clients connected with any of the
+// login sessions ids, after telling them why, and returns how many it
+// disconnected
+func (h *Hub) CloseSessions(username string, ids ...string) int {
+	if len(ids) == 0 {
+		return 0
+	}
+	revoked := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		revoked[id] = true
+	}
+
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":      "session_revoked",
+		"message":   "This session was logged out",
+		"timestamp": getCurrentTime(),
+	})
+	return h.closeClients(username, frame, func(c *Client) bool { return revoked[c.Session] })
+}
+
+// closeClients sends frame to the clients of a user that match, then
+// disconnects them and returns how many it disconnected. Their send
+// channels stay open: each transport closes its connection once Closing
+// is closed, and the client leaves its room and unregisters as it does
+// whenever its connection ends.
+func (h *Hub) closeClients(username string, frame []byte, match func(*Client) bool) int {
+	return h.closeMatching(frame, func(c *Client) bool { return c.Name() == username && match(c) })
+}
+
+// closeMatching is closeClients for the clients of any user that match
+func (h *Hub) closeMatching(frame []byte, match func(*Client) bool) int {
+	closed := 0
+	for _, client := range h.connected() {
+		if !match(client) {
+			continue
+		}
+		deliverTo(client.Priority, client.PriorityQueue, frame, client.ID)
+		client.close()
+		closed++
+	}
+	return closed
+}
+
+// Closing returns a channel that is closed when the hub disconnects the
+// client, such as when its session is revoked. The client's transport then
+// writes its priority frames, which tell it why, and closes its connection.
+func (c *Client) Closing() <-chan struct{} {
+	c.closingInit.Do(func() { c.closing = make(chan struct{}) })
+	return c.closing
+}
+
+// close closes the channel returned by Closing. Calls after the first do
+// nothing.
+func (c *Client) close() {
+	c.Closing()
+	c.closeOnce.Do(func() { close(c.closing) })
+}
@@ -0,0 +1,87 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"realtime-chat/internal/room"
+	"testing"
+	"time"
+)
+
+// newRoomClient registers a client of username in a new room of the
+// workspace and returns it with the room's ID
+func newRoomClient(t *testing.T, h *Hub, workspace, username string) (*Client, string) {
+	t.Helper()
+	roomID := h.RoomManager.CreateRoomAsync(workspace, "room", room.SystemUser, room.ModeNormal, false)
+	client := newTestClient(h, "c-"+username, username)
+	client.Workspace = workspace
+	h.Register <- client
+	if resp := h.RoomManager.JoinRoomAsync(client, roomID); !resp.Success {
+		t.Fatalf("join failed: %s", resp.Message)
+	}
+	for len(client.Send) > 0 {
+		<-client.Send
+	}
+	return client, roomID
+}
+
+// checkDisconnected fails the test unless the hub disconnected client after
+// sending it a frame of type notice, and the client still receives the
+// broadcasts of its room until its transport ends its session
+func checkDisconnected(t *testing.T, h *Hub, client *Client, roomID, notice string) {
+	t.Helper()
+	select {
+	case <-client.Closing():
+	default:
+		t.Fatal("client wasn't disconnected")
+	}
+	select {
+	case frame := <-client.Priority:
+		var got struct{ Type string }
+		json.Unmarshal(frame, &got)
+		if got.Type != notice {
+			t.Fatalf("client was sent %s, want %s", got.Type, notice)
+		}
+	default:
+		t.Fatalf("client wasn't sent %s", notice)
+	}
+
+	h.RoomManager.BroadcastToRoom(roomID, []byte(`{"type":"message"}`), nil)
+	select {
+	case <-client.Send:
+	case <-time.After(2 * time.Second):
+		t.Fatal("broadcast not delivered")
+	}
+
+	// as the transport does once it has closed the connection
+	h.RoomManager.LeaveRoomAsync(client, roomID)
+	h.Unregister <- client
+	if _, ok := <-client.Send; ok {
+		t.Fatal("send channel left open after the client unregistered")
+	}
+}
+
+func TestCloseSessions(t *testing.T) {
+	h := newTestHub(t, context.Background())
+	go h.Run()
+	defer h.Stop()
+
+	client, roomID := newRoomClient(t, h, "", "alice")
+	client.Session = "s1"
+	other, _ := newRoomClient(t, h, "", "bob")
+	other.Session = "s1"
+
+	if closed := h.CloseSessions("alice", "s2"); closed != 0 {
+		t.Fatalf("closed %d clients of another session", closed)
+	}
+	if closed := h.CloseSessions("alice", "s1"); closed != 1 {
+		t.Fatalf("closed %d clients, want 1", closed)
+	}
+	checkDisconnected(t, h, client, roomID, "session_revoked")
+
+	select {
+	case <-other.Closing():
+		t.Fatal("another user's client was disconnected")
+	default:
+	}
+}
@@ -0,0 +1,63 @@
+package hub
+
+import (
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/room"
+	"sort"
+)
+
+// ChannelDepths returns how full the hub's channels are
+func (h *Hub) ChannelDepths() map[string]room.Depth {
+	return map[string]room.Depth{
+		"register":   {Queued: len(h.Register), Capacity: cap(h.Register)},
+		"unregister": {Queued: len(h.Unregister), Capacity: cap(h.Unregister)},
+		"priority":   {Queued: len(h.Priority), Capacity: cap(h.Priority)},
+		"direct":     {Queued: len(h.Direct), Capacity: cap(h.Direct)},
+	}
+}
+
+// QueueState is how full a client queue is and has been, and how many
+// frames it dropped
+type QueueState struct {
+	room.Depth
+	metrics.QueueStats
+}
+
+// queueState returns the state of a client queue
+func queueState(ch chan []byte, q *metrics.Queue) QueueState {
+	return QueueState{Depth: room.Depth{Queued: len(ch), Capacity: cap(ch)}, QueueStats: q.Stats()}
+}
+
+// SlowClient describes a client whose queues have dropped frames or whose
+// send queue has filled at least halfway
+type SlowClient struct {
+	ID       string     `json:"id"`
+	Username string     `json:"username"`
+	Send     QueueState `json:"send"`
+	Priority QueueState `json:"priority"`
+}
+
+// SlowClients returns up to limit clients falling behind, those that
+// dropped the most frames first, so queue sizes can be tuned to them
+func (h *Hub) SlowClients(limit int) []SlowClient {
+	slow := []SlowClient{}
+	for _, client := range h.connected() {
+		send, priority := queueState(client.Send, client.SendQueue), queueState(client.Priority, client.PriorityQueue)
+		if send.Dropped == 0 && priority.Dropped == 0 && send.HighWater*2 < int64(send.Capacity) {
+			continue
+		}
+		slow = append(slow, SlowClient{ID: client.ID, Username: client.Name(), Send: send, Priority: priority})
+	}
+
+	sort.Slice(slow, func(i, j int) bool {
+		a, b := slow[i], slow[j]
+		if da, db := a.Send.Dropped+a.Priority.Dropped, b.Send.Dropped+b.Priority.Dropped; da != db {
+			return da > db
+		}
+		return a.Send.HighWater > b.Send.HighWater
+	})
+	if len(slow) > limit {
+		slow = slow[:limit]
+	}
+	return slow
+}
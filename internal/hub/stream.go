@@ -0,0 +1,67 @@
+package hub
+
+import (
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/stream"
+	"time"
+)
+
+// streamMessage exports every change to a room's messages, including
+// messages removed by expiry, retention and erasure. Direct messages stay
+// private.
+func (h *Hub) streamMessage(event *store.MessageEvent, before *history.Message) {
+	// Messages moved to cold storage haven't changed
+	if conversation.IsID(event.RoomID) || event.Type == history.EventCompact {
+		return
+	}
+	exported := &stream.Event{
+		Type:       event.Type,
+		RoomID:     event.RoomID,
+		MessageID:  event.MessageID,
+		Seq:        event.Seq,
+		Username:   event.Username,
+		Author:     event.Username,
+		Content:    event.Content,
+		Emoji:      event.Emoji,
+		Timestamp:  event.Timestamp,
+		Encryption: event.Encryption,
+		GIF:        event.GIF,
+	}
+	if before != nil {
+		exported.Author = before.Username
+	}
+	switch event.Type {
+	case history.EventExpire, history.EventPrune, history.EventErase:
+		exported.Author = ""
+	}
+	h.Stream.Send(stream.KindMessage, exported)
+}
+
+// streamMembership exports a user joining or leaving a room
+func (h *Hub) streamMembership(roomID, username string, joined bool) {
+	eventType := stream.TypeLeave
+	if joined {
+		eventType = stream.TypeJoin
+	}
+	h.Stream.Send(stream.KindMembership, &stream.Event{
+		Type:      eventType,
+		RoomID:    roomID,
+		Username:  username,
+		Timestamp: time.Now(),
+	})
+}
+
+// streamModeration exports a moderation or admin action recorded in the audit log
+func (h *Hub) streamModeration(entry *store.AuditRecord) {
+	h.Stream.Send(stream.KindModeration, &stream.Event{
+		Type:      entry.Action,
+		RoomID:    entry.RoomID,
+		Username:  entry.Actor,
+		Target:    entry.Target,
+		Reason:    entry.Reason,
+		Details:   entry.Details,
+		Timestamp: entry.Time,
+	})
+}
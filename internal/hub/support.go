@@ -0,0 +1,35 @@
+package hub
+
+import (
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/support"
+	"time"
+)
+
+// supportLeft ends a support conversation once its visitor has left the room
+// on every connection. It is called from the room's goroutine, so the
+// transcript and CRM summary are sent in the background.
+func (h *Hub) supportLeft(roomID, username string) {
+	r, exists := h.RoomManager.GetRoom(roomID)
+	if !exists || r.Mode != room.ModeSupport || r.CreatedBy != username {
+		return
+	}
+	if _, stillPresent := r.FindClient(username); stillPresent {
+		return
+	}
+	// Rooms leave all their clients on shutdown, which doesn't end the conversation
+	if h.ctx.Err() != nil || !h.Support.Enabled() {
+		return
+	}
+
+	end := time.Now()
+	start, visitorEmail := r.EndConversation(end)
+	go h.Support.Close(&support.Conversation{
+		RoomID:       r.ID,
+		RoomName:     r.Name,
+		Visitor:      username,
+		VisitorEmail: visitorEmail,
+		StartedAt:    start,
+		EndedAt:      end,
+	})
+}
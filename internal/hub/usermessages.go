@@ -0,0 +1,293 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/store"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UserMessagesQuery selects a page of the messages a user posted
+type UserMessagesQuery struct {
+	Username string
+
+	// Workspace whose rooms and conversations are looked in, unless
+	// AllWorkspaces is set as it is for data exports
+	Workspace     string
+	AllWorkspaces bool
+
+	RoomIDs []string  // Rooms and conversations to look in; all of them by default
+	Since   time.Time // Posted at or after; any time when zero
+	Until   time.Time // Posted before; any time when zero
+
+	// Page of the messages, newest first; every message when Limit is 0
+	Offset int
+	Limit  int
+}
+
+// UserMessages returns a page of the messages a user posted in rooms and in
+// their direct message conversations, newest first, including those moved
+// to cold storage, and how many match in all. Messages are looked up in an
+// index of their authors, so only the page is read. Deleted messages are
+// left as the tombstones history keeps.
+func (h *Hub) UserMessages(ctx context.Context, q UserMessagesQuery) ([]*history.Message, int, error) {
+	if err := h.buildAuthored(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	var rooms map[string]bool
+	if q.RoomIDs != nil {
+		rooms = make(map[string]bool, len(q.RoomIDs))
+		for _, id := range q.RoomIDs {
+			rooms[id] = true
+		}
+	}
+	visible := make(map[string]bool)
+	var matches []authoredMessage
+	for _, m := range h.authored.list(q.Username) {
+		if (rooms != nil && !rooms[m.RoomID]) ||
+			(!q.Since.IsZero() && m.Timestamp.Before(q.Since)) ||
+			(!q.Until.IsZero() && !m.Timestamp.Before(q.Until)) {
+			continue
+		}
+		seen, ok := visible[m.RoomID]
+		if !ok {
+			seen = h.authoredVisible(q, m.RoomID)
+			visible[m.RoomID] = seen
+		}
+		// Retention policies drop whole segments of cold storage without events
+		if seen && (!m.Cold || h.ColdStorage == nil || h.ColdStorage.Holds(m.RoomID, m.Seq)) {
+			matches = append(matches, m)
+		}
+	}
+
+	total := len(matches)
+	end := total - q.Offset
+	start := 0
+	if q.Limit > 0 {
+		start = max(end-q.Limit, 0)
+	}
+	page := make([]*history.Message, 0, max(end-start, 0))
+	for i := end - 1; i >= start; i-- {
+		m := matches[i]
+		msg, err := h.History.Message(m.RoomID, m.MessageID)
+		if errors.Is(err, history.ErrNotFound) && h.ColdStorage != nil {
+			msg, err = h.ColdStorage.Message(ctx, m.RoomID, m.Seq)
+		}
+		if errors.Is(err, history.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		page = append(page, msg)
+	}
+	return page, total, nil
+}
+
+// authoredVisible reports whether a query looks in a room or conversation:
+// one that still exists in the query's workspace
+func (h *Hub) authoredVisible(q UserMessagesQuery, roomID string) bool {
+	if workspace, _, _, ok := conversation.Participants(roomID); ok {
+		return (q.AllWorkspaces || workspace == q.Workspace) && h.Conversations.Has(q.Username, roomID)
+	}
+	r, exists := h.RoomManager.GetRoom(roomID)
+	return exists && (q.AllWorkspaces || r.Workspace == q.Workspace)
+}
+
+// buildAuthored builds the index of messages by author from every room's
+// and conversation's history and cold storage the first time it is needed
+func (h *Hub) buildAuthored(ctx context.Context) error {
+	a := h.authored
+	a.building.Lock()
+	defer a.building.Unlock()
+
+	a.mutex.Lock()
+	if a.built {
+		a.mutex.Unlock()
+		return nil
+	}
+	// Events from here on are applied once the index is built
+	a.pending = []*store.MessageEvent{}
+	a.mutex.Unlock()
+
+	messages := make(map[messageKey]*authoredMessage)
+	add := func(username string, m *authoredMessage) {
+		m.Username = username
+		messages[messageKey{m.RoomID, m.MessageID}] = m
+	}
+	for _, roomID := range h.HistoryIDs() {
+		if h.ColdStorage != nil {
+			cold, err := h.ColdStorage.Authored(ctx, roomID)
+			if err != nil {
+				a.mutex.Lock()
+				a.pending = nil
+				a.mutex.Unlock()
+				return err
+			}
+			for username, list := range cold {
+				for _, m := range list {
+					add(username, &authoredMessage{RoomID: roomID, MessageID: m.ID, Seq: m.Seq, Timestamp: m.Timestamp, Cold: true})
+				}
+			}
+		}
+		// Messages still in history win over copies written to cold storage
+		// by a compaction that hasn't removed them yet
+		roomMessages, err := h.History.Messages(roomID)
+		if err != nil {
+			a.mutex.Lock()
+			a.pending = nil
+			a.mutex.Unlock()
+			return err
+		}
+		for _, msg := range roomMessages {
+			add(msg.Username, &authoredMessage{RoomID: roomID, MessageID: msg.ID, Seq: msg.Seq, Timestamp: msg.Timestamp})
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.messages = messages
+	a.byAuthor = make(map[string][]*authoredMessage)
+	for _, m := range messages {
+		a.byAuthor[m.Username] = append(a.byAuthor[m.Username], m)
+	}
+	for _, list := range a.byAuthor {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Timestamp.Before(list[j].Timestamp)
+		})
+	}
+	a.built = true
+	for _, event := range a.pending {
+		a.applyLocked(event)
+	}
+	a.pending = nil
+	return nil
+}
+
+// authored indexes the messages of rooms and conversations by their author.
+// It is built the first time a user's messages are asked for and kept up to
+// date with history's events from then on.
+type authored struct {
+	building sync.Mutex // Held while the index is built
+
+	mutex    sync.Mutex
+	built    bool
+	pending  []*store.MessageEvent // Events recorded while the index is built; nil otherwise
+	messages map[messageKey]*authoredMessage
+	byAuthor map[string][]*authoredMessage // Oldest first, including removed messages until compacted
+	removed  map[string]int                // Removed messages still in each author's list
+}
+
+// authoredMessage is a message in the index
+type authoredMessage struct {
+	RoomID    string
+	MessageID string
+	Username  string
+	Seq       int64
+	Timestamp time.Time
+	Cold      bool // Moved to cold storage
+	Removed   bool
+}
+
+// messageKey identifies a message across rooms
+type messageKey struct {
+	RoomID    string
+	MessageID string
+}
+
+func newAuthored() *authored {
+	return &authored{removed: make(map[string]int)}
+}
+
+// observe keeps the index up to date with a history event
+func (a *authored) observe(event *store.MessageEvent, _ *history.Message) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	switch {
+	case a.built:
+		a.applyLocked(event)
+	case a.pending != nil:
+		a.pending = append(a.pending, event)
+	}
+}
+
+// applyLocked applies a history event to the built index; the caller must
+// hold the mutex
+func (a *authored) applyLocked(event *store.MessageEvent) {
+	key := messageKey{event.RoomID, event.MessageID}
+	m, exists := a.messages[key]
+	switch event.Type {
+	case history.EventMessage:
+		if exists {
+			return
+		}
+		m = &authoredMessage{RoomID: event.RoomID, MessageID: event.MessageID, Username: event.Username, Seq: event.Seq, Timestamp: event.Timestamp}
+		a.messages[key] = m
+		a.byAuthor[m.Username] = append(a.byAuthor[m.Username], m)
+
+	case history.EventCompact:
+		if exists {
+			m.Cold = true
+		}
+
+	case history.EventExpire, history.EventPrune, history.EventErase:
+		if exists {
+			a.removeLocked(m)
+		}
+	}
+}
+
+// removeLocked takes a message out of the index, compacting its author's
+// list once most of it is removed; the caller must hold the mutex
+func (a *authored) removeLocked(m *authoredMessage) {
+	delete(a.messages, messageKey{m.RoomID, m.MessageID})
+	m.Removed = true
+	a.removed[m.Username]++
+	list := a.byAuthor[m.Username]
+	if a.removed[m.Username]*2 < len(list) {
+		return
+	}
+	kept := list[:0]
+	for _, listed := range list {
+		if !listed.Removed {
+			kept = append(kept, listed)
+		}
+	}
+	clear(list[len(kept):])
+	delete(a.removed, m.Username)
+	if len(kept) == 0 {
+		delete(a.byAuthor, m.Username)
+	} else {
+		a.byAuthor[m.Username] = kept
+	}
+}
+
+// list returns copies of a user's messages, oldest first
+func (a *authored) list(username string) []authoredMessage {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	found := make([]authoredMessage, 0, len(a.byAuthor[username])-a.removed[username])
+	for _, m := range a.byAuthor[username] {
+		if !m.Removed {
+			found = append(found, *m)
+		}
+	}
+	return found
+}
+
+// drop takes every message of a user out of the index, for users whose
+// data was erased
+func (a *authored) drop(username string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, m := range a.byAuthor[username] {
+		delete(a.messages, messageKey{m.RoomID, m.MessageID})
+	}
+	delete(a.byAuthor, username)
+	delete(a.removed, username)
+}
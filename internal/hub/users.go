@@ -0,0 +1,118 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+	"realtime-chat/internal/presence"
+	"realtime-chat/internal/profile"
+	"sort"
+)
+
+// Member is a room member as shown in member lists
+type Member struct {
+	Username string          `json:"username"`
+	Status   presence.Status `json:"status"`
+}
+
+// Members returns each distinct user of usernames with their presence status, sorted by username
+func (h *Hub) Members(usernames []string) []Member {
+	seen := make(map[string]bool, len(usernames))
+	members := make([]Member, 0, len(usernames))
+	for _, username := range usernames {
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+		members = append(members, Member{Username: username, Status: h.status(username)})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Username < members[j].Username
+	})
+	return members
+}
+
+// RoomMembers returns the users connected to a room, on every node of a
+// cluster, with their presence statuses
+func (h *Hub) RoomMembers(roomID string) []Member {
+	return h.Members(h.RoomMemberNames(roomID))
+}
+
+// RoomMemberNames returns the username of every client in a room, on every
+// node of a cluster; users with several connections are listed for each
+func (h *Hub) RoomMemberNames(roomID string) []string {
+	var usernames []string
+	if r, exists := h.RoomManager.GetRoom(roomID); exists {
+		usernames = r.GetClients()
+	}
+	if h.Cluster != nil {
+		usernames = append(usernames, h.Cluster.RemoteMembers(roomID)...)
+	}
+	return usernames
+}
+
+// status returns a user's presence status. Statuses are kept by the node
+// the user set them on, so users connected only to other nodes of a
+// cluster get the status that node announced.
+func (h *Hub) status(username string) presence.Status {
+	if h.Cluster != nil && len(h.findClients(username)) == 0 {
+		if status, ok := h.Cluster.RemoteStatus(username); ok {
+			return status
+		}
+	}
+	return h.Presence.Get(username)
+}
+
+// SetStatus changes a user's presence status and tells every room they are in
+func (h *Hub) SetStatus(username string, status presence.Status) (presence.Status, error) {
+	status, err := h.Presence.Set(username, status)
+	if err != nil {
+		return status, err
+	}
+	h.presenceChanged()
+
+	h.broadcastToUser(username, map[string]interface{}{
+		"type":     "status_updated",
+		"username": username,
+		"status":   status,
+	})
+	return status, nil
+}
+
+// broadcastProfile tells every room the user is in that their profile changed
+func (h *Hub) broadcastProfile(p *profile.Profile) {
+	h.broadcastToUser(p.Username, map[string]interface{}{
+		"type":    "profile_updated",
+		"profile": p,
+	})
+}
+
+// broadcastToUser sends a frame about a user to every room they are in
+func (h *Hub) broadcastToUser(username string, frame map[string]interface{}) {
+	message, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("Error encoding %v frame: %v", frame["type"], err)
+		return
+	}
+
+	for _, r := range h.RoomManager.GetRooms() {
+		if _, present := r.FindClient(username); present {
+			h.RoomManager.BroadcastToRoom(r.ID, message, nil)
+		}
+	}
+}
+
+// NotifyUser sends a frame to every connection of a user, wherever they are,
+// and returns how many connections it reached
+func (h *Hub) NotifyUser(username string, frame map[string]interface{}) int {
+	message, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("Error encoding %v frame: %v", frame["type"], err)
+		return 0
+	}
+
+	clients := h.findClients(username)
+	for _, client := range clients {
+		deliverTo(client.Priority, client.PriorityQueue, message, client.ID)
+	}
+	return len(clients)
+}
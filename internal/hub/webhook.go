@@ -0,0 +1,47 @@
+package hub
+
+import (
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/webhook"
+)
+
+// notifyWebhook sends message creations, edits and deletions to the room's
+// webhook, with the content before and after the change
+func (h *Hub) notifyWebhook(event *store.MessageEvent, before *history.Message) {
+	r, exists := h.RoomManager.GetRoom(event.RoomID)
+	if !exists {
+		return
+	}
+	url, secret := r.GetWebhook()
+	if url == "" {
+		return
+	}
+
+	payload := &webhook.Event{
+		RoomID:    event.RoomID,
+		MessageID: event.MessageID,
+		Username:  event.Username,
+		Author:    event.Username,
+		Timestamp: event.Timestamp,
+	}
+	if before != nil {
+		payload.Author = before.Username
+		payload.Before = &webhook.Snapshot{Content: before.Content, Encryption: before.Encryption, GIF: before.GIF}
+	}
+
+	switch event.Type {
+	case history.EventMessage:
+		payload.Event = webhook.MessageCreated
+		payload.After = &webhook.Snapshot{Content: event.Content, Encryption: event.Encryption, GIF: event.GIF}
+	case history.EventEdit:
+		payload.Event = webhook.MessageEdited
+		payload.After = &webhook.Snapshot{Content: event.Content, Encryption: event.Encryption}
+	case history.EventDelete:
+		payload.Event = webhook.MessageDeleted
+	default:
+		return
+	}
+
+	h.Webhooks.Send(url, secret, payload)
+}
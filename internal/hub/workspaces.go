@@ -0,0 +1,56 @@
+package hub
+
+import (
+	"encoding/json"
+	"realtime-chat/internal/workspace"
+)
+
+// SaveWorkspace creates a workspace with its lobby, or changes its settings
+// and disconnects the clients it no longer admits. It reports whether the
+// workspace was created and how many clients it disconnected.
+func (h *Hub) SaveWorkspace(ws *workspace.Workspace, actor string) (*workspace.Workspace, bool, int, error) {
+	saved, created, err := h.Workspaces.Save(ws, actor)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	if created {
+		h.RoomManager.CreateLobbyAsync(saved.ID)
+		return saved, true, 0, nil
+	}
+
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":      "workspace_closed",
+		"message":   "This workspace is now closed to you",
+		"timestamp": getCurrentTime(),
+	})
+	closed := h.closeMatching(frame, func(c *Client) bool {
+		account := (c.Session != "" || c.APIKey != nil) && !h.Auth.Guest(c.Name())
+		return c.Workspace == saved.ID && !h.Workspaces.Admits(saved.ID, c.Name(), account)
+	})
+	return saved, false, closed, nil
+}
+
+// DeleteWorkspace removes a workspace and its rooms and disconnects its
+// clients, returning how many rooms it removed and clients it disconnected.
+// The rooms' history is kept.
+func (h *Hub) DeleteWorkspace(id string) (int, int, error) {
+	if err := h.Workspaces.Delete(id); err != nil {
+		return 0, 0, err
+	}
+
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":      "workspace_deleted",
+		"message":   "This workspace was deleted",
+		"timestamp": getCurrentTime(),
+	})
+	closed := h.closeMatching(frame, func(c *Client) bool { return c.Workspace == id })
+
+	rooms := 0
+	for _, r := range h.RoomManager.GetRooms() {
+		if r.Workspace == id {
+			h.RoomManager.DeleteRoomAsync(r.ID)
+			rooms++
+		}
+	}
+	return rooms, closed, nil
+}
@@ -0,0 +1,82 @@
+package hub
+
+import (
+	"context"
+	"realtime-chat/internal/workspace"
+	"testing"
+	"time"
+)
+
+// newTestWorkspace creates a workspace, with its lobby, open to guests
+func newTestWorkspace(t *testing.T, h *Hub, id string) {
+	t.Helper()
+	if _, _, _, err := h.SaveWorkspace(&workspace.Workspace{ID: id, Name: id, Guests: true}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseWorkspace(t *testing.T) {
+	h := newTestHub(t, context.Background())
+	go h.Run()
+	defer h.Stop()
+
+	newTestWorkspace(t, h, "acme")
+	client, roomID := newRoomClient(t, h, "acme", "alice")
+	outside, _ := newRoomClient(t, h, "", "bob")
+
+	_, created, closed, err := h.SaveWorkspace(&workspace.Workspace{ID: "acme", Name: "Acme"}, "admin")
+	if err != nil || created || closed != 1 {
+		t.Fatalf("closing the workspace: created %v, closed %d, %v", created, closed, err)
+	}
+	checkDisconnected(t, h, client, roomID, "workspace_closed")
+
+	select {
+	case <-outside.Closing():
+		t.Fatal("client outside the workspace was disconnected")
+	default:
+	}
+}
+
+func TestDeleteWorkspace(t *testing.T) {
+	h := newTestHub(t, context.Background())
+	go h.Run()
+	defer h.Stop()
+
+	newTestWorkspace(t, h, "acme")
+	client, roomID := newRoomClient(t, h, "acme", "alice")
+	rooms := h.RoomManager.GetRoomCount()
+
+	removed, closed, err := h.DeleteWorkspace("acme")
+	if err != nil || removed != 2 || closed != 1 {
+		t.Fatalf("deleting the workspace: removed %d rooms, closed %d, %v", removed, closed, err)
+	}
+	select {
+	case <-client.Closing():
+	default:
+		t.Fatal("client wasn't disconnected")
+	}
+
+	// The rooms may broadcast to the client until they have stopped, so
+	// its send channel stays open until its transport ends its session
+	h.RoomManager.BroadcastToRoom(roomID, []byte(`{"type":"message"}`), nil)
+	select {
+	case _, ok := <-client.Send:
+		if !ok {
+			t.Fatal("send channel closed while the client was in a room")
+		}
+	default:
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for h.RoomManager.GetRoomCount() != rooms-2 {
+		if time.Now().After(deadline) {
+			t.Fatal("workspace rooms still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	h.RoomManager.LeaveRoomAsync(client, roomID)
+	h.Unregister <- client
+	if _, ok := <-client.Send; ok {
+		t.Fatal("send channel left open after the client unregistered")
+	}
+}
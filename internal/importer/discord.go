@@ -0,0 +1,109 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// discordTypes are the types of Discord messages people posted; the others
+// record joins, pins and calls, which aren't imported
+var discordTypes = map[string]bool{
+	"Default": true,
+	"Reply":   true,
+}
+
+type discordUser struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Nickname string `json:"nickname"`
+}
+
+type discordExport struct {
+	Channel struct {
+		ID    string `json:"id"`
+		Type  string `json:"type"`
+		Name  string `json:"name"`
+		Topic string `json:"topic"`
+	} `json:"channel"`
+	Messages []struct {
+		ID          string      `json:"id"`
+		Type        string      `json:"type"`
+		Timestamp   time.Time   `json:"timestamp"`
+		Content     string      `json:"content"`
+		IsPinned    bool        `json:"isPinned"`
+		Author      discordUser `json:"author"`
+		Attachments []struct {
+			URL string `json:"url"`
+		} `json:"attachments"`
+		Mentions []discordUser `json:"mentions"`
+	} `json:"messages"`
+}
+
+// ReadDiscord reads channels exported by DiscordChatExporter as JSON, one
+// file per channel. Direct and group DMs are imported as group conversations.
+func ReadDiscord(filenames ...string) (*Export, error) {
+	export := &Export{Source: SourceDiscord}
+	users := make(map[string]User)
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var exported discordExport
+		if err := json.Unmarshal(data, &exported); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		ch := &Channel{
+			ID:      exported.Channel.ID,
+			Name:    exported.Channel.Name,
+			Topic:   exported.Channel.Topic,
+			Private: strings.HasPrefix(exported.Channel.Type, "Direct"),
+		}
+		if ch.Private {
+			ch.Name = ""
+		}
+		for _, m := range exported.Messages {
+			if !discordTypes[m.Type] {
+				continue
+			}
+			users[m.Author.Name] = User{Name: m.Author.Name, DisplayName: m.Author.Nickname}
+
+			// Mentions are usually written out already, but not by every version
+			content := m.Content
+			var mentions []string
+			for _, u := range m.Mentions {
+				mentions = append(mentions, u.Name)
+				content = strings.ReplaceAll(content, "<@"+u.ID+">", "@"+u.Name)
+				content = strings.ReplaceAll(content, "<@!"+u.ID+">", "@"+u.Name)
+			}
+			for _, a := range m.Attachments {
+				content = strings.TrimSpace(content + "\n" + a.URL)
+			}
+			ch.Messages = append(ch.Messages, Message{
+				ID:        m.ID,
+				Author:    m.Author.Name,
+				Content:   content,
+				Mentions:  mentions,
+				Timestamp: m.Timestamp,
+				Pinned:    m.IsPinned,
+			})
+		}
+		sort.SliceStable(ch.Messages, func(i, j int) bool {
+			return ch.Messages[i].Timestamp.Before(ch.Messages[j].Timestamp)
+		})
+		export.Channels = append(export.Channels, ch)
+	}
+
+	for _, u := range users {
+		export.Users = append(export.Users, u)
+	}
+	sort.Slice(export.Users, func(i, j int) bool {
+		return export.Users[i].Name < export.Users[j].Name
+	})
+	return export, nil
+}
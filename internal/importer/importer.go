@@ -0,0 +1,370 @@
+// Package importer moves a community's history from another chat platform
+// into storage: Slack export zips and Discord channels exported as JSON are
+// read into a common shape, then written as rooms, profiles and messages that
+// keep their original authors and times.
+package importer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/ledger"
+	"realtime-chat/internal/markdown"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/store"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Sources of an export
+const (
+	SourceSlack   = "slack"
+	SourceDiscord = "discord"
+)
+
+// Export is what was read from another platform's export
+type Export struct {
+	Source   string
+	Users    []User
+	Channels []*Channel
+}
+
+// User is a user of the other platform
+type User struct {
+	Name        string // Unique name the user's messages are attributed by
+	DisplayName string
+}
+
+// Channel is a channel of the other platform and its messages, oldest first
+type Channel struct {
+	ID          string // Unique in the export
+	Name        string
+	Topic       string
+	Description string
+	CreatedBy   string // Name of the user who created it, when known
+	CreatedAt   time.Time
+
+	// Private channels and group DMs are imported as group conversations of
+	// their members, whom only they are visible to
+	Private bool
+	Members []string
+
+	Messages []Message
+}
+
+// Message is a message of a channel
+type Message struct {
+	ID        string   // Unique in its channel
+	Author    string   // Name of the user who posted it
+	Content   string   // Markdown
+	Mentions  []string // Names of the users it mentions as @name
+	Timestamp time.Time
+	Pinned    bool
+}
+
+// Options control how an export is written to storage
+type Options struct {
+	Workspace string            // Workspace the rooms are created in; "" for the server's own rooms
+	Users     map[string]string // Local usernames of the export's users, by name; others are derived from their names
+}
+
+// Report counts what an import wrote
+type Report struct {
+	Rooms    int `json:"rooms"`
+	Skipped  int `json:"skipped"` // Channels left out because an earlier import created their rooms
+	Messages int `json:"messages"`
+	Profiles int `json:"profiles"`
+}
+
+// Import writes an export to st. Each channel becomes a room with its
+// messages as history, chained by sealer as if they had been posted here,
+// and each user without a profile gets one. Rooms record the channel they
+// were imported from, and channels imported before into the workspace are
+// skipped so an import can be run again after it failed part way.
+func Import(st store.Store, sealer *ledger.Sealer, export *Export, opts Options) (*Report, error) {
+	for name, username := range opts.Users {
+		if !hub.ValidUsername(username) {
+			return nil, fmt.Errorf("user %s: %q isn't a valid username", name, username)
+		}
+	}
+	if opts.Workspace != "" {
+		workspaces, err := st.LoadWorkspaces()
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, w := range workspaces {
+			found = found || w.ID == opts.Workspace
+		}
+		if !found {
+			return nil, fmt.Errorf("no workspace %q", opts.Workspace)
+		}
+	}
+
+	rooms, err := st.LoadRooms()
+	if err != nil {
+		return nil, err
+	}
+	slugs := make(map[string]bool)
+	importedBefore := make(map[string]bool)
+	for _, rec := range rooms {
+		if rec.Workspace != opts.Workspace {
+			continue
+		}
+		if rec.Slug != "" {
+			slugs[rec.Slug] = true
+		}
+		if rec.ImportedFrom != "" {
+			importedBefore[rec.ImportedFrom] = true
+		}
+	}
+
+	// Only users with an account own the rooms they created, since anyone
+	// could connect under another name
+	accounts, err := st.LoadAccounts()
+	if err != nil {
+		return nil, err
+	}
+	claimed := make(map[string]bool, len(accounts))
+	for _, account := range accounts {
+		claimed[account.Username] = true
+	}
+
+	im := &importer{
+		store:     st,
+		sealer:    sealer,
+		source:    export.Source,
+		workspace: opts.Workspace,
+		usernames: opts.Users,
+		claimed:   claimed,
+		report:    &Report{},
+	}
+	if err := im.profiles(export.Users); err != nil {
+		return im.report, err
+	}
+
+	for _, ch := range export.Channels {
+		rec := im.room(ch)
+		if importedBefore[rec.ImportedFrom] {
+			im.report.Skipped++
+			continue
+		}
+		importedBefore[rec.ImportedFrom] = true
+		if rec.Slug != "" {
+			// Channels whose slug is taken, by a room here or another
+			// channel, get numbered ones
+			base := rec.Slug
+			for n := 2; slugs[rec.Slug]; n++ {
+				suffix := fmt.Sprintf("-%d", n)
+				rec.Slug = strings.TrimRight(base[:min(len(base), 40-len(suffix))], "-") + suffix
+			}
+			slugs[rec.Slug] = true
+		}
+
+		if err := im.history(rec, ch); err != nil {
+			return im.report, fmt.Errorf("channel %s: %w", ch.Name, err)
+		}
+		if err := st.SaveRoom(rec); err != nil {
+			return im.report, fmt.Errorf("channel %s: %w", ch.Name, err)
+		}
+		im.report.Rooms++
+	}
+	return im.report, nil
+}
+
+// importer writes one export
+type importer struct {
+	store     store.Store
+	sealer    *ledger.Sealer
+	source    string
+	workspace string
+	usernames map[string]string
+	claimed   map[string]bool // Usernames with an account
+	report    *Report
+}
+
+// profiles creates a profile for every user who has none
+func (im *importer) profiles(users []User) error {
+	existing, err := im.store.LoadProfiles()
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool, len(existing))
+	for _, profile := range existing {
+		have[profile.Username] = true
+	}
+
+	now := time.Now().UTC()
+	for _, user := range users {
+		username := im.username(user.Name)
+		if have[username] {
+			continue
+		}
+		have[username] = true
+		displayName := user.DisplayName
+		if displayName == username {
+			displayName = ""
+		}
+		if err := im.store.SaveProfile(&store.ProfileRecord{Username: username, DisplayName: displayName, UpdatedAt: now}); err != nil {
+			return fmt.Errorf("user %s: %w", user.Name, err)
+		}
+		im.report.Profiles++
+	}
+	return nil
+}
+
+// room returns the record of the room a channel is imported as
+func (im *importer) room(ch *Channel) *store.RoomRecord {
+	createdBy := room.SystemUser
+	if username := im.username(ch.CreatedBy); ch.CreatedBy != "" && im.claimed[username] {
+		createdBy = username
+	}
+	createdAt := ch.CreatedAt
+	if createdAt.IsZero() && len(ch.Messages) > 0 {
+		createdAt = ch.Messages[0].Timestamp
+	}
+	rec := &store.RoomRecord{
+		ID:           newRoomID(),
+		Name:         ch.Name,
+		CreatedBy:    createdBy,
+		CreatedAt:    createdAt.UTC(),
+		Mode:         string(room.ModeNormal),
+		Topic:        ch.Topic,
+		Description:  ch.Description,
+		Workspace:    im.workspace,
+		ImportedFrom: im.source + ":" + ch.ID,
+	}
+	if !ch.Private {
+		rec.Slug = slugOf(ch.Name)
+		return rec
+	}
+
+	rec.Mode = string(room.ModeGroup)
+	members := make(map[string]bool)
+	for _, name := range ch.Members {
+		members[im.username(name)] = true
+	}
+	for _, msg := range ch.Messages {
+		members[im.username(msg.Author)] = true
+	}
+	for username := range members {
+		rec.Participants = append(rec.Participants, username)
+	}
+	sort.Strings(rec.Participants)
+	if rec.Name == "" {
+		rec.Name = room.GroupName(rec.Participants)
+	}
+	return rec
+}
+
+// history writes a channel's messages as the history of rec, pinning those
+// that were pinned
+func (im *importer) history(rec *store.RoomRecord, ch *Channel) error {
+	events := make([]*store.MessageEvent, 0, len(ch.Messages))
+	prev := ""
+	for _, msg := range ch.Messages {
+		if strings.TrimSpace(msg.Content) == "" {
+			continue
+		}
+		content := msg.Content
+		for _, name := range msg.Mentions {
+			if username := im.username(name); username != name {
+				content = strings.ReplaceAll(content, "@"+name, "@"+username)
+			}
+		}
+		html, entities := markdown.Render(content)
+		event := &store.MessageEvent{
+			Type:      history.EventMessage,
+			MessageID: im.messageID(ch, msg),
+			RoomID:    rec.ID,
+			Seq:       int64(len(events) + 1),
+			Username:  im.username(msg.Author),
+			Content:   content,
+			Timestamp: msg.Timestamp.UTC(),
+			HTML:      html,
+			Entities:  entities,
+		}
+		im.sealer.Seal(prev, event)
+		prev = event.Hash
+		events = append(events, event)
+		if msg.Pinned {
+			rec.Pins = append(rec.Pins, event.MessageID)
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	if err := im.store.ReplaceEvents(rec.ID, events); err != nil {
+		return err
+	}
+	im.report.Messages += len(events)
+	return nil
+}
+
+// username returns the local username of an export's user: the one it was
+// mapped to, or its name without the whitespace and control characters
+// usernames can't have
+func (im *importer) username(name string) string {
+	if username, ok := im.usernames[name]; ok {
+		return username
+	}
+	username := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if runes := []rune(username); len(runes) > hub.MaxUsernameLength {
+		username = string(runes[:hub.MaxUsernameLength])
+	}
+	if username == "" {
+		return "unknown"
+	}
+	return username
+}
+
+// messageID derives a message's ID from where it came from, so importing a
+// channel again gives its messages the same IDs
+func (im *importer) messageID(ch *Channel, msg Message) string {
+	sum := sha256.Sum256([]byte(im.source + "/" + ch.ID + "/" + msg.ID))
+	return "msg_" + hex.EncodeToString(sum[:8])
+}
+
+// slugOf returns the slug a channel's name gives its room, or "" when the
+// name can't make one
+func slugOf(name string) string {
+	slug := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return unicode.ToLower(r)
+		default:
+			return '-'
+		}
+	}, name)
+	slug = strings.Trim(slug, "-")
+	if len(slug) > 40 {
+		slug = strings.TrimRight(slug[:40], "-")
+	}
+	if !room.ValidSlug(slug) {
+		return ""
+	}
+	return slug
+}
+
+// newRoomID generates a room ID like those the server creates rooms with
+func newRoomID() string {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, 6)
+	rand.Read(b)
+	for i := range b {
+		b[i] = charset[int(b[i])%len(charset)]
+	}
+	return "room_" + time.Now().Format("20060102150405") + "_" + string(b)
+}
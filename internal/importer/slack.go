@@ -0,0 +1,258 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slackSubtypes are the subtypes of Slack messages people posted; the others
+// record joins, leaves and channel changes, which aren't imported
+var slackSubtypes = map[string]bool{
+	"":                 true,
+	"bot_message":      true,
+	"file_share":       true,
+	"me_message":       true,
+	"thread_broadcast": true,
+}
+
+// slackToken matches the <...> tokens Slack writes mentions and links as
+var slackToken = regexp.MustCompile(`<([^<>]+)>`)
+
+type slackUser struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	RealName string `json:"real_name"`
+	Profile  struct {
+		DisplayName string `json:"display_name"`
+		RealName    string `json:"real_name"`
+	} `json:"profile"`
+}
+
+type slackChannel struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Created int64    `json:"created"`
+	Creator string   `json:"creator"`
+	Members []string `json:"members"`
+	Topic   struct {
+		Value string `json:"value"`
+	} `json:"topic"`
+	Purpose struct {
+		Value string `json:"value"`
+	} `json:"purpose"`
+}
+
+type slackMessage struct {
+	Type     string   `json:"type"`
+	Subtype  string   `json:"subtype"`
+	User     string   `json:"user"`
+	Username string   `json:"username"` // Name a bot posted as
+	Text     string   `json:"text"`
+	TS       string   `json:"ts"`
+	PinnedTo []string `json:"pinned_to"`
+	Files    []struct {
+		Name       string `json:"name"`
+		URLPrivate string `json:"url_private"`
+	} `json:"files"`
+}
+
+// ReadSlack reads a Slack workspace export zip: its users, public channels
+// (channels.json), private channels (groups.json) and group DMs (mpims.json)
+// with their messages. Direct messages aren't imported.
+func ReadSlack(filename string) (*Export, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	// Some tools zip the export inside a directory
+	root := "."
+	if matches, _ := fs.Glob(zr, "*/users.json"); len(matches) == 1 {
+		root = path.Dir(matches[0])
+	}
+
+	var users []slackUser
+	if err := readZipJSON(zr, path.Join(root, "users.json"), &users); err != nil {
+		return nil, err
+	}
+	export := &Export{Source: SourceSlack}
+	names := make(map[string]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.Name
+		displayName := u.Profile.DisplayName
+		if displayName == "" {
+			displayName = u.RealName
+		}
+		export.Users = append(export.Users, User{Name: u.Name, DisplayName: displayName})
+	}
+
+	for _, list := range []struct {
+		file    string
+		private bool
+	}{
+		{"channels.json", false},
+		{"groups.json", true},
+		{"mpims.json", true},
+	} {
+		var channels []slackChannel
+		err := readZipJSON(zr, path.Join(root, list.file), &channels)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range channels {
+			ch := &Channel{
+				ID:          c.ID,
+				Name:        c.Name,
+				Topic:       c.Topic.Value,
+				Description: c.Purpose.Value,
+				CreatedBy:   names[c.Creator],
+				CreatedAt:   time.Unix(c.Created, 0),
+				Private:     list.private,
+			}
+			if list.private {
+				for _, id := range c.Members {
+					if name, ok := names[id]; ok {
+						ch.Members = append(ch.Members, name)
+					}
+				}
+			}
+			// Group DMs are named after their members, as group conversations are here
+			if list.file == "mpims.json" {
+				ch.Name = ""
+			}
+			if ch.Messages, err = readSlackMessages(zr, path.Join(root, c.Name), c.ID, names); err != nil {
+				return nil, fmt.Errorf("channel %s: %w", c.Name, err)
+			}
+			export.Channels = append(export.Channels, ch)
+		}
+	}
+	return export, nil
+}
+
+// readSlackMessages reads the messages of a channel from the file of each day
+// in its directory
+func readSlackMessages(zr *zip.ReadCloser, dir, channelID string, names map[string]string) ([]Message, error) {
+	days, err := fs.Glob(zr, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(days)
+
+	var messages []Message
+	for _, day := range days {
+		var posted []slackMessage
+		if err := readZipJSON(zr, day, &posted); err != nil {
+			return nil, err
+		}
+		for _, m := range posted {
+			if m.Type != "message" || !slackSubtypes[m.Subtype] {
+				continue
+			}
+			timestamp, err := slackTime(m.TS)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", day, err)
+			}
+			author := names[m.User]
+			if author == "" {
+				author = m.Username
+			}
+			content, mentions := slackText(m.Text, names)
+			for _, f := range m.Files {
+				if f.URLPrivate != "" {
+					content = strings.TrimSpace(content + "\n" + f.URLPrivate)
+				}
+			}
+			pinned := false
+			for _, id := range m.PinnedTo {
+				pinned = pinned || id == channelID
+			}
+			messages = append(messages, Message{
+				ID:        m.TS,
+				Author:    author,
+				Content:   content,
+				Mentions:  mentions,
+				Timestamp: timestamp,
+				Pinned:    pinned,
+			})
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp.Before(messages[j].Timestamp)
+	})
+	return messages, nil
+}
+
+// slackText returns the text of a Slack message as markdown, with its
+// mentions naming users and its links written out, and the users it mentions
+func slackText(text string, names map[string]string) (string, []string) {
+	var mentions []string
+	text = slackToken.ReplaceAllStringFunc(text, func(token string) string {
+		target, label, _ := strings.Cut(token[1:len(token)-1], "|")
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if name, ok := names[target[1:]]; ok {
+				mentions = append(mentions, name)
+				return "@" + name
+			}
+			return "@" + strings.TrimPrefix(label, "@")
+		case strings.HasPrefix(target, "#"):
+			return "#" + label
+		case strings.HasPrefix(target, "!"):
+			if label != "" {
+				return label
+			}
+			return "@" + strings.TrimPrefix(target, "!")
+		case strings.HasPrefix(target, "mailto:") && label != "":
+			return label
+		case label != "" && label != target:
+			return "[" + label + "](" + target + ")"
+		default:
+			return target
+		}
+	})
+	return html.UnescapeString(text), mentions
+}
+
+// slackTime parses a Slack message timestamp, seconds since the epoch with
+// microseconds after the point
+func slackTime(ts string) (time.Time, error) {
+	sec, frac, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", ts)
+	}
+	var usec int64
+	if frac != "" {
+		if usec, err = strconv.ParseInt((frac + "000000")[:6], 10, 64); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", ts)
+		}
+	}
+	return time.Unix(s, usec*1000).UTC(), nil
+}
+
+// readZipJSON decodes a JSON file of a zip into v
+func readZipJSON(zr *zip.ReadCloser, name string, v interface{}) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
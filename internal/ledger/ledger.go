@@ -0,0 +1,129 @@
+package ledger
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"realtime-chat/internal/store"
+)
+
+// Algorithm names how events are hashed and signed
+const Algorithm = "sha256+ed25519"
+
+// Sealer links each event of a room's history to the one before it by
+// hash, and signs the hashes when it has a key, so a transcript of the
+// events can later be shown to be complete and unmodified
+type Sealer struct {
+	key ed25519.PrivateKey // nil to chain events without signing them
+}
+
+// Problem describes the first event of a transcript that fails verification
+type Problem struct {
+	Index     int    `json:"index"` // Position of the event in the transcript
+	MessageID string `json:"messageId"`
+	Reason    string `json:"reason"`
+}
+
+// Result is the outcome of verifying a transcript
+type Result struct {
+	Valid   bool     `json:"valid"`
+	Events  int      `json:"events"`
+	Signed  bool     `json:"signed"`         // Every event carries a valid signature
+	Head    string   `json:"head,omitempty"` // Hash of the last event of a valid transcript
+	Problem *Problem `json:"problem,omitempty"`
+}
+
+// New creates a sealer that signs with key, or only chains events when key is nil
+func New(key ed25519.PrivateKey) *Sealer {
+	return &Sealer{key: key}
+}
+
+// PublicKey returns the base64 public key that verifies signatures, or ""
+// when events aren't signed
+func (s *Sealer) PublicKey() string {
+	if s.key == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// Seal links an event to the hash of the event before it, which is empty
+// for a room's first event, and sets its hash and signature
+func (s *Sealer) Seal(prev string, event *store.MessageEvent) {
+	event.PrevHash = prev
+	event.Hash = Hash(event)
+	event.Signature = ""
+	if s.key != nil {
+		event.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, []byte(event.Hash)))
+	}
+}
+
+// Linked reports whether an event still has its hash and follows the event
+// whose hash is prev. Signatures aren't checked, which keeps it cheap
+// enough to run over a room's whole history as it loads.
+func Linked(prev string, event *store.MessageEvent) bool {
+	return event.PrevHash == prev && event.Hash == Hash(event)
+}
+
+// Hash returns the hex SHA-256 of an event's JSON encoding without its hash
+// and signature, which covers the hash of the event before it
+func Hash(event *store.MessageEvent) string {
+	unsealed := *event
+	unsealed.Hash = ""
+	unsealed.Signature = ""
+	data, _ := json.Marshal(&unsealed)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks that every event of a transcript has its hash and follows
+// the event before it. Its first event may follow events left out of the
+// transcript. With a public key every event must also be signed by it.
+// Redacted events had their content blanked after they were hashed, so only
+// their place in the chain and their signature can be checked.
+func Verify(events []*store.MessageEvent, publicKey ed25519.PublicKey) *Result {
+	result := &Result{Events: len(events), Signed: publicKey != nil}
+	head := ""
+	for i, event := range events {
+		reason := ""
+		switch {
+		case event.Hash == "":
+			reason = "event is not sealed"
+		case event.Redacted && (event.Content != "" || event.HTML != "" || len(event.Entities) > 0 || event.Encryption != nil || event.GIF != nil):
+			reason = "redacted event carries content"
+		case event.Hash != Hash(event) && !event.Redacted:
+			reason = "event was modified"
+		case i > 0 && event.PrevHash != events[i-1].Hash:
+			reason = "events were removed, added or reordered before this one"
+		case publicKey != nil && !validSignature(publicKey, event):
+			reason = "signature is invalid"
+		}
+		if reason != "" {
+			result.Signed = false
+			result.Problem = &Problem{Index: i, MessageID: event.MessageID, Reason: reason}
+			return result
+		}
+		head = event.Hash
+	}
+	result.Valid = true
+	result.Head = head
+	return result
+}
+
+// ParsePublicKey decodes a base64 Ed25519 public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d base64 encoded bytes", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// validSignature reports whether an event's hash is signed by publicKey
+func validSignature(publicKey ed25519.PublicKey, event *store.MessageEvent) bool {
+	signature, err := base64.StdEncoding.DecodeString(event.Signature)
+	return err == nil && ed25519.Verify(publicKey, []byte(event.Hash), signature)
+}
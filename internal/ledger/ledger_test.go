@@ -0,0 +1,167 @@
+package ledger
+
+import (
+	"crypto/ed25519"
+	"realtime-chat/internal/store"
+	"testing"
+	"time"
+)
+
+// sealedHistory returns n message events chained and signed by sealer
+func sealedHistory(sealer *Sealer, n int) []*store.MessageEvent {
+	events := make([]*store.MessageEvent, n)
+	prev := ""
+	for i := range events {
+		events[i] = &store.MessageEvent{
+			Type:      "message",
+			RoomID:    "room_1",
+			MessageID: string(rune('a' + i)),
+			Username:  "alice",
+			Content:   "message " + string(rune('a'+i)),
+			Timestamp: time.Unix(int64(1700000000+i), 0).UTC(),
+			Seq:       int64(i + 1),
+		}
+		sealer.Seal(prev, events[i])
+		prev = events[i].Hash
+	}
+	return events
+}
+
+// newKey returns a signing key and the public key that verifies it
+func newKey(t *testing.T) (ed25519.PrivateKey, ed25519.PublicKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, public
+}
+
+func TestVerify(t *testing.T) {
+	private, public := newKey(t)
+	events := sealedHistory(New(private), 5)
+
+	result := Verify(events, public)
+	if !result.Valid || !result.Signed || result.Head != events[4].Hash || result.Events != 5 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if !Linked(events[2].Hash, events[3]) || Linked(events[1].Hash, events[3]) {
+		t.Error("Linked disagrees with the chain")
+	}
+
+	// A transcript may start after events left out of it
+	if result := Verify(events[2:], public); !result.Valid {
+		t.Errorf("transcript from the middle rejected: %+v", result.Problem)
+	}
+
+	// Chains without signatures verify without a public key
+	if result := Verify(sealedHistory(New(nil), 3), nil); !result.Valid || result.Signed {
+		t.Errorf("unsigned chain: %+v", result)
+	}
+}
+
+func TestVerifyProblems(t *testing.T) {
+	private, public := newKey(t)
+	_, other := newKey(t)
+
+	for _, tt := range []struct {
+		name   string
+		tamper func([]*store.MessageEvent) []*store.MessageEvent
+		key    ed25519.PublicKey
+		index  int
+		reason string
+	}{
+		{"edited", func(e []*store.MessageEvent) []*store.MessageEvent {
+			e[2].Content = "forged"
+			return e
+		}, public, 2, "event was modified"},
+		{"removed", func(e []*store.MessageEvent) []*store.MessageEvent {
+			return append(e[:2], e[3:]...)
+		}, public, 2, "events were removed, added or reordered before this one"},
+		{"reordered", func(e []*store.MessageEvent) []*store.MessageEvent {
+			e[1], e[2] = e[2], e[1]
+			return e
+		}, public, 1, "events were removed, added or reordered before this one"},
+		{"unsealed", func(e []*store.MessageEvent) []*store.MessageEvent {
+			e[3].Hash = ""
+			return e
+		}, public, 3, "event is not sealed"},
+		{"rehashed without the key", func(e []*store.MessageEvent) []*store.MessageEvent {
+			// Recomputing the hashes of an edited event and those after it
+			// keeps the chain but can't forge the signatures
+			e[3].Content = "forged"
+			for i := 3; i < len(e); i++ {
+				e[i].PrevHash = e[i-1].Hash
+				e[i].Hash = Hash(e[i])
+			}
+			return e
+		}, public, 3, "signature is invalid"},
+		{"signed by another key", func(e []*store.MessageEvent) []*store.MessageEvent {
+			return e
+		}, other, 0, "signature is invalid"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			events := tt.tamper(sealedHistory(New(private), 5))
+			result := Verify(events, tt.key)
+			if result.Valid || result.Signed || result.Problem == nil {
+				t.Fatalf("tampered transcript verified: %+v", result)
+			}
+			if result.Problem.Index != tt.index || result.Problem.Reason != tt.reason {
+				t.Errorf("problem is %+v, want index %d: %s", result.Problem, tt.index, tt.reason)
+			}
+		})
+	}
+}
+
+// redact blanks an event's content as public views of deleted messages do
+func redact(event *store.MessageEvent) {
+	event.Content, event.HTML, event.Entities = "", "", nil
+	event.Redacted = true
+}
+
+func TestVerifyRedacted(t *testing.T) {
+	private, public := newKey(t)
+	events := sealedHistory(New(private), 5)
+	redact(events[1])
+	redact(events[3])
+
+	// Redacted events keep their hash, signature and place in the chain
+	if result := Verify(events, public); !result.Valid || !result.Signed {
+		t.Fatalf("transcript with redacted events rejected: %+v", result.Problem)
+	}
+
+	// but can't be used to pass off other content
+	events[3].Content = "forged"
+	if result := Verify(events, public); result.Valid || result.Problem.Index != 3 || result.Problem.Reason != "redacted event carries content" {
+		t.Errorf("redacted event with content verified: %+v", result)
+	}
+
+	// nor removed, or moved elsewhere in the chain
+	events = sealedHistory(New(private), 5)
+	redact(events[2])
+	if result := Verify(append(events[:2], events[3:]...), public); result.Valid {
+		t.Error("transcript missing a redacted event verified")
+	}
+	events = sealedHistory(New(private), 5)
+	redact(events[1])
+	redact(events[2])
+	events[1], events[2] = events[2], events[1]
+	if result := Verify(events, public); result.Valid {
+		t.Error("reordered redacted events verified")
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	private, public := newKey(t)
+	encoded := New(private).PublicKey()
+	parsed, err := ParsePublicKey(encoded)
+	if err != nil || !parsed.Equal(public) {
+		t.Fatalf("ParsePublicKey(%q) = %v, %v", encoded, parsed, err)
+	}
+	if New(nil).PublicKey() != "" {
+		t.Error("sealer without a key has a public key")
+	}
+	if _, err := ParsePublicKey("c2hvcnQ="); err == nil {
+		t.Error("short public key accepted")
+	}
+}
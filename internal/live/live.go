@@ -0,0 +1,350 @@
+// Package live serves read-only views of rooms to viewers who aren't
+// logged in, so a room can be shown as a live feed, such as the questions
+// screen at an event. A room whose admins turned on its live setting is
+// watched at /rooms/{slug}/live: browsers get a page of its recent messages
+// that keeps itself up to date, and clients asking for text/event-stream
+// get the room's messages as server-sent events:
+//
+//	event: message   A message posted; its id is the message's seq
+//	event: edit      A message edited
+//	event: delete    A message deleted or removed
+//
+// A stream starts with the room's recent messages or, for a viewer
+// reconnecting with Last-Event-ID or asking for ?after=seq, with the
+// messages it missed.
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/workspace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types of the stream
+const (
+	EventMessage = "message"
+	EventEdit    = "edit"
+	EventDelete  = "delete"
+)
+
+// Event is a change to a room's messages as sent to viewers
+type Event struct {
+	Type      string     `json:"-"`
+	ID        string     `json:"id"`
+	Seq       int64      `json:"seq,omitempty"`
+	Username  string     `json:"username,omitempty"`
+	Content   string     `json:"content,omitempty"`
+	HTML      string     `json:"html,omitempty"`
+	GIF       *store.GIF `json:"gif,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
+}
+
+// recentMessages is how many of a room's newest messages its page and new
+// streams start with
+const recentMessages = 50
+
+// maxViewers is the most viewers streaming at once, across every room
+const maxViewers = 10000
+
+// queueSize is the most events waiting to be sent to a viewer; a viewer
+// that falls further behind is disconnected and catches up when it reconnects
+const queueSize = 64
+
+// keepAlive is how often an idle stream is sent a comment, which also ends
+// streams of rooms no longer watchable
+const keepAlive = 30 * time.Second
+
+// Feed passes the changes to live rooms' messages to their viewers
+type Feed struct {
+	hub *hub.Hub
+
+	// Guards viewers
+	mutex sync.Mutex
+
+	// Streaming viewers by room ID
+	viewers map[string]map[*viewer]bool
+}
+
+// viewer is one viewer's stream of a room
+type viewer struct {
+	events chan *Event
+
+	// Closed when the viewer fell behind; guarded by Feed.mutex
+	dropped chan struct{}
+}
+
+// New returns a feed of h's live rooms. It must be called before the hub
+// runs, since it observes the hub's message history.
+func New(h *hub.Hub) *Feed {
+	f := &Feed{
+		hub:     h,
+		viewers: make(map[string]map[*viewer]bool),
+	}
+	h.History.Observe(f.observe)
+	return f
+}
+
+// Register adds the live view of every room to mux
+func (f *Feed) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /rooms/{slug}/live", f.serve)
+}
+
+// observe passes posted, edited and deleted messages to the viewers of
+// their room
+func (f *Feed) observe(event *store.MessageEvent, _ *history.Message) {
+	var live *Event
+	switch event.Type {
+	case history.EventMessage:
+		live = &Event{
+			Type:      EventMessage,
+			ID:        event.MessageID,
+			Seq:       event.Seq,
+			Username:  event.Username,
+			Content:   event.Content,
+			HTML:      event.HTML,
+			GIF:       event.GIF,
+			Timestamp: event.Timestamp,
+		}
+	case history.EventEdit:
+		live = &Event{Type: EventEdit, ID: event.MessageID, Content: event.Content, HTML: event.HTML, Timestamp: event.Timestamp}
+	case history.EventDelete, history.EventExpire, history.EventPrune, history.EventErase:
+		if event.MessageID == "" {
+			return
+		}
+		live = &Event{Type: EventDelete, ID: event.MessageID, Timestamp: event.Timestamp}
+	default:
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for v := range f.viewers[event.RoomID] {
+		select {
+		case v.events <- live:
+		default:
+			f.dropLocked(event.RoomID, v)
+		}
+	}
+}
+
+// room returns the live room a request names by its slug or ID
+func (f *Feed) room(r *http.Request) (*room.Room, bool) {
+	rm, exists := f.hub.Room(workspace.FromContext(r.Context()), r.PathValue("slug"))
+	if !exists || !f.watchable(rm) {
+		return nil, false
+	}
+	return rm, true
+}
+
+// watchable reports whether anyone may watch a room: it is live, and its
+// messages aren't encrypted or private to a group
+func (f *Feed) watchable(rm *room.Room) bool {
+	if _, exists := f.hub.RoomManager.GetRoom(rm.ID); !exists {
+		return false
+	}
+	return rm.IsLive() && !rm.IsEncrypted() && !rm.IsGroup()
+}
+
+// serve sends the live view of a room, as a page or as a stream of events
+func (f *Feed) serve(w http.ResponseWriter, r *http.Request) {
+	rm, exists := f.room(r)
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	// Workspaces closed to guests only show their rooms to members
+	if !f.hub.Workspaces.Admits(rm.Workspace, "", false) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		f.stream(w, r, rm)
+		return
+	}
+	f.page(w, rm)
+}
+
+// stream sends a room's events to a viewer until the viewer goes away, the
+// room stops being watchable or the hub stops
+func (f *Feed) stream(w http.ResponseWriter, r *http.Request, rm *room.Room) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Messages the viewer missed are sent after those it last received
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	var afterSeq int64 = -1
+	if after != "" {
+		seq, err := strconv.ParseInt(after, 10, 64)
+		if err != nil || seq < 0 {
+			http.Error(w, "after must be a sequence number", http.StatusBadRequest)
+			return
+		}
+		afterSeq = seq
+	}
+
+	v, ok := f.subscribe(rm.ID)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(keepAlive/time.Second)))
+		http.Error(w, "Too many viewers, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	defer f.unsubscribe(rm.ID, v)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Subscribed first, so no message falls between those caught up on and
+	// the live ones; messages sent twice are skipped by their seq
+	backlog, err := f.backlog(rm.ID, afterSeq)
+	if err != nil {
+		log.Printf("Error loading live history for %s: %v", rm.ID, err)
+		return
+	}
+	sentSeq := afterSeq
+	for _, msg := range backlog {
+		if !msg.Deleted {
+			writeEvent(w, messageEvent(msg))
+		}
+		sentSeq = msg.Seq
+	}
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-v.events:
+			if event.Type == EventMessage {
+				if event.Seq <= sentSeq {
+					continue
+				}
+				sentSeq = event.Seq
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+
+		case <-ticker.C:
+			if !f.watchable(rm) {
+				return
+			}
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+
+		case <-v.dropped:
+			return
+		case <-r.Context().Done():
+			return
+		case <-f.hub.Done():
+			return
+		}
+	}
+}
+
+// backlog returns the messages of a room posted after afterSeq, or its
+// recent messages when afterSeq is negative, oldest first
+func (f *Feed) backlog(roomID string, afterSeq int64) ([]*history.Message, error) {
+	if afterSeq < 0 {
+		messages, _, err := f.hub.History.Page(roomID, "", "", recentMessages)
+		return messages, err
+	}
+
+	var messages []*history.Message
+	for {
+		page, _, more, err := f.hub.History.Since(roomID, afterSeq, history.MaxPageSize)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, page...)
+		if !more || len(page) == 0 {
+			return messages, nil
+		}
+		afterSeq = page[len(page)-1].Seq
+	}
+}
+
+// writeEvent writes an event in the text/event-stream format. Only
+// messages carry an id, so Last-Event-ID is the newest seq received.
+func writeEvent(w http.ResponseWriter, event *Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if event.Type == EventMessage {
+		fmt.Fprintf(w, "id: %d\n", event.Seq)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
+
+// messageEvent returns the event posting a message from the history
+func messageEvent(msg *history.Message) *Event {
+	return &Event{
+		Type:      EventMessage,
+		ID:        msg.ID,
+		Seq:       msg.Seq,
+		Username:  msg.Username,
+		Content:   msg.Content,
+		HTML:      msg.HTML,
+		GIF:       msg.GIF,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+// subscribe adds a viewer of a room, reporting false when there are too many
+func (f *Feed) subscribe(roomID string) (*viewer, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if metrics.LiveViewers.Value() >= maxViewers {
+		return nil, false
+	}
+	v := &viewer{events: make(chan *Event, queueSize), dropped: make(chan struct{})}
+	if f.viewers[roomID] == nil {
+		f.viewers[roomID] = make(map[*viewer]bool)
+	}
+	f.viewers[roomID][v] = true
+	metrics.LiveViewers.Add(1)
+	return v, true
+}
+
+// unsubscribe removes a viewer of a room
+func (f *Feed) unsubscribe(roomID string, v *viewer) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.dropLocked(roomID, v)
+}
+
+// dropLocked removes a viewer of a room and ends its stream; the caller
+// holds f.mutex
+func (f *Feed) dropLocked(roomID string, v *viewer) {
+	viewers := f.viewers[roomID]
+	if !viewers[v] {
+		return
+	}
+	delete(viewers, v)
+	if len(viewers) == 0 {
+		delete(f.viewers, roomID)
+	}
+	close(v.dropped)
+	metrics.LiveViewers.Add(-1)
+}
@@ -0,0 +1,180 @@
+package live
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/room"
+	"time"
+)
+
+// pageMessage is a message as the page shows it
+type pageMessage struct {
+	ID       string
+	Username string
+	Time     time.Time
+	HTML     template.HTML // Rendered and sanitized by the server
+	Content  string        // Shown as text when there's no HTML
+	GIF      string
+	GIFTitle string
+}
+
+// pageTemplate renders a room's live view. The script follows the stream
+// from the newest message shown, and the page works without it as a snapshot.
+var pageTemplate = template.Must(template.New("live").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}} · Live</title>
+<style>
+  body { margin: 0; font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #f5f6fa; color: #222; }
+  header { position: sticky; top: 0; background: #4a5fc1; color: #fff; padding: 12px 20px; display: flex; justify-content: space-between; align-items: center; }
+  header h1 { margin: 0; font-size: 1.2em; }
+  #status { font-size: 0.85em; opacity: 0.85; }
+  #messages { list-style: none; margin: 0 auto; padding: 12px 20px; max-width: 900px; }
+  .message { background: #fff; border-radius: 8px; padding: 10px 14px; margin: 8px 0; box-shadow: 0 1px 2px rgba(0,0,0,0.08); }
+  .meta { font-size: 0.8em; color: #666; margin-bottom: 4px; }
+  .meta strong { color: #4a5fc1; }
+  .content { overflow-wrap: anywhere; }
+  .content p { margin: 0; }
+  .content img { max-width: 100%; border-radius: 4px; }
+  .edited { font-size: 0.75em; color: #999; margin-left: 6px; }
+</style>
+</head>
+<body>
+<header><h1>{{.Name}}</h1><span id="status">Connecting…</span></header>
+<ol id="messages">
+{{- range .Messages}}
+<li class="message" id="m-{{.ID}}"><div class="meta"><strong>{{.Username}}</strong> <time datetime="{{.Time.Format "2006-01-02T15:04:05Z07:00"}}">{{.Time.Format "15:04"}}</time></div><div class="content">{{if .GIF}}<img src="{{.GIF}}" alt="{{.GIFTitle}}">{{else if .HTML}}{{.HTML}}{{else}}{{.Content}}{{end}}</div></li>
+{{- end}}
+</ol>
+<script>
+(function () {
+  var list = document.getElementById("messages");
+  var status = document.getElementById("status");
+
+  function atBottom() {
+    return window.innerHeight + window.scrollY >= document.body.scrollHeight - 40;
+  }
+  function fill(content, msg) {
+    content.textContent = "";
+    if (msg.gif) {
+      var img = document.createElement("img");
+      img.src = msg.gif.url;
+      img.alt = msg.gif.title || "";
+      content.appendChild(img);
+    } else if (msg.html) {
+      content.innerHTML = msg.html; // Sanitized by the server
+    } else {
+      content.textContent = msg.content;
+    }
+  }
+  function add(msg) {
+    if (document.getElementById("m-" + msg.id)) return;
+    var follow = atBottom();
+    var item = document.createElement("li");
+    item.className = "message";
+    item.id = "m-" + msg.id;
+    var meta = document.createElement("div");
+    meta.className = "meta";
+    var name = document.createElement("strong");
+    name.textContent = msg.username;
+    var time = document.createElement("time");
+    var date = new Date(msg.timestamp);
+    time.dateTime = msg.timestamp;
+    time.textContent = date.toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+    meta.appendChild(name);
+    meta.appendChild(document.createTextNode(" "));
+    meta.appendChild(time);
+    var content = document.createElement("div");
+    content.className = "content";
+    fill(content, msg);
+    item.appendChild(meta);
+    item.appendChild(content);
+    list.appendChild(item);
+    if (follow) window.scrollTo(0, document.body.scrollHeight);
+  }
+
+  window.scrollTo(0, document.body.scrollHeight);
+  if (!window.EventSource) return;
+  var source = new EventSource(location.pathname + "?after={{.LastSeq}}");
+  source.onopen = function () { status.textContent = "Live"; };
+  source.onerror = function () { status.textContent = "Reconnecting…"; };
+  source.addEventListener("message", function (e) { add(JSON.parse(e.data)); });
+  source.addEventListener("edit", function (e) {
+    var msg = JSON.parse(e.data);
+    var item = document.getElementById("m-" + msg.id);
+    if (!item) return;
+    fill(item.querySelector(".content"), msg);
+    if (!item.querySelector(".edited")) {
+      var edited = document.createElement("span");
+      edited.className = "edited";
+      edited.textContent = "(edited)";
+      item.querySelector(".meta").appendChild(edited);
+    }
+  });
+  source.addEventListener("delete", function (e) {
+    var item = document.getElementById("m-" + JSON.parse(e.data).id);
+    if (item) item.remove();
+  });
+})();
+</script>
+</body>
+</html>
+`))
+
+// page renders the live view of a room with its recent messages
+func (f *Feed) page(w http.ResponseWriter, rm *room.Room) {
+	messages, err := f.backlog(rm.ID, -1)
+	if err != nil {
+		log.Printf("Error loading live history for %s: %v", rm.ID, err)
+		http.Error(w, "Could not load messages", http.StatusInternalServerError)
+		return
+	}
+
+	// The stream follows on from the newest message, deleted or not
+	var lastSeq int64
+	shown := make([]pageMessage, 0, len(messages))
+	for _, msg := range messages {
+		lastSeq = msg.Seq
+		if msg.Deleted {
+			continue
+		}
+		shown = append(shown, newPageMessage(msg))
+	}
+	if len(messages) == 0 {
+		lastSeq, _ = f.hub.History.LastSeq(rm.ID)
+	}
+
+	name := rm.Name
+	if name == "" {
+		name = rm.GetSlug()
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	err = pageTemplate.Execute(w, map[string]interface{}{
+		"Name":     name,
+		"Messages": shown,
+		"LastSeq":  lastSeq,
+	})
+	if err != nil {
+		log.Printf("Error rendering live view of %s: %v", rm.ID, err)
+	}
+}
+
+// newPageMessage returns a message from the history as the page shows it
+func newPageMessage(msg *history.Message) pageMessage {
+	m := pageMessage{
+		ID:       msg.ID,
+		Username: msg.Username,
+		Time:     msg.Timestamp.UTC(),
+		HTML:     template.HTML(msg.HTML),
+		Content:  msg.Content,
+	}
+	if msg.GIF != nil {
+		m.GIF, m.GIFTitle = msg.GIF.URL, msg.GIF.Title
+	}
+	return m
+}
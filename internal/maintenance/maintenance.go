@@ -0,0 +1,174 @@
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/store"
+	"sync"
+	"time"
+)
+
+// ErrRunning is returned when a cleanup is requested while one is in progress
+var ErrRunning = errors.New("maintenance is already running")
+
+// Report describes what a cleanup removed
+type Report struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+
+	// Deleted messages purged after the tombstone retention period
+	TombstonesPurged int `json:"tombstonesPurged"`
+
+	// Messages removed by retention policies, in total and by room
+	MessagesPruned int            `json:"messagesPruned"`
+	PrunedByRoom   map[string]int `json:"prunedByRoom,omitempty"`
+
+	// Stored histories and read markers of rooms that no longer exist
+	OrphanedHistories   int `json:"orphanedHistories"`
+	OrphanedReadMarkers int `json:"orphanedReadMarkers"`
+
+	// Approximate storage freed, in bytes
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+
+	// Problems that didn't stop the rest of the cleanup
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Cleaner removes data that is no longer needed: tombstoned messages past
+// their retention, messages beyond their room's retention policy and data
+// left behind by deleted rooms
+type Cleaner struct {
+	history   *history.History
+	store     store.Store // may be nil, leaving only in-memory history to clean
+	retention time.Duration
+
+	// Policy returns the days and number of messages of history a room
+	// keeps, 0 keeping everything. May be nil, keeping all history.
+	Policy func(roomID string) (days, messages int)
+
+	mutex   sync.Mutex
+	running bool
+	last    *Report
+}
+
+// New creates a cleaner that keeps tombstones for the retention period
+func New(h *history.History, st store.Store, retention time.Duration) *Cleaner {
+	return &Cleaner{history: h, store: st, retention: retention}
+}
+
+// Run performs a cleanup. rooms returns the IDs of the rooms that exist; it is
+// called after stored data is listed so rooms created meanwhile are never
+// mistaken for orphans.
+func (c *Cleaner) Run(rooms func() []string) (*Report, error) {
+	c.mutex.Lock()
+	if c.running {
+		c.mutex.Unlock()
+		return nil, ErrRunning
+	}
+	c.running = true
+	c.mutex.Unlock()
+
+	report := &Report{StartedAt: time.Now()}
+	failed := func(format string, args ...interface{}) {
+		report.Errors = append(report.Errors, fmt.Sprintf(format, args...))
+	}
+
+	var historyRooms, markerRooms []string
+	if c.store != nil {
+		var err error
+		if historyRooms, err = c.store.HistoryRooms(); err != nil {
+			failed("list histories: %v", err)
+		}
+		if markerRooms, err = c.store.ReadMarkerRooms(); err != nil {
+			failed("list read markers: %v", err)
+		}
+	}
+
+	live := make(map[string]bool)
+	for _, roomID := range rooms() {
+		live[roomID] = true
+
+		cutoff := report.StartedAt.Add(-c.retention)
+		purged, reclaimed, err := c.history.PurgeTombstones(roomID, cutoff)
+		if err != nil {
+			failed("purge tombstones in room %s: %v", roomID, err)
+			continue
+		}
+		report.TombstonesPurged += purged
+		report.ReclaimedBytes += reclaimed
+
+		if c.Policy == nil {
+			continue
+		}
+		days, messages := c.Policy(roomID)
+		if days == 0 && messages == 0 {
+			continue
+		}
+		var before time.Time
+		if days > 0 {
+			before = report.StartedAt.AddDate(0, 0, -days)
+		}
+		pruned, reclaimed, err := c.history.Prune(roomID, before, messages)
+		if err != nil {
+			failed("prune history of room %s: %v", roomID, err)
+			continue
+		}
+		if pruned > 0 {
+			if report.PrunedByRoom == nil {
+				report.PrunedByRoom = make(map[string]int)
+			}
+			report.PrunedByRoom[roomID] = pruned
+			report.MessagesPruned += pruned
+			report.ReclaimedBytes += reclaimed
+			metrics.PrunedBytes.Add(reclaimed)
+		}
+	}
+	metrics.MessagesPruned.Add(int64(report.MessagesPruned))
+	metrics.LastPruned.Set(int64(report.MessagesPruned))
+
+	for _, roomID := range historyRooms {
+		if live[roomID] {
+			continue
+		}
+		size, err := c.store.DeleteEvents(roomID)
+		if err != nil {
+			failed("delete history of room %s: %v", roomID, err)
+			continue
+		}
+		c.history.Forget(roomID)
+		report.OrphanedHistories++
+		report.ReclaimedBytes += size
+	}
+
+	for _, roomID := range markerRooms {
+		if live[roomID] {
+			continue
+		}
+		if err := c.store.DeleteReadMarkers(roomID); err != nil {
+			failed("delete read markers of room %s: %v", roomID, err)
+			continue
+		}
+		report.OrphanedReadMarkers++
+	}
+
+	report.FinishedAt = time.Now()
+
+	c.mutex.Lock()
+	c.running = false
+	c.last = report
+	c.mutex.Unlock()
+
+	log.Printf("Maintenance purged %d tombstones, %d messages past retention, %d orphaned histories and %d orphaned read markers, reclaiming %d bytes",
+		report.TombstonesPurged, report.MessagesPruned, report.OrphanedHistories, report.OrphanedReadMarkers, report.ReclaimedBytes)
+	return report, nil
+}
+
+// LastReport returns the report of the most recent cleanup, or nil if none has run
+func (c *Cleaner) LastReport() *Report {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.last
+}
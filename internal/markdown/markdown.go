@@ -0,0 +1,383 @@
+// Package markdown renders the markdown subset chat messages may use to
+// sanitized HTML, so every client shows a message the same way and none has
+// to trust raw content. Everything outside the subset is escaped as text.
+//
+// The subset is **bold** (or __bold__), *italic* (or _italic_),
+// ~~strikethrough~~, `inline code`, fenced code blocks with an optional
+// language, > block quotes, [links](https://example.com), bare http and
+// https URLs and @mentions. Line breaks are kept. Links only go to http,
+// https and mailto URLs, and a backslash escapes a markdown character.
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"realtime-chat/internal/store"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// MaxLength is the longest content rendered, in bytes. Longer content is
+// escaped as plain text, which bounds the work a message can cause.
+const MaxLength = 16 << 10
+
+// linkSchemes are the URL schemes links may use
+var linkSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+// escapable are the characters a backslash escapes
+const escapable = "\\`*_~[]()>@#!"
+
+// Render returns content rendered to HTML and the entities found in it
+func Render(content string) (string, []store.Entity) {
+	if len(content) > MaxLength {
+		return strings.ReplaceAll(html.EscapeString(content), "\n", "<br>"), nil
+	}
+	r := &renderer{src: content}
+	r.blocks()
+	return r.out.String(), r.entities
+}
+
+// renderer renders one message
+type renderer struct {
+	src      string
+	out      strings.Builder
+	entities []store.Entity
+}
+
+// blocks renders the content line by line, gathering code blocks and quotes
+func (r *renderer) blocks() {
+	// Whether a line was written that the next one must be broken from
+	lineOpen := false
+
+	for pos := 0; pos < len(r.src); {
+		line, next := r.line(pos)
+		switch {
+		case strings.HasPrefix(line, "```"):
+			next = r.codeBlock(pos)
+			lineOpen = false
+
+		case strings.HasPrefix(line, ">"):
+			next = r.quote(pos)
+			lineOpen = false
+
+		default:
+			if lineOpen {
+				r.out.WriteString("<br>")
+			}
+			r.inline(line, pos)
+			lineOpen = true
+		}
+		pos = next
+	}
+}
+
+// line returns the line starting at pos, without its newline, and where
+// the next line starts
+func (r *renderer) line(pos int) (string, int) {
+	end := strings.IndexByte(r.src[pos:], '\n')
+	if end < 0 {
+		return r.src[pos:], len(r.src)
+	}
+	return r.src[pos : pos+end], pos + end + 1
+}
+
+// codeBlock renders the fenced code block starting at pos and returns where
+// the line after it starts. A block missing its closing fence runs to the
+// end of the content.
+func (r *renderer) codeBlock(pos int) int {
+	opening, next := r.line(pos)
+	language := strings.TrimSpace(opening[3:])
+	if !validLanguage(language) {
+		language = ""
+	}
+
+	start, end := next, len(r.src)
+	for next < len(r.src) {
+		line, after := r.line(next)
+		if strings.TrimSpace(line) == "```" {
+			end = next
+			next = after
+			break
+		}
+		next = after
+	}
+	if end == len(r.src) {
+		next = end
+	}
+	code := strings.TrimSuffix(r.src[min(start, end):end], "\n")
+
+	r.out.WriteString("<pre><code")
+	if language != "" {
+		r.out.WriteString(` class="language-` + language + `"`)
+	}
+	r.out.WriteString(">" + html.EscapeString(code) + "</code></pre>")
+	r.entity(store.Entity{Type: store.EntityCodeBlock, Language: language}, pos, strings.TrimSuffix(r.src[pos:next], "\n"))
+	return next
+}
+
+// validLanguage reports whether a code block's language is a plain name
+// that is safe in a class attribute
+func validLanguage(language string) bool {
+	if len(language) > 20 {
+		return false
+	}
+	for _, c := range language {
+		if !isWordChar(c) && !strings.ContainsRune("+#-.", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// quote renders the block quote of the lines starting with > from pos and
+// returns where the line after it starts
+func (r *renderer) quote(pos int) int {
+	r.out.WriteString("<blockquote>")
+	first := true
+	for pos < len(r.src) {
+		line, next := r.line(pos)
+		if !strings.HasPrefix(line, ">") {
+			break
+		}
+		if !first {
+			r.out.WriteString("<br>")
+		}
+		first = false
+
+		skip := 1
+		if strings.HasPrefix(line, "> ") {
+			skip = 2
+		}
+		r.inline(line[skip:], pos+skip)
+		pos = next
+	}
+	r.out.WriteString("</blockquote>")
+	return pos
+}
+
+// inline renders text found at offset base of the content
+func (r *renderer) inline(text string, base int) {
+	for i := 0; i < len(text); {
+		c := text[i]
+		next := -1
+		switch c {
+		case '\\':
+			if i+1 < len(text) && strings.IndexByte(escapable, text[i+1]) >= 0 {
+				r.out.WriteString(html.EscapeString(text[i+1 : i+2]))
+				next = i + 2
+			}
+		case '`':
+			next = r.code(text, i, base)
+		case '[':
+			next = r.link(text, i, base)
+		case 'h':
+			next = r.autolink(text, i, base)
+		case '@':
+			next = r.mention(text, i, base)
+		case '*', '_', '~':
+			next = r.emphasis(text, i, base)
+		}
+		if next < 0 {
+			r.out.WriteString(html.EscapeString(text[i : i+1]))
+			next = i + 1
+		}
+		i = next
+	}
+}
+
+// code renders the inline code starting at i, returning where it ends or
+// -1 when the backtick isn't closed
+func (r *renderer) code(text string, i, base int) int {
+	end := strings.IndexByte(text[i+1:], '`')
+	if end <= 0 {
+		return -1
+	}
+	end += i + 1
+	r.out.WriteString("<code>" + html.EscapeString(text[i+1:end]) + "</code>")
+	r.entity(store.Entity{Type: store.EntityCode}, base+i, text[i:end+1])
+	return end + 1
+}
+
+// link renders the [text](url) link starting at i, returning where it ends
+// or -1 when there is no link or its URL isn't allowed
+func (r *renderer) link(text string, i, base int) int {
+	mid := strings.Index(text[i:], "](")
+	if mid <= 1 {
+		return -1
+	}
+	mid += i
+	end := strings.IndexByte(text[mid+2:], ')')
+	if end < 0 {
+		return -1
+	}
+	end += mid + 2
+	label, target := text[i+1:mid], strings.TrimSpace(text[mid+2:end])
+	if strings.ContainsAny(label, "[]") || !allowedURL(target) {
+		return -1
+	}
+
+	r.entity(store.Entity{Type: store.EntityLink, URL: target}, base+i, text[i:end+1])
+	r.anchor(target)
+	r.inline(label, base+i+1)
+	r.out.WriteString("</a>")
+	return end + 1
+}
+
+// autolink renders the bare http or https URL starting at i, returning where
+// it ends or -1 when there is none
+func (r *renderer) autolink(text string, i, base int) int {
+	rest := text[i:]
+	if !strings.HasPrefix(rest, "http://") && !strings.HasPrefix(rest, "https://") {
+		return -1
+	}
+	if i > 0 && isWordByte(text[i-1]) {
+		return -1
+	}
+
+	end := strings.IndexAny(rest, " \t\r\n<>\"")
+	if end < 0 {
+		end = len(rest)
+	}
+	// Leave out the punctuation ending a sentence, and a closing parenthesis
+	// the URL didn't open
+	target := strings.TrimRight(rest[:end], ".,:;!?'*_~")
+	if strings.HasSuffix(target, ")") && !strings.Contains(target, "(") {
+		target = strings.TrimRight(target, ")")
+	}
+	if !allowedURL(target) {
+		return -1
+	}
+
+	r.entity(store.Entity{Type: store.EntityLink, URL: target}, base+i, target)
+	r.anchor(target)
+	r.out.WriteString(html.EscapeString(target) + "</a>")
+	return i + len(target)
+}
+
+// anchor writes the opening tag of a link to target
+func (r *renderer) anchor(target string) {
+	r.out.WriteString(`<a href="` + html.EscapeString(target) + `" rel="noopener noreferrer nofollow" target="_blank">`)
+}
+
+// allowedURL reports whether a link may go to target
+func allowedURL(target string) bool {
+	if target == "" || strings.ContainsAny(target, " \t\r\n") {
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil || !linkSchemes[strings.ToLower(u.Scheme)] {
+		return false
+	}
+	return u.Scheme == "mailto" || u.Host != ""
+}
+
+// mention renders the @mention starting at i, returning where it ends or
+// -1 when there is none. Mentions follow the same rule as notifications:
+// an @ that doesn't follow a word character or another @.
+func (r *renderer) mention(text string, i, base int) int {
+	if i > 0 && (isWordByte(text[i-1]) || text[i-1] == '@') {
+		return -1
+	}
+	end := i + 1
+	for end < len(text) && (isWordByte(text[end]) || text[end] == '.' || text[end] == '-') {
+		end++
+	}
+	if end == i+1 {
+		return -1
+	}
+
+	username := text[i+1 : end]
+	r.entity(store.Entity{Type: store.EntityMention, Username: username}, base+i, text[i:end])
+	escaped := html.EscapeString(username)
+	r.out.WriteString(`<span class="mention" data-username="` + escaped + `">@` + escaped + "</span>")
+	return end
+}
+
+// emphasis renders the bold, italic or strikethrough text starting at i,
+// returning where it ends or -1 when the delimiter isn't closed
+func (r *renderer) emphasis(text string, i, base int) int {
+	delim := text[i : i+1]
+	if i+1 < len(text) && text[i+1] == text[i] {
+		delim = text[i : i+2]
+	}
+	if delim == "~" {
+		return -1
+	}
+
+	open := i + len(delim)
+	if open >= len(text) || text[open] == ' ' {
+		return -1
+	}
+	// Underscores inside words, as in snake_case, are text
+	intraword := delim[0] == '_'
+	if intraword && i > 0 && isWordByte(text[i-1]) {
+		return -1
+	}
+
+	close := -1
+	for from := open + 1; from <= len(text)-len(delim); {
+		at := strings.Index(text[from:], delim)
+		if at < 0 {
+			break
+		}
+		at += from
+		after := at + len(delim)
+		if text[at-1] != ' ' && !(intraword && after < len(text) && isWordByte(text[after])) &&
+			!(len(delim) == 1 && (text[at-1] == delim[0] || after < len(text) && text[after] == delim[0])) {
+			close = at
+			break
+		}
+		from = at + 1
+	}
+	if close < 0 {
+		return -1
+	}
+
+	tag := "em"
+	switch delim {
+	case "**", "__":
+		tag = "strong"
+	case "~~":
+		tag = "del"
+	}
+	r.out.WriteString("<" + tag + ">")
+	r.inline(text[open:close], base+open)
+	r.out.WriteString("</" + tag + ">")
+	return close + len(delim)
+}
+
+// entity records an entity spanning text at byte offset start of the content
+func (r *renderer) entity(e store.Entity, start int, text string) {
+	e.Offset = units(r.src[:start])
+	e.Length = units(text)
+	r.entities = append(r.entities, e)
+}
+
+// units returns the length of s in UTF-16 code units, as JavaScript and most
+// clients index strings
+func units(s string) int {
+	n := 0
+	for _, c := range s {
+		if c == utf8.RuneError {
+			n++
+			continue
+		}
+		n += utf16.RuneLen(c)
+	}
+	return n
+}
+
+// isWordByte reports whether c is a word character as \w matches it
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// isWordChar reports whether c is a word character as \w matches it
+func isWordChar(c rune) bool {
+	return c < utf8.RuneSelf && isWordByte(byte(c))
+}
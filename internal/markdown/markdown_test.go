@@ -0,0 +1,91 @@
+package markdown
+
+import (
+	"realtime-chat/internal/store"
+	"strings"
+	"testing"
+)
+
+// link is the opening tag of a rendered link to target
+func link(target string) string {
+	return `<a href="` + target + `" rel="noopener noreferrer nofollow" target="_blank">`
+}
+
+func TestRender(t *testing.T) {
+	for _, tt := range []struct {
+		content, html string
+	}{
+		{"**bold** __bold__", "<strong>bold</strong> <strong>bold</strong>"},
+		{"*italic* _italic_ ~~gone~~", "<em>italic</em> <em>italic</em> <del>gone</del>"},
+		{"`a<b>`", "<code>a&lt;b&gt;</code>"},
+		{"```go\nx < y\n```", `<pre><code class="language-go">x &lt; y</code></pre>`},
+		{"> quoted\nreply", "<blockquote>quoted</blockquote>reply"},
+		{"one\ntwo", "one<br>two"},
+		{`\*not italic\*`, "*not italic*"},
+		{"**unclosed", "**unclosed"},
+		{"[docs](https://example.com/a?b=1&c=2)", link("https://example.com/a?b=1&amp;c=2") + "docs</a>"},
+		{"**[docs](http://example.com)**", "<strong>" + link("http://example.com") + "docs</a></strong>"},
+		{"[mail](mailto:a@example.com)", link("mailto:a@example.com") + "mail</a>"},
+		{"see https://example.com.", "see " + link("https://example.com") + "https://example.com</a>."},
+		{"@alice hi", `<span class="mention" data-username="alice">@alice</span> hi`},
+		{"a@example.com", "a@example.com"},
+	} {
+		if html, _ := Render(tt.content); html != tt.html {
+			t.Errorf("Render(%q)\n got %s\nwant %s", tt.content, html, tt.html)
+		}
+	}
+}
+
+func TestRenderSanitizes(t *testing.T) {
+	for _, tt := range []struct {
+		content, html string
+	}{
+		{"<script>alert(1)</script>", "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"<img src=x onerror=alert(1)>", "&lt;img src=x onerror=alert(1)&gt;"},
+		{"[<b>x</b>](https://example.com)", link("https://example.com") + "&lt;b&gt;x&lt;/b&gt;</a>"},
+
+		// Links only go to http, https and mailto URLs with a host
+		{"[x](javascript:alert(1))", "[x](javascript:alert(1))"},
+		{"[x](JaVaScRiPt:alert(1))", "[x](JaVaScRiPt:alert(1))"},
+		{"[x](data:text/html,<script>)", "[x](data:text/html,&lt;script&gt;)"},
+		{"[x](//example.com)", "[x](//example.com)"},
+
+		// Quotes can't break out of attributes
+		{`[x](https://example.com/"onmouseover=alert(1))`, link("https://example.com/&#34;onmouseover=alert(1") + "x</a>)"},
+		{"@a\"b", `<span class="mention" data-username="a">@a</span>&#34;b`},
+		{"```\"><img>\nx\n```", "<pre><code>x</code></pre>"},
+	} {
+		if html, _ := Render(tt.content); html != tt.html {
+			t.Errorf("Render(%q)\n got %s\nwant %s", tt.content, html, tt.html)
+		}
+	}
+}
+
+func TestRenderEntities(t *testing.T) {
+	// Offsets and lengths count UTF-16 code units, as JavaScript strings do
+	_, entities := Render("é @bob `x` [docs](https://example.com)")
+	want := []store.Entity{
+		{Type: store.EntityMention, Offset: 2, Length: 4, Username: "bob"},
+		{Type: store.EntityCode, Offset: 7, Length: 3},
+		{Type: store.EntityLink, Offset: 11, Length: 27, URL: "https://example.com"},
+	}
+	if len(entities) != len(want) {
+		t.Fatalf("got entities %+v, want %+v", entities, want)
+	}
+	for i := range want {
+		if entities[i] != want[i] {
+			t.Errorf("entity %d is %+v, want %+v", i, entities[i], want[i])
+		}
+	}
+}
+
+func TestRenderTooLong(t *testing.T) {
+	content := strings.Repeat("**<b>**\n", MaxLength/8+1)
+	html, entities := Render(content)
+	if strings.Contains(html, "<strong>") || strings.Contains(html, "<b>") || entities != nil {
+		t.Error("content over MaxLength was rendered")
+	}
+	if !strings.HasPrefix(html, "**&lt;b&gt;**<br>") {
+		t.Errorf("content over MaxLength wasn't escaped: %.40s", html)
+	}
+}
@@ -0,0 +1,93 @@
+// Package media processes uploaded images before they are stored: it
+// identifies them by their content rather than their name or declared type,
+// strips the metadata that can give away where and on what they were taken,
+// and scales them down to thumbnails.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"net/http"
+
+	// Decoders for the formats that can be scaled
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Content types of the accepted image formats
+const (
+	PNG  = "image/png"
+	GIF  = "image/gif"
+	JPEG = "image/jpeg"
+	WebP = "image/webp"
+)
+
+// MaxPixels bounds the decoded size of images, so a small file can't expand
+// to a huge image in memory
+const MaxPixels = 40_000_000
+
+// Errors returned when an upload can't be processed
+var (
+	ErrNotImage = errors.New("not a PNG, GIF, JPEG or WebP image")
+	ErrTooLarge = errors.New("image is larger than 40 megapixels")
+)
+
+// imageTypes lists the accepted image content types
+var imageTypes = map[string]bool{
+	PNG:  true,
+	GIF:  true,
+	JPEG: true,
+	WebP: true,
+}
+
+// Sniff returns the content type of an image from its leading bytes, so a
+// file named or declared as an image that isn't one is rejected
+func Sniff(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if !imageTypes[contentType] {
+		return "", ErrNotImage
+	}
+	return contentType, nil
+}
+
+// Clean sniffs an image and returns it with its metadata stripped, along
+// with its content type. The image data itself is copied unchanged.
+func Clean(data []byte) ([]byte, string, error) {
+	contentType, err := Sniff(data)
+	if err != nil {
+		return nil, "", err
+	}
+	clean, err := Strip(data, contentType)
+	if err != nil {
+		return nil, "", err
+	}
+	return clean, contentType, nil
+}
+
+// Decode sniffs and decodes a PNG, GIF or JPEG image, checking its size
+// before decoding it
+func Decode(data []byte) (image.Image, error) {
+	contentType, err := Sniff(data)
+	if err != nil {
+		return nil, err
+	}
+	if contentType == WebP {
+		// The standard library has no WebP decoder
+		return nil, ErrNotImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrNotImage
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrNotImage
+	}
+	return src, nil
+}
@@ -0,0 +1,247 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Strip returns a copy of an image without the metadata it carries besides
+// its pixels: EXIF (which holds GPS coordinates, camera and timestamps),
+// XMP, IPTC, comments and text chunks. Colour profiles are kept so the image
+// looks the same. The content type must be one Sniff accepts.
+func Strip(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case JPEG:
+		return stripJPEG(data)
+	case PNG:
+		return stripPNG(data)
+	case GIF:
+		return stripGIF(data)
+	case WebP:
+		return stripWebP(data)
+	}
+	return nil, ErrNotImage
+}
+
+// JPEG markers of segments dropped by stripJPEG
+const (
+	jpegAPP1  = 0xE1 // EXIF and XMP
+	jpegAPP13 = 0xED // IPTC and Photoshop resources
+	jpegCOM   = 0xFE // Comments
+	jpegSOS   = 0xDA // Start of scan, followed by the compressed image data
+)
+
+// stripJPEG drops the metadata segments before the image data of a JPEG
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrNotImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, ErrNotImage
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte before a marker
+			i++
+			continue
+		}
+		if marker == jpegSOS {
+			// Metadata only comes before the image data, which is copied as is
+			return append(out, data[i:]...), nil
+		}
+
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			return nil, ErrNotImage
+		}
+		if marker != jpegAPP1 && marker != jpegAPP13 && marker != jpegCOM {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+}
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadata lists the PNG chunk types dropped by stripPNG
+var pngMetadata = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNG drops the EXIF, text and timestamp chunks of a PNG
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrNotImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	for i := len(pngSignature); i < len(data); {
+		// Length, type, data and CRC
+		if i+12 > len(data) {
+			return nil, ErrNotImage
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, ErrNotImage
+		}
+		chunkType := string(data[i+4 : i+8])
+		if !pngMetadata[chunkType] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out, nil
+}
+
+// GIF block introducers and the extensions kept by stripGIF
+const (
+	gifExtension   = 0x21
+	gifImage       = 0x2C
+	gifTrailer     = 0x3B
+	gifComment     = 0xFE
+	gifApplication = 0xFF
+)
+
+// gifApplications lists the application extensions kept by stripGIF, which
+// hold an animation's loop count rather than metadata
+var gifApplications = map[string]bool{
+	"NETSCAPE2.0": true,
+	"ANIMEXTS1.0": true,
+}
+
+// stripGIF drops the comments and the application extensions other than
+// animation loop counts, such as XMP, from a GIF
+func stripGIF(data []byte) ([]byte, error) {
+	// Header and logical screen descriptor
+	if len(data) < 13 {
+		return nil, ErrNotImage
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, ErrNotImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:i]...)
+
+	for i < len(data) {
+		start := i
+		keep := true
+		switch data[i] {
+		case gifTrailer:
+			return append(out, data[i]), nil
+
+		case gifExtension:
+			if i+2 > len(data) {
+				return nil, ErrNotImage
+			}
+			label := data[i+1]
+			i += 2
+			switch label {
+			case gifComment:
+				keep = false
+			case gifApplication:
+				keep = i+12 <= len(data) && data[i] == 11 && gifApplications[string(data[i+1:i+12])]
+			}
+
+		case gifImage:
+			// Image descriptor, local colour table and LZW code size
+			if i+10 > len(data) {
+				return nil, ErrNotImage
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i++
+
+		default:
+			return nil, ErrNotImage
+		}
+
+		// Sub-blocks, ended by an empty one
+		for {
+			if i >= len(data) {
+				return nil, ErrNotImage
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+		if i > len(data) {
+			return nil, ErrNotImage
+		}
+		if keep {
+			out = append(out, data[start:i]...)
+		}
+	}
+	// Tolerate a missing trailer, as decoders do
+	return append(out, gifTrailer), nil
+}
+
+// VP8X flags of the metadata chunks dropped by stripWebP
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+// stripWebP drops the EXIF and XMP chunks of a WebP and clears the flags
+// announcing them
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrNotImage
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, ErrNotImage
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		// Chunks are padded to an even size
+		end := i + 8 + size + size&1
+		if size < 0 || end > len(data) {
+			if i+8+size != len(data) {
+				return nil, ErrNotImage
+			}
+			end = len(data)
+		}
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= webpFlagXMP | webpFlagEXIF
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
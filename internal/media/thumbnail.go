@@ -0,0 +1,72 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// Thumbnails crops an image to a centred square and returns it scaled down
+// to each of sizes, encoded as PNG and keyed by size. Images smaller than a
+// size are left at their own size rather than enlarged. Re-encoding leaves
+// behind any metadata the original carried.
+func Thumbnails(src image.Image, sizes []int) (map[int][]byte, error) {
+	// Crop the longer side so the thumbnails aren't distorted
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+	crop := image.Rect(x0, y0, x0+side, y0+side)
+
+	thumbnails := make(map[int][]byte, len(sizes))
+	for _, size := range sizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, scale(src, crop, min(side, size))); err != nil {
+			return nil, err
+		}
+		thumbnails[size] = buf.Bytes()
+	}
+	return thumbnails, nil
+}
+
+// scale resamples the square crop of src to size×size by averaging the
+// source pixels that fall into each destination pixel
+func scale(src image.Image, crop image.Rectangle, size int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	side := crop.Dx()
+
+	for y := 0; y < size; y++ {
+		sy0 := crop.Min.Y + y*side/size
+		sy1 := max(crop.Min.Y+(y+1)*side/size, sy0+1)
+		for x := 0; x < size; x++ {
+			sx0 := crop.Min.X + x*side/size
+			sx1 := max(crop.Min.X+(x+1)*side/size, sx0+1)
+
+			// Sum premultiplied colours so transparent pixels don't darken edges
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			pixel := color.NRGBA64{}
+			if a > 0 {
+				pixel = color.NRGBA64{
+					R: uint16(r * 0xffff / a),
+					G: uint16(g * 0xffff / a),
+					B: uint16(b * 0xffff / a),
+					A: uint16(a / n),
+				}
+			}
+			dst.Set(x, y, pixel)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stages a frame passes through, used to label frame metrics
+const (
+	// StageReceive is reading an incoming frame off the connection
+	StageReceive = "receive"
+
+	// StageProcess is handling an incoming frame, up to handing its results to rooms or the hub
+	StageProcess = "process"
+
+	// StageDeliver is writing an outgoing frame to the connection
+	StageDeliver = "deliver"
+)
+
+// maxFrameTypes is the number of frame types tracked per stage; further
+// types are counted together as "other"
+const maxFrameTypes = 64
+
+// latencyBuckets are the upper bounds of the latency histogram buckets
+var latencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// histogram counts observations per latency bucket; the last count is for
+// observations above every bucket
+type histogram struct {
+	counts []atomic.Int64
+	bytes  atomic.Int64
+	sum    atomic.Int64 // Nanoseconds
+}
+
+// observe records one frame of the given size that took d
+func (h *histogram) observe(d time.Duration, size int) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	h.counts[i].Add(1)
+	h.bytes.Add(int64(size))
+	h.sum.Add(int64(d))
+}
+
+// Bucket is the number of observations at or below a latency
+type Bucket struct {
+	LeMs  float64 `json:"leMs"` // Upper bound in milliseconds; 0 for the overflow bucket
+	Count int64   `json:"count"`
+}
+
+// FrameStats summarizes the frames of one type at one stage
+type FrameStats struct {
+	Count   int64    `json:"count"`
+	Bytes   int64    `json:"bytes"`
+	MeanMs  float64  `json:"meanMs"`
+	P50Ms   float64  `json:"p50Ms"`
+	P95Ms   float64  `json:"p95Ms"`
+	P99Ms   float64  `json:"p99Ms"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// snapshot returns the histogram's current statistics
+func (h *histogram) snapshot() *FrameStats {
+	stats := &FrameStats{Bytes: h.bytes.Load(), Buckets: make([]Bucket, len(h.counts))}
+
+	counts := make([]int64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+		stats.Count += counts[i]
+		stats.Buckets[i].Count = counts[i]
+		if i < len(latencyBuckets) {
+			stats.Buckets[i].LeMs = milliseconds(latencyBuckets[i])
+		}
+	}
+	if stats.Count == 0 {
+		return stats
+	}
+
+	stats.MeanMs = milliseconds(time.Duration(h.sum.Load() / stats.Count))
+	stats.P50Ms = quantile(counts, stats.Count, 0.50)
+	stats.P95Ms = quantile(counts, stats.Count, 0.95)
+	stats.P99Ms = quantile(counts, stats.Count, 0.99)
+	return stats
+}
+
+// quantile estimates a quantile as the upper bound of the bucket that contains it.
+// Observations above the last bucket report the last bound.
+func quantile(counts []int64, total int64, q float64) float64 {
+	rank := int64(q * float64(total))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i, n := range counts {
+		seen += n
+		if seen >= rank && i < len(latencyBuckets) {
+			return milliseconds(latencyBuckets[i])
+		}
+	}
+	return milliseconds(latencyBuckets[len(latencyBuckets)-1])
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// frames holds a histogram per stage and frame type
+var frames = struct {
+	mutex sync.RWMutex
+	stats map[string]map[string]*histogram
+}{stats: make(map[string]map[string]*histogram)}
+
+// ObserveFrame records a frame of the given type and size that spent d in a stage
+func ObserveFrame(stage, frameType string, size int, d time.Duration) {
+	frames.mutex.RLock()
+	h := frames.stats[stage][frameType]
+	frames.mutex.RUnlock()
+
+	if h == nil {
+		frames.mutex.Lock()
+		if frames.stats[stage] == nil {
+			frames.stats[stage] = make(map[string]*histogram)
+		}
+		// Clients can relay frames of any type, so cap the number of labels
+		if _, exists := frames.stats[stage][frameType]; !exists && len(frames.stats[stage]) >= maxFrameTypes {
+			frameType = "other"
+		}
+		if h = frames.stats[stage][frameType]; h == nil {
+			h = &histogram{counts: make([]atomic.Int64, len(latencyBuckets)+1)}
+			frames.stats[stage][frameType] = h
+		}
+		frames.mutex.Unlock()
+	}
+	h.observe(d, size)
+}
+
+// Frames returns the statistics of every frame type, keyed by stage then frame type
+func Frames() map[string]map[string]*FrameStats {
+	frames.mutex.RLock()
+	defer frames.mutex.RUnlock()
+
+	snapshot := make(map[string]map[string]*FrameStats, len(frames.stats))
+	for stage, byType := range frames.stats {
+		snapshot[stage] = make(map[string]*FrameStats, len(byType))
+		for frameType, h := range byType {
+			snapshot[stage][frameType] = h.snapshot()
+		}
+	}
+	return snapshot
+}
+
+// FrameType returns the value of a frame's top-level "type" field, stopping
+// as soon as it is found. Frames without one are reported as "unknown".
+func FrameType(frame []byte) string {
+	dec := json.NewDecoder(bytes.NewReader(frame))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return "unknown"
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return "unknown"
+		}
+		if key == "type" {
+			if value, err := dec.Token(); err == nil {
+				if frameType, ok := value.(string); ok {
+					return frameType
+				}
+			}
+			return "unknown"
+		}
+
+		// Skip the value, including any nested objects
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return "unknown"
+		}
+	}
+	return "unknown"
+}
+
+func init() {
+	expvar.Publish("frames", expvar.Func(func() interface{} { return Frames() }))
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import "expvar"
+
+// Server metrics, published as JSON at /debug/vars
+var (
+	// Overloaded is 1 while the server is shedding load and 0 otherwise
+	Overloaded = expvar.NewInt("overloaded")
+
+	// OverloadEvents counts how many times the server entered the overloaded state
+	OverloadEvents = expvar.NewInt("overload_events")
+
+	// ConnectionsShed counts connections rejected because of overload
+	ConnectionsShed = expvar.NewInt("connections_shed")
+
+	// Connections is the number of connections holding a slot under the connection limit
+	Connections = expvar.NewInt("connections")
+
+	// AtConnectionLimit is 1 while the connection limit is reached and 0 otherwise
+	AtConnectionLimit = expvar.NewInt("at_connection_limit")
+
+	// ConnectionsLimited counts connections rejected because of the connection limit
+	ConnectionsLimited = expvar.NewInt("connections_limited")
+
+	// FramesShed counts non-essential frames skipped because of overload
+	FramesShed = expvar.NewInt("frames_shed")
+
+	// QueueDepth is the aggregate number of queued outgoing messages at the last check
+	QueueDepth = expvar.NewInt("queue_depth")
+
+	// Goroutines is the number of running goroutines at the last check
+	Goroutines = expvar.NewInt("goroutines")
+
+	// MessagesPruned counts messages removed by retention policies
+	MessagesPruned = expvar.NewInt("messages_pruned")
+
+	// PrunedBytes is the approximate history storage freed by retention policies
+	PrunedBytes = expvar.NewInt("pruned_bytes")
+
+	// LastPruned is the number of messages removed by the most recent cleanup
+	LastPruned = expvar.NewInt("last_pruned")
+
+	// PersistQueued is the number of history events waiting to be written by write-behind persistence
+	PersistQueued = expvar.NewInt("persist_queued")
+
+	// PersistBatches counts batches of history events written by write-behind persistence
+	PersistBatches = expvar.NewInt("persist_batches")
+
+	// PersistFailures counts history events in batches that failed to be written
+	PersistFailures = expvar.NewInt("persist_failures")
+
+	// EventsExported counts events written to Kafka
+	EventsExported = expvar.NewInt("events_exported")
+
+	// EventsDropped counts events not exported because the export queue was full
+	EventsDropped = expvar.NewInt("events_dropped")
+
+	// ExportFailures counts events in batches that Kafka rejected
+	ExportFailures = expvar.NewInt("export_failures")
+
+	// Indexed counts message changes written to the search index, and
+	// IndexDropped and IndexFailures those dropped because the index queue
+	// was full or that the index rejected
+	Indexed       = expvar.NewInt("search_indexed")
+	IndexDropped  = expvar.NewInt("search_index_dropped")
+	IndexFailures = expvar.NewInt("search_index_failures")
+
+	// MessagesCompacted counts messages moved to cold storage, and
+	// ColdReads the segments read back from it
+	MessagesCompacted = expvar.NewInt("messages_compacted")
+	ColdReads         = expvar.NewInt("cold_storage_reads")
+
+	// MQTTDropped counts room messages not published to MQTT because the publish queue was full
+	MQTTDropped = expvar.NewInt("mqtt_dropped")
+
+	// FederationSent and FederationReceived count events exchanged with
+	// federated servers, and FederationDropped those not sent because a
+	// server's queue was full
+	FederationSent     = expvar.NewInt("federation_sent")
+	FederationReceived = expvar.NewInt("federation_received")
+	FederationDropped  = expvar.NewInt("federation_dropped")
+
+	// LiveViewers is the number of viewers streaming rooms' live views
+	LiveViewers = expvar.NewInt("live_viewers")
+)
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"expvar"
+	"sync/atomic"
+)
+
+// queueTotals are the server-wide figures of one kind of client queue
+type queueTotals struct {
+	highWater atomic.Int64
+	dropped   atomic.Int64
+}
+
+var (
+	sendTotals     queueTotals
+	priorityTotals queueTotals
+)
+
+// QueueStats describes how full a queue has been and how many frames it dropped
+type QueueStats struct {
+	HighWater int64 `json:"highWater"`
+	Dropped   int64 `json:"dropped"`
+}
+
+// stats returns the totals' current figures
+func (t *queueTotals) stats() QueueStats {
+	return QueueStats{HighWater: t.highWater.Load(), Dropped: t.dropped.Load()}
+}
+
+// Queue records the high-water mark and drops of one client's send or
+// priority queue. A nil Queue records nothing, so clients that aren't
+// connected, such as those of a replay, need none.
+type Queue struct {
+	highWater atomic.Int64
+	dropped   atomic.Int64
+	totals    *queueTotals
+}
+
+// NewSendQueue returns the record of a client's send queue
+func NewSendQueue() *Queue {
+	return &Queue{totals: &sendTotals}
+}
+
+// NewPriorityQueue returns the record of a client's priority queue
+func NewPriorityQueue() *Queue {
+	return &Queue{totals: &priorityTotals}
+}
+
+// Offer queues a frame on ch without blocking and records the queue's
+// length, or counts the frame as dropped and reports false when ch is full
+func (q *Queue) Offer(ch chan []byte, frame []byte) bool {
+	select {
+	case ch <- frame:
+		if q != nil {
+			raise(&q.highWater, int64(len(ch)))
+			raise(&q.totals.highWater, int64(len(ch)))
+		}
+		return true
+	default:
+		if q != nil {
+			q.dropped.Add(1)
+			q.totals.dropped.Add(1)
+		}
+		return false
+	}
+}
+
+// HighWater returns the most frames the queue has held
+func (q *Queue) HighWater() int64 {
+	if q == nil {
+		return 0
+	}
+	return q.highWater.Load()
+}
+
+// Dropped returns the number of frames dropped because the queue was full
+func (q *Queue) Dropped() int64 {
+	if q == nil {
+		return 0
+	}
+	return q.dropped.Load()
+}
+
+// Stats returns the queue's current figures
+func (q *Queue) Stats() QueueStats {
+	return QueueStats{HighWater: q.HighWater(), Dropped: q.Dropped()}
+}
+
+// raise sets v to n if n is larger
+func raise(v *atomic.Int64, n int64) {
+	for {
+		old := v.Load()
+		if n <= old || v.CompareAndSwap(old, n) {
+			return
+		}
+	}
+}
+
+// Queues returns the server-wide high-water marks and drops of the
+// clients' send and priority queues
+func Queues() map[string]QueueStats {
+	return map[string]QueueStats{
+		"send":     sendTotals.stats(),
+		"priority": priorityTotals.stats(),
+	}
+}
+
+func init() {
+	expvar.Publish("send_queue", expvar.Func(func() interface{} { return sendTotals.stats() }))
+	expvar.Publish("priority_queue", expvar.Func(func() interface{} { return priorityTotals.stats() }))
+}
@@ -0,0 +1,173 @@
+// Package mqtt bridges chat rooms to MQTT through an embedded broker, so
+// constrained IoT devices can post status messages into rooms and receive
+// commands. Each MQTT connection is a chat client named after its MQTT
+// username, and the bridge serves these topics:
+//
+//	chat/rooms/{roomId}/status         Text a device publishes is posted to the room
+//	chat/rooms/{roomId}/messages       Room messages, edits and deletions as JSON
+//	chat/devices/{username}/commands   Direct messages sent to the device's username
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/store"
+	"strings"
+	"sync"
+
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// Topic levels of the bridge
+const (
+	topicRoot     = "chat"
+	topicRooms    = "rooms"
+	topicDevices  = "devices"
+	topicStatus   = "status"
+	topicMessages = "messages"
+	topicCommands = "commands"
+)
+
+// queueSize is the most room messages waiting to be published
+const queueSize = 1024
+
+// Bridge is the embedded broker and the chat sessions of its connections
+type Bridge struct {
+	hub    *hub.Hub
+	broker *broker.Server
+
+	// Room messages waiting to be published; done is closed when the bridge closes
+	queue chan *store.MessageEvent
+	done  chan struct{}
+
+	// Guards sessions and commanders
+	mutex    sync.RWMutex
+	sessions map[*broker.Client]*session
+
+	// Session delivering the commands of each username, so a device
+	// connected more than once gets each command once
+	commanders map[string]*session
+
+	// Guards pending and is held while commands are published
+	delivery sync.Mutex
+
+	// Commands of each username waiting for the device to subscribe
+	pending map[string][][]byte
+}
+
+// Start starts a broker listening on addr and bridges it to h. It must be
+// called before the hub runs, since it observes the hub's message history.
+func Start(h *hub.Hub, addr string) (*Bridge, error) {
+	b := &Bridge{
+		hub: h,
+		broker: broker.New(&broker.Options{
+			InlineClient: true,
+			Logger:       slog.New(slog.NewTextHandler(log.Writer(), &slog.HandlerOptions{Level: slog.LevelError})),
+		}),
+		queue:      make(chan *store.MessageEvent, queueSize),
+		done:       make(chan struct{}),
+		sessions:   make(map[*broker.Client]*session),
+		commanders: make(map[string]*session),
+		pending:    make(map[string][][]byte),
+	}
+
+	if err := b.broker.AddHook(&brokerHook{bridge: b}, nil); err != nil {
+		return nil, err
+	}
+	if err := b.broker.AddListener(listeners.NewTCP(listeners.Config{ID: "chat", Address: addr})); err != nil {
+		return nil, err
+	}
+	if err := b.broker.Serve(); err != nil {
+		return nil, err
+	}
+
+	h.History.Observe(b.observe)
+	go b.run()
+	return b, nil
+}
+
+// Close disconnects every device and stops the broker
+func (b *Bridge) Close() {
+	close(b.done)
+	if err := b.broker.Close(); err != nil {
+		log.Printf("Error closing MQTT broker: %v", err)
+	}
+}
+
+// observe queues posted, edited and deleted messages for publishing. Events
+// are dropped when the queue is full so slow devices can't stall the chat.
+// Direct messages are never published.
+func (b *Bridge) observe(event *store.MessageEvent, _ *history.Message) {
+	if conversation.IsID(event.RoomID) {
+		return
+	}
+	switch event.Type {
+	case history.EventMessage, history.EventEdit, history.EventDelete:
+	default:
+		return
+	}
+
+	select {
+	case b.queue <- event:
+	default:
+		metrics.MQTTDropped.Add(1)
+	}
+}
+
+// run publishes queued messages to their rooms' topics until the bridge closes
+func (b *Bridge) run() {
+	for {
+		select {
+		case event := <-b.queue:
+			payload, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Error encoding MQTT message: %v", err)
+				continue
+			}
+			if err := b.broker.Publish(roomTopic(event.RoomID, topicMessages), payload, false, 0); err != nil {
+				log.Printf("Error publishing to MQTT: %v", err)
+			}
+
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// roomTopic returns the topic of a room's status or messages
+func roomTopic(roomID, kind string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", topicRoot, topicRooms, roomID, kind)
+}
+
+// commandTopic returns the topic a device receives its commands on
+func commandTopic(username string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", topicRoot, topicDevices, username, topicCommands)
+}
+
+// parseTopic splits a topic of the bridge into the room or username it
+// names and its kind, reporting false for other topics and for filters
+// with wildcards
+func parseTopic(topic string) (collection, name, kind string, ok bool) {
+	if strings.ContainsAny(topic, "+#") {
+		return "", "", "", false
+	}
+	levels := strings.Split(topic, "/")
+	if len(levels) != 4 || levels[0] != topicRoot || levels[2] == "" {
+		return "", "", "", false
+	}
+
+	switch {
+	case levels[1] == topicRooms && (levels[3] == topicStatus || levels[3] == topicMessages):
+	case levels[1] == topicDevices && levels[3] == topicCommands:
+	default:
+		return "", "", "", false
+	}
+	return levels[1], levels[2], levels[3], true
+}
@@ -0,0 +1,323 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"realtime-chat/hooks"
+	"realtime-chat/internal/auth"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/websocket"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// pendingLimit is the most commands kept for a device that hasn't subscribed yet
+const pendingLimit = 100
+
+// session is the chat client of one MQTT connection
+type session struct {
+	conn   *broker.Client
+	client *hub.Client
+
+	// Set once the client has joined the hub; guarded by Bridge.mutex
+	registered bool
+}
+
+// brokerHook passes the broker's events to the bridge
+type brokerHook struct {
+	broker.HookBase
+	bridge *Bridge
+}
+
+func (h *brokerHook) ID() string {
+	return "chat"
+}
+
+func (h *brokerHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		broker.OnConnectAuthenticate,
+		broker.OnACLCheck,
+		broker.OnSessionEstablished,
+		broker.OnDisconnect,
+		broker.OnPublish,
+		broker.OnSubscribed,
+	}, []byte{b})
+}
+
+// OnConnectAuthenticate admits a device. A device logs in with a session
+// token as its password, or chats under its MQTT username when the name is
+// valid and isn't claimed by an account.
+func (h *brokerHook) OnConnectAuthenticate(conn *broker.Client, pk packets.Packet) bool {
+	b := h.bridge
+
+	// Shed new devices while the server is overloaded
+	if b.hub.IsOverloaded() {
+		metrics.ConnectionsShed.Add(1)
+		return false
+	}
+
+	username, sessionID := string(pk.Connect.Username), ""
+	if token := string(pk.Connect.Password); token != "" {
+		name, loggedIn := b.hub.Auth.Session(token)
+		if !loggedIn {
+			return false
+		}
+		username, sessionID = name, auth.TokenSessionID(token)
+	} else if !hub.ValidUsername(username) || b.hub.Auth.GuestsOnly() || b.hub.Auth.Reserved(username) {
+		return false
+	}
+
+	// Refuse devices beyond the connection limit
+	client := websocket.NewClient(b.hub, username)
+	if !b.hub.Admit(client) {
+		return false
+	}
+
+	// Operator hooks may rename or turn away the device
+	connect := &hooks.Connect{ClientID: client.ID, Username: username, RemoteAddr: conn.Net.Remote, Transport: hooks.TransportMQTT}
+	if err := b.hub.Hooks.Connect(connect); err != nil {
+		b.hub.Release(client)
+		log.Printf("MQTT client %s (%s) refused: %v", conn.ID, username, err)
+		return false
+	}
+	// A device may hold several connections under its username, so unlike
+	// other clients it doesn't take one of its own
+	client.SetUsername(connect.Username)
+	client.Transport, client.RemoteAddr, client.Session = connect.Transport, connect.RemoteAddr, sessionID
+	client.ConnectedAt = time.Now()
+
+	b.mutex.Lock()
+	b.sessions[conn] = &session{conn: conn, client: client}
+	b.mutex.Unlock()
+	return true
+}
+
+// OnSessionEstablished joins the device's client to the hub
+func (h *brokerHook) OnSessionEstablished(conn *broker.Client, _ packets.Packet) {
+	b := h.bridge
+	s := b.session(conn)
+	if s == nil {
+		return
+	}
+	if !websocket.Register(s.client) {
+		b.broker.DisconnectClient(conn, packets.ErrServerShuttingDown)
+		return
+	}
+
+	b.mutex.Lock()
+	s.registered = true
+	if b.commanders[s.client.Username] == nil {
+		b.commanders[s.client.Username] = s
+	}
+	b.mutex.Unlock()
+
+	go b.deliver(s)
+}
+
+// OnDisconnect ends the device's chat session, handing its commands to
+// another connection of the same device if there is one
+func (h *brokerHook) OnDisconnect(conn *broker.Client, _ error, _ bool) {
+	b := h.bridge
+
+	b.mutex.Lock()
+	s := b.sessions[conn]
+	delete(b.sessions, conn)
+	if s == nil {
+		b.mutex.Unlock()
+		return
+	}
+	username := s.client.Username
+	if b.commanders[username] == s {
+		delete(b.commanders, username)
+		for _, other := range b.sessions {
+			if other.registered && other.client.Username == username {
+				b.commanders[username] = other
+				break
+			}
+		}
+	}
+	gone := b.commanders[username] == nil
+	b.mutex.Unlock()
+
+	if gone {
+		b.delivery.Lock()
+		delete(b.pending, username)
+		b.delivery.Unlock()
+	}
+	if s.registered {
+		websocket.Disconnect(s.client)
+	} else {
+		b.hub.Release(s.client)
+	}
+}
+
+// OnACLCheck lets devices publish statuses to and read the topics of rooms
+// they can see and aren't banned from, and read their own commands
+func (h *brokerHook) OnACLCheck(conn *broker.Client, topic string, write bool) bool {
+	s := h.bridge.session(conn)
+	if s == nil {
+		return false
+	}
+
+	collection, name, kind, ok := parseTopic(topic)
+	if !ok {
+		return false
+	}
+	if collection == topicDevices {
+		return !write && name == s.client.Username
+	}
+	if write && kind != topicStatus {
+		return false
+	}
+	r, exists := h.bridge.hub.Room("", name)
+	return exists && r.ID == name && r.Visible(s.client.Username) && !r.IsBanned(s.client.Username)
+}
+
+// OnPublish posts a device's status to its room. The status is also passed
+// on to the status topic's subscribers.
+func (h *brokerHook) OnPublish(conn *broker.Client, pk packets.Packet) (packets.Packet, error) {
+	if conn.Net.Inline {
+		return pk, nil
+	}
+	s := h.bridge.session(conn)
+	_, roomID, kind, ok := parseTopic(pk.TopicName)
+	if s == nil || !ok || kind != topicStatus {
+		return pk, packets.ErrRejectPacket
+	}
+
+	content := strings.TrimSpace(string(pk.Payload))
+	if content == "" || !utf8.ValidString(content) || !s.post(roomID, content) {
+		return pk, packets.ErrRejectPacket
+	}
+	return pk, nil
+}
+
+// OnSubscribed delivers the commands that arrived before the device
+// subscribed to them
+func (h *brokerHook) OnSubscribed(conn *broker.Client, pk packets.Packet, reasonCodes []byte) {
+	b := h.bridge
+	s := b.session(conn)
+	if s == nil {
+		return
+	}
+
+	for i, filter := range pk.Filters {
+		if i < len(reasonCodes) && reasonCodes[i] >= packets.ErrUnspecifiedError.Code {
+			continue
+		}
+		if filter.Filter != commandTopic(s.client.Username) {
+			continue
+		}
+
+		b.delivery.Lock()
+		for _, command := range b.pending[s.client.Username] {
+			b.publishCommand(s.client.Username, command)
+		}
+		delete(b.pending, s.client.Username)
+		b.delivery.Unlock()
+	}
+}
+
+// session returns the session of a connection, or nil if it wasn't admitted
+func (b *Bridge) session(conn *broker.Client) *session {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.sessions[conn]
+}
+
+// post sends a status to a room as a chat message from the device, joining
+// the room first if the device is elsewhere. It reports false when the
+// device couldn't join or the status is too long.
+func (s *session) post(roomID, content string) bool {
+	frame, _ := json.Marshal(websocket.Message{Type: "message", Content: content})
+	if len(frame) > s.client.Hub.Connection().MaxFrameSize {
+		return false
+	}
+
+	if s.client.RoomID != roomID {
+		join, _ := json.Marshal(websocket.RoomAction{Type: "join", RoomID: roomID})
+		websocket.HandleFrame(s.client, join)
+		if s.client.RoomID != roomID {
+			return false
+		}
+	}
+
+	websocket.HandleFrame(s.client, frame)
+	return true
+}
+
+// deliver publishes direct messages sent to the device as commands until
+// the hub closes or disconnects the device's client, then disconnects the
+// device
+func (b *Bridge) deliver(s *session) {
+	for {
+		select {
+		case frame := <-s.client.Priority:
+			b.command(s, frame)
+
+		case <-s.client.Closing():
+			// The device's session or API key was revoked
+			b.broker.DisconnectClient(s.conn, packets.ErrAdministrativeAction)
+			return
+
+		case frame, ok := <-s.client.Send:
+			if !ok {
+				// The device was kicked or the server is stopping
+				code := packets.ErrAdministrativeAction
+				select {
+				case <-b.hub.Done():
+					code = packets.ErrServerShuttingDown
+				default:
+				}
+				b.broker.DisconnectClient(s.conn, code)
+				return
+			}
+			b.command(s, frame)
+		}
+	}
+}
+
+// command publishes the content of a direct message frame to the device's
+// command topic, or keeps it until the device subscribes. Other frames
+// are dropped.
+func (b *Bridge) command(s *session, frame []byte) {
+	var dm struct {
+		Type    string `json:"type"`
+		Content string `json:"content"`
+	}
+	if json.Unmarshal(frame, &dm) != nil || dm.Type != "dm" {
+		return
+	}
+
+	// Every connection of the device receives the message; one publishes it
+	username := s.client.Username
+	b.mutex.RLock()
+	commander := b.commanders[username] == s
+	b.mutex.RUnlock()
+	if !commander {
+		return
+	}
+
+	b.delivery.Lock()
+	defer b.delivery.Unlock()
+	if len(b.broker.Topics.Subscribers(commandTopic(username)).Subscriptions) == 0 {
+		if len(b.pending[username]) < pendingLimit {
+			b.pending[username] = append(b.pending[username], []byte(dm.Content))
+		}
+		return
+	}
+	b.publishCommand(username, []byte(dm.Content))
+}
+
+// publishCommand publishes a command to a device; the caller holds b.delivery
+func (b *Bridge) publishCommand(username string, command []byte) {
+	if err := b.broker.Publish(commandTopic(username), command, false, 1); err != nil {
+		log.Printf("Error publishing command to %s: %v", username, err)
+	}
+}
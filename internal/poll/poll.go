@@ -0,0 +1,250 @@
+package poll
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/store"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits on the shape of a poll
+const (
+	MinOptions = 2
+	MaxOptions = 10
+)
+
+// Errors returned when a poll operation is rejected
+var (
+	ErrNotFound      = errors.New("poll not found")
+	ErrClosed        = errors.New("poll is closed")
+	ErrNotCreator    = errors.New("only the poll's creator can close it")
+	ErrInvalidOption = errors.New("invalid option")
+	ErrInvalidPoll   = errors.New("a poll needs a question and between 2 and 10 distinct options")
+)
+
+// Option is one choice of a poll with its tally
+type Option struct {
+	Text   string   `json:"text"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters,omitempty"` // Omitted for anonymous polls
+}
+
+// Results is the public state of a poll: its options and their tallies
+type Results struct {
+	ID         string     `json:"id"`
+	RoomID     string     `json:"roomId"`
+	CreatedBy  string     `json:"createdBy"`
+	Question   string     `json:"question"`
+	Options    []Option   `json:"options"`
+	Anonymous  bool       `json:"anonymous"`
+	TotalVotes int        `json:"totalVotes"`
+	Closed     bool       `json:"closed"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ClosedAt   *time.Time `json:"closedAt,omitempty"`
+}
+
+// Polls tallies the votes of every poll and persists them
+type Polls struct {
+	store store.Store // may be nil for in-memory only polls
+	mutex sync.Mutex
+	polls map[string]*store.PollRecord
+}
+
+// New creates an empty set of polls
+func New(st store.Store) *Polls {
+	return &Polls{store: st, polls: make(map[string]*store.PollRecord)}
+}
+
+// Load reads every persisted poll into memory
+func (p *Polls) Load() error {
+	if p.store == nil {
+		return nil
+	}
+
+	records, err := p.store.LoadPolls()
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, rec := range records {
+		if rec.Votes == nil {
+			rec.Votes = make(map[string]int)
+		}
+		p.polls[rec.ID] = rec
+	}
+	return nil
+}
+
+// Create starts a poll in a room
+func (p *Polls) Create(roomID, username, question string, options []string, anonymous bool) (*Results, error) {
+	question = strings.TrimSpace(question)
+	if question == "" || len(options) < MinOptions || len(options) > MaxOptions {
+		return nil, ErrInvalidPoll
+	}
+
+	seen := make(map[string]bool, len(options))
+	trimmed := make([]string, len(options))
+	for i, option := range options {
+		option = strings.TrimSpace(option)
+		if option == "" || seen[option] {
+			return nil, ErrInvalidPoll
+		}
+		seen[option] = true
+		trimmed[i] = option
+	}
+
+	rec := &store.PollRecord{
+		ID:        newID(),
+		RoomID:    roomID,
+		CreatedBy: username,
+		Question:  question,
+		Options:   trimmed,
+		Anonymous: anonymous,
+		Votes:     make(map[string]int),
+		CreatedAt: time.Now(),
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.save(rec); err != nil {
+		return nil, err
+	}
+	p.polls[rec.ID] = rec
+	return results(rec), nil
+}
+
+// Vote records a user's choice in a poll of the given room, replacing any
+// earlier vote so each user has at most one
+func (p *Polls) Vote(roomID, pollID, username string, option int) (*Results, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	rec, ok := p.polls[pollID]
+	if !ok || rec.RoomID != roomID {
+		return nil, ErrNotFound
+	}
+	if rec.ClosedAt != nil {
+		return nil, ErrClosed
+	}
+	if option < 0 || option >= len(rec.Options) {
+		return nil, ErrInvalidOption
+	}
+
+	previous, voted := rec.Votes[username]
+	rec.Votes[username] = option
+	if err := p.save(rec); err != nil {
+		if voted {
+			rec.Votes[username] = previous
+		} else {
+			delete(rec.Votes, username)
+		}
+		return nil, err
+	}
+	return results(rec), nil
+}
+
+// Close ends voting in a poll of the given room; only its creator may close it
+func (p *Polls) Close(roomID, pollID, username string) (*Results, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	rec, ok := p.polls[pollID]
+	if !ok || rec.RoomID != roomID {
+		return nil, ErrNotFound
+	}
+	if rec.CreatedBy != username {
+		return nil, ErrNotCreator
+	}
+	if rec.ClosedAt != nil {
+		return nil, ErrClosed
+	}
+
+	now := time.Now()
+	rec.ClosedAt = &now
+	if err := p.save(rec); err != nil {
+		rec.ClosedAt = nil
+		return nil, err
+	}
+	return results(rec), nil
+}
+
+// Room returns the results of every poll in a room, oldest first
+func (p *Polls) Room(roomID string) []*Results {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	polls := make([]*Results, 0)
+	for _, rec := range p.polls {
+		if rec.RoomID == roomID {
+			polls = append(polls, results(rec))
+		}
+	}
+	sort.Slice(polls, func(i, j int) bool {
+		return polls[i].CreatedAt.Before(polls[j].CreatedAt)
+	})
+	return polls
+}
+
+// save persists a copy of a poll; the caller must hold the lock
+func (p *Polls) save(rec *store.PollRecord) error {
+	if p.store == nil {
+		return nil
+	}
+
+	c := *rec
+	c.Votes = make(map[string]int, len(rec.Votes))
+	for username, option := range rec.Votes {
+		c.Votes[username] = option
+	}
+	return p.store.SavePoll(&c)
+}
+
+// results tallies a poll's votes; voters are listed unless the poll is anonymous
+func results(rec *store.PollRecord) *Results {
+	r := &Results{
+		ID:         rec.ID,
+		RoomID:     rec.RoomID,
+		CreatedBy:  rec.CreatedBy,
+		Question:   rec.Question,
+		Options:    make([]Option, len(rec.Options)),
+		Anonymous:  rec.Anonymous,
+		TotalVotes: len(rec.Votes),
+		Closed:     rec.ClosedAt != nil,
+		CreatedAt:  rec.CreatedAt,
+		ClosedAt:   rec.ClosedAt,
+	}
+
+	for i, text := range rec.Options {
+		r.Options[i].Text = text
+	}
+	for username, option := range rec.Votes {
+		if option < 0 || option >= len(r.Options) {
+			continue
+		}
+		r.Options[option].Votes++
+		if !rec.Anonymous {
+			r.Options[option].Voters = append(r.Options[option].Voters, username)
+		}
+	}
+	for i := range r.Options {
+		sort.Strings(r.Options[i].Voters)
+	}
+	return r
+}
+
+// newID generates a random poll ID
+func newID() string {
+	return replay.ID("poll", func() string {
+		b := make([]byte, 8)
+		rand.Read(b)
+		return "poll_" + hex.EncodeToString(b)
+	})
+}
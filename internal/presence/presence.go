@@ -0,0 +1,91 @@
+package presence
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// States a user can be in
+const (
+	StateAvailable = "available"
+	StateAway      = "away"
+	StateBusy      = "busy"
+)
+
+// Limits on custom status fields, in characters
+const (
+	MaxText  = 100
+	MaxEmoji = 34 // Long enough for a :shortcode: or a multi-codepoint emoji
+)
+
+// ErrInvalidStatus is returned when a status is rejected
+var ErrInvalidStatus = errors.New(`state must be "available", "away" or "busy"; text is limited to 100 characters and emoji to a single emoji or :shortcode:`)
+
+// Status is a user's presence status
+type Status struct {
+	State string `json:"state"`
+	Text  string `json:"text,omitempty"`
+	Emoji string `json:"emoji,omitempty"`
+}
+
+// Statuses holds the presence status of every user who has set one.
+// Statuses last until the server restarts.
+type Statuses struct {
+	mutex    sync.RWMutex
+	statuses map[string]Status
+}
+
+// New creates an empty status set
+func New() *Statuses {
+	return &Statuses{statuses: make(map[string]Status)}
+}
+
+// Get returns a user's status; users who never set one are available
+func (s *Statuses) Get(username string) Status {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if status, ok := s.statuses[username]; ok {
+		return status
+	}
+	return Status{State: StateAvailable}
+}
+
+// Set replaces a user's status and returns it as stored. An empty state
+// means available.
+func (s *Statuses) Set(username string, status Status) (Status, error) {
+	status.Text = strings.TrimSpace(status.Text)
+	status.Emoji = strings.TrimSpace(status.Emoji)
+	if status.State == "" {
+		status.State = StateAvailable
+	}
+
+	switch status.State {
+	case StateAvailable, StateAway, StateBusy:
+	default:
+		return Status{}, ErrInvalidStatus
+	}
+	if !validText(status.Text, MaxText) || !validText(status.Emoji, MaxEmoji) || strings.ContainsAny(status.Emoji, " \t") {
+		return Status{}, ErrInvalidStatus
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if status == (Status{State: StateAvailable}) {
+		delete(s.statuses, username)
+	} else {
+		s.statuses[username] = status
+	}
+	return status, nil
+}
+
+// validText reports whether s is at most limit characters without control characters
+func validText(s string, limit int) bool {
+	if utf8.RuneCountInString(s) > limit || !utf8.ValidString(s) {
+		return false
+	}
+	return strings.IndexFunc(s, unicode.IsControl) < 0
+}
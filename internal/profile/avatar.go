@@ -0,0 +1,43 @@
+package profile
+
+import (
+	"errors"
+	"realtime-chat/internal/media"
+)
+
+// AvatarSize is the width and height avatars are scaled down to, in pixels
+const AvatarSize = 256
+
+// AvatarSizes are the sizes, in pixels, avatars are stored at so clients can
+// fetch the one closest to how large they show it
+var AvatarSizes = []int{32, 64, 128, AvatarSize}
+
+// MaxAvatarUpload is the largest avatar image accepted for upload, in bytes
+const MaxAvatarUpload = 5 << 20
+
+// ErrInvalidAvatar is returned when an uploaded avatar can't be used
+var ErrInvalidAvatar = errors.New("avatar must be a PNG, GIF or JPEG image of at most 5 MiB and 40 megapixels")
+
+// resizeAvatar crops an image to a centred square and returns it scaled down
+// to each of AvatarSizes, encoded as PNG without the original's metadata
+func resizeAvatar(data []byte) (map[int][]byte, error) {
+	if len(data) == 0 || len(data) > MaxAvatarUpload {
+		return nil, ErrInvalidAvatar
+	}
+	src, err := media.Decode(data)
+	if err != nil {
+		return nil, ErrInvalidAvatar
+	}
+	return media.Thumbnails(src, AvatarSizes)
+}
+
+// avatarSize returns the smallest of AvatarSizes at least as large as size,
+// or AvatarSize when size is 0 or larger than every size
+func avatarSize(size int) int {
+	for _, s := range AvatarSizes {
+		if size > 0 && s >= size {
+			return s
+		}
+	}
+	return AvatarSize
+}
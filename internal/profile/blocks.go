@@ -0,0 +1,75 @@
+package profile
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// MaxBlocked is the most users one user can block
+const MaxBlocked = 1000
+
+// Errors returned when blocking a user
+var (
+	ErrBlockSelf      = errors.New("you can't block yourself")
+	ErrTooManyBlocked = errors.New("you have blocked too many users")
+)
+
+// Blocks reports whether blocker has blocked username
+func (p *Profiles) Blocks(blocker, username string) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	rec, ok := p.profiles[blocker]
+	return ok && slices.Contains(rec.Blocked, username)
+}
+
+// Blocked returns the users a user has blocked, sorted
+func (p *Profiles) Blocked(username string) []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if rec, ok := p.profiles[username]; ok {
+		return slices.Clone(rec.Blocked)
+	}
+	return []string{}
+}
+
+// Block adds username to blocker's block list, so their messages, direct
+// messages and mentions no longer reach blocker
+func (p *Profiles) Block(blocker, username string) error {
+	if blocker == username {
+		return ErrBlockSelf
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// The record is a shallow copy, so the list is copied before it changes
+	rec := p.record(blocker)
+	i, found := slices.BinarySearch(rec.Blocked, username)
+	if found {
+		return nil
+	}
+	if len(rec.Blocked) >= MaxBlocked {
+		return ErrTooManyBlocked
+	}
+	rec.Blocked = slices.Insert(slices.Clone(rec.Blocked), i, username)
+	rec.UpdatedAt = time.Now()
+	return p.save(rec)
+}
+
+// Unblock removes username from blocker's block list
+func (p *Profiles) Unblock(blocker, username string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	rec := p.record(blocker)
+	i, found := slices.BinarySearch(rec.Blocked, username)
+	if !found {
+		return nil
+	}
+	rec.Blocked = slices.Delete(slices.Clone(rec.Blocked), i, i+1)
+	rec.UpdatedAt = time.Now()
+	return p.save(rec)
+}
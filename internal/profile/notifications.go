@@ -0,0 +1,74 @@
+package profile
+
+import (
+	"errors"
+	"maps"
+	"time"
+)
+
+// Notification levels of a room
+const (
+	// LevelAll notifies about every message in the room
+	LevelAll = "all"
+
+	// LevelMentions notifies only about messages that mention the user; the default
+	LevelMentions = "mentions"
+
+	// LevelMuted never notifies about the room
+	LevelMuted = "muted"
+)
+
+// ErrInvalidLevel is returned when a notification level is unknown
+var ErrInvalidLevel = errors.New(`level must be "all", "mentions" or "muted"`)
+
+// NotificationLevel returns a user's notification level for a room
+func (p *Profiles) NotificationLevel(username, roomID string) string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if rec, ok := p.profiles[username]; ok {
+		if level, ok := rec.Notifications[roomID]; ok {
+			return level
+		}
+	}
+	return LevelMentions
+}
+
+// NotificationLevels returns a user's notification level of every room
+// that doesn't use the default
+func (p *Profiles) NotificationLevels(username string) map[string]string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	levels := make(map[string]string)
+	if rec, ok := p.profiles[username]; ok {
+		maps.Copy(levels, rec.Notifications)
+	}
+	return levels
+}
+
+// SetNotificationLevel changes a user's notification level for a room
+func (p *Profiles) SetNotificationLevel(username, roomID, level string) error {
+	switch level {
+	case LevelAll, LevelMentions, LevelMuted:
+	default:
+		return ErrInvalidLevel
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// The record is a shallow copy, so the map is copied before it changes
+	rec := p.record(username)
+	rec.Notifications = maps.Clone(rec.Notifications)
+	if level == LevelMentions {
+		delete(rec.Notifications, roomID)
+	} else {
+		if rec.Notifications == nil {
+			rec.Notifications = make(map[string]string)
+		}
+		rec.Notifications[roomID] = level
+	}
+	rec.UpdatedAt = time.Now()
+	return p.save(rec)
+}
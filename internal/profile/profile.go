@@ -0,0 +1,350 @@
+package profile
+
+import (
+	"errors"
+	"net/mail"
+	"net/url"
+	"realtime-chat/internal/store"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on profile fields, in characters
+const (
+	MaxDisplayName = 64
+	MaxBio         = 500
+	MaxStatus      = 100
+)
+
+// Errors returned when a profile change is rejected
+var (
+	ErrNotFound       = errors.New("avatar not found")
+	ErrInvalidProfile = errors.New("display name, bio and status are limited to 64, 500 and 100 characters and may not contain control characters")
+	ErrInvalidEmail   = errors.New("email must be a valid address")
+)
+
+// Profile is a user's public profile as shown to clients
+type Profile struct {
+	Username    string     `json:"username"`
+	DisplayName string     `json:"displayName"`
+	Bio         string     `json:"bio"`
+	Status      string     `json:"status"`
+	AvatarURL   string     `json:"avatarUrl,omitempty"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+
+	// Whether missed messages are emailed; the address itself is never shown
+	EmailDigests bool `json:"emailDigests"`
+}
+
+// Update is a change to a profile; nil fields are left unchanged
+type Update struct {
+	DisplayName *string `json:"displayName"`
+	Bio         *string `json:"bio"`
+	Status      *string `json:"status"`
+
+	// Address digests of missed messages are sent to; empty removes it
+	Email *string `json:"email"`
+
+	// Set to false to opt out of email digests
+	EmailDigests *bool `json:"emailDigests"`
+}
+
+// Observer is called with a profile after it changes
+type Observer func(profile *Profile)
+
+// Profiles holds every user's profile and avatar
+type Profiles struct {
+	store     store.Store // may be nil for in-memory only profiles
+	mutex     sync.RWMutex
+	profiles  map[string]*store.ProfileRecord
+	observers []Observer
+
+	// Avatars of users by size when there is no store
+	avatars map[string]map[int][]byte
+}
+
+// New creates an empty profile set backed by st
+func New(st store.Store) *Profiles {
+	return &Profiles{
+		store:    st,
+		profiles: make(map[string]*store.ProfileRecord),
+		avatars:  make(map[string]map[int][]byte),
+	}
+}
+
+// Load reads every persisted profile
+func (p *Profiles) Load() error {
+	if p.store == nil {
+		return nil
+	}
+
+	records, err := p.store.LoadProfiles()
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, rec := range records {
+		p.profiles[rec.Username] = rec
+	}
+	return nil
+}
+
+// Observe registers a function called after every profile change. It must
+// be called before the profiles are used.
+func (p *Profiles) Observe(fn Observer) {
+	p.observers = append(p.observers, fn)
+}
+
+// notify passes a changed profile to every observer
+func (p *Profiles) notify(profile *Profile) {
+	for _, fn := range p.observers {
+		fn(profile)
+	}
+}
+
+// Get returns a user's profile. Users who never set one have a profile
+// showing just their username.
+func (p *Profiles) Get(username string) *Profile {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if rec, ok := p.profiles[username]; ok {
+		return view(rec)
+	}
+	return view(&store.ProfileRecord{Username: username})
+}
+
+// Record returns a copy of everything stored about a user's profile,
+// including private settings, or nil if they never set one
+func (p *Profiles) Record(username string) *store.ProfileRecord {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	rec, ok := p.profiles[username]
+	if !ok {
+		return nil
+	}
+	c := *rec
+	if rec.Notifications != nil {
+		c.Notifications = make(map[string]string, len(rec.Notifications))
+		for roomID, level := range rec.Notifications {
+			c.Notifications[roomID] = level
+		}
+	}
+	return &c
+}
+
+// Update changes a user's display name, bio or status
+func (p *Profiles) Update(username string, update Update) (*Profile, error) {
+	fields := []struct {
+		value *string
+		limit int
+	}{
+		{update.DisplayName, MaxDisplayName},
+		{update.Bio, MaxBio},
+		{update.Status, MaxStatus},
+	}
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		*field.value = strings.TrimSpace(*field.value)
+		if !validText(*field.value, field.limit) {
+			return nil, ErrInvalidProfile
+		}
+	}
+	if update.Email != nil && *update.Email != "" {
+		addr, err := mail.ParseAddress(*update.Email)
+		if err != nil {
+			return nil, ErrInvalidEmail
+		}
+		*update.Email = addr.Address
+	}
+
+	p.mutex.Lock()
+	rec := p.record(username)
+	if update.DisplayName != nil {
+		rec.DisplayName = *update.DisplayName
+	}
+	if update.Bio != nil {
+		rec.Bio = *update.Bio
+	}
+	if update.Status != nil {
+		rec.Status = *update.Status
+	}
+	if update.Email != nil {
+		rec.Email = *update.Email
+	}
+	if update.EmailDigests != nil {
+		rec.DigestOptOut = !*update.EmailDigests
+	}
+	rec.UpdatedAt = time.Now()
+
+	if err := p.save(rec); err != nil {
+		p.mutex.Unlock()
+		return nil, err
+	}
+	profile := view(rec)
+	p.mutex.Unlock()
+
+	p.notify(profile)
+	return profile, nil
+}
+
+// SetAvatar replaces a user's avatar with image, cropped to a square and
+// scaled down to each of AvatarSizes
+func (p *Profiles) SetAvatar(username string, image []byte) (*Profile, error) {
+	avatars, err := resizeAvatar(image)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	rec := p.record(username)
+	if p.store != nil {
+		if err := p.store.SaveAvatar(username, avatars); err != nil {
+			p.mutex.Unlock()
+			return nil, err
+		}
+	} else {
+		p.avatars[username] = avatars
+	}
+
+	now := time.Now()
+	rec.AvatarUpdatedAt = &now
+	rec.UpdatedAt = now
+	if err := p.save(rec); err != nil {
+		p.mutex.Unlock()
+		return nil, err
+	}
+	profile := view(rec)
+	p.mutex.Unlock()
+
+	p.notify(profile)
+	return profile, nil
+}
+
+// Avatar returns a user's avatar as a PNG image of the smallest of
+// AvatarSizes at least size pixels wide, or the largest when size is 0
+func (p *Profiles) Avatar(username string, size int) ([]byte, error) {
+	size = avatarSize(size)
+
+	p.mutex.RLock()
+	rec, ok := p.profiles[username]
+	avatar := p.avatars[username][size]
+	p.mutex.RUnlock()
+
+	if !ok || rec.AvatarUpdatedAt == nil {
+		return nil, ErrNotFound
+	}
+	if p.store == nil {
+		return avatar, nil
+	}
+	return p.store.LoadAvatar(username, size)
+}
+
+// Delete removes a user's profile, private settings and avatar, leaving
+// them with the default profile
+func (p *Profiles) Delete(username string) error {
+	p.mutex.Lock()
+	if p.store != nil {
+		if err := p.store.DeleteProfile(username); err != nil {
+			p.mutex.Unlock()
+			return err
+		}
+	}
+	delete(p.profiles, username)
+	delete(p.avatars, username)
+	profile := view(&store.ProfileRecord{Username: username})
+	p.mutex.Unlock()
+
+	p.notify(profile)
+	return nil
+}
+
+// DigestAddress returns where a user's digests are sent and the newest
+// message already emailed to them. ok is false when the user has no
+// address or opted out.
+func (p *Profiles) DigestAddress(username string) (address string, lastDigest time.Time, ok bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	rec, exists := p.profiles[username]
+	if !exists || rec.Email == "" || rec.DigestOptOut {
+		return "", time.Time{}, false
+	}
+	if rec.LastDigestAt != nil {
+		lastDigest = *rec.LastDigestAt
+	}
+	return rec.Email, lastDigest, true
+}
+
+// MarkDigested records that a user's messages up to through have been emailed
+func (p *Profiles) MarkDigested(username string, through time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	rec := p.record(username)
+	rec.LastDigestAt = &through
+	return p.save(rec)
+}
+
+// record returns a copy of a user's profile record to change, so readers
+// holding the current one never see a partial update. The caller must hold the mutex.
+func (p *Profiles) record(username string) *store.ProfileRecord {
+	if rec, ok := p.profiles[username]; ok {
+		changed := *rec
+		return &changed
+	}
+	return &store.ProfileRecord{Username: username}
+}
+
+// save persists a changed profile record and makes it current. The caller must hold the mutex.
+func (p *Profiles) save(rec *store.ProfileRecord) error {
+	if p.store != nil {
+		if err := p.store.SaveProfile(rec); err != nil {
+			return err
+		}
+	}
+	p.profiles[rec.Username] = rec
+	return nil
+}
+
+// validText reports whether s is at most limit characters without control characters
+func validText(s string, limit int) bool {
+	if utf8.RuneCountInString(s) > limit || !utf8.ValidString(s) {
+		return false
+	}
+	return strings.IndexFunc(s, unicode.IsControl) < 0
+}
+
+// view converts a record to its client representation
+func view(rec *store.ProfileRecord) *Profile {
+	profile := &Profile{
+		Username:    rec.Username,
+		DisplayName: rec.DisplayName,
+		Bio:         rec.Bio,
+		Status:      rec.Status,
+
+		EmailDigests: !rec.DigestOptOut,
+	}
+	if !rec.UpdatedAt.IsZero() {
+		updatedAt := rec.UpdatedAt
+		profile.UpdatedAt = &updatedAt
+	}
+	if profile.DisplayName == "" {
+		profile.DisplayName = rec.Username
+	}
+	// The version parameter lets clients cache avatars until they change
+	if rec.AvatarUpdatedAt != nil {
+		profile.AvatarURL = "/api/users/" + url.PathEscape(rec.Username) + "/avatar?v=" +
+			strconv.FormatInt(rec.AvatarUpdatedAt.UnixMilli(), 10)
+	}
+	return profile
+}
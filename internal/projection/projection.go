@@ -0,0 +1,303 @@
+package projection
+
+import (
+	"log"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/store"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// previewLength is the maximum number of characters in a last-message preview
+const previewLength = 80
+
+// Preview is a short summary of a room's latest message
+type Preview struct {
+	MessageID string    `json:"messageId"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	Encrypted bool      `json:"encrypted,omitempty"` // Content is left empty, since the server can't read it
+}
+
+// Summary is the denormalized read model of a single room
+type Summary struct {
+	RoomID       string         `json:"roomId"`
+	MemberCount  int            `json:"memberCount"`  // Connected clients in the room
+	MessageCount int            `json:"messageCount"` // Messages that haven't been deleted
+	LastMessage  *Preview       `json:"lastMessage,omitempty"`
+	Unread       map[string]int `json:"unread"` // Unread messages per member who has visited the room
+}
+
+// Projections maintains room summaries, updated on every history and
+// membership event so room lists never need to query each room's history
+type Projections struct {
+	history *history.History
+	store   store.Store // may be nil; persists read markers
+
+	mutex     sync.Mutex
+	summaries map[string]*Summary
+	present   map[string]map[string]int // Room to username to open connections
+	dirty     map[string]bool           // Rooms whose summary must be recomputed before use
+
+	// Cold returns how many of a room's messages were moved out of
+	// history to cold storage, which still count. May be nil.
+	Cold func(roomID string) int
+}
+
+// New creates projections fed by the given history
+func New(h *history.History, st store.Store) *Projections {
+	p := &Projections{
+		history:   h,
+		store:     st,
+		summaries: make(map[string]*Summary),
+		present:   make(map[string]map[string]int),
+		dirty:     make(map[string]bool),
+	}
+	h.Observe(p.apply)
+	return p
+}
+
+// apply updates the projections for a history event
+func (p *Projections) apply(event *store.MessageEvent, _ *history.Message) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	summary := p.summary(event.RoomID)
+
+	switch event.Type {
+	case history.EventMessage:
+		summary.MessageCount++
+		summary.LastMessage = preview(event.MessageID, event.Username, event.Content, event.Encryption != nil, event.Timestamp)
+		for username := range summary.Unread {
+			if username != event.Username && p.present[event.RoomID][username] == 0 {
+				summary.Unread[username]++
+			}
+		}
+
+	case history.EventEdit:
+		if summary.LastMessage != nil && summary.LastMessage.MessageID == event.MessageID {
+			summary.LastMessage = preview(event.MessageID, summary.LastMessage.Username, event.Content, event.Encryption != nil, summary.LastMessage.Timestamp)
+		}
+
+	case history.EventDelete, history.EventRestore, history.EventExpire, history.EventPrune, history.EventErase, history.EventCompact:
+		// Counts and the preview depend on which message went away, so recompute lazily
+		p.dirty[event.RoomID] = true
+	}
+}
+
+// Joined records a client joining a room; joining marks the room as read
+func (p *Projections) Joined(roomID, username string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.present[roomID] == nil {
+		p.present[roomID] = make(map[string]int)
+	}
+	p.present[roomID][username]++
+
+	summary := p.summary(roomID)
+	summary.MemberCount++
+	summary.Unread[username] = 0
+	p.saveReadMarker(roomID, username)
+}
+
+// Left records a client leaving a room
+func (p *Projections) Left(roomID, username string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.present[roomID][username] > 0 {
+		p.present[roomID][username]--
+	}
+	if p.present[roomID][username] == 0 {
+		delete(p.present[roomID], username)
+		p.saveReadMarker(roomID, username)
+	}
+
+	summary := p.summary(roomID)
+	if summary.MemberCount > 0 {
+		summary.MemberCount--
+	}
+}
+
+// Visit starts counting a user's unread messages in a room they have never
+// visited, from now on; users already counted are left as they are
+func (p *Projections) Visit(roomID, username string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	summary := p.summary(roomID)
+	if _, visited := summary.Unread[username]; visited {
+		return
+	}
+	summary.Unread[username] = 0
+	p.saveReadMarker(roomID, username)
+}
+
+// Read marks a room as read by a user without them joining it
+func (p *Projections) Read(roomID, username string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.summary(roomID).Unread[username] = 0
+	p.saveReadMarker(roomID, username)
+}
+
+// Summary returns a copy of a room's summary
+func (p *Projections) Summary(roomID string) *Summary {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.dirty[roomID] {
+		p.rebuildLocked(roomID)
+	}
+	return copySummary(p.summary(roomID))
+}
+
+// Rebuild recomputes the summaries of the given rooms from history and read markers
+func (p *Projections) Rebuild(roomIDs []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, roomID := range roomIDs {
+		p.rebuildLocked(roomID)
+	}
+}
+
+// Verify compares the live summaries of the given rooms with freshly computed
+// ones and returns the IDs of rooms whose projections have drifted
+func (p *Projections) Verify(roomIDs []string) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var drifted []string
+	for _, roomID := range roomIDs {
+		fresh := p.compute(roomID)
+		if !p.dirty[roomID] && !equal(p.summary(roomID), fresh) {
+			drifted = append(drifted, roomID)
+		}
+	}
+	sort.Strings(drifted)
+	return drifted
+}
+
+// rebuildLocked replaces a room's summary with a freshly computed one; the caller must hold the lock
+func (p *Projections) rebuildLocked(roomID string) {
+	p.summaries[roomID] = p.compute(roomID)
+	delete(p.dirty, roomID)
+}
+
+// compute builds a room's summary from its history, read markers and present members
+func (p *Projections) compute(roomID string) *Summary {
+	summary := &Summary{RoomID: roomID, Unread: make(map[string]int)}
+
+	for _, count := range p.present[roomID] {
+		summary.MemberCount += count
+	}
+
+	markers := map[string]time.Time{}
+	if p.store != nil {
+		var err error
+		if markers, err = p.store.LoadReadMarkers(roomID); err != nil {
+			log.Printf("Error loading read markers for room %s: %v", roomID, err)
+		}
+	}
+	for username := range markers {
+		summary.Unread[username] = 0
+	}
+	for username := range p.present[roomID] {
+		summary.Unread[username] = 0
+	}
+
+	if p.Cold != nil {
+		summary.MessageCount = p.Cold(roomID)
+	}
+	messages, err := p.history.Messages(roomID)
+	if err != nil {
+		log.Printf("Error loading history for room %s: %v", roomID, err)
+		return summary
+	}
+
+	for _, msg := range messages {
+		if msg.Deleted {
+			continue
+		}
+		summary.MessageCount++
+		summary.LastMessage = preview(msg.ID, msg.Username, msg.Content, msg.Encryption != nil, msg.Timestamp)
+
+		for username, readAt := range markers {
+			if username != msg.Username && p.present[roomID][username] == 0 && msg.Timestamp.After(readAt) {
+				summary.Unread[username]++
+			}
+		}
+	}
+	return summary
+}
+
+// summary returns a room's live summary, creating it if needed; the caller must hold the lock
+func (p *Projections) summary(roomID string) *Summary {
+	summary, ok := p.summaries[roomID]
+	if !ok {
+		summary = &Summary{RoomID: roomID, Unread: make(map[string]int)}
+		p.summaries[roomID] = summary
+	}
+	return summary
+}
+
+// saveReadMarker persists that a user has read a room up to now
+func (p *Projections) saveReadMarker(roomID, username string) {
+	if p.store == nil {
+		return
+	}
+	if err := p.store.SaveReadMarker(roomID, username, time.Now()); err != nil {
+		log.Printf("Error saving read marker for %s in room %s: %v", username, roomID, err)
+	}
+}
+
+// preview builds a last-message preview, truncating long content and
+// leaving out ciphertext
+func preview(messageID, username, content string, encrypted bool, timestamp time.Time) *Preview {
+	if encrypted {
+		return &Preview{MessageID: messageID, Username: username, Timestamp: timestamp, Encrypted: true}
+	}
+	if utf8.RuneCountInString(content) > previewLength {
+		content = string([]rune(content)[:previewLength]) + "…"
+	}
+	return &Preview{MessageID: messageID, Username: username, Content: content, Timestamp: timestamp}
+}
+
+// copySummary returns a deep copy of a summary
+func copySummary(s *Summary) *Summary {
+	c := *s
+	if s.LastMessage != nil {
+		last := *s.LastMessage
+		c.LastMessage = &last
+	}
+	c.Unread = make(map[string]int, len(s.Unread))
+	for username, n := range s.Unread {
+		c.Unread[username] = n
+	}
+	return &c
+}
+
+// equal reports whether two summaries hold the same values
+func equal(a, b *Summary) bool {
+	if a.MemberCount != b.MemberCount || a.MessageCount != b.MessageCount || len(a.Unread) != len(b.Unread) {
+		return false
+	}
+	if (a.LastMessage == nil) != (b.LastMessage == nil) {
+		return false
+	}
+	if a.LastMessage != nil && (a.LastMessage.MessageID != b.LastMessage.MessageID || a.LastMessage.Content != b.LastMessage.Content || a.LastMessage.Encrypted != b.LastMessage.Encrypted) {
+		return false
+	}
+	for username, n := range a.Unread {
+		if m, ok := b.Unread[username]; !ok || m != n {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,285 @@
+package rbac
+
+import (
+	"errors"
+	"realtime-chat/internal/store"
+	"sync"
+)
+
+// Role is a set of permissions held by a user
+type Role string
+
+// Roles from least to most privileged
+const (
+	Guest     Role = "guest"
+	Member    Role = "member"
+	Moderator Role = "moderator"
+	Admin     Role = "admin"
+)
+
+// Permission allows a group of actions
+type Permission string
+
+// Permissions checked across room actions, moderation and the admin API
+const (
+	// Send messages, start polls and run slash commands
+	PermPost Permission = "post"
+
+	// React to messages and vote in polls
+	PermReact Permission = "react"
+
+	// Send direct messages
+	PermDirectMessage Permission = "direct_message"
+
+	// Create rooms
+	PermCreateRoom Permission = "create_room"
+
+	// Ban, kick and mute users, pin messages, delete other users' messages,
+	// set the topic and manage posters
+	PermModerate Permission = "moderate"
+
+	// Change a room's webhook and message ttl
+	PermManageRoom Permission = "manage_room"
+
+	// Assign roles to other users
+	PermAssignRoles Permission = "assign_roles"
+
+	// Use the admin API; only granted by a global role
+	PermAdminAPI Permission = "admin_api"
+)
+
+// matrix lists the permissions of each role
+var matrix = map[Role][]Permission{
+	Guest:     {PermReact},
+	Member:    {PermReact, PermPost, PermDirectMessage, PermCreateRoom},
+	Moderator: {PermReact, PermPost, PermDirectMessage, PermCreateRoom, PermModerate},
+	Admin:     {PermReact, PermPost, PermDirectMessage, PermCreateRoom, PermModerate, PermManageRoom, PermAssignRoles, PermAdminAPI},
+}
+
+// rank orders roles by privilege
+var rank = map[Role]int{Guest: 0, Member: 1, Moderator: 2, Admin: 3}
+
+// ErrInvalidRole is returned when assigning a role that doesn't exist
+var ErrInvalidRole = errors.New("role must be guest, member, moderator or admin")
+
+// Valid reports whether r is a known role
+func Valid(r Role) bool {
+	_, ok := rank[r]
+	return ok
+}
+
+// Has reports whether a role grants a permission
+func (r Role) Has(perm Permission) bool {
+	for _, p := range matrix[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks reports whether r is more privileged than other
+func (r Role) Outranks(other Role) bool {
+	return rank[r] > rank[other]
+}
+
+// Permissions returns every permission of a role
+func (r Role) Permissions() []Permission {
+	return append([]Permission(nil), matrix[r]...)
+}
+
+// Roles holds the roles assigned to users globally and in each room
+type Roles struct {
+	store       store.Store // may be nil for in-memory only roles
+	defaultRole Role
+
+	// Claimed reports whether a username belongs to an account. Roles above
+	// member, and owning a room, only apply to claimed usernames, since anyone
+	// can connect as the others. May be nil.
+	Claimed func(username string) bool
+
+	// Owner reports whether a user created a room; owners are admins of
+	// their own rooms. May be nil.
+	Owner func(roomID, username string) bool
+
+	// Guest reports whether a username is a guest identity. Guests can't
+	// send direct messages or create rooms whatever their role. May be nil.
+	Guest func(username string) bool
+
+	mutex  sync.RWMutex
+	global map[string]Role            // username -> role
+	rooms  map[string]map[string]Role // room ID -> username -> role
+}
+
+// New creates a role set backed by st in which users without an assigned
+// role have defaultRole
+func New(st store.Store, defaultRole Role) *Roles {
+	return &Roles{
+		store:       st,
+		defaultRole: defaultRole,
+		global:      make(map[string]Role),
+		rooms:       make(map[string]map[string]Role),
+	}
+}
+
+// Load reads every persisted role assignment
+func (r *Roles) Load() error {
+	if r.store == nil {
+		return nil
+	}
+
+	records, err := r.store.LoadRoles()
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, rec := range records {
+		r.assign(rec.RoomID, rec.Username, Role(rec.Role))
+	}
+	return nil
+}
+
+// Default returns the role of users without an assigned role
+func (r *Roles) Default() Role {
+	return r.defaultRole
+}
+
+// Role returns a user's effective role in a room, or their global role when
+// roomID is empty. A room role replaces the global one in that room, except
+// that global admins are admins everywhere. Usernames without an account
+// are never above member, though room roles below it still apply to them.
+func (r *Roles) Role(username, roomID string) Role {
+	r.mutex.RLock()
+	role, ok := r.global[username]
+	if !ok {
+		role = r.defaultRole
+	}
+	roomRole, hasRoomRole := r.rooms[roomID][username]
+	r.mutex.RUnlock()
+
+	claimed := r.Claimed == nil || r.Claimed(username)
+	if role.Outranks(Member) && !claimed {
+		role = Member
+	}
+	if hasRoomRole && role != Admin && (claimed || !roomRole.Outranks(Member)) {
+		role = roomRole
+	}
+	if claimed && roomID != "" && r.Owner != nil && r.Owner(roomID, username) {
+		role = Admin
+	}
+	return role
+}
+
+// Can reports whether a user holds a permission in a room, or globally when
+// roomID is empty. The admin API is only granted by a global role.
+func (r *Roles) Can(username, roomID string, perm Permission) bool {
+	if (perm == PermDirectMessage || perm == PermCreateRoom) && r.Guest != nil && r.Guest(username) {
+		return false
+	}
+	if perm == PermAdminAPI {
+		roomID = ""
+	}
+	return r.Role(username, roomID).Has(perm)
+}
+
+// Elevated reports whether a user holds a moderator or admin role globally
+// or is assigned one in any room. Owning a room doesn't count, and neither
+// do roles of usernames without an account, as with Role.
+func (r *Roles) Elevated(username string) bool {
+	if r.Claimed != nil && !r.Claimed(username) {
+		return false
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	role, ok := r.global[username]
+	if !ok {
+		role = r.defaultRole
+	}
+	if role.Outranks(Member) {
+		return true
+	}
+	for _, roles := range r.rooms {
+		if roles[username].Outranks(Member) {
+			return true
+		}
+	}
+	return false
+}
+
+// Assign gives a user a role in a room, or globally when roomID is empty.
+// An empty role removes the assignment.
+func (r *Roles) Assign(roomID, username string, role Role) error {
+	if role != "" && !Valid(role) {
+		return ErrInvalidRole
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.store != nil {
+		if err := r.store.SaveRole(&store.RoleRecord{Username: username, RoomID: roomID, Role: string(role)}); err != nil {
+			return err
+		}
+	}
+	r.assign(roomID, username, role)
+	return nil
+}
+
+// Assigned returns the role assigned to a user in a room, or globally when
+// roomID is empty, or "" if there is none
+func (r *Roles) Assigned(roomID, username string) Role {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if roomID == "" {
+		return r.global[username]
+	}
+	return r.rooms[roomID][username]
+}
+
+// Assignments returns the global roles and every room's roles
+func (r *Roles) Assignments() (global map[string]Role, rooms map[string]map[string]Role) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	global = make(map[string]Role, len(r.global))
+	for username, role := range r.global {
+		global[username] = role
+	}
+	rooms = make(map[string]map[string]Role, len(r.rooms))
+	for roomID, roles := range r.rooms {
+		rooms[roomID] = make(map[string]Role, len(roles))
+		for username, role := range roles {
+			rooms[roomID][username] = role
+		}
+	}
+	return global, rooms
+}
+
+// assign applies an assignment in memory. The caller must hold the mutex.
+func (r *Roles) assign(roomID, username string, role Role) {
+	if roomID == "" {
+		if role == "" {
+			delete(r.global, username)
+		} else {
+			r.global[username] = role
+		}
+		return
+	}
+
+	if role == "" {
+		delete(r.rooms[roomID], username)
+		if len(r.rooms[roomID]) == 0 {
+			delete(r.rooms, roomID)
+		}
+		return
+	}
+	if r.rooms[roomID] == nil {
+		r.rooms[roomID] = make(map[string]Role)
+	}
+	r.rooms[roomID][username] = role
+}
@@ -0,0 +1,156 @@
+package rbac
+
+import (
+	"path/filepath"
+	"realtime-chat/internal/store"
+	"testing"
+)
+
+// newTestRoles returns roles defaulting to member in which only alice,
+// bob and carol have accounts, and alice owns room_owned
+func newTestRoles() *Roles {
+	r := New(nil, Member)
+	accounts := map[string]bool{"alice": true, "bob": true, "carol": true}
+	r.Claimed = func(username string) bool { return accounts[username] }
+	r.Owner = func(roomID, username string) bool { return roomID == "room_owned" && username == "alice" }
+	r.Guest = func(username string) bool { return username == "guest-1" }
+	return r
+}
+
+func TestRolePermissions(t *testing.T) {
+	for _, tt := range []struct {
+		role Role
+		perm Permission
+		want bool
+	}{
+		{Guest, PermReact, true},
+		{Guest, PermPost, false},
+		{Member, PermPost, true},
+		{Member, PermModerate, false},
+		{Moderator, PermModerate, true},
+		{Moderator, PermManageRoom, false},
+		{Admin, PermAssignRoles, true},
+		{Admin, PermAdminAPI, true},
+		{Role("owner"), PermReact, false},
+	} {
+		if got := tt.role.Has(tt.perm); got != tt.want {
+			t.Errorf("%s has %s: %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+	if !Admin.Outranks(Moderator) || !Moderator.Outranks(Member) || !Member.Outranks(Guest) || Member.Outranks(Member) {
+		t.Error("roles are out of order")
+	}
+	if Valid("owner") || !Valid(Guest) {
+		t.Error("Valid disagrees with the roles")
+	}
+}
+
+func TestRole(t *testing.T) {
+	r := newTestRoles()
+	mustAssign(t, r, "", "bob", Moderator)
+	mustAssign(t, r, "room_1", "bob", Guest)
+	mustAssign(t, r, "room_1", "carol", Moderator)
+	mustAssign(t, r, "", "dave", Admin)
+	mustAssign(t, r, "room_1", "erin", Moderator)
+	mustAssign(t, r, "room_1", "frank", Guest)
+	mustAssign(t, r, "", "carol", Admin)
+
+	for _, tt := range []struct {
+		username, roomID string
+		want             Role
+	}{
+		{"alice", "", Member},
+		{"bob", "", Moderator},
+		{"bob", "room_1", Guest},       // Room roles replace global ones
+		{"bob", "room_2", Moderator},   // but only in their room
+		{"carol", "room_1", Admin},     // except that global admins are admins everywhere
+		{"alice", "room_owned", Admin}, // Owners are admins of their rooms
+		{"alice", "room_1", Member},
+
+		// Usernames without an account are never above member
+		{"dave", "", Member},
+		{"erin", "room_1", Member},
+		{"frank", "room_1", Guest}, // though lower room roles still apply
+		{"mallory", "room_owned", Member},
+	} {
+		if got := r.Role(tt.username, tt.roomID); got != tt.want {
+			t.Errorf("role of %s in %q is %s, want %s", tt.username, tt.roomID, got, tt.want)
+		}
+	}
+}
+
+func TestCan(t *testing.T) {
+	r := newTestRoles()
+	mustAssign(t, r, "room_1", "bob", Admin)
+	mustAssign(t, r, "", "guest-1", Moderator)
+
+	if !r.Can("alice", "room_1", PermPost) || r.Can("alice", "room_1", PermModerate) {
+		t.Error("member permissions are wrong")
+	}
+	if !r.Can("bob", "room_1", PermManageRoom) {
+		t.Error("room admin can't manage their room")
+	}
+	if r.Can("bob", "room_1", PermAdminAPI) || r.Can("alice", "room_owned", PermAdminAPI) {
+		t.Error("room role granted the admin API")
+	}
+	if r.Can("guest-1", "", PermDirectMessage) || r.Can("guest-1", "", PermCreateRoom) {
+		t.Error("guest can send direct messages or create rooms")
+	}
+}
+
+func TestElevated(t *testing.T) {
+	r := newTestRoles()
+	mustAssign(t, r, "", "bob", Moderator)
+	mustAssign(t, r, "room_1", "carol", Admin)
+	mustAssign(t, r, "", "dave", Admin)
+	mustAssign(t, r, "room_1", "erin", Moderator)
+
+	for username, want := range map[string]bool{
+		"alice": false, // Owning a room doesn't count
+		"bob":   true,
+		"carol": true,
+		"dave":  false, // Unclaimed usernames hold no role above member
+		"erin":  false,
+	} {
+		if got := r.Elevated(username); got != want {
+			t.Errorf("Elevated(%s) = %v, want %v", username, got, want)
+		}
+	}
+}
+
+func TestAssign(t *testing.T) {
+	st, err := store.NewMemoryStore(filepath.Join(t.TempDir(), "snapshot.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(st, Member)
+	if err := r.Assign("", "alice", "owner"); err != ErrInvalidRole {
+		t.Fatalf("assigning an unknown role: %v, want %v", err, ErrInvalidRole)
+	}
+	mustAssign(t, r, "room_1", "alice", Moderator)
+	mustAssign(t, r, "", "bob", Admin)
+	mustAssign(t, r, "", "bob", "")
+
+	// Assignments are persisted and read back by Load
+	loaded := New(st, Member)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Assigned("room_1", "alice"); got != Moderator {
+		t.Errorf("alice's room role is %q after Load, want %q", got, Moderator)
+	}
+	if got := loaded.Assigned("", "bob"); got != "" {
+		t.Errorf("bob's removed role is %q after Load", got)
+	}
+	global, rooms := loaded.Assignments()
+	if len(global) != 0 || len(rooms) != 1 || rooms["room_1"]["alice"] != Moderator {
+		t.Errorf("unexpected assignments %v %v", global, rooms)
+	}
+}
+
+func mustAssign(t *testing.T, r *Roles, roomID, username string, role Role) {
+	t.Helper()
+	if err := r.Assign(roomID, username, role); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+package replay
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Mismatch is the first difference between the frames a client was delivered
+// from one channel in the recording and in the replay
+type Mismatch struct {
+	Client   string `json:"client"`
+	Channel  string `json:"channel"`
+	Index    int    `json:"index"`              // Position of the frame among the client's frames on the channel
+	Recorded string `json:"recorded,omitempty"` // Empty when the replay delivered an extra frame
+	Replayed string `json:"replayed,omitempty"` // Empty when the replay missed a frame
+}
+
+// Report is the outcome of a replay
+type Report struct {
+	Inputs     int         `json:"inputs"`
+	Recorded   int         `json:"recorded"`
+	Replayed   int         `json:"replayed"`
+	Mismatches []*Mismatch `json:"mismatches"`
+}
+
+// OK reports whether the replay delivered the same frames as the recording
+func (r *Report) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+// delivery identifies the frames a client received from one of its channels
+type delivery struct {
+	client  string
+	channel string
+}
+
+// Compare compares the deliveries of a recording with those of its replay.
+// Frames are compared per client and channel, since the order frames from
+// different clients, or from a client's two channels, were written in
+// depends on scheduling. Timestamps are ignored.
+func Compare(recorded, replayed []*Event) *Report {
+	report := &Report{}
+	want := deliveries(recorded)
+	got := deliveries(replayed)
+	for _, event := range recorded {
+		if event.IsInput() {
+			report.Inputs++
+		}
+	}
+
+	keys := make(map[delivery]bool)
+	for key, frames := range want {
+		keys[key] = true
+		report.Recorded += len(frames)
+	}
+	for key, frames := range got {
+		keys[key] = true
+		report.Replayed += len(frames)
+	}
+
+	for key := range keys {
+		if m := firstMismatch(key, want[key], got[key]); m != nil {
+			report.Mismatches = append(report.Mismatches, m)
+		}
+	}
+	sort.Slice(report.Mismatches, func(i, j int) bool {
+		a, b := report.Mismatches[i], report.Mismatches[j]
+		if a.Client != b.Client {
+			return a.Client < b.Client
+		}
+		return a.Channel < b.Channel
+	})
+	return report
+}
+
+// deliveries groups the delivered frames of events by client and channel
+func deliveries(events []*Event) map[delivery][]string {
+	frames := make(map[delivery][]string)
+	for _, event := range events {
+		if event.Kind == KindDeliver {
+			key := delivery{client: event.Client, channel: event.Source}
+			frames[key] = append(frames[key], event.Data)
+		}
+	}
+	return frames
+}
+
+// firstMismatch returns the first frame that differs between want and got, or nil
+func firstMismatch(key delivery, want, got []string) *Mismatch {
+	for i := 0; i < len(want) || i < len(got); i++ {
+		m := &Mismatch{Client: key.client, Channel: key.channel, Index: i}
+		if i < len(want) {
+			m.Recorded = want[i]
+		}
+		if i < len(got) {
+			m.Replayed = got[i]
+		}
+		if m.Recorded == "" || m.Replayed == "" || normalize(m.Recorded) != normalize(m.Replayed) {
+			return m
+		}
+	}
+	return nil
+}
+
+// normalize returns a frame with its timestamps removed, so frames that only
+// differ in wall-clock time compare equal
+func normalize(frame string) string {
+	var value interface{}
+	if err := json.Unmarshal([]byte(frame), &value); err != nil {
+		return frame
+	}
+	data, err := json.Marshal(stripTimes(value))
+	if err != nil {
+		return frame
+	}
+	return string(data)
+}
+
+// stripTimes replaces every RFC 3339 timestamp in a decoded JSON value
+func stripTimes(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			v[key] = stripTimes(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = stripTimes(item)
+		}
+	case string:
+		if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return "<time>"
+		}
+	}
+	return value
+}
@@ -0,0 +1,190 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Kinds of recorded events
+const (
+	// KindConnect is a client connecting, with its Client ID and Username,
+	// what it connected to and the room it joined first
+	KindConnect = "connect"
+
+	// KindFrame is a frame received from a client, in Data
+	KindFrame = "frame"
+
+	// KindDisconnect is a client disconnecting
+	KindDisconnect = "disconnect"
+
+	// KindDeliver is a frame written to a client, in Data
+	KindDeliver = "deliver"
+
+	// KindID is an ID generated by Source, in Data
+	KindID = "id"
+)
+
+// Client channels a delivered frame was written from, in Source
+const (
+	ChannelSend     = "send"
+	ChannelPriority = "priority"
+)
+
+// Event is a single recorded hub or room event
+type Event struct {
+	Seq      uint64 `json:"seq"` // Logical timestamp: the event's position in the recording
+	Kind     string `json:"kind"`
+	Client   string `json:"client,omitempty"`
+	Username string `json:"username,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Data     string `json:"data,omitempty"`
+
+	// Protocol features the client's transport supports, on connect events
+	Features uint32 `json:"features,omitempty"`
+
+	// Workspace, embedded widget's room and login session of the client, and
+	// the room it joined first, on connect events
+	Workspace string `json:"workspace,omitempty"`
+	EmbedRoom string `json:"embedRoom,omitempty"`
+	Session   string `json:"session,omitempty"`
+	Room      string `json:"room,omitempty"`
+}
+
+// IsInput reports whether the event is an input that a replay re-executes
+func (e *Event) IsInput() bool {
+	return e.Kind == KindConnect || e.Kind == KindFrame || e.Kind == KindDisconnect
+}
+
+// Recorder appends events to a file, giving each the next logical timestamp.
+// The order of the file is the order the events happened in.
+type Recorder struct {
+	mutex sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	seq   uint64
+}
+
+// NewRecorder creates a recorder that writes to path, replacing any previous recording
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+	return &Recorder{file: f, w: bufio.NewWriter(f)}, nil
+}
+
+// Record appends an event, setting its logical timestamp. A nil recorder records nothing.
+func (r *Recorder) Record(event *Event) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.seq++
+	event.Seq = r.seq
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding recorded event: %v", err)
+		return
+	}
+	r.w.Write(append(data, '\n'))
+}
+
+// Close flushes and closes the recording
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+		return fmt.Errorf("flush recording: %w", err)
+	}
+	return r.file.Close()
+}
+
+// Load reads a recording
+func Load(path string) ([]*Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	defer f.Close()
+
+	var events []*Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("decode recording: %w", err)
+		}
+		events = append(events, &event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read recording: %w", err)
+	}
+	return events, nil
+}
+
+// idSource supplies generated IDs: recording them, or replaying recorded ones
+type idSource struct {
+	recorder *Recorder
+
+	mutex    sync.Mutex
+	recorded map[string][]string // Recorded IDs of each source not yet replayed
+}
+
+// ids is the process-wide ID source; nil generates IDs normally
+var ids atomic.Pointer[idSource]
+
+// RecordIDs records every ID generated from now on to r
+func RecordIDs(r *Recorder) {
+	ids.Store(&idSource{recorder: r})
+}
+
+// ReplayIDs makes ID generation return the IDs in events, per source and in
+// recorded order, so a replay reuses the IDs seen in production
+func ReplayIDs(events []*Event) {
+	source := &idSource{recorded: make(map[string][]string)}
+	for _, event := range events {
+		if event.Kind == KindID {
+			source.recorded[event.Source] = append(source.recorded[event.Source], event.Data)
+		}
+	}
+	ids.Store(source)
+}
+
+// ResetIDs restores normal ID generation
+func ResetIDs() {
+	ids.Store(nil)
+}
+
+// ID returns a new ID from source. It calls generate unless a replay has a
+// recorded ID left for the source, and records the ID while recording.
+func ID(source string, generate func() string) string {
+	s := ids.Load()
+	if s == nil {
+		return generate()
+	}
+
+	if s.recorder != nil {
+		id := generate()
+		s.recorder.Record(&Event{Kind: KindID, Source: source, Data: id})
+		return id
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if recorded := s.recorded[source]; len(recorded) > 0 {
+		s.recorded[source] = recorded[1:]
+		return recorded[0]
+	}
+	return generate()
+}
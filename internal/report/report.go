@@ -0,0 +1,207 @@
+package report
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/store"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxReason is the longest reason a report can give, in characters
+const MaxReason = 500
+
+// Report statuses
+const (
+	StatusOpen      = "open"
+	StatusResolved  = "resolved"
+	StatusDismissed = "dismissed"
+)
+
+// Errors returned when a report operation is rejected
+var (
+	ErrNotFound      = errors.New("report not found")
+	ErrInvalidReport = errors.New("a report needs a reason of at most 500 characters")
+	ErrDuplicate     = errors.New("you have already reported this message")
+	ErrInvalidStatus = errors.New("status must be resolved or dismissed")
+	ErrClosed        = errors.New("report is already closed")
+)
+
+// Reports is the moderation queue of messages flagged by users
+type Reports struct {
+	store   store.Store // may be nil for in-memory only reports
+	mutex   sync.Mutex
+	reports map[string]*store.ReportRecord
+}
+
+// New creates an empty report queue
+func New(st store.Store) *Reports {
+	return &Reports{store: st, reports: make(map[string]*store.ReportRecord)}
+}
+
+// Load reads every persisted report into memory
+func (r *Reports) Load() error {
+	if r.store == nil {
+		return nil
+	}
+
+	records, err := r.store.LoadReports()
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, rec := range records {
+		r.reports[rec.ID] = rec
+	}
+	return nil
+}
+
+// File adds an open report of a message, keeping the author and content the
+// message had when it was reported. A user can only have one open report of
+// each message.
+func (r *Reports) File(roomID, messageID, reporter, reason, author, content string) (*store.ReportRecord, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || len([]rune(reason)) > MaxReason {
+		return nil, ErrInvalidReport
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, rec := range r.reports {
+		if rec.Status == StatusOpen && rec.RoomID == roomID && rec.MessageID == messageID && rec.Reporter == reporter {
+			return nil, ErrDuplicate
+		}
+	}
+
+	rec := &store.ReportRecord{
+		ID:        newID(),
+		RoomID:    roomID,
+		MessageID: messageID,
+		Reporter:  reporter,
+		Reason:    reason,
+		Author:    author,
+		Content:   content,
+		CreatedAt: time.Now(),
+		Status:    StatusOpen,
+	}
+	if err := r.save(rec); err != nil {
+		return nil, err
+	}
+	return copyReport(rec), nil
+}
+
+// List returns reports oldest first, filtered by status and room when they
+// are not empty
+func (r *Reports) List(status, roomID string) []*store.ReportRecord {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	reports := make([]*store.ReportRecord, 0)
+	for _, rec := range r.reports {
+		if (status == "" || rec.Status == status) && (roomID == "" || rec.RoomID == roomID) {
+			reports = append(reports, copyReport(rec))
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].CreatedAt.Before(reports[j].CreatedAt)
+	})
+	return reports
+}
+
+// Resolve closes an open report as resolved or dismissed, recording who
+// closed it and an optional note
+func (r *Reports) Resolve(id, by, status, note string) (*store.ReportRecord, error) {
+	if status != StatusResolved && status != StatusDismissed {
+		return nil, ErrInvalidStatus
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	current, ok := r.reports[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if current.Status != StatusOpen {
+		return nil, ErrClosed
+	}
+
+	// Update a copy so readers never see a partial update
+	rec := copyReport(current)
+	now := time.Now()
+	rec.Status = status
+	rec.ResolvedBy = by
+	rec.ResolvedAt = &now
+	rec.Note = strings.TrimSpace(note)
+	if err := r.save(rec); err != nil {
+		return nil, err
+	}
+	return copyReport(rec), nil
+}
+
+// EraseUser replaces a user's name with pseudonym in every report they
+// filed, were reported in or closed, and with purge also drops the content
+// of their reported messages. Returns how many reports changed.
+func (r *Reports) EraseUser(username, pseudonym string, purge bool) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	changed := 0
+	for _, current := range r.reports {
+		if current.Reporter != username && current.Author != username && current.ResolvedBy != username {
+			continue
+		}
+
+		rec := copyReport(current)
+		if rec.Reporter == username {
+			rec.Reporter = pseudonym
+		}
+		if rec.Author == username {
+			rec.Author = pseudonym
+			if purge {
+				rec.Content = ""
+			}
+		}
+		if rec.ResolvedBy == username {
+			rec.ResolvedBy = pseudonym
+		}
+		if err := r.save(rec); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// save persists a report and makes it current. The caller must hold the mutex.
+func (r *Reports) save(rec *store.ReportRecord) error {
+	if r.store != nil {
+		if err := r.store.SaveReport(rec); err != nil {
+			return err
+		}
+	}
+	r.reports[rec.ID] = rec
+	return nil
+}
+
+// copyReport returns a copy of a report safe to hand out
+func copyReport(rec *store.ReportRecord) *store.ReportRecord {
+	c := *rec
+	return &c
+}
+
+// newID returns a random report ID
+func newID() string {
+	return replay.ID("report", func() string {
+		b := make([]byte, 8)
+		rand.Read(b)
+		return "report_" + hex.EncodeToString(b)
+	})
+}
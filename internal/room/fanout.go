@@ -0,0 +1,128 @@
+package room
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// Fanout is a pool of workers that deliver a broadcast to the clients of
+// large rooms in parallel, so a room of thousands doesn't have its room
+// goroutine write to every send queue in turn. Rooms smaller than the
+// threshold are delivered to by their own goroutine as before.
+type Fanout struct {
+	workers   int
+	threshold int
+	jobs      chan *fanoutJob
+	ctx       context.Context
+}
+
+// fanoutJob is one partition of a broadcast's recipients
+type fanoutJob struct {
+	room    *Room
+	clients []*Client
+	message []byte
+	sender  *Client // Skipped; may be nil
+	author  string  // Skipped by clients who block them; may be empty
+
+	// Shared by the partitions of one broadcast
+	recipients *atomic.Int64
+	dropped    *atomic.Int64
+	done       *sync.WaitGroup
+}
+
+// NewFanout starts workers that deliver broadcasts to rooms of at least
+// threshold clients until ctx is cancelled
+func NewFanout(ctx context.Context, workers, threshold int) *Fanout {
+	f := &Fanout{
+		workers:   workers,
+		threshold: threshold,
+		jobs:      make(chan *fanoutJob),
+		ctx:       ctx,
+	}
+	for i := 0; i < workers; i++ {
+		go f.work()
+	}
+	log.Printf("Broadcast fan-out pool started with %d workers for rooms of %d or more clients", workers, threshold)
+	return f
+}
+
+// work delivers partitions until the pool's context is cancelled
+func (f *Fanout) work() {
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case job := <-f.jobs:
+			job.run()
+		}
+	}
+}
+
+// run delivers the job's message to its partition of the recipients
+func (job *fanoutJob) run() {
+	defer job.done.Done()
+
+	var recipients, dropped int64
+	for _, client := range job.clients {
+		if client == job.sender || job.room.hides(client, job.author) {
+			continue
+		}
+		if job.room.deliver(client, job.message) {
+			recipients++
+		} else {
+			dropped++
+		}
+	}
+	job.recipients.Add(recipients)
+	job.dropped.Add(dropped)
+}
+
+// parallel reports whether a broadcast to n clients is split among the
+// workers; it is false for a nil pool
+func (f *Fanout) parallel(n int) bool {
+	return f != nil && n >= f.threshold
+}
+
+// deliver sends message to clients other than sender and those who block
+// author, split into one
+// partition per worker plus one for the calling room goroutine, and returns
+// once every partition is done so the room's broadcasts stay in order. Partitions no
+// worker is free to take, or that arrive after the pool stopped, are
+// delivered by the caller.
+func (f *Fanout) deliver(r *Room, clients []*Client, message []byte, sender *Client, author string) (recipients, dropped int) {
+	var (
+		delivered, missed atomic.Int64
+		done              sync.WaitGroup
+	)
+
+	size := (len(clients) + f.workers) / (f.workers + 1)
+	for len(clients) > size {
+		job := &fanoutJob{
+			room:       r,
+			clients:    clients[:size],
+			message:    message,
+			sender:     sender,
+			author:     author,
+			recipients: &delivered,
+			dropped:    &missed,
+			done:       &done,
+		}
+		clients = clients[size:]
+
+		done.Add(1)
+		select {
+		case f.jobs <- job:
+		default:
+			job.run()
+		}
+	}
+
+	// The last partition is the room goroutine's own
+	done.Add(1)
+	(&fanoutJob{room: r, clients: clients, message: message, sender: sender, author: author, recipients: &delivered, dropped: &missed, done: &done}).run()
+
+	done.Wait()
+	return int(delivered.Load()), int(missed.Load())
+}
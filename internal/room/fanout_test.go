@@ -0,0 +1,134 @@
+package room
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"realtime-chat/internal/metrics"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// benchQueueSize is the send queue of each benchmarked client; the queues
+// are emptied after every benchQueueSize broadcasts so no message is dropped
+const benchQueueSize = 256
+
+// newLobby starts a manager with a lobby of n clients, split among workers
+// when workers is positive
+func newLobby(tb testing.TB, n, workers int) (*Room, []*Client) {
+	tb.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewManager(ctx)
+	if workers > 0 {
+		m.Fanout = NewFanout(ctx, workers, 1)
+	}
+	// Skip join notices, which would fill every queue n times over
+	m.Overloaded = func() bool { return true }
+	m.EnsureLobby("")
+	go m.Run()
+	tb.Cleanup(func() {
+		cancel()
+		m.Stop()
+	})
+	lobby, _ := m.GetRoom(LobbyID)
+
+	clients := make([]*Client, n)
+	for i := range clients {
+		clients[i] = &Client{
+			ID:        "bench-" + strconv.Itoa(i),
+			Username:  "bench-" + strconv.Itoa(i),
+			Send:      make(chan []byte, benchQueueSize),
+			Priority:  make(chan []byte, 1),
+			Room:      lobby,
+			SendQueue: metrics.NewSendQueue(),
+		}
+		lobby.Register <- clients[i]
+	}
+	return lobby, clients
+}
+
+// settle returns once the room has delivered every broadcast sent to it
+// before, by joining and leaving a client, which the room only receives
+// between broadcasts. Join and leave notices are skipped in these rooms.
+func settle(r *Room) {
+	c := &Client{ID: "settle", Username: "settle", Send: make(chan []byte, 1), Priority: make(chan []byte, 1), Room: r}
+	r.Register <- c
+	r.Unregister <- c
+}
+
+// drain empties the clients' send queues
+func drain(clients []*Client) {
+	for _, client := range clients {
+		for len(client.Send) > 0 {
+			<-client.Send
+		}
+	}
+}
+
+func TestFanoutDeliversOnce(t *testing.T) {
+	for _, workers := range []int{0, 1, 4, 64} {
+		lobby, clients := newLobby(t, 100, workers)
+		for i := range 3 {
+			lobby.Broadcast <- &BroadcastRequest{RoomID: LobbyID, Message: []byte(strconv.Itoa(i))}
+		}
+		settle(lobby)
+
+		for _, client := range clients {
+			if len(client.Send) != 3 {
+				t.Fatalf("%d workers: client %s got %d messages, want 3", workers, client.ID, len(client.Send))
+			}
+			// Partitions are delivered before the next broadcast starts
+			for i := range 3 {
+				if got := string(<-client.Send); got != strconv.Itoa(i) {
+					t.Fatalf("%d workers: client %s got message %s, want %d", workers, client.ID, got, i)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkBroadcast broadcasts to lobbies of several sizes, from the
+// room's goroutine alone and split among a pool of workers. The pool only
+// pays off with several CPUs; on one it adds the cost of partitioning.
+func BenchmarkBroadcast(b *testing.B) {
+	// Rooms log every join
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	workers := max(runtime.NumCPU()-1, 1)
+	message := []byte(`{"type":"message","username":"bench","content":"hello"}`)
+	for _, n := range []int{100, 1000, 10000} {
+		for _, mode := range []struct {
+			name    string
+			workers int
+		}{{"alone", 0}, {fmt.Sprintf("pool-%d", workers), workers}} {
+			b.Run(fmt.Sprintf("clients=%d/%s", n, mode.name), func(b *testing.B) {
+				lobby, clients := newLobby(b, n, mode.workers)
+				b.SetBytes(int64(len(message) * n))
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					lobby.Broadcast <- &BroadcastRequest{RoomID: LobbyID, Message: message}
+
+					if (i+1)%benchQueueSize == 0 {
+						b.StopTimer()
+						settle(lobby)
+						drain(clients)
+						b.StartTimer()
+					}
+				}
+				b.StopTimer()
+				settle(lobby)
+
+				for _, client := range clients {
+					if dropped := client.SendQueue.Dropped(); dropped > 0 {
+						b.Fatalf("client %s dropped %d messages", client.ID, dropped)
+					}
+				}
+			})
+		}
+	}
+}
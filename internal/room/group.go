@@ -0,0 +1,120 @@
+package room
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxGroupParticipants is the most users a group conversation can have
+const MaxGroupParticipants = 50
+
+// groupNameUsers is how many participants an automatic group name lists
+// before counting the rest
+const groupNameUsers = 3
+
+// Errors returned when changing a group conversation's participants
+var (
+	ErrNotGroup  = errors.New("this room isn't a group conversation")
+	ErrGroupFull = errors.New("a group conversation has at most 50 participants")
+)
+
+// GroupName returns the name a group conversation is given when created
+// without one, such as "alice, bob, carol and 2 others"
+func GroupName(participants []string) string {
+	names := append([]string(nil), participants...)
+	sort.Strings(names)
+	if len(names) > groupNameUsers {
+		return strings.Join(names[:groupNameUsers], ", ") + " and " + strconv.Itoa(len(names)-groupNameUsers) + " others"
+	}
+	if len(names) > 1 {
+		return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+	}
+	return strings.Join(names, "")
+}
+
+// CreateGroupAsync creates a group conversation in a workspace whose only
+// participant is its creator, and starts it in a goroutine
+func (m *Manager) CreateGroupAsync(workspace, name, createdBy string, encrypted bool) string {
+	roomID := generateRoomID()
+	room := NewRoom(m.ctx, roomID, name, createdBy, ModeGroup)
+	room.Encrypted = encrypted
+	room.Workspace = workspace
+	room.Participants[createdBy] = true
+	m.attach(room)
+
+	select {
+	case m.CreateRoom <- room:
+	case <-m.ctx.Done():
+	}
+	return roomID
+}
+
+// IsGroup reports whether the room is a group conversation
+func (r *Room) IsGroup() bool {
+	return r.Mode == ModeGroup
+}
+
+// Visible reports whether a user may see and join the room: any room but a
+// group conversation, which only its participants may
+func (r *Room) Visible(username string) bool {
+	if !r.IsGroup() {
+		return true
+	}
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Participants[username]
+}
+
+// GetParticipants returns the participants of a group conversation, sorted
+func (r *Room) GetParticipants() []string {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return sortedKeys(r.Participants)
+}
+
+// AddParticipants adds users to a group conversation and returns those who
+// weren't participants already
+func (r *Room) AddParticipants(usernames []string) ([]string, error) {
+	if !r.IsGroup() {
+		return nil, ErrNotGroup
+	}
+
+	r.Mutex.Lock()
+	var added []string
+	for _, username := range usernames {
+		if !r.Participants[username] {
+			added = append(added, username)
+		}
+	}
+	if len(r.Participants)+len(added) > MaxGroupParticipants {
+		r.Mutex.Unlock()
+		return nil, ErrGroupFull
+	}
+	for _, username := range added {
+		r.Participants[username] = true
+	}
+	r.Mutex.Unlock()
+
+	if len(added) > 0 {
+		r.changed()
+	}
+	return added, nil
+}
+
+// RemoveParticipant takes a user out of a group conversation and returns
+// how many participants are left
+func (r *Room) RemoveParticipant(username string) (int, error) {
+	if !r.IsGroup() {
+		return 0, ErrNotGroup
+	}
+
+	r.Mutex.Lock()
+	delete(r.Participants, username)
+	left := len(r.Participants)
+	r.Mutex.Unlock()
+
+	r.changed()
+	return left, nil
+}
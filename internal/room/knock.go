@@ -0,0 +1,148 @@
+package room
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// JoinRule decides who may join a room
+type JoinRule string
+
+const (
+	// JoinOpen lets anyone join
+	JoinOpen JoinRule = "open"
+
+	// JoinKnock lets only approved users join; others knock and wait for a
+	// moderator to approve or deny them
+	JoinKnock JoinRule = "knock"
+)
+
+// MaxKnocks is the most join requests a room holds waiting for approval
+const MaxKnocks = 200
+
+// MaxKnockReason is the longest message a join request can carry, in bytes
+const MaxKnockReason = 500
+
+// Errors returned when knocking on a room
+var (
+	ErrNotKnockRoom    = errors.New("this room can be joined without asking")
+	ErrAlreadyAdmitted = errors.New("you can already join this room")
+	ErrAlreadyKnocked  = errors.New("you have already asked to join this room")
+	ErrKnockQueueFull  = errors.New("this room has too many pending join requests")
+)
+
+// Knock is a request to join a room waiting for a moderator
+type Knock struct {
+	Username    string    `json:"username"`
+	Reason      string    `json:"reason,omitempty"`
+	RequestedAt time.Time `json:"requestedAt"`
+}
+
+// ValidJoinRule reports whether rule is a known join rule
+func ValidJoinRule(rule JoinRule) bool {
+	return rule == JoinOpen || rule == JoinKnock
+}
+
+// GetJoinRule returns who may join the room
+func (r *Room) GetJoinRule() JoinRule {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	if r.JoinRule == "" {
+		return JoinOpen
+	}
+	return r.JoinRule
+}
+
+// SetJoinRule changes who may join the room. Users in the room when it
+// starts asking to knock are approved, so they can come back.
+func (r *Room) SetJoinRule(rule JoinRule) {
+	r.Mutex.Lock()
+	r.JoinRule = rule
+	if rule == JoinKnock {
+		for client := range r.Clients {
+			r.Approved[client.Username] = true
+		}
+	} else {
+		// Nobody is left waiting on a room anyone can join
+		r.Knocks = make(map[string]Knock)
+	}
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// Admits reports whether a user may join the room without knocking
+func (r *Room) Admits(username string) bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.JoinRule != JoinKnock || (username == r.CreatedBy && username != SystemUser) || r.Approved[username]
+}
+
+// AddKnock queues a user's request to join the room
+func (r *Room) AddKnock(username, reason string) (Knock, error) {
+	r.Mutex.Lock()
+	if r.JoinRule != JoinKnock {
+		r.Mutex.Unlock()
+		return Knock{}, ErrNotKnockRoom
+	}
+	if (username == r.CreatedBy && username != SystemUser) || r.Approved[username] {
+		r.Mutex.Unlock()
+		return Knock{}, ErrAlreadyAdmitted
+	}
+	if _, pending := r.Knocks[username]; pending {
+		r.Mutex.Unlock()
+		return Knock{}, ErrAlreadyKnocked
+	}
+	if len(r.Knocks) >= MaxKnocks {
+		r.Mutex.Unlock()
+		return Knock{}, ErrKnockQueueFull
+	}
+	knock := Knock{Username: username, Reason: reason, RequestedAt: time.Now().UTC()}
+	r.Knocks[username] = knock
+	r.Mutex.Unlock()
+	r.changed()
+	return knock, nil
+}
+
+// PendingKnocks returns the room's pending join requests, oldest first
+func (r *Room) PendingKnocks() []Knock {
+	r.Mutex.RLock()
+	knocks := make([]Knock, 0, len(r.Knocks))
+	for _, knock := range r.Knocks {
+		knocks = append(knocks, knock)
+	}
+	r.Mutex.RUnlock()
+
+	sort.Slice(knocks, func(i, j int) bool {
+		return knocks[i].RequestedAt.Before(knocks[j].RequestedAt)
+	})
+	return knocks
+}
+
+// ResolveKnock approves or denies a user's pending join request, returning
+// false if they hadn't knocked. Approved users may join from then on.
+func (r *Room) ResolveKnock(username string, approve bool) bool {
+	r.Mutex.Lock()
+	if _, pending := r.Knocks[username]; !pending {
+		r.Mutex.Unlock()
+		return false
+	}
+	delete(r.Knocks, username)
+	if approve {
+		r.Approved[username] = true
+	}
+	r.Mutex.Unlock()
+	r.changed()
+	return true
+}
+
+// Unapprove takes back a user's approval to join a room that asks to knock
+func (r *Room) Unapprove(username string) {
+	r.Mutex.Lock()
+	approved := r.Approved[username]
+	delete(r.Approved, username)
+	r.Mutex.Unlock()
+	if approved {
+		r.changed()
+	}
+}
@@ -1,11 +1,42 @@
 package room
 
 import (
+	"context"
 	"log"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/presence"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/tracing"
+	"strings"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// LobbyID is the ID of the default room every client joins when it connects
+const LobbyID = "lobby"
+
+// SystemUser is the creator of the rooms the server makes itself, such as
+// lobbies. It owns none of them.
+const SystemUser = "system"
+
+// LobbyFor returns the ID of a workspace's lobby; the server's own rooms
+// use LobbyID
+func LobbyFor(workspace string) string {
+	if workspace == "" {
+		return LobbyID
+	}
+	return LobbyID + "-" + workspace
+}
+
+// IsLobby reports whether a room is the lobby of the server or a workspace
+func IsLobby(roomID string) bool {
+	return roomID == LobbyID || strings.HasPrefix(roomID, LobbyID+"-")
+}
+
 // Manager manages all chat rooms and their goroutines
 type Manager struct {
 	Rooms      map[string]*Room
@@ -15,13 +46,51 @@ type Manager struct {
 	JoinRoom   chan *JoinRequest
 	LeaveRoom  chan *LeaveRequest
 	Broadcast  chan *BroadcastRequest
+
+	// Overloaded reports whether the server is shedding load; may be nil
+	Overloaded func() bool
+
+	// Store persists room metadata; may be nil
+	Store store.Store
+
+	// OnMembership is called when a client joins or leaves any room; may be nil
+	OnMembership func(roomID, username string, joined bool)
+
+	// Presence returns a user's presence status for join and leave notices; may be nil
+	Presence func(username string) presence.Status
+
+	// Moderator reports whether a user moderates a room, for rooms only
+	// moderators may post in; may be nil
+	Moderator func(roomID, username string) bool
+
+	// Fanout delivers broadcasts to large rooms in parallel; may be nil
+	Fanout *Fanout
+
+	// Relay is called with every message broadcast to a room, and the user
+	// whose message it carries, so other nodes of a cluster can deliver it
+	// too; may be nil
+	Relay func(roomID, author string, message []byte)
+
+	// Blocked reports whether a user blocks another, whose messages are then
+	// not delivered to them; may be nil
+	Blocked func(blocker, username string) bool
+
+	// slugs serializes slug changes so two rooms can't take the same slug
+	slugs sync.Mutex
+
+	// ctx is the parent context of every room owned by the manager
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// stopped is closed once Run has returned
+	stopped chan struct{}
 }
 
 // JoinRequest represents a request to join a room
 type JoinRequest struct {
-	Client     interface{} // Will be *hub.Client
-	RoomID     string
-	Response   chan *JoinResponse
+	Client   interface{} // Will be *hub.Client
+	RoomID   string
+	Response chan *JoinResponse
 }
 
 // LeaveRequest represents a request to leave a room
@@ -35,7 +104,15 @@ type LeaveRequest struct {
 type BroadcastRequest struct {
 	RoomID  string
 	Message []byte
-	Sender  interface{} // Will be *hub.Client
+	Sender  interface{}     // Will be *hub.Client
+	Context context.Context // Trace of the message, if it is traced
+
+	// User whose message or action the frame carries; clients of users who
+	// block them don't receive it. May be empty.
+	Author string
+
+	// Span of the request's routing, ended once the room has it
+	route trace.Span
 }
 
 // JoinResponse represents the response to a join request
@@ -45,8 +122,10 @@ type JoinResponse struct {
 	Message string
 }
 
-// NewManager creates a new room manager
-func NewManager() *Manager {
+// NewManager creates a new room manager whose lifetime is bound to ctx
+func NewManager(ctx context.Context) *Manager {
+	ctx, cancel := context.WithCancel(ctx)
+
 	return &Manager{
 		Rooms:      make(map[string]*Room),
 		CreateRoom: make(chan *Room),
@@ -54,64 +133,109 @@ func NewManager() *Manager {
 		JoinRoom:   make(chan *JoinRequest),
 		LeaveRoom:  make(chan *LeaveRequest),
 		Broadcast:  make(chan *BroadcastRequest),
+		ctx:        ctx,
+		cancel:     cancel,
+		stopped:    make(chan struct{}),
 	}
 }
 
-// Run starts the room manager in a goroutine
+// Run starts the room manager in a goroutine.
+// It returns once the manager's context is cancelled and all rooms have stopped.
 func (m *Manager) Run() {
+	defer close(m.stopped)
+
 	log.Println("Room Manager started")
-	
+
 	for {
 		select {
+		case <-m.ctx.Done():
+			m.stopAllRooms()
+			log.Println("Room Manager stopped")
+			return
+
 		case room := <-m.CreateRoom:
 			m.Mutex.Lock()
+			if _, exists := m.Rooms[room.ID]; exists {
+				// A workspace lobby created twice at once
+				m.Mutex.Unlock()
+				continue
+			}
 			m.Rooms[room.ID] = room
 			m.Mutex.Unlock()
-			
+			m.persistRoom(room)
+
 			// Start the room in its own goroutine
 			go room.Run()
-			
+
 			log.Printf("Room '%s' (%s) created and started", room.Name, room.ID)
 
 		case roomID := <-m.DeleteRoom:
 			m.Mutex.Lock()
-			if room, exists := m.Rooms[roomID]; exists {
-				// Close all client connections in the room
-				for client := range room.Clients {
-					close(client.Send)
-				}
-				delete(m.Rooms, roomID)
+			room, exists := m.Rooms[roomID]
+			delete(m.Rooms, roomID)
+			m.Mutex.Unlock()
+
+			if exists {
+				// Stop the room's goroutine; client connections stay open
+				room.Stop()
+				m.forgetRoom(roomID)
 				log.Printf("Room '%s' (%s) deleted", room.Name, room.ID)
 			}
-			m.Mutex.Unlock()
 
 		case req := <-m.JoinRoom:
 			m.Mutex.RLock()
 			room, exists := m.Rooms[req.RoomID]
 			m.Mutex.RUnlock()
-			
-			if exists {
+
+			if exists && !room.Visible(clientUsername(req.Client)) {
+				// Group conversations are hidden from everyone but their participants
+				req.Response <- &JoinResponse{
+					Success: false,
+					Room:    nil,
+					Message: "Room not found",
+				}
+			} else if exists && room.IsBanned(clientUsername(req.Client)) {
+				req.Response <- &JoinResponse{
+					Success: false,
+					Room:    nil,
+					Message: "You are banned from this room",
+				}
+			} else if exists {
 				// Type assert to get the client
 				if client, ok := req.Client.(interface {
 					GetID() string
 					GetUsername() string
 					GetSendChannel() chan []byte
+					GetPriorityChannel() chan []byte
 				}); ok {
 					// Create a room client that uses the hub client's send channel
 					roomClient := &Client{
 						ID:       client.GetID(),
 						Username: client.GetUsername(),
 						Send:     client.GetSendChannel(), // Use the hub client's channel
+						Priority: client.GetPriorityChannel(),
 						Room:     room,
 					}
-					
+					if queues, ok := req.Client.(interface {
+						GetQueues() (send, priority *metrics.Queue)
+					}); ok {
+						roomClient.SendQueue, roomClient.PriorityQueue = queues.GetQueues()
+					}
+
 					// Register the client with the room
-					room.Register <- roomClient
-					
-					req.Response <- &JoinResponse{
-						Success: true,
-						Room:    room,
-						Message: "Successfully joined room",
+					select {
+					case room.Register <- roomClient:
+						req.Response <- &JoinResponse{
+							Success: true,
+							Room:    room,
+							Message: "Successfully joined room",
+						}
+					case <-room.Done():
+						req.Response <- &JoinResponse{
+							Success: false,
+							Room:    nil,
+							Message: "Room is closed",
+						}
 					}
 				} else {
 					req.Response <- &JoinResponse{
@@ -132,22 +256,31 @@ func (m *Manager) Run() {
 			m.Mutex.RLock()
 			room, exists := m.Rooms[req.RoomID]
 			m.Mutex.RUnlock()
-			
+
 			if exists {
 				// Type assert to get the client
 				if client, ok := req.Client.(interface {
 					GetID() string
 				}); ok {
-					// Find and remove the client from the room
-					room.Mutex.Lock()
+					// Find the client in the room
+					var found *Client
+					room.Mutex.RLock()
 					for roomClient := range room.Clients {
 						if roomClient.ID == client.GetID() {
-							room.Unregister <- roomClient
+							found = roomClient
 							break
 						}
 					}
-					room.Mutex.Unlock()
-					
+					room.Mutex.RUnlock()
+
+					// Unregister outside the lock, since the room's loop takes it
+					if found != nil {
+						select {
+						case room.Unregister <- found:
+						case <-room.Done():
+						}
+					}
+
 					req.Response <- true
 				} else {
 					req.Response <- false
@@ -160,23 +293,130 @@ func (m *Manager) Run() {
 			m.Mutex.RLock()
 			room, exists := m.Rooms[req.RoomID]
 			m.Mutex.RUnlock()
-			
+
 			if exists {
-				room.Broadcast <- req.Message
+				select {
+				case room.Broadcast <- req:
+				case <-room.Done():
+				}
+			}
+			if req.route != nil {
+				req.route.SetAttributes(attribute.Bool("chat.room_found", exists))
+				req.route.End()
 			}
 		}
 	}
 }
 
-// CreateRoom creates a new room and starts it in a goroutine
-func (m *Manager) CreateRoomAsync(name, createdBy string) string {
+// EnsureLobby creates the lobby of the server or a workspace unless it was
+// restored from storage. It must be called before Run.
+func (m *Manager) EnsureLobby(workspace string) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	id := LobbyFor(workspace)
+	if _, exists := m.Rooms[id]; exists {
+		return
+	}
+
+	lobby := NewRoom(m.ctx, id, "Lobby", SystemUser, ModeNormal)
+	lobby.Workspace = workspace
+	m.attach(lobby)
+	m.Rooms[id] = lobby
+	m.persistRoom(lobby)
+	go lobby.Run()
+
+	log.Printf("Room '%s' (%s) created and started", lobby.Name, lobby.ID)
+}
+
+// CreateLobbyAsync creates a workspace's lobby once the manager is running,
+// unless it already exists
+func (m *Manager) CreateLobbyAsync(workspace string) {
+	if _, exists := m.GetRoom(LobbyFor(workspace)); exists {
+		return
+	}
+
+	lobby := NewRoom(m.ctx, LobbyFor(workspace), "Lobby", SystemUser, ModeNormal)
+	lobby.Workspace = workspace
+	m.attach(lobby)
+
+	select {
+	case m.CreateRoom <- lobby:
+	case <-m.ctx.Done():
+	}
+}
+
+// attach wires a room's callbacks to the manager
+func (m *Manager) attach(room *Room) {
+	room.overloaded = m.Overloaded
+	room.onChange = m.persistRoom
+	room.onMembership = m.OnMembership
+	room.presence = m.Presence
+	room.blocked = m.Blocked
+	room.moderator = m.Moderator
+	room.fanout = m.Fanout
+}
+
+// clientUsername returns the username of a hub client, or "" if it has none
+func clientUsername(client interface{}) string {
+	if c, ok := client.(interface{ GetUsername() string }); ok {
+		return c.GetUsername()
+	}
+	return ""
+}
+
+// stopAllRooms stops every room owned by the manager
+func (m *Manager) stopAllRooms() {
+	m.Mutex.Lock()
+	rooms := make([]*Room, 0, len(m.Rooms))
+	for id, room := range m.Rooms {
+		rooms = append(rooms, room)
+		delete(m.Rooms, id)
+	}
+	m.Mutex.Unlock()
+
+	for _, room := range rooms {
+		room.Stop()
+	}
+}
+
+// Stop cancels the manager's context and waits for it and its rooms to stop
+func (m *Manager) Stop() {
+	m.cancel()
+	<-m.stopped
+}
+
+// Done returns a channel that is closed when the manager is stopped
+func (m *Manager) Done() <-chan struct{} {
+	return m.ctx.Done()
+}
+
+// CreateRoom creates a new room in a workspace and starts it in a
+// goroutine. An encrypted room's messages are end-to-end encrypted by its
+// clients.
+func (m *Manager) CreateRoomAsync(workspace, name, createdBy string, mode Mode, encrypted bool) string {
 	roomID := generateRoomID()
-	room := NewRoom(roomID, name, createdBy)
-	
-	m.CreateRoom <- room
+	room := NewRoom(m.ctx, roomID, name, createdBy, mode)
+	room.Encrypted = encrypted
+	room.Workspace = workspace
+	m.attach(room)
+
+	select {
+	case m.CreateRoom <- room:
+	case <-m.ctx.Done():
+	}
 	return roomID
 }
 
+// DeleteRoomAsync stops a room and removes it from the store. Its clients
+// stay connected, and its history is kept.
+func (m *Manager) DeleteRoomAsync(roomID string) {
+	select {
+	case m.DeleteRoom <- roomID:
+	case <-m.ctx.Done():
+	}
+}
+
 // GetRoom returns a room by ID
 func (m *Manager) GetRoom(roomID string) (*Room, bool) {
 	m.Mutex.RLock()
@@ -189,7 +429,7 @@ func (m *Manager) GetRoom(roomID string) (*Room, bool) {
 func (m *Manager) GetRooms() []*Room {
 	m.Mutex.RLock()
 	defer m.Mutex.RUnlock()
-	
+
 	rooms := make([]*Room, 0, len(m.Rooms))
 	for _, room := range m.Rooms {
 		rooms = append(rooms, room)
@@ -212,9 +452,17 @@ func (m *Manager) JoinRoomAsync(client interface{}, roomID string) *JoinResponse
 		RoomID:   roomID,
 		Response: response,
 	}
-	
-	m.JoinRoom <- req
-	return <-response
+
+	select {
+	case m.JoinRoom <- req:
+		return <-response
+	case <-m.ctx.Done():
+		return &JoinResponse{
+			Success: false,
+			Room:    nil,
+			Message: "Server is shutting down",
+		}
+	}
 }
 
 // LeaveRoomAsync removes a client from a room
@@ -225,25 +473,67 @@ func (m *Manager) LeaveRoomAsync(client interface{}, roomID string) bool {
 		RoomID:   roomID,
 		Response: response,
 	}
-	
-	m.LeaveRoom <- req
-	return <-response
+
+	select {
+	case m.LeaveRoom <- req:
+		return <-response
+	case <-m.ctx.Done():
+		return false
+	}
 }
 
-// BroadcastToRoom sends a message to a specific room
+// BroadcastToRoom sends a message to a specific room, on every node of a cluster
 func (m *Manager) BroadcastToRoom(roomID string, message []byte, sender interface{}) {
-	req := &BroadcastRequest{
+	m.BroadcastToRoomContext(context.Background(), roomID, message, sender)
+}
+
+// BroadcastToRoomContext is BroadcastToRoom for a message traced by ctx,
+// whose routing to the room and fan-out are traced under it
+func (m *Manager) BroadcastToRoomContext(ctx context.Context, roomID string, message []byte, sender interface{}) {
+	m.BroadcastFrom(ctx, roomID, "", message, sender)
+}
+
+// BroadcastFrom is BroadcastToRoomContext for a message or action of
+// author, which isn't delivered to users who block them
+func (m *Manager) BroadcastFrom(ctx context.Context, roomID, author string, message []byte, sender interface{}) {
+	ctx, span := tracing.ChildSpan(ctx, "room.route", trace.WithAttributes(attribute.String("chat.room_id", roomID)))
+
+	if m.Relay != nil {
+		m.Relay(roomID, author, message)
+	}
+	m.broadcast(&BroadcastRequest{
 		RoomID:  roomID,
 		Message: message,
 		Sender:  sender,
+		Context: ctx,
+		Author:  author,
+		route:   span,
+	})
+}
+
+// DeliverToRoom sends a message of author, who may be empty, to a room's
+// clients on this node only, for messages relayed from other nodes of a
+// cluster
+func (m *Manager) DeliverToRoom(roomID, author string, message []byte) {
+	m.broadcast(&BroadcastRequest{RoomID: roomID, Message: message, Author: author})
+}
+
+// broadcast hands a broadcast request to the manager's goroutine
+func (m *Manager) broadcast(req *BroadcastRequest) {
+	select {
+	case m.Broadcast <- req:
+	case <-m.ctx.Done():
+		if req.route != nil {
+			req.route.End()
+		}
 	}
-	
-	m.Broadcast <- req
 }
 
 // generateRoomID generates a unique room ID
 func generateRoomID() string {
-	return "room_" + time.Now().Format("20060102150405") + "_" + randomString(6)
+	return replay.ID("room", func() string {
+		return "room_" + time.Now().Format("20060102150405") + "_" + randomString(6)
+	})
 }
 
 // randomString generates a random string of specified length
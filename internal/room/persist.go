@@ -0,0 +1,184 @@
+package room
+
+import (
+	"log"
+	"realtime-chat/internal/store"
+	"sort"
+	"time"
+)
+
+// Record returns the room's persistable metadata
+func (r *Room) Record() *store.RoomRecord {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	rec := &store.RoomRecord{
+		ID:                r.ID,
+		Name:              r.Name,
+		CreatedBy:         r.CreatedBy,
+		CreatedAt:         r.CreatedAt,
+		Mode:              string(r.Mode),
+		Posters:           sortedKeys(r.Posters),
+		Topic:             r.Topic,
+		Description:       r.Description,
+		Bans:              sortedKeys(r.Bans),
+		Pins:              append([]string(nil), r.Pins...),
+		MessageTTL:        int64(r.MessageTTL / time.Second),
+		RetentionDays:     r.Settings.RetentionDays,
+		RetentionMessages: r.Settings.RetentionMessages,
+		SlowMode:          r.Settings.SlowMode,
+		MaxMembers:        r.Settings.MaxMembers,
+		WhoCanPost:        string(r.Settings.WhoCanPost),
+		ProfanityLevel:    string(r.Settings.ProfanityLevel),
+		Welcome:           r.Settings.Welcome,
+		Rules:             r.Settings.Rules,
+		Federation:        append([]string(nil), r.Settings.Federation...),
+		Live:              r.Settings.Live,
+		EmbedOrigins:      append([]string(nil), r.Settings.EmbedOrigins...),
+		Webhook:           r.Webhook,
+		WebhookSecret:     r.WebhookSecret,
+		IncomingToken:     r.IncomingToken,
+		VisitorEmail:      r.VisitorEmail,
+		Encrypted:         r.Encrypted,
+		Workspace:         r.Workspace,
+		Tags:              append([]string(nil), r.Tags...),
+		Featured:          r.Featured,
+		Slug:              r.Slug,
+		Archived:          r.Archived,
+		ArchivedBy:        r.ArchivedBy,
+		ImportedFrom:      r.ImportedFrom,
+		JoinRule:          string(r.JoinRule),
+		Approved:          sortedKeys(r.Approved),
+		Participants:      sortedKeys(r.Participants),
+	}
+	if !r.ConversationStart.IsZero() {
+		start := r.ConversationStart
+		rec.ConversationStart = &start
+	}
+	for _, knock := range r.Knocks {
+		rec.Knocks = append(rec.Knocks, store.KnockRecord{
+			Username:    knock.Username,
+			Reason:      knock.Reason,
+			RequestedAt: knock.RequestedAt,
+		})
+	}
+	// Expired mutes are dropped rather than saved
+	for username, until := range r.Mutes {
+		if r.mutedLocked(username) {
+			if rec.Mutes == nil {
+				rec.Mutes = make(map[string]time.Time)
+			}
+			rec.Mutes[username] = until
+		}
+	}
+	return rec
+}
+
+// RestoreRooms recreates and starts every room saved in the store.
+// It must be called before Run.
+func (m *Manager) RestoreRooms() error {
+	if m.Store == nil {
+		return nil
+	}
+
+	records, err := m.Store.LoadRooms()
+	if err != nil {
+		return err
+	}
+
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	for _, rec := range records {
+		room := NewRoom(m.ctx, rec.ID, rec.Name, rec.CreatedBy, Mode(rec.Mode))
+		room.CreatedAt = rec.CreatedAt
+		room.Topic = rec.Topic
+		room.Description = rec.Description
+		room.MessageTTL = time.Duration(rec.MessageTTL) * time.Second
+		room.Settings = Settings{
+			SlowMode:          rec.SlowMode,
+			RetentionDays:     rec.RetentionDays,
+			RetentionMessages: rec.RetentionMessages,
+			MaxMembers:        rec.MaxMembers,
+			WhoCanPost:        PostPolicy(rec.WhoCanPost),
+			ProfanityLevel:    ProfanityLevel(rec.ProfanityLevel),
+			Welcome:           rec.Welcome,
+			Rules:             rec.Rules,
+			Federation:        rec.Federation,
+			Live:              rec.Live,
+			EmbedOrigins:      rec.EmbedOrigins,
+		}
+		room.Webhook = rec.Webhook
+		room.WebhookSecret = rec.WebhookSecret
+		room.IncomingToken = rec.IncomingToken
+		room.VisitorEmail = rec.VisitorEmail
+		room.Encrypted = rec.Encrypted
+		room.Workspace = rec.Workspace
+		room.Tags = rec.Tags
+		room.Featured = rec.Featured
+		room.Slug = rec.Slug
+		room.Archived = rec.Archived
+		room.ArchivedBy = rec.ArchivedBy
+		room.ImportedFrom = rec.ImportedFrom
+		room.JoinRule = JoinRule(rec.JoinRule)
+		if rec.ConversationStart != nil {
+			room.ConversationStart = *rec.ConversationStart
+		}
+		for _, username := range rec.Posters {
+			room.Posters[username] = true
+		}
+		for _, username := range rec.Bans {
+			room.Bans[username] = true
+		}
+		for username, until := range rec.Mutes {
+			room.Mutes[username] = until
+		}
+		for _, username := range rec.Approved {
+			room.Approved[username] = true
+		}
+		for _, username := range rec.Participants {
+			room.Participants[username] = true
+		}
+		for _, knock := range rec.Knocks {
+			room.Knocks[knock.Username] = Knock{Username: knock.Username, Reason: knock.Reason, RequestedAt: knock.RequestedAt}
+		}
+		room.Pins = rec.Pins
+		m.attach(room)
+
+		m.Rooms[room.ID] = room
+		go room.Run()
+	}
+
+	log.Printf("Restored %d rooms from storage", len(records))
+	return nil
+}
+
+// persistRoom saves a room's metadata to the store
+func (m *Manager) persistRoom(room *Room) {
+	if m.Store == nil {
+		return
+	}
+	if err := m.Store.SaveRoom(room.Record()); err != nil {
+		log.Printf("Error saving room '%s' (%s): %v", room.Name, room.ID, err)
+	}
+}
+
+// forgetRoom removes a room's metadata from the store
+func (m *Manager) forgetRoom(roomID string) {
+	if m.Store == nil {
+		return
+	}
+	if err := m.Store.DeleteRoom(roomID); err != nil {
+		log.Printf("Error deleting room %s from storage: %v", roomID, err)
+	}
+}
+
+// sortedKeys returns the keys of a set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
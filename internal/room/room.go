@@ -1,22 +1,175 @@
 package room
 
 import (
+	"context"
+	"encoding/json"
 	"log"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/presence"
+	"realtime-chat/internal/tracing"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Mode controls who may post messages in a room
+type Mode string
+
+// MaxMessageTTL is the longest lifetime a disappearing message can have
+const MaxMessageTTL = 30 * 24 * time.Hour
+
+// MaxPins is the most messages a room can have pinned at once
+const MaxPins = 50
+
+const (
+	// ModeNormal lets every member post
+	ModeNormal Mode = "normal"
+
+	// ModeAnnouncement lets only the creator and designated posters post
+	ModeAnnouncement Mode = "announcement"
+
+	// ModeSupport is a customer-support conversation opened by a visitor,
+	// the room's creator, which ends when the visitor leaves
+	ModeSupport Mode = "support"
+
+	// ModeGroup is a private conversation among its participants, who alone
+	// may see and join it
+	ModeGroup Mode = "group"
 )
 
 // Room represents a chat room with its own clients and message broadcasting
 type Room struct {
-	ID          string
-	Name        string
-	Clients     map[*Client]bool
-	Broadcast   chan []byte
-	Register    chan *Client
-	Unregister  chan *Client
-	Mutex       sync.RWMutex
-	CreatedAt   time.Time
-	CreatedBy   string
+	ID         string
+	Name       string
+	Clients    map[*Client]bool // Guarded by Mutex; broadcasts use the snapshot instead
+	Broadcast  chan *BroadcastRequest
+	Register   chan *Client
+	Unregister chan *Client
+	Mutex      sync.RWMutex
+	CreatedAt  time.Time
+	CreatedBy  string
+	Mode       Mode
+
+	// Topic and description set by the room owner
+	Topic       string
+	Description string
+
+	// How long messages last before disappearing; 0 keeps them forever
+	MessageTTL time.Duration
+
+	// Slow mode, retention, member limit, who may post and profanity
+	// filtering, as tuned by the room's moderators and admins
+	Settings Settings
+
+	// When each user last posted, for slow mode
+	lastPost map[string]time.Time
+
+	// Usernames allowed to post in announcement mode besides the creator
+	Posters map[string]bool
+
+	// Usernames banned from the room
+	Bans map[string]bool
+
+	// Muted usernames and when their mute ends; a zero time lasts until unmuted
+	Mutes map[string]time.Time
+
+	// IDs of pinned messages, oldest pin first
+	Pins []string
+
+	// IDs of clients removed by a moderator that haven't been told yet
+	kicked map[string]bool
+
+	// URL notified of message creations, edits and deletions, and the secret
+	// used to sign its requests; empty when the room isn't bridged
+	Webhook       string
+	WebhookSecret string
+
+	// Secret in the URL other systems post messages to; empty when the room
+	// has no incoming webhook
+	IncomingToken string
+
+	// Address a support conversation's transcript is emailed to; may be empty
+	VisitorEmail string
+
+	// When the current support conversation started
+	ConversationStart time.Time
+
+	// Set when the room's clients encrypt its messages end to end, so the
+	// server only stores and relays their ciphertext; never unset
+	Encrypted bool
+
+	// Workspace the room belongs to; empty for the server's own rooms.
+	// Set when the room is created and never changed.
+	Workspace string
+
+	// Tags the room is found by in room discovery, lowercase and sorted
+	Tags []string
+
+	// Readable name the room can be joined and linked by, unique in its
+	// workspace; empty when it has none
+	Slug string
+
+	// Set by admins to show the room first in room discovery
+	Featured bool
+
+	// Set by room admins to make the room read-only and leave it out of the
+	// room list; its history is kept so it can be restored later
+	Archived   bool
+	ArchivedBy string
+
+	// Platform and channel ID the room was imported from; empty for rooms
+	// created here
+	ImportedFrom string
+
+	// Who may join the room; empty is JoinOpen. Rooms that ask to knock
+	// admit their creator, approved users and moderators, and hold the
+	// other users' join requests until a moderator answers them.
+	JoinRule JoinRule
+	Approved map[string]bool
+	Knocks   map[string]Knock
+
+	// Users of a group conversation, the only ones who may see and join it
+	Participants map[string]bool
+
+	// Reports whether a user moderates the room, for rooms only moderators
+	// may post in; may be nil
+	moderator func(roomID, username string) bool
+
+	// Reports whether non-essential notices should be skipped; may be nil
+	overloaded func() bool
+
+	// Called after the room's metadata changes so it can be persisted; may be nil
+	onChange func(*Room)
+
+	// Called when a client joins or leaves the room; may be nil
+	onMembership func(roomID, username string, joined bool)
+
+	// Reports whether a user blocks another, whose messages they don't
+	// receive; may be nil
+	blocked func(blocker, username string) bool
+
+	// Returns the presence status shown in join and leave notices; may be nil
+	presence func(username string) presence.Status
+
+	// Workers delivering broadcasts when the room is large; may be nil
+	fanout *Fanout
+
+	// ctx is cancelled when the room is stopped
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// stopped is closed once Run has returned
+	stopped chan struct{}
+
+	// Set while Run is running
+	running atomic.Bool
+
+	// Copy-on-write snapshot of Clients, replaced whenever a client joins or
+	// leaves, so broadcasts read it without holding Mutex
+	snapshot atomic.Pointer[[]*Client]
 }
 
 // Client represents a client in a specific room
@@ -24,81 +177,608 @@ type Client struct {
 	ID       string
 	Username string
 	Send     chan []byte
+	Priority chan []byte
 	Room     *Room
+
+	// High-water marks and drops of Send and Priority, shared with the hub client
+	SendQueue     *metrics.Queue
+	PriorityQueue *metrics.Queue
 }
 
-// NewRoom creates a new chat room
-func NewRoom(id, name, createdBy string) *Room {
+// Notify queues a frame on the client's priority channel without blocking,
+// dropping it when the channel is full
+func (c *Client) Notify(frame []byte) bool {
+	return c.PriorityQueue.Offer(c.Priority, frame)
+}
+
+// NewRoom creates a new chat room whose lifetime is bound to ctx
+func NewRoom(ctx context.Context, id, name, createdBy string, mode Mode) *Room {
+	ctx, cancel := context.WithCancel(ctx)
+
 	return &Room{
-		ID:         id,
-		Name:       name,
-		Clients:    make(map[*Client]bool),
-		Broadcast:  make(chan []byte),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
-		CreatedAt:  time.Now(),
-		CreatedBy:  createdBy,
+		ID:           id,
+		Name:         name,
+		Clients:      make(map[*Client]bool),
+		Broadcast:    make(chan *BroadcastRequest),
+		Register:     make(chan *Client),
+		Unregister:   make(chan *Client),
+		CreatedAt:    time.Now(),
+		CreatedBy:    createdBy,
+		Mode:         mode,
+		Posters:      make(map[string]bool),
+		Bans:         make(map[string]bool),
+		Mutes:        make(map[string]time.Time),
+		Approved:     make(map[string]bool),
+		Knocks:       make(map[string]Knock),
+		Participants: make(map[string]bool),
+		lastPost:     make(map[string]time.Time),
+		kicked:       make(map[string]bool),
+		ctx:          ctx,
+		cancel:       cancel,
+		stopped:      make(chan struct{}),
 	}
 }
 
-// Run starts the room's message broadcasting loop in a goroutine
+// Run starts the room's message broadcasting loop in a goroutine.
+// It returns once the room's context is cancelled.
 func (r *Room) Run() {
+	defer close(r.stopped)
+
+	r.running.Store(true)
+	running.Add(1)
+	defer func() {
+		running.Add(-1)
+		r.running.Store(false)
+	}()
+
 	log.Printf("Room '%s' (%s) started", r.Name, r.ID)
-	
+
 	for {
 		select {
+		case <-r.ctx.Done():
+			log.Printf("Room '%s' (%s) stopped", r.Name, r.ID)
+			return
+
 		case client := <-r.Register:
 			r.Mutex.Lock()
 			r.Clients[client] = true
+			r.resubscribe()
+			delete(r.kicked, client.ID)
 			r.Mutex.Unlock()
-			
-			log.Printf("Client %s (%s) joined room '%s'. Room clients: %d", 
+
+			log.Printf("Client %s (%s) joined room '%s'. Room clients: %d",
 				client.ID, client.Username, r.Name, len(r.Clients))
-			
+
+			if r.onMembership != nil {
+				r.onMembership(r.ID, client.Username, true)
+			}
+
 			// Send welcome message to the room
-			welcomeMsg := []byte(`{"type":"system","message":"` + client.Username + ` joined the room","timestamp":"` + getCurrentTime() + `"}`)
-			r.broadcastMessage(welcomeMsg, client)
+			r.broadcastNotice(r.membershipNotice(client.Username, "joined"), client)
 
 		case client := <-r.Unregister:
 			r.Mutex.Lock()
-			if _, ok := r.Clients[client]; ok {
-				delete(r.Clients, client)
-				close(client.Send)
+			// The client may have been renamed since it was looked up, which
+			// replaces it with a copy
+			member, wasMember := r.member(client.ID)
+			if wasMember {
+				client = member
 			}
+			// The send channel belongs to the hub client, so it is not closed here
+			delete(r.Clients, client)
+			r.resubscribe()
 			r.Mutex.Unlock()
-			
-			log.Printf("Client %s (%s) left room '%s'. Room clients: %d", 
+
+			log.Printf("Client %s (%s) left room '%s'. Room clients: %d",
 				client.ID, client.Username, r.Name, len(r.Clients))
-			
+
+			if wasMember && r.onMembership != nil {
+				r.onMembership(r.ID, client.Username, false)
+			}
+
 			// Send goodbye message to the room
-			goodbyeMsg := []byte(`{"type":"system","message":"` + client.Username + ` left the room","timestamp":"` + getCurrentTime() + `"}`)
-			r.broadcastMessage(goodbyeMsg, nil)
+			r.broadcastNotice(r.membershipNotice(client.Username, "left"), nil)
 
-		case message := <-r.Broadcast:
-			r.broadcastMessage(message, nil)
+		case req := <-r.Broadcast:
+			r.broadcastMessage(req.Context, req.Message, nil, req.Author)
 		}
 	}
 }
 
-// broadcastMessage sends a message to all clients in the room
-func (r *Room) broadcastMessage(message []byte, sender *Client) {
+// broadcastMessage sends a message to all clients in the room but those who
+// block its author. ctx carries the message's trace, if it is traced.
+func (r *Room) broadcastMessage(ctx context.Context, message []byte, sender *Client, author string) {
+	_, span := tracing.ChildSpan(ctx, "room.broadcast", trace.WithAttributes(
+		attribute.String("chat.room_id", r.ID),
+		attribute.Int("chat.message.size", len(message)),
+	))
+	defer span.End()
+
+	// The snapshot can't change while it is read, so no lock is held while
+	// writing to the clients' channels. A client that leaves meanwhile was
+	// still a member when the broadcast began; its channel is only closed
+	// after it has left.
+	subscribers := r.Subscribers()
+	recipients, dropped := 0, 0
+
+	if r.fanout.parallel(len(subscribers)) {
+		recipients, dropped = r.fanout.deliver(r, subscribers, message, sender, author)
+		span.SetAttributes(attribute.Bool("chat.parallel", true))
+	} else {
+		for _, client := range subscribers {
+			// Don't send the message back to the sender, or to users who
+			// block its author
+			if client == sender || r.hides(client, author) {
+				continue
+			}
+
+			if r.deliver(client, message) {
+				recipients++
+			} else {
+				dropped++
+			}
+		}
+	}
+	span.SetAttributes(attribute.Int("chat.recipients", recipients), attribute.Int("chat.dropped", dropped))
+}
+
+// deliver queues a message for a client without blocking. When the client's
+// send queue is full it misses the message; only its first drop is logged,
+// the rest are counted.
+func (r *Room) deliver(client *Client, message []byte) bool {
+	if client.SendQueue.Offer(client.Send, message) {
+		return true
+	}
+	if client.SendQueue.Dropped() == 1 {
+		log.Printf("Dropping messages for client %s in room '%s': send queue full", client.ID, r.Name)
+	}
+	return false
+}
+
+// hides reports whether a client blocks the author of a message
+func (r *Room) hides(client *Client, author string) bool {
+	return author != "" && r.blocked != nil && r.blocked(client.Username, author)
+}
+
+// Stop cancels the room's context and waits for Run to return
+func (r *Room) Stop() {
+	r.cancel()
+	<-r.stopped
+}
+
+// Done returns a channel that is closed when the room is stopped
+func (r *Room) Done() <-chan struct{} {
+	return r.ctx.Done()
+}
+
+// broadcastNotice sends a non-essential system notice, skipping it while the server is overloaded
+func (r *Room) broadcastNotice(message []byte, sender *Client) {
+	if r.overloaded != nil && r.overloaded() {
+		metrics.FramesShed.Add(1)
+		return
+	}
+	r.broadcastMessage(context.Background(), message, sender, "")
+}
+
+// CanPost reports whether the given user may send messages to the room
+func (r *Room) CanPost(username string) bool {
+	r.Mutex.RLock()
+	blocked := r.Bans[username] || r.mutedLocked(username)
+	policy := r.postPolicyLocked()
+	poster := r.Posters[username]
+	r.Mutex.RUnlock()
+
+	switch {
+	case blocked:
+		return false
+	case policy == PostEveryone || (username == r.CreatedBy && username != SystemUser):
+		return true
+	case policy == PostPosters:
+		return poster
+	default:
+		// Asked without the room's mutex, since roles look the room up
+		return r.moderator != nil && r.moderator(r.ID, username)
+	}
+}
+
+// PostPolicy returns who may post in the room
+func (r *Room) PostPolicy() PostPolicy {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.postPolicyLocked()
+}
+
+// AddPoster allows a user to post in an announcement room
+func (r *Room) AddPoster(username string) {
+	r.Mutex.Lock()
+	r.Posters[username] = true
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// RemovePoster revokes a user's permission to post in an announcement room
+func (r *Room) RemovePoster(username string) {
+	r.Mutex.Lock()
+	delete(r.Posters, username)
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// Ban prevents a user from joining or posting in the room
+func (r *Room) Ban(username string) {
+	r.Mutex.Lock()
+	r.Bans[username] = true
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// Unban lifts a user's ban from the room
+func (r *Room) Unban(username string) {
+	r.Mutex.Lock()
+	delete(r.Bans, username)
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// IsBanned reports whether a user is banned from the room
+func (r *Room) IsBanned(username string) bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Bans[username]
+}
+
+// IsEncrypted reports whether the room's messages are end-to-end encrypted
+func (r *Room) IsEncrypted() bool {
 	r.Mutex.RLock()
 	defer r.Mutex.RUnlock()
-	
+	return r.Encrypted
+}
+
+// Mute stops a user from posting in the room until the given time; a zero
+// time lasts until they are unmuted
+func (r *Room) Mute(username string, until time.Time) {
+	r.Mutex.Lock()
+	r.Mutes[username] = until
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// Unmute lets a muted user post again
+func (r *Room) Unmute(username string) {
+	r.Mutex.Lock()
+	delete(r.Mutes, username)
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// MutedUntil reports whether a user is muted and when the mute ends; the
+// time is zero for a mute that lasts until the user is unmuted
+func (r *Room) MutedUntil(username string) (time.Time, bool) {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Mutes[username], r.mutedLocked(username)
+}
+
+// mutedLocked reports whether a user's mute is in effect; the caller must hold the mutex
+func (r *Room) mutedLocked(username string) bool {
+	until, ok := r.Mutes[username]
+	return ok && (until.IsZero() || time.Now().Before(until))
+}
+
+// Pin adds a message to the room's pins. It returns false if the message is
+// already pinned or the room has MaxPins pins.
+func (r *Room) Pin(messageID string) bool {
+	r.Mutex.Lock()
+	for _, id := range r.Pins {
+		if id == messageID {
+			r.Mutex.Unlock()
+			return false
+		}
+	}
+	if len(r.Pins) >= MaxPins {
+		r.Mutex.Unlock()
+		return false
+	}
+	r.Pins = append(r.Pins, messageID)
+	r.Mutex.Unlock()
+	r.changed()
+	return true
+}
+
+// Unpin removes a message from the room's pins, returning false if it wasn't pinned
+func (r *Room) Unpin(messageID string) bool {
+	r.Mutex.Lock()
+	for i, id := range r.Pins {
+		if id == messageID {
+			r.Pins = append(r.Pins[:i:i], r.Pins[i+1:]...)
+			r.Mutex.Unlock()
+			r.changed()
+			return true
+		}
+	}
+	r.Mutex.Unlock()
+	return false
+}
+
+// GetPins returns the IDs of the room's pinned messages, oldest pin first
+func (r *Room) GetPins() []string {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return append([]string(nil), r.Pins...)
+}
+
+// Kick removes every connection of a user from the room and returns them.
+// Each client is remembered as kicked until TakeKick is called for it or it
+// joins again.
+func (r *Room) Kick(username string) []*Client {
+	r.Mutex.Lock()
+	var kicked []*Client
 	for client := range r.Clients {
-		// Don't send the message back to the sender
-		if sender != nil && client == sender {
-			continue
+		if client.Username == username {
+			r.kicked[client.ID] = true
+			kicked = append(kicked, client)
 		}
-		
+	}
+	r.Mutex.Unlock()
+
+	for _, client := range kicked {
 		select {
-		case client.Send <- message:
-		default:
-			// If client's send channel is full, close the connection
-			close(client.Send)
-			delete(r.Clients, client)
+		case r.Unregister <- client:
+		case <-r.Done():
+		}
+	}
+	return kicked
+}
+
+// TakeKick reports whether a client was kicked from the room since it last
+// joined, forgetting the kick
+func (r *Room) TakeKick(clientID string) bool {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	kicked := r.kicked[clientID]
+	delete(r.kicked, clientID)
+	return kicked
+}
+
+// FindClients returns every room client with the given username
+func (r *Room) FindClients(username string) []*Client {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	var clients []*Client
+	for client := range r.Clients {
+		if client.Username == username {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// Rename changes the username of the room client with a client ID,
+// replacing it with a renamed copy so goroutines holding the old one never
+// see it change. It reports whether the client is in the room.
+func (r *Room) Rename(clientID, username string) bool {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	client, ok := r.member(clientID)
+	if !ok {
+		return false
+	}
+	renamed := *client
+	renamed.Username = username
+	delete(r.Clients, client)
+	r.Clients[&renamed] = true
+	r.resubscribe()
+	return true
+}
+
+// member returns the room client with a client ID; the caller holds Mutex
+func (r *Room) member(clientID string) (*Client, bool) {
+	for client := range r.Clients {
+		if client.ID == clientID {
+			return client, true
 		}
 	}
+	return nil, false
+}
+
+// FindClient returns the room client with the given username, if present
+func (r *Room) FindClient(username string) (*Client, bool) {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	for client := range r.Clients {
+		if client.Username == username {
+			return client, true
+		}
+	}
+	return nil, false
+}
+
+// changed notifies the room's owner that its metadata was modified
+func (r *Room) changed() {
+	if r.onChange != nil {
+		r.onChange(r)
+	}
+}
+
+// SetTopic updates the room's topic and description
+func (r *Room) SetTopic(topic, description string) {
+	r.Mutex.Lock()
+	r.Topic = topic
+	r.Description = description
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// SetTags replaces the tags the room is found by, returning them
+// normalized, or ErrInvalidTags
+func (r *Room) SetTags(tags []string) ([]string, error) {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	r.Mutex.Lock()
+	r.Tags = tags
+	r.Mutex.Unlock()
+	r.changed()
+	return tags, nil
+}
+
+// GetTags returns the tags the room is found by
+func (r *Room) GetTags() []string {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return append([]string{}, r.Tags...)
+}
+
+// SetFeatured sets whether room discovery shows the room first
+func (r *Room) SetFeatured(featured bool) {
+	r.Mutex.Lock()
+	r.Featured = featured
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// IsFeatured reports whether room discovery shows the room first
+func (r *Room) IsFeatured() bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Featured
+}
+
+// SetArchived archives the room on behalf of username, or restores it when
+// archived is false
+func (r *Room) SetArchived(archived bool, username string) {
+	r.Mutex.Lock()
+	r.Archived = archived
+	r.ArchivedBy = ""
+	if archived {
+		r.ArchivedBy = username
+	}
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// IsArchived reports whether the room is archived and so read-only
+func (r *Room) IsArchived() bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Archived
+}
+
+// GetTopic returns the room's topic and description
+func (r *Room) GetTopic() (string, string) {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Topic, r.Description
+}
+
+// SetMessageTTL sets how long new messages last before disappearing; 0 disables it
+func (r *Room) SetMessageTTL(ttl time.Duration) {
+	r.Mutex.Lock()
+	r.MessageTTL = ttl
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// GetMessageTTL returns how long new messages last before disappearing
+func (r *Room) GetMessageTTL() time.Duration {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.MessageTTL
+}
+
+// SetRetention sets how many days and messages of history the room keeps;
+// 0 uses the server's setting
+func (r *Room) SetRetention(days, messages int) {
+	r.Mutex.Lock()
+	r.Settings.RetentionDays = days
+	r.Settings.RetentionMessages = messages
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// GetRetention returns the room's own retention policy
+func (r *Room) GetRetention() (days, messages int) {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Settings.RetentionDays, r.Settings.RetentionMessages
+}
+
+// SetWebhook sets the room's webhook and signing secret; an empty url removes it
+func (r *Room) SetWebhook(url, secret string) {
+	r.Mutex.Lock()
+	r.Webhook = url
+	r.WebhookSecret = secret
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// GetWebhook returns the room's webhook URL and signing secret
+func (r *Room) GetWebhook() (string, string) {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Webhook, r.WebhookSecret
+}
+
+// SetIncomingToken sets the secret of the room's incoming webhook; an empty token removes it
+func (r *Room) SetIncomingToken(token string) {
+	r.Mutex.Lock()
+	r.IncomingToken = token
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// GetIncomingToken returns the secret of the room's incoming webhook
+func (r *Room) GetIncomingToken() string {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.IncomingToken
+}
+
+// SetVisitorEmail sets the address a support conversation's transcript is emailed to
+func (r *Room) SetVisitorEmail(email string) {
+	r.Mutex.Lock()
+	r.VisitorEmail = email
+	r.Mutex.Unlock()
+	r.changed()
+}
+
+// EndConversation ends the current support conversation, starting a new one
+// at end, and returns when the ended conversation started and the visitor's email
+func (r *Room) EndConversation(end time.Time) (time.Time, string) {
+	r.Mutex.Lock()
+	start := r.ConversationStart
+	if start.IsZero() {
+		start = r.CreatedAt
+	}
+	r.ConversationStart = end
+	email := r.VisitorEmail
+	r.Mutex.Unlock()
+	r.changed()
+	return start, email
+}
+
+// Subscribers returns the clients in the room when it last changed. The
+// slice is shared and must not be modified.
+func (r *Room) Subscribers() []*Client {
+	if snapshot := r.snapshot.Load(); snapshot != nil {
+		return *snapshot
+	}
+	return nil
+}
+
+// resubscribe replaces the snapshot of the room's clients; the caller
+// holds Mutex for writing
+func (r *Room) resubscribe() {
+	subscribers := make([]*Client, 0, len(r.Clients))
+	for client := range r.Clients {
+		subscribers = append(subscribers, client)
+	}
+	r.snapshot.Store(&subscribers)
 }
 
 // GetClientCount returns the number of clients in the room
@@ -112,7 +792,7 @@ func (r *Room) GetClientCount() int {
 func (r *Room) GetClients() []string {
 	r.Mutex.RLock()
 	defer r.Mutex.RUnlock()
-	
+
 	clients := make([]string, 0, len(r.Clients))
 	for client := range r.Clients {
 		clients = append(clients, client.Username)
@@ -120,6 +800,23 @@ func (r *Room) GetClients() []string {
 	return clients
 }
 
+// membershipNotice returns the system notice that a user joined or left the
+// room, with their presence status so member lists can be kept current
+func (r *Room) membershipNotice(username, event string) []byte {
+	notice := map[string]interface{}{
+		"type":      "system",
+		"event":     event,
+		"username":  username,
+		"message":   username + " " + event + " the room",
+		"timestamp": getCurrentTime(),
+	}
+	if r.presence != nil {
+		notice["status"] = r.presence(username)
+	}
+	data, _ := json.Marshal(notice)
+	return data
+}
+
 // getCurrentTime returns the current timestamp
 func getCurrentTime() string {
 	return time.Now().Format(time.RFC3339)
@@ -0,0 +1,137 @@
+package room
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// testClient is the hub client a room manager joins to rooms
+type testClient struct {
+	id, username   string
+	send, priority chan []byte
+}
+
+func newTestClient(id, username string) *testClient {
+	return &testClient{id: id, username: username, send: make(chan []byte, 64), priority: make(chan []byte, 64)}
+}
+
+func (c *testClient) GetID() string                   { return c.id }
+func (c *testClient) GetUsername() string             { return c.username }
+func (c *testClient) GetSendChannel() chan []byte     { return c.send }
+func (c *testClient) GetPriorityChannel() chan []byte { return c.priority }
+
+// waitFor fails the test unless cond holds within a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// checkNoLeaks fails the test unless the goroutines started after baseline
+// have returned
+func checkNoLeaks(t *testing.T, baseline int) {
+	t.Helper()
+	waitFor(t, "goroutines to return", func() bool {
+		return runtime.NumGoroutine() <= baseline
+	})
+	if n := RunningRooms(); n != 0 {
+		t.Fatalf("%d room goroutines still running", n)
+	}
+}
+
+func TestRoomStopEndsRun(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	r := NewRoom(context.Background(), "room_1", "general", SystemUser, ModeNormal)
+	go r.Run()
+
+	client := &Client{ID: "c1", Username: "alice", Send: make(chan []byte, 8), Priority: make(chan []byte, 8)}
+	r.Register <- client
+	r.Unregister <- client
+
+	r.Stop()
+	select {
+	case <-r.Done():
+	default:
+		t.Fatal("Done isn't closed after Stop")
+	}
+	checkNoLeaks(t, baseline)
+}
+
+func TestCancelledContextStopsRoom(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewRoom(ctx, "room_1", "general", SystemUser, ModeNormal)
+	go r.Run()
+	waitFor(t, "the room to start", func() bool { return RunningRooms() == 1 })
+
+	cancel()
+	select {
+	case <-r.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("room still running after its context was cancelled")
+	}
+	checkNoLeaks(t, baseline)
+}
+
+func TestManagerStopStopsRooms(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	m := NewManager(context.Background())
+	go m.Run()
+
+	var ids []string
+	for range 10 {
+		ids = append(ids, m.CreateRoomAsync("", "room", SystemUser, ModeNormal, false))
+	}
+	waitFor(t, "the rooms to start", func() bool { return RunningRooms() == len(ids) })
+
+	client := newTestClient("c1", "alice")
+	if resp := m.JoinRoomAsync(client, ids[0]); !resp.Success {
+		t.Fatalf("join failed: %s", resp.Message)
+	}
+
+	m.Stop()
+	if n := m.GetRoomCount(); n != 0 {
+		t.Fatalf("%d rooms left after Stop", n)
+	}
+	checkNoLeaks(t, baseline)
+
+	// Calls after the manager stopped return instead of blocking
+	m.DeleteRoomAsync(ids[1])
+	if resp := m.JoinRoomAsync(client, ids[1]); resp.Success {
+		t.Fatal("joined a room of a stopped manager")
+	}
+}
+
+func TestDeleteRoomStopsIt(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	m := NewManager(context.Background())
+	go m.Run()
+	defer func() {
+		m.Stop()
+		checkNoLeaks(t, baseline)
+	}()
+
+	id := m.CreateRoomAsync("", "room", SystemUser, ModeNormal, false)
+	waitFor(t, "the room to start", func() bool { return RunningRooms() == 1 })
+	r, _ := m.GetRoom(id)
+
+	m.DeleteRoomAsync(id)
+	select {
+	case <-r.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("deleted room still running")
+	}
+	if _, exists := m.GetRoom(id); exists {
+		t.Fatal("deleted room still managed")
+	}
+	if n := RunningRooms(); n != 0 {
+		t.Fatalf("%d room goroutines still running", n)
+	}
+}
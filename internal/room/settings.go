@@ -0,0 +1,251 @@
+package room
+
+import (
+	"errors"
+	"net/url"
+	"realtime-chat/internal/config"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PostPolicy decides who may post in a room
+type PostPolicy string
+
+const (
+	// PostEveryone lets every member post
+	PostEveryone PostPolicy = "everyone"
+
+	// PostPosters lets only the creator and designated posters post, as in
+	// announcement rooms
+	PostPosters PostPolicy = "posters"
+
+	// PostModerators lets only the creator and the room's moderators post
+	PostModerators PostPolicy = "moderators"
+)
+
+// ProfanityLevel decides what happens to messages with profanity
+type ProfanityLevel string
+
+const (
+	// ProfanityOff leaves messages as they are
+	ProfanityOff ProfanityLevel = "off"
+
+	// ProfanityMask replaces profane words with asterisks
+	ProfanityMask ProfanityLevel = "mask"
+
+	// ProfanityBlock refuses messages with profane words
+	ProfanityBlock ProfanityLevel = "block"
+)
+
+// MaxSlowMode is the longest a room's slow mode can make users wait, in seconds
+const MaxSlowMode = 6 * 60 * 60
+
+// MaxWelcomeLength is the longest a room's welcome message or rules can be, in bytes
+const MaxWelcomeLength = 4000
+
+// MaxFederation is the most servers a room can be shared with
+const MaxFederation = 16
+
+// MaxEmbedOrigins is the most sites a room can be embedded in
+const MaxEmbedOrigins = 16
+
+// maxSlowModeUsers is how many users' last posts a room remembers before
+// forgetting those no longer held back by slow mode
+const maxSlowModeUsers = 1000
+
+// ErrInvalidSettings is returned for settings out of range or of unknown values
+var ErrInvalidSettings = errors.New("invalid room settings")
+
+// Settings are the options a room's moderators and admins tune
+type Settings struct {
+	// Seconds a user waits between messages; 0 turns slow mode off
+	SlowMode int `json:"slowMode"`
+
+	// Days and number of messages of history kept, overriding the server's
+	// retention policy; 0 uses the server's setting
+	RetentionDays     int `json:"retentionDays"`
+	RetentionMessages int `json:"retentionMessages"`
+
+	// Most clients in the room at once; 0 is unlimited
+	MaxMembers int `json:"maxMembers"`
+
+	// Who may post; empty is PostPosters in announcement rooms and
+	// PostEveryone in the others
+	WhoCanPost PostPolicy `json:"whoCanPost"`
+
+	// What happens to messages with profanity; empty is ProfanityOff
+	ProfanityLevel ProfanityLevel `json:"profanityLevel"`
+
+	// Welcome message and rules sent privately to each user who joins; empty
+	// sends none
+	Welcome string `json:"welcome"`
+	Rules   string `json:"rules"`
+
+	// Names of the federated servers whose users may join the room, sharing
+	// it with their room of the same slug; empty shares it with none
+	Federation []string `json:"federation"`
+
+	// Whether anyone may watch the room's messages, without logging in, at
+	// /rooms/{slug}/live
+	Live bool `json:"live"`
+
+	// Origins of the sites that may embed the room's chat widget, such as
+	// https://example.com; empty lets none
+	EmbedOrigins []string `json:"embedOrigins"`
+}
+
+// Validate reports whether the settings are in range and of known values
+func (s Settings) Validate() error {
+	if s.SlowMode < 0 || s.SlowMode > MaxSlowMode || s.RetentionDays < 0 || s.RetentionMessages < 0 || s.MaxMembers < 0 {
+		return ErrInvalidSettings
+	}
+	if len(s.Welcome) > MaxWelcomeLength || len(s.Rules) > MaxWelcomeLength {
+		return ErrInvalidSettings
+	}
+	switch s.WhoCanPost {
+	case "", PostEveryone, PostPosters, PostModerators:
+	default:
+		return ErrInvalidSettings
+	}
+	switch s.ProfanityLevel {
+	case "", ProfanityOff, ProfanityMask, ProfanityBlock:
+	default:
+		return ErrInvalidSettings
+	}
+	if len(s.Federation) > MaxFederation {
+		return ErrInvalidSettings
+	}
+	for _, server := range s.Federation {
+		if !config.ValidServerName(server) {
+			return ErrInvalidSettings
+		}
+	}
+	if len(s.EmbedOrigins) > MaxEmbedOrigins {
+		return ErrInvalidSettings
+	}
+	for _, origin := range s.EmbedOrigins {
+		if !ValidOrigin(origin) {
+			return ErrInvalidSettings
+		}
+	}
+	return nil
+}
+
+// ValidOrigin reports whether origin is a web origin as browsers send it:
+// an http or https scheme and a lowercase host, with an optional port and
+// nothing after
+func ValidOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	return origin == u.Scheme+"://"+u.Host && u.Host == strings.ToLower(u.Host)
+}
+
+// GetSettings returns the room's settings, with defaults filled in
+func (r *Room) GetSettings() Settings {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	s := r.Settings
+	s.WhoCanPost = r.postPolicyLocked()
+	if s.ProfanityLevel == "" {
+		s.ProfanityLevel = ProfanityOff
+	}
+	s.Federation = slices.Clone(s.Federation)
+	if s.Federation == nil {
+		s.Federation = []string{}
+	}
+	s.EmbedOrigins = slices.Clone(s.EmbedOrigins)
+	if s.EmbedOrigins == nil {
+		s.EmbedOrigins = []string{}
+	}
+	return s
+}
+
+// FederatedWith reports whether the room is shared with a federated server
+func (r *Room) FederatedWith(server string) bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return slices.Contains(r.Settings.Federation, server)
+}
+
+// IsLive reports whether the room can be watched without logging in
+func (r *Room) IsLive() bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Settings.Live
+}
+
+// Embeddable reports whether a site may embed the room's chat widget
+func (r *Room) Embeddable(origin string) bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return slices.Contains(r.Settings.EmbedOrigins, origin)
+}
+
+// EmbedOrigins returns the origins of the sites that may embed the room
+func (r *Room) EmbedOrigins() []string {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return slices.Clone(r.Settings.EmbedOrigins)
+}
+
+// SetSettings replaces the room's settings, or returns ErrInvalidSettings
+func (r *Room) SetSettings(s Settings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	r.Mutex.Lock()
+	r.Settings = s
+	r.Mutex.Unlock()
+	r.changed()
+	return nil
+}
+
+// postPolicyLocked returns who may post in the room; the caller holds Mutex
+func (r *Room) postPolicyLocked() PostPolicy {
+	if r.Settings.WhoCanPost != "" {
+		return r.Settings.WhoCanPost
+	}
+	if r.Mode == ModeAnnouncement {
+		return PostPosters
+	}
+	return PostEveryone
+}
+
+// Full reports whether the room has as many clients as it allows
+func (r *Room) Full() bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Settings.MaxMembers > 0 && len(r.Clients) >= r.Settings.MaxMembers
+}
+
+// SlowModeWait returns how long a user must wait before posting again in a
+// room with slow mode on. When it is 0 the user may post, and the post is
+// counted as their last.
+func (r *Room) SlowModeWait(username string) time.Duration {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	interval := time.Duration(r.Settings.SlowMode) * time.Second
+	if interval == 0 {
+		return 0
+	}
+	now := time.Now()
+	if wait := r.lastPost[username].Add(interval).Sub(now); wait > 0 {
+		return wait
+	}
+	r.lastPost[username] = now
+
+	// Forget posts old enough not to hold anyone back
+	if len(r.lastPost) > maxSlowModeUsers {
+		for name, last := range r.lastPost {
+			if now.Sub(last) >= interval {
+				delete(r.lastPost, name)
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package room
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Errors returned when setting a room's slug
+var (
+	ErrInvalidSlug = errors.New("a slug is 2 to 40 lowercase letters, digits or '-', starting with a letter or digit")
+	ErrSlugTaken   = errors.New("another room already has this slug")
+)
+
+// slugPattern matches a valid slug. Slugs can't contain '_', so they never
+// look like a generated room ID.
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,39}$`)
+
+// ValidSlug reports whether slug can name a room. Lobby IDs are reserved.
+func ValidSlug(slug string) bool {
+	return slugPattern.MatchString(slug) && !IsLobby(slug)
+}
+
+// GetSlug returns the room's slug, or "" when it has none
+func (r *Room) GetSlug() string {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Slug
+}
+
+// SetSlug gives a room a slug unique in its workspace, or removes its slug
+// when slug is empty
+func (m *Manager) SetSlug(r *Room, slug string) error {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if slug != "" && !ValidSlug(slug) {
+		return ErrInvalidSlug
+	}
+
+	m.slugs.Lock()
+	defer m.slugs.Unlock()
+
+	if other, exists := m.FindSlug(r.Workspace, slug); exists && other != r {
+		return ErrSlugTaken
+	}
+	r.Mutex.Lock()
+	r.Slug = slug
+	r.Mutex.Unlock()
+	r.changed()
+	return nil
+}
+
+// FindSlug returns the room of a workspace with a slug
+func (m *Manager) FindSlug(workspace, slug string) (*Room, bool) {
+	if slug == "" {
+		return nil, false
+	}
+	for _, r := range m.GetRooms() {
+		if r.Workspace == workspace && r.GetSlug() == slug {
+			return r, true
+		}
+	}
+	return nil, false
+}
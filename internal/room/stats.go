@@ -0,0 +1,92 @@
+package room
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// running counts the room goroutines that haven't returned, across managers
+var running atomic.Int64
+
+// RunningRooms returns the number of room goroutines still running. More
+// than the rooms managed means rooms were dropped without being stopped.
+func RunningRooms() int {
+	return int(running.Load())
+}
+
+// Depth is how full a channel is
+type Depth struct {
+	Queued   int `json:"queued"`
+	Capacity int `json:"capacity"`
+}
+
+// depthOf returns how full ch is
+func depthOf[T any](ch chan T) Depth {
+	return Depth{Queued: len(ch), Capacity: cap(ch)}
+}
+
+// Stats describes a room's goroutine, channels and clients, for diagnosing
+// a live server
+type Stats struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Clients int    `json:"clients"`
+
+	// Whether Run is running, and whether the room was told to stop
+	Running bool `json:"running"`
+	Stopped bool `json:"stopped"`
+
+	Broadcast  Depth `json:"broadcast"`
+	Register   Depth `json:"register"`
+	Unregister Depth `json:"unregister"`
+
+	// Frames waiting in the clients' send buffers, in all and for the fullest one
+	Queued    int `json:"queued"`
+	MaxQueued int `json:"maxQueued"`
+}
+
+// Stats returns the room's current stats
+func (r *Room) Stats() Stats {
+	stats := Stats{
+		ID:         r.ID,
+		Name:       r.Name,
+		Running:    r.running.Load(),
+		Stopped:    r.ctx.Err() != nil,
+		Broadcast:  depthOf(r.Broadcast),
+		Register:   depthOf(r.Register),
+		Unregister: depthOf(r.Unregister),
+	}
+
+	subscribers := r.Subscribers()
+	stats.Clients = len(subscribers)
+	for _, client := range subscribers {
+		queued := len(client.Send)
+		stats.Queued += queued
+		stats.MaxQueued = max(stats.MaxQueued, queued)
+	}
+	return stats
+}
+
+// ChannelDepths returns how full the manager's request channels are
+func (m *Manager) ChannelDepths() map[string]Depth {
+	return map[string]Depth{
+		"createRoom": depthOf(m.CreateRoom),
+		"deleteRoom": depthOf(m.DeleteRoom),
+		"joinRoom":   depthOf(m.JoinRoom),
+		"leaveRoom":  depthOf(m.LeaveRoom),
+		"broadcast":  depthOf(m.Broadcast),
+	}
+}
+
+// Stats returns the stats of every managed room, ordered by ID
+func (m *Manager) Stats() []Stats {
+	rooms := m.GetRooms()
+	stats := make([]Stats, 0, len(rooms))
+	for _, r := range rooms {
+		stats = append(stats, r.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ID < stats[j].ID
+	})
+	return stats
+}
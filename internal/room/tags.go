@@ -0,0 +1,40 @@
+package room
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxTags is the most tags a room can have
+const MaxTags = 5
+
+// ErrInvalidTags is returned for tags that aren't 1 to 24 letters, digits
+// or '-', or more than MaxTags of them
+var ErrInvalidTags = errors.New("a room has at most 5 tags of 1 to 24 letters, digits or '-'")
+
+// tagPattern matches a valid tag once lowercased
+var tagPattern = regexp.MustCompile(`^[\p{Ll}\p{Lo}0-9-]{1,24}$`)
+
+// NormalizeTags lowercases tags, trims a leading '#', and returns them
+// sorted without duplicates, or ErrInvalidTags
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if !tagPattern.MatchString(tag) {
+			return nil, ErrInvalidTags
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > MaxTags {
+		return nil, ErrInvalidTags
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
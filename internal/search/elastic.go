@@ -0,0 +1,392 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/metrics"
+	"time"
+)
+
+// batchSize is the most changes written to the index in one bulk request
+const batchSize = 200
+
+// client is shared by every request to the cluster
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// mappings are the index's field types: content is analyzed for full-text
+// search and the rest is matched exactly
+var mappings = map[string]interface{}{
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"roomId":    map[string]string{"type": "keyword"},
+			"messageId": map[string]string{"type": "keyword"},
+			"seq":       map[string]string{"type": "long"},
+			"username":  map[string]string{"type": "keyword"},
+			"content":   map[string]string{"type": "text"},
+			"timestamp": map[string]string{"type": "date"},
+		},
+	},
+}
+
+// document is a message as it is indexed, under its message ID
+type document struct {
+	RoomID    string    `json:"roomId"`
+	MessageID string    `json:"messageId"`
+	Seq       int64     `json:"seq"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// operation is a change to the index: a message indexed, or removed when
+// doc is nil
+type operation struct {
+	id  string
+	doc *document
+}
+
+// Elastic indexes messages in an Elasticsearch or OpenSearch cluster and
+// searches them there. Changes are written in the background in batches,
+// and dropped when the queue is full so a slow or unreachable cluster can't
+// stall chat traffic.
+type Elastic struct {
+	url      string
+	index    string
+	username string
+	password string
+	apiKey   string
+
+	queue chan operation
+	ctx   context.Context
+	done  chan struct{}
+}
+
+// NewElastic starts indexing in the cluster in cfg until ctx is cancelled,
+// or returns nil when no cluster is configured
+func NewElastic(ctx context.Context, cfg config.SearchConfig) *Elastic {
+	if cfg.URL == "" {
+		return nil
+	}
+	e := &Elastic{
+		url:      cfg.URL,
+		index:    cfg.Index,
+		username: cfg.Username,
+		password: cfg.Password,
+		apiKey:   cfg.APIKey,
+		queue:    make(chan operation, cfg.QueueSize),
+		ctx:      ctx,
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Index queues a message to be indexed, or removed from the index when it
+// can no longer be found
+func (e *Elastic) Index(msg *history.Message) {
+	if !Searchable(msg) {
+		e.Delete(msg.ID)
+		return
+	}
+	e.send(operation{id: msg.ID, doc: documentOf(msg)})
+}
+
+// Delete queues a message's removal from the index
+func (e *Elastic) Delete(messageID string) {
+	e.send(operation{id: messageID})
+}
+
+// Replace indexes a room's messages in place of those indexed before, as
+// after its history was rewritten. It returns how many were indexed.
+func (e *Elastic) Replace(ctx context.Context, roomID string, messages []*history.Message) (int, error) {
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"term": map[string]string{"roomId": roomID}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	res, err := e.do(ctx, http.MethodPost, "/"+e.index+"/_delete_by_query?conflicts=proceed&refresh=true", "application/json", query)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	indexed := 0
+	batch := make([]operation, 0, batchSize)
+	for i, msg := range messages {
+		if Searchable(msg) {
+			batch = append(batch, operation{id: msg.ID, doc: documentOf(msg)})
+		}
+		if len(batch) == batchSize || (i == len(messages)-1 && len(batch) > 0) {
+			if err := e.bulk(ctx, batch); err != nil {
+				return indexed, err
+			}
+			indexed += len(batch)
+			batch = batch[:0]
+		}
+	}
+	return indexed, nil
+}
+
+// Search returns the messages matching q from the index
+func (e *Elastic) Search(ctx context.Context, q Query) (*Results, error) {
+	results := &Results{Hits: []Hit{}}
+	if len(q.RoomIDs) == 0 {
+		return results, nil
+	}
+
+	filter := []interface{}{
+		map[string]interface{}{"terms": map[string]interface{}{"roomId": q.RoomIDs}},
+	}
+	if q.Author != "" {
+		filter = append(filter, map[string]interface{}{"term": map[string]string{"username": q.Author}})
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		bounds := make(map[string]string)
+		if !q.Since.IsZero() {
+			bounds["gte"] = q.Since.UTC().Format(time.RFC3339Nano)
+		}
+		if !q.Until.IsZero() {
+			bounds["lt"] = q.Until.UTC().Format(time.RFC3339Nano)
+		}
+		filter = append(filter, map[string]interface{}{"range": map[string]interface{}{"timestamp": bounds}})
+	}
+	boolQuery := map[string]interface{}{"filter": filter}
+	if q.Text != "" {
+		boolQuery["must"] = map[string]interface{}{
+			"match": map[string]interface{}{"content": map[string]string{"query": q.Text, "operator": "and"}},
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"query":            map[string]interface{}{"bool": boolQuery},
+		"sort":             []interface{}{map[string]string{"timestamp": "desc"}},
+		"from":             q.Offset,
+		"size":             q.Limit,
+		"track_total_hits": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := e.do(ctx, http.MethodPost, "/"+e.index+"/_search", "application/json", body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var response struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Source document `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIndex, err)
+	}
+
+	results.Total = response.Hits.Total.Value
+	for _, hit := range response.Hits.Hits {
+		doc := hit.Source
+		results.Hits = append(results.Hits, Hit{
+			ID:        doc.MessageID,
+			RoomID:    doc.RoomID,
+			Seq:       doc.Seq,
+			Username:  doc.Username,
+			Content:   doc.Content,
+			Timestamp: doc.Timestamp,
+		})
+	}
+	return results, nil
+}
+
+// Close waits for the changes queued before the indexer's context was
+// cancelled to be written
+func (e *Elastic) Close() {
+	<-e.done
+}
+
+// send queues a change without blocking
+func (e *Elastic) send(op operation) {
+	select {
+	case e.queue <- op:
+	default:
+		metrics.IndexDropped.Add(1)
+	}
+}
+
+// run creates the index if needed, then writes queued changes in batches
+// until the indexer's context is cancelled, and then writes what is left
+func (e *Elastic) run() {
+	defer close(e.done)
+
+	if err := e.createIndex(e.ctx); err != nil {
+		log.Printf("Error creating search index %s: %v", e.index, err)
+	}
+
+	batch := make([]operation, 0, batchSize)
+	for {
+		select {
+		case op := <-e.queue:
+			batch = e.fill(append(batch[:0], op))
+			e.write(e.ctx, batch)
+
+		case <-e.ctx.Done():
+			// Give the last changes a moment to reach the cluster
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			for batch = e.fill(batch[:0]); len(batch) > 0; batch = e.fill(batch[:0]) {
+				e.write(ctx, batch)
+			}
+			cancel()
+			return
+		}
+	}
+}
+
+// fill adds queued changes to batch until it is full or the queue is empty
+func (e *Elastic) fill(batch []operation) []operation {
+	for len(batch) < batchSize {
+		select {
+		case op := <-e.queue:
+			batch = append(batch, op)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// write sends a batch of changes to the index. Failures are logged and
+// counted, since the chat has moved on.
+func (e *Elastic) write(ctx context.Context, batch []operation) {
+	if err := e.bulk(ctx, batch); err != nil {
+		log.Printf("Error indexing %d messages: %v", len(batch), err)
+	}
+}
+
+// bulk writes changes to the index in one request, counting those indexed
+// and rejected
+func (e *Elastic) bulk(ctx context.Context, batch []operation) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, op := range batch {
+		action := "index"
+		if op.doc == nil {
+			action = "delete"
+		}
+		if err := encoder.Encode(map[string]interface{}{action: map[string]string{"_id": op.id}}); err != nil {
+			return err
+		}
+		if op.doc != nil {
+			if err := encoder.Encode(op.doc); err != nil {
+				return err
+			}
+		}
+	}
+
+	res, err := e.do(ctx, http.MethodPost, "/"+e.index+"/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		metrics.IndexFailures.Add(int64(len(batch)))
+		return err
+	}
+	defer res.Body.Close()
+	var response struct {
+		Items []map[string]struct {
+			Status int `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		metrics.IndexFailures.Add(int64(len(batch)))
+		return fmt.Errorf("%w: %v", ErrIndex, err)
+	}
+
+	// Removing a message that was never indexed isn't a failure
+	failed := 0
+	for _, item := range response.Items {
+		for action, result := range item {
+			if result.Status >= 300 && !(action == "delete" && result.Status == http.StatusNotFound) {
+				failed++
+			}
+		}
+	}
+	metrics.Indexed.Add(int64(len(batch) - failed))
+	metrics.IndexFailures.Add(int64(failed))
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d changes rejected", ErrIndex, failed, len(batch))
+	}
+	return nil
+}
+
+// createIndex creates the index with its mappings unless it exists
+func (e *Elastic) createIndex(ctx context.Context) error {
+	res, err := e.do(ctx, http.MethodHead, "/"+e.index, "", nil)
+	if err == nil {
+		res.Body.Close()
+		return nil
+	}
+
+	body, err := json.Marshal(mappings)
+	if err != nil {
+		return err
+	}
+	res, err = e.do(ctx, http.MethodPut, "/"+e.index, "application/json", body)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	log.Printf("Created search index %s", e.index)
+	return nil
+}
+
+// do sends a request to the cluster, returning ErrIndex unless it succeeds
+func (e *Elastic) do(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, e.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	} else if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		// The error names the URL, which may carry credentials
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrIndex, err)
+	}
+	if res.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		res.Body.Close()
+		return nil, fmt.Errorf("%w: %s %s: %s %s", ErrIndex, method, path, res.Status, bytes.TrimSpace(detail))
+	}
+	return res, nil
+}
+
+// documentOf returns a message as it is indexed
+func documentOf(msg *history.Message) *document {
+	return &document{
+		RoomID:    msg.RoomID,
+		MessageID: msg.ID,
+		Seq:       msg.Seq,
+		Username:  msg.Username,
+		Content:   msg.Content,
+		Timestamp: msg.Timestamp,
+	}
+}
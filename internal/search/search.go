@@ -0,0 +1,139 @@
+// Package search finds messages in rooms' history. By default it scans the
+// history each server keeps in memory; large deployments can index every
+// message in Elasticsearch or OpenSearch instead, which the search API then
+// asks without its callers noticing.
+package search
+
+import (
+	"context"
+	"errors"
+	"realtime-chat/internal/history"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sizes of a page of results
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// ErrIndex is returned when the search index can't be reached or refuses a query
+var ErrIndex = errors.New("search index unavailable")
+
+// Query selects the messages to find, newest first
+type Query struct {
+	Text    string    // Words the content must all contain; any content when empty
+	RoomIDs []string  // Rooms to search; none matches nothing
+	Author  string    // Only messages posted by this user, when set
+	Since   time.Time // Only messages posted at or after this time, when set
+	Until   time.Time // Only messages posted before this time, when set
+	Limit   int
+	Offset  int
+}
+
+// Hit is a message found by a search
+type Hit struct {
+	ID        string    `json:"id"`
+	RoomID    string    `json:"roomId"`
+	Seq       int64     `json:"seq"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Results is a page of the messages a query found
+type Results struct {
+	Hits  []Hit `json:"results"`
+	Total int   `json:"total"` // Messages found in all
+}
+
+// Searcher finds messages
+type Searcher interface {
+	Search(ctx context.Context, q Query) (*Results, error)
+}
+
+// Scan searches by reading every message of the rooms asked for from the
+// history in memory. Words match anywhere in the content, regardless of case.
+type Scan struct {
+	history *history.History
+}
+
+// NewScan returns a searcher over h
+func NewScan(h *history.History) *Scan {
+	return &Scan{history: h}
+}
+
+// Search returns the messages matching q. Deleted, encrypted and GIF
+// messages are never found.
+func (s *Scan) Search(ctx context.Context, q Query) (*Results, error) {
+	words := strings.Fields(strings.ToLower(q.Text))
+	var hits []Hit
+	for _, roomID := range q.RoomIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		messages, err := s.history.Messages(roomID)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			if !Searchable(msg) || !matches(q, words, msg) {
+				continue
+			}
+			hits = append(hits, HitOf(msg))
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].Timestamp.After(hits[j].Timestamp)
+	})
+	results := &Results{Hits: []Hit{}, Total: len(hits)}
+	if q.Offset < len(hits) {
+		hits = hits[q.Offset:]
+		if len(hits) > q.Limit {
+			hits = hits[:q.Limit]
+		}
+		results.Hits = hits
+	}
+	return results, nil
+}
+
+// Searchable reports whether a message can be found: deleted messages are
+// gone, and the server can't read encrypted content or search GIFs
+func Searchable(msg *history.Message) bool {
+	return !msg.Deleted && msg.Encryption == nil && msg.GIF == nil
+}
+
+// HitOf returns a message as a search result
+func HitOf(msg *history.Message) Hit {
+	return Hit{
+		ID:        msg.ID,
+		RoomID:    msg.RoomID,
+		Seq:       msg.Seq,
+		Username:  msg.Username,
+		Content:   msg.Content,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+// matches reports whether a message passes q's filters and contains every word
+func matches(q Query, words []string, msg *history.Message) bool {
+	if q.Author != "" && msg.Username != q.Author {
+		return false
+	}
+	if !q.Since.IsZero() && msg.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !msg.Timestamp.Before(q.Until) {
+		return false
+	}
+	content := strings.ToLower(msg.Content)
+	for _, word := range words {
+		if !strings.Contains(content, word) {
+			return false
+		}
+	}
+	return true
+}
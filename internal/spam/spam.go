@@ -0,0 +1,190 @@
+package spam
+
+import (
+	"fmt"
+	"realtime-chat/internal/config"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Kinds of spam the detector recognises
+const (
+	KindRepeat = "repeat"
+	KindCaps   = "caps"
+	KindLinks  = "links"
+	KindHop    = "room_hopping"
+)
+
+// maxRecent caps the messages remembered per user for repeat detection
+const maxRecent = 50
+
+// Violation describes why a message or join was taken as spam
+type Violation struct {
+	Kind   string `json:"kind"`
+	Reason string `json:"reason"`
+}
+
+// sent is a message remembered for repeat detection
+type sent struct {
+	content string // normalised
+	at      time.Time
+}
+
+// activity is what the detector remembers about one user
+type activity struct {
+	recent []sent
+	joins  []time.Time
+}
+
+// Detector spots spam from each user's recent messages and room joins
+type Detector struct {
+	cfg config.SpamConfig
+
+	mutex sync.Mutex
+	users map[string]*activity
+}
+
+// New creates a detector with the thresholds in cfg
+func New(cfg config.SpamConfig) *Detector {
+	return &Detector{cfg: cfg, users: make(map[string]*activity)}
+}
+
+// Action returns how detected spam should be handled: config.SpamFlag or config.SpamMute
+func (d *Detector) Action() string {
+	return d.cfg.Action
+}
+
+// MuteDuration returns how long spammers are muted for
+func (d *Detector) MuteDuration() time.Duration {
+	return d.cfg.MuteDuration
+}
+
+// CheckMessage records a chat message from username and returns the first
+// rule it breaks, or nil
+func (d *Detector) CheckMessage(username, content string) *Violation {
+	now := time.Now()
+	normalised := strings.Join(strings.Fields(strings.ToLower(content)), " ")
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	a := d.activity(username)
+	a.recent = append(trimSent(a.recent, now.Add(-d.cfg.RepeatWindow)), sent{content: normalised, at: now})
+	if len(a.recent) > maxRecent {
+		a.recent = a.recent[len(a.recent)-maxRecent:]
+	}
+
+	if d.cfg.RepeatLimit > 0 && normalised != "" {
+		repeats := 0
+		for _, s := range a.recent {
+			if s.content == normalised {
+				repeats++
+			}
+		}
+		if repeats >= d.cfg.RepeatLimit {
+			return &Violation{Kind: KindRepeat, Reason: fmt.Sprintf("Sent the same message %d times within %s", repeats, d.cfg.RepeatWindow)}
+		}
+	}
+
+	if d.cfg.CapsPercent > 0 {
+		letters, upper := 0, 0
+		for _, r := range content {
+			if unicode.IsLetter(r) {
+				letters++
+				if unicode.IsUpper(r) {
+					upper++
+				}
+			}
+		}
+		if letters >= d.cfg.CapsMinLetters && letters > 0 && upper*100 >= letters*d.cfg.CapsPercent {
+			return &Violation{Kind: KindCaps, Reason: fmt.Sprintf("%d%% of the message's letters are capitals", upper*100/letters)}
+		}
+	}
+
+	if d.cfg.MaxLinks > 0 {
+		if links := countLinks(content); links >= d.cfg.MaxLinks {
+			return &Violation{Kind: KindLinks, Reason: fmt.Sprintf("The message has %d links", links)}
+		}
+	}
+	return nil
+}
+
+// CheckJoin records username joining a room and returns a violation when
+// they are hopping between rooms too quickly, or nil
+func (d *Detector) CheckJoin(username string) *Violation {
+	if d.cfg.HopLimit <= 0 {
+		return nil
+	}
+	now := time.Now()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	a := d.activity(username)
+	a.joins = append(trimTimes(a.joins, now.Add(-d.cfg.HopWindow)), now)
+	if len(a.joins) >= d.cfg.HopLimit {
+		return &Violation{Kind: KindHop, Reason: fmt.Sprintf("Joined %d rooms within %s", len(a.joins), d.cfg.HopWindow)}
+	}
+	return nil
+}
+
+// Prune forgets activity older than the detection windows and returns how
+// many users were forgotten entirely
+func (d *Detector) Prune() int {
+	now := time.Now()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	removed := 0
+	for username, a := range d.users {
+		a.recent = trimSent(a.recent, now.Add(-d.cfg.RepeatWindow))
+		a.joins = trimTimes(a.joins, now.Add(-d.cfg.HopWindow))
+		if len(a.recent) == 0 && len(a.joins) == 0 {
+			delete(d.users, username)
+			removed++
+		}
+	}
+	return removed
+}
+
+// activity returns a user's activity, creating it if needed. The caller must hold the mutex.
+func (d *Detector) activity(username string) *activity {
+	a, ok := d.users[username]
+	if !ok {
+		a = &activity{}
+		d.users[username] = a
+	}
+	return a
+}
+
+// trimSent drops messages sent before cutoff
+func trimSent(recent []sent, cutoff time.Time) []sent {
+	i := 0
+	for i < len(recent) && recent[i].at.Before(cutoff) {
+		i++
+	}
+	return recent[i:]
+}
+
+// trimTimes drops times before cutoff
+func trimTimes(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// countLinks counts the URLs in a message
+func countLinks(content string) int {
+	links := 0
+	for _, word := range strings.Fields(strings.ToLower(content)) {
+		if strings.HasPrefix(word, "http://") || strings.HasPrefix(word, "https://") || strings.HasPrefix(word, "www.") {
+			links++
+		}
+	}
+	return links
+}
@@ -0,0 +1,1050 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of a BoltStore. History, read markers, offline queues and avatars
+// hold a nested bucket per room, recipient or user; history, queues and the audit log
+// are keyed by sequence number so they read back in the order they were written.
+var (
+	bucketRooms       = []byte("rooms")
+	bucketPending     = []byte("pending")
+	bucketHistory     = []byte("history")
+	bucketReads       = []byte("reads")
+	bucketPolls       = []byte("polls")
+	bucketEmoji       = []byte("emoji")
+	bucketEmojiImages = []byte("emoji_images")
+	bucketProfiles    = []byte("profiles")
+	bucketAvatars     = []byte("avatars")
+	bucketAccounts    = []byte("accounts")
+	bucketSessions    = []byte("sessions")
+	bucketRoles       = []byte("roles")
+	bucketReports     = []byte("reports")
+	bucketAudit       = []byte("audit")
+	bucketDeviceKeys  = []byte("device_keys")
+	bucketWorkspaces  = []byte("workspaces")
+	bucketGameScores  = []byte("game_scores")
+	bucketStats       = []byte("stats")
+	bucketUserStats   = []byte("user_stats")
+	bucketCold        = []byte("cold_segments")
+)
+
+// boltBuckets lists every top-level bucket, created when the store is opened
+var boltBuckets = [][]byte{
+	bucketRooms, bucketPending, bucketHistory, bucketReads, bucketPolls,
+	bucketEmoji, bucketEmojiImages, bucketProfiles, bucketAvatars,
+	bucketAccounts, bucketSessions, bucketRoles, bucketReports, bucketAudit,
+	bucketDeviceKeys, bucketWorkspaces, bucketGameScores, bucketStats,
+	bucketUserStats, bucketCold,
+}
+
+// BoltStore is a Store that keeps everything in a single embedded bbolt
+// database file. Every change is committed in its own transaction and
+// synced to disk before it returns.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (creating if needed) a bbolt database at path. Only
+// one process can have the database open at a time.
+func NewBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create data directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create buckets: %w", err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the database, waiting for open transactions to finish
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// SaveRoom creates or replaces a room's metadata
+func (s *BoltStore) SaveRoom(room *RoomRecord) error {
+	return s.put(bucketRooms, room.ID, room)
+}
+
+// DeleteRoom removes a room's metadata
+func (s *BoltStore) DeleteRoom(id string) error {
+	return s.delete(bucketRooms, id)
+}
+
+// LoadRooms returns the metadata of every persisted room, oldest first
+func (s *BoltStore) LoadRooms() ([]*RoomRecord, error) {
+	rooms := make([]*RoomRecord, 0)
+	err := s.each(bucketRooms, func(data []byte) error {
+		var room RoomRecord
+		if err := json.Unmarshal(data, &room); err != nil {
+			return err
+		}
+		rooms = append(rooms, &room)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load rooms: %w", err)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].CreatedAt.Before(rooms[j].CreatedAt)
+	})
+	return rooms, nil
+}
+
+// QueueMessage stores a message for an offline user
+func (s *BoltStore) QueueMessage(msg *PendingMessage, limit int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		queue, err := tx.Bucket(bucketPending).CreateBucketIfNotExists([]byte(msg.To))
+		if err != nil {
+			return err
+		}
+		if queue.Stats().KeyN >= limit {
+			return ErrQueueFull
+		}
+		return appendJSON(queue, msg)
+	})
+}
+
+// TakeMessages removes and returns every message waiting for a user, oldest first
+func (s *BoltStore) TakeMessages(username string) ([]*PendingMessage, error) {
+	var messages []*PendingMessage
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(bucketPending)
+		queue := pending.Bucket([]byte(username))
+		if queue == nil {
+			return nil
+		}
+		err := queue.ForEach(func(_, data []byte) error {
+			var msg PendingMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return err
+			}
+			messages = append(messages, &msg)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return pending.DeleteBucket([]byte(username))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("take queued messages: %w", err)
+	}
+	return messages, nil
+}
+
+// PendingMessages returns every queued message by recipient, oldest first, without removing them
+func (s *BoltStore) PendingMessages() (map[string][]*PendingMessage, error) {
+	pending := make(map[string][]*PendingMessage)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return eachNested(tx.Bucket(bucketPending), func(username string, queue *bolt.Bucket) error {
+			return queue.ForEach(func(_, data []byte) error {
+				var msg PendingMessage
+				if err := json.Unmarshal(data, &msg); err != nil {
+					return err
+				}
+				pending[username] = append(pending[username], &msg)
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load queued messages: %w", err)
+	}
+	return pending, nil
+}
+
+// ExpireMessages discards queued messages sent before the cutoff
+func (s *BoltStore) ExpireMessages(before time.Time) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(bucketPending)
+		var empty [][]byte
+		err := eachNested(pending, func(username string, queue *bolt.Bucket) error {
+			var expired [][]byte
+			kept := 0
+			err := queue.ForEach(func(key, data []byte) error {
+				var msg PendingMessage
+				if err := json.Unmarshal(data, &msg); err != nil {
+					return err
+				}
+				if msg.Timestamp.Before(before) {
+					expired = append(expired, append([]byte(nil), key...))
+				} else {
+					kept++
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, key := range expired {
+				if err := queue.Delete(key); err != nil {
+					return err
+				}
+			}
+			removed += len(expired)
+			if kept == 0 {
+				empty = append(empty, []byte(username))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, username := range empty {
+			if err := pending.DeleteBucket(username); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("expire queued messages: %w", err)
+	}
+	return removed, nil
+}
+
+// AppendEvent adds an event to the end of a room's history
+func (s *BoltStore) AppendEvent(event *MessageEvent) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		room, err := tx.Bucket(bucketHistory).CreateBucketIfNotExists([]byte(event.RoomID))
+		if err != nil {
+			return err
+		}
+		return appendJSON(room, event)
+	})
+	if err != nil {
+		return fmt.Errorf("append history: %w", err)
+	}
+	return nil
+}
+
+// AppendEvents adds events to the end of their rooms' histories in one transaction
+func (s *BoltStore) AppendEvents(events []*MessageEvent) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		history := tx.Bucket(bucketHistory)
+		for _, event := range events {
+			room, err := history.CreateBucketIfNotExists([]byte(event.RoomID))
+			if err != nil {
+				return err
+			}
+			if err := appendJSON(room, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("append history: %w", err)
+	}
+	return nil
+}
+
+// LoadEvents returns a room's history in the order it was appended
+func (s *BoltStore) LoadEvents(roomID string) ([]*MessageEvent, error) {
+	var events []*MessageEvent
+	err := s.db.View(func(tx *bolt.Tx) error {
+		room := tx.Bucket(bucketHistory).Bucket([]byte(roomID))
+		if room == nil {
+			return nil
+		}
+		return room.ForEach(func(_, data []byte) error {
+			var event MessageEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return err
+			}
+			events = append(events, &event)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load history: %w", err)
+	}
+	return events, nil
+}
+
+// ReplaceEvents atomically overwrites a room's history
+func (s *BoltStore) ReplaceEvents(roomID string, events []*MessageEvent) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		history := tx.Bucket(bucketHistory)
+		if err := history.DeleteBucket([]byte(roomID)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		room, err := history.CreateBucket([]byte(roomID))
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := appendJSON(room, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("replace history: %w", err)
+	}
+	return nil
+}
+
+// HistoryRooms returns the IDs of every room with a stored history, sorted
+func (s *BoltStore) HistoryRooms() ([]string, error) {
+	return s.nestedNames(bucketHistory)
+}
+
+// DeleteEvents removes a room's history and returns the size of its encoded events
+func (s *BoltStore) DeleteEvents(roomID string) (int64, error) {
+	var size int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		history := tx.Bucket(bucketHistory)
+		room := history.Bucket([]byte(roomID))
+		if room == nil {
+			return nil
+		}
+		err := room.ForEach(func(_, data []byte) error {
+			size += int64(len(data)) + 1
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return history.DeleteBucket([]byte(roomID))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("delete history: %w", err)
+	}
+	return size, nil
+}
+
+// SaveReadMarker records when a user last read a room
+func (s *BoltStore) SaveReadMarker(roomID, username string, at time.Time) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		room, err := tx.Bucket(bucketReads).CreateBucketIfNotExists([]byte(roomID))
+		if err != nil {
+			return err
+		}
+		data, err := at.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return room.Put([]byte(username), data)
+	})
+	if err != nil {
+		return fmt.Errorf("save read marker: %w", err)
+	}
+	return nil
+}
+
+// LoadReadMarkers returns when each user last read a room
+func (s *BoltStore) LoadReadMarkers(roomID string) (map[string]time.Time, error) {
+	markers := make(map[string]time.Time)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		room := tx.Bucket(bucketReads).Bucket([]byte(roomID))
+		if room == nil {
+			return nil
+		}
+		return room.ForEach(func(username, data []byte) error {
+			var at time.Time
+			if err := at.UnmarshalBinary(data); err != nil {
+				return err
+			}
+			markers[string(username)] = at
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load read markers: %w", err)
+	}
+	return markers, nil
+}
+
+// ReadMarkerRooms returns the IDs of every room with read markers, sorted
+func (s *BoltStore) ReadMarkerRooms() ([]string, error) {
+	return s.nestedNames(bucketReads)
+}
+
+// DeleteReadMarkers removes every read marker of a room
+func (s *BoltStore) DeleteReadMarkers(roomID string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(bucketReads).DeleteBucket([]byte(roomID))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("delete read markers: %w", err)
+	}
+	return nil
+}
+
+// SavePoll creates or replaces a poll
+func (s *BoltStore) SavePoll(poll *PollRecord) error {
+	return s.put(bucketPolls, poll.ID, poll)
+}
+
+// LoadPolls returns every persisted poll, oldest first
+func (s *BoltStore) LoadPolls() ([]*PollRecord, error) {
+	polls := make([]*PollRecord, 0)
+	err := s.each(bucketPolls, func(data []byte) error {
+		var poll PollRecord
+		if err := json.Unmarshal(data, &poll); err != nil {
+			return err
+		}
+		polls = append(polls, &poll)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load polls: %w", err)
+	}
+	sort.Slice(polls, func(i, j int) bool {
+		return polls[i].CreatedAt.Before(polls[j].CreatedAt)
+	})
+	return polls, nil
+}
+
+// SaveEmoji records a custom emoji and its image in one transaction
+func (s *BoltStore) SaveEmoji(emoji *EmojiRecord, image []byte) error {
+	data, err := json.Marshal(emoji)
+	if err != nil {
+		return fmt.Errorf("encode emoji: %w", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketEmojiImages).Put([]byte(emoji.Shortcode), image); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketEmoji).Put([]byte(emoji.Shortcode), data)
+	})
+	if err != nil {
+		return fmt.Errorf("save emoji: %w", err)
+	}
+	return nil
+}
+
+// DeleteEmoji removes a custom emoji and its image
+func (s *BoltStore) DeleteEmoji(shortcode string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketEmoji).Delete([]byte(shortcode)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketEmojiImages).Delete([]byte(shortcode))
+	})
+	if err != nil {
+		return fmt.Errorf("delete emoji: %w", err)
+	}
+	return nil
+}
+
+// LoadEmoji returns every custom emoji, sorted by shortcode
+func (s *BoltStore) LoadEmoji() ([]*EmojiRecord, error) {
+	emoji := make([]*EmojiRecord, 0)
+	err := s.each(bucketEmoji, func(data []byte) error {
+		var e EmojiRecord
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		emoji = append(emoji, &e)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load emoji: %w", err)
+	}
+	return emoji, nil
+}
+
+// LoadEmojiImage returns a custom emoji's image
+func (s *BoltStore) LoadEmojiImage(shortcode string) ([]byte, error) {
+	image, err := s.get(bucketEmojiImages, shortcode)
+	if err != nil {
+		return nil, fmt.Errorf("read emoji: %w", err)
+	}
+	return image, nil
+}
+
+// SaveProfile creates or replaces a user's profile
+func (s *BoltStore) SaveProfile(profile *ProfileRecord) error {
+	return s.put(bucketProfiles, profile.Username, profile)
+}
+
+// LoadProfiles returns every user profile, sorted by username
+func (s *BoltStore) LoadProfiles() ([]*ProfileRecord, error) {
+	profiles := make([]*ProfileRecord, 0)
+	err := s.each(bucketProfiles, func(data []byte) error {
+		var p ProfileRecord
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		profiles = append(profiles, &p)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load profiles: %w", err)
+	}
+	return profiles, nil
+}
+
+// SaveAvatar replaces a user's avatar images, keyed by size, in one
+// transaction
+func (s *BoltStore) SaveAvatar(username string, images map[int][]byte) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		avatars := tx.Bucket(bucketAvatars)
+		if err := avatars.DeleteBucket([]byte(username)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		avatar, err := avatars.CreateBucket([]byte(username))
+		if err != nil {
+			return err
+		}
+		for size, image := range images {
+			if err := avatar.Put(sizeKey(size), image); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("write avatar: %w", err)
+	}
+	return nil
+}
+
+// LoadAvatar returns a user's avatar image of a size
+func (s *BoltStore) LoadAvatar(username string, size int) ([]byte, error) {
+	var image []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if avatar := tx.Bucket(bucketAvatars).Bucket([]byte(username)); avatar != nil {
+			// Values are only valid during the transaction
+			if data := avatar.Get(sizeKey(size)); data != nil {
+				image = append([]byte(nil), data...)
+			}
+		}
+		return nil
+	})
+	if err == nil && image == nil {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read avatar: %w", err)
+	}
+	return image, nil
+}
+
+// DeleteProfile removes a user's profile and avatar images
+func (s *BoltStore) DeleteProfile(username string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketAvatars).DeleteBucket([]byte(username)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		return tx.Bucket(bucketProfiles).Delete([]byte(username))
+	})
+	if err != nil {
+		return fmt.Errorf("delete profile: %w", err)
+	}
+	return nil
+}
+
+// SaveAccount creates or replaces a user account
+func (s *BoltStore) SaveAccount(account *AccountRecord) error {
+	return s.put(bucketAccounts, account.Username, account)
+}
+
+// LoadAccounts returns every user account, sorted by username
+func (s *BoltStore) LoadAccounts() ([]*AccountRecord, error) {
+	accounts := make([]*AccountRecord, 0)
+	err := s.each(bucketAccounts, func(data []byte) error {
+		var a AccountRecord
+		if err := json.Unmarshal(data, &a); err != nil {
+			return err
+		}
+		accounts = append(accounts, &a)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load accounts: %w", err)
+	}
+	return accounts, nil
+}
+
+// DeleteAccount removes a user account
+func (s *BoltStore) DeleteAccount(username string) error {
+	return s.delete(bucketAccounts, username)
+}
+
+// SaveSession creates or replaces a login session
+func (s *BoltStore) SaveSession(session *SessionRecord) error {
+	return s.put(bucketSessions, session.TokenHash, session)
+}
+
+// DeleteSession removes a login session
+func (s *BoltStore) DeleteSession(tokenHash string) error {
+	return s.delete(bucketSessions, tokenHash)
+}
+
+// LoadSessions returns every login session
+func (s *BoltStore) LoadSessions() ([]*SessionRecord, error) {
+	sessions := make([]*SessionRecord, 0)
+	err := s.each(bucketSessions, func(data []byte) error {
+		var session SessionRecord
+		if err := json.Unmarshal(data, &session); err != nil {
+			return err
+		}
+		sessions = append(sessions, &session)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// SaveRole creates or replaces a role assignment; an empty role removes it
+func (s *BoltStore) SaveRole(role *RoleRecord) error {
+	if role.Role == "" {
+		return s.delete(bucketRoles, role.Key())
+	}
+	return s.put(bucketRoles, role.Key(), role)
+}
+
+// LoadRoles returns every role assignment
+func (s *BoltStore) LoadRoles() ([]*RoleRecord, error) {
+	roles := make([]*RoleRecord, 0)
+	err := s.each(bucketRoles, func(data []byte) error {
+		var role RoleRecord
+		if err := json.Unmarshal(data, &role); err != nil {
+			return err
+		}
+		roles = append(roles, &role)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load roles: %w", err)
+	}
+	return roles, nil
+}
+
+// SaveDeviceKeys creates or replaces a device's key bundle
+func (s *BoltStore) SaveDeviceKeys(keys *DeviceKeysRecord) error {
+	return s.put(bucketDeviceKeys, keys.Key(), keys)
+}
+
+// DeleteDeviceKeys removes a device's key bundle
+func (s *BoltStore) DeleteDeviceKeys(username, deviceID string) error {
+	return s.delete(bucketDeviceKeys, (&DeviceKeysRecord{Username: username, DeviceID: deviceID}).Key())
+}
+
+// LoadDeviceKeys returns the key bundle of every device
+func (s *BoltStore) LoadDeviceKeys() ([]*DeviceKeysRecord, error) {
+	devices := make([]*DeviceKeysRecord, 0)
+	err := s.each(bucketDeviceKeys, func(data []byte) error {
+		var keys DeviceKeysRecord
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return err
+		}
+		devices = append(devices, &keys)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load device keys: %w", err)
+	}
+	return devices, nil
+}
+
+// SaveWorkspace creates or replaces a workspace
+func (s *BoltStore) SaveWorkspace(workspace *WorkspaceRecord) error {
+	return s.put(bucketWorkspaces, workspace.ID, workspace)
+}
+
+// DeleteWorkspace removes a workspace
+func (s *BoltStore) DeleteWorkspace(id string) error {
+	return s.delete(bucketWorkspaces, id)
+}
+
+// LoadWorkspaces returns every workspace
+func (s *BoltStore) LoadWorkspaces() ([]*WorkspaceRecord, error) {
+	workspaces := make([]*WorkspaceRecord, 0)
+	err := s.each(bucketWorkspaces, func(data []byte) error {
+		var workspace WorkspaceRecord
+		if err := json.Unmarshal(data, &workspace); err != nil {
+			return err
+		}
+		workspaces = append(workspaces, &workspace)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load workspaces: %w", err)
+	}
+	return workspaces, nil
+}
+
+// SaveReport creates or replaces a message report
+func (s *BoltStore) SaveReport(report *ReportRecord) error {
+	return s.put(bucketReports, report.ID, report)
+}
+
+// LoadReports returns every message report
+func (s *BoltStore) LoadReports() ([]*ReportRecord, error) {
+	reports := make([]*ReportRecord, 0)
+	err := s.each(bucketReports, func(data []byte) error {
+		var report ReportRecord
+		if err := json.Unmarshal(data, &report); err != nil {
+			return err
+		}
+		reports = append(reports, &report)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load reports: %w", err)
+	}
+	return reports, nil
+}
+
+// AppendAudit adds an entry to the end of the audit log
+func (s *BoltStore) AppendAudit(entry *AuditRecord) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return appendJSON(tx.Bucket(bucketAudit), entry)
+	})
+	if err != nil {
+		return fmt.Errorf("append audit log: %w", err)
+	}
+	return nil
+}
+
+// LoadAudit returns the audit log in the order it was appended
+func (s *BoltStore) LoadAudit() ([]*AuditRecord, error) {
+	var entries []*AuditRecord
+	err := s.each(bucketAudit, func(data []byte) error {
+		var entry AuditRecord
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, &entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// SaveGameScore creates or replaces a user's score in a game
+func (s *BoltStore) SaveGameScore(score *GameScoreRecord) error {
+	return s.put(bucketGameScores, score.Key(), score)
+}
+
+// LoadGameScores returns every user's score in every game
+func (s *BoltStore) LoadGameScores() ([]*GameScoreRecord, error) {
+	scores := make([]*GameScoreRecord, 0)
+	err := s.each(bucketGameScores, func(data []byte) error {
+		var score GameScoreRecord
+		if err := json.Unmarshal(data, &score); err != nil {
+			return err
+		}
+		scores = append(scores, &score)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load game scores: %w", err)
+	}
+	return scores, nil
+}
+
+// SaveStats creates or replaces hours of activity, in one transaction
+func (s *BoltStore) SaveStats(records []*StatsRecord) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketStats)
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(record.Key()), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("save stats: %w", err)
+	}
+	return nil
+}
+
+// LoadStats returns every stored hour of activity
+func (s *BoltStore) LoadStats() ([]*StatsRecord, error) {
+	records := make([]*StatsRecord, 0)
+	err := s.each(bucketStats, func(data []byte) error {
+		var record StatsRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		records = append(records, &record)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load stats: %w", err)
+	}
+	return records, nil
+}
+
+// DeleteStats discards the hours of activity that started before the cutoff
+func (s *BoltStore) DeleteStats(before time.Time) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketStats)
+		var expired [][]byte
+		err := bucket.ForEach(func(key, data []byte) error {
+			var record StatsRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			if record.Hour.Before(before) {
+				expired = append(expired, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("delete stats: %w", err)
+	}
+	return removed, nil
+}
+
+// SaveUserStats creates or replaces users' activity, in one transaction
+func (s *BoltStore) SaveUserStats(records []*UserStatsRecord) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketUserStats)
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(record.Key()), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("save user stats: %w", err)
+	}
+	return nil
+}
+
+// LoadUserStats returns every user's activity in every workspace
+func (s *BoltStore) LoadUserStats() ([]*UserStatsRecord, error) {
+	records := make([]*UserStatsRecord, 0)
+	err := s.each(bucketUserStats, func(data []byte) error {
+		var record UserStatsRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		records = append(records, &record)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load user stats: %w", err)
+	}
+	return records, nil
+}
+
+// DeleteUserStats discards a user's activity in every workspace
+func (s *BoltStore) DeleteUserStats(username string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketUserStats)
+		var keys [][]byte
+		err := bucket.ForEach(func(key, data []byte) error {
+			var record UserStatsRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			if record.Username == username {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delete user stats: %w", err)
+	}
+	return nil
+}
+
+// SaveColdSegment creates or replaces the description of a segment in cold storage
+func (s *BoltStore) SaveColdSegment(segment *ColdSegmentRecord) error {
+	return s.put(bucketCold, segment.Key(), segment)
+}
+
+// LoadColdSegments returns the description of every segment in cold storage
+func (s *BoltStore) LoadColdSegments() ([]*ColdSegmentRecord, error) {
+	segments := make([]*ColdSegmentRecord, 0)
+	err := s.each(bucketCold, func(data []byte) error {
+		var segment ColdSegmentRecord
+		if err := json.Unmarshal(data, &segment); err != nil {
+			return err
+		}
+		segments = append(segments, &segment)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load cold segments: %w", err)
+	}
+	return segments, nil
+}
+
+// DeleteColdSegment discards the description of a segment
+func (s *BoltStore) DeleteColdSegment(key string) error {
+	return s.delete(bucketCold, key)
+}
+
+// put stores the JSON encoding of v under key in a top-level bucket
+func (s *BoltStore) put(bucket []byte, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", bucket, err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+	if err != nil {
+		return fmt.Errorf("save %s: %w", bucket, err)
+	}
+	return nil
+}
+
+// get returns a copy of the value under key in a top-level bucket, or an
+// error wrapping os.ErrNotExist when there is none
+func (s *BoltStore) get(bucket []byte, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Values are only valid during the transaction
+		if data := tx.Bucket(bucket).Get([]byte(key)); data != nil {
+			value = append([]byte(nil), data...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, os.ErrNotExist
+	}
+	return value, nil
+}
+
+// delete removes a key from a top-level bucket
+func (s *BoltStore) delete(bucket []byte, key string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", bucket, err)
+	}
+	return nil
+}
+
+// each calls fn with every value of a top-level bucket in key order
+func (s *BoltStore) each(bucket []byte, fn func(data []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(_, data []byte) error {
+			if data == nil {
+				return nil // A nested bucket
+			}
+			return fn(data)
+		})
+	})
+}
+
+// nestedNames returns the names of the nested buckets of a top-level bucket, sorted
+func (s *BoltStore) nestedNames(bucket []byte) ([]string, error) {
+	var names []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return eachNested(tx.Bucket(bucket), func(name string, _ *bolt.Bucket) error {
+			names = append(names, name)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", bucket, err)
+	}
+	return names, nil
+}
+
+// eachNested calls fn with every nested bucket of b in name order
+func eachNested(b *bolt.Bucket, fn func(name string, nested *bolt.Bucket) error) error {
+	return b.ForEach(func(name, data []byte) error {
+		if data != nil {
+			return nil
+		}
+		return fn(string(name), b.Bucket(name))
+	})
+}
+
+// appendJSON stores the JSON encoding of v under the bucket's next sequence number
+func appendJSON(b *bolt.Bucket, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return b.Put(key, data)
+}
+
+// sizeKey returns the key of an avatar image of a size, which sorts the
+// images from smallest to largest
+func sizeKey(size int) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(size))
+	return key
+}
@@ -0,0 +1,413 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedPrefix starts content encrypted at rest, followed by the ID of
+// its key, a colon and the base64 nonce and ciphertext
+const encryptedPrefix = "enc:v1:"
+
+// ErrUnknownKey is returned when stored content is encrypted with a key
+// that isn't configured
+var ErrUnknownKey = errors.New("content is encrypted with a key that isn't configured")
+
+// BlobSealer is implemented by stores that encrypt at rest, so data kept
+// outside them, such as cold storage segments, is encrypted with the same keys
+type BlobSealer interface {
+	// SealBlob encrypts data with the current key, authenticating it with
+	// name, or returns it as it is without a current key
+	SealBlob(data []byte, name string) ([]byte, error)
+
+	// OpenBlob decrypts data sealed by SealBlob with the same name, and
+	// returns data that was never sealed as it is
+	OpenBlob(data []byte, name string) ([]byte, error)
+}
+
+// Encrypted wraps a Store so message content is encrypted with AES-256-GCM
+// before it is written and decrypted as it is read: the content of history
+// events, of messages queued for offline users and of reported messages, and
+// the secrets of accounts' authenticator apps. Everything else, including who sent what and when, is passed straight
+// through to the wrapped Store.
+//
+// New content is encrypted with the current key. Content encrypted with a
+// previous key can still be read, and content written before encryption was
+// turned on is read as it is, so both are replaced as history is rewritten.
+// Without a current key content is stored in plaintext, while content
+// encrypted earlier fails to load instead of being read as ciphertext.
+type Encrypted struct {
+	Store
+
+	current string                 // ID of the key new content is encrypted with; "" stores plaintext
+	keys    map[string]cipher.AEAD // By key ID
+}
+
+// NewEncrypted encrypts the message content st stores with key, or stores
+// it in plaintext when key is nil, and reads content encrypted with any of
+// the previous keys. Keys are 32 bytes.
+func NewEncrypted(st Store, key []byte, previous [][]byte) (*Encrypted, error) {
+	e := &Encrypted{Store: st, keys: make(map[string]cipher.AEAD)}
+	if key != nil {
+		e.current = KeyID(key)
+		previous = append([][]byte{key}, previous...)
+	}
+	for _, k := range previous {
+		if len(k) != 32 {
+			return nil, fmt.Errorf("encryption keys must be 32 bytes")
+		}
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		e.keys[KeyID(k)] = aead
+	}
+	return e, nil
+}
+
+// KeyID returns the short ID stored with content to name the key that
+// encrypted it, without revealing the key
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// QueueMessage encrypts a message's content and stores it for an offline user
+func (e *Encrypted) QueueMessage(msg *PendingMessage, limit int) error {
+	sealed := *msg
+	var err error
+	if sealed.Content, err = e.encrypt(msg.Content, "pending"); err != nil {
+		return err
+	}
+	return e.Store.QueueMessage(&sealed, limit)
+}
+
+// TakeMessages removes and returns every message waiting for a user, decrypted
+func (e *Encrypted) TakeMessages(username string) ([]*PendingMessage, error) {
+	messages, err := e.Store.TakeMessages(username)
+	if err != nil {
+		return nil, err
+	}
+	return e.openMessages(messages)
+}
+
+// PendingMessages returns every queued message by recipient, decrypted
+func (e *Encrypted) PendingMessages() (map[string][]*PendingMessage, error) {
+	pending, err := e.Store.PendingMessages()
+	if err != nil {
+		return nil, err
+	}
+	for username, messages := range pending {
+		if pending[username], err = e.openMessages(messages); err != nil {
+			return nil, err
+		}
+	}
+	return pending, nil
+}
+
+// AppendEvent encrypts an event's content and adds it to the end of its room's history
+func (e *Encrypted) AppendEvent(event *MessageEvent) error {
+	sealed, err := e.sealEvent(event)
+	if err != nil {
+		return err
+	}
+	return e.Store.AppendEvent(sealed)
+}
+
+// AppendEvents encrypts the content of events and adds them to the end of
+// their rooms' histories, in one call when the wrapped store supports batches
+func (e *Encrypted) AppendEvents(events []*MessageEvent) error {
+	sealed, err := e.sealEvents(events)
+	if err != nil {
+		return err
+	}
+	if batcher, ok := e.Store.(EventBatcher); ok {
+		return batcher.AppendEvents(sealed)
+	}
+	for _, event := range sealed {
+		if err := e.Store.AppendEvent(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadEvents returns a room's history, decrypted
+func (e *Encrypted) LoadEvents(roomID string) ([]*MessageEvent, error) {
+	events, err := e.Store.LoadEvents(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	opened := make([]*MessageEvent, len(events))
+	for i, event := range events {
+		if opened[i], err = e.openEvent(event); err != nil {
+			return nil, fmt.Errorf("room %s: %w", roomID, err)
+		}
+	}
+	return opened, nil
+}
+
+// ReplaceEvents encrypts the content of events and overwrites a room's history with them
+func (e *Encrypted) ReplaceEvents(roomID string, events []*MessageEvent) error {
+	sealed, err := e.sealEvents(events)
+	if err != nil {
+		return err
+	}
+	return e.Store.ReplaceEvents(roomID, sealed)
+}
+
+// SaveReport encrypts the reported message's content and saves the report
+func (e *Encrypted) SaveReport(report *ReportRecord) error {
+	sealed := *report
+	var err error
+	if sealed.Content, err = e.encrypt(report.Content, "report:"+report.ID); err != nil {
+		return err
+	}
+	return e.Store.SaveReport(&sealed)
+}
+
+// LoadReports returns every message report, decrypted
+func (e *Encrypted) LoadReports() ([]*ReportRecord, error) {
+	reports, err := e.Store.LoadReports()
+	if err != nil {
+		return nil, err
+	}
+
+	opened := make([]*ReportRecord, len(reports))
+	for i, report := range reports {
+		c := *report
+		if c.Content, err = e.decrypt(report.Content, "report:"+report.ID); err != nil {
+			return nil, fmt.Errorf("report %s: %w", report.ID, err)
+		}
+		opened[i] = &c
+	}
+	return opened, nil
+}
+
+// SaveAccount encrypts the secret of the account's authenticator app and
+// saves the account
+func (e *Encrypted) SaveAccount(account *AccountRecord) error {
+	if account.TOTP == nil {
+		return e.Store.SaveAccount(account)
+	}
+	sealed, totp := *account, *account.TOTP
+	var err error
+	if totp.Secret, err = e.encrypt(totp.Secret, "totp:"+account.Username); err != nil {
+		return err
+	}
+	sealed.TOTP = &totp
+	return e.Store.SaveAccount(&sealed)
+}
+
+// LoadAccounts returns every user account with its authenticator app's
+// secret decrypted
+func (e *Encrypted) LoadAccounts() ([]*AccountRecord, error) {
+	accounts, err := e.Store.LoadAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	opened := make([]*AccountRecord, len(accounts))
+	for i, account := range accounts {
+		opened[i] = account
+		if account.TOTP == nil {
+			continue
+		}
+		c, totp := *account, *account.TOTP
+		if totp.Secret, err = e.decrypt(account.TOTP.Secret, "totp:"+account.Username); err != nil {
+			return nil, fmt.Errorf("account %s: %w", account.Username, err)
+		}
+		c.TOTP = &totp
+		opened[i] = &c
+	}
+	return opened, nil
+}
+
+// sealEvent returns a copy of an event with its content encrypted, leaving
+// the event itself untouched since history still holds it. The content's
+// rendered HTML and the URLs and usernames of its entities repeat it, so
+// they are encrypted too.
+func (e *Encrypted) sealEvent(event *MessageEvent) (*MessageEvent, error) {
+	sealed := *event
+	data := eventData(event)
+	var err error
+	if sealed.Content, err = e.encrypt(event.Content, data); err != nil {
+		return nil, err
+	}
+	if sealed.HTML, err = e.encrypt(event.HTML, data); err != nil {
+		return nil, err
+	}
+	if event.Entities != nil {
+		sealed.Entities = make([]Entity, len(event.Entities))
+		for i, entity := range event.Entities {
+			if entity.URL, err = e.encrypt(entity.URL, data); err != nil {
+				return nil, err
+			}
+			if entity.Username, err = e.encrypt(entity.Username, data); err != nil {
+				return nil, err
+			}
+			sealed.Entities[i] = entity
+		}
+	}
+	return &sealed, nil
+}
+
+// openEvent returns a copy of an event sealed by sealEvent, decrypted
+func (e *Encrypted) openEvent(event *MessageEvent) (*MessageEvent, error) {
+	opened := *event
+	data := eventData(event)
+	var err error
+	if opened.Content, err = e.decrypt(event.Content, data); err != nil {
+		return nil, err
+	}
+	if opened.HTML, err = e.decrypt(event.HTML, data); err != nil {
+		return nil, err
+	}
+	if event.Entities != nil {
+		opened.Entities = make([]Entity, len(event.Entities))
+		for i, entity := range event.Entities {
+			if entity.URL, err = e.decrypt(entity.URL, data); err != nil {
+				return nil, err
+			}
+			if entity.Username, err = e.decrypt(entity.Username, data); err != nil {
+				return nil, err
+			}
+			opened.Entities[i] = entity
+		}
+	}
+	return &opened, nil
+}
+
+// sealEvents returns copies of events with their content encrypted
+func (e *Encrypted) sealEvents(events []*MessageEvent) ([]*MessageEvent, error) {
+	sealed := make([]*MessageEvent, len(events))
+	for i, event := range events {
+		var err error
+		if sealed[i], err = e.sealEvent(event); err != nil {
+			return nil, err
+		}
+	}
+	return sealed, nil
+}
+
+// openMessages returns copies of queued messages with their content decrypted
+func (e *Encrypted) openMessages(messages []*PendingMessage) ([]*PendingMessage, error) {
+	opened := make([]*PendingMessage, len(messages))
+	for i, msg := range messages {
+		c := *msg
+		var err error
+		if c.Content, err = e.decrypt(msg.Content, "pending"); err != nil {
+			return nil, fmt.Errorf("message for %s: %w", msg.To, err)
+		}
+		opened[i] = &c
+	}
+	return opened, nil
+}
+
+// encrypt seals content with the current key. data is authenticated along
+// with it, so content can't be moved to another record without failing to
+// decrypt. Empty content stays empty, and without a current key content
+// is returned as it is.
+func (e *Encrypted) encrypt(content, data string) (string, error) {
+	if content == "" || e.current == "" {
+		return content, nil
+	}
+
+	aead := e.keys[e.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(content), []byte(data))
+	return encryptedPrefix + e.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens content sealed by encrypt with data, and returns content
+// that was stored before encryption was turned on as it is
+func (e *Encrypted) decrypt(content, data string) (string, error) {
+	rest, ok := strings.CutPrefix(content, encryptedPrefix)
+	if !ok {
+		return content, nil
+	}
+
+	keyID, encoded, _ := strings.Cut(rest, ":")
+	aead, ok := e.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w (key %s)", ErrUnknownKey, keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("corrupt encrypted content")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(data))
+	if err != nil {
+		return "", fmt.Errorf("encrypted content fails authentication")
+	}
+	return string(plain), nil
+}
+
+// SealBlob encrypts data with the current key, authenticating name along
+// with it so it can't be passed off as another blob. Without a current key
+// data is returned as it is.
+func (e *Encrypted) SealBlob(data []byte, name string) ([]byte, error) {
+	if e.current == "" {
+		return data, nil
+	}
+
+	aead := e.keys[e.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := []byte(encryptedPrefix + e.current + ":")
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, data, []byte("blob:"+name)), nil
+}
+
+// Sealed reports whether data was encrypted by SealBlob
+func Sealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedPrefix))
+}
+
+// OpenBlob decrypts data sealed by SealBlob under name with the current or a
+// previous key, and returns data written before encryption was turned on as
+// it is
+func (e *Encrypted) OpenBlob(data []byte, name string) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(data, []byte(encryptedPrefix))
+	if !ok {
+		return data, nil
+	}
+
+	keyID, sealed, _ := bytes.Cut(rest, []byte(":"))
+	aead, ok := e.keys[string(keyID)]
+	if !ok {
+		return nil, fmt.Errorf("%w (key %s)", ErrUnknownKey, keyID)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("corrupt encrypted content")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte("blob:"+name))
+	if err != nil {
+		return nil, fmt.Errorf("encrypted content fails authentication")
+	}
+	return plain, nil
+}
+
+// eventData is the data authenticated with an event's content, tying it to
+// its room and message
+func eventData(event *MessageEvent) string {
+	return "event:" + event.RoomID + "/" + event.MessageID
+}
@@ -0,0 +1,995 @@
+package store
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore is a Store that keeps its data as JSON files in a directory
+type FileStore struct {
+	dir      string
+	mutex    sync.Mutex
+	rooms    map[string]*RoomRecord
+	pending  map[string][]*PendingMessage
+	reads    map[string]map[string]time.Time
+	polls    map[string]*PollRecord
+	emoji    map[string]*EmojiRecord
+	profiles map[string]*ProfileRecord
+	accounts map[string]*AccountRecord
+	sessions map[string]*SessionRecord
+	roles    map[string]*RoleRecord
+	spaces   map[string]*WorkspaceRecord
+	reports  map[string]*ReportRecord
+	devices  map[string]*DeviceKeysRecord
+	scores   map[string]*GameScoreRecord
+	stats    map[string]*StatsRecord
+	users    map[string]*UserStatsRecord
+	cold     map[string]*ColdSegmentRecord
+}
+
+// NewFileStore opens (creating if needed) a file store in dir
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create data directory: %w", err)
+	}
+
+	s := &FileStore{
+		dir:      dir,
+		rooms:    make(map[string]*RoomRecord),
+		pending:  make(map[string][]*PendingMessage),
+		reads:    make(map[string]map[string]time.Time),
+		polls:    make(map[string]*PollRecord),
+		emoji:    make(map[string]*EmojiRecord),
+		profiles: make(map[string]*ProfileRecord),
+		accounts: make(map[string]*AccountRecord),
+		sessions: make(map[string]*SessionRecord),
+		roles:    make(map[string]*RoleRecord),
+		spaces:   make(map[string]*WorkspaceRecord),
+		reports:  make(map[string]*ReportRecord),
+		devices:  make(map[string]*DeviceKeysRecord),
+		scores:   make(map[string]*GameScoreRecord),
+		stats:    make(map[string]*StatsRecord),
+		users:    make(map[string]*UserStatsRecord),
+		cold:     make(map[string]*ColdSegmentRecord),
+	}
+
+	if err := s.readJSON("rooms.json", &s.rooms); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("pending.json", &s.pending); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("reads.json", &s.reads); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("polls.json", &s.polls); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("emoji.json", &s.emoji); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("profiles.json", &s.profiles); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("accounts.json", &s.accounts); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("sessions.json", &s.sessions); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("roles.json", &s.roles); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("workspaces.json", &s.spaces); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("reports.json", &s.reports); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("device_keys.json", &s.devices); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("game_scores.json", &s.scores); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("stats.json", &s.stats); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("user_stats.json", &s.users); err != nil {
+		return nil, err
+	}
+	if err := s.readJSON("cold_segments.json", &s.cold); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveRoom creates or replaces a room's metadata
+func (s *FileStore) SaveRoom(room *RoomRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rooms[room.ID] = room
+	return s.writeJSON("rooms.json", s.rooms)
+}
+
+// DeleteRoom removes a room's metadata
+func (s *FileStore) DeleteRoom(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.rooms, id)
+	return s.writeJSON("rooms.json", s.rooms)
+}
+
+// LoadRooms returns the metadata of every persisted room, oldest first
+func (s *FileStore) LoadRooms() ([]*RoomRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rooms := make([]*RoomRecord, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].CreatedAt.Before(rooms[j].CreatedAt)
+	})
+	return rooms, nil
+}
+
+// QueueMessage stores a message for an offline user
+func (s *FileStore) QueueMessage(msg *PendingMessage, limit int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.pending[msg.To]) >= limit {
+		return ErrQueueFull
+	}
+
+	s.pending[msg.To] = append(s.pending[msg.To], msg)
+	return s.writeJSON("pending.json", s.pending)
+}
+
+// TakeMessages removes and returns every message waiting for a user
+func (s *FileStore) TakeMessages(username string) ([]*PendingMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := s.pending[username]
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	delete(s.pending, username)
+	return messages, s.writeJSON("pending.json", s.pending)
+}
+
+// PendingMessages returns every queued message by recipient without removing them
+func (s *FileStore) PendingMessages() (map[string][]*PendingMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Queues are compacted in place, so callers get copies
+	pending := make(map[string][]*PendingMessage, len(s.pending))
+	for username, messages := range s.pending {
+		pending[username] = append([]*PendingMessage(nil), messages...)
+	}
+	return pending, nil
+}
+
+// ExpireMessages discards queued messages sent before the cutoff
+func (s *FileStore) ExpireMessages(before time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+	for username, messages := range s.pending {
+		kept := messages[:0]
+		for _, msg := range messages {
+			if msg.Timestamp.Before(before) {
+				removed++
+				continue
+			}
+			kept = append(kept, msg)
+		}
+
+		if len(kept) == 0 {
+			delete(s.pending, username)
+		} else {
+			s.pending[username] = kept
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.writeJSON("pending.json", s.pending)
+}
+
+// AppendEvent adds an event to the end of a room's history file
+func (s *FileStore) AppendEvent(event *MessageEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encode event: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.dir, "history"), 0o700); err != nil {
+		return fmt.Errorf("create history directory: %w", err)
+	}
+
+	f, err := os.OpenFile(s.historyPath(event.RoomID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open history: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("append history: %w", err)
+	}
+	return nil
+}
+
+// AppendEvents adds events to the end of their rooms' history files,
+// opening each file once
+func (s *FileStore) AppendEvents(events []*MessageEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var roomIDs []string
+	lines := make(map[string][]byte)
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("encode event: %w", err)
+		}
+		if _, ok := lines[event.RoomID]; !ok {
+			roomIDs = append(roomIDs, event.RoomID)
+		}
+		lines[event.RoomID] = append(append(lines[event.RoomID], data...), '\n')
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.dir, "history"), 0o700); err != nil {
+		return fmt.Errorf("create history directory: %w", err)
+	}
+
+	for _, roomID := range roomIDs {
+		f, err := os.OpenFile(s.historyPath(roomID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("open history: %w", err)
+		}
+		_, err = f.Write(lines[roomID])
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("append history: %w", err)
+		}
+	}
+	return nil
+}
+
+// LoadEvents reads a room's history file
+func (s *FileStore) LoadEvents(roomID string) ([]*MessageEvent, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f, err := os.Open(s.historyPath(roomID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	defer f.Close()
+
+	var events []*MessageEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event MessageEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("decode history: %w", err)
+		}
+		events = append(events, &event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	return events, nil
+}
+
+// ReplaceEvents atomically rewrites a room's history file
+func (s *FileStore) ReplaceEvents(roomID string, events []*MessageEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var buf []byte
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("encode event: %w", err)
+		}
+		buf = append(append(buf, data...), '\n')
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.dir, "history"), 0o700); err != nil {
+		return fmt.Errorf("create history directory: %w", err)
+	}
+
+	path := s.historyPath(roomID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o600); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace history: %w", err)
+	}
+	return nil
+}
+
+// HistoryRooms returns the IDs of every room with a history file, sorted
+func (s *FileStore) HistoryRooms() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, "history"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list history: %w", err)
+	}
+
+	var roomIDs []string
+	for _, entry := range entries {
+		if roomID, ok := strings.CutSuffix(entry.Name(), ".jsonl"); ok && !entry.IsDir() {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	return roomIDs, nil
+}
+
+// DeleteEvents removes a room's history file and returns its size
+func (s *FileStore) DeleteEvents(roomID string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := s.historyPath(roomID)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("stat history: %w", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return 0, fmt.Errorf("delete history: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// SaveReadMarker records when a user last read a room
+func (s *FileStore) SaveReadMarker(roomID, username string, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.reads[roomID] == nil {
+		s.reads[roomID] = make(map[string]time.Time)
+	}
+	s.reads[roomID][username] = at
+	return s.writeJSON("reads.json", s.reads)
+}
+
+// LoadReadMarkers returns when each user last read a room
+func (s *FileStore) LoadReadMarkers(roomID string) (map[string]time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	markers := make(map[string]time.Time, len(s.reads[roomID]))
+	for username, at := range s.reads[roomID] {
+		markers[username] = at
+	}
+	return markers, nil
+}
+
+// ReadMarkerRooms returns the IDs of every room with read markers, sorted
+func (s *FileStore) ReadMarkerRooms() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	roomIDs := make([]string, 0, len(s.reads))
+	for roomID := range s.reads {
+		roomIDs = append(roomIDs, roomID)
+	}
+	sort.Strings(roomIDs)
+	return roomIDs, nil
+}
+
+// DeleteReadMarkers removes every read marker of a room
+func (s *FileStore) DeleteReadMarkers(roomID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.reads[roomID]; !ok {
+		return nil
+	}
+	delete(s.reads, roomID)
+	return s.writeJSON("reads.json", s.reads)
+}
+
+// SavePoll creates or replaces a poll
+func (s *FileStore) SavePoll(poll *PollRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.polls[poll.ID] = poll
+	return s.writeJSON("polls.json", s.polls)
+}
+
+// LoadPolls returns every persisted poll, oldest first
+func (s *FileStore) LoadPolls() ([]*PollRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	polls := make([]*PollRecord, 0, len(s.polls))
+	for _, poll := range s.polls {
+		polls = append(polls, poll)
+	}
+	sort.Slice(polls, func(i, j int) bool {
+		return polls[i].CreatedAt.Before(polls[j].CreatedAt)
+	})
+	return polls, nil
+}
+
+// SaveEmoji writes a custom emoji's image, then records its metadata
+func (s *FileStore) SaveEmoji(emoji *EmojiRecord, image []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Join(s.dir, "emoji"), 0o700); err != nil {
+		return fmt.Errorf("create emoji directory: %w", err)
+	}
+
+	path := s.emojiPath(emoji.Shortcode)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, image, 0o600); err != nil {
+		return fmt.Errorf("write emoji: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace emoji: %w", err)
+	}
+
+	s.emoji[emoji.Shortcode] = emoji
+	return s.writeJSON("emoji.json", s.emoji)
+}
+
+// DeleteEmoji removes a custom emoji's metadata, then its image
+func (s *FileStore) DeleteEmoji(shortcode string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.emoji, shortcode)
+	if err := s.writeJSON("emoji.json", s.emoji); err != nil {
+		return err
+	}
+	if err := os.Remove(s.emojiPath(shortcode)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete emoji: %w", err)
+	}
+	return nil
+}
+
+// LoadEmoji returns every custom emoji, sorted by shortcode
+func (s *FileStore) LoadEmoji() ([]*EmojiRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	emoji := make([]*EmojiRecord, 0, len(s.emoji))
+	for _, e := range s.emoji {
+		emoji = append(emoji, e)
+	}
+	sort.Slice(emoji, func(i, j int) bool {
+		return emoji[i].Shortcode < emoji[j].Shortcode
+	})
+	return emoji, nil
+}
+
+// LoadEmojiImage reads a custom emoji's image
+func (s *FileStore) LoadEmojiImage(shortcode string) ([]byte, error) {
+	image, err := os.ReadFile(s.emojiPath(shortcode))
+	if err != nil {
+		return nil, fmt.Errorf("read emoji: %w", err)
+	}
+	return image, nil
+}
+
+// emojiPath returns the path of a custom emoji's image
+func (s *FileStore) emojiPath(shortcode string) string {
+	return filepath.Join(s.dir, "emoji", filepath.Base(shortcode))
+}
+
+// SaveProfile creates or replaces a user's profile
+func (s *FileStore) SaveProfile(profile *ProfileRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.profiles[profile.Username] = profile
+	return s.writeJSON("profiles.json", s.profiles)
+}
+
+// LoadProfiles returns every user profile, sorted by username
+func (s *FileStore) LoadProfiles() ([]*ProfileRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	profiles := make([]*ProfileRecord, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Username < profiles[j].Username
+	})
+	return profiles, nil
+}
+
+// SaveAvatar atomically writes each of a user's avatar images, replacing
+// the directory holding the previous ones
+func (s *FileStore) SaveAvatar(username string, images map[int][]byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dir := s.avatarDir(username)
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("clear avatar directory: %w", err)
+	}
+	if err := os.MkdirAll(tmp, 0o700); err != nil {
+		return fmt.Errorf("create avatar directory: %w", err)
+	}
+	for size, image := range images {
+		if err := os.WriteFile(filepath.Join(tmp, strconv.Itoa(size)+".png"), image, 0o600); err != nil {
+			return fmt.Errorf("write avatar: %w", err)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("remove avatar: %w", err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return fmt.Errorf("replace avatar: %w", err)
+	}
+	return nil
+}
+
+// LoadAvatar reads a user's avatar image of a size
+func (s *FileStore) LoadAvatar(username string, size int) ([]byte, error) {
+	image, err := os.ReadFile(filepath.Join(s.avatarDir(username), strconv.Itoa(size)+".png"))
+	if err != nil {
+		return nil, fmt.Errorf("read avatar: %w", err)
+	}
+	return image, nil
+}
+
+// DeleteProfile removes a user's profile and avatar images
+func (s *FileStore) DeleteProfile(username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.RemoveAll(s.avatarDir(username)); err != nil {
+		return fmt.Errorf("remove avatar: %w", err)
+	}
+	delete(s.profiles, username)
+	return s.writeJSON("profiles.json", s.profiles)
+}
+
+// avatarDir returns the directory of a user's avatar images. Usernames may
+// contain any character, so it is named after the hex-encoded username.
+func (s *FileStore) avatarDir(username string) string {
+	return filepath.Join(s.dir, "avatars", hex.EncodeToString([]byte(username)))
+}
+
+// SaveAccount creates or replaces a user account
+func (s *FileStore) SaveAccount(account *AccountRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.accounts[account.Username] = account
+	return s.writeJSON("accounts.json", s.accounts)
+}
+
+// LoadAccounts returns every user account, sorted by username
+func (s *FileStore) LoadAccounts() ([]*AccountRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	accounts := make([]*AccountRecord, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Username < accounts[j].Username
+	})
+	return accounts, nil
+}
+
+// DeleteAccount removes a user account
+func (s *FileStore) DeleteAccount(username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.accounts, username)
+	return s.writeJSON("accounts.json", s.accounts)
+}
+
+// SaveSession creates or replaces a login session
+func (s *FileStore) SaveSession(session *SessionRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sessions[session.TokenHash] = session
+	return s.writeJSON("sessions.json", s.sessions)
+}
+
+// DeleteSession removes a login session
+func (s *FileStore) DeleteSession(tokenHash string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, tokenHash)
+	return s.writeJSON("sessions.json", s.sessions)
+}
+
+// LoadSessions returns every login session
+func (s *FileStore) LoadSessions() ([]*SessionRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessions := make([]*SessionRecord, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// SaveRole creates or replaces a role assignment; an empty role removes it
+func (s *FileStore) SaveRole(role *RoleRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if role.Role == "" {
+		delete(s.roles, role.Key())
+	} else {
+		s.roles[role.Key()] = role
+	}
+	return s.writeJSON("roles.json", s.roles)
+}
+
+// LoadRoles returns every role assignment
+func (s *FileStore) LoadRoles() ([]*RoleRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	roles := make([]*RoleRecord, 0, len(s.roles))
+	for _, role := range s.roles {
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// SaveWorkspace creates or replaces a workspace
+func (s *FileStore) SaveWorkspace(workspace *WorkspaceRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.spaces[workspace.ID] = workspace
+	return s.writeJSON("workspaces.json", s.spaces)
+}
+
+// DeleteWorkspace removes a workspace
+func (s *FileStore) DeleteWorkspace(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.spaces, id)
+	return s.writeJSON("workspaces.json", s.spaces)
+}
+
+// LoadWorkspaces returns every workspace
+func (s *FileStore) LoadWorkspaces() ([]*WorkspaceRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	workspaces := make([]*WorkspaceRecord, 0, len(s.spaces))
+	for _, workspace := range s.spaces {
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, nil
+}
+
+// SaveDeviceKeys creates or replaces a device's key bundle
+func (s *FileStore) SaveDeviceKeys(keys *DeviceKeysRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.devices[keys.Key()] = keys
+	return s.writeJSON("device_keys.json", s.devices)
+}
+
+// DeleteDeviceKeys removes a device's key bundle
+func (s *FileStore) DeleteDeviceKeys(username, deviceID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.devices, (&DeviceKeysRecord{Username: username, DeviceID: deviceID}).Key())
+	return s.writeJSON("device_keys.json", s.devices)
+}
+
+// LoadDeviceKeys returns the key bundle of every device
+func (s *FileStore) LoadDeviceKeys() ([]*DeviceKeysRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	devices := make([]*DeviceKeysRecord, 0, len(s.devices))
+	for _, keys := range s.devices {
+		devices = append(devices, keys)
+	}
+	return devices, nil
+}
+
+// SaveReport creates or replaces a message report
+func (s *FileStore) SaveReport(report *ReportRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.reports[report.ID] = report
+	return s.writeJSON("reports.json", s.reports)
+}
+
+// LoadReports returns every message report
+func (s *FileStore) LoadReports() ([]*ReportRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reports := make([]*ReportRecord, 0, len(s.reports))
+	for _, report := range s.reports {
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// AppendAudit adds an entry to the end of the audit log file
+func (s *FileStore) AppendAudit(entry *AuditRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encode audit entry: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, "audit.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("append audit log: %w", err)
+	}
+	return nil
+}
+
+// LoadAudit reads the audit log file
+func (s *FileStore) LoadAudit() ([]*AuditRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f, err := os.Open(filepath.Join(s.dir, "audit.log"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []*AuditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("decode audit log: %w", err)
+		}
+		entries = append(entries, &entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// SaveGameScore creates or replaces a user's score in a game
+func (s *FileStore) SaveGameScore(score *GameScoreRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.scores[score.Key()] = score
+	return s.writeJSON("game_scores.json", s.scores)
+}
+
+// LoadGameScores returns every user's score in every game
+func (s *FileStore) LoadGameScores() ([]*GameScoreRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scores := make([]*GameScoreRecord, 0, len(s.scores))
+	for _, score := range s.scores {
+		scores = append(scores, score)
+	}
+	return scores, nil
+}
+
+// SaveStats creates or replaces hours of activity
+func (s *FileStore) SaveStats(records []*StatsRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, record := range records {
+		s.stats[record.Key()] = record
+	}
+	return s.writeJSON("stats.json", s.stats)
+}
+
+// LoadStats returns every stored hour of activity
+func (s *FileStore) LoadStats() ([]*StatsRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records := make([]*StatsRecord, 0, len(s.stats))
+	for _, record := range s.stats {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// DeleteStats discards the hours of activity that started before the cutoff
+func (s *FileStore) DeleteStats(before time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+	for key, record := range s.stats {
+		if record.Hour.Before(before) {
+			delete(s.stats, key)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.writeJSON("stats.json", s.stats)
+}
+
+// SaveUserStats creates or replaces users' activity
+func (s *FileStore) SaveUserStats(records []*UserStatsRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, record := range records {
+		s.users[record.Key()] = record
+	}
+	return s.writeJSON("user_stats.json", s.users)
+}
+
+// LoadUserStats returns every user's activity in every workspace
+func (s *FileStore) LoadUserStats() ([]*UserStatsRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records := make([]*UserStatsRecord, 0, len(s.users))
+	for _, record := range s.users {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// DeleteUserStats discards a user's activity in every workspace
+func (s *FileStore) DeleteUserStats(username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := false
+	for key, record := range s.users {
+		if record.Username == username {
+			delete(s.users, key)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return s.writeJSON("user_stats.json", s.users)
+}
+
+// historyPath returns the path of a room's history file
+func (s *FileStore) historyPath(roomID string) string {
+	return filepath.Join(s.dir, "history", filepath.Base(roomID)+".jsonl")
+}
+
+// SaveColdSegment creates or replaces the description of a segment in cold storage
+func (s *FileStore) SaveColdSegment(segment *ColdSegmentRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.cold[segment.Key()] = segment
+	return s.writeJSON("cold_segments.json", s.cold)
+}
+
+// LoadColdSegments returns the description of every segment in cold storage
+func (s *FileStore) LoadColdSegments() ([]*ColdSegmentRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	segments := make([]*ColdSegmentRecord, 0, len(s.cold))
+	for _, segment := range s.cold {
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// DeleteColdSegment discards the description of a segment
+func (s *FileStore) DeleteColdSegment(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.cold[key]; !ok {
+		return nil
+	}
+	delete(s.cold, key)
+	return s.writeJSON("cold_segments.json", s.cold)
+}
+
+// readJSON decodes a file from the data directory, leaving v untouched if it doesn't exist
+func (s *FileStore) readJSON(name string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read %s: %w", name, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeJSON atomically replaces a file in the data directory with the JSON encoding of v
+func (s *FileStore) writeJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", name, err)
+	}
+
+	path := filepath.Join(s.dir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace %s: %w", name, err)
+	}
+	return nil
+}
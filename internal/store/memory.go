@@ -0,0 +1,851 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// snapshotVersion identifies the layout of snapshot files
+const snapshotVersion = 1
+
+// snapshot is the on-disk form of a MemoryStore
+type snapshot struct {
+	Version      int                             `json:"version"`
+	TakenAt      time.Time                       `json:"takenAt"`
+	Rooms        map[string]*RoomRecord          `json:"rooms"`
+	Pending      map[string][]*PendingMessage    `json:"pending"`
+	Reads        map[string]map[string]time.Time `json:"reads"`
+	Polls        map[string]*PollRecord          `json:"polls"`
+	Emoji        map[string]*EmojiRecord         `json:"emoji"`
+	EmojiImages  map[string][]byte               `json:"emojiImages"`
+	Profiles     map[string]*ProfileRecord       `json:"profiles"`
+	Avatars      map[string]map[int][]byte       `json:"avatars"`
+	Accounts     map[string]*AccountRecord       `json:"accounts"`
+	Sessions     map[string]*SessionRecord       `json:"sessions"`
+	Roles        map[string]*RoleRecord          `json:"roles"`
+	Workspaces   map[string]*WorkspaceRecord     `json:"workspaces"`
+	Reports      map[string]*ReportRecord        `json:"reports"`
+	DeviceKeys   map[string]*DeviceKeysRecord    `json:"deviceKeys"`
+	Audit        []*AuditRecord                  `json:"audit"`
+	GameScores   map[string]*GameScoreRecord     `json:"gameScores"`
+	Stats        map[string]*StatsRecord         `json:"stats"`
+	UserStats    map[string]*UserStatsRecord     `json:"userStats"`
+	ColdSegments map[string]*ColdSegmentRecord   `json:"coldSegments"`
+	History      map[string][]*MessageEvent      `json:"history"` // Most recent events of each room
+}
+
+// MemoryStore is a Store that keeps everything in memory and periodically
+// writes a snapshot to a single file, which is restored on startup. Changes
+// made after the last snapshot are lost if the process dies.
+type MemoryStore struct {
+	path         string
+	historyLimit int // Events kept per room in snapshots; 0 keeps all
+
+	mutex sync.Mutex
+	data  *snapshot
+	dirty bool // Set when data changed since the last snapshot
+}
+
+// NewMemoryStore creates a memory store that snapshots to path, restoring
+// the previous snapshot if there is one
+func NewMemoryStore(path string, historyLimit int) (*MemoryStore, error) {
+	s := &MemoryStore{
+		path:         path,
+		historyLimit: historyLimit,
+		data: &snapshot{
+			Version:      snapshotVersion,
+			Rooms:        make(map[string]*RoomRecord),
+			Pending:      make(map[string][]*PendingMessage),
+			Reads:        make(map[string]map[string]time.Time),
+			Polls:        make(map[string]*PollRecord),
+			Emoji:        make(map[string]*EmojiRecord),
+			EmojiImages:  make(map[string][]byte),
+			Profiles:     make(map[string]*ProfileRecord),
+			Avatars:      make(map[string]map[int][]byte),
+			Accounts:     make(map[string]*AccountRecord),
+			Sessions:     make(map[string]*SessionRecord),
+			Roles:        make(map[string]*RoleRecord),
+			Workspaces:   make(map[string]*WorkspaceRecord),
+			Reports:      make(map[string]*ReportRecord),
+			DeviceKeys:   make(map[string]*DeviceKeysRecord),
+			GameScores:   make(map[string]*GameScoreRecord),
+			Stats:        make(map[string]*StatsRecord),
+			UserStats:    make(map[string]*UserStatsRecord),
+			ColdSegments: make(map[string]*ColdSegmentRecord),
+			History:      make(map[string][]*MessageEvent),
+		},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	if err := json.Unmarshal(data, s.data); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	if s.data.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.data.Version)
+	}
+
+	log.Printf("Restored snapshot taken at %s", s.data.TakenAt.Format(time.RFC3339))
+	return s, nil
+}
+
+// Run writes a snapshot every interval until ctx is cancelled. The caller
+// should call Snapshot once more after everything using the store has stopped.
+func (s *MemoryStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Snapshot(); err != nil {
+				log.Printf("Error writing snapshot: %v", err)
+			}
+		}
+	}
+}
+
+// Snapshot atomically writes the store's state to its file if it changed
+// since the last snapshot
+func (s *MemoryStore) Snapshot() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	// Only the most recent history of each room is kept
+	out := *s.data
+	out.TakenAt = time.Now()
+	out.History = make(map[string][]*MessageEvent, len(s.data.History))
+	for roomID, events := range s.data.History {
+		if s.historyLimit > 0 && len(events) > s.historyLimit {
+			events = events[len(events)-s.historyLimit:]
+		}
+		out.History[roomID] = events
+	}
+
+	data, err := json.Marshal(&out)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("create snapshot directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("replace snapshot: %w", err)
+	}
+
+	s.dirty = false
+	return nil
+}
+
+// SaveRoom creates or replaces a room's metadata
+func (s *MemoryStore) SaveRoom(room *RoomRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.Rooms[room.ID] = room
+	s.dirty = true
+	return nil
+}
+
+// DeleteRoom removes a room's metadata
+func (s *MemoryStore) DeleteRoom(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data.Rooms, id)
+	s.dirty = true
+	return nil
+}
+
+// LoadRooms returns the metadata of every room, oldest first
+func (s *MemoryStore) LoadRooms() ([]*RoomRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rooms := make([]*RoomRecord, 0, len(s.data.Rooms))
+	for _, room := range s.data.Rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].CreatedAt.Before(rooms[j].CreatedAt)
+	})
+	return rooms, nil
+}
+
+// QueueMessage stores a message for an offline user
+func (s *MemoryStore) QueueMessage(msg *PendingMessage, limit int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.data.Pending[msg.To]) >= limit {
+		return ErrQueueFull
+	}
+
+	s.data.Pending[msg.To] = append(s.data.Pending[msg.To], msg)
+	s.dirty = true
+	return nil
+}
+
+// TakeMessages removes and returns every message waiting for a user
+func (s *MemoryStore) TakeMessages(username string) ([]*PendingMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := s.data.Pending[username]
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	delete(s.data.Pending, username)
+	s.dirty = true
+	return messages, nil
+}
+
+// PendingMessages returns every queued message by recipient without removing them
+func (s *MemoryStore) PendingMessages() (map[string][]*PendingMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Queues are compacted in place, so callers get copies
+	pending := make(map[string][]*PendingMessage, len(s.data.Pending))
+	for username, messages := range s.data.Pending {
+		pending[username] = append([]*PendingMessage(nil), messages...)
+	}
+	return pending, nil
+}
+
+// ExpireMessages discards queued messages sent before the cutoff
+func (s *MemoryStore) ExpireMessages(before time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+	for username, messages := range s.data.Pending {
+		kept := messages[:0]
+		for _, msg := range messages {
+			if msg.Timestamp.Before(before) {
+				removed++
+				continue
+			}
+			kept = append(kept, msg)
+		}
+
+		if len(kept) == 0 {
+			delete(s.data.Pending, username)
+		} else {
+			s.data.Pending[username] = kept
+		}
+	}
+
+	if removed > 0 {
+		s.dirty = true
+	}
+	return removed, nil
+}
+
+// AppendEvent adds an event to the end of a room's history
+func (s *MemoryStore) AppendEvent(event *MessageEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.History[event.RoomID] = append(s.data.History[event.RoomID], event)
+	s.dirty = true
+	return nil
+}
+
+// LoadEvents returns a room's history
+func (s *MemoryStore) LoadEvents(roomID string) ([]*MessageEvent, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]*MessageEvent(nil), s.data.History[roomID]...), nil
+}
+
+// ReplaceEvents overwrites a room's history
+func (s *MemoryStore) ReplaceEvents(roomID string, events []*MessageEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.History[roomID] = append([]*MessageEvent(nil), events...)
+	s.dirty = true
+	return nil
+}
+
+// HistoryRooms returns the IDs of every room with a history, sorted
+func (s *MemoryStore) HistoryRooms() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	roomIDs := make([]string, 0, len(s.data.History))
+	for roomID := range s.data.History {
+		roomIDs = append(roomIDs, roomID)
+	}
+	sort.Strings(roomIDs)
+	return roomIDs, nil
+}
+
+// DeleteEvents removes a room's history and returns its encoded size
+func (s *MemoryStore) DeleteEvents(roomID string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var size int64
+	for _, event := range s.data.History[roomID] {
+		if data, err := json.Marshal(event); err == nil {
+			size += int64(len(data))
+		}
+	}
+
+	delete(s.data.History, roomID)
+	s.dirty = true
+	return size, nil
+}
+
+// SaveReadMarker records when a user last read a room
+func (s *MemoryStore) SaveReadMarker(roomID, username string, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data.Reads[roomID] == nil {
+		s.data.Reads[roomID] = make(map[string]time.Time)
+	}
+	s.data.Reads[roomID][username] = at
+	s.dirty = true
+	return nil
+}
+
+// LoadReadMarkers returns when each user last read a room
+func (s *MemoryStore) LoadReadMarkers(roomID string) (map[string]time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	markers := make(map[string]time.Time, len(s.data.Reads[roomID]))
+	for username, at := range s.data.Reads[roomID] {
+		markers[username] = at
+	}
+	return markers, nil
+}
+
+// ReadMarkerRooms returns the IDs of every room with read markers, sorted
+func (s *MemoryStore) ReadMarkerRooms() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	roomIDs := make([]string, 0, len(s.data.Reads))
+	for roomID := range s.data.Reads {
+		roomIDs = append(roomIDs, roomID)
+	}
+	sort.Strings(roomIDs)
+	return roomIDs, nil
+}
+
+// DeleteReadMarkers removes every read marker of a room
+func (s *MemoryStore) DeleteReadMarkers(roomID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data.Reads, roomID)
+	s.dirty = true
+	return nil
+}
+
+// SavePoll creates or replaces a poll
+func (s *MemoryStore) SavePoll(poll *PollRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.Polls[poll.ID] = poll
+	s.dirty = true
+	return nil
+}
+
+// LoadPolls returns every poll, oldest first
+func (s *MemoryStore) LoadPolls() ([]*PollRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	polls := make([]*PollRecord, 0, len(s.data.Polls))
+	for _, poll := range s.data.Polls {
+		polls = append(polls, poll)
+	}
+	sort.Slice(polls, func(i, j int) bool {
+		return polls[i].CreatedAt.Before(polls[j].CreatedAt)
+	})
+	return polls, nil
+}
+
+// SaveEmoji creates or replaces a custom emoji and its image
+func (s *MemoryStore) SaveEmoji(emoji *EmojiRecord, image []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.Emoji[emoji.Shortcode] = emoji
+	s.data.EmojiImages[emoji.Shortcode] = image
+	s.dirty = true
+	return nil
+}
+
+// DeleteEmoji removes a custom emoji and its image
+func (s *MemoryStore) DeleteEmoji(shortcode string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data.Emoji, shortcode)
+	delete(s.data.EmojiImages, shortcode)
+	s.dirty = true
+	return nil
+}
+
+// LoadEmoji returns every custom emoji, sorted by shortcode
+func (s *MemoryStore) LoadEmoji() ([]*EmojiRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	emoji := make([]*EmojiRecord, 0, len(s.data.Emoji))
+	for _, e := range s.data.Emoji {
+		emoji = append(emoji, e)
+	}
+	sort.Slice(emoji, func(i, j int) bool {
+		return emoji[i].Shortcode < emoji[j].Shortcode
+	})
+	return emoji, nil
+}
+
+// LoadEmojiImage returns a custom emoji's image
+func (s *MemoryStore) LoadEmojiImage(shortcode string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	image, ok := s.data.EmojiImages[shortcode]
+	if !ok {
+		return nil, fmt.Errorf("read emoji: %w", os.ErrNotExist)
+	}
+	return image, nil
+}
+
+// SaveProfile creates or replaces a user's profile
+func (s *MemoryStore) SaveProfile(profile *ProfileRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.Profiles[profile.Username] = profile
+	s.dirty = true
+	return nil
+}
+
+// LoadProfiles returns every user profile, sorted by username
+func (s *MemoryStore) LoadProfiles() ([]*ProfileRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	profiles := make([]*ProfileRecord, 0, len(s.data.Profiles))
+	for _, p := range s.data.Profiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Username < profiles[j].Username
+	})
+	return profiles, nil
+}
+
+// SaveAvatar creates or replaces a user's avatar images
+func (s *MemoryStore) SaveAvatar(username string, images map[int][]byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.Avatars[username] = images
+	s.dirty = true
+	return nil
+}
+
+// LoadAvatar returns a user's avatar image of a size
+func (s *MemoryStore) LoadAvatar(username string, size int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	image, ok := s.data.Avatars[username][size]
+	if !ok {
+		return nil, fmt.Errorf("read avatar: %w", os.ErrNotExist)
+	}
+	return image, nil
+}
+
+// DeleteProfile removes a user's profile and avatar images
+func (s *MemoryStore) DeleteProfile(username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data.Profiles, username)
+	delete(s.data.Avatars, username)
+	s.dirty = true
+	return nil
+}
+
+// SaveAccount creates or replaces a user account
+func (s *MemoryStore) SaveAccount(account *AccountRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.Accounts[account.Username] = account
+	s.dirty = true
+	return nil
+}
+
+// LoadAccounts returns every user account, sorted by username
+func (s *MemoryStore) LoadAccounts() ([]*AccountRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	accounts := make([]*AccountRecord, 0, len(s.data.Accounts))
+	for _, a := range s.data.Accounts {
+		accounts = append(accounts, a)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Username < accounts[j].Username
+	})
+	return accounts, nil
+}
+
+// SaveSession creates or replaces a login session
+func (s *MemoryStore) SaveSession(session *SessionRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.Sessions[session.TokenHash] = session
+	s.dirty = true
+	return nil
+}
+
+// DeleteAccount removes a user account
+func (s *MemoryStore) DeleteAccount(username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data.Accounts, username)
+	s.dirty = true
+	return nil
+}
+
+// DeleteSession removes a login session
+func (s *MemoryStore) DeleteSession(tokenHash string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data.Sessions, tokenHash)
+	s.dirty = true
+	return nil
+}
+
+// LoadSessions returns every login session
+func (s *MemoryStore) LoadSessions() ([]*SessionRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessions := make([]*SessionRecord, 0, len(s.data.Sessions))
+	for _, session := range s.data.Sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// SaveRole creates or replaces a role assignment; an empty role removes it
+func (s *MemoryStore) SaveRole(role *RoleRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if role.Role == "" {
+		delete(s.data.Roles, role.Key())
+	} else {
+		s.data.Roles[role.Key()] = role
+	}
+	s.dirty = true
+	return nil
+}
+
+// LoadRoles returns every role assignment
+func (s *MemoryStore) LoadRoles() ([]*RoleRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	roles := make([]*RoleRecord, 0, len(s.data.Roles))
+	for _, role := range s.data.Roles {
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// SaveWorkspace creates or replaces a workspace
+func (s *MemoryStore) SaveWorkspace(workspace *WorkspaceRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.Workspaces[workspace.ID] = workspace
+	s.dirty = true
+	return nil
+}
+
+// DeleteWorkspace removes a workspace
+func (s *MemoryStore) DeleteWorkspace(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data.Workspaces, id)
+	s.dirty = true
+	return nil
+}
+
+// LoadWorkspaces returns every workspace
+func (s *MemoryStore) LoadWorkspaces() ([]*WorkspaceRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	workspaces := make([]*WorkspaceRecord, 0, len(s.data.Workspaces))
+	for _, workspace := range s.data.Workspaces {
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, nil
+}
+
+// SaveDeviceKeys creates or replaces a device's key bundle
+func (s *MemoryStore) SaveDeviceKeys(keys *DeviceKeysRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.DeviceKeys[keys.Key()] = keys
+	s.dirty = true
+	return nil
+}
+
+// DeleteDeviceKeys removes a device's key bundle
+func (s *MemoryStore) DeleteDeviceKeys(username, deviceID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data.DeviceKeys, (&DeviceKeysRecord{Username: username, DeviceID: deviceID}).Key())
+	s.dirty = true
+	return nil
+}
+
+// LoadDeviceKeys returns the key bundle of every device
+func (s *MemoryStore) LoadDeviceKeys() ([]*DeviceKeysRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	devices := make([]*DeviceKeysRecord, 0, len(s.data.DeviceKeys))
+	for _, keys := range s.data.DeviceKeys {
+		devices = append(devices, keys)
+	}
+	return devices, nil
+}
+
+// SaveReport creates or replaces a message report
+func (s *MemoryStore) SaveReport(report *ReportRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.Reports[report.ID] = report
+	s.dirty = true
+	return nil
+}
+
+// LoadReports returns every message report
+func (s *MemoryStore) LoadReports() ([]*ReportRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reports := make([]*ReportRecord, 0, len(s.data.Reports))
+	for _, report := range s.data.Reports {
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// AppendAudit adds an entry to the end of the audit log
+func (s *MemoryStore) AppendAudit(entry *AuditRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data.Audit = append(s.data.Audit, entry)
+	s.dirty = true
+	return nil
+}
+
+// LoadAudit returns the audit log
+func (s *MemoryStore) LoadAudit() ([]*AuditRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]*AuditRecord(nil), s.data.Audit...), nil
+}
+
+// SaveGameScore creates or replaces a user's score in a game
+func (s *MemoryStore) SaveGameScore(score *GameScoreRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data.GameScores == nil {
+		s.data.GameScores = make(map[string]*GameScoreRecord)
+	}
+	s.data.GameScores[score.Key()] = score
+	s.dirty = true
+	return nil
+}
+
+// LoadGameScores returns every user's score in every game
+func (s *MemoryStore) LoadGameScores() ([]*GameScoreRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scores := make([]*GameScoreRecord, 0, len(s.data.GameScores))
+	for _, score := range s.data.GameScores {
+		scores = append(scores, score)
+	}
+	return scores, nil
+}
+
+// SaveStats creates or replaces hours of activity
+func (s *MemoryStore) SaveStats(records []*StatsRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data.Stats == nil {
+		s.data.Stats = make(map[string]*StatsRecord)
+	}
+	for _, record := range records {
+		s.data.Stats[record.Key()] = record
+	}
+	s.dirty = true
+	return nil
+}
+
+// LoadStats returns every stored hour of activity
+func (s *MemoryStore) LoadStats() ([]*StatsRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records := make([]*StatsRecord, 0, len(s.data.Stats))
+	for _, record := range s.data.Stats {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// DeleteStats discards the hours of activity that started before the cutoff
+func (s *MemoryStore) DeleteStats(before time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+	for key, record := range s.data.Stats {
+		if record.Hour.Before(before) {
+			delete(s.data.Stats, key)
+			removed++
+		}
+	}
+	if removed > 0 {
+		s.dirty = true
+	}
+	return removed, nil
+}
+
+// SaveUserStats creates or replaces users' activity
+func (s *MemoryStore) SaveUserStats(records []*UserStatsRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data.UserStats == nil {
+		s.data.UserStats = make(map[string]*UserStatsRecord)
+	}
+	for _, record := range records {
+		s.data.UserStats[record.Key()] = record
+	}
+	s.dirty = true
+	return nil
+}
+
+// LoadUserStats returns every user's activity in every workspace
+func (s *MemoryStore) LoadUserStats() ([]*UserStatsRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records := make([]*UserStatsRecord, 0, len(s.data.UserStats))
+	for _, record := range s.data.UserStats {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// DeleteUserStats discards a user's activity in every workspace
+func (s *MemoryStore) DeleteUserStats(username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, record := range s.data.UserStats {
+		if record.Username == username {
+			delete(s.data.UserStats, key)
+			s.dirty = true
+		}
+	}
+	return nil
+}
+
+// SaveColdSegment creates or replaces the description of a segment in cold storage
+func (s *MemoryStore) SaveColdSegment(segment *ColdSegmentRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data.ColdSegments == nil {
+		s.data.ColdSegments = make(map[string]*ColdSegmentRecord)
+	}
+	s.data.ColdSegments[segment.Key()] = segment
+	s.dirty = true
+	return nil
+}
+
+// LoadColdSegments returns the description of every segment in cold storage
+func (s *MemoryStore) LoadColdSegments() ([]*ColdSegmentRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	segments := make([]*ColdSegmentRecord, 0, len(s.data.ColdSegments))
+	for _, segment := range s.data.ColdSegments {
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// DeleteColdSegment discards the description of a segment
+func (s *MemoryStore) DeleteColdSegment(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.data.ColdSegments[key]; ok {
+		delete(s.data.ColdSegments, key)
+		s.dirty = true
+	}
+	return nil
+}
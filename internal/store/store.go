@@ -0,0 +1,647 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrQueueFull is returned when a user's offline queue has reached its limit
+var ErrQueueFull = errors.New("offline queue is full")
+
+// RoomRecord is the persisted metadata of a chat room
+type RoomRecord struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	CreatedBy   string    `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Mode        string    `json:"mode"`
+	Posters     []string  `json:"posters,omitempty"`
+	Topic       string    `json:"topic,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Bans        []string  `json:"bans,omitempty"`
+	Pins        []string  `json:"pins,omitempty"`       // IDs of pinned messages, oldest pin first
+	MessageTTL  int64     `json:"messageTtl,omitempty"` // Seconds before messages disappear; 0 keeps them
+
+	// Retention policy overriding the global one; 0 uses the global setting
+	RetentionDays     int `json:"retentionDays,omitempty"`
+	RetentionMessages int `json:"retentionMessages,omitempty"`
+
+	// Remaining room settings: seconds between a user's messages, most
+	// clients at once, who may post and what happens to profanity
+	SlowMode       int    `json:"slowMode,omitempty"`
+	MaxMembers     int    `json:"maxMembers,omitempty"`
+	WhoCanPost     string `json:"whoCanPost,omitempty"`
+	ProfanityLevel string `json:"profanityLevel,omitempty"`
+
+	// Welcome message and rules sent privately to each user who joins
+	Welcome string `json:"welcome,omitempty"`
+	Rules   string `json:"rules,omitempty"`
+
+	// Federated servers the room is shared with
+	Federation []string `json:"federation,omitempty"`
+
+	// Whether the room can be watched without logging in
+	Live bool `json:"live,omitempty"`
+
+	// Origins of the sites that may embed the room's chat widget
+	EmbedOrigins []string `json:"embedOrigins,omitempty"`
+
+	// Muted usernames and when their mute ends; a zero time lasts until unmuted
+	Mutes map[string]time.Time `json:"mutes,omitempty"`
+
+	// Webhook notified of message changes, for rooms bridged to other systems
+	Webhook       string `json:"webhook,omitempty"`
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+
+	// Secret of the URL other systems post messages to the room through
+	IncomingToken string `json:"incomingToken,omitempty"`
+
+	// Support-mode conversation state
+	VisitorEmail      string     `json:"visitorEmail,omitempty"`
+	ConversationStart *time.Time `json:"conversationStart,omitempty"`
+
+	// Set on rooms whose messages are end-to-end encrypted by their clients
+	Encrypted bool `json:"encrypted,omitempty"`
+
+	// Workspace the room belongs to; empty for the server's own rooms
+	Workspace string `json:"workspace,omitempty"`
+
+	// Tags the room is found by, and whether admins feature it, in room discovery
+	Tags     []string `json:"tags,omitempty"`
+	Featured bool     `json:"featured,omitempty"`
+
+	// Readable name the room is joined and linked by, unique in its workspace
+	Slug string `json:"slug,omitempty"`
+
+	// Set on rooms archived read-only by their admins, with who archived them
+	Archived   bool   `json:"archived,omitempty"`
+	ArchivedBy string `json:"archivedBy,omitempty"`
+
+	// Platform and channel ID of a room imported from another platform, as
+	// "slack:C024BE91L", so importing the channel again skips it
+	ImportedFrom string `json:"importedFrom,omitempty"`
+
+	// Who may join the room, the users approved to join when it asks to
+	// knock, and the join requests waiting for a moderator
+	JoinRule string        `json:"joinRule,omitempty"`
+	Approved []string      `json:"approved,omitempty"`
+	Knocks   []KnockRecord `json:"knocks,omitempty"`
+
+	// Users of a group conversation, the only ones who may see and join it
+	Participants []string `json:"participants,omitempty"`
+}
+
+// KnockRecord is a persisted request to join a room that asks to knock
+type KnockRecord struct {
+	Username    string    `json:"username"`
+	Reason      string    `json:"reason,omitempty"`
+	RequestedAt time.Time `json:"requestedAt"`
+}
+
+// Kinds of pending messages
+const (
+	PendingDirect  = ""        // A direct message
+	PendingMention = "mention" // A room message that mentions the recipient
+	PendingRoom    = "room"    // A message in a room the recipient gets every notification for
+)
+
+// PendingMessage is a direct message or mention waiting for an offline recipient
+type PendingMessage struct {
+	Kind      string    `json:"kind,omitempty"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// The room message of a mention or room notification, or the ID of a
+	// direct message in its conversation
+	RoomID    string `json:"roomId,omitempty"`
+	MessageID string `json:"messageId,omitempty"`
+
+	// Set on end-to-end encrypted direct messages, whose Content is ciphertext
+	Encryption *Encryption `json:"encryption,omitempty"`
+
+	// Workspace a direct message was sent in, which it is only delivered in
+	Workspace string `json:"workspace,omitempty"`
+}
+
+// Encryption describes how a client encrypted a message end to end. The
+// server never sees the keys: it stores and relays the ciphertext as it is
+// and hands this header to the recipients so they can decrypt it.
+type Encryption struct {
+	Algorithm    string `json:"algorithm"`              // For example "m.megolm.v1.aes-sha2" or "m.olm.v1.curve25519-aes-sha2"
+	SenderKey    string `json:"senderKey"`              // Identity key of the sending device
+	SenderDevice string `json:"senderDevice,omitempty"` // ID of the sending device
+	SessionID    string `json:"sessionId,omitempty"`    // Group session of a room message
+	RecipientKey string `json:"recipientKey,omitempty"` // Identity key of the device a direct message is encrypted for
+}
+
+// GIF is a GIF picked from the configured provider's search and posted as a
+// message. Only the provider's media URLs are kept.
+type GIF struct {
+	Provider   string `json:"provider"` // "giphy" or "tenor"
+	ID         string `json:"id"`       // The GIF's ID at the provider
+	Title      string `json:"title,omitempty"`
+	URL        string `json:"url"`                  // The full-size GIF
+	PreviewURL string `json:"previewUrl,omitempty"` // A smaller rendition for pickers and previews
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+}
+
+// Kinds of Entity
+const (
+	EntityLink      = "link"
+	EntityMention   = "mention"
+	EntityCode      = "code"       // Inline code
+	EntityCodeBlock = "code_block" // Fenced code block
+)
+
+// Entity is a span of a message's content found by rendering its markdown,
+// so clients can act on links, mentions and code without parsing it again
+type Entity struct {
+	Type     string `json:"type"`
+	Offset   int    `json:"offset"`             // Start of the span in the content, in UTF-16 code units
+	Length   int    `json:"length"`             // Length of the span, in UTF-16 code units
+	URL      string `json:"url,omitempty"`      // Target of a "link"
+	Username string `json:"username,omitempty"` // User named by a "mention"
+	Language string `json:"language,omitempty"` // Language given to a "code_block"
+}
+
+// MessageEvent is a single change to a room's message history: a new message,
+// an edit, a deletion or a reaction. A room's history is the ordered list of its events.
+type MessageEvent struct {
+	Type      string     `json:"type"` // "message", "edit", "delete", "reaction_add", "reaction_remove"
+	MessageID string     `json:"messageId"`
+	RoomID    string     `json:"roomId"`
+	Seq       int64      `json:"seq,omitempty"` // Position of a "message" in its room, counting from 1
+	Username  string     `json:"username"`
+	Content   string     `json:"content,omitempty"`   // Message text for "message" and "edit"
+	Emoji     string     `json:"emoji,omitempty"`     // Reaction for "reaction_add" and "reaction_remove"
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // When a disappearing "message" is purged
+	Timestamp time.Time  `json:"timestamp"`
+
+	// Set on the "message" and "edit" events of encrypted rooms, whose Content is ciphertext
+	Encryption *Encryption `json:"encryption,omitempty"`
+
+	// Set on a "message" posting a GIF, whose Content is the GIF's URL
+	GIF *GIF `json:"gif,omitempty"`
+
+	// Content of a plaintext "message" or "edit" rendered from markdown to
+	// sanitized HTML, and the entities found in it
+	HTML     string   `json:"html,omitempty"`
+	Entities []Entity `json:"entities,omitempty"`
+
+	// ID the sender's client gave a "message", used to drop retransmissions
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+
+	// Set on the "message" and "edit" events of deleted messages served
+	// outside the store, whose content was blanked; Hash still covers it
+	Redacted bool `json:"redacted,omitempty"`
+
+	// Hash chain linking the event to the one before it in its room, and the
+	// server's signature of Hash when history signing is on
+	PrevHash  string `json:"prevHash,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// PollRecord is a poll and the votes cast in it
+type PollRecord struct {
+	ID        string         `json:"id"`
+	RoomID    string         `json:"roomId"`
+	CreatedBy string         `json:"createdBy"`
+	Question  string         `json:"question"`
+	Options   []string       `json:"options"`
+	Anonymous bool           `json:"anonymous,omitempty"`
+	Votes     map[string]int `json:"votes"` // Username to the index of the chosen option
+	CreatedAt time.Time      `json:"createdAt"`
+	ClosedAt  *time.Time     `json:"closedAt,omitempty"`
+}
+
+// EmojiRecord is a custom emoji or sticker registered by an admin
+type EmojiRecord struct {
+	Shortcode   string    `json:"shortcode"`
+	Kind        string    `json:"kind"` // "emoji" or "sticker"
+	ContentType string    `json:"contentType"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// ProfileRecord is a user's public profile
+type ProfileRecord struct {
+	Username        string     `json:"username"`
+	DisplayName     string     `json:"displayName,omitempty"`
+	Bio             string     `json:"bio,omitempty"`
+	Status          string     `json:"status,omitempty"`
+	AvatarUpdatedAt *time.Time `json:"avatarUpdatedAt,omitempty"` // Set once the user has uploaded an avatar
+	UpdatedAt       time.Time  `json:"updatedAt"`
+
+	// Private settings for email digests of missed messages
+	Email        string     `json:"email,omitempty"`
+	DigestOptOut bool       `json:"digestOptOut,omitempty"`
+	LastDigestAt *time.Time `json:"lastDigestAt,omitempty"` // Newest message included in the last digest
+
+	// Notification level of each room whose level isn't the default
+	Notifications map[string]string `json:"notifications,omitempty"`
+
+	// Users whose messages, direct messages and mentions are hidden from
+	// this user, sorted
+	Blocked []string `json:"blocked,omitempty"`
+}
+
+// Identity is a user of an OAuth2 provider linked to a local account
+type Identity struct {
+	Provider string `json:"provider"` // "google" or "github"
+	Subject  string `json:"subject"`  // The provider's stable user ID
+	Email    string `json:"email,omitempty"`
+}
+
+// AccountRecord is a local user account created by logging in with an OAuth2 provider
+type AccountRecord struct {
+	Username   string           `json:"username"`
+	Identities []*Identity      `json:"identities"`
+	CreatedAt  time.Time        `json:"createdAt"`
+	Deletion   *DeletionRequest `json:"deletion,omitempty"` // Set while the user's deletion is pending
+	TOTP       *TOTPRecord      `json:"totp,omitempty"`     // Set once the user starts enrolling an authenticator app
+
+	// Service accounts belong to bots and integrations, which use API keys
+	// instead of logging in
+	Service bool            `json:"service,omitempty"`
+	APIKeys []*APIKeyRecord `json:"apiKeys,omitempty"`
+}
+
+// APIKeyRecord is an API key of a service account; only a hash of the key is stored
+type APIKeyRecord struct {
+	ID        string    `json:"id"`
+	TokenHash string    `json:"tokenHash"`
+	Name      string    `json:"name,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	RateLimit int       `json:"rateLimit,omitempty"` // Requests a minute; 0 for the server's default
+	CreatedBy string    `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TOTPRecord is an account's authenticator app for two-factor login
+type TOTPRecord struct {
+	Secret      string    `json:"secret"`  // Base32 shared secret
+	Enabled     bool      `json:"enabled"` // False until a first code confirms enrollment
+	EnabledAt   time.Time `json:"enabledAt,omitempty"`
+	LastStep    int64     `json:"lastStep,omitempty"`    // Time step of the last accepted code, so codes can't be replayed
+	BackupCodes []string  `json:"backupCodes,omitempty"` // Hashes of unused backup codes
+}
+
+// DeletionRequest is a user's request to have their account and data erased
+// once its grace period is over
+type DeletionRequest struct {
+	Mode        string    `json:"mode"` // "anonymize" or "purge" for the user's messages
+	RequestedAt time.Time `json:"requestedAt"`
+	DueAt       time.Time `json:"dueAt"`
+}
+
+// SessionRecord is a login session; only a hash of its token is stored
+type SessionRecord struct {
+	TokenHash string    `json:"tokenHash"`
+	Username  string    `json:"username"`
+	Device    string    `json:"device,omitempty"`   // User agent that logged in
+	IP        string    `json:"ip,omitempty"`       // Address that logged in
+	Verified  bool      `json:"verified,omitempty"` // Passed two-factor verification
+	Failures  int       `json:"failures,omitempty"` // Wrong two-factor codes entered
+	Guest     bool      `json:"guest,omitempty"`    // A guest identity rather than an account's login
+	Origin    string    `json:"origin,omitempty"`   // Site an embedded chat widget's guest is limited to
+	RoomID    string    `json:"roomId,omitempty"`   // Room an embedded chat widget's guest is limited to
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// WorkspaceRecord is a workspace hosting its own community on the server
+type WorkspaceRecord struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Members     []string  `json:"members,omitempty"` // Usernames of the only accounts allowed in; anyone when empty
+	NoGuests    bool      `json:"noGuests,omitempty"`
+	CreatedBy   string    `json:"createdBy,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// RoleRecord assigns a role to a user, either everywhere or in one room
+type RoleRecord struct {
+	Username string `json:"username"`
+	RoomID   string `json:"roomId,omitempty"` // Empty for a global role
+	Role     string `json:"role"`
+}
+
+// Key identifies the assignment a role record replaces
+func (r *RoleRecord) Key() string {
+	return r.RoomID + "/" + r.Username
+}
+
+// DeviceKeysRecord is the public key bundle a user's device publishes so
+// other devices can start end-to-end encrypted sessions with it
+type DeviceKeysRecord struct {
+	Username     string    `json:"username"`
+	DeviceID     string    `json:"deviceId"`
+	IdentityKey  string    `json:"identityKey"` // Long-term Curve25519 key
+	SigningKey   string    `json:"signingKey"`  // Ed25519 key that signs the prekeys
+	SignedPreKey PreKey    `json:"signedPreKey"`
+	OneTimeKeys  []PreKey  `json:"oneTimeKeys,omitempty"` // Each is handed out to one claim only
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// PreKey is a public prekey of a device and its signature by the device's signing key
+type PreKey struct {
+	ID        string `json:"id"`
+	Key       string `json:"key"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Key identifies the device a key bundle replaces
+func (r *DeviceKeysRecord) Key() string {
+	return r.Username + "/" + r.DeviceID
+}
+
+// ReportRecord is a message flagged for moderators to review
+type ReportRecord struct {
+	ID        string    `json:"id"`
+	RoomID    string    `json:"roomId"`
+	MessageID string    `json:"messageId"`
+	Reporter  string    `json:"reporter"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// The message as it was when reported, in case it is later edited or deleted
+	Author  string `json:"author"`
+	Content string `json:"content"`
+
+	// "open" until a moderator resolves or dismisses the report
+	Status     string     `json:"status"`
+	ResolvedBy string     `json:"resolvedBy,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	Note       string     `json:"note,omitempty"`
+}
+
+// AuditRecord is one moderation or admin action in the append-only audit log
+type AuditRecord struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`  // Username, or "admin" for the admin token
+	Action string    `json:"action"` // e.g. "kick", "ban", "mute", "delete", "admin_api"
+	Target string    `json:"target,omitempty"`
+	RoomID string    `json:"roomId,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+
+	// Extra facts about the action, such as a mute's duration or an admin call's status
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// GameScoreRecord is a user's standing in one of the chat games
+type GameScoreRecord struct {
+	Game      string    `json:"game"` // "trivia"
+	Username  string    `json:"username"`
+	Points    int       `json:"points"`
+	Played    int       `json:"played"` // Games finished with at least one point
+	Won       int       `json:"won"`    // Games finished with the most points, ties included
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Key identifies the score a record replaces
+func (r *GameScoreRecord) Key() string {
+	return r.Game + "/" + r.Username
+}
+
+// StatsRecord is an hour of activity in a room, or in a workspace as a whole
+type StatsRecord struct {
+	Workspace string    `json:"workspace,omitempty"` // "" for the server's own rooms
+	RoomID    string    `json:"roomId,omitempty"`    // "" for the workspace as a whole
+	Hour      time.Time `json:"hour"`                // Start of the hour, in UTC
+	Messages  int       `json:"messages"`
+	Users     []string  `json:"users,omitempty"` // Users who posted or were connected, sorted
+
+	// Most connections at once, sampled every minute
+	PeakConnections int `json:"peakConnections,omitempty"`
+}
+
+// Key identifies the hour of activity a record replaces
+func (r *StatsRecord) Key() string {
+	return r.Workspace + "/" + r.RoomID + "/" + r.Hour.UTC().Format("2006010215")
+}
+
+// UserStatsRecord is a user's activity in a workspace
+type UserStatsRecord struct {
+	Workspace string    `json:"workspace,omitempty"` // "" for the server's own rooms
+	Username  string    `json:"username"`
+	Messages  int       `json:"messages"`        // Messages posted in rooms
+	Rooms     []string  `json:"rooms,omitempty"` // IDs of the rooms joined, in the order joined
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// Key identifies the user activity a record replaces
+func (r *UserStatsRecord) Key() string {
+	return r.Workspace + "/" + r.Username
+}
+
+// ColdSegmentRecord describes a segment of a room's history moved to
+// cold storage: a compressed file of consecutive messages in an object store
+type ColdSegmentRecord struct {
+	RoomID   string    `json:"roomId"`
+	Object   string    `json:"object"`   // Key of the segment in the bucket
+	FirstSeq int64     `json:"firstSeq"` // Sequence numbers of its first and last message
+	LastSeq  int64     `json:"lastSeq"`
+	From     time.Time `json:"from"` // Timestamps of its first and last message
+	To       time.Time `json:"to"`
+	Messages int       `json:"messages"`
+	Bytes    int64     `json:"bytes"` // Compressed size
+
+	// Messages of each author, so a user's messages are found without
+	// reading the segment. Unset on segments written before it was kept.
+	Authors map[string][]ColdMessage `json:"authors,omitempty"`
+}
+
+// ColdMessage identifies a message in a segment of cold storage
+type ColdMessage struct {
+	ID        string    `json:"id"`
+	Seq       int64     `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Key identifies the segment a record replaces
+func (r *ColdSegmentRecord) Key() string {
+	return r.RoomID + "/" + strconv.FormatInt(r.FirstSeq, 10)
+}
+
+// Store persists chat data across server restarts
+type Store interface {
+	// SaveRoom creates or replaces a room's metadata
+	SaveRoom(room *RoomRecord) error
+
+	// DeleteRoom removes a room's metadata
+	DeleteRoom(id string) error
+
+	// LoadRooms returns the metadata of every persisted room
+	LoadRooms() ([]*RoomRecord, error)
+
+	// QueueMessage stores a message for an offline user, returning
+	// ErrQueueFull if they already have limit messages waiting
+	QueueMessage(msg *PendingMessage, limit int) error
+
+	// TakeMessages removes and returns every message waiting for a user, oldest first
+	TakeMessages(username string) ([]*PendingMessage, error)
+
+	// PendingMessages returns every queued message by recipient, oldest first, without removing them
+	PendingMessages() (map[string][]*PendingMessage, error)
+
+	// ExpireMessages discards queued messages sent before the cutoff and returns how many were removed
+	ExpireMessages(before time.Time) (int, error)
+
+	// AppendEvent adds an event to the end of a room's history
+	AppendEvent(event *MessageEvent) error
+
+	// LoadEvents returns a room's history in the order it was appended
+	LoadEvents(roomID string) ([]*MessageEvent, error)
+
+	// ReplaceEvents overwrites a room's entire history, used to purge events
+	ReplaceEvents(roomID string, events []*MessageEvent) error
+
+	// HistoryRooms returns the IDs of every room with a stored history
+	HistoryRooms() ([]string, error)
+
+	// DeleteEvents removes a room's entire history and returns how many bytes it used
+	DeleteEvents(roomID string) (int64, error)
+
+	// SaveReadMarker records when a user last read a room
+	SaveReadMarker(roomID, username string, at time.Time) error
+
+	// LoadReadMarkers returns when each user last read a room
+	LoadReadMarkers(roomID string) (map[string]time.Time, error)
+
+	// ReadMarkerRooms returns the IDs of every room with stored read markers
+	ReadMarkerRooms() ([]string, error)
+
+	// DeleteReadMarkers removes every read marker of a room
+	DeleteReadMarkers(roomID string) error
+
+	// SavePoll creates or replaces a poll
+	SavePoll(poll *PollRecord) error
+
+	// LoadPolls returns every persisted poll
+	LoadPolls() ([]*PollRecord, error)
+
+	// SaveEmoji creates or replaces a custom emoji and its image
+	SaveEmoji(emoji *EmojiRecord, image []byte) error
+
+	// DeleteEmoji removes a custom emoji and its image
+	DeleteEmoji(shortcode string) error
+
+	// LoadEmoji returns every custom emoji
+	LoadEmoji() ([]*EmojiRecord, error)
+
+	// LoadEmojiImage returns a custom emoji's image
+	LoadEmojiImage(shortcode string) ([]byte, error)
+
+	// SaveProfile creates or replaces a user's profile
+	SaveProfile(profile *ProfileRecord) error
+
+	// LoadProfiles returns every user profile
+	LoadProfiles() ([]*ProfileRecord, error)
+
+	// SaveAvatar creates or replaces a user's avatar with its images by size
+	// in pixels
+	SaveAvatar(username string, images map[int][]byte) error
+
+	// LoadAvatar returns a user's avatar image of a size
+	LoadAvatar(username string, size int) ([]byte, error)
+
+	// DeleteProfile removes a user's profile and avatar images
+	DeleteProfile(username string) error
+
+	// SaveAccount creates or replaces a user account
+	SaveAccount(account *AccountRecord) error
+
+	// LoadAccounts returns every user account
+	LoadAccounts() ([]*AccountRecord, error)
+
+	// DeleteAccount removes a user account
+	DeleteAccount(username string) error
+
+	// SaveSession creates or replaces a login session
+	SaveSession(session *SessionRecord) error
+
+	// DeleteSession removes a login session
+	DeleteSession(tokenHash string) error
+
+	// LoadSessions returns every login session
+	LoadSessions() ([]*SessionRecord, error)
+
+	// SaveRole creates or replaces a role assignment; an empty role removes it
+	SaveRole(role *RoleRecord) error
+
+	// LoadRoles returns every role assignment
+	LoadRoles() ([]*RoleRecord, error)
+
+	// SaveWorkspace creates or replaces a workspace
+	SaveWorkspace(workspace *WorkspaceRecord) error
+
+	// DeleteWorkspace removes a workspace
+	DeleteWorkspace(id string) error
+
+	// LoadWorkspaces returns every workspace
+	LoadWorkspaces() ([]*WorkspaceRecord, error)
+
+	// SaveDeviceKeys creates or replaces a device's key bundle
+	SaveDeviceKeys(keys *DeviceKeysRecord) error
+
+	// DeleteDeviceKeys removes a device's key bundle
+	DeleteDeviceKeys(username, deviceID string) error
+
+	// LoadDeviceKeys returns the key bundle of every device
+	LoadDeviceKeys() ([]*DeviceKeysRecord, error)
+
+	// SaveReport creates or replaces a message report
+	SaveReport(report *ReportRecord) error
+
+	// LoadReports returns every message report
+	LoadReports() ([]*ReportRecord, error)
+
+	// AppendAudit adds an entry to the end of the audit log, which is never rewritten
+	AppendAudit(entry *AuditRecord) error
+
+	// LoadAudit returns the audit log in the order it was appended
+	LoadAudit() ([]*AuditRecord, error)
+
+	// SaveGameScore creates or replaces a user's score in a game
+	SaveGameScore(score *GameScoreRecord) error
+
+	// LoadGameScores returns every user's score in every game
+	LoadGameScores() ([]*GameScoreRecord, error)
+
+	// SaveStats creates or replaces hours of activity
+	SaveStats(records []*StatsRecord) error
+
+	// LoadStats returns every stored hour of activity
+	LoadStats() ([]*StatsRecord, error)
+
+	// DeleteStats discards the hours of activity that started before the
+	// cutoff and returns how many were removed
+	DeleteStats(before time.Time) (int, error)
+
+	// SaveUserStats creates or replaces users' activity
+	SaveUserStats(records []*UserStatsRecord) error
+
+	// LoadUserStats returns every user's activity in every workspace
+	LoadUserStats() ([]*UserStatsRecord, error)
+
+	// DeleteUserStats discards a user's activity in every workspace
+	DeleteUserStats(username string) error
+
+	// SaveColdSegment creates or replaces the description of a segment of
+	// history in cold storage
+	SaveColdSegment(segment *ColdSegmentRecord) error
+
+	// LoadColdSegments returns the description of every segment of
+	// history in cold storage
+	LoadColdSegments() ([]*ColdSegmentRecord, error)
+
+	// DeleteColdSegment discards the description of a segment, given its key
+	DeleteColdSegment(key string) error
+}
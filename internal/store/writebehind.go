@@ -0,0 +1,206 @@
+package store
+
+import (
+	"log"
+	"realtime-chat/internal/metrics"
+	"time"
+)
+
+// EventBatcher is implemented by stores that can append many history
+// events more cheaply than one at a time
+type EventBatcher interface {
+	// AppendEvents adds events to the end of their rooms' histories, in order
+	AppendEvents(events []*MessageEvent) error
+}
+
+// WriteBehind wraps a Store so history events are persisted in the
+// background instead of in the broadcast path. AppendEvent only queues the
+// event; a single writer appends queued events in batches once batchSize
+// have collected or interval has passed. When the queue is full
+// AppendEvent blocks until the writer catches up, so a slow disk slows
+// senders down rather than growing memory without bound.
+//
+// Queued events that haven't been written are lost if the process dies;
+// Close writes them on a clean shutdown. Reading or rewriting a history
+// first waits for every queued event to be written. Everything else is
+// passed straight through to the wrapped Store.
+type WriteBehind struct {
+	Store
+
+	batchSize int
+	interval  time.Duration
+	queue     chan *MessageEvent
+	flush     chan chan error
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewWriteBehind starts writing st's history events in the background,
+// with at most queueSize events waiting
+func NewWriteBehind(st Store, batchSize, queueSize int, interval time.Duration) *WriteBehind {
+	w := &WriteBehind{
+		Store:     st,
+		batchSize: batchSize,
+		interval:  interval,
+		queue:     make(chan *MessageEvent, queueSize),
+		flush:     make(chan chan error),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// AppendEvent queues an event to be appended to its room's history,
+// blocking while the queue is full. Events are appended directly once the
+// writer has stopped.
+func (w *WriteBehind) AppendEvent(event *MessageEvent) error {
+	select {
+	case w.queue <- event:
+		metrics.PersistQueued.Set(int64(len(w.queue)))
+		return nil
+	case <-w.done:
+		return w.Store.AppendEvent(event)
+	}
+}
+
+// LoadEvents writes every queued event, then returns a room's history
+func (w *WriteBehind) LoadEvents(roomID string) ([]*MessageEvent, error) {
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return w.Store.LoadEvents(roomID)
+}
+
+// ReplaceEvents writes every queued event, then overwrites a room's history
+func (w *WriteBehind) ReplaceEvents(roomID string, events []*MessageEvent) error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.Store.ReplaceEvents(roomID, events)
+}
+
+// HistoryRooms writes every queued event, then returns the IDs of every room with a stored history
+func (w *WriteBehind) HistoryRooms() ([]string, error) {
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return w.Store.HistoryRooms()
+}
+
+// DeleteEvents writes every queued event, then removes a room's history
+func (w *WriteBehind) DeleteEvents(roomID string) (int64, error) {
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return w.Store.DeleteEvents(roomID)
+}
+
+// Flush waits until every event queued so far has been written
+func (w *WriteBehind) Flush() error {
+	reply := make(chan error, 1)
+	select {
+	case w.flush <- reply:
+		return <-reply
+	case <-w.done:
+		return nil
+	}
+}
+
+// Close writes every queued event and stops the writer. Events appended
+// afterwards are written directly.
+func (w *WriteBehind) Close() error {
+	close(w.stop)
+	<-w.done
+	return nil
+}
+
+// run collects queued events into batches and writes them until stopped
+func (w *WriteBehind) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make([]*MessageEvent, 0, w.batchSize)
+	for {
+		select {
+		case event := <-w.queue:
+			batch = append(batch, event)
+			if len(batch) >= w.batchSize {
+				w.write(batch)
+				batch = batch[:0]
+			}
+
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.write(batch)
+				batch = batch[:0]
+			}
+
+		case reply := <-w.flush:
+			batch = w.drain(batch)
+			reply <- w.write(batch)
+			batch = batch[:0]
+
+		case <-w.stop:
+			w.write(w.drain(batch))
+			return
+		}
+	}
+}
+
+// drain moves every queued event into batch
+func (w *WriteBehind) drain(batch []*MessageEvent) []*MessageEvent {
+	for {
+		select {
+		case event := <-w.queue:
+			batch = append(batch, event)
+		default:
+			return batch
+		}
+	}
+}
+
+// write appends a batch of events to the wrapped store, in one call when
+// it supports batches. Failures are logged, since the senders have moved on.
+func (w *WriteBehind) write(batch []*MessageEvent) error {
+	defer metrics.PersistQueued.Set(int64(len(w.queue)))
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var err error
+	if batcher, ok := w.Store.(EventBatcher); ok {
+		err = batcher.AppendEvents(batch)
+	} else {
+		for _, event := range batch {
+			if err = w.Store.AppendEvent(event); err != nil {
+				break
+			}
+		}
+	}
+
+	metrics.PersistBatches.Add(1)
+	if err != nil {
+		metrics.PersistFailures.Add(int64(len(batch)))
+		log.Printf("Error persisting %d history events: %v", len(batch), err)
+	}
+	return err
+}
+
+// SealBlob seals data with the wrapped store's keys, if it has any
+func (w *WriteBehind) SealBlob(data []byte, name string) ([]byte, error) {
+	if sealer, ok := w.Store.(BlobSealer); ok {
+		return sealer.SealBlob(data, name)
+	}
+	return data, nil
+}
+
+// OpenBlob opens data sealed with the wrapped store's keys
+func (w *WriteBehind) OpenBlob(data []byte, name string) ([]byte, error) {
+	if sealer, ok := w.Store.(BlobSealer); ok {
+		return sealer.OpenBlob(data, name)
+	}
+	return data, nil
+}
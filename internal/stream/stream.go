@@ -0,0 +1,163 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/store"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Kinds of exported events, each written to its own topic
+const (
+	KindMessage    = "message"    // A message posted, edited, deleted, reacted to or removed
+	KindMembership = "membership" // A user joining or leaving a room
+	KindModeration = "moderation" // A moderation or admin action from the audit log
+)
+
+// Types of membership events
+const (
+	TypeJoin  = "join"
+	TypeLeave = "leave"
+)
+
+// batchSize is the most events written to Kafka in one request
+const batchSize = 100
+
+// Event is the JSON record exported for every chat event
+type Event struct {
+	Type      string            `json:"type"` // The history event type, TypeJoin, TypeLeave, or the audit action
+	RoomID    string            `json:"roomId,omitempty"`
+	MessageID string            `json:"messageId,omitempty"`
+	Seq       int64             `json:"seq,omitempty"`      // Sequence number of a posted message
+	Username  string            `json:"username,omitempty"` // Who acted
+	Author    string            `json:"author,omitempty"`   // Who wrote the message that changed
+	Target    string            `json:"target,omitempty"`   // Who a moderation action was taken against
+	Content   string            `json:"content,omitempty"`
+	Emoji     string            `json:"emoji,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+
+	// Set when Content is end-to-end encrypted ciphertext
+	Encryption *store.Encryption `json:"encryption,omitempty"`
+
+	// Set on a message posting a GIF, whose URL is Content
+	GIF *store.GIF `json:"gif,omitempty"`
+}
+
+// Exporter writes chat events to Kafka in the background. Events of a room
+// share a partition key, so consumers see each room's events in order.
+// Events are dropped when the queue is full so a slow or unreachable
+// cluster can't stall chat traffic.
+type Exporter struct {
+	writer *kafka.Writer
+	topics map[string]string
+	queue  chan kafka.Message
+	ctx    context.Context
+	done   chan struct{}
+}
+
+// New starts an exporter writing to the brokers in cfg until ctx is
+// cancelled, or returns nil when no brokers are configured
+func New(ctx context.Context, cfg config.KafkaConfig) *Exporter {
+	if len(cfg.Brokers) == 0 {
+		return nil
+	}
+
+	e := &Exporter{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(cfg.Brokers...),
+			Balancer:               &kafka.Hash{},
+			BatchSize:              batchSize,
+			BatchTimeout:           100 * time.Millisecond,
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+		},
+		topics: map[string]string{
+			KindMessage:    cfg.MessageTopic,
+			KindMembership: cfg.MembershipTopic,
+			KindModeration: cfg.ModerationTopic,
+		},
+		queue: make(chan kafka.Message, cfg.QueueSize),
+		ctx:   ctx,
+		done:  make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Send queues an event of a kind for export
+func (e *Exporter) Send(kind string, event *Event) {
+	value, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", kind, err)
+		return
+	}
+
+	msg := kafka.Message{Topic: e.topics[kind], Key: []byte(event.RoomID), Value: value, Time: event.Timestamp}
+	select {
+	case e.queue <- msg:
+	default:
+		metrics.EventsDropped.Add(1)
+	}
+}
+
+// Close waits for the exporter to write the events queued before its
+// context was cancelled, then closes its connections
+func (e *Exporter) Close() error {
+	<-e.done
+	return e.writer.Close()
+}
+
+// run writes queued events in batches until the exporter's context is
+// cancelled, then writes what is left
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	batch := make([]kafka.Message, 0, batchSize)
+	for {
+		select {
+		case msg := <-e.queue:
+			batch = e.fill(append(batch[:0], msg))
+			e.write(e.ctx, batch)
+
+		case <-e.ctx.Done():
+			// Give the last events a moment to reach Kafka
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			for batch = e.fill(batch[:0]); len(batch) > 0; batch = e.fill(batch[:0]) {
+				e.write(ctx, batch)
+			}
+			cancel()
+			return
+		}
+	}
+}
+
+// fill adds queued events to batch until it is full or the queue is empty
+func (e *Exporter) fill(batch []kafka.Message) []kafka.Message {
+	for len(batch) < batchSize {
+		select {
+		case msg := <-e.queue:
+			batch = append(batch, msg)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// write sends a batch of events to Kafka. Failures are logged and counted,
+// since the chat has moved on.
+func (e *Exporter) write(ctx context.Context, batch []kafka.Message) {
+	if err := e.writer.WriteMessages(ctx, batch...); err != nil {
+		metrics.ExportFailures.Add(int64(len(batch)))
+		log.Printf("Error exporting %d events to Kafka: %v", len(batch), err)
+		return
+	}
+	metrics.EventsExported.Add(int64(len(batch)))
+}
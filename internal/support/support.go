@@ -0,0 +1,163 @@
+package support
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"realtime-chat/internal/email"
+	"realtime-chat/internal/history"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Conversation is a support conversation that has ended
+type Conversation struct {
+	RoomID       string
+	RoomName     string
+	Visitor      string
+	VisitorEmail string // Transcript recipient; no email is sent when empty
+	StartedAt    time.Time
+	EndedAt      time.Time
+}
+
+// Summary is the record posted to the CRM webhook when a conversation ends
+type Summary struct {
+	RoomID            string    `json:"roomId"`
+	RoomName          string    `json:"roomName"`
+	Visitor           string    `json:"visitor"`
+	VisitorEmail      string    `json:"visitorEmail,omitempty"`
+	Agents            []string  `json:"agents"`
+	MessageCount      int       `json:"messageCount"`
+	StartedAt         time.Time `json:"startedAt"`
+	EndedAt           time.Time `json:"endedAt"`
+	TranscriptEmailed bool      `json:"transcriptEmailed"`
+}
+
+// Closer wraps up ended support conversations: it emails the visitor a
+// transcript and posts a summary to the CRM webhook
+type Closer struct {
+	history *history.History
+	email   email.Sender // nil disables transcript emails
+	webhook string       // empty disables the CRM webhook
+	client  *http.Client
+}
+
+// NewCloser creates a closer; sender may be nil and webhook may be empty
+func NewCloser(h *history.History, sender email.Sender, webhook string) *Closer {
+	return &Closer{
+		history: h,
+		email:   sender,
+		webhook: webhook,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Enabled reports whether ending a conversation has any effect
+func (c *Closer) Enabled() bool {
+	return c.email != nil || c.webhook != ""
+}
+
+// Close sends the transcript and summary of an ended conversation. It
+// blocks on network I/O, so callers should run it in its own goroutine.
+func (c *Closer) Close(conv *Conversation) {
+	messages, err := c.transcriptMessages(conv)
+	if err != nil {
+		log.Printf("Error loading transcript for room %s: %v", conv.RoomID, err)
+		return
+	}
+
+	summary := &Summary{
+		RoomID:       conv.RoomID,
+		RoomName:     conv.RoomName,
+		Visitor:      conv.Visitor,
+		VisitorEmail: conv.VisitorEmail,
+		Agents:       agents(conv.Visitor, messages),
+		MessageCount: len(messages),
+		StartedAt:    conv.StartedAt,
+		EndedAt:      conv.EndedAt,
+	}
+
+	if c.email != nil && conv.VisitorEmail != "" && len(messages) > 0 {
+		subject := fmt.Sprintf("Transcript of your conversation in %s", conv.RoomName)
+		if err := c.email.Send(conv.VisitorEmail, subject, transcript(conv, messages)); err != nil {
+			log.Printf("Error emailing transcript for room %s: %v", conv.RoomID, err)
+		} else {
+			summary.TranscriptEmailed = true
+		}
+	}
+
+	if c.webhook != "" {
+		if err := c.post(summary); err != nil {
+			log.Printf("Error posting support summary for room %s: %v", conv.RoomID, err)
+		}
+	}
+}
+
+// transcriptMessages returns the conversation's messages that weren't deleted
+func (c *Closer) transcriptMessages(conv *Conversation) ([]*history.Message, error) {
+	all, err := c.history.Messages(conv.RoomID)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*history.Message
+	for _, msg := range all {
+		if msg.Deleted || msg.Timestamp.Before(conv.StartedAt) || msg.Timestamp.After(conv.EndedAt) {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// post sends the summary to the CRM webhook
+func (c *Closer) post(summary *Summary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Post(c.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// transcript renders the conversation as plain text
+func transcript(conv *Conversation, messages []*history.Message) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Conversation in %s\n", conv.RoomName)
+	fmt.Fprintf(&b, "Started: %s\n", conv.StartedAt.Format(time.RFC1123))
+	fmt.Fprintf(&b, "Ended: %s\n\n", conv.EndedAt.Format(time.RFC1123))
+
+	for _, msg := range messages {
+		fmt.Fprintf(&b, "[%s] %s: %s\n", msg.Timestamp.Format("15:04:05"), msg.Username, msg.Content)
+	}
+	return b.String()
+}
+
+// agents returns the sorted usernames other than the visitor who posted messages
+func agents(visitor string, messages []*history.Message) []string {
+	seen := make(map[string]bool)
+	for _, msg := range messages {
+		if msg.Username != visitor {
+			seen[msg.Username] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,119 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of RFC 6238's test vectors, "12345678901234567890", base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The last six digits of RFC 6238's eight-digit SHA-1 vectors
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("code at %d is %s, want %s", unix, got, want)
+		}
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code, _ := Code(rfcSecret, step)
+
+	got, ok := Validate(rfcSecret, code, now, 0)
+	if !ok || got != step {
+		t.Fatalf("current code rejected: step %d, %v", got, ok)
+	}
+	if _, ok := Validate(rfcSecret, code[:3]+" "+code[3:], now, 0); !ok {
+		t.Error("code with a space rejected")
+	}
+	if _, ok := Validate(strings.ToLower(rfcSecret), code, now, 0); !ok {
+		t.Error("lowercase secret rejected")
+	}
+
+	// Codes can't be replayed once their step is used
+	if _, ok := Validate(rfcSecret, code, now, step); ok {
+		t.Error("code accepted twice")
+	}
+
+	// One step of drift either way is allowed, but no more
+	for offset, want := range map[int64]bool{-2: false, -1: true, 1: true, 2: false} {
+		drifted, _ := Code(rfcSecret, step+offset)
+		if _, ok := Validate(rfcSecret, drifted, now, 0); ok != want {
+			t.Errorf("code %d steps away accepted: %v, want %v", offset, ok, want)
+		}
+	}
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now, 0); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewSecret()
+	if a == b {
+		t.Error("secrets repeat")
+	}
+	if raw, err := encoding.DecodeString(a); err != nil || len(raw) != 20 {
+		t.Errorf("secret %q isn't 160 bits of base32: %v", a, err)
+	}
+	if _, err := Code(a, 1); err != nil {
+		t.Errorf("new secret can't make codes: %v", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Chat", "alice", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Chat:alice" {
+		t.Errorf("unexpected URI %s", u)
+	}
+	query := u.Query()
+	if query.Get("secret") != rfcSecret || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("unexpected parameters %s", u.RawQuery)
+	}
+}
+
+func TestBackupCodes(t *testing.T) {
+	codes, err := BackupCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 9 || code[4] != '-' {
+			t.Errorf("unexpected backup code %q", code)
+		}
+		if seen[code] {
+			t.Errorf("backup code %q repeats", code)
+		}
+		seen[code] = true
+	}
+}
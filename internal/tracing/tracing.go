@@ -0,0 +1,147 @@
+// Package tracing traces the message path — WebSocket upgrades, frame reads
+// and parsing, room routing and broadcast fan-out — with OpenTelemetry and
+// exports the spans to an OTLP collector. W3C trace context is taken from
+// upgrade request headers and from "traceparent" fields of frames.
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"realtime-chat/internal/config"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer of the server's spans
+const instrumentation = "realtime-chat"
+
+// shutdownTimeout bounds how long Close waits to export the last spans
+const shutdownTimeout = 5 * time.Second
+
+// enabled is set while spans are exported
+var enabled atomic.Bool
+
+// Tracing exports the server's spans
+type Tracing struct {
+	provider *sdktrace.TracerProvider
+}
+
+// Start exports spans to the OTLP collector at cfg.Endpoint over gRPC. The
+// collector is connected to in the background, so Start does not fail when
+// it is down; spans that can't be exported are logged and dropped.
+func Start(cfg config.TracingConfig) (*Tracing, error) {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(attribute.String("service.name", cfg.ServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("describing service: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Frames of a traced client request are sampled with it
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.Printf("Error exporting traces: %v", err)
+	}))
+	enabled.Store(true)
+
+	log.Printf("Exporting traces to %s (sample ratio %g)", cfg.Endpoint, cfg.SampleRatio)
+	return &Tracing{provider: provider}, nil
+}
+
+// Close exports the spans still buffered and stops exporting
+func (t *Tracing) Close() {
+	enabled.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := t.provider.Shutdown(ctx); err != nil {
+		log.Printf("Error flushing traces: %v", err)
+	}
+}
+
+// Enabled reports whether spans are exported
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Tracer returns the tracer of the server's spans. Its spans are not
+// recorded unless Start was called.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// ChildSpan starts a span under the one ctx carries. Without one it starts
+// nothing, so work outside a traced path, such as join notices, adds no
+// traces of its own.
+func ChildSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// Link links a span to another, such as a frame to its client's connection,
+// unless sc is empty
+func Link(sc trace.SpanContext) trace.SpanStartOption {
+	if !sc.IsValid() {
+		return trace.WithLinks()
+	}
+	return trace.WithLinks(trace.Link{SpanContext: sc})
+}
+
+// FromHeaders returns ctx carrying the trace context of HTTP request headers
+func FromHeaders(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// FromFrame returns ctx carrying the trace context a client put in a frame's
+// optional "traceparent" and "tracestate" fields. Frames are only inspected
+// while spans are exported.
+func FromFrame(ctx context.Context, frame []byte) context.Context {
+	if !Enabled() {
+		return ctx
+	}
+
+	var carrier struct {
+		Traceparent string `json:"traceparent"`
+		Tracestate  string `json:"tracestate"`
+	}
+	if err := json.Unmarshal(frame, &carrier); err != nil || carrier.Traceparent == "" {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier{
+		"traceparent": carrier.Traceparent,
+		"tracestate":  carrier.Tracestate,
+	})
+}
@@ -0,0 +1,112 @@
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// libre translates through the LibreTranslate API
+type libre struct {
+	url string
+	key string
+}
+
+// newLibre returns a LibreTranslate provider at url, or at libretranslate.com
+// when url is empty
+func newLibre(url, key string) *libre {
+	if url == "" {
+		url = "https://libretranslate.com"
+	}
+	return &libre{url: url, key: key}
+}
+
+func (l *libre) request(ctx context.Context, text, language string) (*http.Request, error) {
+	// LibreTranslate names languages by their base code
+	language, _, _ = strings.Cut(language, "-")
+	body, err := json.Marshal(map[string]string{
+		"q":       text,
+		"source":  "auto",
+		"target":  language,
+		"format":  "text",
+		"api_key": l.key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func (l *libre) parse(body io.Reader) (string, string, error) {
+	var response struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return "", "", err
+	}
+	return response.TranslatedText, response.DetectedLanguage.Language, nil
+}
+
+// deepl translates through the DeepL v2 API
+type deepl struct {
+	url string
+	key string
+}
+
+// newDeepL returns a DeepL provider at url, or at the free or pro API as the
+// key suits when url is empty. Keys of the free API end in ":fx".
+func newDeepL(url, key string) *deepl {
+	if url == "" {
+		url = "https://api.deepl.com"
+		if strings.HasSuffix(key, ":fx") {
+			url = "https://api-free.deepl.com"
+		}
+	}
+	return &deepl{url: url, key: key}
+}
+
+func (d *deepl) request(ctx context.Context, text, language string) (*http.Request, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"text":        []string{text},
+		"target_lang": strings.ToUpper(language),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url+"/v2/translate", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+d.key)
+	return req, nil
+}
+
+func (d *deepl) parse(body io.Reader) (string, string, error) {
+	var response struct {
+		Translations []struct {
+			Text                   string `json:"text"`
+			DetectedSourceLanguage string `json:"detected_source_language"`
+		} `json:"translations"`
+	}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return "", "", err
+	}
+	if len(response.Translations) == 0 {
+		return "", "", errors.New("no translation returned")
+	}
+	t := response.Translations[0]
+	return t.Text, t.DetectedSourceLanguage, nil
+}
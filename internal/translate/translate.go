@@ -0,0 +1,192 @@
+// Package translate translates message content through a translation
+// provider, LibreTranslate or DeepL, so the provider's API key stays on the
+// server. Translations are cached per language, so a message many users
+// translate alike is only sent to the provider once.
+package translate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"realtime-chat/internal/config"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxLength is the longest content translated, in bytes
+const MaxLength = 5000
+
+// maxCached bounds how many translations are cached
+const maxCached = 5000
+
+// Errors returned by translations
+var (
+	ErrDisabled = errors.New("translation is not configured")
+	ErrLanguage = errors.New("language not supported")
+	ErrTooLong  = errors.New("message is too long to translate")
+)
+
+// languagePattern matches a language code such as "de", "pt-br" or "zh-hans"
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z]{2,4})?$`)
+
+// client is shared by every request to the provider
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// Translation is a text translated to another language
+type Translation struct {
+	Text     string `json:"text"`
+	Language string `json:"language"`         // The language translated to
+	Source   string `json:"source,omitempty"` // The language the provider detected the text in
+}
+
+// provider builds the requests of one translation provider and reads its responses
+type provider interface {
+	// request returns the request translating text to a language
+	request(ctx context.Context, text, language string) (*http.Request, error)
+
+	// parse reads the translated text and the detected source language of a response
+	parse(body io.Reader) (text, source string, err error)
+}
+
+// cached is a cached translation and when it stops being used
+type cached struct {
+	translation *Translation
+	expires     time.Time
+}
+
+// Client translates through the configured provider and caches its results
+type Client struct {
+	name     string
+	provider provider
+	ttl      time.Duration
+
+	mutex sync.Mutex
+	cache map[string]cached
+}
+
+// New returns a client of the provider in cfg, or nil when translation is
+// off. A nil client reports every translation as ErrDisabled.
+func New(cfg config.TranslateConfig) *Client {
+	if cfg.URL == "" && cfg.APIKey == "" {
+		return nil
+	}
+	var p provider
+	switch cfg.Provider {
+	case config.TranslateDeepL:
+		p = newDeepL(cfg.URL, cfg.APIKey)
+	default:
+		p = newLibre(cfg.URL, cfg.APIKey)
+	}
+	return &Client{
+		name:     cfg.Provider,
+		provider: p,
+		ttl:      cfg.CacheTTL,
+		cache:    make(map[string]cached),
+	}
+}
+
+// Provider returns the name of the provider used, or "" when translation is off
+func (c *Client) Provider() string {
+	if c == nil {
+		return ""
+	}
+	return c.name
+}
+
+// Language returns a language code normalized for translation, or
+// ErrLanguage when it isn't one
+func Language(language string) (string, error) {
+	language = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(language), "_", "-"))
+	if !languagePattern.MatchString(language) {
+		return "", ErrLanguage
+	}
+	return language, nil
+}
+
+// Translate returns text translated to a language. Translations are served
+// from the cache while they are fresh.
+func (c *Client) Translate(ctx context.Context, text, language string) (*Translation, error) {
+	if c == nil {
+		return nil, ErrDisabled
+	}
+	language, err := Language(language)
+	if err != nil {
+		return nil, err
+	}
+	if len(text) > MaxLength {
+		return nil, ErrTooLong
+	}
+	sum := sha256.Sum256([]byte(text))
+	key := language + ":" + hex.EncodeToString(sum[:])
+
+	c.mutex.Lock()
+	entry, ok := c.cache[key]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.translation, nil
+	}
+
+	translation, err := c.fetch(ctx, text, language)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.evict()
+	c.cache[key] = cached{translation, time.Now().Add(c.ttl)}
+	c.mutex.Unlock()
+	return translation, nil
+}
+
+// fetch asks the provider to translate text to a language
+func (c *Client) fetch(ctx context.Context, text, language string) (*Translation, error) {
+	req, err := c.provider.request(ctx, text, language)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s: %w", c.name, err)
+	}
+	defer resp.Body.Close()
+
+	// Both providers answer an unknown target language with 400
+	if resp.StatusCode == http.StatusBadRequest {
+		return nil, ErrLanguage
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", c.name, resp.Status)
+	}
+	translated, source, err := c.provider.parse(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s response: %w", c.name, err)
+	}
+	return &Translation{Text: translated, Language: language, Source: strings.ToLower(source)}, nil
+}
+
+// evict makes room for one more translation in a full cache, dropping
+// expired ones or, when none have expired, an arbitrary one. The caller
+// must hold the lock.
+func (c *Client) evict() {
+	if len(c.cache) < maxCached {
+		return
+	}
+	now := time.Now()
+	for key, entry := range c.cache {
+		if now.After(entry.expires) {
+			delete(c.cache, key)
+		}
+	}
+	for key := range c.cache {
+		if len(c.cache) < maxCached {
+			break
+		}
+		delete(c.cache, key)
+	}
+}
@@ -0,0 +1,201 @@
+package webhook
+
+import (
+	"regexp"
+	"strings"
+)
+
+// SlackMessage is the part of a Slack incoming webhook payload the chat
+// understands, so tooling written for Slack can post to a room unchanged
+type SlackMessage struct {
+	Text        string            `json:"text"`
+	Username    string            `json:"username"` // Name the message is posted under
+	Blocks      []slackBlock      `json:"blocks"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+// slackText is a Block Kit text object or context element
+type slackText struct {
+	Type     string `json:"type"` // "plain_text", "mrkdwn" or "image"
+	Text     string `json:"text"`
+	AltText  string `json:"alt_text"`
+	ImageURL string `json:"image_url"`
+}
+
+// slackBlock is a Block Kit layout block
+type slackBlock struct {
+	Type     string      `json:"type"` // "section", "header", "context", "divider" or "image"
+	Text     *slackText  `json:"text"`
+	Fields   []slackText `json:"fields"`
+	Elements []slackText `json:"elements"`
+	Title    *slackText  `json:"title"`
+	ImageURL string      `json:"image_url"`
+	AltText  string      `json:"alt_text"`
+}
+
+// slackAttachment is a legacy message attachment
+type slackAttachment struct {
+	Fallback   string       `json:"fallback"`
+	Pretext    string       `json:"pretext"`
+	AuthorName string       `json:"author_name"`
+	Title      string       `json:"title"`
+	TitleLink  string       `json:"title_link"`
+	Text       string       `json:"text"`
+	Fields     []slackField `json:"fields"`
+	ImageURL   string       `json:"image_url"`
+	Footer     string       `json:"footer"`
+	Blocks     []slackBlock `json:"blocks"`
+}
+
+// slackField is a titled value of an attachment
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// slackLink matches Slack's <target> and <target|label> markup
+var slackLink = regexp.MustCompile(`<([^<>|]+)(?:\|([^<>]*))?>`)
+
+// slackEntities are the only HTML entities Slack escapes
+var slackEntities = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+// Render returns the message as plain chat text. Blocks replace the text,
+// as they do in Slack, and attachments follow. Unsupported blocks are skipped.
+func (m *SlackMessage) Render() string {
+	var lines []string
+	if len(m.Blocks) > 0 {
+		lines = renderBlocks(m.Blocks)
+	} else if m.Text != "" {
+		lines = append(lines, slackMarkup(m.Text))
+	}
+
+	for _, a := range m.Attachments {
+		lines = append(lines, renderAttachment(a)...)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// renderBlocks renders section, header, context, divider and image blocks
+func renderBlocks(blocks []slackBlock) []string {
+	var lines []string
+	for _, b := range blocks {
+		switch b.Type {
+		case "section", "header":
+			if text := b.Text.render(); text != "" {
+				lines = append(lines, text)
+			}
+			for _, field := range b.Fields {
+				if text := field.render(); text != "" {
+					lines = append(lines, text)
+				}
+			}
+
+		case "context":
+			var parts []string
+			for _, element := range b.Elements {
+				if text := element.render(); text != "" {
+					parts = append(parts, text)
+				}
+			}
+			if len(parts) > 0 {
+				lines = append(lines, strings.Join(parts, " | "))
+			}
+
+		case "divider":
+			lines = append(lines, "---")
+
+		case "image":
+			lines = append(lines, labelled(b.Title.render(), b.ImageURL))
+		}
+	}
+	return lines
+}
+
+// renderAttachment renders an attachment's pretext, author, title, text,
+// fields, blocks, image and footer, or its fallback when it has none of them
+func renderAttachment(a slackAttachment) []string {
+	var lines []string
+	add := func(text string) {
+		if text != "" {
+			lines = append(lines, text)
+		}
+	}
+
+	add(slackMarkup(a.Pretext))
+	add(a.AuthorName)
+	add(labelled(a.Title, a.TitleLink))
+	add(slackMarkup(a.Text))
+	for _, field := range a.Fields {
+		switch {
+		case field.Title != "" && field.Value != "":
+			add(field.Title + ": " + slackMarkup(field.Value))
+		default:
+			add(field.Title + slackMarkup(field.Value))
+		}
+	}
+	lines = append(lines, renderBlocks(a.Blocks)...)
+	add(a.ImageURL)
+	add(a.Footer)
+
+	if len(lines) == 0 {
+		add(a.Fallback)
+	}
+	return lines
+}
+
+// render returns a text object's text, converting mrkdwn, or an image's alt text
+func (t *slackText) render() string {
+	if t == nil {
+		return ""
+	}
+	switch t.Type {
+	case "plain_text":
+		return t.Text
+	case "image":
+		return t.AltText
+	default:
+		return slackMarkup(t.Text)
+	}
+}
+
+// slackMarkup converts Slack's link, mention and escaping markup to plain
+// text; emphasis such as *bold* reads fine as it is
+func slackMarkup(text string) string {
+	text = slackLink.ReplaceAllStringFunc(text, func(match string) string {
+		parts := slackLink.FindStringSubmatch(match)
+		target, label := parts[1], parts[2]
+
+		switch {
+		// Special mentions such as <!here> and <!subteam^ID|@team>
+		case strings.HasPrefix(target, "!"):
+			if label != "" {
+				return label
+			}
+			name, _, _ := strings.Cut(target[1:], "^")
+			return "@" + name
+
+		// Users and channels, such as <@U123> and <#C123|general>
+		case strings.HasPrefix(target, "@"), strings.HasPrefix(target, "#"):
+			if label != "" {
+				return target[:1] + strings.TrimPrefix(label, target[:1])
+			}
+			return target
+
+		default:
+			return labelled(label, target)
+		}
+	})
+	return slackEntities.Replace(text)
+}
+
+// labelled returns "label (url)", or whichever of the two is set
+func labelled(label, url string) string {
+	switch {
+	case label == "" || label == url:
+		return url
+	case url == "":
+		return label
+	default:
+		return label + " (" + url + ")"
+	}
+}
@@ -0,0 +1,230 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"realtime-chat/internal/store"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Event names sent in the "event" field and the X-Chat-Event header
+const (
+	MessageCreated = "message.created"
+	MessageEdited  = "message.edited"
+	MessageDeleted = "message.deleted"
+)
+
+// deliveryAttempts is how many times a delivery is tried before it is dropped
+const deliveryAttempts = 3
+
+// ErrPrivateAddress is returned for webhooks on loopback, link-local,
+// private or otherwise internal addresses, which room admins mustn't be
+// able to make the server post to
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+// internalRanges are ranges outside the ones netip classifies that are
+// still not reachable on the internet
+var internalRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // Benchmarking
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64, which can reach IPv4 private ranges
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// Public reports whether ip is an address on the internet a webhook may post to
+func Public(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range internalRanges {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckURL reports whether raw can be a webhook: an http or https URL
+// whose host isn't an internal address. Host names are checked again for
+// every delivery once they are resolved.
+func CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("the webhook must be an http or https URL")
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateAddress
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !Public(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// dialPublic refuses connections to internal addresses. It runs after
+// host names are resolved, so names pointing at internal addresses, and
+// redirects to them, are refused too.
+func dialPublic(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !Public(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// PublicTransport returns a transport that only connects to public
+// addresses, for requests to URLs that users or other servers choose. It
+// never uses a proxy, so the dialer sees the address it connects to.
+func PublicTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: 5 * time.Second, Control: dialPublic}).DialContext
+	return transport
+}
+
+// Snapshot is a message's content at one point in time
+type Snapshot struct {
+	Content string `json:"content"`
+
+	// Set when Content is end-to-end encrypted ciphertext
+	Encryption *store.Encryption `json:"encryption,omitempty"`
+
+	// Set when the message posts a GIF, whose URL is Content
+	GIF *store.GIF `json:"gif,omitempty"`
+}
+
+// Event is the payload posted to a room's webhook
+type Event struct {
+	Event     string    `json:"event"`
+	RoomID    string    `json:"roomId"`
+	MessageID string    `json:"messageId"`
+	Username  string    `json:"username"` // Who made the change
+	Author    string    `json:"author"`   // Who wrote the message
+	Before    *Snapshot `json:"before,omitempty"`
+	After     *Snapshot `json:"after,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// delivery is an event waiting to be posted
+type delivery struct {
+	url    string
+	secret string
+	event  *Event
+}
+
+// Dispatcher posts events to webhooks in the background. A single worker
+// delivers events in the order they were sent so receivers can mirror
+// creations, edits and deletions in sequence.
+type Dispatcher struct {
+	client *http.Client
+	queue  chan *delivery
+	ctx    context.Context
+}
+
+// NewDispatcher starts a dispatcher that stops when ctx is cancelled
+func NewDispatcher(ctx context.Context) *Dispatcher {
+	d := &Dispatcher{
+		client: &http.Client{Timeout: 5 * time.Second, Transport: PublicTransport()},
+		queue:  make(chan *delivery, 1024),
+		ctx:    ctx,
+	}
+	go d.run()
+	return d
+}
+
+// Send queues an event for delivery to url, signed with secret. Events are
+// dropped if the queue is full so a slow receiver can't stall chat traffic.
+func (d *Dispatcher) Send(url, secret string, event *Event) {
+	select {
+	case d.queue <- &delivery{url: url, secret: secret, event: event}:
+	default:
+		log.Printf("Webhook queue full, dropping %s for room %s", event.Event, event.RoomID)
+	}
+}
+
+// run delivers queued events until the dispatcher's context is cancelled
+func (d *Dispatcher) run() {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case del := <-d.queue:
+			d.deliver(del)
+		}
+	}
+}
+
+// deliver posts an event, retrying with a growing delay on failure
+func (d *Dispatcher) deliver(del *delivery) {
+	body, err := json.Marshal(del.event)
+	if err != nil {
+		log.Printf("Error encoding webhook event: %v", err)
+		return
+	}
+
+	for attempt := 1; attempt <= deliveryAttempts; attempt++ {
+		err = d.post(del, body)
+		if err == nil {
+			return
+		}
+		if attempt == deliveryAttempts {
+			break
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+	log.Printf("Error delivering %s for room %s: %v", del.event.Event, del.event.RoomID, err)
+}
+
+// post sends a single delivery attempt
+func (d *Dispatcher) post(del *delivery, body []byte) error {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, del.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Chat-Event", del.event.Event)
+	req.Header.Set("X-Chat-Signature", "sha256="+Sign(del.secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body with secret, as sent in X-Chat-Signature
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// A widely published HMAC-SHA256 test value
+	got := Sign("key", []byte("The quick brown fox jumps over the lazy dog"))
+	if want := "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"; got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	if Sign("other", []byte("body")) == Sign("key", []byte("body")) {
+		t.Error("signature doesn't depend on the secret")
+	}
+}
+
+func TestDeliverySignature(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	received := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- request{r.Header, body}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := NewDispatcher(ctx)
+	// The test server is on loopback, which deliveries normally refuse
+	d.client = srv.Client()
+
+	d.Send(srv.URL, "s3cret", &Event{Event: "message.created", RoomID: "room_1", MessageID: "m1"})
+	select {
+	case r := <-received:
+		if r.header.Get("X-Chat-Event") != "message.created" {
+			t.Errorf("X-Chat-Event is %q", r.header.Get("X-Chat-Event"))
+		}
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(r.body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.header.Get("X-Chat-Signature") != want {
+			t.Errorf("X-Chat-Signature is %q, want %q", r.header.Get("X-Chat-Signature"), want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event wasn't delivered")
+	}
+}
+
+func TestPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::1":   true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false, // Cloud metadata
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"198.18.0.1":           false,
+		"224.0.0.1":            false,
+		"::1":                  false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"64:ff9b::a00:1":       false,
+		"::ffff:93.184.216.34": true,
+		"255.255.255.255":      false,
+		"2001:db8::1":          true, // Documentation, but not internal
+		"fc00::1":              false,
+		"64:ff9b:1::1":         false,
+		"192.0.0.8":            false,
+		"100.127.255.255":      false,
+		"100.128.0.0":          true,
+	} {
+		if got := Public(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Public(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for raw, want := range map[string]error{
+		"https://hooks.example.com/x": nil,
+		"http://93.184.216.34:8080/":  nil,
+		"http://localhost/":           ErrPrivateAddress,
+		"http://api.localhost./":      ErrPrivateAddress,
+		"http://127.0.0.1:8080/":      ErrPrivateAddress,
+		"http://[::1]/":               ErrPrivateAddress,
+		"http://169.254.169.254/meta": ErrPrivateAddress,
+		"http://[::ffff:10.0.0.1]/":   ErrPrivateAddress,
+	} {
+		if err := CheckURL(raw); !errors.Is(err, want) {
+			t.Errorf("CheckURL(%s) = %v, want %v", raw, err, want)
+		}
+	}
+	for _, raw := range []string{"ftp://example.com/", "file:///etc/passwd", "example.com", "http://", "gopher://x"} {
+		if err := CheckURL(raw); err == nil || errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("CheckURL(%s) = %v, want a URL error", raw, err)
+		}
+	}
+}
+
+func TestDeliveryRefusesInternalAddresses(t *testing.T) {
+	// Stands in for a host name that resolves to an internal address, which
+	// CheckURL can't see when the webhook is set
+	hit := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		hit <- struct{}{}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := NewDispatcher(ctx)
+	err := d.post(&delivery{url: srv.URL, secret: "s", event: &Event{Event: "message.created"}}, []byte("{}"))
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("delivery to %s: %v, want %v", srv.URL, err, ErrPrivateAddress)
+	}
+	select {
+	case <-hit:
+		t.Fatal("internal address was posted to")
+	default:
+	}
+}
+
+func TestDialPublic(t *testing.T) {
+	if err := dialPublic("tcp4", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("public address refused: %v", err)
+	}
+	for _, address := range []string{"127.0.0.1:80", "[::1]:443", "10.0.0.1:80", "169.254.169.254:80"} {
+		if err := dialPublic("tcp", address, nil); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("dial to %s: %v, want %v", address, err, ErrPrivateAddress)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"realtime-chat/internal/auth"
+	"realtime-chat/internal/hub"
+)
+
+// errKeyRateLimited is returned when an API key connects over its rate limit
+var errKeyRateLimited = errors.New("API key rate limit exceeded, try again later")
+
+// readFrameTypes are the frames a client connected with an API key may send
+// without the post scope
+var readFrameTypes = map[string]bool{
+	"hello":     true,
+	"join":      true,
+	"leave":     true,
+	"list":      true,
+	"discover":  true,
+	"who":       true,
+	"load_more": true,
+	"translate": true,
+
+	"conversations": true,
+	"dm_history":    true,
+	"groups":        true,
+}
+
+// connectingKey returns the API key a connection is made with, or nil when
+// token isn't one. The connection counts towards the key's rate limit.
+func connectingKey(h *hub.Hub, token string) (*auth.APIKey, error) {
+	if !auth.IsAPIKey(token) {
+		return nil, nil
+	}
+	key, ok := h.Auth.APIKey(token)
+	switch {
+	case !ok:
+		return nil, errors.New("Invalid API key")
+	case !key.Allows(auth.ScopeRead):
+		return nil, errors.New("Connecting needs an API key with the read scope")
+	case !h.AllowKeyRequest(key):
+		return nil, errKeyRateLimited
+	}
+	return key, nil
+}
+
+// allowKeyFrame reports whether a client may send a frame of frameType,
+// telling it why not. Clients connected with an API key need its post
+// scope for anything but reading, and every frame counts towards the key's
+// rate limit.
+func allowKeyFrame(c *hub.Client, frameType string) bool {
+	key := c.APIKey
+	if key == nil {
+		return true
+	}
+	if !readFrameTypes[frameType] && !key.Allows(auth.ScopePost) {
+		sendPermissionError(c, "This API key can only read; posting needs the post scope")
+		return false
+	}
+	if !c.Hub.AllowKeyRequest(key) {
+		sendRoomError(c, fmt.Sprintf("This API key can make %d requests a minute; wait a moment", c.Hub.KeyRateLimit(key)))
+		return false
+	}
+	return true
+}
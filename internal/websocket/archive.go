@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"errors"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/room"
+)
+
+// ErrArchivedRoom is returned when a message is posted to an archived room
+var ErrArchivedRoom = errors.New("room is archived")
+
+// handleArchive archives the client's room, making it read-only and leaving
+// it out of the room list, or restores it. Only room admins can do either.
+func handleArchive(c *hub.Client, action RoomAction) {
+	r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+	if !exists {
+		sendRoomError(c, "You are not in a room")
+		return
+	}
+	if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermManageRoom) {
+		sendPermissionError(c, "Only room admins can archive the room")
+		return
+	}
+	if room.IsLobby(r.ID) {
+		sendRoomError(c, "The lobby can't be archived")
+		return
+	}
+
+	archive := action.Type == "archive"
+	if r.IsArchived() == archive {
+		if archive {
+			sendRoomError(c, "The room is already archived")
+		} else {
+			sendRoomError(c, "The room is not archived")
+		}
+		return
+	}
+
+	c.Hub.ArchiveRoom(r, archive, c.Username, action.Reason)
+}
+
+// rejectArchived tells the client an archived room is read-only, reporting
+// whether it was
+func rejectArchived(c *hub.Client, r *room.Room) bool {
+	if !r.IsArchived() {
+		return false
+	}
+	sendPermissionError(c, "This room is archived and read-only")
+	return true
+}
@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/profile"
+	"strings"
+)
+
+// BlockAction represents a user blocking or unblocking another, or listing
+// who they block
+type BlockAction struct {
+	Type     string `json:"type"` // "block", "unblock" or "blocked"
+	Username string `json:"username,omitempty"`
+}
+
+// blockActionTypes lists the message types handled as block actions
+var blockActionTypes = map[string]bool{
+	"block":   true,
+	"unblock": true,
+	"blocked": true,
+}
+
+// handleBlockAction changes the client's block list, whose users' messages,
+// direct messages and mentions no longer reach them, and replies with the
+// list
+func handleBlockAction(c *hub.Client, action BlockAction) {
+	if action.Type != "blocked" {
+		username := strings.TrimSpace(action.Username)
+		if username == "" {
+			sendRoomError(c, "A username is required")
+			return
+		}
+
+		var err error
+		if action.Type == "block" {
+			err = c.Hub.Profiles.Block(c.Username, username)
+		} else {
+			err = c.Hub.Profiles.Unblock(c.Username, username)
+		}
+		switch {
+		case errors.Is(err, profile.ErrBlockSelf), errors.Is(err, profile.ErrTooManyBlocked):
+			sendRoomError(c, err.Error())
+			return
+		case err != nil:
+			log.Printf("Error updating the block list of %s: %v", c.Username, err)
+			sendRoomError(c, "Could not update your block list")
+			return
+		}
+	}
+
+	response, _ := json.Marshal(map[string]interface{}{
+		"type":    "blocked_users",
+		"blocked": c.Hub.Profiles.Blocked(c.Username),
+	})
+	c.Send <- response
+}
+
+// hideBlocked removes the messages of users the client blocks from a page of
+// history, adding to the response how many were hidden and the IDs the page
+// began and ended with, so the client can keep paging past them
+func hideBlocked(c *hub.Client, page []*history.Message, response map[string]interface{}) []*history.Message {
+	shown := page[:0:0]
+	for _, msg := range page {
+		if !c.Hub.Profiles.Blocks(c.Username, msg.Username) {
+			shown = append(shown, msg)
+		}
+	}
+	if hidden := len(page) - len(shown); hidden > 0 {
+		response["hidden"] = hidden
+		response["firstId"] = page[0].ID
+		response["lastId"] = page[len(page)-1].ID
+	}
+	return shown
+}
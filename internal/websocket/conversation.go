@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/conversation"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+)
+
+// ConversationAction represents a client listing its direct message
+// conversations, loading a page of one or marking one as read
+type ConversationAction struct {
+	Type   string `json:"type"`             // "conversations", "dm_history" or "dm_read"
+	With   string `json:"with,omitempty"`   // The other user of the conversation
+	Before string `json:"before,omitempty"` // Load the messages just before this message ID
+	After  string `json:"after,omitempty"`  // Load the messages just after this message ID
+	Limit  int    `json:"limit,omitempty"`  // Messages in the page, history.DefaultPageSize when omitted
+}
+
+// conversationActionTypes lists the message types handled as conversation actions
+var conversationActionTypes = map[string]bool{
+	"conversations": true,
+	"dm_history":    true,
+	"dm_read":       true,
+}
+
+// handleConversationAction answers a conversation action
+func handleConversationAction(c *hub.Client, action ConversationAction) {
+	if action.Type == "conversations" {
+		sendConversations(c)
+		return
+	}
+
+	if action.With == "" {
+		sendDirectError(c, "", "The other user is required")
+		return
+	}
+
+	if action.Type == "dm_read" {
+		c.Hub.Conversations.Read(c.Workspace, c.Username, action.With)
+		sendConversations(c)
+		return
+	}
+
+	if action.Before != "" && action.After != "" {
+		sendDirectError(c, action.With, "Give either before or after, not both")
+		return
+	}
+	messages, more, err := c.Hub.Conversations.Page(c.Workspace, c.Username, action.With, action.Before, action.After, action.Limit)
+	if errors.Is(err, history.ErrNotFound) {
+		sendDirectError(c, action.With, "Message not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading direct messages of %s with %s: %v", c.Username, action.With, err)
+		sendDirectError(c, action.With, "Could not load messages")
+		return
+	}
+
+	response := map[string]interface{}{
+		"type":           "dm_history",
+		"with":           action.With,
+		"conversationId": conversation.ID(c.Workspace, c.Username, action.With),
+		"hasMore":        more,
+	}
+	response["messages"] = hideBlocked(c, messages, response)
+	if action.Before != "" {
+		response["before"] = action.Before
+	}
+	if action.After != "" {
+		response["after"] = action.After
+	}
+	responseJSON, _ := json.Marshal(response)
+	c.Send <- responseJSON
+}
+
+// sendConversations sends the client its conversations in its workspace, the
+// most recently active first
+func sendConversations(c *hub.Client) {
+	response, _ := json.Marshal(map[string]interface{}{
+		"type":          "conversations",
+		"conversations": c.Hub.Conversations.List(c.Workspace, c.Username),
+	})
+	c.Send <- response
+}
+
+// sendDirectError tells the client a direct message request failed
+func sendDirectError(c *hub.Client, with, message string) {
+	response := map[string]interface{}{
+		"type":    "dm_error",
+		"message": message,
+	}
+	if with != "" {
+		response["to"] = with
+	}
+	responseJSON, _ := json.Marshal(response)
+	c.Send <- responseJSON
+}
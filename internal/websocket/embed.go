@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"realtime-chat/internal/auth"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/workspace"
+)
+
+// embedFrameTypes are the frames a client of an embedded chat widget may
+// send. It chats in its room as any guest would, but can't go elsewhere.
+var embedFrameTypes = map[string]bool{
+	"hello":      true,
+	"join":       true,
+	"message":    true,
+	"gif":        true,
+	"edit":       true,
+	"delete":     true,
+	"react":      true,
+	"unreact":    true,
+	"poll_vote":  true,
+	"report":     true,
+	"who":        true,
+	"load_more":  true,
+	"translate":  true,
+	"set_status": true,
+}
+
+// connectingEmbed returns the guest a connection is made as with an embedded
+// chat widget's session token and the room it is limited to, or "" when the
+// token isn't one.
+// Such tokens only connect from the embed page or the site they were issued
+// to, and only while the room is still embedded there.
+func connectingEmbed(h *hub.Hub, r *http.Request) (username, roomID string, err error) {
+	username, origin, roomID, ok := h.Auth.EmbedSession(auth.Token(r))
+	if !ok {
+		return "", "", nil
+	}
+	if from := r.Header.Get("Origin"); from != origin {
+		if u, err := url.Parse(from); err != nil || u.Host != r.Host {
+			return "", "", errors.New("This chat widget's token belongs to another site")
+		}
+	}
+	rm, exists := h.RoomManager.GetRoom(roomID)
+	if !exists || rm.Workspace != workspace.FromContext(r.Context()) || !rm.Embeddable(origin) || rm.IsEncrypted() || rm.IsGroup() {
+		return "", "", errors.New("This room is no longer embedded in your site")
+	}
+	return username, roomID, nil
+}
+
+// allowEmbedFrame reports whether a client may send a frame, telling it why
+// not. Clients of an embedded chat widget stay in their room.
+func allowEmbedFrame(c *hub.Client, action RoomAction) bool {
+	if c.EmbedRoom == "" {
+		return true
+	}
+	allowed := embedFrameTypes[action.Type]
+	if action.Type == "join" {
+		r, exists := c.Hub.Room(c.Workspace, action.RoomID)
+		allowed = exists && r.ID == c.EmbedRoom
+	}
+	if !allowed {
+		sendPermissionError(c, "This chat widget only chats in its room")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/store"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// benchMessage is a chat message as rooms broadcast it
+var benchMessage = RoomMessage{
+	ID:        "msg_20240101000000_AbCdEf",
+	Type:      "message",
+	Username:  "loadgen",
+	Content:   strings.Repeat("hello ", 20),
+	Timestamp: "2024-01-01T00:00:00Z",
+	RoomID:    room.LobbyID,
+	Seq:       42,
+}
+
+// BenchmarkMarshalMessage encodes a chat message. encoding/json already
+// pools its encoding buffers, so frames are encoded with json.Marshal; each
+// needs its own allocation anyway, since it is shared by every client it is
+// queued for.
+func BenchmarkMarshalMessage(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(benchMessage); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDelivery broadcasts chat messages to the lobby with one client
+// connected over a real WebSocket connection, and waits until the client
+// has read them all. The allocations include the room fan-out and the
+// write path, which borrows a pooled buffer only while writing, as well as
+// the client's reads.
+func BenchmarkDelivery(b *testing.B) {
+	// The hub logs every connection and join
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	st, err := store.NewMemoryStore(filepath.Join(b.TempDir(), "snapshot.json"), 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	h := hub.NewHub(context.Background(), config.Default(), st)
+	go h.Run()
+	defer h.Stop()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(h, w, r)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?username=reader", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	frame, err := json.Marshal(benchMessage)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// Count the benchmark's frames, which may arrive several to a message
+	received := make(chan int, 1024)
+	go func() {
+		var buf bytes.Buffer
+		for {
+			_, r, err := conn.NextReader()
+			if err == nil {
+				buf.Reset()
+				_, err = buf.ReadFrom(r)
+			}
+			if err != nil {
+				close(received)
+				return
+			}
+			if n := bytes.Count(buf.Bytes(), []byte(`"seq":42`)); n > 0 {
+				received <- n
+			}
+		}
+	}()
+
+	// Wait until the client is in the lobby
+	for {
+		if r, ok := h.RoomManager.GetRoom(room.LobbyID); ok && r.GetClientCount() == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Keep at most half a send queue of frames in flight so none is dropped
+	inFlight := h.Connection().SendQueueSize / 2
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.RoomManager.BroadcastToRoom(room.LobbyID, frame, nil)
+
+		if (i+1)%inFlight == 0 || i == b.N-1 {
+			for want := i%inFlight + 1; want > 0; {
+				select {
+				case n, ok := <-received:
+					if !ok {
+						b.Fatal("connection closed")
+					}
+					want -= n
+				case <-time.After(5 * time.Second):
+					b.Fatalf("%d frames not delivered", want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"encoding/json"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/room"
+	"strings"
+	"time"
+)
+
+// GroupAction represents a client creating, growing, leaving or listing
+// group conversations
+type GroupAction struct {
+	Type         string   `json:"type"`                   // "create_group", "add_to_group", "leave_group" or "groups"
+	RoomID       string   `json:"roomId,omitempty"`       // The group to add to or leave; the client's room when omitted
+	RoomName     string   `json:"roomName,omitempty"`     // Name of a new group; named after its participants when omitted
+	Participants []string `json:"participants,omitempty"` // Users to start a group with or add to it
+	Encrypted    bool     `json:"encrypted,omitempty"`    // End-to-end encrypt a new group's messages
+}
+
+// groupActionTypes lists the message types handled as group actions
+var groupActionTypes = map[string]bool{
+	"create_group": true,
+	"add_to_group": true,
+	"leave_group":  true,
+	"groups":       true,
+}
+
+// handleGroupAction applies a group action
+func handleGroupAction(c *hub.Client, action GroupAction) {
+	switch action.Type {
+	case "create_group":
+		createGroup(c, action)
+
+	case "add_to_group":
+		r, ok := clientGroup(c, action.RoomID)
+		if !ok {
+			return
+		}
+		participants, ok := groupParticipants(c, action.Participants)
+		if !ok {
+			return
+		}
+		added, err := c.Hub.AddToGroup(r, c.Username, participants)
+		if err != nil {
+			sendRoomError(c, err.Error())
+			return
+		}
+		if len(added) > 0 {
+			announceParticipants(c, r, map[string]interface{}{"added": added})
+		}
+
+	case "leave_group":
+		r, ok := clientGroup(c, action.RoomID)
+		if !ok {
+			return
+		}
+		remaining, _ := r.RemoveParticipant(c.Username)
+
+		response, _ := json.Marshal(map[string]interface{}{
+			"type":   "group_left",
+			"roomId": r.ID,
+		})
+		c.Send <- response
+
+		if c.RoomID == r.ID {
+			handleRoomAction(c, RoomAction{Type: "leave"})
+		}
+		if remaining == 0 {
+			// Nobody is left to see the group
+			c.Hub.RoomManager.DeleteRoomAsync(r.ID)
+			return
+		}
+		announceParticipants(c, r, map[string]interface{}{"left": c.Username})
+
+	case "groups":
+		response, _ := json.Marshal(map[string]interface{}{
+			"type":   "groups",
+			"groups": c.Hub.Groups(c.Workspace, c.Username),
+		})
+		c.Send <- response
+	}
+}
+
+// createGroup starts a group conversation among the client and the users
+// in the action, and joins the client to it
+func createGroup(c *hub.Client, action GroupAction) {
+	if !c.Hub.Roles.Can(c.Username, "", rbac.PermDirectMessage) {
+		sendPermissionError(c, deniedMessage(c, "Your role does not allow direct messages", "Guests can't start group conversations; sign in to start one"))
+		return
+	}
+	participants, ok := groupParticipants(c, action.Participants)
+	if !ok {
+		return
+	}
+	if len(participants) >= room.MaxGroupParticipants {
+		sendRoomError(c, room.ErrGroupFull.Error())
+		return
+	}
+
+	name := strings.TrimSpace(action.RoomName)
+	if name == "" {
+		name = room.GroupName(append(participants, c.Username))
+	}
+	roomID := c.Hub.RoomManager.CreateGroupAsync(c.Workspace, name, c.Username, action.Encrypted)
+
+	response, _ := json.Marshal(map[string]interface{}{
+		"type":         "group_created",
+		"roomId":       roomID,
+		"roomName":     name,
+		"participants": append(participants, c.Username),
+		"encrypted":    action.Encrypted,
+	})
+	c.Send <- response
+
+	// The manager has registered the group once the join is handled
+	handleRoomAction(c, RoomAction{Type: "join", RoomID: roomID})
+	if r, exists := c.Hub.RoomManager.GetRoom(roomID); exists {
+		c.Hub.AddToGroup(r, c.Username, participants)
+	}
+}
+
+// clientGroup returns the group conversation with an ID, or the client's
+// room when the ID is empty, telling the client if it isn't a group they
+// are in
+func clientGroup(c *hub.Client, roomID string) (*room.Room, bool) {
+	if roomID == "" {
+		roomID = c.RoomID
+	}
+	r, exists := c.Hub.Room(c.Workspace, roomID)
+	if !exists || !r.Visible(c.Username) {
+		sendRoomError(c, "Room not found")
+		return nil, false
+	}
+	if !r.IsGroup() {
+		sendRoomError(c, room.ErrNotGroup.Error())
+		return nil, false
+	}
+	return r, true
+}
+
+// groupParticipants returns the distinct valid usernames other than the
+// client's among those given, telling the client if there are none
+func groupParticipants(c *hub.Client, usernames []string) ([]string, bool) {
+	seen := map[string]bool{c.Username: true}
+	var participants []string
+	for _, username := range usernames {
+		username = strings.TrimSpace(username)
+		if seen[username] {
+			continue
+		}
+		if !hub.ValidUsername(username) {
+			sendRoomError(c, "Participants must be valid usernames")
+			return nil, false
+		}
+		seen[username] = true
+		participants = append(participants, username)
+	}
+	if len(participants) == 0 {
+		sendRoomError(c, "Name at least one other participant")
+		return nil, false
+	}
+	return participants, true
+}
+
+// announceParticipants tells everyone in a group conversation who is in it
+// now, with the change made by the client
+func announceParticipants(c *hub.Client, r *room.Room, change map[string]interface{}) {
+	event := map[string]interface{}{
+		"type":         "group_participants",
+		"roomId":       r.ID,
+		"participants": r.GetParticipants(),
+		"username":     c.Username,
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}
+	for key, value := range change {
+		event[key] = value
+	}
+	eventJSON, _ := json.Marshal(event)
+	c.Hub.RoomManager.BroadcastToRoom(r.ID, eventJSON, nil)
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+)
+
+// HistoryAction represents a client asking for a page of its room's history
+type HistoryAction struct {
+	Type     string `json:"type"`               // "load_more"
+	Before   string `json:"before,omitempty"`   // Load the messages just before this message ID
+	After    string `json:"after,omitempty"`    // Load the messages just after this message ID
+	AfterSeq *int64 `json:"afterSeq,omitempty"` // Load the messages posted after this sequence number, to fill a gap
+	Limit    int    `json:"limit,omitempty"`    // Messages in the page, history.DefaultPageSize when omitted
+}
+
+// handleLoadMore sends the client a page of its current room's history, so
+// it can scroll back (or catch up) without loading the whole room
+func handleLoadMore(c *hub.Client, action HistoryAction) {
+	if c.RoomID == "" {
+		sendRoomError(c, "Join a room to load its history")
+		return
+	}
+	if action.Before != "" && action.After != "" {
+		sendRoomError(c, "Give either before or after, not both")
+		return
+	}
+	if action.AfterSeq != nil {
+		if action.Before != "" || action.After != "" {
+			sendRoomError(c, "afterSeq can't be combined with before or after")
+			return
+		}
+		handleCatchUp(c, *action.AfterSeq, action.Limit)
+		return
+	}
+
+	messages, more, err := c.Hub.HistoryPage(context.Background(), c.RoomID, action.Before, action.After, action.Limit)
+	if errors.Is(err, history.ErrNotFound) {
+		sendRoomError(c, "Message not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading history page for %s: %v", c.RoomID, err)
+		sendRoomError(c, "Could not load history")
+		return
+	}
+
+	response := map[string]interface{}{
+		"type":    "history_page",
+		"roomId":  c.RoomID,
+		"hasMore": more,
+	}
+	response["messages"] = hideBlocked(c, messages, response)
+	if action.Before != "" {
+		response["before"] = action.Before
+	}
+	if action.After != "" {
+		response["after"] = action.After
+	}
+	responseJSON, _ := json.Marshal(response)
+	c.Send <- responseJSON
+}
+
+// handleCatchUp sends the client the messages of its current room posted
+// after a sequence number, so it can fill a gap in the messages it received
+func handleCatchUp(c *hub.Client, afterSeq int64, limit int) {
+	if afterSeq < 0 {
+		sendRoomError(c, "afterSeq must not be negative")
+		return
+	}
+
+	messages, lastSeq, more, err := c.Hub.HistorySince(context.Background(), c.RoomID, afterSeq, limit)
+	if err != nil {
+		log.Printf("Error loading history since %d for %s: %v", afterSeq, c.RoomID, err)
+		sendRoomError(c, "Could not load history")
+		return
+	}
+
+	response := map[string]interface{}{
+		"type":     "history_page",
+		"roomId":   c.RoomID,
+		"hasMore":  more,
+		"afterSeq": afterSeq,
+		"lastSeq":  lastSeq,
+	}
+	response["messages"] = hideBlocked(c, messages, response)
+	responseJSON, _ := json.Marshal(response)
+	c.Send <- responseJSON
+}
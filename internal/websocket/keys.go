@@ -0,0 +1,143 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/e2ee"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/store"
+)
+
+// maxKeyQueryUsers is the most users whose devices one keys_query returns
+const maxKeyQueryUsers = 100
+
+// ErrEncryptedRoom is returned when the server is asked to post plaintext
+// to an end-to-end encrypted room
+var ErrEncryptedRoom = errors.New("room is end-to-end encrypted")
+
+// KeyAction publishes, looks up or claims the public keys devices use to set
+// up end-to-end encrypted sessions
+type KeyAction struct {
+	Type string `json:"type"` // "keys_upload", "keys_query", "keys_claim", "keys_remove"
+
+	// The device's keys, used by "keys_upload"; DeviceID also names the
+	// device to "keys_remove"
+	DeviceID     string         `json:"deviceId,omitempty"`
+	IdentityKey  string         `json:"identityKey,omitempty"`
+	SigningKey   string         `json:"signingKey,omitempty"`
+	SignedPreKey store.PreKey   `json:"signedPreKey"`
+	OneTimeKeys  []store.PreKey `json:"oneTimeKeys,omitempty"`
+
+	Usernames []string `json:"usernames,omitempty"` // Users whose devices to list, used by "keys_query"
+	Username  string   `json:"username,omitempty"`  // User whose devices to start sessions with, used by "keys_claim"
+}
+
+// keyActionTypes lists the message types handled as key actions
+var keyActionTypes = map[string]bool{
+	"keys_upload": true,
+	"keys_query":  true,
+	"keys_claim":  true,
+	"keys_remove": true,
+}
+
+// handleKeyAction publishes, lists, claims or removes device keys for the
+// client's user. The server only handles public keys; clients encrypt and
+// decrypt everything themselves.
+func handleKeyAction(c *hub.Client, action KeyAction) {
+	var (
+		reply map[string]interface{}
+		err   error
+	)
+
+	switch action.Type {
+	case "keys_upload":
+		var device *e2ee.Device
+		device, err = c.Hub.Keys.Upload(c.Username, &store.DeviceKeysRecord{
+			DeviceID:     action.DeviceID,
+			IdentityKey:  action.IdentityKey,
+			SigningKey:   action.SigningKey,
+			SignedPreKey: action.SignedPreKey,
+			OneTimeKeys:  action.OneTimeKeys,
+		})
+		reply = map[string]interface{}{"type": "keys_uploaded", "device": device}
+
+	case "keys_query":
+		if len(action.Usernames) == 0 || len(action.Usernames) > maxKeyQueryUsers {
+			sendKeyError(c, "Between 1 and 100 usernames are required")
+			return
+		}
+		devices := make(map[string][]*e2ee.Device, len(action.Usernames))
+		for _, username := range action.Usernames {
+			devices[username] = c.Hub.Keys.Devices(username)
+		}
+		reply = map[string]interface{}{"type": "keys", "devices": devices}
+
+	case "keys_claim":
+		if action.Username == "" {
+			sendKeyError(c, "A username is required")
+			return
+		}
+		var bundles []*e2ee.Bundle
+		bundles, err = c.Hub.Keys.Claim(action.Username)
+		reply = map[string]interface{}{"type": "keys_claimed", "username": action.Username, "bundles": bundles}
+
+	case "keys_remove":
+		err = c.Hub.Keys.Remove(c.Username, action.DeviceID)
+		reply = map[string]interface{}{"type": "keys_removed", "deviceId": action.DeviceID}
+	}
+
+	switch {
+	case errors.Is(err, e2ee.ErrInvalidKeys), errors.Is(err, e2ee.ErrTooManyDevices),
+		errors.Is(err, e2ee.ErrTooManyKeys), errors.Is(err, e2ee.ErrUnknownDevice):
+		sendKeyError(c, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error handling %s for %s: %v", action.Type, c.Username, err)
+		sendKeyError(c, "Could not save keys")
+		return
+	}
+
+	replyJSON, _ := json.Marshal(reply)
+	c.Send <- replyJSON
+}
+
+// sendKeyError sends a key action error to the client
+func sendKeyError(c *hub.Client, message string) {
+	errorResponse, _ := json.Marshal(map[string]interface{}{
+		"type":    "keys_error",
+		"message": message,
+	})
+	c.Send <- errorResponse
+}
+
+// validEncryption reports whether an encryption header names its algorithm
+// and sender key and has nothing longer than a key
+func validEncryption(enc *store.Encryption) bool {
+	if enc.Algorithm == "" || enc.SenderKey == "" {
+		return false
+	}
+	for _, value := range []string{enc.Algorithm, enc.SenderKey, enc.SenderDevice, enc.SessionID, enc.RecipientKey} {
+		if len(value) > e2ee.MaxKeyLength {
+			return false
+		}
+	}
+	return true
+}
+
+// checkEncryption checks that a message is encrypted exactly when its room
+// is, telling the client what is wrong otherwise
+func checkEncryption(c *hub.Client, encrypted bool, enc *store.Encryption) bool {
+	switch {
+	case encrypted && enc == nil:
+		sendRoomError(c, "This room is end-to-end encrypted; messages must be sent as ciphertext")
+		return false
+	case !encrypted && enc != nil:
+		sendRoomError(c, "This room is not end-to-end encrypted")
+		return false
+	case enc != nil && !validEncryption(enc):
+		sendRoomError(c, "Encrypted messages need an algorithm and sender key")
+		return false
+	}
+	return true
+}
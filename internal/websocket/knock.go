@@ -0,0 +1,203 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/store"
+	"strings"
+	"time"
+)
+
+// handleSetJoinRule lets room admins choose whether anyone may join the
+// client's room or users must knock and be approved first
+func handleSetJoinRule(c *hub.Client, action RoomAction) {
+	r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+	if !exists {
+		sendRoomError(c, "You are not in a room")
+		return
+	}
+	if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermManageRoom) {
+		sendPermissionError(c, "Only room admins can change who may join")
+		return
+	}
+	rule := room.JoinRule(action.JoinRule)
+	if !room.ValidJoinRule(rule) {
+		sendRoomError(c, "The join rule must be open or knock")
+		return
+	}
+	if room.IsLobby(r.ID) || r.Mode == room.ModeSupport {
+		sendRoomError(c, "Anyone may join this room")
+		return
+	}
+	if r.IsGroup() {
+		sendRoomError(c, "Only participants may join a group conversation")
+		return
+	}
+
+	r.SetJoinRule(rule)
+	c.Hub.Audit.Record(store.AuditRecord{
+		Actor:   c.Username,
+		Action:  audit.ActionSetJoinRule,
+		RoomID:  r.ID,
+		Reason:  action.Reason,
+		Details: map[string]string{"joinRule": string(rule)},
+	})
+
+	event, _ := json.Marshal(map[string]interface{}{
+		"type":      "join_rule_changed",
+		"roomId":    r.ID,
+		"joinRule":  rule,
+		"username":  c.Username,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+	c.Hub.RoomManager.BroadcastToRoom(r.ID, event, nil)
+}
+
+// knockRequired tells a client that wants to join a room which asks to knock
+// that it must ask first, reporting whether it had to. Moderators never knock.
+func knockRequired(c *hub.Client, r *room.Room) bool {
+	if r.Admits(c.Username) || c.Hub.Roles.Can(c.Username, r.ID, rbac.PermModerate) {
+		return false
+	}
+	response, _ := json.Marshal(map[string]interface{}{
+		"type":     "knock_required",
+		"roomId":   r.ID,
+		"roomName": r.Name,
+		"message":  "This room's moderators approve who joins it; knock to ask",
+	})
+	c.Send <- response
+	return true
+}
+
+// handleKnock asks the moderators of a room that asks to knock to let the
+// client join, telling those online straight away
+func handleKnock(c *hub.Client, action RoomAction) {
+	r, exists := c.Hub.Room(c.Workspace, action.RoomID)
+	if !exists {
+		sendRoomError(c, "Room not found")
+		return
+	}
+	if r.IsBanned(c.Username) {
+		sendPermissionError(c, "You are banned from this room")
+		return
+	}
+	reason := strings.TrimSpace(action.Reason)
+	if len(reason) > room.MaxKnockReason {
+		sendRoomError(c, "A join request's message is at most 500 bytes")
+		return
+	}
+
+	knock, err := r.AddKnock(c.Username, reason)
+	switch {
+	case errors.Is(err, room.ErrNotKnockRoom), errors.Is(err, room.ErrAlreadyAdmitted):
+		sendRoomError(c, "You can join this room without asking")
+		return
+	case errors.Is(err, room.ErrAlreadyKnocked):
+		sendRoomError(c, "You have already asked to join this room")
+		return
+	case err != nil:
+		sendRoomError(c, "This room has too many join requests waiting; try again later")
+		return
+	}
+
+	c.Hub.NotifyModerators(r.ID, map[string]interface{}{
+		"type":     "join_requested",
+		"roomId":   r.ID,
+		"roomName": r.Name,
+		"knock":    knock,
+	})
+
+	response, _ := json.Marshal(map[string]interface{}{
+		"type":     "knock_sent",
+		"roomId":   r.ID,
+		"roomName": r.Name,
+		"message":  "Your request to join was sent to the room's moderators",
+	})
+	c.Send <- response
+}
+
+// handleJoinRequests lists the join requests waiting in a room, the
+// client's own unless roomId names another. Only moderators can.
+func handleJoinRequests(c *hub.Client, action RoomAction) {
+	r, ok := knockRoom(c, action)
+	if !ok {
+		return
+	}
+
+	response, _ := json.Marshal(map[string]interface{}{
+		"type":     "join_requests",
+		"roomId":   r.ID,
+		"joinRule": r.GetJoinRule(),
+		"knocks":   r.PendingKnocks(),
+	})
+	c.Send <- response
+}
+
+// handleAnswerKnock approves or denies a user's request to join a room, the
+// client's own unless roomId names another, and tells the user and the
+// room's moderators. Only moderators can.
+func handleAnswerKnock(c *hub.Client, action RoomAction) {
+	r, ok := knockRoom(c, action)
+	if !ok {
+		return
+	}
+	if action.Username == "" {
+		sendRoomError(c, "A username is required")
+		return
+	}
+
+	approve := action.Type == "approve_join"
+	if !r.ResolveKnock(action.Username, approve) {
+		sendRoomError(c, "This user hasn't asked to join")
+		return
+	}
+
+	auditAction, eventType := audit.ActionDenyJoin, "join_denied"
+	if approve {
+		auditAction, eventType = audit.ActionApproveJoin, "join_approved"
+	}
+	c.Hub.Audit.Record(store.AuditRecord{
+		Actor:  c.Username,
+		Action: auditAction,
+		Target: action.Username,
+		RoomID: r.ID,
+		Reason: action.Reason,
+	})
+
+	c.Hub.NotifyUser(action.Username, map[string]interface{}{
+		"type":      eventType,
+		"roomId":    r.ID,
+		"roomName":  r.Name,
+		"moderator": c.Username,
+	})
+	c.Hub.NotifyModerators(r.ID, map[string]interface{}{
+		"type":      "join_request_answered",
+		"roomId":    r.ID,
+		"username":  action.Username,
+		"approved":  approve,
+		"moderator": c.Username,
+	})
+}
+
+// knockRoom returns the room a join request action is about, checking the
+// client moderates it
+func knockRoom(c *hub.Client, action RoomAction) (*room.Room, bool) {
+	roomID := action.RoomID
+	if roomID == "" {
+		roomID = c.RoomID
+	}
+	r, exists := c.Hub.Room(c.Workspace, roomID)
+	if !exists {
+		sendRoomError(c, "Room not found")
+		return nil, false
+	}
+	if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermModerate) {
+		sendPermissionError(c, "Only moderators can answer join requests")
+		return nil, false
+	}
+	return r, true
+}
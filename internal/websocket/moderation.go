@@ -0,0 +1,253 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/store"
+	"strconv"
+	"time"
+)
+
+// MaxMuteDuration is the longest timed mute, in seconds
+const MaxMuteDuration = 30 * 24 * 60 * 60
+
+// handleModeration kicks, mutes (or times out) or unmutes a user, or pins or
+// unpins a message, in the client's room. Only moderators can, and only over users
+// with a lower role than their own.
+func handleModeration(c *hub.Client, action RoomAction) {
+	r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+	if !exists {
+		sendRoomError(c, "You are not in a room")
+		return
+	}
+
+	role := c.Hub.Roles.Role(c.Username, r.ID)
+	if !role.Has(rbac.PermModerate) {
+		sendPermissionError(c, "Only moderators can "+action.Type+" in this room")
+		return
+	}
+
+	switch action.Type {
+	case "pin", "unpin":
+		handlePin(c, r, action)
+		return
+	}
+
+	if action.Username == "" || action.Username == c.Username || action.Username == r.CreatedBy {
+		sendRoomError(c, "A valid username is required")
+		return
+	}
+	if !role.Outranks(c.Hub.Roles.Role(action.Username, r.ID)) {
+		sendPermissionError(c, "You can only "+action.Type+" users with a lower role than yours")
+		return
+	}
+
+	entry := store.AuditRecord{
+		Actor:  c.Username,
+		Action: action.Type,
+		Target: action.Username,
+		RoomID: r.ID,
+		Reason: action.Reason,
+	}
+
+	var response map[string]interface{}
+	switch action.Type {
+	case "kick":
+		if room.IsLobby(r.ID) {
+			sendRoomError(c, "Users can't be kicked from the lobby")
+			return
+		}
+
+		// Kicked users must knock again to return to a room that asks to
+		r.Unapprove(action.Username)
+		kicked := r.Kick(action.Username)
+		notice, _ := json.Marshal(map[string]interface{}{
+			"type":    "room_kicked",
+			"roomId":  r.ID,
+			"message": "You have been removed from this room by a moderator",
+		})
+		for _, target := range kicked {
+			target.Notify(notice)
+		}
+
+		entry.Details = map[string]string{"connections": strconv.Itoa(len(kicked))}
+		response = map[string]interface{}{
+			"type":        "user_kicked",
+			"roomId":      r.ID,
+			"username":    action.Username,
+			"connections": len(kicked),
+		}
+
+	case "mute", "timeout":
+		// A timeout is a mute that always ends
+		if action.Type == "timeout" && action.Duration == nil {
+			sendRoomError(c, "A timeout needs a duration in seconds")
+			return
+		}
+
+		var until time.Time
+		if action.Duration != nil {
+			if *action.Duration <= 0 || *action.Duration > MaxMuteDuration {
+				sendRoomError(c, "A mute duration must be between 1 second and 30 days")
+				return
+			}
+			until = time.Now().Add(time.Duration(*action.Duration) * time.Second)
+			entry.Details = map[string]string{"duration": strconv.Itoa(*action.Duration), "until": until.Format(time.RFC3339)}
+		}
+		r.Mute(action.Username, until)
+
+		// Tell the muted user directly how long it lasts
+		notice := muteNotice(r.ID, until)
+		for _, target := range r.FindClients(action.Username) {
+			target.Notify(notice)
+		}
+
+		response = map[string]interface{}{
+			"type":     "mute_updated",
+			"roomId":   r.ID,
+			"username": action.Username,
+			"muted":    true,
+		}
+		if !until.IsZero() {
+			response["until"] = until.Format(time.RFC3339)
+		}
+
+	case "unmute":
+		r.Unmute(action.Username)
+		response = map[string]interface{}{
+			"type":     "mute_updated",
+			"roomId":   r.ID,
+			"username": action.Username,
+			"muted":    false,
+		}
+	}
+
+	c.Hub.Audit.Record(entry)
+
+	// Let the whole room know, ahead of any chat backlog
+	response["moderator"] = c.Username
+	responseJSON, _ := json.Marshal(response)
+	c.Hub.SendPriority(&hub.PriorityMessage{RoomID: r.ID, Message: responseJSON})
+}
+
+// handlePin pins or unpins a message in the room and tells everyone in it
+func handlePin(c *hub.Client, r *room.Room, action RoomAction) {
+	if action.MessageID == "" {
+		sendRoomError(c, "A messageId is required")
+		return
+	}
+
+	var event map[string]interface{}
+	if action.Type == "pin" {
+		msg, err := c.Hub.History.Message(r.ID, action.MessageID)
+		if errors.Is(err, history.ErrNotFound) || (err == nil && msg.Deleted) {
+			sendRoomError(c, "Message not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Error reading message %s: %v", action.MessageID, err)
+			sendRoomError(c, "Could not pin message")
+			return
+		}
+		if !r.Pin(msg.ID) {
+			sendRoomError(c, "The message is already pinned or the room has too many pins")
+			return
+		}
+		event = map[string]interface{}{
+			"type":    "message_pinned",
+			"message": msg,
+		}
+	} else {
+		if !r.Unpin(action.MessageID) {
+			sendRoomError(c, "The message is not pinned")
+			return
+		}
+		event = map[string]interface{}{
+			"type": "message_unpinned",
+		}
+	}
+
+	c.Hub.Audit.Record(store.AuditRecord{
+		Actor:   c.Username,
+		Action:  action.Type,
+		RoomID:  r.ID,
+		Reason:  action.Reason,
+		Details: map[string]string{"messageId": action.MessageID},
+	})
+
+	event["roomId"] = r.ID
+	event["messageId"] = action.MessageID
+	event["username"] = c.Username
+
+	eventJSON, _ := json.Marshal(event)
+	c.Hub.RoomManager.BroadcastToRoom(r.ID, eventJSON, nil)
+}
+
+// handlePromotion makes a user a moderator of the client's room, or returns
+// a moderator to their usual role
+func handlePromotion(c *hub.Client, action RoomAction) {
+	if action.Type == "promote" {
+		action.Role = string(rbac.Moderator)
+	} else {
+		if c.Hub.Roles.Assigned(c.RoomID, action.Username) != rbac.Moderator {
+			sendRoomError(c, action.Username+" is not a moderator of this room")
+			return
+		}
+		action.Role = ""
+	}
+	handleSetRole(c, action)
+}
+
+// returnKicked sends a client that a moderator removed from its room back to
+// the lobby, or to no room when it is an embedded chat widget's, reporting
+// whether it did
+func returnKicked(c *hub.Client) bool {
+	if c.RoomID == "" || room.IsLobby(c.RoomID) {
+		return false
+	}
+	r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+	if !exists || !r.TakeKick(c.ID) {
+		return false
+	}
+
+	c.RoomID = ""
+	if c.EmbedRoom == "" {
+		handleRoomAction(c, RoomAction{Type: "join", RoomID: room.LobbyFor(c.Workspace)})
+	}
+	return true
+}
+
+// rejectMuted reports whether the client's user is muted in a room, telling
+// them how much of the mute remains
+func rejectMuted(c *hub.Client, r *room.Room) bool {
+	until, muted := r.MutedUntil(c.Username)
+	if muted {
+		c.Priority <- muteNotice(r.ID, until)
+	}
+	return muted
+}
+
+// muteNotice tells a user they are muted in a room until the given time, or
+// until unmuted when it is zero
+func muteNotice(roomID string, until time.Time) []byte {
+	notice := map[string]interface{}{
+		"type":    "muted",
+		"roomId":  roomID,
+		"message": "You are muted in this room until a moderator unmutes you",
+	}
+	if !until.IsZero() {
+		remaining := int(math.Ceil(time.Until(until).Seconds()))
+		notice["until"] = until.Format(time.RFC3339)
+		notice["remainingSeconds"] = remaining
+		notice["message"] = "You are muted in this room for " + (time.Duration(remaining) * time.Second).String()
+	}
+
+	noticeJSON, _ := json.Marshal(notice)
+	return noticeJSON
+}
@@ -0,0 +1,27 @@
+package websocket
+
+import (
+	"realtime-chat/hooks"
+	"realtime-chat/internal/hub"
+	"strings"
+)
+
+// NickAction represents a client that didn't log in changing its username
+type NickAction struct {
+	Type     string `json:"type"` // "nick"
+	Username string `json:"username"`
+}
+
+// handleNick renames the client, which the hub announces to its room with
+// a "renamed" frame
+func handleNick(c *hub.Client, username string) {
+	// MQTT devices are addressed by the username they connected with
+	if c.Transport == hooks.TransportMQTT {
+		sendRoomError(c, "Devices keep the username they connected with")
+		return
+	}
+
+	if err := c.Hub.Rename(c, strings.TrimSpace(username)); err != nil {
+		sendRoomError(c, "Can't change username: "+err.Error())
+	}
+}
@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/poll"
+	"realtime-chat/internal/rbac"
+)
+
+// PollAction represents creating, voting in or closing a poll in the client's room
+type PollAction struct {
+	Type      string   `json:"type"` // "poll_create", "poll_vote", "poll_close"
+	PollID    string   `json:"pollId,omitempty"`
+	Question  string   `json:"question,omitempty"`
+	Options   []string `json:"options,omitempty"`
+	Anonymous bool     `json:"anonymous,omitempty"` // Hide who voted for what, used by "poll_create"
+	Option    *int     `json:"option,omitempty"`    // Index of the chosen option, used by "poll_vote"
+}
+
+// pollActionTypes lists the message types handled as poll actions
+var pollActionTypes = map[string]bool{
+	"poll_create": true,
+	"poll_vote":   true,
+	"poll_close":  true,
+}
+
+// handlePollAction applies a poll action and broadcasts the poll's live results to the room
+func handlePollAction(c *hub.Client, action PollAction) {
+	if c.RoomID == "" {
+		sendRoomError(c, "You are not in a room")
+		return
+	}
+
+	r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+	if exists && r.IsBanned(c.Username) {
+		sendPermissionError(c, "You are banned from this room")
+		return
+	}
+	if exists && rejectArchived(c, r) {
+		return
+	}
+
+	var (
+		results   *poll.Results
+		err       error
+		eventType string
+	)
+
+	switch action.Type {
+	case "poll_create":
+		// Starting a poll is posting to the room
+		if !c.Hub.Roles.Can(c.Username, c.RoomID, rbac.PermPost) {
+			sendPermissionError(c, "Your role does not allow posting in this room")
+			return
+		}
+		if exists && rejectMuted(c, r) {
+			return
+		}
+		if exists && !r.CanPost(c.Username) {
+			sendPermissionError(c, "Only designated posters can start polls in this room")
+			return
+		}
+		results, err = c.Hub.Polls.Create(c.RoomID, c.Username, action.Question, action.Options, action.Anonymous)
+		eventType = "poll_created"
+
+	case "poll_vote":
+		if action.Option == nil {
+			sendRoomError(c, "An option is required")
+			return
+		}
+		if !c.Hub.Roles.Can(c.Username, c.RoomID, rbac.PermReact) {
+			sendPermissionError(c, "Your role does not allow voting in this room")
+			return
+		}
+		results, err = c.Hub.Polls.Vote(c.RoomID, action.PollID, c.Username, *action.Option)
+		eventType = "poll_updated"
+
+	case "poll_close":
+		results, err = c.Hub.Polls.Close(c.RoomID, action.PollID, c.Username)
+		eventType = "poll_closed"
+	}
+
+	switch {
+	case errors.Is(err, poll.ErrNotCreator):
+		sendPermissionError(c, err.Error())
+		return
+	case errors.Is(err, poll.ErrNotFound), errors.Is(err, poll.ErrClosed),
+		errors.Is(err, poll.ErrInvalidOption), errors.Is(err, poll.ErrInvalidPoll):
+		sendRoomError(c, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error updating poll %s: %v", action.PollID, err)
+		sendRoomError(c, "Could not update poll")
+		return
+	}
+
+	event := map[string]interface{}{
+		"type":   eventType,
+		"roomId": c.RoomID,
+		"poll":   results,
+	}
+	// Naming the voter would reveal their choice by comparing tallies
+	if !(action.Type == "poll_vote" && results.Anonymous) {
+		event["username"] = c.Username
+	}
+
+	eventJSON, _ := json.Marshal(event)
+	c.Hub.RoomManager.BroadcastToRoom(c.RoomID, eventJSON, nil)
+}
@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/emoji"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/presence"
+)
+
+// StatusAction represents a client setting its user's presence status
+type StatusAction struct {
+	Type  string `json:"type"`            // "set_status"
+	State string `json:"state,omitempty"` // "available", "away" or "busy"; empty means available
+	Text  string `json:"text,omitempty"`
+	Emoji string `json:"emoji,omitempty"` // An emoji or a registered :shortcode:
+}
+
+// handleStatusAction sets the user's presence status, which the hub
+// broadcasts to every room they are in
+func handleStatusAction(c *hub.Client, action StatusAction) {
+	// Custom emoji must be registered ones
+	if emoji.IsShortcode(action.Emoji) && !c.Hub.Emoji.Known(action.Emoji) {
+		sendRoomError(c, "Unknown emoji "+action.Emoji)
+		return
+	}
+
+	_, err := c.Hub.SetStatus(c.Username, presence.Status{
+		State: action.State,
+		Text:  action.Text,
+		Emoji: action.Emoji,
+	})
+	if errors.Is(err, presence.ErrInvalidStatus) {
+		sendRoomError(c, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting status of %s: %v", c.Username, err)
+	}
+}
+
+// handleWho sends the client the members of its current room, including
+// those connected to other nodes of a cluster
+func handleWho(c *hub.Client) {
+	if c.RoomID == "" {
+		sendRoomError(c, "Join a room to list its members")
+		return
+	}
+
+	responseJSON, _ := json.Marshal(map[string]interface{}{
+		"type":    "members",
+		"roomId":  c.RoomID,
+		"members": c.Hub.RoomMembers(c.RoomID),
+	})
+	c.Send <- responseJSON
+}
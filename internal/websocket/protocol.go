@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"realtime-chat/internal/hub"
+	"strings"
+)
+
+// Protocol versions the server speaks. A client's hello declares the newest
+// version it speaks and the server answers with the newest both share.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// features maps the capability names of hello frames to protocol features
+var features = map[string]hub.Feature{
+	"compression": hub.FeatureCompression,
+	"binary":      hub.FeatureBinary,
+	"ack":         hub.FeatureAck,
+}
+
+// HelloAction opens the protocol handshake. Clients send it as their first
+// frame; clients that never send one get version 1 without optional features.
+type HelloAction struct {
+	Type         string   `json:"type"` // "hello"
+	Version      int      `json:"version"`
+	Capabilities []string `json:"capabilities,omitempty"` // "compression", "binary" and "ack"
+}
+
+// handleHello agrees on a protocol version and the requested features the
+// client's transport supports, and tells the client. A client whose version
+// is too old, or that says hello twice, is sent a hello_error and disconnected.
+func handleHello(c *hub.Client, action HelloAction) {
+	switch {
+	case c.ProtocolVersion() != 0:
+		rejectHello(c, "Protocol already negotiated")
+		return
+	case action.Version < MinProtocolVersion:
+		rejectHello(c, fmt.Sprintf("Protocol version %d is not supported", action.Version))
+		return
+	}
+
+	version := min(action.Version, ProtocolVersion)
+	var agreed hub.Feature
+	capabilities := []string{}
+	for _, name := range action.Capabilities {
+		f, ok := features[name]
+		if !ok || c.Supported&f == 0 || agreed&f != 0 {
+			continue
+		}
+		agreed |= f
+		capabilities = append(capabilities, name)
+	}
+	c.Negotiate(version, agreed)
+
+	// Sent ahead of queued traffic; frames after it use the agreed features
+	reply, _ := json.Marshal(map[string]interface{}{
+		"type":         "hello",
+		"version":      version,
+		"minVersion":   MinProtocolVersion,
+		"maxVersion":   ProtocolVersion,
+		"capabilities": capabilities,
+		"maxFrameSize": c.Hub.Connection().MaxFrameSize,
+		"clientId":     c.ID,
+		"username":     c.Username,
+	})
+	c.Priority <- reply
+}
+
+// rejectHello tells the client why its hello was refused and ends its session
+func rejectHello(c *hub.Client, message string) {
+	reply, _ := json.Marshal(map[string]interface{}{
+		"type":       "hello_error",
+		"message":    message,
+		"minVersion": MinProtocolVersion,
+		"maxVersion": ProtocolVersion,
+	})
+	c.Priority <- reply
+	Disconnect(c)
+}
+
+// offersDeflate reports whether a WebSocket upgrade request offers the
+// permessage-deflate extension, which the upgrader then accepts
+func offersDeflate(r *http.Request) bool {
+	for _, header := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package websocket
+
+import (
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/room"
+	"time"
+)
+
+// replayBuffer is the capacity of the channels of replayed clients, large
+// enough that no frame is dropped while a step settles
+const replayBuffer = 4096
+
+// replayClient is a client re-created from a recording
+type replayClient struct {
+	client         *hub.Client
+	sendClosed     bool
+	priorityClosed bool
+}
+
+// Replay re-executes the client input of a recording against h, one event
+// at a time in logical-timestamp order, and compares the frames delivered
+// to each client with the recorded ones. After every event it waits until no
+// frame has been delivered for settle, so each event is fully processed
+// before the next begins. h must be running and should start out empty;
+// IDs are replayed when replay.ReplayIDs has been called with the events.
+func Replay(h *hub.Hub, events []*replay.Event, settle time.Duration) *replay.Report {
+	clients := make(map[string]*replayClient)
+	var order []*replayClient
+	var delivered []*replay.Event
+
+	for _, event := range events {
+		if !event.IsInput() {
+			continue
+		}
+
+		switch event.Kind {
+		case replay.KindConnect:
+			rc := &replayClient{client: &hub.Client{
+				ID:       event.Client,
+				Send:     make(chan []byte, replayBuffer),
+				Priority: make(chan []byte, replayBuffer),
+				Hub:      h,
+
+				Supported: hub.Feature(event.Features),
+				Workspace: event.Workspace,
+				EmbedRoom: event.EmbedRoom,
+				Session:   event.Session,
+			}}
+			rc.client.SetUsername(event.Username)
+			// Workspaces are created through the REST API, which isn't recorded
+			if event.Workspace != "" {
+				h.RoomManager.CreateLobbyAsync(event.Workspace)
+			}
+			select {
+			case h.Register <- rc.client:
+			case <-h.Done():
+				return replay.Compare(events, delivered)
+			}
+			clients[event.Client] = rc
+			order = append(order, rc)
+			// Recordings made before the first room was recorded started in the lobby
+			start := event.Room
+			if start == "" {
+				start = room.LobbyFor(event.Workspace)
+			}
+			handleRoomAction(rc.client, RoomAction{Type: "join", RoomID: start})
+
+		case replay.KindFrame:
+			if rc, ok := clients[event.Client]; ok {
+				handleFrame(rc.client, []byte(event.Data))
+			}
+
+		case replay.KindDisconnect:
+			if rc, ok := clients[event.Client]; ok {
+				// A refused hello may already have ended the session
+				if rc.client.End() {
+					disconnect(rc.client)
+				}
+				delete(clients, event.Client)
+			}
+		}
+
+		delivered = drainReplay(order, delivered, settle)
+	}
+
+	return replay.Compare(events, delivered)
+}
+
+// drainReplay collects the frames delivered to clients until none has
+// arrived for settle
+func drainReplay(clients []*replayClient, delivered []*replay.Event, settle time.Duration) []*replay.Event {
+	quiet := time.Now()
+	for time.Since(quiet) < settle {
+		received := false
+		for _, rc := range clients {
+			// Priority frames are written first, as by writePump
+			for !rc.priorityClosed {
+				frame, ok := receive(rc.client.Priority)
+				if frame == nil {
+					rc.priorityClosed = !ok
+					break
+				}
+				delivered = append(delivered, deliveredFrame(rc, replay.ChannelPriority, frame))
+				received = true
+			}
+			for !rc.sendClosed {
+				frame, ok := receive(rc.client.Send)
+				if frame == nil {
+					rc.sendClosed = !ok
+					break
+				}
+				delivered = append(delivered, deliveredFrame(rc, replay.ChannelSend, frame))
+				received = true
+			}
+		}
+
+		if received {
+			quiet = time.Now()
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return delivered
+}
+
+// receive returns the next frame of ch without blocking. It returns a nil
+// frame when there is none, with ok false once ch is closed.
+func receive(ch chan []byte) ([]byte, bool) {
+	select {
+	case frame, ok := <-ch:
+		return frame, ok
+	default:
+		return nil, true
+	}
+}
+
+// deliveredFrame returns the delivery event of a replayed frame
+func deliveredFrame(rc *replayClient, channel string, frame []byte) *replay.Event {
+	return &replay.Event{Kind: replay.KindDeliver, Client: rc.client.ID, Source: channel, Data: string(frame)}
+}
@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/report"
+)
+
+// handleReport flags a message in the client's room for the moderators,
+// queueing it for the admin API and alerting the room's moderators who are
+// online
+func handleReport(c *hub.Client, action RoomAction) {
+	r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+	if !exists {
+		sendRoomError(c, "You are not in a room")
+		return
+	}
+	if action.MessageID == "" {
+		sendRoomError(c, "A messageId is required")
+		return
+	}
+
+	msg, err := c.Hub.History.Message(r.ID, action.MessageID)
+	if errors.Is(err, history.ErrNotFound) || (err == nil && msg.Deleted) {
+		sendRoomError(c, "Message not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading message %s: %v", action.MessageID, err)
+		sendRoomError(c, "Could not report message")
+		return
+	}
+	if msg.Username == c.Username {
+		sendRoomError(c, "You can't report your own message")
+		return
+	}
+
+	rep, err := c.Hub.Reports.File(r.ID, msg.ID, c.Username, action.Reason, msg.Username, msg.Content)
+	if errors.Is(err, report.ErrInvalidReport) || errors.Is(err, report.ErrDuplicate) {
+		sendRoomError(c, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error filing report in room %s: %v", r.ID, err)
+		sendRoomError(c, "Could not report message")
+		return
+	}
+
+	c.Hub.NotifyModerators(r.ID, map[string]interface{}{
+		"type":     "message_reported",
+		"roomId":   r.ID,
+		"roomName": r.Name,
+		"report":   rep,
+	})
+
+	response := map[string]interface{}{
+		"type":      "report_filed",
+		"roomId":    r.ID,
+		"messageId": msg.ID,
+		"reportId":  rep.ID,
+	}
+	responseJSON, _ := json.Marshal(response)
+	c.Send <- responseJSON
+}
@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/store"
+)
+
+// handleSetRole gives a user a role in the client's room. Room admins can
+// assign roles up to their own to users below them.
+func handleSetRole(c *hub.Client, action RoomAction) {
+	r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+	if !exists {
+		sendRoomError(c, "You are not in a room")
+		return
+	}
+
+	role := c.Hub.Roles.Role(c.Username, r.ID)
+	if !role.Has(rbac.PermAssignRoles) {
+		sendPermissionError(c, "Only room admins can assign roles")
+		return
+	}
+	if action.Username == "" || action.Username == c.Username || action.Username == r.CreatedBy {
+		sendRoomError(c, "A valid username is required")
+		return
+	}
+	if !role.Outranks(c.Hub.Roles.Role(action.Username, r.ID)) || rbac.Role(action.Role).Outranks(role) {
+		sendPermissionError(c, "You can only assign roles up to your own to users with a lower role")
+		return
+	}
+
+	err := c.Hub.Roles.Assign(r.ID, action.Username, rbac.Role(action.Role))
+	if errors.Is(err, rbac.ErrInvalidRole) {
+		sendRoomError(c, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error assigning role in room %s: %v", r.ID, err)
+		sendRoomError(c, "Could not assign role")
+		return
+	}
+
+	c.Hub.Audit.Record(store.AuditRecord{
+		Actor:   c.Username,
+		Action:  audit.ActionSetRole,
+		Target:  action.Username,
+		RoomID:  r.ID,
+		Reason:  action.Reason,
+		Details: map[string]string{"role": action.Role},
+	})
+
+	response := map[string]interface{}{
+		"type":     "role_updated",
+		"roomId":   r.ID,
+		"username": action.Username,
+		"role":     c.Hub.Roles.Role(action.Username, r.ID),
+		"canPost":  r.CanPost(action.Username) && c.Hub.Roles.Can(action.Username, r.ID, rbac.PermPost),
+	}
+
+	// Let the whole room know, ahead of any chat backlog
+	responseJSON, _ := json.Marshal(response)
+	c.Hub.SendPriority(&hub.PriorityMessage{RoomID: r.ID, Message: responseJSON})
+}
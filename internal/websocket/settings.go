@@ -0,0 +1,214 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/store"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SettingsUpdate changes some of a room's settings; omitted ones are left
+// unchanged
+type SettingsUpdate struct {
+	SlowMode          *int      `json:"slowMode,omitempty"`
+	RetentionDays     *int      `json:"retentionDays,omitempty"`
+	RetentionMessages *int      `json:"retentionMessages,omitempty"`
+	MaxMembers        *int      `json:"maxMembers,omitempty"`
+	WhoCanPost        *string   `json:"whoCanPost,omitempty"`
+	ProfanityLevel    *string   `json:"profanityLevel,omitempty"`
+	Welcome           *string   `json:"welcome,omitempty"`
+	Rules             *string   `json:"rules,omitempty"`
+	Federation        *[]string `json:"federation,omitempty"`
+	Live              *bool     `json:"live,omitempty"`
+	EmbedOrigins      *[]string `json:"embedOrigins,omitempty"`
+}
+
+// handleRoomSettings sends the client its room's settings, first changing
+// those in the action's settings. Moderators may change slow mode; the other
+// settings are the room admins'.
+func handleRoomSettings(c *hub.Client, action RoomAction) {
+	r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+	if !exists {
+		sendRoomError(c, "You are not in a room")
+		return
+	}
+
+	update := action.Settings
+	if update == nil {
+		response, _ := json.Marshal(map[string]interface{}{
+			"type":     "room_settings",
+			"roomId":   r.ID,
+			"settings": r.GetSettings(),
+		})
+		c.Send <- response
+		return
+	}
+
+	adminOnly := update.RetentionDays != nil || update.RetentionMessages != nil || update.MaxMembers != nil ||
+		update.WhoCanPost != nil || update.ProfanityLevel != nil || update.Welcome != nil || update.Rules != nil || update.Federation != nil || update.Live != nil || update.EmbedOrigins != nil
+	if adminOnly && !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermManageRoom) {
+		sendPermissionError(c, "Only room admins can change these settings")
+		return
+	}
+	if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermModerate) {
+		sendPermissionError(c, "Only moderators can change slow mode")
+		return
+	}
+	if update.MaxMembers != nil && *update.MaxMembers != 0 && room.IsLobby(r.ID) {
+		sendRoomError(c, "The lobby can't limit its members")
+		return
+	}
+	if update.Federation != nil && len(*update.Federation) > 0 && (r.Workspace != "" || r.GetSlug() == "" || r.IsEncrypted()) {
+		sendRoomError(c, "Only unencrypted rooms outside workspaces with a slug can be shared with other servers")
+		return
+	}
+	if update.Live != nil && *update.Live && (r.GetSlug() == "" || r.IsEncrypted() || r.IsGroup()) {
+		sendRoomError(c, "Only unencrypted rooms with a slug can be watched live")
+		return
+	}
+	if update.EmbedOrigins != nil && len(*update.EmbedOrigins) > 0 && (r.IsEncrypted() || r.IsGroup()) {
+		sendRoomError(c, "Only unencrypted rooms can be embedded in other sites")
+		return
+	}
+
+	settings := r.GetSettings()
+	details := make(map[string]string)
+	if update.SlowMode != nil {
+		settings.SlowMode = *update.SlowMode
+		details["slowMode"] = strconv.Itoa(settings.SlowMode)
+	}
+	if update.RetentionDays != nil {
+		settings.RetentionDays = *update.RetentionDays
+		details["retentionDays"] = strconv.Itoa(settings.RetentionDays)
+	}
+	if update.RetentionMessages != nil {
+		settings.RetentionMessages = *update.RetentionMessages
+		details["retentionMessages"] = strconv.Itoa(settings.RetentionMessages)
+	}
+	if update.MaxMembers != nil {
+		settings.MaxMembers = *update.MaxMembers
+		details["maxMembers"] = strconv.Itoa(settings.MaxMembers)
+	}
+	if update.WhoCanPost != nil {
+		settings.WhoCanPost = room.PostPolicy(*update.WhoCanPost)
+		details["whoCanPost"] = *update.WhoCanPost
+	}
+	if update.ProfanityLevel != nil {
+		settings.ProfanityLevel = room.ProfanityLevel(*update.ProfanityLevel)
+		details["profanityLevel"] = *update.ProfanityLevel
+	}
+	if update.Welcome != nil {
+		settings.Welcome = strings.TrimSpace(*update.Welcome)
+		details["welcome"] = strconv.Itoa(len(settings.Welcome)) + " bytes"
+	}
+	if update.Rules != nil {
+		settings.Rules = strings.TrimSpace(*update.Rules)
+		details["rules"] = strconv.Itoa(len(settings.Rules)) + " bytes"
+	}
+	if update.Federation != nil {
+		settings.Federation = nil
+		for _, server := range *update.Federation {
+			server = strings.ToLower(strings.TrimSpace(server))
+			if !slices.Contains(settings.Federation, server) {
+				settings.Federation = append(settings.Federation, server)
+			}
+		}
+		details["federation"] = strings.Join(settings.Federation, ",")
+	}
+	if update.Live != nil {
+		settings.Live = *update.Live
+		details["live"] = strconv.FormatBool(settings.Live)
+	}
+	if update.EmbedOrigins != nil {
+		settings.EmbedOrigins = nil
+		for _, origin := range *update.EmbedOrigins {
+			origin = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+			if !slices.Contains(settings.EmbedOrigins, origin) {
+				settings.EmbedOrigins = append(settings.EmbedOrigins, origin)
+			}
+		}
+		details["embedOrigins"] = strings.Join(settings.EmbedOrigins, ",")
+	}
+	if err := r.SetSettings(settings); err != nil {
+		sendRoomError(c, fmt.Sprintf("Slow mode is 0 to %d seconds, limits are not negative, whoCanPost is everyone, posters or moderators, profanityLevel is off, mask or block, the welcome and rules are at most %d bytes, federation lists up to %d server names, and embedOrigins up to %d origins such as https://example.com", room.MaxSlowMode, room.MaxWelcomeLength, room.MaxFederation, room.MaxEmbedOrigins))
+		return
+	}
+
+	c.Hub.Audit.Record(store.AuditRecord{
+		Actor:   c.Username,
+		Action:  audit.ActionRoomSettings,
+		RoomID:  r.ID,
+		Reason:  action.Reason,
+		Details: details,
+	})
+
+	// Announce the new settings to everyone in the room
+	event, _ := json.Marshal(map[string]interface{}{
+		"type":      "room_settings_updated",
+		"roomId":    r.ID,
+		"settings":  r.GetSettings(),
+		"retention": retentionPolicy(c.Hub, r.ID),
+		"username":  c.Username,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+	c.Hub.RoomManager.BroadcastToRoom(r.ID, event, nil)
+}
+
+// rejectSlowMode tells a client posting again too soon in a room with slow
+// mode on how long to wait, reporting whether it must. Moderators aren't
+// slowed down.
+func rejectSlowMode(c *hub.Client, r *room.Room) bool {
+	if c.Hub.Roles.Can(c.Username, r.ID, rbac.PermModerate) {
+		return false
+	}
+	wait := r.SlowModeWait(c.Username)
+	if wait == 0 {
+		return false
+	}
+	sendRoomError(c, fmt.Sprintf("Slow mode is on; wait %d seconds before posting again", int(math.Ceil(wait.Seconds()))))
+	return true
+}
+
+// rejectFull tells a client the room it wants to join is full, reporting
+// whether it is. Moderators can always join.
+func rejectFull(c *hub.Client, r *room.Room) bool {
+	if !r.Full() || c.Hub.Roles.Can(c.Username, r.ID, rbac.PermModerate) {
+		return false
+	}
+	sendRoomError(c, "This room is full")
+	return true
+}
+
+// postPolicyError returns the error shown to a user who may not post under
+// a room's post policy
+func postPolicyError(r *room.Room) string {
+	if r.PostPolicy() == room.PostModerators {
+		return "Only moderators can send messages in this room"
+	}
+	return "Only designated posters can send messages in this room"
+}
+
+// sendWelcome privately sends a client that just joined a room the room's
+// welcome message and rules, if it has any, ahead of the history it loads
+func sendWelcome(c *hub.Client, r *room.Room) {
+	settings := r.GetSettings()
+	if settings.Welcome == "" && settings.Rules == "" {
+		return
+	}
+	response, _ := json.Marshal(map[string]interface{}{
+		"type":     "room_welcome",
+		"roomId":   r.ID,
+		"roomName": r.Name,
+		"welcome":  settings.Welcome,
+		"rules":    settings.Rules,
+	})
+	c.Send <- response
+}
@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"encoding/json"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/spam"
+	"realtime-chat/internal/store"
+	"time"
+)
+
+// rejectSpam checks a chat message for spam and reports whether it must be
+// dropped because its sender was muted for it. Moderators are never checked.
+func rejectSpam(c *hub.Client, r *room.Room, content string) bool {
+	if c.Hub.Roles.Can(c.Username, r.ID, rbac.PermModerate) {
+		return false
+	}
+	if v := c.Hub.Spam.CheckMessage(c.Username, content); v != nil {
+		return handleSpam(c, r, v)
+	}
+	return false
+}
+
+// checkRoomHop checks whether a client that just joined a room is hopping
+// between rooms too quickly. Returns to the lobby don't count.
+func checkRoomHop(c *hub.Client, r *room.Room) {
+	if room.IsLobby(r.ID) || c.Hub.Roles.Can(c.Username, r.ID, rbac.PermModerate) {
+		return
+	}
+	if v := c.Hub.Spam.CheckJoin(c.Username); v != nil {
+		handleSpam(c, r, v)
+	}
+}
+
+// handleSpam flags a user caught spamming in a room to the audit log and the
+// room's online moderators, first muting them when the detector is set to,
+// and reports whether they were muted
+func handleSpam(c *hub.Client, r *room.Room, v *spam.Violation) bool {
+	action := c.Hub.Spam.Action()
+	details := map[string]string{"kind": v.Kind, "action": action}
+	alert := map[string]interface{}{
+		"type":     "spam_detected",
+		"roomId":   r.ID,
+		"roomName": r.Name,
+		"username": c.Username,
+		"kind":     v.Kind,
+		"reason":   v.Reason,
+		"action":   action,
+	}
+
+	muted := action == config.SpamMute
+	if muted {
+		until := time.Now().Add(c.Hub.Spam.MuteDuration())
+		r.Mute(c.Username, until)
+		details["until"] = until.Format(time.RFC3339)
+		alert["until"] = until.Format(time.RFC3339)
+
+		notice := muteNotice(r.ID, until)
+		for _, target := range r.FindClients(c.Username) {
+			target.Notify(notice)
+		}
+
+		update, _ := json.Marshal(map[string]interface{}{
+			"type":      "mute_updated",
+			"roomId":    r.ID,
+			"username":  c.Username,
+			"muted":     true,
+			"until":     until.Format(time.RFC3339),
+			"automatic": true,
+		})
+		c.Hub.SendPriority(&hub.PriorityMessage{RoomID: r.ID, Message: update})
+	}
+
+	c.Hub.Audit.Record(store.AuditRecord{
+		Actor:   "system",
+		Action:  audit.ActionSpam,
+		Target:  c.Username,
+		RoomID:  r.ID,
+		Reason:  v.Reason,
+		Details: details,
+	})
+	c.Hub.NotifyModerators(r.ID, alert)
+	return muted
+}
@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"realtime-chat/internal/history"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/markdown"
+	"realtime-chat/internal/translate"
+)
+
+// TranslateAction represents a user asking for a message of their room in
+// another language
+type TranslateAction struct {
+	Type      string `json:"type"` // "translate"
+	MessageID string `json:"messageId"`
+	Language  string `json:"language"` // Language code to translate to, such as "de" or "pt-br"
+}
+
+// handleTranslate sends the client a translated copy of a message of its
+// current room. Only the client gets it; the message itself is unchanged.
+func handleTranslate(c *hub.Client, action TranslateAction) {
+	if c.RoomID == "" {
+		sendRoomError(c, "Join a room to translate its messages")
+		return
+	}
+
+	msg, err := c.Hub.History.Message(c.RoomID, action.MessageID)
+	switch {
+	case errors.Is(err, history.ErrNotFound):
+		sendRoomError(c, "Message not found")
+		return
+	case err != nil:
+		log.Printf("Error loading message %s to translate: %v", action.MessageID, err)
+		sendRoomError(c, "Could not translate the message")
+		return
+	case msg.Deleted:
+		sendRoomError(c, "Message has been deleted")
+		return
+	case msg.Encryption != nil:
+		sendRoomError(c, "End-to-end encrypted messages can't be translated by the server")
+		return
+	case msg.GIF != nil:
+		sendRoomError(c, "GIF messages can't be translated")
+		return
+	}
+
+	translation, err := c.Hub.Translator.Translate(c.Frame, msg.Content, action.Language)
+	if err != nil {
+		if !errors.Is(err, translate.ErrDisabled) && !errors.Is(err, translate.ErrLanguage) && !errors.Is(err, translate.ErrTooLong) {
+			log.Printf("Error translating message %s: %v", msg.ID, err)
+			err = errors.New("Could not translate the message; try again")
+		}
+		sendRoomError(c, err.Error())
+		return
+	}
+
+	// Translations are rendered like the messages they come from
+	html, entities := markdown.Render(translation.Text)
+	response, _ := json.Marshal(map[string]interface{}{
+		"type":           "translation",
+		"roomId":         c.RoomID,
+		"messageId":      msg.ID,
+		"language":       translation.Language,
+		"sourceLanguage": translation.Source,
+		"content":        translation.Text,
+		"html":           html,
+		"entities":       entities,
+	})
+	c.Send <- response
+}
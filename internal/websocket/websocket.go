@@ -1,100 +1,420 @@
 package websocket
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/mail"
+	"realtime-chat/hooks"
+	"realtime-chat/internal/audit"
+	"realtime-chat/internal/auth"
+	"realtime-chat/internal/bot"
+	"realtime-chat/internal/emoji"
+	"realtime-chat/internal/gif"
+	"realtime-chat/internal/history"
 	"realtime-chat/internal/hub"
+	"realtime-chat/internal/metrics"
+	"realtime-chat/internal/rbac"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/room"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/tracing"
+	"realtime-chat/internal/webhook"
+	"realtime-chat/internal/workspace"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // WebSocket upgrader configuration
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+
+	// Connections borrow a write buffer only while writing, coalesced frames
+	// included, so idle connections hold none
+	WriteBufferPool: &sync.Pool{},
+
+	// Frames are only compressed for clients that negotiate compression
+	EnableCompression: true,
+	// HandleWebSocket checks the origin of connections made with the session
+	// cookie before upgrading; other connections carry their own credentials
 	CheckOrigin: func(r *http.Request) bool {
-		// Allow connections from any origin (in production, be more restrictive)
 		return true
 	},
 }
 
+// allowedOrigin reports whether a connection may be made from the browser
+// origin it comes from. Browsers send the session cookie along with
+// connections opened by any site, so those must come from one of the chat's
+// own; bearer and query tokens can't be sent by another site.
+func allowedOrigin(h *hub.Hub, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return true
+	}
+	if _, err := r.Cookie(auth.SessionCookie); err != nil {
+		return true
+	}
+	return h.SiteOrigin(origin)
+}
+
 // Message represents a chat message
 type Message struct {
+	ID        string `json:"id,omitempty"`
 	Type      string `json:"type"`
 	Username  string `json:"username"`
 	Content   string `json:"content"`
 	Timestamp string `json:"timestamp"`
 	RoomID    string `json:"roomId,omitempty"`
+	TTL       int    `json:"ttl,omitempty"` // Seconds before the message disappears, overriding the room's setting
+
+	// Set on messages to end-to-end encrypted rooms, whose content is ciphertext
+	Encryption *store.Encryption `json:"encryption,omitempty"`
+
+	// ID the client gave the message, such as a UUID; a message resent with
+	// the same ID is acknowledged again instead of being posted twice
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+
+	// ID at the GIF provider of the GIF a "gif" message posts, as found by
+	// searching /api/gifs
+	GIFID string `json:"gifId,omitempty"`
 }
 
 // RoomMessage represents a room-specific message
 type RoomMessage struct {
+	ID        string `json:"id,omitempty"`
 	Type      string `json:"type"`
 	Username  string `json:"username"`
 	Content   string `json:"content"`
 	Timestamp string `json:"timestamp"`
 	RoomID    string `json:"roomId"`
+	Seq       int64  `json:"seq,omitempty"` // The message's sequence number in the room
+	Bot       bool   `json:"bot,omitempty"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+
+	// Image URLs of the custom emoji used in the content, by shortcode
+	Emoji map[string]string `json:"emoji,omitempty"`
+
+	// Set on end-to-end encrypted messages, whose content is ciphertext
+	Encryption *store.Encryption `json:"encryption,omitempty"`
+
+	// Set on "gif" messages, whose content is the GIF's URL
+	GIF *store.GIF `json:"gif,omitempty"`
+
+	// Content of a chat message rendered from markdown to sanitized HTML,
+	// and the links, mentions and code found in it. Clients show HTML
+	// rather than rendering content themselves.
+	HTML     string         `json:"html,omitempty"`
+	Entities []store.Entity `json:"entities,omitempty"`
+}
+
+// DirectMessageAction represents a private message to another user
+type DirectMessageAction struct {
+	Type    string `json:"type"` // "dm"
+	To      string `json:"to"`
+	Content string `json:"content"`
+
+	// Set on end-to-end encrypted messages, whose content is ciphertext for
+	// the device with the recipient key
+	Encryption *store.Encryption `json:"encryption,omitempty"`
+}
+
+// MessageAction represents a change to an existing room message
+type MessageAction struct {
+	Type      string `json:"type"` // "edit", "delete", "restore", "react", "unreact"
+	MessageID string `json:"messageId"`
+	Content   string `json:"content,omitempty"`
+	Emoji     string `json:"emoji,omitempty"`
+	Reason    string `json:"reason,omitempty"` // Why a moderator deleted or restored someone else's message, kept in the audit log
+
+	// Set on edits in end-to-end encrypted rooms, whose content is ciphertext
+	Encryption *store.Encryption `json:"encryption,omitempty"`
+}
+
+// maxClientMessageIDLength is the longest client message ID accepted
+const maxClientMessageIDLength = 64
+
+// messageActionTypes lists the message types handled as message actions
+var messageActionTypes = map[string]bool{
+	"edit":    true,
+	"delete":  true,
+	"restore": true,
+	"react":   true,
+	"unreact": true,
 }
 
 // RoomAction represents room operations
 type RoomAction struct {
-	Type     string `json:"type"` // "join", "leave", "create", "list"
-	RoomID   string `json:"roomId,omitempty"`
-	RoomName string `json:"roomName,omitempty"`
-	Username string `json:"username,omitempty"`
+	Type        string `json:"type"` // "join", "leave", "create", "list", "add_poster", "remove_poster", "set_topic", "set_ttl", "set_webhook", "create_incoming_webhook", "remove_incoming_webhook", "ban", "unban", "set_role", "promote", "demote", "kick", "mute", "timeout", "unmute", "pin", "unpin", "report", "set_retention", "set_tags", "discover", "set_slug", "archive", "unarchive", "set_join_rule", "knock", "join_requests", "approve_join", "deny_join", "room_settings"
+	RoomID      string `json:"roomId,omitempty"`
+	RoomName    string `json:"roomName,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Mode        string `json:"mode,omitempty"`      // "normal", "announcement" or "support", used by "create"
+	Encrypted   bool   `json:"encrypted,omitempty"` // End-to-end encrypt the room's messages, used by "create"
+	Topic       string `json:"topic,omitempty"`
+	Description string `json:"description,omitempty"`
+	TTL         *int   `json:"ttl,omitempty"`       // Seconds before messages disappear, used by "set_ttl"
+	Email       string `json:"email,omitempty"`     // Where to email the transcript of a "support" room, used by "create"
+	Slug        string `json:"slug,omitempty"`      // Readable name the room is joined and linked by, used by "create" and "set_slug"
+	URL         string `json:"url,omitempty"`       // Webhook for message changes, used by "set_webhook"; empty removes it
+	Role        string `json:"role,omitempty"`      // Role given to the user in the room, used by "set_role"; empty removes it
+	Duration    *int   `json:"duration,omitempty"`  // Seconds a "mute" or "timeout" lasts; omitted mutes until "unmute"
+	MessageID   string `json:"messageId,omitempty"` // Message to "pin", "unpin" or "report"
+	Reason      string `json:"reason,omitempty"`    // Why a message is reported, or why a moderator acted; kept in the audit log
+	Archived    bool   `json:"archived,omitempty"`  // Include archived rooms, used by "list"
+	JoinRule    string `json:"joinRule,omitempty"`  // "open" or "knock", used by "set_join_rule"
+
+	// Settings changed by "room_settings"; omitted only reads them
+	Settings *SettingsUpdate `json:"settings,omitempty"`
+
+	// Days and number of messages of history the room keeps, used by
+	// "set_retention"; 0 uses the server's setting and omitted leaves it unchanged
+	RetentionDays     *int `json:"retentionDays,omitempty"`
+	RetentionMessages *int `json:"retentionMessages,omitempty"`
+
+	// Tags the room is found by, used by "set_tags"
+	Tags []string `json:"tags,omitempty"`
+
+	// Search, tag filter, order and page of "discover", as in GET /api/rooms/discover
+	Query  string `json:"query,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	Sort   string `json:"sort,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+}
+
+// roomActionTypes lists the message types handled as room actions
+var roomActionTypes = map[string]bool{
+	"create":                  true,
+	"join":                    true,
+	"leave":                   true,
+	"list":                    true,
+	"add_poster":              true,
+	"remove_poster":           true,
+	"set_topic":               true,
+	"set_ttl":                 true,
+	"set_webhook":             true,
+	"create_incoming_webhook": true,
+	"remove_incoming_webhook": true,
+	"ban":                     true,
+	"unban":                   true,
+	"set_role":                true,
+	"promote":                 true,
+	"demote":                  true,
+	"kick":                    true,
+	"mute":                    true,
+	"timeout":                 true,
+	"unmute":                  true,
+	"pin":                     true,
+	"unpin":                   true,
+	"report":                  true,
+	"set_retention":           true,
+	"set_tags":                true,
+	"discover":                true,
+	"set_slug":                true,
+	"archive":                 true,
+	"unarchive":               true,
+	"set_join_rule":           true,
+	"knock":                   true,
+	"join_requests":           true,
+	"approve_join":            true,
+	"deny_join":               true,
+	"room_settings":           true,
 }
 
 // HandleWebSocket handles WebSocket connections
 func HandleWebSocket(h *hub.Hub, w http.ResponseWriter, r *http.Request) {
-	// Upgrade HTTP connection to WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// The upgrade continues any trace of the request; the client's frames link to it
+	_, span := tracing.Tracer().Start(tracing.FromHeaders(r.Context(), r.Header), "websocket.upgrade",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("net.peer.addr", r.RemoteAddr)))
+	defer span.End()
+
+	// Shed new connections while the server is overloaded
+	if h.IsOverloaded() {
+		metrics.ConnectionsShed.Add(1)
+		span.SetStatus(codes.Error, "overloaded")
+		w.Header().Set("Retry-After", strconv.Itoa(h.RetryAfterSeconds()))
+		http.Error(w, "Server overloaded, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !allowedOrigin(h, r) {
+		span.SetStatus(codes.Error, "origin refused")
+		http.Error(w, "Connections with a session cookie must come from this chat's own site", http.StatusForbidden)
+		return
+	}
+
+	// API keys connect as their service account, and need the read scope
+	// since connections receive their rooms' messages
+	key, err := connectingKey(h, auth.Token(r))
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		span.SetStatus(codes.Error, "api key refused")
+		if errors.Is(err, errKeyRateLimited) {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	// Get username from query parameter
-	username := r.URL.Query().Get("username")
-	if username == "" {
-		username = "Anonymous"
+	// Logged-in users chat as their account and guests as their guest
+	// identity; others pick any name no account or guest has claimed
+	username, loggedIn := h.Auth.Session(auth.Token(r))
+	if key != nil {
+		username, loggedIn = key.Username, true
 	}
 
-	// Create a new client
-	client := &hub.Client{
-		ID:       generateClientID(),
-		Username: username,
-		Send:     make(chan []byte, 256),
-		Hub:      h,
-		RoomID:   "", // Will be set when joining a room
+	// Guests of embedded chat widgets only chat in the room they were
+	// issued for
+	embedUser, embedRoom, err := connectingEmbed(h, r)
+	if err != nil {
+		span.SetStatus(codes.Error, "embed refused")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if embedRoom != "" {
+		username, loggedIn = embedUser, true
+	}
+	if !loggedIn {
+		if h.Auth.GuestsOnly() {
+			span.SetStatus(codes.Error, "guests only")
+			http.Error(w, "Log in or get a guest identity from /api/auth/guest", http.StatusUnauthorized)
+			return
+		}
+		username = r.URL.Query().Get("username")
+		if username == "" {
+			username = "Anonymous"
+		}
+		if !hub.ValidUsername(username) {
+			span.SetStatus(codes.Error, "invalid username")
+			http.Error(w, "Usernames need 1 to "+strconv.Itoa(hub.MaxUsernameLength)+" characters and no spaces or '@'", http.StatusBadRequest)
+			return
+		}
+		if h.Auth.Reserved(username) {
+			span.SetStatus(codes.Error, "username claimed")
+			http.Error(w, "Log in to use this username", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Workspaces may be limited to their members, or turn away guests
+	space := workspace.FromContext(r.Context())
+	if !h.Workspaces.Admits(space, username, loggedIn && !h.Auth.Guest(username)) {
+		span.SetStatus(codes.Error, "not a workspace member")
+		http.Error(w, "This workspace is closed to you; log in with a member's account", http.StatusForbidden)
+		return
+	}
+
+	// Refuse connections beyond the connection limit
+	client := NewClient(h, username)
+	if !h.Admit(client) {
+		span.SetStatus(codes.Error, "connection limit")
+		w.Header().Set("Retry-After", strconv.Itoa(h.RetryAfterSeconds()))
+		http.Error(w, "Too many connections, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Operator hooks may rename or turn away the client
+	connect := &hooks.Connect{ClientID: client.ID, Username: username, RemoteAddr: r.RemoteAddr, Transport: hooks.TransportWebSocket}
+	if err := h.Hooks.Connect(connect); err != nil {
+		h.Release(client)
+		span.SetStatus(codes.Error, "refused by hook")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	// No two clients without an account share a username
+	if !loggedIn {
+		connect.Username = h.TakeUsername(client, connect.Username)
+	}
+	client.SetUsername(connect.Username)
+	client.Transport, client.RemoteAddr, client.ConnectedAt = connect.Transport, connect.RemoteAddr, time.Now()
+	client.Workspace = space
+	client.EmbedRoom = embedRoom
+	if key != nil {
+		client.APIKey = key
+	} else if loggedIn {
+		client.Session = auth.TokenSessionID(auth.Token(r))
+	}
+	client.Connection = span.SpanContext()
+	span.SetAttributes(attribute.String("chat.client_id", client.ID), attribute.String("chat.username", client.Username))
+	client.Supported |= hub.FeatureBinary
+	if offersDeflate(r) {
+		client.Supported |= hub.FeatureCompression
+	}
+
+	// Upgrade HTTP connection to WebSocket
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.Release(client)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "upgrade failed")
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
 	}
 
 	// Register the client with the hub
-	h.Register <- client
+	select {
+	case h.Register <- client:
+	case <-h.Done():
+		h.Release(client)
+		conn.Close()
+		return
+	}
+	// Every connection starts in the lobby of its workspace, and embedded
+	// chat widgets in their room
+	start := room.LobbyFor(client.Workspace)
+	if client.EmbedRoom != "" {
+		start = client.EmbedRoom
+	}
+	h.Recorder.Record(connectEvent(client, start))
 
 	// Start goroutines for reading and writing
 	go writePump(client, conn)
-	go readPump(client, conn)
+	go func() {
+		handleRoomAction(client, RoomAction{Type: "join", RoomID: start})
+		readPump(client, conn)
+	}()
 }
 
 // readPump pumps messages from the WebSocket connection to the hub
 func readPump(c *hub.Client, conn *websocket.Conn) {
 	defer func() {
-		c.Hub.Unregister <- c
+		Disconnect(c)
 		conn.Close()
 	}()
 
 	// Set read deadline and pong handler
-	conn.SetReadLimit(512)
-	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	limits := c.Hub.Connection()
+	conn.SetReadLimit(int64(limits.MaxFrameSize))
+	conn.SetReadDeadline(time.Now().Add(limits.ReadTimeout))
 	conn.SetPongHandler(func(string) error {
-		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		conn.SetReadDeadline(time.Now().Add(limits.ReadTimeout))
 		return nil
 	})
 
 	for {
-		_, messageBytes, err := conn.ReadMessage()
+		// The receive stage starts once a frame's header has arrived
+		_, reader, err := conn.NextReader()
+		received := time.Now()
+		var messageBytes []byte
+		if err == nil {
+			messageBytes, err = io.ReadAll(reader)
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
@@ -102,202 +422,1619 @@ func readPump(c *hub.Client, conn *websocket.Conn) {
 			break
 		}
 
-		// Try to parse as a room action first (only for specific room action types)
-		var roomAction RoomAction
-		if err := json.Unmarshal(messageBytes, &roomAction); err == nil && 
-			(roomAction.Type == "create" || roomAction.Type == "join" || 
-			 roomAction.Type == "leave" || roomAction.Type == "list") {
-			// Handle room operations
-			handleRoomAction(c, roomAction, conn)
-			continue
-		}
+		metrics.ObserveFrame(metrics.StageReceive, inboundFrameType(messageBytes), len(messageBytes), time.Since(received))
 
-		// Try to parse as a regular message
-		var msg Message
-		if err := json.Unmarshal(messageBytes, &msg); err != nil {
-			log.Printf("Error parsing message: %v", err)
-			continue
-		}
+		// A frame continues the trace of its traceparent field, if any
+		ctx, span := tracing.Tracer().Start(tracing.FromFrame(context.Background(), messageBytes), "websocket.read",
+			trace.WithTimestamp(received),
+			tracing.Link(c.Connection),
+			trace.WithAttributes(attribute.Int("chat.frame.size", len(messageBytes))))
+		c.Frame = ctx
+		HandleFrame(c, messageBytes)
+		c.Frame = nil
+		span.End()
+	}
+}
 
-		// Set the username and timestamp
-		msg.Username = c.Username
-		msg.Timestamp = time.Now().Format(time.RFC3339)
-		msg.RoomID = c.RoomID
+// NewClient returns a client for a user that hasn't joined a room yet
+func NewClient(h *hub.Hub, username string) *hub.Client {
+	return &hub.Client{
+		ID:       generateClientID(),
+		Username: username,
+		Send:     make(chan []byte, h.Connection().SendQueueSize),
+		Priority: make(chan []byte, h.Connection().PriorityQueueSize),
+		Hub:      h,
+		RoomID:   "", // Will be set when joining a room
 
-		// If client is in a room, send to that room
-		if c.RoomID != "" {
-			roomMessage := RoomMessage{
-				Type:      msg.Type,
-				Username:  msg.Username,
-				Content:   msg.Content,
-				Timestamp: msg.Timestamp,
-				RoomID:    c.RoomID,
-			}
-			
-			messageJSON, err := json.Marshal(roomMessage)
-			if err != nil {
-				log.Printf("Error marshaling room message: %v", err)
-				continue
-			}
-			
-			// Broadcast to the specific room
-			c.Hub.RoomManager.BroadcastToRoom(c.RoomID, messageJSON, nil)
-		} else {
-			// Broadcast to all clients (global chat)
-			messageJSON, err := json.Marshal(msg)
-			if err != nil {
-				log.Printf("Error marshaling message: %v", err)
-				continue
-			}
-			
-			c.Hub.Broadcast <- messageJSON
-		}
+		SendQueue:     metrics.NewSendQueue(),
+		PriorityQueue: metrics.NewPriorityQueue(),
+
+		// Features every transport provides
+		Supported: hub.FeatureAck,
 	}
 }
 
-// writePump pumps messages from the hub to the WebSocket connection
-func writePump(c *hub.Client, conn *websocket.Conn) {
-	ticker := time.NewTicker(54 * time.Second)
-	defer func() {
-		ticker.Stop()
-		conn.Close()
-	}()
+// Register adds a client connected over another transport to the hub and
+// joins it to the lobby, reporting false when the hub has stopped. The
+// client must hold a connection slot from Admit, which Register frees on
+// failure and the hub frees when the client is unregistered. Its
+// frames are then passed to HandleFrame, and Disconnect ends its session,
+// so every transport shares the WebSocket protocol.
+func Register(c *hub.Client) bool {
+	return RegisterIn(c, room.LobbyFor(c.Workspace))
+}
 
-	for {
-		select {
-		case message, ok := <-c.Send:
-			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
+// RegisterIn is Register for a client that joins roomID instead of the
+// lobby. The client is registered even when it can't join the room, and is
+// then in none.
+func RegisterIn(c *hub.Client, roomID string) bool {
+	select {
+	case c.Hub.Register <- c:
+	case <-c.Hub.Done():
+		c.Hub.Release(c)
+		return false
+	}
+	c.Hub.Recorder.Record(connectEvent(c, roomID))
 
-			w, err := conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
+	handleRoomAction(c, RoomAction{Type: "join", RoomID: roomID})
+	return true
+}
 
-			// Add queued chat messages to the current websocket message
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
-			}
+// connectEvent returns the recorded event of a client connecting and
+// joining roomID
+func connectEvent(c *hub.Client, roomID string) *replay.Event {
+	return &replay.Event{
+		Kind:      replay.KindConnect,
+		Client:    c.ID,
+		Username:  c.Username,
+		Features:  uint32(c.Supported),
+		Workspace: c.Workspace,
+		EmbedRoom: c.EmbedRoom,
+		Session:   c.Session,
+		Room:      roomID,
+	}
+}
 
-			if err := w.Close(); err != nil {
-				return
-			}
+// HandleFrame records and handles a JSON frame received from a client
+func HandleFrame(c *hub.Client, frame []byte) {
+	c.Hub.Recorder.Record(&replay.Event{Kind: replay.KindFrame, Client: c.ID, Data: string(frame)})
 
-		case <-ticker.C:
-			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				return
-			}
-		}
+	// Transports that don't trace their reads start the frame's trace here
+	parent := c.Frame
+	if parent == nil {
+		parent = tracing.FromFrame(context.Background(), frame)
 	}
+	frameType := inboundFrameType(frame)
+	ctx, span := tracing.Tracer().Start(parent, "chat.frame", tracing.Link(c.Connection), trace.WithAttributes(
+		attribute.String("chat.frame.type", frameType),
+		attribute.String("chat.client_id", c.ID),
+		attribute.String("chat.username", c.Username),
+		attribute.String("chat.room_id", c.RoomID),
+	))
+	c.Frame = ctx
+
+	processing := time.Now()
+	handleFrame(c, frame)
+	metrics.ObserveFrame(metrics.StageProcess, frameType, len(frame), time.Since(processing))
+
+	span.End()
+	c.Frame = parent
 }
 
-// generateClientID generates a unique client ID
-func generateClientID() string {
-	return time.Now().Format("20060102150405") + "-" + randomString(6)
+// Disconnect records a client going away and ends its session. Calls after
+// the first do nothing.
+func Disconnect(c *hub.Client) {
+	if !c.End() {
+		return
+	}
+	c.Hub.Recorder.Record(&replay.Event{Kind: replay.KindDisconnect, Client: c.ID})
+	disconnect(c)
 }
 
-// handleRoomAction handles room-related operations
-func handleRoomAction(c *hub.Client, action RoomAction, conn *websocket.Conn) {
-	switch action.Type {
-	case "create":
-		// Create a new room
-		roomID := c.Hub.RoomManager.CreateRoomAsync(action.RoomName, c.Username)
+// disconnect removes a client from its room and unregisters it from the hub
+func disconnect(c *hub.Client) {
+	c.Hub.Hooks.Disconnect(&hooks.Disconnect{ClientID: c.ID, Username: c.Username, RoomID: c.RoomID})
 
-		// Send room created response
-		response := map[string]interface{}{
-			"type":     "room_created",
-			"roomId":   roomID,
-			"roomName": action.RoomName,
-			"message":  "Room created successfully",
-		}
+	// Leave the current room before the hub closes the send channel
+	if c.RoomID != "" {
+		c.Hub.RoomManager.LeaveRoomAsync(c, c.RoomID)
+	}
 
-		responseJSON, _ := json.Marshal(response)
-		c.Send <- responseJSON
+	select {
+	case c.Hub.Unregister <- c:
+	case <-c.Hub.Done():
+	}
+}
 
-		// Auto-join the created room
-		joinAction := RoomAction{
-			Type:   "join",
-			RoomID: roomID,
+// inboundFrameType returns the metrics label of a frame sent by a client.
+// Types the server doesn't handle are grouped as "unknown" so clients
+// can't create arbitrary labels.
+func inboundFrameType(frame []byte) string {
+	frameType := metrics.FrameType(frame)
+	if roomActionTypes[frameType] || messageActionTypes[frameType] || pollActionTypes[frameType] || keyActionTypes[frameType] || blockActionTypes[frameType] || conversationActionTypes[frameType] || groupActionTypes[frameType] ||
+		frameType == "hello" || frameType == "dm" || frameType == "set_status" || frameType == "nick" || frameType == "who" || frameType == "load_more" || frameType == "message" || frameType == "gif" || frameType == "translate" {
+		return frameType
+	}
+	return "unknown"
+}
+
+// handleFrame dispatches a single frame received from a client
+func handleFrame(c *hub.Client, messageBytes []byte) {
+	// Try to parse as a room action first (only for specific room action types)
+	_, parse := tracing.ChildSpan(c.Frame, "chat.parse")
+	var roomAction RoomAction
+	err := json.Unmarshal(messageBytes, &roomAction)
+	if err != nil {
+		parse.SetStatus(codes.Error, "invalid JSON")
+	}
+	parse.End()
+
+	// A client kicked from its room is returned to the lobby, and anything
+	// it meant for the room is dropped
+	if returnKicked(c) && !roomActionTypes[roomAction.Type] {
+		return
+	}
+
+	// Clients connected with an API key are held to its scopes and rate limit
+	if !allowKeyFrame(c, roomAction.Type) {
+		return
+	}
+
+	// Embedded chat widgets only chat in their room
+	if !allowEmbedFrame(c, roomAction) {
+		return
+	}
+
+	if err == nil && roomActionTypes[roomAction.Type] {
+		// Handle room operations
+		handleRoomAction(c, roomAction)
+		return
+	}
+
+	// The protocol handshake
+	if roomAction.Type == "hello" {
+		var action HelloAction
+		if err := json.Unmarshal(messageBytes, &action); err == nil {
+			handleHello(c, action)
 		}
-		handleRoomAction(c, joinAction, conn)
+		return
+	}
 
-	case "join":
-		// Join a room
-		response := c.Hub.RoomManager.JoinRoomAsync(c, action.RoomID)
+	// Direct messages are routed by the hub
+	if roomAction.Type == "dm" {
+		if !c.Hub.Roles.Can(c.Username, "", rbac.PermDirectMessage) {
+			sendPermissionError(c, deniedMessage(c, "Your role does not allow direct messages", "Guests can't send direct messages; sign in to send them"))
+			return
+		}
+		handleDirectMessage(c, messageBytes)
+		return
+	}
 
-		if response.Success {
-			c.RoomID = action.RoomID
+	// Direct message conversations keep their history
+	if conversationActionTypes[roomAction.Type] {
+		var action ConversationAction
+		if err := json.Unmarshal(messageBytes, &action); err == nil {
+			handleConversationAction(c, action)
+		}
+		return
+	}
 
-			// Send join success response
-			joinResponse := map[string]interface{}{
-				"type":     "room_joined",
-				"roomId":   action.RoomID,
-				"roomName": response.Room.Name,
-				"message":  "Successfully joined room",
-			}
+	// Group conversations are private rooms of the users taking part
+	if groupActionTypes[roomAction.Type] {
+		var action GroupAction
+		if err := json.Unmarshal(messageBytes, &action); err == nil {
+			handleGroupAction(c, action)
+		}
+		return
+	}
 
-			joinResponseJSON, _ := json.Marshal(joinResponse)
-			c.Send <- joinResponseJSON
-		} else {
-			// Send join error response
-			errorResponse := map[string]interface{}{
-				"type":    "room_error",
-				"message": response.Message,
-			}
+	// Edits, deletions and reactions change existing room messages
+	if messageActionTypes[roomAction.Type] {
+		var action MessageAction
+		if err := json.Unmarshal(messageBytes, &action); err == nil {
+			handleMessageAction(c, action)
+		}
+		return
+	}
 
-			errorResponseJSON, _ := json.Marshal(errorResponse)
-			c.Send <- errorResponseJSON
+	// Polls are tallied by the server and their results broadcast live
+	if pollActionTypes[roomAction.Type] {
+		var action PollAction
+		if err := json.Unmarshal(messageBytes, &action); err == nil {
+			handlePollAction(c, action)
 		}
+		return
+	}
 
-	case "leave":
-		// Leave current room
-		if c.RoomID != "" {
-			success := c.Hub.RoomManager.LeaveRoomAsync(c, c.RoomID)
+	// Device keys let clients encrypt end to end
+	if keyActionTypes[roomAction.Type] {
+		var action KeyAction
+		if err := json.Unmarshal(messageBytes, &action); err == nil {
+			handleKeyAction(c, action)
+		}
+		return
+	}
 
-			if success {
-				c.RoomID = ""
+	// Presence statuses are shared with every room the user is in
+	if roomAction.Type == "set_status" {
+		var action StatusAction
+		if err := json.Unmarshal(messageBytes, &action); err == nil {
+			handleStatusAction(c, action)
+		}
+		return
+	}
 
-				// Send leave success response
-				leaveResponse := map[string]interface{}{
-					"type":    "room_left",
-					"message": "Successfully left room",
-				}
+	// Clients without an account may change their username
+	if roomAction.Type == "nick" {
+		var action NickAction
+		if err := json.Unmarshal(messageBytes, &action); err == nil {
+			handleNick(c, action.Username)
+		}
+		return
+	}
 
-				leaveResponseJSON, _ := json.Marshal(leaveResponse)
-				c.Send <- leaveResponseJSON
-			}
+	// Users hide the messages of users they block
+	if blockActionTypes[roomAction.Type] {
+		var action BlockAction
+		if err := json.Unmarshal(messageBytes, &action); err == nil {
+			handleBlockAction(c, action)
 		}
+		return
+	}
 
-	case "list":
-		// List all available rooms
-		rooms := c.Hub.RoomManager.GetRooms()
-
-		roomList := make([]map[string]interface{}, 0, len(rooms))
-		for _, room := range rooms {
-			roomList = append(roomList, map[string]interface{}{
-				"id":          room.ID,
-				"name":        room.Name,
-				"clientCount": room.GetClientCount(),
-				"createdBy":   room.CreatedBy,
-				"createdAt":   room.CreatedAt.Format(time.RFC3339),
-			})
+	// Member lists cover every node of a cluster
+	if roomAction.Type == "who" {
+		handleWho(c)
+		return
+	}
+
+	// Clients page through history as they scroll
+	if roomAction.Type == "load_more" {
+		var action HistoryAction
+		if err := json.Unmarshal(messageBytes, &action); err == nil {
+			handleLoadMore(c, action)
 		}
+		return
+	}
 
-		response := map[string]interface{}{
-			"type":  "room_list",
-			"rooms": roomList,
+	// Translations are sent only to the user who asked
+	if roomAction.Type == "translate" {
+		var action TranslateAction
+		if err := json.Unmarshal(messageBytes, &action); err == nil {
+			handleTranslate(c, action)
 		}
+		return
+	}
 
-		responseJSON, _ := json.Marshal(response)
-		c.Send <- responseJSON
+	// Try to parse as a regular message
+	var msg Message
+	if err := json.Unmarshal(messageBytes, &msg); err != nil {
+		log.Printf("Error parsing message: %v", err)
+		return
+	}
+
+	// Set the username and timestamp
+	msg.Username = c.Username
+	msg.Timestamp = time.Now().Format(time.RFC3339)
+	msg.RoomID = c.RoomID
+
+	// Every message belongs to a room; clients start in the lobby
+	if c.RoomID == "" {
+		sendRoomError(c, "Join a room to send messages")
+		return
+	}
+
+	r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+	if exists && r.IsBanned(c.Username) {
+		sendPermissionError(c, "You are banned from this room")
+		return
+	}
+
+	// Archived rooms keep their history but take no new messages
+	if exists && rejectArchived(c, r) {
+		return
+	}
+
+	if !c.Hub.Roles.Can(c.Username, c.RoomID, rbac.PermPost) {
+		sendPermissionError(c, "Your role does not allow posting in this room")
+		return
+	}
+
+	// Chat messages and GIFs are recorded in the room's history
+	posted := msg.Type == "message" || msg.Type == "gif"
+
+	// Encrypted rooms only take ciphertext, which the server can't read, so
+	// hooks, bots, spam checks and custom emoji skip their messages. A GIF
+	// would be posted in the clear, so they take none.
+	encrypted := false
+	if msg.Type == "gif" && exists && r.IsEncrypted() {
+		sendRoomError(c, "GIFs can't be posted in end-to-end encrypted rooms")
+		return
 	}
+	if msg.Type == "message" {
+		if !checkEncryption(c, exists && r.IsEncrypted(), msg.Encryption) {
+			return
+		}
+		encrypted = msg.Encryption != nil
+	} else {
+		msg.Encryption = nil
+	}
+
+	// A message resent after a reconnect is acknowledged again, not reposted
+	if posted && msg.ClientMessageID != "" {
+		if len(msg.ClientMessageID) > maxClientMessageIDLength {
+			sendRoomError(c, "Client message ID is too long")
+			return
+		}
+		if original, err := c.Hub.History.ClientMessage(c.RoomID, c.Username, msg.ClientMessageID); err == nil {
+			sendMessageAck(c, original, true)
+			return
+		}
+	}
+
+	if exists && rejectMuted(c, r) {
+		return
+	}
+
+	// Guests may only send so many chat messages a minute
+	if posted && !c.Hub.AllowGuestMessage(c.Username) {
+		sendRoomError(c, fmt.Sprintf("Guests can send %d messages a minute; wait a moment or sign in", c.Hub.GuestRateLimit()))
+		return
+	}
+
+	// Operator hooks may rewrite or reject chat messages, commands included
+	if msg.Type == "message" && !encrypted {
+		event := &hooks.Message{Kind: hooks.KindMessage, ClientID: c.ID, Username: c.Username, RoomID: c.RoomID, Content: msg.Content}
+		if err := c.Hub.Hooks.Message(event); err != nil {
+			sendRoomError(c, err.Error())
+			return
+		}
+		msg.Content = event.Content
+	}
+
+	// Slash commands go to bots instead of the room
+	if msg.Type == "message" && !encrypted && strings.HasPrefix(msg.Content, "/") {
+		handleCommand(c, msg.Content)
+		return
+	}
+
+	// Announcement rooms only accept messages from designated posters, and
+	// rooms may limit posting to posters or moderators
+	if exists && !r.CanPost(c.Username) {
+		sendPermissionError(c, postPolicyError(r))
+		return
+	}
+
+	// GIFs are looked up at the provider by ID, so only its media is posted
+	var gifMessage *store.GIF
+	if msg.Type == "gif" {
+		if gifMessage, err = c.Hub.GIFs.GIF(c.Frame, msg.GIFID); err != nil {
+			if !errors.Is(err, gif.ErrDisabled) && !errors.Is(err, gif.ErrNotFound) {
+				log.Printf("Error looking up GIF %q: %v", msg.GIFID, err)
+				err = errors.New("Could not find the GIF; try again")
+			}
+			sendRoomError(c, err.Error())
+			return
+		}
+		msg.Content = gifMessage.URL
+	}
+
+	// Slow mode spaces out each user's messages
+	if posted && exists && rejectSlowMode(c, r) {
+		return
+	}
+
+	// Profanity is masked or refused as the room's settings ask
+	if msg.Type == "message" && !encrypted && exists {
+		content, allowed := c.Hub.FilterProfanity(r, msg.Content)
+		if !allowed {
+			sendRoomError(c, "Messages with profanity aren't allowed in this room")
+			return
+		}
+		msg.Content = content
+	}
+
+	// Spam can get its sender muted, dropping the message
+	if msg.Type == "message" && !encrypted && exists && rejectSpam(c, r, msg.Content) {
+		return
+	}
+
+	// Custom emoji in chat messages must be registered
+	var customEmoji map[string]string
+	if msg.Type == "message" && !encrypted {
+		var unknown []string
+		customEmoji, unknown = c.Hub.Emoji.Resolve(msg.Content)
+		if len(unknown) > 0 {
+			sendRoomError(c, "Unknown emoji :"+unknown[0]+":")
+			return
+		}
+	}
+
+	// Record chat messages in the room's history
+	var expiresAt, html string
+	var seq int64
+	var entities []store.Entity
+	if posted {
+		ttl := time.Duration(msg.TTL) * time.Second
+		if msg.TTL < 0 || ttl > room.MaxMessageTTL {
+			sendRoomError(c, "Invalid message ttl")
+			return
+		}
+		if ttl == 0 && exists {
+			ttl = r.GetMessageTTL()
+		}
+
+		var recorded *history.Message
+		var duplicate bool
+		if gifMessage != nil {
+			recorded, duplicate, err = c.Hub.History.PostGIF(c.RoomID, c.Username, msg.ClientMessageID, gifMessage, ttl)
+		} else {
+			recorded, duplicate, err = c.Hub.History.PostEncrypted(c.RoomID, c.Username, msg.ClientMessageID, msg.Content, msg.Encryption, ttl)
+		}
+		if err != nil {
+			log.Printf("Error recording message: %v", err)
+			sendRoomError(c, "Could not save message")
+			return
+		}
+		if msg.ClientMessageID != "" || c.Has(hub.FeatureAck) {
+			sendMessageAck(c, recorded, duplicate)
+		}
+		if duplicate {
+			return
+		}
+		msg.ID = recorded.ID
+		seq = recorded.Seq
+		html, entities = recorded.HTML, recorded.Entities
+		if recorded.ExpiresAt != nil {
+			expiresAt = recorded.ExpiresAt.Format(time.RFC3339)
+		}
+	}
+
+	roomMessage := RoomMessage{
+		ID:         msg.ID,
+		Type:       msg.Type,
+		Username:   msg.Username,
+		Content:    msg.Content,
+		Timestamp:  msg.Timestamp,
+		RoomID:     c.RoomID,
+		Seq:        seq,
+		ExpiresAt:  expiresAt,
+		Emoji:      customEmoji,
+		Encryption: msg.Encryption,
+		GIF:        gifMessage,
+		HTML:       html,
+		Entities:   entities,
+	}
+
+	messageJSON, err := json.Marshal(roomMessage)
+	if err != nil {
+		log.Printf("Error marshaling room message: %v", err)
+		return
+	}
+
+	// Broadcast to the specific room but users who block the sender, tracing
+	// its routing and fan-out under the frame
+	trace.SpanFromContext(c.Frame).SetAttributes(attribute.String("chat.message_id", msg.ID), attribute.Int64("chat.seq", int64(seq)))
+	c.Hub.RoomManager.BroadcastFrom(c.Frame, c.RoomID, c.Username, messageJSON, nil)
+}
+
+// newline separates the frames coalesced into one WebSocket message
+var newline = []byte{'\n'}
+
+// writePump pumps messages from the hub to the WebSocket connection
+func writePump(c *hub.Client, conn *websocket.Conn) {
+	limits := c.Hub.Connection()
+	ticker := time.NewTicker(limits.PingInterval)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	// Frames coalesced into the current write, reused for every write
+	var batch [][]byte
+
+	for {
+		// Flush high-priority frames before regular traffic
+		select {
+		case message := <-c.Priority:
+			if err := writeFrame(c, conn, message); err != nil {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case message := <-c.Priority:
+			if err := writeFrame(c, conn, message); err != nil {
+				return
+			}
+
+		case <-c.Closing():
+			// The hub disconnected the client; readPump then ends its session
+			closeConn(c, conn)
+			return
+
+		case message, ok := <-c.Send:
+			if !ok {
+				closeConn(c, conn)
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(limits.WriteTimeout))
+			writing := time.Now()
+
+			conn.EnableWriteCompression(c.Has(hub.FeatureCompression))
+			w, err := conn.NextWriter(messageType(c))
+			if err != nil {
+				return
+			}
+			w.Write(message)
+			recordDelivery(c, replay.ChannelSend, message)
+
+			// Add queued chat messages to the current websocket message
+			batch = append(batch[:0], message)
+			n := len(c.Send)
+			for i := 0; i < n; i++ {
+				queued := <-c.Send
+				w.Write(newline)
+				w.Write(queued)
+				recordDelivery(c, replay.ChannelSend, queued)
+				batch = append(batch, queued)
+			}
+
+			if err := w.Close(); err != nil {
+				return
+			}
+
+			// Frames written together share the write's latency
+			elapsed := time.Since(writing)
+			for _, frame := range batch {
+				metrics.ObserveFrame(metrics.StageDeliver, metrics.FrameType(frame), len(frame), elapsed)
+			}
+			clear(batch)
+
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(limits.WriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// closeConn delivers any final priority frames, such as a shutdown notice,
+// and closes the WebSocket connection
+func closeConn(c *hub.Client, conn *websocket.Conn) {
+	for len(c.Priority) > 0 {
+		if err := writeFrame(c, conn, <-c.Priority); err != nil {
+			return
+		}
+	}
+	conn.SetWriteDeadline(time.Now().Add(c.Hub.Connection().WriteTimeout))
+	conn.WriteMessage(websocket.CloseMessage, []byte{})
+}
+
+// writeFrame writes a single priority frame to the connection
+func writeFrame(c *hub.Client, conn *websocket.Conn, message []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(c.Hub.Connection().WriteTimeout))
+	writing := time.Now()
+	conn.EnableWriteCompression(c.Has(hub.FeatureCompression))
+	if err := conn.WriteMessage(messageType(c), message); err != nil {
+		return err
+	}
+	recordDelivery(c, replay.ChannelPriority, message)
+	metrics.ObserveFrame(metrics.StageDeliver, metrics.FrameType(message), len(message), time.Since(writing))
+	return nil
+}
+
+// messageType returns the WebSocket message type frames are sent to the client as
+func messageType(c *hub.Client) int {
+	if c.Has(hub.FeatureBinary) {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// recordDelivery records a frame written to a client from one of its channels
+func recordDelivery(c *hub.Client, channel string, frame []byte) {
+	// Don't build the event unless it is recorded
+	if c.Hub.Recorder == nil {
+		return
+	}
+	c.Hub.Recorder.Record(&replay.Event{Kind: replay.KindDeliver, Client: c.ID, Source: channel, Data: string(frame)})
+}
+
+// generateClientID generates a unique client ID
+func generateClientID() string {
+	return time.Now().Format("20060102150405") + "-" + randomString(6)
+}
+
+// handleRoomAction handles room-related operations
+func handleRoomAction(c *hub.Client, action RoomAction) {
+	switch action.Type {
+	case "create":
+		if !c.Hub.Roles.Can(c.Username, "", rbac.PermCreateRoom) {
+			sendPermissionError(c, deniedMessage(c, "Your role does not allow creating rooms", "Guests can't create rooms; sign in to create one"))
+			return
+		}
+
+		mode := room.ModeNormal
+		switch room.Mode(action.Mode) {
+		case room.ModeAnnouncement, room.ModeSupport:
+			mode = room.Mode(action.Mode)
+		}
+
+		// Support transcripts are emailed, so their messages must stay readable
+		if action.Encrypted && mode == room.ModeSupport {
+			sendRoomError(c, "Support rooms can't be end-to-end encrypted")
+			return
+		}
+
+		// Visitors may ask for a transcript of a support conversation
+		var visitorEmail string
+		if action.Email != "" {
+			addr, err := mail.ParseAddress(action.Email)
+			if err != nil || mode != room.ModeSupport {
+				sendRoomError(c, "A transcript email can only be set on a support room and must be a valid address")
+				return
+			}
+			visitorEmail = addr.Address
+		}
+
+		// A slug must be free before the room is created
+		slug := strings.ToLower(strings.TrimSpace(action.Slug))
+		if slug != "" && !room.ValidSlug(slug) {
+			sendRoomError(c, "A slug is 2 to 40 lowercase letters, digits or '-'")
+			return
+		}
+		if _, taken := c.Hub.RoomManager.FindSlug(c.Workspace, slug); taken {
+			sendRoomError(c, "Another room already has this slug")
+			return
+		}
+
+		// Create a new room
+		roomID := c.Hub.RoomManager.CreateRoomAsync(c.Workspace, action.RoomName, c.Username, mode, action.Encrypted)
+
+		// Send room created response
+		response := map[string]interface{}{
+			"type":      "room_created",
+			"roomId":    roomID,
+			"roomName":  action.RoomName,
+			"slug":      slug,
+			"mode":      mode,
+			"encrypted": action.Encrypted,
+			"message":   "Room created successfully",
+		}
+
+		responseJSON, _ := json.Marshal(response)
+		c.Send <- responseJSON
+
+		// Auto-join the created room
+		joinAction := RoomAction{
+			Type:   "join",
+			RoomID: roomID,
+		}
+		handleRoomAction(c, joinAction)
+
+		// The manager has registered the room once the join is handled
+		if r, exists := c.Hub.RoomManager.GetRoom(roomID); exists {
+			if visitorEmail != "" {
+				r.SetVisitorEmail(visitorEmail)
+			}
+			if slug != "" {
+				if err := c.Hub.RoomManager.SetSlug(r, slug); err != nil {
+					sendRoomError(c, "The room was created without its slug, which another room took meanwhile")
+				}
+			}
+		}
+
+	case "join":
+		// Operator hooks may redirect or refuse the join
+		join := &hooks.JoinRoom{ClientID: c.ID, Username: c.Username, RoomID: action.RoomID}
+		if err := c.Hub.Hooks.JoinRoom(join); err != nil {
+			sendRoomError(c, err.Error())
+			return
+		}
+		action.RoomID = join.RoomID
+
+		// Rooms are joined by ID or slug; rooms of other workspaces can't be
+		// joined, or even seen
+		if r, exists := c.Hub.Room(c.Workspace, action.RoomID); exists {
+			action.RoomID = r.ID
+		} else if _, exists := c.Hub.RoomManager.GetRoom(action.RoomID); exists {
+			sendRoomError(c, "Room not found")
+			return
+		}
+
+		if action.RoomID == c.RoomID {
+			sendRoomError(c, "You are already in this room")
+			return
+		}
+
+		// Rooms that ask to knock only let approved users in, and full rooms
+		// only moderators
+		if r, exists := c.Hub.RoomManager.GetRoom(action.RoomID); exists && (knockRequired(c, r) || rejectFull(c, r)) {
+			return
+		}
+
+		// Join a room
+		response := c.Hub.RoomManager.JoinRoomAsync(c, action.RoomID)
+
+		if response.Success {
+			// A client is in one room at a time, so leave the previous one
+			if c.RoomID != "" {
+				c.Hub.RoomManager.LeaveRoomAsync(c, c.RoomID)
+			}
+			c.RoomID = action.RoomID
+			checkRoomHop(c, response.Room)
+
+			// Send join success response
+			topic, description := response.Room.GetTopic()
+			joinResponse := map[string]interface{}{
+				"type":        "room_joined",
+				"roomId":      action.RoomID,
+				"roomName":    response.Room.Name,
+				"slug":        response.Room.GetSlug(),
+				"username":    c.Username,
+				"mode":        response.Room.Mode,
+				"encrypted":   response.Room.IsEncrypted(),
+				"topic":       topic,
+				"description": description,
+				"tags":        response.Room.GetTags(),
+				"archived":    response.Room.IsArchived(),
+				"joinRule":    response.Room.GetJoinRule(),
+				"messageTtl":  int(response.Room.GetMessageTTL().Seconds()),
+				"retention":   retentionPolicy(c.Hub, action.RoomID),
+				"settings":    response.Room.GetSettings(),
+				"canPost":     response.Room.CanPost(c.Username) && c.Hub.Roles.Can(c.Username, action.RoomID, rbac.PermPost) && !response.Room.IsArchived(),
+				"role":        c.Hub.Roles.Role(c.Username, action.RoomID),
+				"polls":       c.Hub.Polls.Room(action.RoomID),
+				"pins":        c.Hub.Pinned(action.RoomID),
+				"members":     c.Hub.Members(append(c.Hub.RoomMemberNames(action.RoomID), c.Username)),
+				"message":     "Successfully joined room",
+			}
+
+			// Clients spot missed messages by the gaps after this number
+			if lastSeq, err := c.Hub.History.LastSeq(action.RoomID); err == nil {
+				joinResponse["lastSeq"] = lastSeq
+			}
+
+			if until, muted := response.Room.MutedUntil(c.Username); muted {
+				joinResponse["muted"] = true
+				if !until.IsZero() {
+					joinResponse["mutedUntil"] = until.Format(time.RFC3339)
+				}
+			}
+
+			joinResponseJSON, _ := json.Marshal(joinResponse)
+			c.Send <- joinResponseJSON
+			sendWelcome(c, response.Room)
+		} else {
+			// Send join error response
+			sendRoomError(c, response.Message)
+		}
+
+	case "leave":
+		// Leaving a room returns the client to the lobby
+		if room.IsLobby(c.RoomID) {
+			sendRoomError(c, "You are already in the lobby")
+			return
+		}
+
+		if c.RoomID != "" {
+			success := c.Hub.RoomManager.LeaveRoomAsync(c, c.RoomID)
+
+			if success {
+				c.RoomID = ""
+
+				// Send leave success response
+				leaveResponse := map[string]interface{}{
+					"type":    "room_left",
+					"message": "Successfully left room",
+				}
+
+				leaveResponseJSON, _ := json.Marshal(leaveResponse)
+				c.Send <- leaveResponseJSON
+			}
+		}
+
+		handleRoomAction(c, RoomAction{Type: "join", RoomID: room.LobbyFor(c.Workspace)})
+
+	case "list":
+		// List all available rooms with their last message and this user's
+		// unread count; archived rooms only when asked for
+		roomList := c.Hub.RoomList(c.Workspace, c.Username, action.Archived)
+
+		response := map[string]interface{}{
+			"type":  "room_list",
+			"rooms": roomList,
+		}
+
+		responseJSON, _ := json.Marshal(response)
+		c.Send <- responseJSON
+
+	case "add_poster", "remove_poster":
+		// Only moderators can manage posters
+		r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+		if !exists {
+			sendRoomError(c, "You are not in a room")
+			return
+		}
+		if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermModerate) {
+			sendPermissionError(c, "Only moderators can manage posters")
+			return
+		}
+		if action.Username == "" {
+			sendRoomError(c, "A username is required")
+			return
+		}
+
+		if action.Type == "add_poster" {
+			r.AddPoster(action.Username)
+		} else {
+			r.RemovePoster(action.Username)
+		}
+
+		response := map[string]interface{}{
+			"type":     "posters_updated",
+			"roomId":   r.ID,
+			"username": action.Username,
+			"canPost":  action.Type == "add_poster",
+		}
+
+		// Let the whole room know, ahead of any chat backlog
+		responseJSON, _ := json.Marshal(response)
+		c.Hub.SendPriority(&hub.PriorityMessage{RoomID: r.ID, Message: responseJSON})
+
+	case "set_topic":
+		// Only moderators can change the topic
+		r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+		if !exists {
+			sendRoomError(c, "You are not in a room")
+			return
+		}
+		if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermModerate) {
+			sendPermissionError(c, "Only moderators can change the topic")
+			return
+		}
+
+		r.SetTopic(action.Topic, action.Description)
+
+		// Announce the change to everyone in the room
+		event := map[string]interface{}{
+			"type":        "topic_changed",
+			"roomId":      r.ID,
+			"topic":       action.Topic,
+			"description": action.Description,
+			"username":    c.Username,
+			"timestamp":   time.Now().Format(time.RFC3339),
+		}
+
+		eventJSON, _ := json.Marshal(event)
+		c.Hub.RoomManager.BroadcastToRoom(r.ID, eventJSON, nil)
+
+	case "set_tags":
+		// Moderators tag rooms so they can be found in room discovery
+		r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+		if !exists {
+			sendRoomError(c, "You are not in a room")
+			return
+		}
+		if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermModerate) {
+			sendPermissionError(c, "Only moderators can change the tags")
+			return
+		}
+
+		tags, err := r.SetTags(action.Tags)
+		if err != nil {
+			sendRoomError(c, "A room can have at most 5 tags of 1 to 24 letters, digits or '-'")
+			return
+		}
+
+		// Announce the change to everyone in the room
+		event := map[string]interface{}{
+			"type":      "tags_changed",
+			"roomId":    r.ID,
+			"tags":      tags,
+			"username":  c.Username,
+			"timestamp": time.Now().Format(time.RFC3339),
+		}
+
+		eventJSON, _ := json.Marshal(event)
+		c.Hub.RoomManager.BroadcastToRoom(r.ID, eventJSON, nil)
+
+	case "set_slug":
+		// Room admins choose the name the room is linked by
+		r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+		if !exists {
+			sendRoomError(c, "You are not in a room")
+			return
+		}
+		if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermManageRoom) {
+			sendPermissionError(c, "Only room admins can change the slug")
+			return
+		}
+		if room.IsLobby(r.ID) || r.IsGroup() {
+			sendRoomError(c, "The lobby and group conversations can't have a slug")
+			return
+		}
+
+		switch err := c.Hub.RoomManager.SetSlug(r, action.Slug); {
+		case errors.Is(err, room.ErrSlugTaken):
+			sendRoomError(c, "Another room already has this slug")
+			return
+		case err != nil:
+			sendRoomError(c, "A slug is 2 to 40 lowercase letters, digits or '-'")
+			return
+		}
+
+		// Announce the change to everyone in the room
+		event := map[string]interface{}{
+			"type":      "slug_changed",
+			"roomId":    r.ID,
+			"slug":      r.GetSlug(),
+			"username":  c.Username,
+			"timestamp": time.Now().Format(time.RFC3339),
+		}
+
+		eventJSON, _ := json.Marshal(event)
+		c.Hub.RoomManager.BroadcastToRoom(r.ID, eventJSON, nil)
+
+	case "discover":
+		// Search and page through the rooms instead of listing them all
+		if !hub.ValidSort(action.Sort) {
+			sendRoomError(c, "Sort rooms by active, members, new or name")
+			return
+		}
+		response := c.Hub.Discover(c.Workspace, c.Username, hub.Discovery{
+			Query:  action.Query,
+			Tag:    action.Tag,
+			Sort:   action.Sort,
+			Limit:  action.Limit,
+			Offset: action.Offset,
+		})
+		response["type"] = "room_discovery"
+
+		responseJSON, _ := json.Marshal(response)
+		c.Send <- responseJSON
+
+	case "set_webhook":
+		// Only room admins can bridge the room to another system
+		r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+		if !exists {
+			sendRoomError(c, "You are not in a room")
+			return
+		}
+		if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermManageRoom) {
+			sendPermissionError(c, "Only room admins can change the webhook")
+			return
+		}
+
+		var secret string
+		if action.URL != "" {
+			if err := webhook.CheckURL(action.URL); errors.Is(err, webhook.ErrPrivateAddress) {
+				sendRoomError(c, "The webhook must be on a public address")
+				return
+			} else if err != nil {
+				sendRoomError(c, "The webhook must be an http or https URL")
+				return
+			}
+			secret = randomSecret()
+		}
+
+		r.SetWebhook(action.URL, secret)
+
+		// The secret is only shown to the admin, who configures the receiver with it
+		response := map[string]interface{}{
+			"type":   "webhook_updated",
+			"roomId": r.ID,
+			"url":    action.URL,
+			"secret": secret,
+		}
+
+		responseJSON, _ := json.Marshal(response)
+		c.Send <- responseJSON
+
+	case "create_incoming_webhook", "remove_incoming_webhook":
+		// Only room admins can let other systems post to the room
+		r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+		if !exists {
+			sendRoomError(c, "You are not in a room")
+			return
+		}
+		if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermManageRoom) {
+			sendPermissionError(c, "Only room admins can change the incoming webhook")
+			return
+		}
+		if action.Type == "create_incoming_webhook" && r.IsEncrypted() {
+			sendRoomError(c, "Other systems can't post to an end-to-end encrypted room")
+			return
+		}
+
+		// Creating a webhook replaces any earlier one, whose URL stops working
+		var token, path string
+		if action.Type == "create_incoming_webhook" {
+			token = randomSecret()
+			path = "/api/hooks/" + r.ID + "/" + token
+		}
+		r.SetIncomingToken(token)
+
+		// The URL is only shown to the admin, who configures the sender with it
+		response := map[string]interface{}{
+			"type":   "incoming_webhook_updated",
+			"roomId": r.ID,
+			"path":   path,
+		}
+
+		responseJSON, _ := json.Marshal(response)
+		c.Send <- responseJSON
+
+	case "set_ttl":
+		// Only room admins can make messages disappear
+		r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+		if !exists {
+			sendRoomError(c, "You are not in a room")
+			return
+		}
+		if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermManageRoom) {
+			sendPermissionError(c, "Only room admins can change the message ttl")
+			return
+		}
+		if action.TTL == nil || *action.TTL < 0 || time.Duration(*action.TTL)*time.Second > room.MaxMessageTTL {
+			sendRoomError(c, "A ttl between 0 and "+strconv.Itoa(int(room.MaxMessageTTL.Seconds()))+" seconds is required")
+			return
+		}
+
+		r.SetMessageTTL(time.Duration(*action.TTL) * time.Second)
+
+		event := map[string]interface{}{
+			"type":      "ttl_changed",
+			"roomId":    r.ID,
+			"ttl":       *action.TTL,
+			"username":  c.Username,
+			"timestamp": time.Now().Format(time.RFC3339),
+		}
+
+		eventJSON, _ := json.Marshal(event)
+		c.Hub.RoomManager.BroadcastToRoom(r.ID, eventJSON, nil)
+
+	case "set_retention":
+		// Only room admins can change how much history the room keeps
+		r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+		if !exists {
+			sendRoomError(c, "You are not in a room")
+			return
+		}
+		if !c.Hub.Roles.Can(c.Username, r.ID, rbac.PermManageRoom) {
+			sendPermissionError(c, "Only room admins can change the retention policy")
+			return
+		}
+		days, messages := r.GetRetention()
+		if action.RetentionDays != nil {
+			days = *action.RetentionDays
+		}
+		if action.RetentionMessages != nil {
+			messages = *action.RetentionMessages
+		}
+		if days < 0 || messages < 0 {
+			sendRoomError(c, "Retention days and messages must not be negative")
+			return
+		}
+
+		r.SetRetention(days, messages)
+		c.Hub.Audit.Record(store.AuditRecord{
+			Actor:   c.Username,
+			Action:  audit.ActionSetRetention,
+			RoomID:  r.ID,
+			Reason:  action.Reason,
+			Details: map[string]string{"days": strconv.Itoa(days), "messages": strconv.Itoa(messages)},
+		})
+
+		event := map[string]interface{}{
+			"type":      "retention_updated",
+			"roomId":    r.ID,
+			"retention": retentionPolicy(c.Hub, r.ID),
+			"username":  c.Username,
+			"timestamp": time.Now().Format(time.RFC3339),
+		}
+
+		eventJSON, _ := json.Marshal(event)
+		c.Hub.RoomManager.BroadcastToRoom(r.ID, eventJSON, nil)
+
+	case "ban", "unban":
+		// Moderators can ban users with a lower role than their own
+		r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+		if !exists {
+			sendRoomError(c, "You are not in a room")
+			return
+		}
+		role := c.Hub.Roles.Role(c.Username, r.ID)
+		if !role.Has(rbac.PermModerate) {
+			sendPermissionError(c, "Only moderators can ban users")
+			return
+		}
+		if action.Username == "" || action.Username == r.CreatedBy {
+			sendRoomError(c, "A valid username is required")
+			return
+		}
+		if !role.Outranks(c.Hub.Roles.Role(action.Username, r.ID)) {
+			sendPermissionError(c, "You can only ban users with a lower role than yours")
+			return
+		}
+
+		c.Hub.Audit.Record(store.AuditRecord{
+			Actor:  c.Username,
+			Action: action.Type,
+			Target: action.Username,
+			RoomID: r.ID,
+			Reason: action.Reason,
+		})
+
+		if action.Type == "unban" {
+			r.Unban(action.Username)
+		} else {
+			r.Ban(action.Username)
+
+			// Remove the user from the room if they are currently in it
+			if target, ok := r.FindClient(action.Username); ok {
+				select {
+				case r.Unregister <- target:
+				case <-r.Done():
+				}
+
+				notice, _ := json.Marshal(map[string]interface{}{
+					"type":    "room_banned",
+					"roomId":  r.ID,
+					"message": "You have been banned from this room",
+				})
+				target.Notify(notice)
+			}
+		}
+
+		response := map[string]interface{}{
+			"type":     "ban_updated",
+			"roomId":   r.ID,
+			"username": action.Username,
+			"banned":   action.Type == "ban",
+		}
+
+		responseJSON, _ := json.Marshal(response)
+		c.Send <- responseJSON
+
+	case "set_role":
+		handleSetRole(c, action)
+
+	case "promote", "demote":
+		handlePromotion(c, action)
+
+	case "kick", "mute", "timeout", "unmute", "pin", "unpin":
+		handleModeration(c, action)
+
+	case "report":
+		handleReport(c, action)
+
+	case "archive", "unarchive":
+		handleArchive(c, action)
+
+	case "set_join_rule":
+		handleSetJoinRule(c, action)
+
+	case "knock":
+		handleKnock(c, action)
+
+	case "join_requests":
+		handleJoinRequests(c, action)
+
+	case "approve_join", "deny_join":
+		handleAnswerKnock(c, action)
+
+	case "room_settings":
+		handleRoomSettings(c, action)
+	}
+}
+
+// retentionPolicy describes how much history a room keeps, 0 meaning no limit
+func retentionPolicy(h *hub.Hub, roomID string) map[string]int {
+	days, messages := h.RetentionPolicy(roomID)
+	return map[string]int{"days": days, "messages": messages}
+}
+
+// handleDirectMessage validates a direct message and hands it to the hub for delivery
+func handleDirectMessage(c *hub.Client, messageBytes []byte) {
+	var action DirectMessageAction
+	if err := json.Unmarshal(messageBytes, &action); err != nil {
+		log.Printf("Error parsing direct message: %v", err)
+		return
+	}
+
+	if action.To == "" || action.Content == "" {
+		errorResponse, _ := json.Marshal(map[string]interface{}{
+			"type":    "dm_error",
+			"message": "A recipient and content are required",
+		})
+		c.Send <- errorResponse
+		return
+	}
+
+	// Hooks can't read ciphertext, so encrypted messages are relayed as they are
+	if action.Encryption != nil {
+		if !validEncryption(action.Encryption) {
+			errorResponse, _ := json.Marshal(map[string]interface{}{
+				"type":    "dm_error",
+				"to":      action.To,
+				"message": "Encrypted messages need an algorithm and sender key",
+			})
+			c.Send <- errorResponse
+			return
+		}
+		c.Hub.SendDirect(&hub.DirectMessage{
+			Sender:     c,
+			To:         action.To,
+			Content:    action.Content,
+			Encryption: action.Encryption,
+		})
+		return
+	}
+
+	event := &hooks.Message{Kind: hooks.KindDirect, ClientID: c.ID, Username: c.Username, To: action.To, Content: action.Content}
+	if err := c.Hub.Hooks.Message(event); err != nil {
+		errorResponse, _ := json.Marshal(map[string]interface{}{
+			"type":    "dm_error",
+			"to":      action.To,
+			"message": err.Error(),
+		})
+		c.Send <- errorResponse
+		return
+	}
+
+	c.Hub.SendDirect(&hub.DirectMessage{
+		Sender:  c,
+		To:      event.To,
+		Content: event.Content,
+	})
+}
+
+// handleMessageAction applies an edit, deletion or reaction and broadcasts the change to the room
+func handleMessageAction(c *hub.Client, action MessageAction) {
+	if c.RoomID == "" {
+		sendRoomError(c, "You are not in a room")
+		return
+	}
+	if r, exists := c.Hub.RoomManager.GetRoom(c.RoomID); exists && rejectArchived(c, r) {
+		return
+	}
+	if action.Type == "restore" {
+		handleRestore(c, action)
+		return
+	}
+
+	var (
+		msg   *history.Message
+		err   error
+		event map[string]interface{}
+	)
+
+	switch action.Type {
+	case "edit":
+		if action.Content == "" {
+			sendRoomError(c, "Content is required")
+			return
+		}
+
+		// Edits in encrypted rooms replace the ciphertext, which hooks can't read
+		r, exists := c.Hub.RoomManager.GetRoom(c.RoomID)
+		if !checkEncryption(c, exists && r.IsEncrypted(), action.Encryption) {
+			return
+		}
+		if action.Encryption != nil {
+			msg, err = c.Hub.History.EditEncrypted(c.RoomID, action.MessageID, c.Username, action.Content, action.Encryption)
+			if err == nil {
+				event = map[string]interface{}{
+					"type":       "message_edited",
+					"messageId":  msg.ID,
+					"content":    msg.Content,
+					"encryption": msg.Encryption,
+					"editedAt":   msg.EditedAt.Format(time.RFC3339),
+				}
+			}
+			break
+		}
+
+		edit := &hooks.Message{Kind: hooks.KindEdit, ClientID: c.ID, Username: c.Username, RoomID: c.RoomID, MessageID: action.MessageID, Content: action.Content}
+		if err := c.Hub.Hooks.Message(edit); err != nil {
+			sendRoomError(c, err.Error())
+			return
+		}
+		if exists {
+			content, allowed := c.Hub.FilterProfanity(r, edit.Content)
+			if !allowed {
+				sendRoomError(c, "Messages with profanity aren't allowed in this room")
+				return
+			}
+			edit.Content = content
+		}
+		msg, err = c.Hub.History.Edit(c.RoomID, action.MessageID, c.Username, edit.Content)
+		if err == nil {
+			event = map[string]interface{}{
+				"type":      "message_edited",
+				"messageId": msg.ID,
+				"content":   msg.Content,
+				"html":      msg.HTML,
+				"entities":  msg.Entities,
+				"editedAt":  msg.EditedAt.Format(time.RFC3339),
+			}
+		}
+
+	case "delete":
+		// Moderators can delete anyone's message
+		if c.Hub.Roles.Can(c.Username, c.RoomID, rbac.PermModerate) {
+			msg, err = c.Hub.History.Remove(c.RoomID, action.MessageID, c.Username)
+		} else {
+			msg, err = c.Hub.History.Delete(c.RoomID, action.MessageID, c.Username)
+		}
+		if err == nil {
+			event = map[string]interface{}{
+				"type":      "message_deleted",
+				"messageId": msg.ID,
+				"deletedAt": msg.DeletedAt.Format(time.RFC3339),
+			}
+		}
+
+	case "react", "unreact":
+		if action.Emoji == "" {
+			sendRoomError(c, "An emoji is required")
+			return
+		}
+		if !c.Hub.Roles.Can(c.Username, c.RoomID, rbac.PermReact) {
+			sendPermissionError(c, "Your role does not allow reactions in this room")
+			return
+		}
+		// Reactions with custom emoji must use registered ones; removing is always allowed
+		if action.Type == "react" && emoji.IsShortcode(action.Emoji) && !c.Hub.Emoji.Known(action.Emoji) {
+			sendRoomError(c, "Unknown emoji "+action.Emoji)
+			return
+		}
+		msg, err = c.Hub.History.React(c.RoomID, action.MessageID, c.Username, action.Emoji, action.Type == "react")
+		if err == nil {
+			eventType := "reaction_added"
+			if action.Type == "unreact" {
+				eventType = "reaction_removed"
+			}
+			event = map[string]interface{}{
+				"type":      eventType,
+				"messageId": msg.ID,
+				"emoji":     action.Emoji,
+				"reactions": msg.Reactions,
+			}
+		}
+	}
+
+	switch {
+	case errors.Is(err, history.ErrNotAuthor):
+		sendPermissionError(c, err.Error())
+		return
+	case errors.Is(err, history.ErrNotFound), errors.Is(err, history.ErrDeleted), errors.Is(err, history.ErrGIF):
+		sendRoomError(c, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error updating message %s: %v", action.MessageID, err)
+		sendRoomError(c, "Could not update message")
+		return
+	}
+
+	// Audit moderators removing other users' messages
+	if action.Type == "delete" && msg.Username != c.Username {
+		c.Hub.Audit.Record(store.AuditRecord{
+			Actor:   c.Username,
+			Action:  audit.ActionDelete,
+			Target:  msg.Username,
+			RoomID:  c.RoomID,
+			Reason:  action.Reason,
+			Details: map[string]string{"messageId": msg.ID},
+		})
+	}
+
+	event["roomId"] = c.RoomID
+	event["username"] = c.Username
+
+	// Users who block the author don't see their edits; deletions reach everyone
+	author := c.Username
+	if action.Type == "delete" {
+		author = ""
+	}
+	eventJSON, _ := json.Marshal(event)
+	c.Hub.RoomManager.BroadcastFrom(context.Background(), c.RoomID, author, eventJSON, nil)
+}
+
+// handleRestore undoes a message's deletion for a moderator of the room,
+// which the hub announces to the room
+func handleRestore(c *hub.Client, action MessageAction) {
+	if !c.Hub.Roles.Can(c.Username, c.RoomID, rbac.PermModerate) {
+		sendPermissionError(c, "Only moderators can restore deleted messages")
+		return
+	}
+	_, err := c.Hub.RestoreMessage(c.RoomID, action.MessageID, c.Username, action.Reason)
+	switch {
+	case errors.Is(err, history.ErrNotFound), errors.Is(err, history.ErrNotDeleted), errors.Is(err, hub.ErrRestoreExpired):
+		sendRoomError(c, err.Error())
+	case err != nil:
+		log.Printf("Error restoring message %s: %v", action.MessageID, err)
+		sendRoomError(c, "Could not restore message")
+	}
+}
+
+// handleCommand validates a slash command and runs it, posting the bot's reply to the room
+func handleCommand(c *hub.Client, line string) {
+	// Built-in commands come before bots'
+	switch name, args, _ := strings.Cut(strings.TrimPrefix(line, "/"), " "); name {
+	case "help":
+		sendCommandHelp(c)
+		return
+	case "nick":
+		handleNick(c, args)
+		return
+	}
+
+	cmd, inv, err := c.Hub.Commands.Parse(c.RoomID, c.Username, line)
+	var validationErr *bot.ValidationError
+	if errors.As(err, &validationErr) {
+		sendCommandError(c, validationErr)
+		return
+	}
+	if cmd.Permission != "" && !c.Hub.Roles.Can(c.Username, c.RoomID, cmd.Permission) {
+		sendCommandError(c, &bot.ValidationError{
+			Code:    bot.ErrForbidden,
+			Command: cmd.Name,
+			Message: "Your role does not allow /" + cmd.Name + " in this room",
+		})
+		return
+	}
+
+	reply, err := cmd.Handler(inv)
+	if err != nil {
+		sendCommandError(c, &bot.ValidationError{
+			Code:    "command_failed",
+			Command: cmd.Name,
+			Message: err.Error(),
+		})
+		return
+	}
+	if reply == "" {
+		return
+	}
+
+	if err := PostBotMessage(c.Hub, c.RoomID, cmd.Bot, reply); err != nil {
+		log.Printf("Error recording bot reply: %v", err)
+	}
+}
+
+// PostBotMessage records content as a message from a bot and broadcasts it
+// to the room; it is used for command replies and incoming webhooks
+func PostBotMessage(h *hub.Hub, roomID, username, content string) error {
+	var ttl time.Duration
+	if r, exists := h.RoomManager.GetRoom(roomID); exists {
+		if r.IsEncrypted() {
+			return ErrEncryptedRoom
+		}
+		if r.IsArchived() {
+			return ErrArchivedRoom
+		}
+		ttl = r.GetMessageTTL()
+	}
+
+	recorded, err := h.History.Post(roomID, username, content, ttl)
+	if err != nil {
+		return err
+	}
+
+	message := RoomMessage{
+		ID:        recorded.ID,
+		Type:      "message",
+		Username:  username,
+		Content:   content,
+		Timestamp: recorded.Timestamp.Format(time.RFC3339),
+		RoomID:    roomID,
+		Seq:       recorded.Seq,
+		Bot:       true,
+		HTML:      recorded.HTML,
+		Entities:  recorded.Entities,
+	}
+	if recorded.ExpiresAt != nil {
+		message.ExpiresAt = recorded.ExpiresAt.Format(time.RFC3339)
+	}
+
+	messageJSON, _ := json.Marshal(message)
+	h.RoomManager.BroadcastToRoom(roomID, messageJSON, nil)
+	return nil
+}
+
+// sendCommandHelp sends the client the commands available in its current room
+func sendCommandHelp(c *hub.Client) {
+	commands := c.Hub.Commands.Available(c.RoomID)
+
+	list := make([]map[string]interface{}, 0, len(commands))
+	for _, cmd := range commands {
+		list = append(list, map[string]interface{}{
+			"name":        cmd.Name,
+			"bot":         cmd.Bot,
+			"usage":       cmd.Usage(),
+			"description": cmd.Description,
+			"args":        cmd.Args,
+			"permission":  cmd.Permission,
+		})
+	}
+
+	helpJSON, _ := json.Marshal(map[string]interface{}{
+		"type":     "command_help",
+		"roomId":   c.RoomID,
+		"commands": list,
+	})
+	c.Send <- helpJSON
+}
+
+// sendCommandError tells the client why its command was rejected
+func sendCommandError(c *hub.Client, err *bot.ValidationError) {
+	errorJSON, _ := json.Marshal(struct {
+		Type string `json:"type"`
+		*bot.ValidationError
+	}{"command_error", err})
+	c.Send <- errorJSON
+}
+
+// sendMessageAck tells the client its message was posted, pairing the
+// client's message ID with the one the server gave it. duplicate is set when
+// the message had already been posted and was resent.
+func sendMessageAck(c *hub.Client, msg *history.Message, duplicate bool) {
+	ack, _ := json.Marshal(map[string]interface{}{
+		"type":            "message_ack",
+		"clientMessageId": msg.ClientMessageID,
+		"id":              msg.ID,
+		"seq":             msg.Seq,
+		"roomId":          msg.RoomID,
+		"duplicate":       duplicate,
+		"timestamp":       msg.Timestamp.Format(time.RFC3339),
+	})
+	c.Send <- ack
+}
+
+// sendRoomError sends a room_error response to the client
+func sendRoomError(c *hub.Client, message string) {
+	errorResponse := map[string]interface{}{
+		"type":    "room_error",
+		"message": message,
+	}
+
+	errorResponseJSON, _ := json.Marshal(errorResponse)
+	c.Send <- errorResponseJSON
+}
+
+// sendPermissionError tells the client it is not allowed to perform an action
+func sendPermissionError(c *hub.Client, message string) {
+	errorResponse := map[string]interface{}{
+		"type":    "permission_error",
+		"message": message,
+	}
+
+	errorResponseJSON, _ := json.Marshal(errorResponse)
+	c.Priority <- errorResponseJSON
+}
+
+// deniedMessage explains a refused action: guestMessage for guests, who are
+// restricted whatever their role, and message for everyone else
+func deniedMessage(c *hub.Client, message, guestMessage string) string {
+	if c.Hub.Auth.Guest(c.Username) {
+		return guestMessage
+	}
+	return message
+}
+
+// randomSecret generates a random secret for signing webhook requests
+func randomSecret() string {
+	return replay.ID("webhook_secret", func() string {
+		b := make([]byte, 32)
+		rand.Read(b)
+		return hex.EncodeToString(b)
+	})
 }
 
 // randomString generates a random string of specified length
@@ -0,0 +1,291 @@
+// Package workspace lets one server host several isolated communities.
+// Each workspace has its own rooms, lobby and members, and is reached
+// through its own subdomain or under /w/{id}/. Requests addressing neither
+// use the server's own rooms, as a server without workspaces does.
+package workspace
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"realtime-chat/internal/store"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// PathPrefix starts the paths that address a workspace, as /w/{id}/...
+const PathPrefix = "/w/"
+
+// maxName is the longest name or description of a workspace, in characters
+const (
+	maxName        = 64
+	maxDescription = 500
+)
+
+// Errors returned when a workspace can't be saved
+var (
+	ErrNotFound  = errors.New("workspace not found")
+	ErrInvalidID = errors.New("a workspace ID is 2 to 32 lowercase letters, digits or '-', starting with a letter")
+	ErrInvalid   = errors.New("a workspace needs a name of at most 64 characters and a description of at most 500")
+)
+
+// idPattern matches a valid workspace ID, which must also work as a subdomain
+var idPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,30}[a-z0-9]$`)
+
+// Workspace is a workspace and its settings as shown to admins
+type Workspace struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Members     []string  `json:"members"` // Only these accounts may connect; anyone when empty
+	Guests      bool      `json:"guests"`  // Whether guests and users who didn't log in may connect
+	CreatedBy   string    `json:"createdBy,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Registry holds the server's workspaces
+type Registry struct {
+	store  store.Store // may be nil for in-memory only workspaces
+	domain string      // Base domain whose subdomains name workspaces; "" for paths only
+
+	mutex      sync.RWMutex
+	workspaces map[string]*store.WorkspaceRecord
+}
+
+// NewRegistry creates an empty registry backed by st. When domain is set,
+// hosts of the form {id}.{domain} address workspaces too.
+func NewRegistry(st store.Store, domain string) *Registry {
+	return &Registry{
+		store:      st,
+		domain:     strings.ToLower(strings.TrimSuffix(domain, ".")),
+		workspaces: make(map[string]*store.WorkspaceRecord),
+	}
+}
+
+// Load reads every persisted workspace into the registry
+func (r *Registry) Load() error {
+	if r.store == nil {
+		return nil
+	}
+
+	records, err := r.store.LoadWorkspaces()
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, rec := range records {
+		r.workspaces[rec.ID] = rec
+	}
+	return nil
+}
+
+// Save creates a workspace or replaces the settings of an existing one,
+// reporting whether it was created
+func (r *Registry) Save(ws *Workspace, actor string) (*Workspace, bool, error) {
+	if !idPattern.MatchString(ws.ID) {
+		return nil, false, ErrInvalidID
+	}
+	name, description := strings.TrimSpace(ws.Name), strings.TrimSpace(ws.Description)
+	if name == "" || utf8.RuneCountInString(name) > maxName || utf8.RuneCountInString(description) > maxDescription {
+		return nil, false, ErrInvalid
+	}
+
+	rec := &store.WorkspaceRecord{
+		ID:          ws.ID,
+		Name:        name,
+		Description: description,
+		Members:     uniqueSorted(ws.Members),
+		NoGuests:    !ws.Guests,
+		CreatedBy:   actor,
+		CreatedAt:   time.Now(),
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	current, exists := r.workspaces[ws.ID]
+	if exists {
+		rec.CreatedBy, rec.CreatedAt = current.CreatedBy, current.CreatedAt
+	}
+	if r.store != nil {
+		if err := r.store.SaveWorkspace(rec); err != nil {
+			return nil, false, err
+		}
+	}
+	r.workspaces[ws.ID] = rec
+	return view(rec), !exists, nil
+}
+
+// Delete removes a workspace. Its rooms are the caller's to remove.
+func (r *Registry) Delete(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.workspaces[id]; !ok {
+		return ErrNotFound
+	}
+	if r.store != nil {
+		if err := r.store.DeleteWorkspace(id); err != nil {
+			return err
+		}
+	}
+	delete(r.workspaces, id)
+	return nil
+}
+
+// Get returns a workspace by ID
+func (r *Registry) Get(id string) (*Workspace, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	rec, ok := r.workspaces[id]
+	if !ok {
+		return nil, false
+	}
+	return view(rec), true
+}
+
+// List returns every workspace, sorted by ID
+func (r *Registry) List() []*Workspace {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	list := make([]*Workspace, 0, len(r.workspaces))
+	for _, rec := range r.workspaces {
+		list = append(list, view(rec))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Admits reports whether a user may connect to a workspace. Members-only
+// workspaces admit only the accounts listed, and others admit every account
+// and, unless the workspace turned them away, guests and users who didn't
+// log in. The server's own rooms admit everyone.
+func (r *Registry) Admits(id, username string, account bool) bool {
+	if id == "" {
+		return true
+	}
+	ws, ok := r.Get(id)
+	if !ok {
+		return false
+	}
+	if !account {
+		return ws.Guests && len(ws.Members) == 0
+	}
+	if len(ws.Members) == 0 {
+		return true
+	}
+	i := sort.SearchStrings(ws.Members, username)
+	return i < len(ws.Members) && ws.Members[i] == username
+}
+
+// Resolve returns the workspace a request addresses, from its subdomain or
+// /w/{id}/ path prefix, and the path prefix to strip, if any. It returns ""
+// for requests to the server's own rooms, and ok is false when the
+// addressed workspace doesn't exist.
+func (r *Registry) Resolve(req *http.Request) (id, prefix string, ok bool) {
+	if rest, found := strings.CutPrefix(req.URL.Path, PathPrefix); found {
+		id, _, _ = strings.Cut(rest, "/")
+		_, ok = r.Get(id)
+		return id, PathPrefix + id, ok
+	}
+	id, ok = r.FromHost(req.Host)
+	return id, "", ok
+}
+
+// FromHost returns the workspace a host of the form {id}.{domain} addresses,
+// with or without a port. It returns "" for other hosts, and ok is false
+// when the addressed workspace doesn't exist.
+func (r *Registry) FromHost(host string) (id string, ok bool) {
+	if r.domain == "" {
+		return "", true
+	}
+
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub, found := strings.CutSuffix(host, "."+r.domain)
+	if !found || strings.Contains(sub, ".") {
+		return "", true
+	}
+	_, ok = r.Get(sub)
+	return sub, ok
+}
+
+// Handler serves next within the workspace each request addresses, which
+// handlers read with FromContext. Paths under /w/{id}/ are served as if the
+// prefix wasn't there, and unknown workspaces get 404.
+func (r *Registry) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id, prefix, ok := r.Resolve(req)
+		if !ok {
+			http.Error(w, "No such workspace", http.StatusNotFound)
+			return
+		}
+		if prefix != "" && req.URL.Path == prefix {
+			http.Redirect(w, req, prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		req = req.WithContext(WithID(req.Context(), id))
+		if prefix != "" {
+			http.StripPrefix(prefix, next).ServeHTTP(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// contextKey keys the workspace ID in a request's context
+type contextKey struct{}
+
+// WithID returns ctx carrying a workspace ID
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the workspace a request addresses, or "" for the
+// server's own rooms
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// view returns the admin view of a record
+func view(rec *store.WorkspaceRecord) *Workspace {
+	members := append([]string{}, rec.Members...)
+	return &Workspace{
+		ID:          rec.ID,
+		Name:        rec.Name,
+		Description: rec.Description,
+		Members:     members,
+		Guests:      !rec.NoGuests,
+		CreatedBy:   rec.CreatedBy,
+		CreatedAt:   rec.CreatedAt,
+	}
+}
+
+// uniqueSorted returns the non-empty strings of list, sorted and without
+// duplicates
+func uniqueSorted(list []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
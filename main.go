@@ -1,46 +1,81 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
-	"realtime-chat/internal/hub"
-	"realtime-chat/internal/websocket"
+	"os"
+	"os/signal"
+	"realtime-chat/internal/config"
+	"realtime-chat/server"
+	"syscall"
+	"time"
+
+	"github.com/skip2/go-qrcode"
 )
 
 func main() {
-	// Create a new hub for managing clients and broadcasting messages
-	h := hub.NewHub()
-
-	// Start the hub in a goroutine
-	go h.Run()
+	// Cancel the root context on Ctrl+C or SIGTERM so everything shuts down cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// WebSocket endpoint
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		websocket.HandleWebSocket(h, w, r)
-	})
-
-	// Serve static files
-	//  (HTML, CSS, JS)
-	
-	http.Handle("/", http.FileServer(http.Dir("./web/")))
+	// Load configuration from the environment
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	// Get the local IP address
+	// Get the local IP address, which other devices join at unless configured
 	localIP := getLocalIP()
+	if cfg.NetworkURL == "" {
+		cfg.NetworkURL = fmt.Sprintf("http://%s:8080", localIP)
+	}
+
+	// Build the server; main only adds the HTTP listener
+	chat := server.New(cfg)
+	if err := chat.Start(ctx); err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
 
 	// Display server information
 	fmt.Println("🚀 Real-time Chat Server Starting...")
 	fmt.Println("==================================================")
 	fmt.Printf("📱 Local Access:    http://localhost:8080\n")
-	fmt.Printf("🌐 Network Access:  http://%s:8080\n", localIP)
+	fmt.Printf("🌐 Network Access:  %s\n", cfg.NetworkURL)
 	fmt.Println("==================================================")
-	fmt.Println("💡 Share the network URL with other devices on your local network")
+	fmt.Println("💡 Share the network URL with other devices on your local network,")
+	fmt.Println("   or scan this QR code with a phone to join:")
+	if code, err := qrcode.New(cfg.NetworkURL+"/", qrcode.Medium); err == nil {
+		fmt.Print(code.ToSmallString(false))
+	}
 	fmt.Println("🛑 Press Ctrl+C to stop the server")
 	fmt.Println("")
 
-	log.Printf("Server starting on 0.0.0.0:8080 (accessible from local network)")
-	log.Fatal(http.ListenAndServe("0.0.0.0:8080", nil))
+	httpServer := &http.Server{Addr: "0.0.0.0:8080", Handler: chat.Handler()}
+
+	go func() {
+		log.Printf("Server starting on 0.0.0.0:8080 (accessible from local network)")
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for a shutdown signal
+	<-ctx.Done()
+	log.Println("Shutting down server...")
+
+	// Stop accepting new connections, then stop the hub and all rooms
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown error: %v", err)
+	}
+	chat.Stop()
+
+	log.Println("Server stopped")
 }
 
 // getLocalIP returns the local IP address of the machine
@@ -0,0 +1,50 @@
+// Command wordfilter is an example moderation plugin. It censors the words
+// listed in CHAT_FILTER_CENSOR and rejects messages containing the words
+// listed in CHAT_FILTER_BLOCK, both comma-separated. Build it into the
+// server's plugin directory:
+//
+//	go build -o plugins/wordfilter ./plugin/examples/wordfilter
+package main
+
+import (
+	"os"
+	"realtime-chat/hooks"
+	"realtime-chat/plugin"
+	"strings"
+)
+
+// filter checks room messages, edits and direct messages
+type filter struct {
+	plugin.Base
+	block  func(*hooks.Message) error
+	censor func(*hooks.Message) error
+}
+
+func (f *filter) Info() plugin.Info {
+	return plugin.Info{Name: "wordfilter", Version: "1.0.0", Events: []string{plugin.EventMessage}}
+}
+
+func (f *filter) OnMessage(e *hooks.Message) error {
+	if err := f.block(e); err != nil {
+		return err
+	}
+	return f.censor(e)
+}
+
+func main() {
+	plugin.Serve(&filter{
+		block:  hooks.Block(words("CHAT_FILTER_BLOCK")...),
+		censor: hooks.Censor(words("CHAT_FILTER_CENSOR")...),
+	})
+}
+
+// words returns the comma-separated words of the environment variable key
+func words(key string) []string {
+	var list []string
+	for _, word := range strings.Split(os.Getenv(key), ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			list = append(list, word)
+		}
+	}
+	return list
+}
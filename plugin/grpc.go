@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"context"
+	"realtime-chat/hooks"
+	"realtime-chat/plugin/pluginpb"
+
+	goplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+)
+
+// grpcPlugin carries the chat plugin over gRPC: impl is served inside the
+// plugin, and the server gets a pluginpb.PluginClient back
+type grpcPlugin struct {
+	goplugin.NetRPCUnsupportedPlugin
+	impl Plugin
+}
+
+// GRPCServer registers the plugin's implementation with the plugin's gRPC server
+func (p *grpcPlugin) GRPCServer(_ *goplugin.GRPCBroker, s *grpc.Server) error {
+	pluginpb.RegisterPluginServer(s, &server{impl: p.impl})
+	return nil
+}
+
+// GRPCClient returns the client the server calls the plugin with
+func (p *grpcPlugin) GRPCClient(_ context.Context, _ *goplugin.GRPCBroker, conn *grpc.ClientConn) (interface{}, error) {
+	return pluginpb.NewPluginClient(conn), nil
+}
+
+// server adapts a Plugin to the Plugin gRPC service
+type server struct {
+	pluginpb.UnimplementedPluginServer
+	impl Plugin
+}
+
+func (s *server) Info(context.Context, *pluginpb.InfoRequest) (*pluginpb.InfoResponse, error) {
+	info := s.impl.Info()
+	return &pluginpb.InfoResponse{Name: info.Name, Version: info.Version, Events: info.Events}, nil
+}
+
+func (s *server) OnConnect(_ context.Context, req *pluginpb.ConnectEvent) (*pluginpb.ConnectResult, error) {
+	event := connect(req)
+	return &pluginpb.ConnectResult{Reject: reject(s.impl.OnConnect(event)), Event: connectEvent(event)}, nil
+}
+
+func (s *server) OnMessage(_ context.Context, req *pluginpb.MessageEvent) (*pluginpb.MessageResult, error) {
+	event := message(req)
+	return &pluginpb.MessageResult{Reject: reject(s.impl.OnMessage(event)), Event: messageEvent(event)}, nil
+}
+
+func (s *server) OnJoinRoom(_ context.Context, req *pluginpb.JoinRoomEvent) (*pluginpb.JoinRoomResult, error) {
+	event := joinRoom(req)
+	return &pluginpb.JoinRoomResult{Reject: reject(s.impl.OnJoinRoom(event)), Event: joinRoomEvent(event)}, nil
+}
+
+func (s *server) OnDisconnect(_ context.Context, req *pluginpb.DisconnectEvent) (*pluginpb.DisconnectResult, error) {
+	s.impl.OnDisconnect(disconnect(req))
+	return &pluginpb.DisconnectResult{}, nil
+}
+
+// reject returns the reason a hook rejected its event, or "" when it didn't
+func reject(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func connectEvent(e *hooks.Connect) *pluginpb.ConnectEvent {
+	return &pluginpb.ConnectEvent{ClientId: e.ClientID, Username: e.Username, RemoteAddr: e.RemoteAddr, Transport: e.Transport}
+}
+
+func connect(e *pluginpb.ConnectEvent) *hooks.Connect {
+	return &hooks.Connect{ClientID: e.GetClientId(), Username: e.GetUsername(), RemoteAddr: e.GetRemoteAddr(), Transport: e.GetTransport()}
+}
+
+func messageEvent(e *hooks.Message) *pluginpb.MessageEvent {
+	return &pluginpb.MessageEvent{
+		Kind:      e.Kind,
+		ClientId:  e.ClientID,
+		Username:  e.Username,
+		RoomId:    e.RoomID,
+		MessageId: e.MessageID,
+		To:        e.To,
+		Content:   e.Content,
+	}
+}
+
+func message(e *pluginpb.MessageEvent) *hooks.Message {
+	return &hooks.Message{
+		Kind:      e.GetKind(),
+		ClientID:  e.GetClientId(),
+		Username:  e.GetUsername(),
+		RoomID:    e.GetRoomId(),
+		MessageID: e.GetMessageId(),
+		To:        e.GetTo(),
+		Content:   e.GetContent(),
+	}
+}
+
+func joinRoomEvent(e *hooks.JoinRoom) *pluginpb.JoinRoomEvent {
+	return &pluginpb.JoinRoomEvent{ClientId: e.ClientID, Username: e.Username, RoomId: e.RoomID}
+}
+
+func joinRoom(e *pluginpb.JoinRoomEvent) *hooks.JoinRoom {
+	return &hooks.JoinRoom{ClientID: e.GetClientId(), Username: e.GetUsername(), RoomID: e.GetRoomId()}
+}
+
+func disconnectEvent(e *hooks.Disconnect) *pluginpb.DisconnectEvent {
+	return &pluginpb.DisconnectEvent{ClientId: e.ClientID, Username: e.Username, RoomId: e.RoomID}
+}
+
+func disconnect(e *pluginpb.DisconnectEvent) *hooks.Disconnect {
+	return &hooks.Disconnect{ClientID: e.GetClientId(), Username: e.GetUsername(), RoomID: e.GetRoomId()}
+}
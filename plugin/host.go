@@ -0,0 +1,194 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"realtime-chat/hooks"
+	"realtime-chat/plugin/pluginpb"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+)
+
+// Host is the set of plugins a server started
+type Host struct {
+	clients []*goplugin.Client
+}
+
+// remote is a started plugin
+type remote struct {
+	info    Info
+	client  pluginpb.PluginClient
+	timeout time.Duration
+}
+
+// Load starts every executable in dir as a plugin and registers its hooks
+// with registry, after any hooks registered before. A plugin that fails to
+// answer a hook within timeout, or has crashed, lets the event through. If
+// any plugin fails to start, the ones already started are stopped.
+func Load(dir string, registry *hooks.Registry, timeout time.Duration) (*Host, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading plugin directory: %w", err)
+	}
+
+	host := &Host{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			host.Close()
+			return nil, err
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		p, err := host.start(filepath.Join(dir, entry.Name()), timeout)
+		if err != nil {
+			host.Close()
+			return nil, fmt.Errorf("starting plugin %s: %w", entry.Name(), err)
+		}
+		p.register(registry)
+		log.Printf("Loaded plugin %s %s from %s (%s)", p.info.Name, p.info.Version, entry.Name(), strings.Join(p.info.Events, ", "))
+	}
+	return host, nil
+}
+
+// start starts the plugin at path and asks it what it handles
+func (h *Host) start(path string, timeout time.Duration) (*remote, error) {
+	client := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig: Handshake,
+		VersionedPlugins: map[int]goplugin.PluginSet{
+			ProtocolVersion: {name: &grpcPlugin{}},
+		},
+		Cmd:              exec.Command(path),
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolGRPC},
+		Stderr:           log.Writer(),
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "plugin." + filepath.Base(path),
+			Output: log.Writer(),
+			Level:  hclog.Warn,
+		}),
+	})
+	h.clients = append(h.clients, client)
+
+	protocol, err := client.Client()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := protocol.Dispense(name)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &remote{client: raw.(pluginpb.PluginClient), timeout: timeout}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	res, err := p.client.Info(ctx, &pluginpb.InfoRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("getting info: %w", err)
+	}
+	p.info = Info{Name: res.GetName(), Version: res.GetVersion(), Events: res.GetEvents()}
+	if p.info.Name == "" {
+		p.info.Name = filepath.Base(path)
+	}
+	for _, event := range p.info.Events {
+		switch event {
+		case EventConnect, EventMessage, EventJoinRoom, EventDisconnect:
+		default:
+			return nil, fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return p, nil
+}
+
+// Close stops every plugin
+func (h *Host) Close() {
+	for _, client := range h.clients {
+		client.Kill()
+	}
+	h.clients = nil
+}
+
+// register adds hooks calling the plugin for the events it handles
+func (p *remote) register(registry *hooks.Registry) {
+	if slices.Contains(p.info.Events, EventConnect) {
+		registry.OnConnect(p.onConnect)
+	}
+	if slices.Contains(p.info.Events, EventMessage) {
+		registry.OnMessage(p.onMessage)
+	}
+	if slices.Contains(p.info.Events, EventJoinRoom) {
+		registry.OnJoinRoom(p.onJoinRoom)
+	}
+	if slices.Contains(p.info.Events, EventDisconnect) {
+		registry.OnDisconnect(p.onDisconnect)
+	}
+}
+
+func (p *remote) onConnect(e *hooks.Connect) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	res, err := p.client.OnConnect(ctx, connectEvent(e))
+	if err != nil {
+		log.Printf("Plugin %s failed on connect: %v", p.info.Name, err)
+		return nil
+	}
+	if res.GetReject() != "" {
+		return errors.New(res.GetReject())
+	}
+	if username := res.GetEvent().GetUsername(); username != "" {
+		e.Username = username
+	}
+	return nil
+}
+
+func (p *remote) onMessage(e *hooks.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	res, err := p.client.OnMessage(ctx, messageEvent(e))
+	if err != nil {
+		log.Printf("Plugin %s failed on message: %v", p.info.Name, err)
+		return nil
+	}
+	if res.GetReject() != "" {
+		return errors.New(res.GetReject())
+	}
+	if res.GetEvent() != nil {
+		e.Content = res.GetEvent().GetContent()
+	}
+	return nil
+}
+
+func (p *remote) onJoinRoom(e *hooks.JoinRoom) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	res, err := p.client.OnJoinRoom(ctx, joinRoomEvent(e))
+	if err != nil {
+		log.Printf("Plugin %s failed on room join: %v", p.info.Name, err)
+		return nil
+	}
+	if res.GetReject() != "" {
+		return errors.New(res.GetReject())
+	}
+	if roomID := res.GetEvent().GetRoomId(); roomID != "" {
+		e.RoomID = roomID
+	}
+	return nil
+}
+
+func (p *remote) onDisconnect(e *hooks.Disconnect) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	if _, err := p.client.OnDisconnect(ctx, disconnectEvent(e)); err != nil {
+		log.Printf("Plugin %s failed on disconnect: %v", p.info.Name, err)
+	}
+}
@@ -0,0 +1,107 @@
+// Package plugin runs moderation and integration plugins out of process, so
+// operators can add them without recompiling the server. A plugin is an
+// executable the server starts from its plugin directory and talks to over
+// gRPC; it sees the same events as in-process hooks and can change or
+// reject them the same way. A Go plugin is a small main package:
+//
+//	type filter struct{ plugin.Base }
+//
+//	func (filter) Info() plugin.Info {
+//		return plugin.Info{Name: "filter", Version: "1.0.0", Events: []string{plugin.EventMessage}}
+//	}
+//
+//	func (filter) OnMessage(e *hooks.Message) error {
+//		...
+//	}
+//
+//	func main() {
+//		plugin.Serve(filter{})
+//	}
+//
+// Plugins in other languages implement the Plugin service of
+// pluginpb/plugin.proto and the go-plugin handshake described by Handshake.
+package plugin
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pluginpb/plugin.proto
+
+import (
+	"os"
+	"realtime-chat/hooks"
+
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+)
+
+// ProtocolVersion is the version of the plugin protocol, raised whenever a
+// change to it breaks existing plugins. The server only starts plugins
+// speaking a version it supports.
+const ProtocolVersion = 1
+
+// Handshake is the configuration the server and plugins check before
+// talking, so that executables that aren't plugins refuse to run under it
+var Handshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  ProtocolVersion,
+	MagicCookieKey:   "CHAT_PLUGIN",
+	MagicCookieValue: "f3a9c1d2-realtime-chat",
+}
+
+// Events a plugin can handle
+const (
+	EventConnect    = "connect"
+	EventMessage    = "message"
+	EventJoinRoom   = "join_room"
+	EventDisconnect = "disconnect"
+)
+
+// name is the name the chat plugin is dispensed under
+const name = "chat"
+
+// Info describes a plugin
+type Info struct {
+	Name    string
+	Version string
+
+	// Events the plugin handles; the server only calls hooks for these
+	Events []string
+}
+
+// Plugin is implemented by Go plugins. Each hook behaves like the in-process
+// hook of the same event: it can change the event, and returning an error
+// rejects it with the error's message.
+type Plugin interface {
+	Info() Info
+	OnConnect(*hooks.Connect) error
+	OnMessage(*hooks.Message) error
+	OnJoinRoom(*hooks.JoinRoom) error
+	OnDisconnect(*hooks.Disconnect)
+}
+
+// Base implements every hook of Plugin by allowing the event, so plugins
+// embedding it only implement Info and the hooks they need
+type Base struct{}
+
+// OnConnect admits the connection
+func (Base) OnConnect(*hooks.Connect) error { return nil }
+
+// OnMessage sends the message
+func (Base) OnMessage(*hooks.Message) error { return nil }
+
+// OnJoinRoom allows the join
+func (Base) OnJoinRoom(*hooks.JoinRoom) error { return nil }
+
+// OnDisconnect does nothing
+func (Base) OnDisconnect(*hooks.Disconnect) {}
+
+// Serve serves p to the chat server that started the process, returning
+// when the server stops it. It must be called from the plugin's main.
+func Serve(p Plugin) {
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		VersionedPlugins: map[int]goplugin.PluginSet{
+			ProtocolVersion: {name: &grpcPlugin{impl: p}},
+		},
+		GRPCServer: goplugin.DefaultGRPCServer,
+		// Anything else the plugin writes to stderr ends up in the server's log
+		Logger: hclog.New(&hclog.LoggerOptions{Level: hclog.Warn, Output: os.Stderr, JSONFormat: true}),
+	})
+}
@@ -0,0 +1,717 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: pluginpb/plugin.proto
+
+package pluginpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type InfoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InfoRequest) Reset() {
+	*x = InfoRequest{}
+	mi := &file_pluginpb_plugin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InfoRequest) ProtoMessage() {}
+
+func (x *InfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pluginpb_plugin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InfoRequest.ProtoReflect.Descriptor instead.
+func (*InfoRequest) Descriptor() ([]byte, []int) {
+	return file_pluginpb_plugin_proto_rawDescGZIP(), []int{0}
+}
+
+type InfoResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Name    string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Version string                 `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	// Events the plugin handles: "connect", "message", "join_room" and "disconnect"
+	Events        []string `protobuf:"bytes,3,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InfoResponse) Reset() {
+	*x = InfoResponse{}
+	mi := &file_pluginpb_plugin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InfoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InfoResponse) ProtoMessage() {}
+
+func (x *InfoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pluginpb_plugin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InfoResponse.ProtoReflect.Descriptor instead.
+func (*InfoResponse) Descriptor() ([]byte, []int) {
+	return file_pluginpb_plugin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InfoResponse) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *InfoResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *InfoResponse) GetEvents() []string {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+type ConnectEvent struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	ClientId   string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	Username   string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	RemoteAddr string                 `protobuf:"bytes,3,opt,name=remote_addr,json=remoteAddr,proto3" json:"remote_addr,omitempty"`
+	// "websocket", "grpc" or "mqtt"
+	Transport     string `protobuf:"bytes,4,opt,name=transport,proto3" json:"transport,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConnectEvent) Reset() {
+	*x = ConnectEvent{}
+	mi := &file_pluginpb_plugin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConnectEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConnectEvent) ProtoMessage() {}
+
+func (x *ConnectEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_pluginpb_plugin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConnectEvent.ProtoReflect.Descriptor instead.
+func (*ConnectEvent) Descriptor() ([]byte, []int) {
+	return file_pluginpb_plugin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ConnectEvent) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *ConnectEvent) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *ConnectEvent) GetRemoteAddr() string {
+	if x != nil {
+		return x.RemoteAddr
+	}
+	return ""
+}
+
+func (x *ConnectEvent) GetTransport() string {
+	if x != nil {
+		return x.Transport
+	}
+	return ""
+}
+
+type ConnectResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Event *ConnectEvent          `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`
+	// Why the connection is refused; empty admits it
+	Reject        string `protobuf:"bytes,2,opt,name=reject,proto3" json:"reject,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConnectResult) Reset() {
+	*x = ConnectResult{}
+	mi := &file_pluginpb_plugin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConnectResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConnectResult) ProtoMessage() {}
+
+func (x *ConnectResult) ProtoReflect() protoreflect.Message {
+	mi := &file_pluginpb_plugin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConnectResult.ProtoReflect.Descriptor instead.
+func (*ConnectResult) Descriptor() ([]byte, []int) {
+	return file_pluginpb_plugin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ConnectResult) GetEvent() *ConnectEvent {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *ConnectResult) GetReject() string {
+	if x != nil {
+		return x.Reject
+	}
+	return ""
+}
+
+type MessageEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// "message", "edit" or "dm"
+	Kind          string `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	ClientId      string `protobuf:"bytes,2,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	Username      string `protobuf:"bytes,3,opt,name=username,proto3" json:"username,omitempty"`
+	RoomId        string `protobuf:"bytes,4,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	MessageId     string `protobuf:"bytes,5,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	To            string `protobuf:"bytes,6,opt,name=to,proto3" json:"to,omitempty"`
+	Content       string `protobuf:"bytes,7,opt,name=content,proto3" json:"content,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MessageEvent) Reset() {
+	*x = MessageEvent{}
+	mi := &file_pluginpb_plugin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MessageEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MessageEvent) ProtoMessage() {}
+
+func (x *MessageEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_pluginpb_plugin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MessageEvent.ProtoReflect.Descriptor instead.
+func (*MessageEvent) Descriptor() ([]byte, []int) {
+	return file_pluginpb_plugin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *MessageEvent) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *MessageEvent) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *MessageEvent) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *MessageEvent) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+func (x *MessageEvent) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *MessageEvent) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *MessageEvent) GetContent() string {
+	if x != nil {
+		return x.Content
+	}
+	return ""
+}
+
+type MessageResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Event *MessageEvent          `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`
+	// Why the message is dropped; empty sends it
+	Reject        string `protobuf:"bytes,2,opt,name=reject,proto3" json:"reject,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MessageResult) Reset() {
+	*x = MessageResult{}
+	mi := &file_pluginpb_plugin_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MessageResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MessageResult) ProtoMessage() {}
+
+func (x *MessageResult) ProtoReflect() protoreflect.Message {
+	mi := &file_pluginpb_plugin_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MessageResult.ProtoReflect.Descriptor instead.
+func (*MessageResult) Descriptor() ([]byte, []int) {
+	return file_pluginpb_plugin_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *MessageResult) GetEvent() *MessageEvent {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *MessageResult) GetReject() string {
+	if x != nil {
+		return x.Reject
+	}
+	return ""
+}
+
+type JoinRoomEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientId      string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	RoomId        string                 `protobuf:"bytes,3,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JoinRoomEvent) Reset() {
+	*x = JoinRoomEvent{}
+	mi := &file_pluginpb_plugin_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JoinRoomEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JoinRoomEvent) ProtoMessage() {}
+
+func (x *JoinRoomEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_pluginpb_plugin_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JoinRoomEvent.ProtoReflect.Descriptor instead.
+func (*JoinRoomEvent) Descriptor() ([]byte, []int) {
+	return file_pluginpb_plugin_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *JoinRoomEvent) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *JoinRoomEvent) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *JoinRoomEvent) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+type JoinRoomResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Event *JoinRoomEvent         `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`
+	// Why the join is refused; empty allows it
+	Reject        string `protobuf:"bytes,2,opt,name=reject,proto3" json:"reject,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JoinRoomResult) Reset() {
+	*x = JoinRoomResult{}
+	mi := &file_pluginpb_plugin_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JoinRoomResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JoinRoomResult) ProtoMessage() {}
+
+func (x *JoinRoomResult) ProtoReflect() protoreflect.Message {
+	mi := &file_pluginpb_plugin_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JoinRoomResult.ProtoReflect.Descriptor instead.
+func (*JoinRoomResult) Descriptor() ([]byte, []int) {
+	return file_pluginpb_plugin_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *JoinRoomResult) GetEvent() *JoinRoomEvent {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *JoinRoomResult) GetReject() string {
+	if x != nil {
+		return x.Reject
+	}
+	return ""
+}
+
+type DisconnectEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientId      string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	RoomId        string                 `protobuf:"bytes,3,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DisconnectEvent) Reset() {
+	*x = DisconnectEvent{}
+	mi := &file_pluginpb_plugin_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DisconnectEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisconnectEvent) ProtoMessage() {}
+
+func (x *DisconnectEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_pluginpb_plugin_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisconnectEvent.ProtoReflect.Descriptor instead.
+func (*DisconnectEvent) Descriptor() ([]byte, []int) {
+	return file_pluginpb_plugin_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DisconnectEvent) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *DisconnectEvent) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *DisconnectEvent) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+type DisconnectResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DisconnectResult) Reset() {
+	*x = DisconnectResult{}
+	mi := &file_pluginpb_plugin_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DisconnectResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisconnectResult) ProtoMessage() {}
+
+func (x *DisconnectResult) ProtoReflect() protoreflect.Message {
+	mi := &file_pluginpb_plugin_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisconnectResult.ProtoReflect.Descriptor instead.
+func (*DisconnectResult) Descriptor() ([]byte, []int) {
+	return file_pluginpb_plugin_proto_rawDescGZIP(), []int{9}
+}
+
+var File_pluginpb_plugin_proto protoreflect.FileDescriptor
+
+const file_pluginpb_plugin_proto_rawDesc = "" +
+	"\n" +
+	"\x15pluginpb/plugin.proto\x12\x0echat.plugin.v1\"\r\n" +
+	"\vInfoRequest\"T\n" +
+	"\fInfoResponse\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\x12\x16\n" +
+	"\x06events\x18\x03 \x03(\tR\x06events\"\x86\x01\n" +
+	"\fConnectEvent\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\x12\x1f\n" +
+	"\vremote_addr\x18\x03 \x01(\tR\n" +
+	"remoteAddr\x12\x1c\n" +
+	"\ttransport\x18\x04 \x01(\tR\ttransport\"[\n" +
+	"\rConnectResult\x122\n" +
+	"\x05event\x18\x01 \x01(\v2\x1c.chat.plugin.v1.ConnectEventR\x05event\x12\x16\n" +
+	"\x06reject\x18\x02 \x01(\tR\x06reject\"\xbd\x01\n" +
+	"\fMessageEvent\x12\x12\n" +
+	"\x04kind\x18\x01 \x01(\tR\x04kind\x12\x1b\n" +
+	"\tclient_id\x18\x02 \x01(\tR\bclientId\x12\x1a\n" +
+	"\busername\x18\x03 \x01(\tR\busername\x12\x17\n" +
+	"\aroom_id\x18\x04 \x01(\tR\x06roomId\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x05 \x01(\tR\tmessageId\x12\x0e\n" +
+	"\x02to\x18\x06 \x01(\tR\x02to\x12\x18\n" +
+	"\acontent\x18\a \x01(\tR\acontent\"[\n" +
+	"\rMessageResult\x122\n" +
+	"\x05event\x18\x01 \x01(\v2\x1c.chat.plugin.v1.MessageEventR\x05event\x12\x16\n" +
+	"\x06reject\x18\x02 \x01(\tR\x06reject\"a\n" +
+	"\rJoinRoomEvent\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\x12\x17\n" +
+	"\aroom_id\x18\x03 \x01(\tR\x06roomId\"]\n" +
+	"\x0eJoinRoomResult\x123\n" +
+	"\x05event\x18\x01 \x01(\v2\x1d.chat.plugin.v1.JoinRoomEventR\x05event\x12\x16\n" +
+	"\x06reject\x18\x02 \x01(\tR\x06reject\"c\n" +
+	"\x0fDisconnectEvent\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\x12\x17\n" +
+	"\aroom_id\x18\x03 \x01(\tR\x06roomId\"\x12\n" +
+	"\x10DisconnectResult2\xff\x02\n" +
+	"\x06Plugin\x12A\n" +
+	"\x04Info\x12\x1b.chat.plugin.v1.InfoRequest\x1a\x1c.chat.plugin.v1.InfoResponse\x12H\n" +
+	"\tOnConnect\x12\x1c.chat.plugin.v1.ConnectEvent\x1a\x1d.chat.plugin.v1.ConnectResult\x12H\n" +
+	"\tOnMessage\x12\x1c.chat.plugin.v1.MessageEvent\x1a\x1d.chat.plugin.v1.MessageResult\x12K\n" +
+	"\n" +
+	"OnJoinRoom\x12\x1d.chat.plugin.v1.JoinRoomEvent\x1a\x1e.chat.plugin.v1.JoinRoomResult\x12Q\n" +
+	"\fOnDisconnect\x12\x1f.chat.plugin.v1.DisconnectEvent\x1a .chat.plugin.v1.DisconnectResultB\x1fZ\x1drealtime-chat/plugin/pluginpbb\x06proto3"
+
+var (
+	file_pluginpb_plugin_proto_rawDescOnce sync.Once
+	file_pluginpb_plugin_proto_rawDescData []byte
+)
+
+func file_pluginpb_plugin_proto_rawDescGZIP() []byte {
+	file_pluginpb_plugin_proto_rawDescOnce.Do(func() {
+		file_pluginpb_plugin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pluginpb_plugin_proto_rawDesc), len(file_pluginpb_plugin_proto_rawDesc)))
+	})
+	return file_pluginpb_plugin_proto_rawDescData
+}
+
+var file_pluginpb_plugin_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_pluginpb_plugin_proto_goTypes = []any{
+	(*InfoRequest)(nil),      // 0: chat.plugin.v1.InfoRequest
+	(*InfoResponse)(nil),     // 1: chat.plugin.v1.InfoResponse
+	(*ConnectEvent)(nil),     // 2: chat.plugin.v1.ConnectEvent
+	(*ConnectResult)(nil),    // 3: chat.plugin.v1.ConnectResult
+	(*MessageEvent)(nil),     // 4: chat.plugin.v1.MessageEvent
+	(*MessageResult)(nil),    // 5: chat.plugin.v1.MessageResult
+	(*JoinRoomEvent)(nil),    // 6: chat.plugin.v1.JoinRoomEvent
+	(*JoinRoomResult)(nil),   // 7: chat.plugin.v1.JoinRoomResult
+	(*DisconnectEvent)(nil),  // 8: chat.plugin.v1.DisconnectEvent
+	(*DisconnectResult)(nil), // 9: chat.plugin.v1.DisconnectResult
+}
+var file_pluginpb_plugin_proto_depIdxs = []int32{
+	2, // 0: chat.plugin.v1.ConnectResult.event:type_name -> chat.plugin.v1.ConnectEvent
+	4, // 1: chat.plugin.v1.MessageResult.event:type_name -> chat.plugin.v1.MessageEvent
+	6, // 2: chat.plugin.v1.JoinRoomResult.event:type_name -> chat.plugin.v1.JoinRoomEvent
+	0, // 3: chat.plugin.v1.Plugin.Info:input_type -> chat.plugin.v1.InfoRequest
+	2, // 4: chat.plugin.v1.Plugin.OnConnect:input_type -> chat.plugin.v1.ConnectEvent
+	4, // 5: chat.plugin.v1.Plugin.OnMessage:input_type -> chat.plugin.v1.MessageEvent
+	6, // 6: chat.plugin.v1.Plugin.OnJoinRoom:input_type -> chat.plugin.v1.JoinRoomEvent
+	8, // 7: chat.plugin.v1.Plugin.OnDisconnect:input_type -> chat.plugin.v1.DisconnectEvent
+	1, // 8: chat.plugin.v1.Plugin.Info:output_type -> chat.plugin.v1.InfoResponse
+	3, // 9: chat.plugin.v1.Plugin.OnConnect:output_type -> chat.plugin.v1.ConnectResult
+	5, // 10: chat.plugin.v1.Plugin.OnMessage:output_type -> chat.plugin.v1.MessageResult
+	7, // 11: chat.plugin.v1.Plugin.OnJoinRoom:output_type -> chat.plugin.v1.JoinRoomResult
+	9, // 12: chat.plugin.v1.Plugin.OnDisconnect:output_type -> chat.plugin.v1.DisconnectResult
+	8, // [8:13] is the sub-list for method output_type
+	3, // [3:8] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_pluginpb_plugin_proto_init() }
+func file_pluginpb_plugin_proto_init() {
+	if File_pluginpb_plugin_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pluginpb_plugin_proto_rawDesc), len(file_pluginpb_plugin_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pluginpb_plugin_proto_goTypes,
+		DependencyIndexes: file_pluginpb_plugin_proto_depIdxs,
+		MessageInfos:      file_pluginpb_plugin_proto_msgTypes,
+	}.Build()
+	File_pluginpb_plugin_proto = out.File
+	file_pluginpb_plugin_proto_goTypes = nil
+	file_pluginpb_plugin_proto_depIdxs = nil
+}
@@ -0,0 +1,283 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: pluginpb/plugin.proto
+
+package pluginpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Plugin_Info_FullMethodName         = "/chat.plugin.v1.Plugin/Info"
+	Plugin_OnConnect_FullMethodName    = "/chat.plugin.v1.Plugin/OnConnect"
+	Plugin_OnMessage_FullMethodName    = "/chat.plugin.v1.Plugin/OnMessage"
+	Plugin_OnJoinRoom_FullMethodName   = "/chat.plugin.v1.Plugin/OnJoinRoom"
+	Plugin_OnDisconnect_FullMethodName = "/chat.plugin.v1.Plugin/OnDisconnect"
+)
+
+// PluginClient is the client API for Plugin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Plugin is served by an out-of-process plugin and called by the chat
+// server for the events the plugin asks for in Info. Each hook returns the
+// event, changed or not, and a non-empty reject to refuse it.
+type PluginClient interface {
+	// Info describes the plugin; it is called once after the plugin starts
+	Info(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*InfoResponse, error)
+	OnConnect(ctx context.Context, in *ConnectEvent, opts ...grpc.CallOption) (*ConnectResult, error)
+	OnMessage(ctx context.Context, in *MessageEvent, opts ...grpc.CallOption) (*MessageResult, error)
+	OnJoinRoom(ctx context.Context, in *JoinRoomEvent, opts ...grpc.CallOption) (*JoinRoomResult, error)
+	OnDisconnect(ctx context.Context, in *DisconnectEvent, opts ...grpc.CallOption) (*DisconnectResult, error)
+}
+
+type pluginClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPluginClient(cc grpc.ClientConnInterface) PluginClient {
+	return &pluginClient{cc}
+}
+
+func (c *pluginClient) Info(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*InfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InfoResponse)
+	err := c.cc.Invoke(ctx, Plugin_Info_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) OnConnect(ctx context.Context, in *ConnectEvent, opts ...grpc.CallOption) (*ConnectResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ConnectResult)
+	err := c.cc.Invoke(ctx, Plugin_OnConnect_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) OnMessage(ctx context.Context, in *MessageEvent, opts ...grpc.CallOption) (*MessageResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MessageResult)
+	err := c.cc.Invoke(ctx, Plugin_OnMessage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) OnJoinRoom(ctx context.Context, in *JoinRoomEvent, opts ...grpc.CallOption) (*JoinRoomResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(JoinRoomResult)
+	err := c.cc.Invoke(ctx, Plugin_OnJoinRoom_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) OnDisconnect(ctx context.Context, in *DisconnectEvent, opts ...grpc.CallOption) (*DisconnectResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DisconnectResult)
+	err := c.cc.Invoke(ctx, Plugin_OnDisconnect_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PluginServer is the server API for Plugin service.
+// All implementations must embed UnimplementedPluginServer
+// for forward compatibility.
+//
+// Plugin is served by an out-of-process plugin and called by the chat
+// server for the events the plugin asks for in Info. Each hook returns the
+// event, changed or not, and a non-empty reject to refuse it.
+type PluginServer interface {
+	// Info describes the plugin; it is called once after the plugin starts
+	Info(context.Context, *InfoRequest) (*InfoResponse, error)
+	OnConnect(context.Context, *ConnectEvent) (*ConnectResult, error)
+	OnMessage(context.Context, *MessageEvent) (*MessageResult, error)
+	OnJoinRoom(context.Context, *JoinRoomEvent) (*JoinRoomResult, error)
+	OnDisconnect(context.Context, *DisconnectEvent) (*DisconnectResult, error)
+	mustEmbedUnimplementedPluginServer()
+}
+
+// UnimplementedPluginServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPluginServer struct{}
+
+func (UnimplementedPluginServer) Info(context.Context, *InfoRequest) (*InfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Info not implemented")
+}
+func (UnimplementedPluginServer) OnConnect(context.Context, *ConnectEvent) (*ConnectResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method OnConnect not implemented")
+}
+func (UnimplementedPluginServer) OnMessage(context.Context, *MessageEvent) (*MessageResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method OnMessage not implemented")
+}
+func (UnimplementedPluginServer) OnJoinRoom(context.Context, *JoinRoomEvent) (*JoinRoomResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method OnJoinRoom not implemented")
+}
+func (UnimplementedPluginServer) OnDisconnect(context.Context, *DisconnectEvent) (*DisconnectResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method OnDisconnect not implemented")
+}
+func (UnimplementedPluginServer) mustEmbedUnimplementedPluginServer() {}
+func (UnimplementedPluginServer) testEmbeddedByValue()                {}
+
+// UnsafePluginServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PluginServer will
+// result in compilation errors.
+type UnsafePluginServer interface {
+	mustEmbedUnimplementedPluginServer()
+}
+
+func RegisterPluginServer(s grpc.ServiceRegistrar, srv PluginServer) {
+	// If the following call pancis, it indicates UnimplementedPluginServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Plugin_ServiceDesc, srv)
+}
+
+func _Plugin_Info_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Info(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Info_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Info(ctx, req.(*InfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_OnConnect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConnectEvent)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).OnConnect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_OnConnect_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).OnConnect(ctx, req.(*ConnectEvent))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_OnMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MessageEvent)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).OnMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_OnMessage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).OnMessage(ctx, req.(*MessageEvent))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_OnJoinRoom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(JoinRoomEvent)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).OnJoinRoom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_OnJoinRoom_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).OnJoinRoom(ctx, req.(*JoinRoomEvent))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_OnDisconnect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisconnectEvent)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).OnDisconnect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_OnDisconnect_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).OnDisconnect(ctx, req.(*DisconnectEvent))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Plugin_ServiceDesc is the grpc.ServiceDesc for Plugin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Plugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "chat.plugin.v1.Plugin",
+	HandlerType: (*PluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Info",
+			Handler:    _Plugin_Info_Handler,
+		},
+		{
+			MethodName: "OnConnect",
+			Handler:    _Plugin_OnConnect_Handler,
+		},
+		{
+			MethodName: "OnMessage",
+			Handler:    _Plugin_OnMessage_Handler,
+		},
+		{
+			MethodName: "OnJoinRoom",
+			Handler:    _Plugin_OnJoinRoom_Handler,
+		},
+		{
+			MethodName: "OnDisconnect",
+			Handler:    _Plugin_OnDisconnect_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "pluginpb/plugin.proto",
+}
@@ -0,0 +1,402 @@
+// Package server builds the whole chat server as a library, so Go
+// applications can embed the chat in their own HTTP server and mux:
+//
+//	chat := server.New(server.DefaultConfig())
+//	if err := chat.Start(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+//	mux.Handle("/", chat.Handler())
+//	...
+//	chat.Stop()
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"realtime-chat/hooks"
+	"realtime-chat/internal/api"
+	chatgrpc "realtime-chat/internal/api/grpc"
+	"realtime-chat/internal/cluster"
+	"realtime-chat/internal/config"
+	"realtime-chat/internal/discovery"
+	"realtime-chat/internal/embed"
+	"realtime-chat/internal/federation"
+	"realtime-chat/internal/game"
+	"realtime-chat/internal/hub"
+	"realtime-chat/internal/live"
+	"realtime-chat/internal/mqtt"
+	"realtime-chat/internal/replay"
+	"realtime-chat/internal/store"
+	"realtime-chat/internal/tracing"
+	"realtime-chat/internal/websocket"
+	"realtime-chat/internal/workspace"
+	"realtime-chat/plugin"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"google.golang.org/grpc"
+)
+
+// Config configures a server
+type Config = config.Config
+
+// DefaultConfig returns the default configuration
+func DefaultConfig() *Config {
+	return config.Default()
+}
+
+// LoadConfig returns the default configuration overridden by CHAT_*
+// environment variables
+func LoadConfig() (*Config, error) {
+	return config.Load()
+}
+
+// qrSize is the width and height of the QR code served at /qr, in pixels
+const qrSize = 256
+
+// Server is a chat server: its hub, storage and optional cluster, Kafka,
+// gRPC, MQTT, plugin and trace exporter connections
+type Server struct {
+	cfg   *Config
+	hooks *hooks.Registry
+
+	hub        *hub.Hub
+	handler    *http.ServeMux
+	grpcServer *grpc.Server
+	bridge     *mqtt.Bridge
+	advertiser *discovery.Advertiser
+	federation *federation.Federation
+	live       *live.Feed
+	node       *cluster.Cluster
+	recorder   *replay.Recorder
+	plugins    *plugin.Host
+	tracing    *tracing.Tracing
+	cancel     context.CancelFunc
+
+	// Storage, of which only the parts the configured backend uses are set
+	snapshot    *store.MemoryStore
+	database    *store.BoltStore
+	writeBehind *store.WriteBehind
+}
+
+// New returns a server for cfg that hasn't started yet
+func New(cfg *Config) *Server {
+	return &Server{cfg: cfg, hooks: hooks.New()}
+}
+
+// Hooks returns the registry of operator middleware run on connections,
+// messages, room joins and disconnections. Hooks registered before Start
+// see every event.
+func (s *Server) Hooks() *hooks.Registry {
+	return s.hooks
+}
+
+// Start opens the server's storage and starts the hub and the gRPC API. The
+// hub stops when ctx is cancelled, but only Stop flushes and closes storage.
+func (s *Server) Start(ctx context.Context) error {
+	ctx, s.cancel = context.WithCancel(ctx)
+	cfg := s.cfg
+
+	// Open the storage used to persist rooms across restarts
+	var (
+		st  store.Store
+		err error
+	)
+	switch cfg.Storage.Backend {
+	case config.StorageMemory:
+		s.snapshot, err = store.NewMemoryStore(filepath.Join(cfg.DataDir, "snapshot.json"), cfg.Storage.SnapshotHistory)
+		st = s.snapshot
+	case config.StorageBolt:
+		s.database, err = store.NewBoltStore(filepath.Join(cfg.DataDir, "chat.db"))
+		st = s.database
+	default:
+		st, err = store.NewFileStore(cfg.DataDir)
+	}
+	if err != nil {
+		s.cancel()
+		return fmt.Errorf("opening storage: %w", err)
+	}
+	if s.snapshot != nil {
+		go s.snapshot.Run(ctx, cfg.Storage.SnapshotInterval)
+	}
+
+	// Encrypt message content before it reaches the storage backend. Without
+	// a key it is stored in plaintext, and anything encrypted earlier fails
+	// to load rather than reaching clients as ciphertext.
+	encrypted, err := store.NewEncrypted(st, cfg.Storage.EncryptionKey, cfg.Storage.PreviousKeys)
+	if err != nil {
+		s.abort()
+		return fmt.Errorf("opening storage: %w", err)
+	}
+	st = encrypted
+	if cfg.Storage.EncryptionKey != nil {
+		log.Printf("Encrypting message content at rest with key %s", store.KeyID(cfg.Storage.EncryptionKey))
+	}
+
+	// Take history writes out of the broadcast path when batching is allowed;
+	// the memory backend never writes in that path
+	if cfg.Storage.Durability == config.DurabilityBatched && s.snapshot == nil {
+		s.writeBehind = store.NewWriteBehind(st, cfg.Storage.BatchSize, cfg.Storage.QueueSize, cfg.Storage.FlushInterval)
+		st = s.writeBehind
+	}
+
+	// Record events for replay, including the IDs generated while the hub starts
+	if cfg.RecordFile != "" {
+		if s.recorder, err = replay.NewRecorder(cfg.RecordFile); err != nil {
+			s.abort()
+			return fmt.Errorf("opening recording: %w", err)
+		}
+		replay.RecordIDs(s.recorder)
+		log.Printf("Recording events to %s", cfg.RecordFile)
+	}
+
+	// Export spans of the message path to an OTLP collector
+	if cfg.Tracing.Endpoint != "" {
+		if s.tracing, err = tracing.Start(cfg.Tracing); err != nil {
+			s.abort()
+			return fmt.Errorf("starting tracing: %w", err)
+		}
+	}
+
+	// Start out-of-process plugins, whose hooks run after those registered in Go
+	if cfg.Plugins.Dir != "" {
+		if s.plugins, err = plugin.Load(cfg.Plugins.Dir, s.hooks, cfg.Plugins.Timeout); err != nil {
+			s.abort()
+			return fmt.Errorf("loading plugins: %w", err)
+		}
+	}
+
+	// Create a new hub for managing clients and broadcasting messages
+	s.hub = hub.NewHub(ctx, cfg, st)
+	s.hub.Recorder = s.recorder
+	s.hub.Hooks = s.hooks
+
+	// Dice and trivia, played through bot commands
+	if err := game.Start(ctx, s.hub, st, cfg.Games); err != nil {
+		s.abort()
+		return fmt.Errorf("starting games: %w", err)
+	}
+
+	// Share broadcasts with the other nodes when running as a cluster
+	if cfg.Cluster.NATSURL != "" {
+		if s.node, err = cluster.Connect(cfg.Cluster); err == nil {
+			err = s.hub.JoinCluster(s.node)
+		}
+		if err != nil {
+			s.abort()
+			return fmt.Errorf("joining cluster: %w", err)
+		}
+	}
+
+	// gRPC API for backend services and non-browser clients
+	if cfg.GRPCAddr != "" {
+		listener, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			s.abort()
+			return fmt.Errorf("starting gRPC API: %w", err)
+		}
+		s.grpcServer = grpc.NewServer()
+		chatgrpc.Register(s.grpcServer, s.hub)
+		go func() {
+			log.Printf("gRPC API listening on %s", listener.Addr())
+			if err := s.grpcServer.Serve(listener); err != nil {
+				log.Printf("gRPC server error: %v", err)
+			}
+		}()
+	}
+
+	// MQTT broker for IoT devices
+	if cfg.MQTTAddr != "" {
+		if s.bridge, err = mqtt.Start(s.hub, cfg.MQTTAddr); err != nil {
+			if s.grpcServer != nil {
+				s.grpcServer.Stop()
+			}
+			s.abort()
+			return fmt.Errorf("starting MQTT bridge: %w", err)
+		}
+		log.Printf("MQTT broker listening on %s", cfg.MQTTAddr)
+	}
+
+	// Rooms shared with other servers
+	if cfg.Federation.Name != "" {
+		s.federation = federation.Start(s.hub, cfg.Federation)
+	}
+
+	// Read-only views of live rooms
+	s.live = live.New(s.hub)
+
+	// Start the hub in a goroutine
+	go s.hub.Run()
+
+	s.handler = http.NewServeMux()
+
+	// WebSocket endpoint
+	s.handler.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		websocket.HandleWebSocket(s.hub, w, r)
+	})
+
+	// Vanity URLs open a room in the web client by its slug
+	s.handler.HandleFunc("GET /r/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		rm, exists := s.hub.Room(workspace.FromContext(r.Context()), r.PathValue("slug"))
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		// Relative, so links under a workspace's path prefix stay in it
+		w.Header().Set("Location", "../?room="+url.QueryEscape(rm.ID))
+		w.WriteHeader(http.StatusFound)
+	})
+
+	// Live rooms can be watched without logging in, as a page or a stream
+	s.live.Register(s.handler)
+
+	// Rooms embedded in other sites, as a chat widget for their pages
+	embed.Register(s.handler, s.hub)
+
+	// QR code of the network URL, so phones on the LAN can join by scanning it
+	if cfg.NetworkURL != "" {
+		s.handler.HandleFunc("GET /qr", func(w http.ResponseWriter, r *http.Request) {
+			target := cfg.NetworkURL + "/"
+			if id := workspace.FromContext(r.Context()); id != "" {
+				target += strings.TrimPrefix(workspace.PathPrefix, "/") + id + "/"
+			}
+			png, err := qrcode.Encode(target, qrcode.Medium, qrSize)
+			if err != nil {
+				http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Write(png)
+		})
+	}
+
+	// REST API and metrics
+	api.Register(s.handler, s.hub, cfg.AdminToken)
+	if s.federation != nil {
+		s.federation.Register(s.handler)
+	}
+	s.handler.Handle("GET /debug/vars", expvar.Handler())
+
+	// Serve static files (HTML, CSS, JS)
+	if cfg.WebDir != "" {
+		s.handler.Handle("/", http.FileServer(http.Dir(cfg.WebDir)))
+	}
+
+	// Advertise the server on the LAN; chatting works without it, so failing is only logged
+	if cfg.MDNS && cfg.NetworkURL != "" {
+		if s.advertiser, err = discovery.Advertise(cfg.NetworkURL, cfg.MDNSName); err != nil {
+			log.Printf("Not advertising over mDNS: %v", err)
+		} else {
+			log.Printf("Advertising %s over mDNS as %s", cfg.NetworkURL, discovery.Service)
+		}
+	}
+
+	return nil
+}
+
+// Handler returns the HTTP handler serving the WebSocket endpoint, the REST
+// API, metrics and the web client, at the root for the server's own rooms
+// and under each workspace's path prefix or subdomain. It is nil until the
+// server has started.
+func (s *Server) Handler() http.Handler {
+	if s.handler == nil {
+		return nil
+	}
+	return s.hub.Workspaces.Handler(s.handler)
+}
+
+// Stop stops the hub and all rooms, then flushes and closes everything the
+// server opened. Stop the HTTP server serving Handler first, so no new
+// connections arrive meanwhile.
+func (s *Server) Stop() {
+	// Nothing is left open unless Start succeeded
+	if s.handler == nil {
+		return
+	}
+	s.cancel()
+	if s.advertiser != nil {
+		s.advertiser.Close()
+	}
+	if s.federation != nil {
+		s.federation.Close()
+	}
+	s.hub.Stop()
+
+	// Streams end once the hub has closed their clients
+	if s.grpcServer != nil {
+		s.grpcServer.GracefulStop()
+	}
+	if s.bridge != nil {
+		s.bridge.Close()
+	}
+
+	if s.node != nil {
+		s.node.Close()
+	}
+	if s.plugins != nil {
+		s.plugins.Close()
+	}
+	if s.hub.Stream != nil {
+		if err := s.hub.Stream.Close(); err != nil {
+			log.Printf("Error closing Kafka exporter: %v", err)
+		}
+	}
+	if s.hub.Index != nil {
+		s.hub.Index.Close()
+	}
+
+	s.closeStorage()
+
+	// Last, so the spans of the final broadcasts are exported
+	if s.tracing != nil {
+		s.tracing.Close()
+	}
+}
+
+// abort undoes a start that failed before the hub ran
+func (s *Server) abort() {
+	s.cancel()
+	if s.node != nil {
+		s.node.Close()
+	}
+	if s.plugins != nil {
+		s.plugins.Close()
+	}
+	s.closeStorage()
+	if s.tracing != nil {
+		s.tracing.Close()
+	}
+}
+
+// closeStorage closes the recording and storage, keeping everything
+// written before the hub stopped
+func (s *Server) closeStorage() {
+	if s.recorder != nil {
+		if err := s.recorder.Close(); err != nil {
+			log.Printf("Error closing recording: %v", err)
+		}
+	}
+
+	if s.writeBehind != nil {
+		if err := s.writeBehind.Close(); err != nil {
+			log.Printf("Error writing queued history: %v", err)
+		}
+	}
+	if s.snapshot != nil {
+		if err := s.snapshot.Snapshot(); err != nil {
+			log.Printf("Error writing snapshot: %v", err)
+		}
+	}
+	if s.database != nil {
+		if err := s.database.Close(); err != nil {
+			log.Printf("Error closing database: %v", err)
+		}
+	}
+}